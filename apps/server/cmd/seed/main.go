@@ -55,7 +55,7 @@ func main() {
 	}
 
 	// 3. 初始化 VMStore (SQLite)
-	vmStore := vmruntime.NewVMStore(cfg.VMRuntime.BaseDir)
+	vmStore := vmruntime.NewVMStore(vmruntime.StoreOptionsFromConfig(cfg.VMRuntime))
 	defer vmStore.Close()
 
 	wsDB, err := vmStore.GetDB(wsID.String())
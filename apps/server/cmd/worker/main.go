@@ -45,7 +45,7 @@ func main() {
 		Queues:        cfg.Queue.Queues,
 	}
 
-	worker, err := queue.NewWorker(workerCfg, log, nil, nil)
+	worker, err := queue.NewWorker(workerCfg, log, nil, nil, nil, nil)
 	if err != nil {
 		log.Fatal("Failed to create worker", "error", err)
 	}
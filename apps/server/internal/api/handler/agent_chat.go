@@ -3,8 +3,11 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -19,6 +22,7 @@ type AgentChatHandler struct {
 	skillRegistry    *service.SkillRegistry
 	personaRegistry  *service.PersonaRegistry
 	workspaceService service.WorkspaceService
+	runs             service.AgentRunService
 }
 
 // NewAgentChatHandler 创建 Agent 对话处理器
@@ -35,6 +39,11 @@ func NewAgentChatHandler(engine service.AgentEngine, sessions *service.AgentSess
 	return h
 }
 
+// SetRunService 设置 Agent 运行服务：运行在后台进行，事件编号持久化并推送到 WebSocket 房间 agent:<sessionID>
+func (h *AgentChatHandler) SetRunService(runs service.AgentRunService) {
+	h.runs = runs
+}
+
 // Chat SSE 流式对话
 func (h *AgentChatHandler) Chat(c echo.Context) error {
 	workspaceID := c.Param("id")
@@ -78,13 +87,6 @@ func (h *AgentChatHandler) Chat(c echo.Context) error {
 		return errorResponse(c, http.StatusInternalServerError, "SSE_NOT_SUPPORTED", "Server does not support SSE")
 	}
 
-	// Set SSE headers
-	c.Response().Header().Set("Content-Type", "text/event-stream")
-	c.Response().Header().Set("Cache-Control", "no-cache")
-	c.Response().Header().Set("Connection", "keep-alive")
-	c.Response().Header().Set("X-Accel-Buffering", "no")
-	c.Response().WriteHeader(http.StatusOK)
-
 	ctx, cancel := context.WithCancel(c.Request().Context())
 	defer cancel()
 
@@ -112,7 +114,28 @@ func (h *AgentChatHandler) Chat(c echo.Context) error {
 		}
 	}
 
-	events := h.engine.Run(ctx, workspaceID, userID, req.Message, req.SessionID, req.PersonaID)
+	// 经运行服务启动时，页面刷新或断线不会中断运行，事件可通过 WebSocket 或 events 接口续传
+	var events <-chan service.AgentEvent
+	if h.runs != nil {
+		run, err := h.runs.Start(ctx, workspaceID, userID, req.Message, req.SessionID, req.PersonaID)
+		if errors.Is(err, service.ErrAgentRunInProgress) {
+			return errorResponse(c, http.StatusConflict, "RUN_IN_PROGRESS", "This session already has a running agent; resume it instead")
+		}
+		if err != nil {
+			return errorResponse(c, http.StatusInternalServerError, "RUN_FAILED", "Failed to start agent run")
+		}
+		defer run.Detach()
+		events = run.Events
+	} else {
+		events = h.engine.Run(ctx, workspaceID, userID, req.Message, req.SessionID, req.PersonaID)
+	}
+
+	// Set SSE headers
+	c.Response().Header().Set("Content-Type", "text/event-stream")
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Connection", "keep-alive")
+	c.Response().Header().Set("X-Accel-Buffering", "no")
+	c.Response().WriteHeader(http.StatusOK)
 
 	for event := range events {
 		data, err := json.Marshal(event)
@@ -120,6 +143,11 @@ func (h *AgentChatHandler) Chat(c echo.Context) error {
 			continue
 		}
 
+		if event.Seq > 0 {
+			if _, err := fmt.Fprintf(c.Response().Writer, "id: %d\n", event.Seq); err != nil {
+				return nil
+			}
+		}
 		_, writeErr := fmt.Fprintf(c.Response().Writer, "event: %s\ndata: %s\n\n", event.Type, string(data))
 		if writeErr != nil {
 			return nil
@@ -178,6 +206,9 @@ func (h *AgentChatHandler) Cancel(c echo.Context) error {
 	if err := h.engine.Cancel(c.Request().Context(), req.SessionID); err != nil {
 		return errorResponse(c, http.StatusBadRequest, "CANCEL_FAILED", err.Error())
 	}
+	if h.runs != nil {
+		h.runs.Cancel(req.SessionID)
+	}
 
 	return successResponse(c, map[string]string{"message": "Session cancelled"})
 }
@@ -277,6 +308,60 @@ func (h *AgentChatHandler) GetSession(c echo.Context) error {
 	return successResponse(c, session)
 }
 
+// ListSessionEvents 回放会话的运行事件
+// 返回 after_seq 之后的事件（按 seq 升序，最多 limit 条）；页面刷新或重连后先订阅 agent:<sessionID> 房间，
+// 再从最后收到的 seq 拉取缺失事件，按 seq 去重
+func (h *AgentChatHandler) ListSessionEvents(c echo.Context) error {
+	workspaceID := c.Param("id")
+	sessionID := c.Param("sessionId")
+
+	// 验证 workspace 访问权限（读）
+	if h.workspaceService != nil {
+		wsID, err1 := uuid.Parse(workspaceID)
+		uID, err2 := uuid.Parse(middleware.GetUserID(c))
+		if err1 != nil || err2 != nil {
+			return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "无权限访问此工作空间")
+		}
+		if _, err := h.workspaceService.GetWorkspaceAccess(c.Request().Context(), wsID, uID); err != nil {
+			return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "无权限访问此工作空间")
+		}
+	}
+
+	session, ok := h.sessions.Get(sessionID)
+	if !ok || session.WorkspaceID != workspaceID {
+		return errorResponse(c, http.StatusNotFound, "SESSION_NOT_FOUND", "Session not found")
+	}
+	if h.runs == nil {
+		return errorResponse(c, http.StatusNotFound, "NO_RUN_SERVICE", "Agent run history not available")
+	}
+
+	var afterSeq int64
+	if v := c.QueryParam("after_seq"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_AFTER_SEQ", "after_seq must be a non-negative integer")
+		}
+		afterSeq = n
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+
+	events, err := h.runs.EventsAfter(c.Request().Context(), sessionID, afterSeq, limit)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "LIST_FAILED", "Failed to load session events")
+	}
+	lastSeq := afterSeq
+	if len(events) > 0 {
+		lastSeq = events[len(events)-1].Seq
+	}
+
+	return successResponse(c, map[string]interface{}{
+		"session_id": sessionID,
+		"events":     events,
+		"last_seq":   lastSeq,
+		"running":    h.runs.IsRunning(sessionID),
+	})
+}
+
 // DeleteSession 删除会话
 func (h *AgentChatHandler) DeleteSession(c echo.Context) error {
 	// 验证 workspace 成员权限（写）
@@ -299,6 +384,12 @@ func (h *AgentChatHandler) DeleteSession(c echo.Context) error {
 	if !h.sessions.Delete(sessionID) {
 		return errorResponse(c, http.StatusNotFound, "SESSION_NOT_FOUND", "Session not found")
 	}
+	if h.runs != nil {
+		h.runs.Cancel(sessionID)
+		if err := h.runs.DeleteEvents(c.Request().Context(), sessionID); err != nil {
+			log.Printf("[AgentChat] delete run events of session %s failed: %v", sessionID, err)
+		}
+	}
 
 	return successResponse(c, map[string]string{"message": "Session deleted"})
 }
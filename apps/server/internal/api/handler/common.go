@@ -72,3 +72,12 @@ func errorResponse(c echo.Context, status int, code, message string) error {
 	resp.ErrorMessage = message
 	return c.JSON(status, resp)
 }
+
+// errorResponseWithDetails 返回附带详情的错误响应
+func errorResponseWithDetails(c echo.Context, status int, code, message string, details interface{}) error {
+	resp := buildResponse(c, code, message, nil, nil)
+	resp.ErrorCode = code
+	resp.ErrorMessage = message
+	resp.Details = details
+	return c.JSON(status, resp)
+}
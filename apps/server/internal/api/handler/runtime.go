@@ -589,6 +589,9 @@ func normalizeRuntimeHost(value string) string {
 
 func runtimeErrorResponse(c echo.Context, err error, fallbackCode, fallbackMessage string) error {
 	if status, code, message, ok := mapRuntimeError(err); ok {
+		if status == http.StatusTooManyRequests {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(runtimeRetryAfterSeconds(err)))
+		}
 		return errorResponse(c, status, code, message)
 	}
 	return errorResponse(c, http.StatusInternalServerError, fallbackCode, fallbackMessage)
@@ -634,6 +637,15 @@ func mapRuntimeError(err error) (int, string, string, bool) {
 	}
 }
 
+// runtimeRetryAfterSeconds 从限流错误中提取 Retry-After 秒数
+func runtimeRetryAfterSeconds(err error) int {
+	var limitErr *service.RuntimeRateLimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.RetryAfterSeconds()
+	}
+	return 1
+}
+
 func (h *RuntimeHandler) trackAnonymousAccess(c echo.Context, entry *service.RuntimeEntry, eventType string, captchaToken string, skipSession bool) (*service.RuntimeAccessResult, error) {
 	if entry == nil {
 		return nil, nil
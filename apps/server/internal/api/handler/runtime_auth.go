@@ -1,8 +1,11 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
+	"net/mail"
+	"strconv"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -31,24 +34,44 @@ func (h *RuntimeAuthHandler) SetWorkspaceService(ws service.WorkspaceService) {
 
 // requireMemberAccess 验证用户是 workspace 成员或 owner
 func (h *RuntimeAuthHandler) requireMemberAccess(c echo.Context, workspaceID uuid.UUID) error {
+	_, err := h.workspaceAccess(c, workspaceID)
+	return err
+}
+
+// requireUserManageAccess 验证用户可以管理应用用户（邀请、封禁、撤销会话）：
+// owner 或拥有成员管理 / 工作空间管理权限
+func (h *RuntimeAuthHandler) requireUserManageAccess(c echo.Context, workspaceID uuid.UUID) error {
+	access, err := h.workspaceAccess(c, workspaceID)
+	if err != nil || access == nil {
+		return err
+	}
+	if !access.Can(service.PermissionMembersManage, service.PermissionWorkspaceAdmin) {
+		_ = c.JSON(http.StatusForbidden, map[string]string{"error": "无权限，仅有成员管理权限的成员可执行此操作"})
+		return fmt.Errorf("manage_forbidden")
+	}
+	return nil
+}
+
+// workspaceAccess 返回成员或 owner 的访问权限；未设置 workspaceService 时返回 nil；失败时已写入响应
+func (h *RuntimeAuthHandler) workspaceAccess(c echo.Context, workspaceID uuid.UUID) (*service.WorkspaceAccess, error) {
 	if h.workspaceService == nil {
-		return nil
+		return nil, nil
 	}
 	uid, err := uuid.Parse(middleware.GetUserID(c))
 	if err != nil {
 		_ = c.JSON(http.StatusForbidden, map[string]string{"error": "用户 ID 无效"})
-		return fmt.Errorf("invalid_user")
+		return nil, fmt.Errorf("invalid_user")
 	}
 	access, err := h.workspaceService.GetWorkspaceAccess(c.Request().Context(), workspaceID, uid)
 	if err != nil {
 		_ = c.JSON(http.StatusForbidden, map[string]string{"error": "无权限访问此工作空间"})
-		return err
+		return nil, err
 	}
 	if !access.IsOwner && access.Role == nil {
 		_ = c.JSON(http.StatusForbidden, map[string]string{"error": "无权限，仅 workspace 成员可执行此操作"})
-		return fmt.Errorf("write_forbidden")
+		return nil, fmt.Errorf("write_forbidden")
 	}
-	return nil
+	return access, nil
 }
 
 // resolveWorkspaceID resolves workspace ID from slug param (or UUID directly)
@@ -136,14 +159,49 @@ func (h *RuntimeAuthHandler) Login(c echo.Context) error {
 	})
 }
 
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// Refresh 用 Refresh Token 换取新的会话凭证（旧 Refresh Token 随即失效）
+func (h *RuntimeAuthHandler) Refresh(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+
+	var req refreshRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if req.RefreshToken == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "refresh_token is required"})
+	}
+
+	result, err := h.authService.Refresh(c.Request().Context(), workspaceID, req.RefreshToken)
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "session refreshed",
+		"data":    result,
+	})
+}
+
 // Logout 应用用户登出
 func (h *RuntimeAuthHandler) Logout(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
 	token := c.Request().Header.Get("X-App-Token")
 	if token == "" {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
 	}
 
-	if err := h.authService.Logout(c.Request().Context(), token); err != nil {
+	if err := h.authService.Logout(c.Request().Context(), workspaceID, token); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 	}
 
@@ -153,6 +211,188 @@ func (h *RuntimeAuthHandler) Logout(c echo.Context) error {
 	})
 }
 
+// LogoutAll 应用用户退出所有设备
+func (h *RuntimeAuthHandler) LogoutAll(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+	token := c.Request().Header.Get("X-App-Token")
+	if token == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "token is required"})
+	}
+
+	if err := h.authService.LogoutAll(c.Request().Context(), workspaceID, token); err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "logged out from all sessions",
+	})
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword 发送重置密码邮件（邮箱未注册时同样返回成功）
+func (h *RuntimeAuthHandler) ForgotPassword(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+
+	var req forgotPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if req.Email == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "email is required"})
+	}
+
+	if err := h.authService.ForgotPassword(c.Request().Context(), workspaceID, req.Email, c.RealIP()); err != nil {
+		return accountErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "if the email is registered, a reset link has been sent",
+	})
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
+
+// ResetPassword 用重置令牌设置新密码（该用户的全部会话随即失效）
+func (h *RuntimeAuthHandler) ResetPassword(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+
+	var req resetPasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if req.Token == "" || len(req.Password) < 6 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token and a password of at least 6 characters are required"})
+	}
+
+	if err := h.authService.ResetPassword(c.Request().Context(), workspaceID, req.Token, req.Password); err != nil {
+		return accountErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "password reset",
+	})
+}
+
+type verifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// VerifyEmail 验证应用用户邮箱
+func (h *RuntimeAuthHandler) VerifyEmail(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+
+	var req verifyEmailRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token is required"})
+	}
+
+	if err := h.authService.VerifyEmail(c.Request().Context(), workspaceID, req.Token); err != nil {
+		return accountErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "email verified",
+	})
+}
+
+// ResendVerification 重新发送验证邮件
+func (h *RuntimeAuthHandler) ResendVerification(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+	token := c.Request().Header.Get("X-App-Token")
+	if token == "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "token is required"})
+	}
+
+	if err := h.authService.ResendVerification(c.Request().Context(), workspaceID, token, c.RealIP()); err != nil {
+		return accountErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "verification email sent",
+	})
+}
+
+type acceptInvitationRequest struct {
+	Token       string `json:"token" validate:"required"`
+	Password    string `json:"password" validate:"required,min=6"`
+	DisplayName string `json:"display_name"`
+}
+
+// AcceptInvitation 接受邀请、设置密码并登录
+func (h *RuntimeAuthHandler) AcceptInvitation(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
+
+	var req acceptInvitationRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if req.Token == "" || len(req.Password) < 6 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "token and a password of at least 6 characters are required"})
+	}
+
+	result, err := h.authService.AcceptInvitation(c.Request().Context(), workspaceID, req.Token, req.Password, req.DisplayName)
+	if err != nil {
+		return accountErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "invitation accepted",
+		"data":    result,
+	})
+}
+
+// accountErrorResponse 重置密码、验证邮箱与邀请接口的错误响应
+func accountErrorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrInvalidAppUserToken), errors.Is(err, service.ErrInvalidAppUserRole):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrInvalidAppSession):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrAppEmailAlreadyVerified), errors.Is(err, service.ErrAppUserExists):
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrMailNotConfigured):
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	case errors.Is(err, service.ErrRuntimeRateLimited):
+		c.Response().Header().Set("Retry-After", strconv.Itoa(runtimeRetryAfterSeconds(err)))
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too many emails requested, please try again later"})
+	default:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	}
+}
+
 // ListUsers 列出应用用户（需要 workspace 成员权限）
 func (h *RuntimeAuthHandler) ListUsers(c echo.Context) error {
 	workspaceID, err := uuid.Parse(c.Param("id"))
@@ -184,12 +424,16 @@ func (h *RuntimeAuthHandler) ListUsers(c echo.Context) error {
 
 // Me 验证 token 并返回当前应用用户信息
 func (h *RuntimeAuthHandler) Me(c echo.Context) error {
+	workspaceID, err := h.resolveWorkspaceID(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace identifier"})
+	}
 	token := c.Request().Header.Get("X-App-Token")
 	if token == "" {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "token is required"})
 	}
 
-	user, err := h.authService.ValidateSession(c.Request().Context(), token)
+	user, _, err := h.authService.ValidateSession(c.Request().Context(), workspaceID, token)
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
 	}
@@ -201,13 +445,13 @@ func (h *RuntimeAuthHandler) Me(c echo.Context) error {
 	})
 }
 
-// BlockUser 封禁应用用户
+// BlockUser 封禁应用用户（需要成员管理权限）
 func (h *RuntimeAuthHandler) BlockUser(c echo.Context) error {
 	workspaceID, err := uuid.Parse(c.Param("id"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace id"})
 	}
-	if err := h.requireMemberAccess(c, workspaceID); err != nil {
+	if err := h.requireUserManageAccess(c, workspaceID); err != nil {
 		return nil
 	}
 
@@ -216,8 +460,8 @@ func (h *RuntimeAuthHandler) BlockUser(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user id"})
 	}
 
-	if err := h.authService.BlockUser(c.Request().Context(), userID); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	if err := h.authService.BlockUser(c.Request().Context(), workspaceID, userID); err != nil {
+		return appUserErrorResponse(c, err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -225,3 +469,131 @@ func (h *RuntimeAuthHandler) BlockUser(c echo.Context) error {
 		"message": "user blocked",
 	})
 }
+
+type inviteUserRequest struct {
+	Email       string `json:"email" validate:"required,email"`
+	Role        string `json:"role"`
+	DisplayName string `json:"display_name"`
+}
+
+// InviteUser 邀请应用用户并预设角色（需要成员管理权限）
+func (h *RuntimeAuthHandler) InviteUser(c echo.Context) error {
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace id"})
+	}
+	if err := h.requireUserManageAccess(c, workspaceID); err != nil {
+		return nil
+	}
+	inviterID, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid user id"})
+	}
+
+	var req inviteUserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "a valid email is required"})
+	}
+
+	user, err := h.authService.InviteUser(c.Request().Context(), workspaceID, inviterID, req.Email, req.Role, req.DisplayName, c.RealIP())
+	if err != nil {
+		return accountErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"code":    "success",
+		"message": "invitation sent",
+		"data":    user,
+	})
+}
+
+// ListUserSessions 列出应用用户的有效会话
+func (h *RuntimeAuthHandler) ListUserSessions(c echo.Context) error {
+	workspaceID, userID, ok := h.appUserParams(c, h.requireMemberAccess)
+	if !ok {
+		return nil
+	}
+
+	sessions, err := h.authService.ListSessions(c.Request().Context(), workspaceID, userID)
+	if err != nil {
+		return appUserErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "ok",
+		"data": map[string]interface{}{
+			"items": sessions,
+			"total": len(sessions),
+		},
+	})
+}
+
+// RevokeUserSession 撤销应用用户的单个会话（需要成员管理权限）
+func (h *RuntimeAuthHandler) RevokeUserSession(c echo.Context) error {
+	workspaceID, userID, ok := h.appUserParams(c, h.requireUserManageAccess)
+	if !ok {
+		return nil
+	}
+	sessionID, err := uuid.Parse(c.Param("sessionId"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid session id"})
+	}
+
+	if err := h.authService.RevokeSession(c.Request().Context(), workspaceID, userID, sessionID); err != nil {
+		return appUserErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "session revoked",
+	})
+}
+
+// RevokeUserSessions 撤销应用用户的全部会话（需要成员管理权限）
+func (h *RuntimeAuthHandler) RevokeUserSessions(c echo.Context) error {
+	workspaceID, userID, ok := h.appUserParams(c, h.requireUserManageAccess)
+	if !ok {
+		return nil
+	}
+
+	revoked, err := h.authService.RevokeUserSessions(c.Request().Context(), workspaceID, userID)
+	if err != nil {
+		return appUserErrorResponse(c, err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "success",
+		"message": "sessions revoked",
+		"data":    map[string]interface{}{"revoked": revoked},
+	})
+}
+
+// appUserParams 解析 :id 与 :userId 并用 authorize 校验权限；失败时已写入响应
+func (h *RuntimeAuthHandler) appUserParams(c echo.Context, authorize func(echo.Context, uuid.UUID) error) (uuid.UUID, uuid.UUID, bool) {
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid workspace id"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	if err := authorize(c, workspaceID); err != nil {
+		return uuid.Nil, uuid.Nil, false
+	}
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		_ = c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user id"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	return workspaceID, userID, true
+}
+
+// appUserErrorResponse 应用用户管理接口的错误响应
+func appUserErrorResponse(c echo.Context, err error) error {
+	if errors.Is(err, service.ErrAppUserNotFound) || errors.Is(err, service.ErrAppSessionNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
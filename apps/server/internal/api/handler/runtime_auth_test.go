@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/service"
+)
+
+// stubAccessWorkspaceService returns fixed workspace access per user.
+type stubAccessWorkspaceService struct {
+	service.WorkspaceService
+	access map[uuid.UUID]*service.WorkspaceAccess
+}
+
+func (s *stubAccessWorkspaceService) GetWorkspaceAccess(_ context.Context, _ uuid.UUID, userID uuid.UUID) (*service.WorkspaceAccess, error) {
+	if access, ok := s.access[userID]; ok {
+		return access, nil
+	}
+	return nil, service.ErrWorkspaceUnauthorized
+}
+
+// recordingAppUserService records the app user management calls it receives.
+type recordingAppUserService struct {
+	service.RuntimeAuthService
+	calls []string
+}
+
+func (s *recordingAppUserService) InviteUser(_ context.Context, workspaceID, _ uuid.UUID, email, role, _, _ string) (*entity.AppUser, error) {
+	s.calls = append(s.calls, "invite")
+	return &entity.AppUser{ID: uuid.New(), WorkspaceID: workspaceID, Email: email, Role: role, Status: "invited"}, nil
+}
+
+func (s *recordingAppUserService) BlockUser(_ context.Context, _, _ uuid.UUID) error {
+	s.calls = append(s.calls, "block")
+	return nil
+}
+
+func (s *recordingAppUserService) RevokeSession(_ context.Context, _, _, _ uuid.UUID) error {
+	s.calls = append(s.calls, "revoke")
+	return nil
+}
+
+func (s *recordingAppUserService) RevokeUserSessions(_ context.Context, _, _ uuid.UUID) (int64, error) {
+	s.calls = append(s.calls, "revoke_all")
+	return 1, nil
+}
+
+func TestRuntimeAuth_UserManagementRequiresPermission(t *testing.T) {
+	wsID, appUserID := uuid.New(), uuid.New()
+	owner, manager, admin, editor := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	role := func(permissions entity.JSON) *service.WorkspaceAccess {
+		return &service.WorkspaceAccess{Role: &entity.WorkspaceRole{Name: "member"}, Permissions: permissions}
+	}
+	workspaces := &stubAccessWorkspaceService{access: map[uuid.UUID]*service.WorkspaceAccess{
+		owner:   {IsOwner: true},
+		manager: role(entity.JSON{service.PermissionMembersManage: true}),
+		admin:   role(entity.JSON{service.PermissionWorkspaceAdmin: true}),
+		editor:  role(entity.JSON{service.PermissionWorkspaceEdit: true}),
+	}}
+
+	actions := map[string]func(h *RuntimeAuthHandler, c echo.Context) error{
+		"invite":     (*RuntimeAuthHandler).InviteUser,
+		"block":      (*RuntimeAuthHandler).BlockUser,
+		"revoke":     (*RuntimeAuthHandler).RevokeUserSession,
+		"revoke_all": (*RuntimeAuthHandler).RevokeUserSessions,
+	}
+	for name, action := range actions {
+		for user, allowed := range map[uuid.UUID]bool{owner: true, manager: true, admin: true, editor: false, uuid.New(): false} {
+			auth := &recordingAppUserService{}
+			h := NewRuntimeAuthHandler(auth)
+			h.SetWorkspaceService(workspaces)
+
+			body, _ := json.Marshal(map[string]string{"email": "new@example.com", "role": "staff"})
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.Set("user_id", user.String())
+			c.SetParamNames("id", "userId", "sessionId")
+			c.SetParamValues(wsID.String(), appUserID.String(), uuid.NewString())
+
+			if err := action(h, c); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if allowed && (rec.Code >= 300 || len(auth.calls) != 1 || auth.calls[0] != name) {
+				t.Fatalf("%s by %s: status %d, calls %v", name, user, rec.Code, auth.calls)
+			}
+			if !allowed && (rec.Code != http.StatusForbidden || len(auth.calls) != 0) {
+				t.Fatalf("%s by %s: status %d, calls %v, want 403", name, user, rec.Code, auth.calls)
+			}
+		}
+	}
+}
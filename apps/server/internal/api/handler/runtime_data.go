@@ -3,6 +3,8 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
@@ -11,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/security"
 	"github.com/reverseai/server/internal/service"
 	"github.com/reverseai/server/internal/vmruntime"
 )
@@ -23,6 +26,8 @@ type RuntimeDataHandler struct {
 	vmPool             *vmruntime.VMPool
 	rlsService         service.WorkspaceRLSService
 	runtimeAuthService service.RuntimeAuthService
+	dataHookService    service.WorkspaceDataHookService
+	secretService      service.WorkspaceSecretService
 }
 
 // NewRuntimeDataHandler 创建 Runtime 数据处理器
@@ -51,59 +56,116 @@ func (h *RuntimeDataHandler) SetVMPool(pool *vmruntime.VMPool) {
 	h.vmPool = pool
 }
 
-// rlsFilter is a local filter struct used by RLS resolution
-type rlsFilter struct {
-	Column   string
-	Operator string
-	Value    string
+// SetSecretService sets the secret service backing `secrets.get` and fetch
+// secret references inside data hooks.
+func (h *RuntimeDataHandler) SetSecretService(secretService service.WorkspaceSecretService) {
+	h.secretService = secretService
 }
 
-// getRLSFilters resolves RLS filters for the given table based on X-App-Token
-func (h *RuntimeDataHandler) getRLSFilters(c echo.Context, workspaceID uuid.UUID, tableName string) ([]rlsFilter, error) {
+// SetDataHookService routes after-* hooks through the task queue and registers
+// runDataHook as their executor.
+func (h *RuntimeDataHandler) SetDataHookService(dataHookService service.WorkspaceDataHookService) {
+	h.dataHookService = dataHookService
+	dataHookService.SetExecutor(h.runDataHook)
+}
+
+// rlsScope is the resolved RLS restriction for one operation on a table.
+type rlsScope struct {
+	enforced  bool // at least one enabled policy applies to the operation
+	denied    bool // policies apply but the request has no valid app user
+	predicate *service.RLSPredicate
+	owner     map[string]string // policy column → value it must hold for the current user
+}
+
+// rowScope converts the RLS restriction into a VMStore row scope.
+func (s *rlsScope) rowScope() *vmruntime.VMRowScope {
+	if s == nil || !s.enforced {
+		return nil
+	}
+	if s.denied || s.predicate == nil {
+		return &vmruntime.VMRowScope{DenyAll: true}
+	}
+	return &vmruntime.VMRowScope{Clause: s.predicate.Clause, Args: s.predicate.Args}
+}
+
+// resolveRLS resolves the RLS scope for an operation on a table based on X-App-Token.
+// When policies exist but the user cannot be resolved the scope is denied (fail closed).
+func (h *RuntimeDataHandler) resolveRLS(c echo.Context, workspaceID uuid.UUID, tableName, operation string) (*rlsScope, error) {
 	if h.rlsService == nil {
-		return nil, nil
+		return &rlsScope{}, nil
 	}
 
 	policies, err := h.rlsService.GetActivePoliciesForTable(c.Request().Context(), workspaceID, tableName)
-	if err != nil || len(policies) == 0 {
-		return nil, nil
+	if err != nil {
+		return nil, err
+	}
+	if !rlsPoliciesApply(policies, operation) {
+		return &rlsScope{}, nil
 	}
+	return buildRLSScope(policies, operation, resolveAppUser(c, h.runtimeAuthService, workspaceID))
+}
 
-	// Resolve current app user from X-App-Token
-	token := c.Request().Header.Get("X-App-Token")
-	if token == "" {
-		// No token but RLS policies exist — deny access by adding impossible filter
-		return []rlsFilter{{Column: "1", Operator: "=", Value: "0"}}, nil
+// rlsPoliciesApply reports whether any policy applies to the operation.
+func rlsPoliciesApply(policies []entity.RLSPolicy, operation string) bool {
+	for _, policy := range policies {
+		if policy.AppliesTo(operation) {
+			return true
+		}
 	}
+	return false
+}
 
-	if h.runtimeAuthService == nil {
-		return nil, nil
+// buildRLSScope compiles one table's enabled policies for an operation.
+// A nil user denies the scope whenever a policy applies (fail closed).
+func buildRLSScope(policies []entity.RLSPolicy, operation string, user *entity.AppUser) (*rlsScope, error) {
+	scope := &rlsScope{}
+	if !rlsPoliciesApply(policies, operation) {
+		return scope, nil
+	}
+	scope.enforced = true
+	if user == nil {
+		scope.denied = true
+		return scope, nil
 	}
 
-	user, err := h.runtimeAuthService.ValidateSession(c.Request().Context(), token)
+	predicate, err := service.CompileRLSPolicies(policies, operation, user)
 	if err != nil {
-		// Invalid token with RLS — deny
-		return []rlsFilter{{Column: "1", Operator: "=", Value: "0"}}, nil
+		return nil, err
 	}
+	scope.predicate = predicate
+	if predicate != nil {
+		scope.owner = predicate.Owner
+	}
+	return scope, nil
+}
 
-	var filters []rlsFilter
-	for _, policy := range policies {
-		var matchValue string
-		switch policy.MatchField {
-		case "app_user_id":
-			matchValue = user.ID.String()
-		case "email":
-			matchValue = user.Email
-		default:
-			matchValue = user.ID.String()
+// resolveAppUser resolves the app user from X-App-Token. Returns nil when the
+// token is missing, invalid or belongs to another workspace.
+func resolveAppUser(c echo.Context, authService service.RuntimeAuthService, workspaceID uuid.UUID) *entity.AppUser {
+	token := c.Request().Header.Get("X-App-Token")
+	if token == "" || authService == nil {
+		return nil
+	}
+	user, _, err := authService.ValidateSession(c.Request().Context(), workspaceID, token)
+	if err != nil || user == nil {
+		return nil
+	}
+	return user
+}
+
+// rlsWriteDenied 写操作在 RLS 下无有效用户时的统一响应
+func rlsWriteDenied(c echo.Context) error {
+	return errorResponse(c, http.StatusUnauthorized, "RLS_AUTH_REQUIRED", "Sign in is required to modify this table")
+}
+
+// rlsOwnerViolation 返回被改写成其他用户值的 RLS 列（没有则返回空串）
+func rlsOwnerViolation(scope *rlsScope, data map[string]interface{}) string {
+	for col, want := range scope.owner {
+		if v, ok := data[col]; ok && fmt.Sprint(v) != want {
+			return col
 		}
-		filters = append(filters, rlsFilter{
-			Column:   policy.Column,
-			Operator: "=",
-			Value:    matchValue,
-		})
 	}
-	return filters, nil
+	return ""
 }
 
 // resolveWorkspaceID 通过 slug 解析已发布的 workspace ID
@@ -126,12 +188,17 @@ func (h *RuntimeDataHandler) resolveWorkspaceID(c echo.Context) (string, error)
 
 // resolveWorkspace 通过 slug 解析已发布的 workspace 实体
 func (h *RuntimeDataHandler) resolveWorkspace(c echo.Context) (*entity.Workspace, error) {
+	return resolveRuntimeWorkspace(c, h.runtimeService)
+}
+
+// resolveRuntimeWorkspace 通过 slug 解析已发布的 workspace 实体，失败时已写入错误响应
+func resolveRuntimeWorkspace(c echo.Context, runtimeService service.RuntimeService) (*entity.Workspace, error) {
 	slug := c.Param("workspaceSlug")
 	if strings.TrimSpace(slug) == "" {
 		return nil, errorResponse(c, http.StatusBadRequest, "INVALID_SLUG", "Workspace slug is required")
 	}
 
-	entry, err := h.runtimeService.GetEntry(c.Request().Context(), slug, nil)
+	entry, err := runtimeService.GetEntry(c.Request().Context(), slug, nil)
 	if err != nil {
 		return nil, runtimeErrorResponse(c, err, "RUNTIME_FAILED", "Workspace not found or not published")
 	}
@@ -176,11 +243,11 @@ func (h *RuntimeDataHandler) QueryRows(c echo.Context) error {
 		filters = append(filters, vmruntime.VMQueryFilter{Column: col, Operator: op, Value: val})
 	}
 
-	// Inject RLS filters
+	// RLS 作为独立的 scope 注入，不受 filter_combinator 影响
 	wsUUID, _ := uuid.Parse(workspaceID)
-	rlsFilters, _ := h.getRLSFilters(c, wsUUID, tableName)
-	for _, f := range rlsFilters {
-		filters = append(filters, vmruntime.VMQueryFilter{Column: f.Column, Operator: f.Operator, Value: f.Value})
+	scope, err := h.resolveRLS(c, wsUUID, tableName, entity.RLSOperationSelect)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "RLS_FAILED", "Failed to resolve row level security")
 	}
 
 	filterCombinator := c.QueryParam("filter_combinator")
@@ -192,6 +259,7 @@ func (h *RuntimeDataHandler) QueryRows(c echo.Context) error {
 		OrderDir:         orderDir,
 		Filters:          filters,
 		FilterCombinator: filterCombinator,
+		Scope:            scope.rowScope(),
 	})
 	if err != nil {
 		return handleDBQueryError(c, err)
@@ -214,36 +282,80 @@ type hookResult struct {
 	Handled bool                   // true if a hook was found and executed
 }
 
-// callVMHook invokes a VM route like "POST /hooks/before-insert/:table" and returns the result.
-// If no VM is loaded or the hook route doesn't exist, it returns a passthrough result (allow=true).
-func (h *RuntimeDataHandler) callVMHook(ctx context.Context, workspaceID, hookType, tableName string, data map[string]interface{}) hookResult {
+// invokeVMHook calls the VM route "POST /hooks/<hookType>/<table>" with body.
+// Egress rules and secrets are applied as for an HTTP request; there is no
+// session, so fetch is only logged when blocked. The response and error are
+// redacted with the workspace's secrets.
+// It returns a nil response when no VM pool is configured or no code is deployed.
+func (h *RuntimeDataHandler) invokeVMHook(ctx context.Context, workspace *entity.Workspace, hookType, tableName string, body map[string]interface{}) (*vmruntime.VMResponse, error) {
 	if h.vmPool == nil {
-		return hookResult{Allow: true}
+		return nil, nil
 	}
 
-	vm, err := h.vmPool.GetOrCreate(ctx, workspaceID)
+	vm, err := h.vmPool.GetOrCreate(ctx, workspace.ID.String())
 	if err != nil {
-		// No VM deployed — passthrough
-		return hookResult{Allow: true}
+		if errors.Is(err, vmruntime.ErrNoLogicCode) {
+			return nil, nil
+		}
+		return nil, err
 	}
 
 	req := vmruntime.VMRequest{
 		Method: "POST",
 		Path:   "/hooks/" + hookType + "/" + tableName,
-		Body: map[string]interface{}{
-			"table": tableName,
-			"data":  data,
+		Body:   body,
+		Egress: &vmruntime.VMEgress{
+			Allow: getEgressRules(workspace.Settings),
+			OnFetch: func(event vmruntime.VMFetchEvent) {
+				if event.Blocked {
+					log.Printf("[VM:%s] hook %s/%s fetch blocked: %s %s%s: %s", workspace.ID, hookType, tableName, event.Method, event.Host, event.Path, event.Error)
+				}
+			},
 		},
 	}
+	if h.secretService != nil {
+		workspaceID := workspace.ID
+		req.Secrets = func(name string) (string, bool, error) {
+			return h.secretService.Resolve(ctx, workspaceID, name)
+		}
+		req.Redactor = h.secretService.Redactor(ctx, workspaceID)
+	}
+	resp, err := vm.HandleContext(ctx, req)
+	return redactHookResult(req.Redactor, resp, err)
+}
 
-	resp, err := vm.Handle(req)
+// redactHookResult removes workspace secret values from a hook's response body
+// and error before they are merged into rows, recorded as a run or returned to
+// the client. Errors are only replaced when redaction changes their text.
+func redactHookResult(redactor *security.SecretRedactor, resp *vmruntime.VMResponse, err error) (*vmruntime.VMResponse, error) {
+	if redactor.Empty() {
+		return resp, err
+	}
+	if resp != nil {
+		resp = &vmruntime.VMResponse{Status: resp.Status, Body: redactor.RedactValue(resp.Body)}
+	}
+	if err != nil {
+		if text := redactor.Redact(err.Error()); text != err.Error() {
+			err = errors.New(text)
+		}
+	}
+	return resp, err
+}
+
+// callVMHook invokes a before-* hook and returns the result.
+// If no VM is loaded or the hook route doesn't exist, it returns a passthrough result (allow=true).
+func (h *RuntimeDataHandler) callVMHook(ctx context.Context, workspace *entity.Workspace, hookType, tableName string, data map[string]interface{}) hookResult {
+	resp, err := h.invokeVMHook(ctx, workspace, hookType, tableName, map[string]interface{}{
+		"table": tableName,
+		"data":  data,
+	})
 	if err != nil {
 		log.Printf("[DataHook] VM hook %s/%s error: %v", hookType, tableName, err)
 		return hookResult{Allow: true}
 	}
 
-	// 404 means no hook registered — passthrough
-	if resp.Status == 404 {
+	// No VM deployed or 404 (no hook registered) — passthrough
+	if resp == nil || resp.Status == 404 {
 		return hookResult{Allow: true}
 	}
 
@@ -268,12 +380,60 @@ func (h *RuntimeDataHandler) callVMHook(ctx context.Context, workspaceID, hookTy
 	return hr
 }
 
+// rejectByHook writes the response for a before-* hook that rejected the operation.
+func rejectByHook(c echo.Context, hookRes hookResult) error {
+	errMsg := hookRes.Error
+	if errMsg == "" {
+		errMsg = "Operation rejected by business rule"
+	}
+	return errorResponse(c, http.StatusBadRequest, "HOOK_REJECTED", errMsg)
+}
+
+// dispatchHook hands an after-* hook to the data hook service, which runs it
+// through the task queue with retries. Without the service it falls back to a
+// fire-and-forget call.
+func (h *RuntimeDataHandler) dispatchHook(ctx context.Context, workspace *entity.Workspace, hookType, tableName string, data map[string]interface{}) {
+	if h.dataHookService == nil {
+		go h.callVMHook(context.Background(), workspace, hookType, tableName, data)
+		return
+	}
+	h.dataHookService.Dispatch(ctx, workspace.ID, hookType, tableName, data)
+}
+
+// runDataHook executes a queued after-* hook (service.DataHookExecutor).
+// The hook receives ctx.body = { table, data, eventId, attempt }; eventId stays
+// the same across retries. A thrown error or a status >= 400 fails the attempt.
+func (h *RuntimeDataHandler) runDataHook(ctx context.Context, workspace *entity.Workspace, exec service.DataHookExecution) (bool, error) {
+	resp, err := h.invokeVMHook(ctx, workspace, exec.Hook, exec.Table, map[string]interface{}{
+		"table":   exec.Table,
+		"data":    exec.Data,
+		"eventId": exec.EventID.String(),
+		"attempt": exec.Attempt,
+	})
+	if err != nil {
+		return true, err
+	}
+	if resp == nil || resp.Status == 404 {
+		return false, nil
+	}
+	if resp.Status >= 400 {
+		if body, ok := resp.Body.(map[string]interface{}); ok {
+			if msg, ok := body["error"].(string); ok && msg != "" {
+				return true, fmt.Errorf("hook responded with status %d: %s", resp.Status, msg)
+			}
+		}
+		return true, fmt.Errorf("hook responded with status %d", resp.Status)
+	}
+	return true, nil
+}
+
 // InsertRow 插入行（公开访问 — 表单提交）
 func (h *RuntimeDataHandler) InsertRow(c echo.Context) error {
-	workspaceID, err := h.resolveWorkspaceID(c)
-	if err != nil {
-		return nil
+	workspace, err := h.resolveWorkspace(c)
+	if workspace == nil {
+		return err
 	}
+	workspaceID := workspace.ID.String()
 
 	tableName := c.Param("table")
 	if strings.TrimSpace(tableName) == "" {
@@ -290,14 +450,18 @@ func (h *RuntimeDataHandler) InsertRow(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "Data cannot be empty")
 	}
 
+	scope, err := h.resolveRLS(c, workspace.ID, tableName, entity.RLSOperationInsert)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "RLS_FAILED", "Failed to resolve row level security")
+	}
+	if scope.denied {
+		return rlsWriteDenied(c)
+	}
+
 	// Before-insert hook
-	hookRes := h.callVMHook(c.Request().Context(), workspaceID, "before-insert", tableName, req.Data)
+	hookRes := h.callVMHook(c.Request().Context(), workspace, entity.DataHookBeforeInsert, tableName, req.Data)
 	if hookRes.Handled && !hookRes.Allow {
-		errMsg := hookRes.Error
-		if errMsg == "" {
-			errMsg = "Operation rejected by business rule"
-		}
-		return errorResponse(c, http.StatusBadRequest, "HOOK_REJECTED", errMsg)
+		return rejectByHook(c, hookRes)
 	}
 	// Hook can mutate data (e.g., auto-generate fields)
 	if hookRes.Handled && hookRes.Data != nil {
@@ -306,13 +470,21 @@ func (h *RuntimeDataHandler) InsertRow(c echo.Context) error {
 		}
 	}
 
-	result, err := h.vmStore.InsertRow(c.Request().Context(), workspaceID, tableName, req.Data)
+	// RLS：自动写入归属列，覆盖客户端或 hook 提供的值
+	for col, v := range scope.owner {
+		req.Data[col] = v
+	}
+
+	result, err := h.vmStore.InsertRowScoped(c.Request().Context(), workspaceID, tableName, req.Data, scope.rowScope())
 	if err != nil {
+		if errors.Is(err, vmruntime.ErrRowScopeViolation) {
+			return errorResponse(c, http.StatusForbidden, "RLS_VIOLATION", "Row violates row level security policy")
+		}
 		return handleDBQueryError(c, err)
 	}
 
-	// After-insert hook (fire-and-forget)
-	go h.callVMHook(context.Background(), workspaceID, "after-insert", tableName, req.Data)
+	// After-insert hook (queued, retried on failure)
+	h.dispatchHook(c.Request().Context(), workspace, entity.DataHookAfterInsert, tableName, req.Data)
 
 	return successResponse(c, map[string]interface{}{
 		"affected_rows": result.AffectedRows,
@@ -321,10 +493,11 @@ func (h *RuntimeDataHandler) InsertRow(c echo.Context) error {
 
 // UpdateRow 更新行（公开访问）
 func (h *RuntimeDataHandler) UpdateRow(c echo.Context) error {
-	workspaceID, err := h.resolveWorkspaceID(c)
-	if err != nil {
-		return nil
+	workspace, err := h.resolveWorkspace(c)
+	if workspace == nil {
+		return err
 	}
+	workspaceID := workspace.ID.String()
 
 	tableName := c.Param("table")
 	if strings.TrimSpace(tableName) == "" {
@@ -341,14 +514,18 @@ func (h *RuntimeDataHandler) UpdateRow(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "Data cannot be empty")
 	}
 
+	scope, err := h.resolveRLS(c, workspace.ID, tableName, entity.RLSOperationUpdate)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "RLS_FAILED", "Failed to resolve row level security")
+	}
+	if scope.denied {
+		return rlsWriteDenied(c)
+	}
+
 	// Before-update hook
-	hookRes := h.callVMHook(c.Request().Context(), workspaceID, "before-update", tableName, req.Data)
+	hookRes := h.callVMHook(c.Request().Context(), workspace, entity.DataHookBeforeUpdate, tableName, req.Data)
 	if hookRes.Handled && !hookRes.Allow {
-		errMsg := hookRes.Error
-		if errMsg == "" {
-			errMsg = "Operation rejected by business rule"
-		}
-		return errorResponse(c, http.StatusBadRequest, "HOOK_REJECTED", errMsg)
+		return rejectByHook(c, hookRes)
 	}
 	if hookRes.Handled && hookRes.Data != nil {
 		for k, v := range hookRes.Data {
@@ -368,13 +545,24 @@ func (h *RuntimeDataHandler) UpdateRow(c echo.Context) error {
 	if len(pkCols) == 0 {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "Data must include 'id' field")
 	}
-	result, err := h.vmStore.UpdateRow(c.Request().Context(), workspaceID, tableName, dataCols, pkCols)
+
+	// RLS：只能更新自己的行，且不能把行转移给其他用户
+	if col := rlsOwnerViolation(scope, dataCols); col != "" {
+		return errorResponse(c, http.StatusForbidden, "RLS_VIOLATION", "Column '"+col+"' is protected by row level security")
+	}
+
+	result, err := h.vmStore.UpdateRowScoped(c.Request().Context(), workspaceID, tableName, dataCols, pkCols, scope.rowScope())
 	if err != nil {
+		if errors.Is(err, vmruntime.ErrRowScopeViolation) {
+			return errorResponse(c, http.StatusForbidden, "RLS_VIOLATION", "Row violates row level security policy")
+		}
 		return handleDBQueryError(c, err)
 	}
 
-	// After-update hook (fire-and-forget)
-	go h.callVMHook(context.Background(), workspaceID, "after-update", tableName, req.Data)
+	// After-update hook (queued, retried on failure); skipped when no row matched
+	if result.AffectedRows > 0 {
+		h.dispatchHook(c.Request().Context(), workspace, entity.DataHookAfterUpdate, tableName, req.Data)
+	}
 
 	return successResponse(c, map[string]interface{}{
 		"affected_rows": result.AffectedRows,
@@ -383,10 +571,11 @@ func (h *RuntimeDataHandler) UpdateRow(c echo.Context) error {
 
 // DeleteRows 删除行（公开访问）
 func (h *RuntimeDataHandler) DeleteRows(c echo.Context) error {
-	workspaceID, err := h.resolveWorkspaceID(c)
-	if err != nil {
-		return nil
+	workspace, err := h.resolveWorkspace(c)
+	if workspace == nil {
+		return err
 	}
+	workspaceID := workspace.ID.String()
 
 	tableName := c.Param("table")
 	if strings.TrimSpace(tableName) == "" {
@@ -403,11 +592,34 @@ func (h *RuntimeDataHandler) DeleteRows(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "IDs cannot be empty")
 	}
 
-	result, err := h.vmStore.DeleteRows(c.Request().Context(), workspaceID, tableName, req.IDs)
+	// RLS：只能删除自己的行
+	scope, err := h.resolveRLS(c, workspace.ID, tableName, entity.RLSOperationDelete)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "RLS_FAILED", "Failed to resolve row level security")
+	}
+	if scope.denied {
+		return rlsWriteDenied(c)
+	}
+
+	// Before-delete hook — data is { ids }; can reject but not change the ids
+	hookData := map[string]interface{}{"ids": req.IDs}
+	hookRes := h.callVMHook(c.Request().Context(), workspace, entity.DataHookBeforeDelete, tableName, hookData)
+	if hookRes.Handled && !hookRes.Allow {
+		return rejectByHook(c, hookRes)
+	}
+
+	result, err := h.vmStore.DeleteRowsScoped(c.Request().Context(), workspaceID, tableName, req.IDs, scope.rowScope())
 	if err != nil {
 		return handleDBQueryError(c, err)
 	}
 
+	// After-delete hook (queued, retried on failure) receives only the ids
+	// actually deleted; skipped when no row matched
+	if len(result.DeletedIDs) > 0 {
+		h.dispatchHook(c.Request().Context(), workspace, entity.DataHookAfterDelete, tableName,
+			map[string]interface{}{"ids": result.DeletedIDs})
+	}
+
 	return successResponse(c, map[string]interface{}{
 		"affected_rows": result.AffectedRows,
 	})
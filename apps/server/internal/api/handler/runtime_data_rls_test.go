@@ -0,0 +1,344 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/service"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// stubRLSService returns a fixed policy list for every table lookup.
+type stubRLSService struct {
+	service.WorkspaceRLSService
+	policies []entity.RLSPolicy
+}
+
+func (s *stubRLSService) GetActivePoliciesForTable(_ context.Context, _ uuid.UUID, tableName string) ([]entity.RLSPolicy, error) {
+	var out []entity.RLSPolicy
+	for _, p := range s.policies {
+		if strings.EqualFold(p.TblName, tableName) && p.Enabled {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+func (s *stubRLSService) ListPolicies(_ context.Context, _ uuid.UUID) ([]entity.RLSPolicy, error) {
+	return s.policies, nil
+}
+
+// stubRuntimeAuthService resolves app users by token.
+type stubRuntimeAuthService struct {
+	service.RuntimeAuthService
+	users    map[string]*entity.AppUser          // token → user
+	sessions map[string]*entity.WorkspaceSession // token → session, optional
+}
+
+func (s *stubRuntimeAuthService) ValidateSession(_ context.Context, workspaceID uuid.UUID, token string) (*entity.AppUser, *entity.WorkspaceSession, error) {
+	user, ok := s.users[token]
+	if !ok || user.WorkspaceID != workspaceID {
+		return nil, nil, fmt.Errorf("invalid or expired session")
+	}
+	if session, ok := s.sessions[token]; ok {
+		return user, session, nil
+	}
+	return user, &entity.WorkspaceSession{ID: uuid.NewSHA1(uuid.NameSpaceOID, []byte(token)), WorkspaceID: workspaceID, AppUserID: &user.ID}, nil
+}
+
+type rlsTestEnv struct {
+	*integrationEnv
+	alice *entity.AppUser
+	bob   *entity.AppUser
+}
+
+// newRLSTestEnv creates a "notes" table owned via owner_id, with one row per user.
+func newRLSTestEnv(t *testing.T, operation string) *rlsTestEnv {
+	t.Helper()
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	wsID := uuid.MustParse(env.wsID)
+
+	alice := &entity.AppUser{ID: uuid.New(), WorkspaceID: wsID, Email: "alice@example.com"}
+	bob := &entity.AppUser{ID: uuid.New(), WorkspaceID: wsID, Email: "bob@example.com"}
+
+	env.dataHandler = NewRuntimeDataHandler(env.runtimeSvc, env.store, &stubRLSService{
+		policies: []entity.RLSPolicy{{
+			WorkspaceID: wsID,
+			TblName:     "notes",
+			Column:      "owner_id",
+			MatchField:  "app_user_id",
+			Operation:   operation,
+			Enabled:     true,
+		}},
+	})
+	env.dataHandler.SetRuntimeAuthService(&stubRuntimeAuthService{
+		users: map[string]*entity.AppUser{"alice-token": alice, "bob-token": bob},
+	})
+
+	if err := env.store.CreateTable(ctx, env.wsID, vmruntime.VMCreateTableRequest{
+		Name: "notes",
+		Columns: []vmruntime.VMCreateColumnDef{
+			{Name: "id", Type: "INTEGER"},
+			{Name: "owner_id", Type: "TEXT"},
+			{Name: "body", Type: "TEXT"},
+		},
+		PrimaryKey: []string{"id"},
+	}); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 1, "owner_id": alice.ID.String(), "body": "alice note"})
+	env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 2, "owner_id": bob.ID.String(), "body": "bob note"})
+
+	return &rlsTestEnv{integrationEnv: env, alice: alice, bob: bob}
+}
+
+func (env *rlsTestEnv) doDataWrite(method, token string, body interface{}) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, "/runtime/"+env.slug+"/data/notes", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("X-App-Token", token)
+	}
+	rec := httptest.NewRecorder()
+	c := env.echo.NewContext(req, rec)
+	c.SetParamNames("workspaceSlug", "table")
+	c.SetParamValues(env.slug, "notes")
+	switch method {
+	case http.MethodPost:
+		env.dataHandler.InsertRow(c)
+	case http.MethodPatch:
+		env.dataHandler.UpdateRow(c)
+	case http.MethodDelete:
+		env.dataHandler.DeleteRows(c)
+	}
+	return rec
+}
+
+func (env *rlsTestEnv) row(t *testing.T, id int) map[string]interface{} {
+	t.Helper()
+	result, err := env.store.ExecuteSQL(context.Background(), env.wsID, "SELECT * FROM notes WHERE id = ?", id)
+	if err != nil {
+		t.Fatalf("select row %d: %v", id, err)
+	}
+	if len(result.Rows) == 0 {
+		return nil
+	}
+	return result.Rows[0]
+}
+
+func affectedRows(t *testing.T, rec *httptest.ResponseRecorder) float64 {
+	t.Helper()
+	data, _ := parseJSON(t, rec)["data"].(map[string]interface{})
+	n, _ := data["affected_rows"].(float64)
+	return n
+}
+
+func TestRuntimeDataRLS_UpdateOtherUsersRowIsNoop(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationAll)
+
+	rec := env.doDataWrite(http.MethodPatch, "bob-token", map[string]interface{}{
+		"data": map[string]interface{}{"id": 1, "body": "hacked"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if n := affectedRows(t, rec); n != 0 {
+		t.Fatalf("affected_rows = %v, want 0", n)
+	}
+	if got := env.row(t, 1)["body"]; got != "alice note" {
+		t.Fatalf("alice's row was modified: body = %v", got)
+	}
+
+	rec = env.doDataWrite(http.MethodPatch, "alice-token", map[string]interface{}{
+		"data": map[string]interface{}{"id": 1, "body": "edited"},
+	})
+	if n := affectedRows(t, rec); n != 1 {
+		t.Fatalf("owner update affected_rows = %v, want 1", n)
+	}
+}
+
+func TestRuntimeDataRLS_UpdateCannotReassignOwner(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationAll)
+
+	rec := env.doDataWrite(http.MethodPatch, "alice-token", map[string]interface{}{
+		"data": map[string]interface{}{"id": 1, "owner_id": env.bob.ID.String()},
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := env.row(t, 1)["owner_id"]; got != env.alice.ID.String() {
+		t.Fatalf("owner changed to %v", got)
+	}
+}
+
+func TestRuntimeDataRLS_DeleteOnlyOwnRows(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationDelete)
+
+	rec := env.doDataWrite(http.MethodDelete, "bob-token", map[string]interface{}{"ids": []int{1, 2}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if n := affectedRows(t, rec); n != 1 {
+		t.Fatalf("affected_rows = %v, want 1", n)
+	}
+	if env.row(t, 1) == nil {
+		t.Fatal("alice's row was deleted by bob")
+	}
+	if env.row(t, 2) != nil {
+		t.Fatal("bob's own row should be deleted")
+	}
+}
+
+func TestRuntimeDataRLS_InsertStampsOwner(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationInsert)
+
+	rec := env.doDataWrite(http.MethodPost, "bob-token", map[string]interface{}{
+		"data": map[string]interface{}{"id": 3, "owner_id": env.alice.ID.String(), "body": "forged"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := env.row(t, 3)["owner_id"]; got != env.bob.ID.String() {
+		t.Fatalf("owner_id = %v, want bob (%s)", got, env.bob.ID)
+	}
+}
+
+func TestRuntimeDataRLS_WritesWithoutTokenDenied(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationAll)
+
+	cases := []struct {
+		method string
+		body   interface{}
+	}{
+		{http.MethodPost, map[string]interface{}{"data": map[string]interface{}{"body": "anon"}}},
+		{http.MethodPatch, map[string]interface{}{"data": map[string]interface{}{"id": 1, "body": "anon"}}},
+		{http.MethodDelete, map[string]interface{}{"ids": []int{1}}},
+	}
+	for _, tc := range cases {
+		for _, token := range []string{"", "bogus-token"} {
+			rec := env.doDataWrite(tc.method, token, tc.body)
+			if rec.Code != http.StatusUnauthorized {
+				t.Fatalf("%s token=%q status = %d, want 401", tc.method, token, rec.Code)
+			}
+		}
+	}
+	if got := env.row(t, 1)["body"]; got != "alice note" {
+		t.Fatalf("row modified without token: %v", got)
+	}
+}
+
+func TestRuntimeDataRLS_OperationScoping(t *testing.T) {
+	// A select-only policy must not restrict writes.
+	env := newRLSTestEnv(t, entity.RLSOperationSelect)
+
+	rec := env.doDataWrite(http.MethodPatch, "", map[string]interface{}{
+		"data": map[string]interface{}{"id": 1, "body": "open"},
+	})
+	if rec.Code != http.StatusOK || affectedRows(t, rec) != 1 {
+		t.Fatalf("select-only policy blocked update: %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestRuntimeDataRLS_OrCombinatorCannotBypassSelect(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationSelect)
+
+	url := "/runtime/" + env.slug + "/data/notes?filter_combinator=or" +
+		"&filters[0][column]=id&filters[0][operator]=>&filters[0][value]=0"
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("X-App-Token", "bob-token")
+	rec := httptest.NewRecorder()
+	c := env.echo.NewContext(req, rec)
+	c.SetParamNames("workspaceSlug", "table")
+	c.SetParamValues(env.slug, "notes")
+	env.dataHandler.QueryRows(c)
+
+	data := parseJSON(t, rec)["data"].(map[string]interface{})
+	if data["total"].(float64) != 1 {
+		t.Fatalf("total = %v, want 1 (only bob's row)", data["total"])
+	}
+}
+
+func TestRuntimeDataRLS_ExpressionPolicy(t *testing.T) {
+	env := newRLSTestEnv(t, entity.RLSOperationSelect)
+	ctx := context.Background()
+	wsID := uuid.MustParse(env.wsID)
+
+	env.store.ExecuteSQL(ctx, env.wsID, `ALTER TABLE notes ADD COLUMN is_public INTEGER NOT NULL DEFAULT 0`)
+	env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 3, "owner_id": env.alice.ID.String(), "body": "public", "is_public": 1})
+
+	env.dataHandler.rlsService = &stubRLSService{policies: []entity.RLSPolicy{{
+		WorkspaceID: wsID,
+		TblName:     "notes",
+		Operation:   entity.RLSOperationAll,
+		Expression:  "is_public = 1 OR owner_id = user.id",
+		Enabled:     true,
+	}}}
+
+	req := httptest.NewRequest(http.MethodGet, "/runtime/"+env.slug+"/data/notes", nil)
+	req.Header.Set("X-App-Token", "bob-token")
+	rec := httptest.NewRecorder()
+	c := env.echo.NewContext(req, rec)
+	c.SetParamNames("workspaceSlug", "table")
+	c.SetParamValues(env.slug, "notes")
+	env.dataHandler.QueryRows(c)
+	data := parseJSON(t, rec)["data"].(map[string]interface{})
+	if data["total"].(float64) != 2 {
+		t.Fatalf("bob sees %v rows, want 2 (own + public)", data["total"])
+	}
+
+	// WITH CHECK: bob may edit his row but not move it out of his scope.
+	rec = env.doDataWrite(http.MethodPatch, "bob-token", map[string]interface{}{
+		"data": map[string]interface{}{"id": 2, "owner_id": env.alice.ID.String()},
+	})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403, body = %s", rec.Code, rec.Body.String())
+	}
+	if got := env.row(t, 2)["owner_id"]; got != env.bob.ID.String() {
+		t.Fatalf("update was not rolled back: owner_id = %v", got)
+	}
+
+	// Inserting a private row for someone else is rejected as well.
+	rec = env.doDataWrite(http.MethodPost, "bob-token", map[string]interface{}{
+		"data": map[string]interface{}{"id": 4, "owner_id": env.alice.ID.String(), "body": "spoofed"},
+	})
+	if rec.Code != http.StatusForbidden || env.row(t, 4) != nil {
+		t.Fatalf("spoofed insert status = %d, row = %v", rec.Code, env.row(t, 4))
+	}
+}
+
+func TestRuntimeVM_RLSResolverIgnoresTableCase(t *testing.T) {
+	wsID := uuid.New()
+	alice := &entity.AppUser{ID: uuid.New(), WorkspaceID: wsID}
+	h := &RuntimeVMHandler{rlsService: &stubRLSService{policies: []entity.RLSPolicy{{
+		WorkspaceID: wsID,
+		TblName:     "Notes",
+		Column:      "owner_id",
+		MatchField:  "app_user_id",
+		Operation:   entity.RLSOperationAll,
+		Enabled:     true,
+	}}}}
+	resolver, err := h.rlsResolver(context.Background(), wsID, alice)
+	if err != nil || resolver == nil {
+		t.Fatalf("resolver = %v, err = %v", resolver, err)
+	}
+	for _, table := range []string{"notes", "NOTES", "Notes"} {
+		for _, op := range []string{vmruntime.VMScopeSelect, vmruntime.VMScopeDelete} {
+			scope, _, err := resolver(table, op)
+			if err != nil || scope == nil || scope.Clause == "" {
+				t.Fatalf("%s %s: scope = %+v, err = %v", op, table, scope, err)
+			}
+		}
+	}
+	if scope, _, _ := resolver("orders", vmruntime.VMScopeSelect); scope != nil && scope.Clause != "" {
+		t.Fatalf("unrestricted table got scope %+v", scope)
+	}
+}
@@ -0,0 +1,328 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/config"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/websocket"
+	"github.com/reverseai/server/internal/service"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// realtimePublishTimeout 单批变更做 RLS 过滤的超时
+const realtimePublishTimeout = 10 * time.Second
+
+// RuntimeRealtimeHandler 已发布 App 的实时数据订阅
+// 客户端通过 WebSocket 订阅 workspace:<id>:table:<name> 频道；
+// VMStore 提交的每次行变更（Data API、数据库面板、JS db API）按订阅者的 RLS 过滤后推送
+type RuntimeRealtimeHandler struct {
+	hub                *websocket.Hub
+	runtimeService     service.RuntimeService
+	vmStore            *vmruntime.VMStore
+	rlsService         service.WorkspaceRLSService
+	runtimeAuthService service.RuntimeAuthService
+	workspaceService   service.WorkspaceService
+	jwtCfg             *config.JWTConfig
+	wsCfg              *config.WebSocketConfig
+}
+
+// NewRuntimeRealtimeHandler 创建实时订阅处理器
+func NewRuntimeRealtimeHandler(
+	hub *websocket.Hub,
+	runtimeService service.RuntimeService,
+	vmStore *vmruntime.VMStore,
+	rlsService service.WorkspaceRLSService,
+	runtimeAuthService service.RuntimeAuthService,
+	workspaceService service.WorkspaceService,
+	jwtCfg *config.JWTConfig,
+	wsCfg *config.WebSocketConfig,
+) *RuntimeRealtimeHandler {
+	return &RuntimeRealtimeHandler{
+		hub:                hub,
+		runtimeService:     runtimeService,
+		vmStore:            vmStore,
+		rlsService:         rlsService,
+		runtimeAuthService: runtimeAuthService,
+		workspaceService:   workspaceService,
+		jwtCfg:             jwtCfg,
+		wsCfg:              wsCfg,
+	}
+}
+
+// realtimeSession 实时连接的身份，决定订阅者能收到哪些行
+type realtimeSession struct {
+	workspaceID  uuid.UUID
+	appUser      *entity.AppUser // 应用用户（App Token），nil 为匿名
+	appSessionID uuid.UUID       // 应用用户的会话，会话撤销时关闭连接
+	member       bool            // 有工作空间访问权限的平台用户，与数据库面板一样不受 RLS 限制
+}
+
+// key 相同 key 的订阅者看到的行相同，用于缓存过滤结果
+func (s *realtimeSession) key() string {
+	switch {
+	case s.member:
+		return "member"
+	case s.appUser != nil:
+		return "app:" + s.appUser.ID.String()
+	default:
+		return "anonymous"
+	}
+}
+
+// realtimeTableChannel 返回数据表的实时频道名
+func realtimeTableChannel(workspaceID, table string) string {
+	return fmt.Sprintf("workspace:%s:table:%s", workspaceID, table)
+}
+
+// HandleConnection 建立实时订阅连接
+// @Summary 实时数据订阅
+// @Tags Runtime
+// @Param workspaceSlug path string true "Workspace slug"
+// @Param app_token query string false "应用用户 Token（也可用 X-App-Token 请求头）"
+// @Param token query string false "平台 JWT，需有工作空间访问权限"
+// @Success 101 "Switching Protocols"
+// @Router /runtime/{workspaceSlug}/realtime [get]
+func (h *RuntimeRealtimeHandler) HandleConnection(c echo.Context) error {
+	workspace, err := resolveRuntimeWorkspace(c, h.runtimeService)
+	if err != nil {
+		return nil
+	}
+	// 已发布 App 可能嵌在 Workspace 自己配置的站点中
+	allowedOrigins := [][]string{h.wsCfg.AllowedOrigins, workspace.AllowedOrigins}
+	if !websocket.OriginAllowed(c.Request(), allowedOrigins...) {
+		return errorResponse(c, http.StatusForbidden, "ORIGIN_NOT_ALLOWED", "Origin not allowed")
+	}
+	var expiresAt time.Time
+	session := &realtimeSession{workspaceID: workspace.ID}
+	userID := ""
+
+	// 浏览器的 WebSocket 无法设置请求头，App Token 也可通过查询参数传递
+	appToken := c.QueryParam("app_token")
+	if appToken == "" {
+		appToken = c.Request().Header.Get("X-App-Token")
+	}
+	if appToken != "" {
+		user, appSession, err := h.validateAppToken(c.Request().Context(), appToken, workspace.ID)
+		if err != nil {
+			return errorResponse(c, http.StatusUnauthorized, "INVALID_APP_TOKEN", "Invalid or expired app session")
+		}
+		session.appUser = user
+		session.appSessionID = appSession.ID
+		userID = realtimeAppUserID(user.ID.String())
+		if appSession.ExpiredAt != nil {
+			expiresAt = *appSession.ExpiredAt
+		}
+	}
+
+	if tokenString := c.QueryParam("token"); tokenString != "" {
+		platformUserID, exp, err := parsePlatformToken(h.jwtCfg, tokenString)
+		if err != nil {
+			return errorResponse(c, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid or expired token")
+		}
+		uid, err := uuid.Parse(platformUserID)
+		if err != nil || h.workspaceService == nil {
+			return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "No access to this workspace")
+		}
+		if _, err := h.workspaceService.GetWorkspaceAccess(c.Request().Context(), workspace.ID, uid); err != nil {
+			return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "No access to this workspace")
+		}
+		session.member = true
+		userID = platformUserID
+		expiresAt = exp
+	}
+
+	conn, err := newUpgrader(allowedOrigins...).Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		return err
+	}
+
+	client := websocket.NewClient(uuid.New().String(), userID, conn, h.hub)
+	client.Session = session
+	client.Authorize = h.authorizeChannel(session)
+	client.ExpiresAt = expiresAt
+	h.hub.Register(client)
+
+	go client.WritePump()
+	go client.ReadPump()
+
+	return nil
+}
+
+// validateAppToken 校验应用用户会话，会话须属于该工作空间
+func (h *RuntimeRealtimeHandler) validateAppToken(ctx context.Context, token string, workspaceID uuid.UUID) (*entity.AppUser, *entity.WorkspaceSession, error) {
+	if h.runtimeAuthService == nil {
+		return nil, nil, errors.New("app authentication is not available")
+	}
+	return h.runtimeAuthService.ValidateSession(ctx, workspaceID, token)
+}
+
+// realtimeAppUserID 应用用户连接在 Hub 中的用户 ID，与平台用户区分
+func realtimeAppUserID(appUserID string) string {
+	return "app:" + appUserID
+}
+
+// CloseRevokedSessions 关闭会话已被撤销的应用用户连接
+// 作为失效总线的订阅者运行，登出、撤销会话、封禁或重置密码后各实例上的连接随即断开
+func (h *RuntimeRealtimeHandler) CloseRevokedSessions(event service.InvalidationEvent) {
+	if event.Kind != service.InvalidationAppSessions || event.AppUserID == "" {
+		return
+	}
+	for _, client := range h.hub.UserClients(realtimeAppUserID(event.AppUserID)) {
+		session, ok := client.Session.(*realtimeSession)
+		if !ok || session.appUser == nil || session.workspaceID.String() != event.WorkspaceID {
+			continue
+		}
+		if event.SessionID != "" && session.appSessionID.String() != event.SessionID {
+			continue
+		}
+		client.Close(websocket.CloseSessionRevoked, "session revoked")
+	}
+}
+
+// authorizeChannel 只允许订阅本工作空间的数据表频道
+func (h *RuntimeRealtimeHandler) authorizeChannel(session *realtimeSession) func(room string) error {
+	prefix := realtimeTableChannel(session.workspaceID.String(), "")
+	return func(room string) error {
+		table := strings.TrimPrefix(room, prefix)
+		if table == room || table == "" {
+			return fmt.Errorf("channel must be %s<name>", prefix)
+		}
+		if vmruntime.IsInternalTable(table) {
+			return fmt.Errorf("table %q cannot be subscribed to", table)
+		}
+		return nil
+	}
+}
+
+// PublishChanges 把一次提交的行变更推送给订阅了对应数据表的客户端
+// 作为 VMStore 的变更监听器运行；应用用户与匿名订阅者按数据表的 select 策略过滤（失败时不推送）
+func (h *RuntimeRealtimeHandler) PublishChanges(changes []vmruntime.VMTableChange) {
+	ctx, cancel := context.WithTimeout(context.Background(), realtimePublishTimeout)
+	defer cancel()
+
+	// 同一批次内每张表只读取一次策略；读取失败记为 nil，只推送给不受 RLS 限制的成员
+	policies := make(map[string][]entity.RLSPolicy)
+	for _, change := range changes {
+		channel := realtimeTableChannel(change.WorkspaceID, change.Table)
+		clients := h.hub.RoomClients(channel)
+		if len(clients) == 0 {
+			continue
+		}
+
+		tablePolicies, ok := policies[change.Table]
+		if !ok {
+			loaded, err := h.loadPolicies(ctx, change)
+			if err != nil {
+				log.Printf("[Realtime] load RLS policies for %s failed: %v", channel, err)
+				loaded = nil
+			} else if loaded == nil {
+				loaded = []entity.RLSPolicy{}
+			}
+			policies[change.Table] = loaded
+			tablePolicies = loaded
+		}
+
+		type visibility struct{ send, withOld bool }
+		cache := make(map[string]visibility)
+		var withOld, withoutOld []*websocket.Client
+		for _, client := range clients {
+			session, ok := client.Session.(*realtimeSession)
+			if !ok {
+				// 平台连接（/ws）订阅时已由 Hub 校验工作空间访问权限
+				session = &realtimeSession{member: true}
+			}
+			v, seen := cache[session.key()]
+			if !seen {
+				v.send, v.withOld = h.changeVisible(ctx, change, tablePolicies, session)
+				cache[session.key()] = v
+			}
+			switch {
+			case !v.send:
+			case v.withOld:
+				withOld = append(withOld, client)
+			default:
+				withoutOld = append(withoutOld, client)
+			}
+		}
+
+		h.send(withOld, channel, change, true)
+		h.send(withoutOld, channel, change, false)
+	}
+}
+
+// loadPolicies 读取数据表当前启用的 RLS 策略
+func (h *RuntimeRealtimeHandler) loadPolicies(ctx context.Context, change vmruntime.VMTableChange) ([]entity.RLSPolicy, error) {
+	if h.rlsService == nil {
+		return nil, nil
+	}
+	workspaceID, err := uuid.Parse(change.WorkspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return h.rlsService.GetActivePoliciesForTable(ctx, workspaceID, change.Table)
+}
+
+// changeVisible 判断订阅者能否收到变更，以及更新事件能否附带变更前的行
+// insert/update 以新行判断，delete 以被删除的行判断；更新前的行不可见时不附带
+func (h *RuntimeRealtimeHandler) changeVisible(ctx context.Context, change vmruntime.VMTableChange, policies []entity.RLSPolicy, session *realtimeSession) (bool, bool) {
+	if session.member {
+		return true, true
+	}
+	if policies == nil {
+		return false, false
+	}
+	scope, err := buildRLSScope(policies, entity.RLSOperationSelect, session.appUser)
+	if err != nil {
+		return false, false
+	}
+	rowScope := scope.rowScope()
+	inScope := func(row map[string]interface{}) bool {
+		ok, err := h.vmStore.RowInScope(ctx, change.WorkspaceID, change.Table, row, rowScope)
+		return err == nil && ok
+	}
+
+	switch change.Op {
+	case vmruntime.VMChangeDelete:
+		return inScope(change.Old), true
+	case vmruntime.VMChangeUpdate:
+		if !inScope(change.New) {
+			return false, false
+		}
+		return true, inScope(change.Old)
+	default:
+		return inScope(change.New), false
+	}
+}
+
+// send 推送一条变更消息
+func (h *RuntimeRealtimeHandler) send(clients []*websocket.Client, channel string, change vmruntime.VMTableChange, withOld bool) {
+	if len(clients) == 0 {
+		return
+	}
+	payload := websocket.TableChangePayload{
+		Channel:         channel,
+		Table:           change.Table,
+		Type:            change.Op,
+		Record:          change.New,
+		CommitTimestamp: change.CommittedAt,
+	}
+	if withOld {
+		payload.OldRecord = change.Old
+	}
+	if err := h.hub.SendToClients(clients, &websocket.Message{
+		Type:      websocket.MessageTypeTableChange,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}); err != nil {
+		log.Printf("[Realtime] publish to %s failed: %v", channel, err)
+	}
+}
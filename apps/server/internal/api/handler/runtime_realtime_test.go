@@ -0,0 +1,305 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	ws "github.com/gorilla/websocket"
+	"github.com/reverseai/server/internal/config"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/websocket"
+	"github.com/reverseai/server/internal/service"
+)
+
+// stubMemberWorkspaceService grants workspace access to a fixed set of users.
+type stubMemberWorkspaceService struct {
+	service.WorkspaceService
+	members map[uuid.UUID]bool
+}
+
+func (s *stubMemberWorkspaceService) GetWorkspaceAccess(_ context.Context, _ uuid.UUID, userID uuid.UUID) (*service.WorkspaceAccess, error) {
+	if !s.members[userID] {
+		return nil, errors.New("no access")
+	}
+	return &service.WorkspaceAccess{}, nil
+}
+
+type realtimeTestEnv struct {
+	*rlsTestEnv
+	handler *RuntimeRealtimeHandler
+	auth    *stubRuntimeAuthService
+	server  *httptest.Server
+	channel string
+	jwtCfg  *config.JWTConfig
+	member  uuid.UUID
+}
+
+// newRealtimeTestEnv serves the realtime endpoint for the RLS "notes" table,
+// with a select policy that limits app users to their own rows.
+func newRealtimeTestEnv(t *testing.T) *realtimeTestEnv {
+	t.Helper()
+	env := newRLSTestEnv(t, entity.RLSOperationSelect)
+	log, err := logger.New(false)
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	hub := websocket.NewHub(log)
+	go hub.Run()
+
+	jwtCfg := &config.JWTConfig{Secret: "realtime-test-secret"}
+	member := uuid.New()
+	wsID := uuid.MustParse(env.wsID)
+	auth := &stubRuntimeAuthService{
+		users:    map[string]*entity.AppUser{"alice-token": env.alice, "bob-token": env.bob},
+		sessions: map[string]*entity.WorkspaceSession{},
+	}
+	h := NewRuntimeRealtimeHandler(hub, env.runtimeSvc, env.store,
+		&stubRLSService{policies: []entity.RLSPolicy{{
+			WorkspaceID: wsID,
+			TblName:     "notes",
+			Column:      "owner_id",
+			MatchField:  "app_user_id",
+			Operation:   entity.RLSOperationSelect,
+			Enabled:     true,
+		}}},
+		auth,
+		&stubMemberWorkspaceService{members: map[uuid.UUID]bool{member: true}},
+		jwtCfg,
+		&config.WebSocketConfig{AllowedOrigins: []string{"https://studio.example.com"}},
+	)
+	env.store.SetChangeListener(h.PublishChanges)
+	env.echo.GET("/runtime/:workspaceSlug/realtime", h.HandleConnection)
+
+	server := httptest.NewServer(env.echo)
+	t.Cleanup(server.Close)
+	return &realtimeTestEnv{
+		rlsTestEnv: env,
+		handler:    h,
+		auth:       auth,
+		server:     server,
+		channel:    realtimeTableChannel(env.wsID, "notes"),
+		jwtCfg:     jwtCfg,
+		member:     member,
+	}
+}
+
+// dial connects with the given query string and subscribes to channel,
+// returning the connection once the subscription is confirmed.
+func (env *realtimeTestEnv) dial(t *testing.T, query, channel string) *ws.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(env.server.URL, "http") + "/runtime/" + env.slug + "/realtime?" + query
+	conn, _, err := ws.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", query, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	subscribe, _ := json.Marshal(map[string]interface{}{
+		"type":    "subscribe",
+		"payload": map[string]string{"channel": channel},
+	})
+	if err := conn.WriteMessage(ws.TextMessage, subscribe); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	if msg := readRealtime(t, conn); msg.Type != string(websocket.MessageTypeSubscribed) {
+		t.Fatalf("subscribe reply = %+v", msg)
+	}
+	return conn
+}
+
+type realtimeMessage struct {
+	Type    string `json:"type"`
+	Payload struct {
+		Channel   string                 `json:"channel"`
+		Type      string                 `json:"type"`
+		Record    map[string]interface{} `json:"record"`
+		OldRecord map[string]interface{} `json:"oldRecord"`
+		Error     string                 `json:"error"`
+	} `json:"payload"`
+}
+
+func readRealtime(t *testing.T, conn *ws.Conn) realtimeMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg realtimeMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return msg
+}
+
+func TestRuntimeRealtime_ChangesFilteredByRLS(t *testing.T) {
+	env := newRealtimeTestEnv(t)
+	ctx := context.Background()
+
+	alice := env.dial(t, "app_token=alice-token", env.channel)
+	bob := env.dial(t, "app_token=bob-token", env.channel)
+
+	if _, err := env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 3, "owner_id": env.alice.ID.String(), "body": "secret"}); err != nil {
+		t.Fatalf("insert alice row: %v", err)
+	}
+	if _, err := env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 4, "owner_id": env.bob.ID.String(), "body": "hello"}); err != nil {
+		t.Fatalf("insert bob row: %v", err)
+	}
+
+	msg := readRealtime(t, alice)
+	if msg.Type != string(websocket.MessageTypeTableChange) || msg.Payload.Type != "insert" || msg.Payload.Record["body"] != "secret" {
+		t.Fatalf("alice got %+v", msg)
+	}
+	// Bob's first event is his own row; Alice's insert was never sent to him.
+	msg = readRealtime(t, bob)
+	if msg.Payload.Channel != env.channel || msg.Payload.Record["body"] != "hello" {
+		t.Fatalf("bob got %+v", msg)
+	}
+
+	// Reassigning a row away from Bob is not visible to him, and Alice does
+	// not get the previous version she could not read.
+	if _, err := env.store.UpdateRow(ctx, env.wsID, "notes", map[string]interface{}{"owner_id": env.alice.ID.String()}, map[string]interface{}{"id": 4}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	msg = readRealtime(t, alice)
+	if msg.Payload.Type != "update" || msg.Payload.Record["body"] != "hello" || msg.Payload.OldRecord != nil {
+		t.Fatalf("alice update = %+v", msg)
+	}
+	if _, err := env.store.DeleteRows(ctx, env.wsID, "notes", []interface{}{2}); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	msg = readRealtime(t, bob)
+	if msg.Payload.Type != "delete" || msg.Payload.OldRecord["body"] != "bob note" {
+		t.Fatalf("bob delete = %+v", msg)
+	}
+}
+
+func TestRuntimeRealtime_MemberSeesAllRows(t *testing.T) {
+	env := newRealtimeTestEnv(t)
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": env.member.String(),
+		"exp":     time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte(env.jwtCfg.Secret))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	conn := env.dial(t, "token="+token, env.channel)
+
+	if _, err := env.store.UpdateRow(context.Background(), env.wsID, "notes", map[string]interface{}{"body": "edited"}, map[string]interface{}{"id": 2}); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	msg := readRealtime(t, conn)
+	if msg.Payload.Type != "update" || msg.Payload.Record["body"] != "edited" || msg.Payload.OldRecord["body"] != "bob note" {
+		t.Fatalf("member got %+v", msg)
+	}
+}
+
+func TestRuntimeRealtime_RejectsForeignOriginsAndChannels(t *testing.T) {
+	env := newRealtimeTestEnv(t)
+	env.runtimeSvc.workspaces[env.slug].AllowedOrigins = entity.StringArray{"https://*.myapp.dev"}
+
+	url := "ws" + strings.TrimPrefix(env.server.URL, "http") + "/runtime/" + env.slug + "/realtime"
+	for origin, ok := range map[string]bool{
+		"https://studio.example.com": true,
+		"https://shop.myapp.dev":     true,
+		"https://myapp.dev":          false,
+		"https://evil.example.net":   false,
+	} {
+		conn, resp, err := ws.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if ok && err != nil {
+			t.Fatalf("origin %s rejected: %v", origin, err)
+		}
+		if !ok && (err == nil || resp == nil || resp.StatusCode != http.StatusForbidden) {
+			t.Fatalf("origin %s: err=%v resp=%v", origin, err, resp)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	if _, resp, err := ws.DefaultDialer.Dial(url+"?app_token=nope", nil); err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("invalid app token: err=%v resp=%v", err, resp)
+	}
+
+	conn := env.dial(t, "", env.channel)
+	for _, channel := range []string{
+		realtimeTableChannel(uuid.New().String(), "notes"),
+		realtimeTableChannel(env.wsID, "_vm_kv"),
+		realtimeTableChannel(env.wsID, ""),
+		"execution:abc",
+	} {
+		subscribe, _ := json.Marshal(map[string]interface{}{
+			"type":    "subscribe",
+			"payload": map[string]string{"channel": channel},
+		})
+		conn.WriteMessage(ws.TextMessage, subscribe)
+		if msg := readRealtime(t, conn); msg.Type != string(websocket.MessageTypeError) || msg.Payload.Channel != channel {
+			t.Fatalf("subscribe %q = %+v", channel, msg)
+		}
+	}
+}
+
+// expectClose reads until the server closes conn and checks the close code.
+func expectClose(t *testing.T, conn *ws.Conn, code int) {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *ws.CloseError
+		if !errors.As(err, &closeErr) || closeErr.Code != code {
+			t.Fatalf("read err = %v, want close %d", err, code)
+		}
+		return
+	}
+}
+
+func TestRuntimeRealtime_AppSessionExpiry(t *testing.T) {
+	env := newRealtimeTestEnv(t)
+	expiresAt := time.Now().Add(300 * time.Millisecond)
+	env.auth.sessions["alice-token"] = &entity.WorkspaceSession{ID: uuid.New(), AppUserID: &env.alice.ID, ExpiredAt: &expiresAt}
+
+	conn := env.dial(t, "app_token=alice-token", env.channel)
+	expectClose(t, conn, websocket.CloseTokenExpired)
+}
+
+func TestRuntimeRealtime_ClosesRevokedAppSessions(t *testing.T) {
+	env := newRealtimeTestEnv(t)
+	aliceSession := uuid.New()
+	env.auth.sessions["alice-token"] = &entity.WorkspaceSession{ID: aliceSession, AppUserID: &env.alice.ID}
+
+	alice := env.dial(t, "app_token=alice-token", env.channel)
+	bob := env.dial(t, "app_token=bob-token", env.channel)
+
+	// Another session of alice, and alice's session in another workspace,
+	// leave the connection open.
+	env.handler.CloseRevokedSessions(service.InvalidationEvent{
+		Kind: service.InvalidationAppSessions, WorkspaceID: env.wsID, AppUserID: env.alice.ID.String(), SessionID: uuid.NewString(),
+	})
+	env.handler.CloseRevokedSessions(service.InvalidationEvent{
+		Kind: service.InvalidationAppSessions, WorkspaceID: uuid.NewString(), AppUserID: env.alice.ID.String(),
+	})
+	if _, err := env.store.InsertRow(context.Background(), env.wsID, "notes", map[string]interface{}{"id": 3, "owner_id": env.alice.ID.String(), "body": "still here"}); err != nil {
+		t.Fatalf("insert: %v", err)
+	}
+	if msg := readRealtime(t, alice); msg.Payload.Record["body"] != "still here" {
+		t.Fatalf("alice got %+v", msg)
+	}
+
+	env.handler.CloseRevokedSessions(service.InvalidationEvent{
+		Kind: service.InvalidationAppSessions, WorkspaceID: env.wsID, AppUserID: env.alice.ID.String(), SessionID: aliceSession.String(),
+	})
+	expectClose(t, alice, websocket.CloseSessionRevoked)
+
+	// Revoking all of bob's sessions closes his connection too.
+	env.handler.CloseRevokedSessions(service.InvalidationEvent{
+		Kind: service.InvalidationAppSessions, WorkspaceID: env.wsID, AppUserID: env.bob.ID.String(),
+	})
+	expectClose(t, bob, websocket.CloseSessionRevoked)
+}
@@ -1,11 +1,14 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/service"
 )
 
 func newRuntimeTestContext(host string, headers map[string]string) echo.Context {
@@ -60,3 +63,23 @@ func TestGetDomainHost(t *testing.T) {
 		})
 	}
 }
+
+func TestRuntimeErrorResponse_RateLimitedSetsRetryAfter(t *testing.T) {
+	ctx := newRuntimeTestContext("platform.local", nil)
+	err := fmt.Errorf("track access: %w", &service.RuntimeRateLimitError{
+		Scope:      "ip",
+		Limit:      10,
+		Window:     time.Minute,
+		RetryAfter: 1500 * time.Millisecond,
+	})
+
+	if respErr := runtimeErrorResponse(ctx, err, "RUNTIME_FAILED", "failed"); respErr != nil {
+		t.Fatalf("unexpected error: %v", respErr)
+	}
+	if status := ctx.Response().Status; status != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", status)
+	}
+	if got := ctx.Response().Header().Get("Retry-After"); got != "2" {
+		t.Fatalf("Retry-After = %q, want %q", got, "2")
+	}
+}
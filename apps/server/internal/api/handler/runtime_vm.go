@@ -1,12 +1,18 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/domain/entity"
 	"github.com/reverseai/server/internal/service"
 	"github.com/reverseai/server/internal/vmruntime"
 )
@@ -16,6 +22,9 @@ type RuntimeVMHandler struct {
 	runtimeService     service.RuntimeService
 	vmPool             *vmruntime.VMPool
 	runtimeAuthService service.RuntimeAuthService
+	rlsService         service.WorkspaceRLSService
+	secretService      service.WorkspaceSecretService
+	scheduleService    service.WorkspaceScheduleService
 }
 
 // NewRuntimeVMHandler creates a new RuntimeVMHandler.
@@ -31,6 +40,17 @@ func NewRuntimeVMHandler(
 	}
 }
 
+// SetRLSService sets the RLS policy service used to scope the VM db API.
+func (h *RuntimeVMHandler) SetRLSService(rlsService service.WorkspaceRLSService) {
+	h.rlsService = rlsService
+}
+
+// SetSecretService sets the secret service backing `secrets.get` and fetch
+// secret references.
+func (h *RuntimeVMHandler) SetSecretService(secretService service.WorkspaceSecretService) {
+	h.secretService = secretService
+}
+
 // HandleAPI is the catch-all handler for /runtime/:slug/api/*
 func (h *RuntimeVMHandler) HandleAPI(c echo.Context) error {
 	slug := c.Param("workspaceSlug")
@@ -49,6 +69,28 @@ func (h *RuntimeVMHandler) HandleAPI(c echo.Context) error {
 
 	workspaceID := entry.Workspace.ID.String()
 
+	// 限流 / 异常检测 / 过载保护（仅对匿名公开访问的 Workspace 生效）
+	accessMeta := h.buildAccessMeta(c)
+	accessResult, err := h.runtimeService.TrackAnonymousAccess(c.Request().Context(), entry, accessMeta)
+	if err != nil {
+		if status, code, message, ok := mapRuntimeError(err); ok {
+			if status == http.StatusTooManyRequests {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(runtimeRetryAfterSeconds(err)))
+			}
+			return c.JSON(status, map[string]interface{}{
+				"error": message,
+				"code":  code,
+			})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "failed to track runtime access",
+		})
+	}
+	var session *entity.WorkspaceSession
+	if accessResult != nil {
+		session = accessResult.Session
+	}
+
 	vm, err := h.vmPool.GetOrCreate(c.Request().Context(), workspaceID)
 	if err != nil {
 		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
@@ -63,11 +105,60 @@ func (h *RuntimeVMHandler) HandleAPI(c echo.Context) error {
 	}
 
 	// Build VMRequest
-	req := h.buildVMRequest(c, apiPath)
+	appUser := resolveAppUser(c, h.runtimeAuthService, entry.Workspace.ID)
+	req := h.buildVMRequest(c, apiPath, appUser)
+	req.Scope, err = h.rlsResolver(c.Request().Context(), entry.Workspace.ID, appUser)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": "failed to resolve row level security",
+		})
+	}
+	var fetches []vmruntime.VMFetchEvent
+	req.Egress = &vmruntime.VMEgress{
+		Allow:   getEgressRules(entry.Workspace.Settings),
+		OnFetch: func(event vmruntime.VMFetchEvent) { fetches = append(fetches, event) },
+	}
+	defer func() { h.recordFetches(c, entry, session, fetches) }()
+	if h.secretService != nil {
+		ctx, workspaceID := c.Request().Context(), entry.Workspace.ID
+		req.Secrets = func(name string) (string, bool, error) {
+			return h.secretService.Resolve(ctx, workspaceID, name)
+		}
+		req.Redactor = h.secretService.Redactor(ctx, workspaceID)
+	}
 
 	// Execute in VM
-	resp, err := vm.Handle(req)
+	resp, err := vm.HandleContext(c.Request().Context(), req)
+	// ErrVMClosed 仅在 VM 被池淘汰且数据库句柄已关闭时出现，重试会拿到新的 VM
+	if errors.Is(err, vmruntime.ErrVMBusy) || errors.Is(err, vmruntime.ErrVMClosed) {
+		h.recordExecutionResult(c, entry, session, accessMeta, http.StatusServiceUnavailable)
+		c.Response().Header().Set("Retry-After", "1")
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"error": "workspace is busy, please retry",
+			"code":  "VM_BUSY",
+		})
+	}
+	if errors.Is(err, vmruntime.ErrVMHeapPressure) {
+		h.recordExecutionResult(c, entry, session, accessMeta, http.StatusServiceUnavailable)
+		c.Response().Header().Set("Retry-After", "1")
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"error": "server is under memory pressure, please retry",
+			"code":  "VM_HEAP_PRESSURE",
+		})
+	}
+	var limitErr *vmruntime.VMLimitError
+	if errors.As(err, &limitErr) {
+		h.recordExecutionResult(c, entry, session, accessMeta, http.StatusInternalServerError)
+		h.recordLimitExceeded(c, entry, session, accessMeta, limitErr)
+		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
+			"error": limitErr.Error(),
+			"code":  "VM_LIMIT_EXCEEDED",
+			"limit": limitErr.Limit,
+			"max":   limitErr.Max,
+		})
+	}
 	if err != nil {
+		h.recordExecutionResult(c, entry, session, accessMeta, http.StatusInternalServerError)
 		return c.JSON(http.StatusInternalServerError, map[string]interface{}{
 			"error": err.Error(),
 		})
@@ -77,11 +168,76 @@ func (h *RuntimeVMHandler) HandleAPI(c echo.Context) error {
 	if status == 0 {
 		status = http.StatusOK
 	}
+	h.recordExecutionResult(c, entry, session, accessMeta, status)
 	return c.JSON(status, resp.Body)
 }
 
+// buildAccessMeta 构建 Runtime 访问元信息；未携带会话时不为每个 API 调用创建匿名会话
+func (h *RuntimeVMHandler) buildAccessMeta(c echo.Context) service.RuntimeAccessMeta {
+	var sessionID *uuid.UUID
+	raw := strings.TrimSpace(c.Request().Header.Get("X-Workspace-Session-Id"))
+	if raw == "" {
+		raw = strings.TrimSpace(c.Request().Header.Get("X-App-Session-Id"))
+	}
+	if parsed, err := uuid.Parse(raw); err == nil {
+		sessionID = &parsed
+	}
+	return service.RuntimeAccessMeta{
+		SessionID:   sessionID,
+		IP:          c.RealIP(),
+		UserAgent:   c.Request().UserAgent(),
+		EventType:   service.RuntimeEventExecute,
+		Path:        c.Request().URL.Path,
+		SkipSession: true,
+	}
+}
+
+// recordExecutionResult 记录执行结果，4xx/5xx 计入失败率检测
+func (h *RuntimeVMHandler) recordExecutionResult(c echo.Context, entry *service.RuntimeEntry, session *entity.WorkspaceSession, meta service.RuntimeAccessMeta, status int) {
+	payload := entity.JSON{
+		"method": c.Request().Method,
+		"path":   meta.Path,
+		"status": status,
+	}
+	_ = h.runtimeService.RecordExecutionResult(c.Request().Context(), entry, session, meta, status >= http.StatusBadRequest, payload)
+}
+
+// recordLimitExceeded 记录超出执行预算（步数/内存/超时等）的事件
+func (h *RuntimeVMHandler) recordLimitExceeded(c echo.Context, entry *service.RuntimeEntry, session *entity.WorkspaceSession, meta service.RuntimeAccessMeta, limitErr *vmruntime.VMLimitError) {
+	log.Printf("[VM:%s] %s %s: %v", entry.Workspace.ID, c.Request().Method, meta.Path, limitErr)
+	payload := entity.JSON{
+		"method": c.Request().Method,
+		"path":   meta.Path,
+		"limit":  limitErr.Limit,
+		"max":    limitErr.Max,
+	}
+	_ = h.runtimeService.RecordRuntimeEvent(c.Request().Context(), entry, session, service.RuntimeEventLimitExceeded, payload)
+}
+
+// recordFetches 记录本次执行中的每个出站 fetch 调用（不含 query、请求头与请求体，避免泄露密钥）
+func (h *RuntimeVMHandler) recordFetches(c echo.Context, entry *service.RuntimeEntry, session *entity.WorkspaceSession, fetches []vmruntime.VMFetchEvent) {
+	for _, event := range fetches {
+		if event.Blocked {
+			log.Printf("[VM:%s] fetch blocked: %s %s%s: %s", entry.Workspace.ID, event.Method, event.Host, event.Path, event.Error)
+		}
+		payload := entity.JSON{
+			"method":      event.Method,
+			"host":        event.Host,
+			"path":        event.Path,
+			"status":      event.Status,
+			"bytes":       event.Bytes,
+			"duration_ms": event.DurationMs,
+			"blocked":     event.Blocked,
+		}
+		if event.Error != "" {
+			payload["error"] = event.Error
+		}
+		_ = h.runtimeService.RecordRuntimeEvent(c.Request().Context(), entry, session, service.RuntimeEventFetch, payload)
+	}
+}
+
 // buildVMRequest extracts request parameters and builds a VMRequest.
-func (h *RuntimeVMHandler) buildVMRequest(c echo.Context, apiPath string) vmruntime.VMRequest {
+func (h *RuntimeVMHandler) buildVMRequest(c echo.Context, apiPath string, appUser *entity.AppUser) vmruntime.VMRequest {
 	req := vmruntime.VMRequest{
 		Method:  c.Request().Method,
 		Path:    apiPath,
@@ -113,8 +269,7 @@ func (h *RuntimeVMHandler) buildVMRequest(c echo.Context, apiPath string) vmrunt
 		req.Body = h.parseBody(c)
 	}
 
-	// Resolve app user from X-App-Token
-	req.User = h.resolveUser(c)
+	req.User = toVMUser(appUser)
 
 	return req
 }
@@ -133,15 +288,9 @@ func (h *RuntimeVMHandler) parseBody(c echo.Context) map[string]interface{} {
 	return data
 }
 
-// resolveUser extracts the app user from X-App-Token header.
-func (h *RuntimeVMHandler) resolveUser(c echo.Context) *vmruntime.VMUser {
-	token := c.Request().Header.Get("X-App-Token")
-	if token == "" || h.runtimeAuthService == nil {
-		return nil
-	}
-
-	user, err := h.runtimeAuthService.ValidateSession(c.Request().Context(), token)
-	if err != nil || user == nil {
+// toVMUser converts the app user resolved from X-App-Token for the VM context.
+func toVMUser(user *entity.AppUser) *vmruntime.VMUser {
+	if user == nil {
 		return nil
 	}
 
@@ -155,3 +304,42 @@ func (h *RuntimeVMHandler) resolveUser(c echo.Context) *vmruntime.VMUser {
 		Name:  name,
 	}
 }
+
+// rlsResolver builds the per-request RLS resolver for the VM db API.
+// Policies are loaded once; compiled scopes are cached per table and operation.
+// Returns nil when the workspace has no enabled policies.
+func (h *RuntimeVMHandler) rlsResolver(ctx context.Context, workspaceID uuid.UUID, user *entity.AppUser) (vmruntime.VMScopeResolver, error) {
+	if h.rlsService == nil {
+		return nil, nil
+	}
+	policies, err := h.rlsService.ListPolicies(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite 表名不区分大小写，策略按小写表名匹配
+	byTable := make(map[string][]entity.RLSPolicy)
+	for _, policy := range policies {
+		if policy.Enabled {
+			name := strings.ToLower(policy.TblName)
+			byTable[name] = append(byTable[name], policy)
+		}
+	}
+	if len(byTable) == 0 {
+		return nil, nil
+	}
+
+	cache := make(map[string]*rlsScope)
+	return func(table, operation string) (*vmruntime.VMRowScope, map[string]string, error) {
+		table = strings.ToLower(table)
+		key := table + "\x00" + operation
+		scope, ok := cache[key]
+		if !ok {
+			var err error
+			if scope, err = buildRLSScope(byTable[table], operation, user); err != nil {
+				return nil, nil, err
+			}
+			cache[key] = scope
+		}
+		return scope.rowScope(), scope.owner, nil
+	}, nil
+}
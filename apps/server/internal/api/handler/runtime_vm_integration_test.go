@@ -6,24 +6,28 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/security"
 	"github.com/reverseai/server/internal/service"
 	"github.com/reverseai/server/internal/vmruntime"
 )
 
 // ── Mocks ────────────────────────────────────────────────────────────
 
-// stubRuntimeService mocks RuntimeService; only GetEntry is implemented.
+// stubRuntimeService mocks RuntimeService; GetEntry resolves by slug and access tracking is a no-op.
 type stubRuntimeService struct {
 	service.RuntimeService
 	workspaces map[string]*entity.Workspace // slug → workspace
+	events     []string                     // recorded runtime event types
 }
 
 func (s *stubRuntimeService) GetEntry(_ context.Context, slug string, _ *uuid.UUID) (*service.RuntimeEntry, error) {
@@ -34,6 +38,19 @@ func (s *stubRuntimeService) GetEntry(_ context.Context, slug string, _ *uuid.UU
 	return &service.RuntimeEntry{Workspace: ws}, nil
 }
 
+func (s *stubRuntimeService) TrackAnonymousAccess(_ context.Context, _ *service.RuntimeEntry, _ service.RuntimeAccessMeta) (*service.RuntimeAccessResult, error) {
+	return &service.RuntimeAccessResult{}, nil
+}
+
+func (s *stubRuntimeService) RecordExecutionResult(_ context.Context, _ *service.RuntimeEntry, _ *entity.WorkspaceSession, _ service.RuntimeAccessMeta, _ bool, _ entity.JSON) error {
+	return nil
+}
+
+func (s *stubRuntimeService) RecordRuntimeEvent(_ context.Context, _ *service.RuntimeEntry, _ *entity.WorkspaceSession, eventType string, _ entity.JSON) error {
+	s.events = append(s.events, eventType)
+	return nil
+}
+
 // stubCodeLoader is a configurable VMCodeLoader for tests.
 type stubCodeLoader struct {
 	codes map[string]string // workspaceID → JS code
@@ -78,11 +95,11 @@ func newIntegrationEnv(t *testing.T) *integrationEnv {
 	}
 
 	dir := t.TempDir()
-	store := vmruntime.NewVMStore(dir)
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: dir})
 	t.Cleanup(func() { store.Close() })
 
 	loader := &stubCodeLoader{codes: make(map[string]string)}
-	pool := vmruntime.NewVMPool(store, loader, 10)
+	pool := vmruntime.NewVMPool(store, loader, vmruntime.VMPoolOptions{MaxVMs: 10})
 	t.Cleanup(func() { pool.Close() })
 
 	vmHandler := NewRuntimeVMHandler(runtimeSvc, pool, nil)
@@ -421,16 +438,21 @@ func TestIntegration_InfiniteLoop_Timeout(t *testing.T) {
 		t.Fatalf("/ok status = %d, want 200", rec.Code)
 	}
 
-	// The /loop route should timeout and return 500
+	// The /loop route exhausts its step budget before the wall clock and returns 500
 	rec = env.doVMRequest("GET", "/loop", nil)
 	if rec.Code != http.StatusInternalServerError {
 		t.Fatalf("/loop status = %d, want 500\nBody: %s", rec.Code, rec.Body.String())
 	}
 
 	resp := parseJSON(t, rec)
-	errMsg, _ := resp["error"].(string)
-	if !strings.Contains(errMsg, "timeout") && !strings.Contains(errMsg, "interrupt") {
-		t.Fatalf("error = %q, should mention timeout/interrupt", errMsg)
+	if resp["code"] != "VM_LIMIT_EXCEEDED" {
+		t.Fatalf("code = %v, want VM_LIMIT_EXCEEDED", resp["code"])
+	}
+	if limit := resp["limit"]; limit != vmruntime.VMLimitSteps && limit != vmruntime.VMLimitExecTimeout {
+		t.Fatalf("limit = %v, want steps or exec_timeout", limit)
+	}
+	if len(env.runtimeSvc.events) != 1 || env.runtimeSvc.events[0] != service.RuntimeEventLimitExceeded {
+		t.Fatalf("events = %v, want [%s]", env.runtimeSvc.events, service.RuntimeEventLimitExceeded)
 	}
 }
 
@@ -528,3 +550,312 @@ func TestIntegration_QueryParamsAndHeaders(t *testing.T) {
 		t.Fatalf("contentType = %v, want application/json", resp["contentType"])
 	}
 }
+
+// ── Integration Test: fetch egress allowlist and runtime events ──
+
+func TestIntegration_FetchEgressAllowlist(t *testing.T) {
+	env := newIntegrationEnv(t)
+	env.runtimeSvc.workspaces[env.slug].Settings = entity.JSON{
+		egressSettingsKey: []interface{}{
+			map[string]interface{}{"host": "api.example.com", "methods": []interface{}{"post"}},
+			map[string]interface{}{"host": "bad host"},
+		},
+	}
+	env.loader.codes[env.wsID] = `
+		exports.routes = {
+			"GET /hook": function(ctx) {
+				try { fetch("http://127.0.0.1:1/internal?token=x"); } catch (e) { return { error: String(e) }; }
+			}
+		};
+	`
+
+	if rules := getEgressRules(env.runtimeSvc.workspaces[env.slug].Settings); len(rules) != 1 || rules[0].Methods[0] != "POST" {
+		t.Fatalf("rules = %+v, want the valid rule only", rules)
+	}
+
+	rec := env.doVMRequest("GET", "/hook", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200\nBody: %s", rec.Code, rec.Body.String())
+	}
+	if msg, _ := parseJSON(t, rec)["error"].(string); !strings.Contains(msg, "egress allowlist") {
+		t.Fatalf("error = %v, want the host refused", msg)
+	}
+	if len(env.runtimeSvc.events) != 1 || env.runtimeSvc.events[0] != service.RuntimeEventFetch {
+		t.Fatalf("events = %v, want one %s", env.runtimeSvc.events, service.RuntimeEventFetch)
+	}
+}
+
+// stubScheduleService records the specs synced by the handler.
+type stubScheduleService struct {
+	service.WorkspaceScheduleService
+	synced   []string
+	executor service.ScheduleExecutor
+}
+
+func (s *stubScheduleService) SetExecutor(executor service.ScheduleExecutor) {
+	s.executor = executor
+}
+
+func (s *stubScheduleService) Sync(_ context.Context, _ uuid.UUID, specs []string) error {
+	s.synced = specs
+	return nil
+}
+
+func TestIntegration_SchedulesSyncAndRun(t *testing.T) {
+	env := newIntegrationEnv(t)
+	schedules := &stubScheduleService{synced: []string{"stale"}}
+	env.vmHandler.SetScheduleService(schedules)
+	ws := env.runtimeSvc.workspaces[env.slug]
+
+	if err := env.vmHandler.SyncSchedules(context.Background(), ws.ID); err != nil || schedules.synced != nil {
+		t.Fatalf("sync without code: err=%v synced=%v", err, schedules.synced)
+	}
+
+	env.loader.codes[env.wsID] = `
+		exports.schedules = {
+			"0 2 * * *": function(ctx) { kv.set("last", { trigger: ctx.trigger, attempt: ctx.attempt }); }
+		};
+		exports.routes = {
+			"GET /last": function(ctx) { return kv.get("last"); }
+		};
+	`
+	if err := env.vmHandler.SyncSchedules(context.Background(), ws.ID); err != nil {
+		t.Fatalf("SyncSchedules: %v", err)
+	}
+	if len(schedules.synced) != 1 || schedules.synced[0] != "0 2 * * *" {
+		t.Fatalf("synced = %v", schedules.synced)
+	}
+
+	err := schedules.executor(context.Background(), ws, service.ScheduleExecution{
+		RunID: uuid.New(), Cron: "0 2 * * *", Trigger: "manual", ScheduledAt: time.Now(), Attempt: 1,
+	})
+	if err != nil {
+		t.Fatalf("executor: %v", err)
+	}
+	last := parseJSON(t, env.doVMRequest("GET", "/last", nil))
+	if last["trigger"] != "manual" || last["attempt"] != float64(1) {
+		t.Fatalf("last = %v", last)
+	}
+}
+
+// stubDataHookService records the after-* hooks dispatched by the data handler.
+type stubDataHookService struct {
+	service.WorkspaceDataHookService
+	dispatched []string
+	data       []map[string]interface{}
+	executor   service.DataHookExecutor
+}
+
+func (s *stubDataHookService) SetExecutor(executor service.DataHookExecutor) {
+	s.executor = executor
+}
+
+func (s *stubDataHookService) Dispatch(_ context.Context, _ uuid.UUID, hook, table string, data map[string]interface{}) {
+	s.dispatched = append(s.dispatched, hook+"/"+table)
+	s.data = append(s.data, data)
+}
+
+func (env *integrationEnv) doDataDeleteRequest(table string, ids []interface{}) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"ids": ids})
+	req := httptest.NewRequest(http.MethodDelete, "/runtime/"+env.slug+"/data/"+table, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := env.echo.NewContext(req, rec)
+	c.SetParamNames("workspaceSlug", "table")
+	c.SetParamValues(env.slug, table)
+	env.dataHandler.DeleteRows(c)
+	return rec
+}
+
+func TestIntegration_DataHooksQueuedAndDeleteHooks(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	hooks := &stubDataHookService{}
+	env.dataHandler.SetVMPool(env.pool)
+	env.dataHandler.SetDataHookService(hooks)
+
+	env.store.CreateTable(ctx, env.wsID, vmruntime.VMCreateTableRequest{
+		Name: "notes",
+		Columns: []vmruntime.VMCreateColumnDef{
+			{Name: "id", Type: "INTEGER", Nullable: false},
+			{Name: "title", Type: "TEXT", Nullable: false},
+		},
+		PrimaryKey: []string{"id"},
+	})
+	env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 1, "title": "pinned"})
+	env.store.InsertRow(ctx, env.wsID, "notes", map[string]interface{}{"id": 2, "title": "scratch"})
+
+	env.loader.codes[env.wsID] = `
+		exports.routes = {
+			"POST /hooks/before-delete/:table": function(ctx) {
+				if (ctx.body.data.ids.indexOf(1) >= 0) return { allow: false, error: "pinned notes cannot be deleted" };
+				return { allow: true };
+			},
+			"POST /hooks/after-delete/:table": function(ctx) {
+				kv.set("deleted", { ids: ctx.body.data.ids, eventId: ctx.body.eventId, attempt: ctx.body.attempt });
+				return { ok: true };
+			},
+			"POST /hooks/after-insert/:table": function(ctx) { throw new Error("webhook down"); },
+			"GET /deleted": function(ctx) { return kv.get("deleted"); }
+		};
+	`
+
+	rec := env.doDataDeleteRequest("notes", []interface{}{1})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "pinned notes") {
+		t.Fatalf("before-delete should reject: %d %s", rec.Code, rec.Body.String())
+	}
+	if len(hooks.dispatched) != 0 {
+		t.Fatalf("rejected delete dispatched %v", hooks.dispatched)
+	}
+
+	rec = env.doDataDeleteRequest("notes", []interface{}{2, 3})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DeleteRows status = %d\nBody: %s", rec.Code, rec.Body.String())
+	}
+	// Only the row actually deleted reaches the after-delete hook
+	if ids := fmt.Sprint(hooks.data[0]["ids"]); ids != "[2]" {
+		t.Fatalf("after-delete ids = %s, want [2]", ids)
+	}
+	// No row matched: after-delete is not dispatched again
+	env.doDataDeleteRequest("notes", []interface{}{2})
+	if len(hooks.dispatched) != 1 || hooks.dispatched[0] != "after-delete/notes" {
+		t.Fatalf("dispatched = %v", hooks.dispatched)
+	}
+
+	// The worker side: run the queued hook through the executor
+	ws := env.runtimeSvc.workspaces[env.slug]
+	eventID := uuid.New()
+	handled, err := hooks.executor(ctx, ws, service.DataHookExecution{
+		EventID: eventID, Hook: "after-delete", Table: "notes", Data: hooks.data[0], Attempt: 2,
+	})
+	if err != nil || !handled {
+		t.Fatalf("after-delete executor: handled=%v err=%v", handled, err)
+	}
+	deleted := parseJSON(t, env.doVMRequest("GET", "/deleted", nil))
+	if deleted["eventId"] != eventID.String() || deleted["attempt"] != float64(2) {
+		t.Fatalf("deleted = %v", deleted)
+	}
+
+	// A throwing hook fails the attempt so the queue retries it
+	if handled, err := hooks.executor(ctx, ws, service.DataHookExecution{
+		EventID: uuid.New(), Hook: "after-insert", Table: "notes", Attempt: 1,
+	}); !handled || err == nil || !strings.Contains(err.Error(), "webhook down") {
+		t.Fatalf("after-insert executor: handled=%v err=%v", handled, err)
+	}
+
+	// No hook registered: not handled, nothing to record
+	if handled, err := hooks.executor(ctx, ws, service.DataHookExecution{
+		EventID: uuid.New(), Hook: "after-update", Table: "notes", Attempt: 1,
+	}); handled || err != nil {
+		t.Fatalf("after-update executor: handled=%v err=%v", handled, err)
+	}
+}
+
+// stubSecretService resolves secrets from a fixed map.
+type stubSecretService struct {
+	service.WorkspaceSecretService
+	values map[string]string
+}
+
+func (s *stubSecretService) Resolve(_ context.Context, _ uuid.UUID, name string) (string, bool, error) {
+	value, ok := s.values[name]
+	return value, ok, nil
+}
+
+func (s *stubSecretService) Redactor(_ context.Context, _ uuid.UUID) *security.SecretRedactor {
+	return security.NewSecretRedactor(s.values)
+}
+
+func TestIntegration_DataHookSecretsAndEgress(t *testing.T) {
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	hooks := &stubDataHookService{}
+	env.dataHandler.SetVMPool(env.pool)
+	env.dataHandler.SetDataHookService(hooks)
+	env.dataHandler.SetSecretService(&stubSecretService{values: map[string]string{"WEBHOOK_TOKEN": "tok-live-9f8e7d6c5b4a"}})
+	ws := env.runtimeSvc.workspaces[env.slug]
+	ws.Settings = entity.JSON{
+		egressSettingsKey: []interface{}{map[string]interface{}{"host": "hooks.example.com"}},
+	}
+
+	env.loader.codes[env.wsID] = `
+		exports.routes = {
+			"POST /hooks/after-insert/:table": function(ctx) {
+				var token = secrets.get("WEBHOOK_TOKEN");
+				console.log("notifying with " + token);
+				try { fetch("http://127.0.0.1:1/notify"); } catch (e) { kv.set("fetch", String(e)); }
+				kv.set("seen", token.length);
+				return { ok: true };
+			},
+			"GET /state": function(ctx) { return { seen: kv.get("seen"), fetch: kv.get("fetch") }; }
+		};
+	`
+
+	handled, err := hooks.executor(ctx, ws, service.DataHookExecution{
+		EventID: uuid.New(), Hook: "after-insert", Table: "notes", Data: map[string]interface{}{"id": 1}, Attempt: 1,
+	})
+	if err != nil || !handled {
+		t.Fatalf("after-insert executor: handled=%v err=%v", handled, err)
+	}
+	state := parseJSON(t, env.doVMRequest("GET", "/state", nil))
+	if state["seen"] != float64(len("tok-live-9f8e7d6c5b4a")) {
+		t.Fatalf("hook did not resolve the secret: %v", state)
+	}
+	if msg, _ := state["fetch"].(string); !strings.Contains(msg, "egress allowlist") {
+		t.Fatalf("fetch = %v, want the host refused by the workspace allowlist", state["fetch"])
+	}
+	if out := logs.String(); strings.Contains(out, "tok-live-9f8e7d6c5b4a") || !strings.Contains(out, "notifying with") {
+		t.Fatalf("hook console output not redacted:\n%s", out)
+	}
+}
+
+func TestIntegration_DataHookResultsRedacted(t *testing.T) {
+	env := newIntegrationEnv(t)
+	ctx := context.Background()
+	hooks := &stubDataHookService{}
+	env.dataHandler.SetVMPool(env.pool)
+	env.dataHandler.SetDataHookService(hooks)
+	env.dataHandler.SetSecretService(&stubSecretService{values: map[string]string{"WEBHOOK_TOKEN": "tok-live-9f8e7d6c5b4a"}})
+	ws := env.runtimeSvc.workspaces[env.slug]
+
+	env.loader.codes[env.wsID] = `
+		exports.routes = {
+			"POST /hooks/before-insert/:table": function(ctx) {
+				return { allow: false, error: "rejected by " + secrets.get("WEBHOOK_TOKEN") };
+			},
+			"POST /hooks/after-insert/:table": function(ctx) {
+				return { status: 502, body: { error: "upstream refused " + secrets.get("WEBHOOK_TOKEN") } };
+			},
+			"POST /hooks/after-update/:table": function(ctx) {
+				throw new Error("bad token " + secrets.get("WEBHOOK_TOKEN"));
+			}
+		};
+	`
+
+	// The rejection message returned to the client
+	rec := env.doDataInsertRequest("notes", map[string]interface{}{"title": "x"})
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "rejected by") {
+		t.Fatalf("before-insert should reject: %d %s", rec.Code, rec.Body.String())
+	}
+	if strings.Contains(rec.Body.String(), "tok-live-9f8e7d6c5b4a") {
+		t.Fatalf("rejection leaks the secret: %s", rec.Body.String())
+	}
+
+	// The errors recorded as hook runs
+	for _, hook := range []string{"after-insert", "after-update"} {
+		handled, err := hooks.executor(ctx, ws, service.DataHookExecution{
+			EventID: uuid.New(), Hook: hook, Table: "notes", Attempt: 1,
+		})
+		if !handled || err == nil {
+			t.Fatalf("%s executor: handled=%v err=%v, want a failure", hook, handled, err)
+		}
+		if strings.Contains(err.Error(), "tok-live-9f8e7d6c5b4a") {
+			t.Fatalf("%s error leaks the secret: %v", hook, err)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/service"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// SetScheduleService sets the schedule service kept in sync with the deployed
+// exports.schedules and registers RunSchedule as its executor.
+func (h *RuntimeVMHandler) SetScheduleService(scheduleService service.WorkspaceScheduleService) {
+	h.scheduleService = scheduleService
+	scheduleService.SetExecutor(h.RunSchedule)
+}
+
+// RunSchedule 在工作空间 VM 中执行一次定时任务（service.ScheduleExecutor）
+// 出站白名单与密钥和 HTTP 请求一致；没有会话，因此 fetch 只在被拦截时写日志
+func (h *RuntimeVMHandler) RunSchedule(ctx context.Context, workspace *entity.Workspace, exec service.ScheduleExecution) error {
+	vm, err := h.vmPool.GetOrCreate(ctx, workspace.ID.String())
+	if err != nil {
+		return err
+	}
+	run := vmruntime.VMScheduleRun{
+		Schedule:    exec.Cron,
+		ScheduledAt: exec.ScheduledAt,
+		Trigger:     exec.Trigger,
+		RunID:       exec.RunID.String(),
+		Attempt:     exec.Attempt,
+		Egress: &vmruntime.VMEgress{
+			Allow: getEgressRules(workspace.Settings),
+			OnFetch: func(event vmruntime.VMFetchEvent) {
+				if event.Blocked {
+					log.Printf("[VM:%s] schedule %q fetch blocked: %s %s%s: %s", workspace.ID, exec.Cron, event.Method, event.Host, event.Path, event.Error)
+				}
+			},
+		},
+	}
+	if h.secretService != nil {
+		workspaceID := workspace.ID
+		run.Secrets = func(name string) (string, bool, error) {
+			return h.secretService.Resolve(ctx, workspaceID, name)
+		}
+		run.Redactor = h.secretService.Redactor(ctx, workspaceID)
+	}
+	_, err = vm.RunSchedule(ctx, run)
+	var limitErr *vmruntime.VMLimitError
+	if errors.As(err, &limitErr) {
+		log.Printf("[VM:%s] schedule %q: %v", workspace.ID, exec.Cron, limitErr)
+	}
+	return err
+}
+
+// SyncSchedules 将工作空间当前部署代码中的 exports.schedules 同步到定时任务服务
+// 代码加载失败时保留已有记录，执行时会失败并写入执行记录
+func (h *RuntimeVMHandler) SyncSchedules(ctx context.Context, workspaceID uuid.UUID) error {
+	if h.scheduleService == nil {
+		return nil
+	}
+	vm, err := h.vmPool.GetOrCreate(ctx, workspaceID.String())
+	if errors.Is(err, vmruntime.ErrNoLogicCode) {
+		return h.scheduleService.Sync(ctx, workspaceID, nil)
+	}
+	if err != nil {
+		return err
+	}
+	return h.scheduleService.Sync(ctx, workspaceID, vm.Schedules())
+}
@@ -1,7 +1,12 @@
 package handler
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
@@ -9,31 +14,47 @@ import (
 	"github.com/labstack/echo/v4"
 	"github.com/reverseai/server/internal/config"
 	"github.com/reverseai/server/internal/pkg/websocket"
+	"github.com/reverseai/server/internal/service"
 )
 
-var upgrader = ws.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// 在生产环境中应该检查 Origin
-		return true
-	},
+// newUpgrader 创建 WebSocket Upgrader，握手 Origin 须同源或匹配 allowedOrigins
+func newUpgrader(allowedOrigins ...[]string) *ws.Upgrader {
+	return &ws.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			return websocket.OriginAllowed(r, allowedOrigins...)
+		},
+	}
 }
 
+// roomAuthorizeTimeout 房间鉴权查询的超时
+const roomAuthorizeTimeout = 5 * time.Second
+
 // WebSocketHandler WebSocket 处理器
 type WebSocketHandler struct {
-	hub    *websocket.Hub
-	jwtCfg *config.JWTConfig
+	hub              *websocket.Hub
+	jwtCfg           *config.JWTConfig
+	wsCfg            *config.WebSocketConfig
+	workspaceService service.WorkspaceService
+	agentSessions    *service.AgentSessionManager
 }
 
 // NewWebSocketHandler 创建 WebSocket 处理器
-func NewWebSocketHandler(hub *websocket.Hub, jwtCfg *config.JWTConfig) *WebSocketHandler {
+func NewWebSocketHandler(hub *websocket.Hub, jwtCfg *config.JWTConfig, wsCfg *config.WebSocketConfig, workspaceService service.WorkspaceService) *WebSocketHandler {
 	return &WebSocketHandler{
-		hub:    hub,
-		jwtCfg: jwtCfg,
+		hub:              hub,
+		jwtCfg:           jwtCfg,
+		wsCfg:            wsCfg,
+		workspaceService: workspaceService,
 	}
 }
 
+// SetAgentSessions 设置 Agent 会话管理器，用于 agent:<sessionID> 房间鉴权
+func (h *WebSocketHandler) SetAgentSessions(sessions *service.AgentSessionManager) {
+	h.agentSessions = sessions
+}
+
 // HandleConnection 处理 WebSocket 连接
 // @Summary WebSocket 连接
 // @Tags WebSocket
@@ -41,6 +62,10 @@ func NewWebSocketHandler(hub *websocket.Hub, jwtCfg *config.JWTConfig) *WebSocke
 // @Success 101 "Switching Protocols"
 // @Router /ws [get]
 func (h *WebSocketHandler) HandleConnection(c echo.Context) error {
+	if !websocket.OriginAllowed(c.Request(), h.wsCfg.AllowedOrigins) {
+		return errorResponse(c, http.StatusForbidden, "ORIGIN_NOT_ALLOWED", "Origin not allowed")
+	}
+
 	// 从查询参数获取 Token
 	tokenString := c.QueryParam("token")
 	if tokenString == "" {
@@ -48,20 +73,21 @@ func (h *WebSocketHandler) HandleConnection(c echo.Context) error {
 	}
 
 	// 验证 Token
-	userID, err := h.validateToken(tokenString)
+	userID, expiresAt, err := h.validateToken(tokenString)
 	if err != nil {
 		return errorResponse(c, http.StatusUnauthorized, "INVALID_TOKEN", "Invalid or expired token")
 	}
 
 	// 升级 HTTP 连接为 WebSocket
-	conn, err := upgrader.Upgrade(c.Response(), c.Request(), nil)
+	conn, err := newUpgrader(h.wsCfg.AllowedOrigins).Upgrade(c.Response(), c.Request(), nil)
 	if err != nil {
 		return err
 	}
 
-	// 创建客户端
+	// 创建客户端，Token 过期时关闭连接
 	clientID := uuid.New().String()
 	client := websocket.NewClient(clientID, userID, conn, h.hub)
+	client.ExpiresAt = expiresAt
 
 	// 注册客户端
 	h.hub.Register(client)
@@ -73,24 +99,87 @@ func (h *WebSocketHandler) HandleConnection(c echo.Context) error {
 	return nil
 }
 
-func (h *WebSocketHandler) validateToken(tokenString string) (string, error) {
+// AuthorizeRoom 平台连接的房间鉴权，作为 Hub 的默认鉴权
+// 开放 workspace:<id> 及其子频道与 agent:<sessionID>，订阅者须有所属工作空间的访问权限
+func (h *WebSocketHandler) AuthorizeRoom(client *websocket.Client, room string) error {
+	workspaceID, ok := roomWorkspaceID(room)
+	if !ok {
+		workspaceID, ok = h.agentRoomWorkspaceID(room)
+	}
+	if !ok {
+		return fmt.Errorf("unknown channel %q", room)
+	}
+	userID, err := uuid.Parse(client.UserID)
+	if err != nil || h.workspaceService == nil {
+		return errors.New("no access to this workspace")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), roomAuthorizeTimeout)
+	defer cancel()
+	if _, err := h.workspaceService.GetWorkspaceAccess(ctx, workspaceID, userID); err != nil {
+		return errors.New("no access to this workspace")
+	}
+	return nil
+}
+
+// agentRoomWorkspaceID 查找 agent:<sessionID> 房间对应会话所属的工作空间
+func (h *WebSocketHandler) agentRoomWorkspaceID(room string) (uuid.UUID, bool) {
+	sessionID, ok := strings.CutPrefix(room, websocket.AgentRoom(""))
+	if !ok || sessionID == "" || h.agentSessions == nil {
+		return uuid.Nil, false
+	}
+	session, ok := h.agentSessions.Get(sessionID)
+	if !ok {
+		return uuid.Nil, false
+	}
+	workspaceID, err := uuid.Parse(session.WorkspaceID)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return workspaceID, true
+}
+
+// roomWorkspaceID 解析 workspace:<id>[:...] 房间所属的工作空间
+func roomWorkspaceID(room string) (uuid.UUID, bool) {
+	rest, ok := strings.CutPrefix(room, "workspace:")
+	if !ok {
+		return uuid.Nil, false
+	}
+	id, _, _ := strings.Cut(rest, ":")
+	workspaceID, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, false
+	}
+	return workspaceID, true
+}
+
+func (h *WebSocketHandler) validateToken(tokenString string) (string, time.Time, error) {
+	return parsePlatformToken(h.jwtCfg, tokenString)
+}
+
+// parsePlatformToken 校验平台 JWT 并返回其中的用户 ID 与过期时间（未设置时为零值）
+func parsePlatformToken(jwtCfg *config.JWTConfig, tokenString string) (string, time.Time, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(h.jwtCfg.Secret), nil
+		return []byte(jwtCfg.Secret), nil
 	})
 
 	if err != nil || !token.Valid {
-		return "", err
+		return "", time.Time{}, err
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", jwt.ErrInvalidKey
+		return "", time.Time{}, jwt.ErrInvalidKey
 	}
 
 	userID, ok := claims["user_id"].(string)
 	if !ok {
-		return "", jwt.ErrInvalidKey
+		return "", time.Time{}, jwt.ErrInvalidKey
+	}
+
+	var expiresAt time.Time
+	if exp, err := claims.GetExpirationTime(); err == nil && exp != nil {
+		expiresAt = exp.Time
 	}
 
-	return userID, nil
+	return userID, expiresAt, nil
 }
@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	ws "github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/config"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/websocket"
+	"github.com/reverseai/server/internal/service"
+)
+
+type wsTestEnv struct {
+	url       string
+	jwtCfg    *config.JWTConfig
+	member    uuid.UUID
+	workspace uuid.UUID
+}
+
+// newWSTestEnv serves /ws with room authorization against one workspace and
+// at most two subscriptions per connection.
+func newWSTestEnv(t *testing.T) *wsTestEnv {
+	t.Helper()
+	log, err := logger.New(false)
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	env := &wsTestEnv{
+		jwtCfg:    &config.JWTConfig{Secret: "ws-test-secret"},
+		member:    uuid.New(),
+		workspace: uuid.New(),
+	}
+	hub := websocket.NewHub(log)
+	h := NewWebSocketHandler(hub, env.jwtCfg,
+		&config.WebSocketConfig{AllowedOrigins: []string{"http://localhost:*"}},
+		&stubMemberWorkspaceService{members: map[uuid.UUID]bool{env.member: true}},
+	)
+	sessions := service.NewAgentSessionManager()
+	sessions.GetOrCreate("agent-session", env.workspace.String(), env.member.String(), "")
+	h.SetAgentSessions(sessions)
+	hub.SetAuthorizer(h.AuthorizeRoom)
+	hub.SetMaxSubscriptions(2)
+	go hub.Run()
+
+	e := echo.New()
+	e.GET("/ws", h.HandleConnection)
+	server := httptest.NewServer(e)
+	t.Cleanup(server.Close)
+	env.url = "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	return env
+}
+
+func (env *wsTestEnv) token(t *testing.T, userID uuid.UUID, ttl time.Duration) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id": userID.String(),
+		"exp":     time.Now().Add(ttl).Unix(),
+	}).SignedString([]byte(env.jwtCfg.Secret))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	return token
+}
+
+func (env *wsTestEnv) dial(t *testing.T, token string, header http.Header) (*ws.Conn, *http.Response, error) {
+	t.Helper()
+	conn, resp, err := ws.DefaultDialer.Dial(env.url+"?token="+token, header)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+// subscribe sends a subscribe request and returns the reply type and error text.
+func subscribeChannel(t *testing.T, conn *ws.Conn, channel string) (string, string) {
+	t.Helper()
+	subscribe, _ := json.Marshal(map[string]interface{}{
+		"type":    "subscribe",
+		"payload": map[string]string{"channel": channel},
+	})
+	if err := conn.WriteMessage(ws.TextMessage, subscribe); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	msg := readRealtime(t, conn)
+	if msg.Payload.Channel != channel {
+		t.Fatalf("reply for %q = %+v", channel, msg)
+	}
+	return msg.Type, msg.Payload.Error
+}
+
+func TestWebSocket_RoomAuthorization(t *testing.T) {
+	env := newWSTestEnv(t)
+
+	member, _, err := env.dial(t, env.token(t, env.member, time.Hour), nil)
+	if err != nil {
+		t.Fatalf("dial member: %v", err)
+	}
+	room := "workspace:" + env.workspace.String()
+	if typ, errText := subscribeChannel(t, member, room+":table:notes"); typ != "subscribed" {
+		t.Fatalf("member subscribe = %s %s", typ, errText)
+	}
+	// Agent rooms are checked against the session's workspace.
+	if typ, errText := subscribeChannel(t, member, websocket.AgentRoom("agent-session")); typ != "subscribed" {
+		t.Fatalf("member agent room = %s %s", typ, errText)
+	}
+	// Rooms without a workspace to check against are refused.
+	for _, room := range []string{"exec-123", websocket.AgentRoom("unknown-session")} {
+		if typ, _ := subscribeChannel(t, member, room); typ != "error" {
+			t.Fatalf("%s subscribe = %s", room, typ)
+		}
+	}
+
+	outsider, _, err := env.dial(t, env.token(t, uuid.New(), time.Hour), nil)
+	if err != nil {
+		t.Fatalf("dial outsider: %v", err)
+	}
+	for _, channel := range []string{room, websocket.AgentRoom("agent-session")} {
+		if typ, errText := subscribeChannel(t, outsider, channel); typ != "error" || !strings.Contains(errText, "no access") {
+			t.Fatalf("outsider subscribe %s = %s %s", channel, typ, errText)
+		}
+	}
+}
+
+func TestWebSocket_SubscriptionLimit(t *testing.T) {
+	env := newWSTestEnv(t)
+	conn, _, err := env.dial(t, env.token(t, env.member, time.Hour), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	room := "workspace:" + env.workspace.String()
+	for _, channel := range []string{room + ":table:a", room + ":table:b", room + ":table:a"} {
+		if typ, errText := subscribeChannel(t, conn, channel); typ != "subscribed" {
+			t.Fatalf("subscribe %s = %s %s", channel, typ, errText)
+		}
+	}
+	if typ, errText := subscribeChannel(t, conn, room+":table:c"); typ != "error" || !strings.Contains(errText, "too many") {
+		t.Fatalf("third channel = %s %s", typ, errText)
+	}
+}
+
+func TestWebSocket_OriginCheck(t *testing.T) {
+	env := newWSTestEnv(t)
+	token := env.token(t, env.member, time.Hour)
+
+	if _, _, err := env.dial(t, token, http.Header{"Origin": {"http://localhost:5173"}}); err != nil {
+		t.Fatalf("allowed origin: %v", err)
+	}
+	if _, resp, err := env.dial(t, token, http.Header{"Origin": {"https://evil.example.com"}}); err == nil || resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("foreign origin: err=%v resp=%v", err, resp)
+	}
+}
+
+func TestWebSocket_ClosesOnTokenExpiry(t *testing.T) {
+	env := newWSTestEnv(t)
+	conn, _, err := env.dial(t, env.token(t, env.member, time.Second), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err = conn.ReadMessage()
+	if !ws.IsCloseError(err, websocket.CloseTokenExpired) {
+		t.Fatalf("read after expiry: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/api/middleware"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/repository"
+	"github.com/reverseai/server/internal/service"
+)
+
+// WorkspaceDataHookHandler 数据钩子执行记录与死信 Handler
+type WorkspaceDataHookHandler struct {
+	dataHookService service.WorkspaceDataHookService
+	auditLogService service.AuditLogService
+}
+
+func NewWorkspaceDataHookHandler(dataHookService service.WorkspaceDataHookService, auditLogService service.AuditLogService) *WorkspaceDataHookHandler {
+	return &WorkspaceDataHookHandler{dataHookService: dataHookService, auditLogService: auditLogService}
+}
+
+// parseIDs 解析路径中的工作空间 ID 与当前用户，失败时已写入错误响应
+func (h *WorkspaceDataHookHandler) parseIDs(c echo.Context) (uuid.UUID, uuid.UUID, bool) {
+	uid, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		_ = errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "用户 ID 无效")
+		return uuid.Nil, uuid.Nil, false
+	}
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = errorResponse(c, http.StatusBadRequest, "INVALID_ID", "工作空间 ID 无效")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return workspaceID, uid, true
+}
+
+// ListRuns 列出 after-* 钩子执行记录，可按 table / hook / status / event_id 过滤
+func (h *WorkspaceDataHookHandler) ListRuns(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	filter := repository.DataHookRunFilter{
+		Table:  c.QueryParam("table"),
+		Hook:   c.QueryParam("hook"),
+		Status: c.QueryParam("status"),
+	}
+	if raw := c.QueryParam("event_id"); raw != "" {
+		eventID, err := uuid.Parse(raw)
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "事件 ID 无效")
+		}
+		filter.EventID = &eventID
+	}
+	filter.Limit, _ = strconv.Atoi(c.QueryParam("limit"))
+	runs, err := h.dataHookService.ListRuns(c.Request().Context(), workspaceID, uid, filter)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"runs": runs,
+	})
+}
+
+// ListDeadTasks 列出重试次数用尽的钩子任务
+func (h *WorkspaceDataHookHandler) ListDeadTasks(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	tasks, err := h.dataHookService.ListDeadTasks(c.Request().Context(), workspaceID, uid, limit)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"tasks": tasks,
+	})
+}
+
+// RetryDeadTask 将死信任务重新入队
+func (h *WorkspaceDataHookHandler) RetryDeadTask(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	taskID := c.Param("taskId")
+	if taskID == "" {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "任务 ID 无效")
+	}
+	if err := h.dataHookService.RetryDeadTask(c.Request().Context(), workspaceID, uid, taskID); err != nil {
+		return h.handleError(c, err)
+	}
+
+	if h.auditLogService != nil {
+		_, _ = h.auditLogService.Record(c.Request().Context(), service.AuditLogRecordRequest{
+			WorkspaceID: workspaceID,
+			ActorUserID: &uid,
+			Action:      "workspace.data_hook.retry",
+			TargetType:  "data_hook",
+			Metadata:    buildAuditMetadata(c, entity.JSON{"task_id": taskID}),
+		})
+	}
+
+	return successResponse(c, map[string]interface{}{
+		"task_id": taskID,
+	})
+}
+
+func (h *WorkspaceDataHookHandler) handleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrWorkspaceNotFound):
+		return errorResponse(c, http.StatusNotFound, "NOT_FOUND", "工作空间不存在")
+	case errors.Is(err, service.ErrWorkspaceUnauthorized):
+		return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "无权限查看或重试数据钩子")
+	case errors.Is(err, service.ErrDataHookTaskNotFound):
+		return errorResponse(c, http.StatusNotFound, "TASK_NOT_FOUND", "死信任务不存在")
+	case errors.Is(err, service.ErrDataHookQueueUnavailable):
+		return errorResponse(c, http.StatusServiceUnavailable, "QUEUE_UNAVAILABLE", "任务队列不可用")
+	default:
+		return errorResponse(c, http.StatusInternalServerError, "INTERNAL_ERROR", "数据钩子操作失败")
+	}
+}
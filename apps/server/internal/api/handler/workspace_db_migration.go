@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// ListMigrations 获取数据库 Schema 迁移列表（按版本升序）
+func (h *VMDatabaseHandler) ListMigrations(c echo.Context) error {
+	workspaceID, _, err := h.ensureAccess(c, false)
+	if err != nil {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	migrations, err := h.vmStore.ListMigrations(ctx, workspaceID)
+	if err != nil {
+		return handleMigrationError(c, err)
+	}
+	current, err := h.vmStore.SchemaVersion(ctx, workspaceID)
+	if err != nil {
+		return handleMigrationError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"migrations":      migrations,
+		"current_version": current,
+	})
+}
+
+// ApplyMigration 将数据库迁移到指定版本（依次应用已回退的迁移）
+func (h *VMDatabaseHandler) ApplyMigration(c echo.Context) error {
+	return h.migrateTo(c, "workspace.db.migration.apply", 0)
+}
+
+// RevertMigration 回退指定版本及其之后的迁移（回退前自动创建 pre_migrate 快照）
+func (h *VMDatabaseHandler) RevertMigration(c echo.Context) error {
+	return h.migrateTo(c, "workspace.db.migration.revert", -1)
+}
+
+// migrateTo 将数据库迁移到 :version + offset
+func (h *VMDatabaseHandler) migrateTo(c echo.Context, action string, offset int64) error {
+	workspaceID, userID, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+
+	version, err := strconv.ParseInt(c.Param("version"), 10, 64)
+	if err != nil || version < 1 {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_VERSION", "迁移版本无效")
+	}
+
+	ctx := c.Request().Context()
+	if err := h.vmStore.MigrateTo(ctx, workspaceID, version+offset); err != nil {
+		return handleMigrationError(c, err)
+	}
+	current, err := h.vmStore.SchemaVersion(ctx, workspaceID)
+	if err != nil {
+		return handleMigrationError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, userID, action, "database_migration", nil, entity.JSON{
+		"version":         version,
+		"current_version": current,
+	})
+
+	return successResponse(c, map[string]interface{}{
+		"message":         "数据库 Schema 已迁移",
+		"current_version": current,
+	})
+}
+
+func handleMigrationError(c echo.Context, err error) error {
+	if errors.Is(err, vmruntime.ErrMigrationNotFound) {
+		return errorResponse(c, http.StatusNotFound, "MIGRATION_NOT_FOUND", "迁移不存在")
+	}
+	return handleDBQueryError(c, err)
+}
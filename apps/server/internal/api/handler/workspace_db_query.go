@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
@@ -16,9 +19,10 @@ import (
 
 // VMDatabaseHandler 工作空间数据库处理器（SQLite via VMStore）
 type VMDatabaseHandler struct {
-	vmStore          *vmruntime.VMStore
-	auditLogService  service.AuditLogService
-	workspaceService service.WorkspaceService
+	vmStore             *vmruntime.VMStore
+	auditLogService     service.AuditLogService
+	workspaceService    service.WorkspaceService
+	queryHistoryService service.QueryHistoryService
 }
 
 // NewVMDatabaseHandler 创建 VMDatabaseHandler
@@ -34,6 +38,11 @@ func NewVMDatabaseHandler(
 	}
 }
 
+// SetQueryHistoryService 设置 SQL 查询历史服务
+func (h *VMDatabaseHandler) SetQueryHistoryService(queryHistoryService service.QueryHistoryService) {
+	h.queryHistoryService = queryHistoryService
+}
+
 func (h *VMDatabaseHandler) ensureAccess(c echo.Context, writeRequired bool) (string, string, error) {
 	userID := middleware.GetUserID(c)
 	uid, err := uuid.Parse(userID)
@@ -182,6 +191,10 @@ type AlterTableRequestBody struct {
 	AlterColumns []AlterColumnDefBody  `json:"alter_columns,omitempty"`
 	DropColumns  []string              `json:"drop_columns,omitempty"`
 	Rename       string                `json:"rename,omitempty"`
+	// DryRun 仅预览生成的 DDL 与数据丢失报告，不修改数据库
+	DryRun bool `json:"dry_run,omitempty"`
+	// AllowDataLoss 允许丢弃违反 NOT NULL 的行、接受有损类型转换
+	AllowDataLoss bool `json:"allow_data_loss,omitempty"`
 }
 
 // AlterColumnDefBody 修改列定义请求体
@@ -231,21 +244,44 @@ func (h *VMDatabaseHandler) AlterTable(c echo.Context) error {
 		}
 	}
 
-	if err := h.vmStore.AlterTable(c.Request().Context(), workspaceID, tableName, vmruntime.VMAlterTableRequest{
-		AddColumns:   addCols,
-		AlterColumns: alterCols,
-		DropColumns:  req.DropColumns,
-		Rename:       req.Rename,
-	}); err != nil {
+	alterReq := vmruntime.VMAlterTableRequest{
+		AddColumns:    addCols,
+		AlterColumns:  alterCols,
+		DropColumns:   req.DropColumns,
+		Rename:        req.Rename,
+		AllowDataLoss: req.AllowDataLoss,
+	}
+
+	if req.DryRun {
+		plan, err := h.vmStore.PreviewAlterTable(c.Request().Context(), workspaceID, tableName, alterReq)
+		if err != nil {
+			return handleDBQueryError(c, err)
+		}
+		return successResponse(c, map[string]interface{}{
+			"plan": plan,
+		})
+	}
+
+	plan, err := h.vmStore.AlterTable(c.Request().Context(), workspaceID, tableName, alterReq)
+	if err != nil {
+		var lossErr *vmruntime.VMDataLossError
+		if errors.As(err, &lossErr) {
+			return errorResponseWithDetails(c, http.StatusConflict, "DATA_LOSS", "修改会丢失数据，请确认后设置 allow_data_loss", map[string]interface{}{
+				"plan": lossErr.Plan,
+			})
+		}
 		return handleDBQueryError(c, err)
 	}
 
 	h.recordAudit(c, workspaceID, userID, "workspace.db.table.alter", "database_table", nil, entity.JSON{
 		"table_name": tableName,
+		"rebuild":    plan.Rebuild,
+		"data_loss":  plan.DataLoss,
 	})
 
 	return successResponse(c, map[string]interface{}{
 		"message": "表结构修改成功",
+		"plan":    plan,
 	})
 }
 
@@ -470,7 +506,9 @@ func (h *VMDatabaseHandler) ExecuteSQL(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "SQL 不能为空")
 	}
 
+	start := time.Now()
 	result, err := h.vmStore.ExecuteSQL(c.Request().Context(), workspaceID, req.SQL, req.Params...)
+	h.recordQueryHistory(c, workspaceID, userID, req.SQL, time.Since(start), result, err)
 	if err != nil {
 		return handleDBQueryError(c, err)
 	}
@@ -487,19 +525,154 @@ func (h *VMDatabaseHandler) ExecuteSQL(c echo.Context) error {
 	})
 }
 
-// GetQueryHistory 查询历史
+// GetQueryHistory 查询历史（支持 search/status/pinned/mine 过滤与分页）
 func (h *VMDatabaseHandler) GetQueryHistory(c echo.Context) error {
-	workspaceID, _, err := h.ensureAccess(c, false)
+	workspaceID, userID, err := h.ensureAccess(c, false)
 	if err != nil {
 		return nil
 	}
+	if h.queryHistoryService == nil {
+		return successResponse(c, map[string]interface{}{
+			"history": []entity.QueryHistory{},
+		})
+	}
 
-	history := h.vmStore.GetQueryHistory(c.Request().Context(), workspaceID)
-	return successResponse(c, map[string]interface{}{
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+	if pageSize > 100 {
+		pageSize = 100
+	}
+	params := service.QueryHistoryListParams{
+		WorkspaceID: uuid.MustParse(workspaceID),
+		Search:      c.QueryParam("search"),
+		Status:      c.QueryParam("status"),
+		Page:        page,
+		PageSize:    pageSize,
+	}
+	if raw := c.QueryParam("pinned"); raw != "" {
+		pinned, parseErr := strconv.ParseBool(raw)
+		if parseErr != nil {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "pinned 参数无效")
+		}
+		params.Pinned = &pinned
+	}
+	if mine, _ := strconv.ParseBool(c.QueryParam("mine")); mine {
+		uid := uuid.MustParse(userID)
+		params.UserID = &uid
+	}
+
+	history, total, err := h.queryHistoryService.List(c.Request().Context(), params)
+	if err != nil {
+		return errorResponse(c, http.StatusInternalServerError, "LIST_FAILED", "获取查询历史失败")
+	}
+	return successResponseWithMeta(c, map[string]interface{}{
 		"history": history,
+	}, map[string]interface{}{
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
 	})
 }
 
+// UpdateQueryHistoryRequestBody 更新查询历史请求体
+type UpdateQueryHistoryRequestBody struct {
+	Pinned *bool   `json:"pinned"`
+	Name   *string `json:"name"`
+}
+
+// UpdateQueryHistory 固定/取消固定查询历史并命名（保存的查询）
+func (h *VMDatabaseHandler) UpdateQueryHistory(c echo.Context) error {
+	workspaceID, _, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+	historyID, err := uuid.Parse(c.Param("historyId"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "查询历史 ID 无效")
+	}
+	if h.queryHistoryService == nil {
+		return errorResponse(c, http.StatusNotFound, "NOT_FOUND", "查询历史不存在")
+	}
+
+	var req UpdateQueryHistoryRequestBody
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	item, err := h.queryHistoryService.Update(c.Request().Context(), uuid.MustParse(workspaceID), historyID, req.Pinned, req.Name)
+	if err != nil {
+		return handleQueryHistoryError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"history": item,
+	})
+}
+
+// DeleteQueryHistory 删除查询历史
+func (h *VMDatabaseHandler) DeleteQueryHistory(c echo.Context) error {
+	workspaceID, _, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+	historyID, err := uuid.Parse(c.Param("historyId"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "查询历史 ID 无效")
+	}
+	if h.queryHistoryService == nil {
+		return errorResponse(c, http.StatusNotFound, "NOT_FOUND", "查询历史不存在")
+	}
+
+	if err := h.queryHistoryService.Delete(c.Request().Context(), uuid.MustParse(workspaceID), historyID); err != nil {
+		return handleQueryHistoryError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"deleted": true,
+	})
+}
+
+// recordQueryHistory 记录 SQL 编辑器执行结果；记录失败不影响本次查询
+func (h *VMDatabaseHandler) recordQueryHistory(c echo.Context, workspaceID, userID, sqlStr string, duration time.Duration, result *vmruntime.VMQueryResult, execErr error) {
+	if h.queryHistoryService == nil {
+		return
+	}
+	wsID, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return
+	}
+
+	item := &entity.QueryHistory{
+		WorkspaceID: wsID,
+		UserID:      uid,
+		SQL:         sqlStr,
+		DurationMs:  duration.Milliseconds(),
+		Status:      entity.QueryStatusSuccess,
+	}
+	if execErr != nil {
+		item.Status = entity.QueryStatusError
+		item.Error = execErr.Error()
+	} else if result != nil {
+		item.RowCount = int64(len(result.Rows))
+		item.AffectedRows = result.AffectedRows
+	}
+	if err := h.queryHistoryService.Record(c.Request().Context(), item); err != nil {
+		log.Printf("[VMDatabase] record query history for %s: %v", workspaceID, err)
+	}
+}
+
+func handleQueryHistoryError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrQueryHistoryNotFound):
+		return errorResponse(c, http.StatusNotFound, "NOT_FOUND", "查询历史不存在")
+	case errors.Is(err, service.ErrQueryHistoryNameTooLong):
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "名称不能超过 200 个字符")
+	default:
+		return errorResponse(c, http.StatusInternalServerError, "UPDATE_FAILED", "更新查询历史失败")
+	}
+}
+
 // GetStats 数据库统计
 func (h *VMDatabaseHandler) GetStats(c echo.Context) error {
 	workspaceID, _, err := h.ensureAccess(c, false)
@@ -568,8 +741,14 @@ func handleDBQueryError(c echo.Context, err error) error {
 	if err != nil {
 		errMsg = err.Error()
 	}
-	if strings.Contains(errMsg, "no such table") {
+	if errors.Is(err, vmruntime.ErrTableExists) {
+		return errorResponse(c, http.StatusConflict, "TABLE_EXISTS", "表已存在")
+	}
+	if errors.Is(err, vmruntime.ErrTableNotFound) || strings.Contains(errMsg, "no such table") {
 		return errorResponse(c, http.StatusNotFound, "TABLE_NOT_FOUND", "表不存在")
 	}
+	if errors.Is(err, vmruntime.ErrDBQuotaExceeded) {
+		return errorResponse(c, http.StatusInsufficientStorage, "DB_QUOTA_EXCEEDED", "数据库已达到容量上限")
+	}
 	return errorResponse(c, http.StatusInternalServerError, "QUERY_FAILED", errMsg)
 }
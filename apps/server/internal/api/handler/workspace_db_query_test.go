@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/service"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// stubQueryHistoryService keeps recorded history in memory.
+type stubQueryHistoryService struct {
+	items      []*entity.QueryHistory
+	lastParams service.QueryHistoryListParams
+}
+
+func (s *stubQueryHistoryService) Record(_ context.Context, item *entity.QueryHistory) error {
+	item.ID = uuid.New()
+	s.items = append(s.items, item)
+	return nil
+}
+
+func (s *stubQueryHistoryService) List(_ context.Context, params service.QueryHistoryListParams) ([]entity.QueryHistory, int64, error) {
+	s.lastParams = params
+	out := make([]entity.QueryHistory, 0, len(s.items))
+	for _, item := range s.items {
+		out = append(out, *item)
+	}
+	return out, int64(len(out)), nil
+}
+
+func (s *stubQueryHistoryService) Update(_ context.Context, workspaceID, id uuid.UUID, pinned *bool, name *string) (*entity.QueryHistory, error) {
+	for _, item := range s.items {
+		if item.ID == id && item.WorkspaceID == workspaceID {
+			if pinned != nil {
+				item.Pinned = *pinned
+			}
+			if name != nil {
+				item.Name = *name
+			}
+			return item, nil
+		}
+	}
+	return nil, service.ErrQueryHistoryNotFound
+}
+
+func (s *stubQueryHistoryService) Delete(_ context.Context, workspaceID, id uuid.UUID) error {
+	return service.ErrQueryHistoryNotFound
+}
+
+func newVMDatabaseTestContext(method, target string, body interface{}, workspaceID, userID uuid.UUID) (echo.Context, *httptest.ResponseRecorder) {
+	var reader *bytes.Reader
+	if body != nil {
+		b, _ := json.Marshal(body)
+		reader = bytes.NewReader(b)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.Set("user_id", userID.String())
+	c.SetParamNames("id")
+	c.SetParamValues(workspaceID.String())
+	return c, rec
+}
+
+func TestVMDatabase_ExecuteSQLRecordsHistory(t *testing.T) {
+	wsID, userID := uuid.New(), uuid.New()
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: t.TempDir()})
+	t.Cleanup(func() { store.Close() })
+	history := &stubQueryHistoryService{}
+	h := NewVMDatabaseHandler(store, nil, nil)
+	h.SetQueryHistoryService(history)
+
+	for _, sql := range []string{
+		"CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)",
+		"INSERT INTO notes (body) VALUES ('a'), ('b')",
+		"SELECT * FROM notes",
+		"SELECT * FROM missing",
+	} {
+		c, _ := newVMDatabaseTestContext(http.MethodPost, "/query", map[string]interface{}{"sql": sql}, wsID, userID)
+		if err := h.ExecuteSQL(c); err != nil {
+			t.Fatalf("ExecuteSQL(%q): %v", sql, err)
+		}
+	}
+
+	if len(history.items) != 4 {
+		t.Fatalf("recorded %d entries, want 4", len(history.items))
+	}
+	insert, selectAll, failed := history.items[1], history.items[2], history.items[3]
+	if insert.AffectedRows != 2 || insert.Status != entity.QueryStatusSuccess {
+		t.Fatalf("insert entry = %+v, want 2 affected rows", insert)
+	}
+	if selectAll.RowCount != 2 || selectAll.UserID != userID || selectAll.WorkspaceID != wsID {
+		t.Fatalf("select entry = %+v, want 2 rows by user", selectAll)
+	}
+	if failed.Status != entity.QueryStatusError || failed.Error == "" {
+		t.Fatalf("failed entry = %+v, want error status and message", failed)
+	}
+
+	// Pin the SELECT as a saved query.
+	c, rec := newVMDatabaseTestContext(http.MethodPatch, "/query/history", map[string]interface{}{"pinned": true, "name": "all notes"}, wsID, userID)
+	c.SetParamNames("id", "historyId")
+	c.SetParamValues(wsID.String(), selectAll.ID.String())
+	if err := h.UpdateQueryHistory(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("UpdateQueryHistory = %v, status %d: %s", err, rec.Code, rec.Body.String())
+	}
+	if !selectAll.Pinned || selectAll.Name != "all notes" {
+		t.Fatalf("entry = %+v, want pinned with name", selectAll)
+	}
+
+	// Another workspace cannot touch the entry.
+	other := uuid.New()
+	c, rec = newVMDatabaseTestContext(http.MethodPatch, "/query/history", map[string]interface{}{"pinned": false}, other, userID)
+	c.SetParamNames("id", "historyId")
+	c.SetParamValues(other.String(), selectAll.ID.String())
+	h.UpdateQueryHistory(c)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("cross-workspace update status = %d, want 404", rec.Code)
+	}
+}
+
+func TestVMDatabase_GetQueryHistoryFilters(t *testing.T) {
+	wsID, userID := uuid.New(), uuid.New()
+	history := &stubQueryHistoryService{}
+	h := NewVMDatabaseHandler(nil, nil, nil)
+	h.SetQueryHistoryService(history)
+
+	c, rec := newVMDatabaseTestContext(http.MethodGet, "/query/history?search=notes&pinned=true&mine=1&page=2&page_size=500", nil, wsID, userID)
+	if err := h.GetQueryHistory(c); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("GetQueryHistory = %v, status %d", err, rec.Code)
+	}
+	p := history.lastParams
+	if p.WorkspaceID != wsID || p.Search != "notes" || p.Page != 2 || p.PageSize != 100 {
+		t.Fatalf("params = %+v", p)
+	}
+	if p.Pinned == nil || !*p.Pinned || p.UserID == nil || *p.UserID != userID {
+		t.Fatalf("params = %+v, want pinned and own user filter", p)
+	}
+
+	c, rec = newVMDatabaseTestContext(http.MethodGet, "/query/history?pinned=maybe", nil, wsID, userID)
+	h.GetQueryHistory(c)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid pinned status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// maxSnapshotLabelLength 快照备注的最大字符数
+const maxSnapshotLabelLength = 200
+
+// ListSnapshots 获取数据库快照列表（按时间倒序）
+func (h *VMDatabaseHandler) ListSnapshots(c echo.Context) error {
+	workspaceID, _, err := h.ensureAccess(c, false)
+	if err != nil {
+		return nil
+	}
+
+	snapshots, err := h.vmStore.ListSnapshots(workspaceID)
+	if err != nil {
+		return handleSnapshotError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"snapshots": snapshots,
+	})
+}
+
+// CreateSnapshotRequestBody 创建快照请求体
+type CreateSnapshotRequestBody struct {
+	Label string `json:"label"`
+}
+
+// CreateSnapshot 手动创建数据库快照
+func (h *VMDatabaseHandler) CreateSnapshot(c echo.Context) error {
+	workspaceID, userID, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+
+	var req CreateSnapshotRequestBody
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	if utf8.RuneCountInString(req.Label) > maxSnapshotLabelLength {
+		return errorResponse(c, http.StatusBadRequest, "LABEL_TOO_LONG", fmt.Sprintf("快照备注不能超过 %d 个字符", maxSnapshotLabelLength))
+	}
+
+	snapshot, err := h.vmStore.CreateSnapshot(c.Request().Context(), workspaceID, vmruntime.SnapshotReasonManual, req.Label)
+	if err != nil {
+		return handleSnapshotError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, userID, "workspace.db.snapshot.create", "database_snapshot", nil, entity.JSON{
+		"snapshot_id": snapshot.ID,
+		"label":       snapshot.Label,
+	})
+
+	return successResponse(c, map[string]interface{}{
+		"snapshot": snapshot,
+	})
+}
+
+// RestoreSnapshot 将数据库恢复到指定快照（恢复前自动创建 pre_restore 快照）
+func (h *VMDatabaseHandler) RestoreSnapshot(c echo.Context) error {
+	workspaceID, userID, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+
+	snapshot, err := h.vmStore.RestoreSnapshot(c.Request().Context(), workspaceID, c.Param("snapshotId"))
+	if err != nil {
+		return handleSnapshotError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, userID, "workspace.db.snapshot.restore", "database_snapshot", nil, entity.JSON{
+		"snapshot_id": snapshot.ID,
+		"reason":      snapshot.Reason,
+	})
+
+	return successResponse(c, map[string]interface{}{
+		"message":  "数据库已恢复",
+		"snapshot": snapshot,
+	})
+}
+
+// DownloadSnapshot 下载快照的 SQLite 文件
+func (h *VMDatabaseHandler) DownloadSnapshot(c echo.Context) error {
+	// 快照包含全部数据，仅 workspace 成员可下载
+	workspaceID, userID, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+
+	snapshotID := c.Param("snapshotId")
+	path, err := h.vmStore.SnapshotFile(workspaceID, snapshotID)
+	if err != nil {
+		return handleSnapshotError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, userID, "workspace.db.snapshot.download", "database_snapshot", nil, entity.JSON{
+		"snapshot_id": snapshotID,
+	})
+
+	return c.Attachment(path, fmt.Sprintf("%s-%s.db", workspaceID, snapshotID))
+}
+
+// DeleteSnapshot 删除快照
+func (h *VMDatabaseHandler) DeleteSnapshot(c echo.Context) error {
+	workspaceID, userID, err := h.ensureAccess(c, true)
+	if err != nil {
+		return nil
+	}
+
+	snapshotID := c.Param("snapshotId")
+	if err := h.vmStore.DeleteSnapshot(workspaceID, snapshotID); err != nil {
+		return handleSnapshotError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, userID, "workspace.db.snapshot.delete", "database_snapshot", nil, entity.JSON{
+		"snapshot_id": snapshotID,
+	})
+
+	return successResponse(c, map[string]interface{}{
+		"message": "快照已删除",
+	})
+}
+
+func handleSnapshotError(c echo.Context, err error) error {
+	if errors.Is(err, vmruntime.ErrSnapshotNotFound) {
+		return errorResponse(c, http.StatusNotFound, "SNAPSHOT_NOT_FOUND", "快照不存在")
+	}
+	return handleDBQueryError(c, err)
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/api/middleware"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+// egressSettingsKey Workspace.Settings 中保存出站白名单的键
+const egressSettingsKey = "egress_allowlist"
+
+// maxEgressRules 单个 Workspace 的出站白名单规则数量上限
+const maxEgressRules = 100
+
+// getEgressRules 从 Workspace 设置中读取出站白名单，跳过格式无效的规则
+func getEgressRules(settings entity.JSON) []vmruntime.VMEgressRule {
+	raw, ok := settings[egressSettingsKey].([]interface{})
+	if !ok {
+		return []vmruntime.VMEgressRule{}
+	}
+	rules := make([]vmruntime.VMEgressRule, 0, len(raw))
+	for _, item := range raw {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rule := vmruntime.VMEgressRule{Host: fmt.Sprint(m["host"])}
+		if methods, ok := m["methods"].([]interface{}); ok {
+			for _, method := range methods {
+				rule.Methods = append(rule.Methods, fmt.Sprint(method))
+			}
+		}
+		if normalized, err := rule.Normalize(); err == nil {
+			rules = append(rules, normalized)
+		}
+	}
+	return rules
+}
+
+// GetEgressAllowlist 获取 JS 逻辑 fetch 的出站白名单
+func (h *WorkspaceHandler) GetEgressAllowlist(c echo.Context) error {
+	uid, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "用户 ID 无效")
+	}
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "工作空间 ID 无效")
+	}
+	workspace, err := h.workspaceService.GetByID(c.Request().Context(), workspaceID, uid)
+	if err != nil {
+		return h.handleWorkspaceError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"rules": getEgressRules(workspace.Settings),
+	})
+}
+
+// UpdateEgressAllowlist 整体替换出站白名单（host 支持 *.example.com 通配子域名，methods 为空表示允许全部方法）
+func (h *WorkspaceHandler) UpdateEgressAllowlist(c echo.Context) error {
+	uid, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "用户 ID 无效")
+	}
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "工作空间 ID 无效")
+	}
+
+	var req struct {
+		Rules []vmruntime.VMEgressRule `json:"rules"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	if len(req.Rules) > maxEgressRules {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", fmt.Sprintf("白名单规则最多 %d 条", maxEgressRules))
+	}
+	rules := make([]interface{}, 0, len(req.Rules))
+	for _, rule := range req.Rules {
+		normalized, err := rule.Normalize()
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", "白名单规则无效: "+err.Error())
+		}
+		methods := make([]interface{}, len(normalized.Methods))
+		for i, m := range normalized.Methods {
+			methods[i] = m
+		}
+		rules = append(rules, map[string]interface{}{"host": normalized.Host, "methods": methods})
+	}
+
+	workspace, err := h.workspaceService.GetByID(c.Request().Context(), workspaceID, uid)
+	if err != nil {
+		return h.handleWorkspaceError(c, err)
+	}
+	settings := entity.JSON{}
+	for k, v := range workspace.Settings {
+		settings[k] = v
+	}
+	settings[egressSettingsKey] = rules
+	if err := h.workspaceService.UpdateSettings(c.Request().Context(), workspace.ID, uid, settings); err != nil {
+		return h.handleWorkspaceError(c, err)
+	}
+
+	h.recordAudit(c, workspace.ID, uid, "workspace.egress.update", "workspace", &workspace.ID, entity.JSON{"rules": len(rules)})
+
+	return successResponse(c, map[string]interface{}{
+		"rules": getEgressRules(settings),
+	})
+}
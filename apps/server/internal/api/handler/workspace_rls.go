@@ -1,25 +1,40 @@
 package handler
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/reverseai/server/internal/api/middleware"
+	"github.com/reverseai/server/internal/domain/entity"
 	"github.com/reverseai/server/internal/service"
+	"github.com/reverseai/server/internal/vmruntime"
 )
 
 // WorkspaceRLSHandler RLS 策略 Handler
 type WorkspaceRLSHandler struct {
-	rlsService       service.WorkspaceRLSService
-	workspaceService service.WorkspaceService
+	rlsService         service.WorkspaceRLSService
+	workspaceService   service.WorkspaceService
+	runtimeAuthService service.RuntimeAuthService
+	vmStore            *vmruntime.VMStore
 }
 
 func NewWorkspaceRLSHandler(rlsService service.WorkspaceRLSService, workspaceService service.WorkspaceService) *WorkspaceRLSHandler {
 	return &WorkspaceRLSHandler{rlsService: rlsService, workspaceService: workspaceService}
 }
 
+// SetRuntimeAuthService sets the runtime auth service used to load app users for policy tests
+func (h *WorkspaceRLSHandler) SetRuntimeAuthService(authService service.RuntimeAuthService) {
+	h.runtimeAuthService = authService
+}
+
+// SetVMStore sets the VM store used to run policy tests against workspace data
+func (h *WorkspaceRLSHandler) SetVMStore(store *vmruntime.VMStore) {
+	h.vmStore = store
+}
+
 // requireMemberAccess 验证用户是工作空间成员或 owner（访客无写入权限）
 func (h *WorkspaceRLSHandler) requireMemberAccess(c echo.Context, workspaceID uuid.UUID) error {
 	if h.workspaceService == nil {
@@ -47,6 +62,7 @@ type createRLSPolicyRequest struct {
 	Column      string `json:"column"`
 	MatchField  string `json:"match_field"`
 	Operation   string `json:"operation"`
+	Expression  string `json:"expression"`
 	Description string `json:"description"`
 }
 
@@ -64,12 +80,15 @@ func (h *WorkspaceRLSHandler) CreatePolicy(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_BODY", "Invalid request body")
 	}
-	if req.TableName == "" || req.Column == "" {
-		return errorResponse(c, http.StatusBadRequest, "MISSING_FIELDS", "table_name and column are required")
+	if req.TableName == "" || (req.Column == "" && req.Expression == "") {
+		return errorResponse(c, http.StatusBadRequest, "MISSING_FIELDS", "table_name and column or expression are required")
 	}
 
-	policy, err := h.rlsService.CreatePolicy(c.Request().Context(), workspaceID, req.TableName, req.Column, req.MatchField, req.Operation, req.Description)
+	policy, err := h.rlsService.CreatePolicy(c.Request().Context(), workspaceID, req.TableName, req.Column, req.MatchField, req.Operation, req.Expression, req.Description)
 	if err != nil {
+		if isRLSValidationError(err) {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_POLICY", err.Error())
+		}
 		return errorResponse(c, http.StatusInternalServerError, "CREATE_FAILED", err.Error())
 	}
 
@@ -104,6 +123,7 @@ type updateRLSPolicyRequest struct {
 	Column      *string `json:"column,omitempty"`
 	MatchField  *string `json:"match_field,omitempty"`
 	Operation   *string `json:"operation,omitempty"`
+	Expression  *string `json:"expression,omitempty"`
 	Description *string `json:"description,omitempty"`
 }
 
@@ -127,8 +147,11 @@ func (h *WorkspaceRLSHandler) UpdatePolicy(c echo.Context) error {
 		return errorResponse(c, http.StatusBadRequest, "INVALID_BODY", "Invalid request body")
 	}
 
-	policy, err := h.rlsService.UpdatePolicy(c.Request().Context(), workspaceID, policyID, req.Enabled, req.Column, req.MatchField, req.Operation, req.Description)
+	policy, err := h.rlsService.UpdatePolicy(c.Request().Context(), workspaceID, policyID, req.Enabled, req.Column, req.MatchField, req.Operation, req.Expression, req.Description)
 	if err != nil {
+		if isRLSValidationError(err) {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_POLICY", err.Error())
+		}
 		return errorResponse(c, http.StatusInternalServerError, "UPDATE_FAILED", err.Error())
 	}
 
@@ -163,3 +186,177 @@ func (h *WorkspaceRLSHandler) DeletePolicy(c echo.Context) error {
 		"message": "deleted",
 	})
 }
+
+type testRLSPolicyDraft struct {
+	Column     string `json:"column"`
+	MatchField string `json:"match_field"`
+	Operation  string `json:"operation"`
+	Expression string `json:"expression"`
+}
+
+type testRLSPolicyRequest struct {
+	TableName string `json:"table_name"`
+	Operation string `json:"operation"`
+	AppUserID string `json:"app_user_id"`
+	// User 模拟的用户属性；与 app_user_id 同时提供时覆盖对应字段
+	User *struct {
+		ID          string `json:"id"`
+		Email       string `json:"email"`
+		Role        string `json:"role"`
+		DisplayName string `json:"display_name"`
+	} `json:"user"`
+	// Policies 草稿策略；为空时使用该表已启用的策略
+	Policies []testRLSPolicyDraft `json:"policies"`
+	Page     int                  `json:"page"`
+	PageSize int                  `json:"page_size"`
+}
+
+// TestPolicies 以指定 app user 的身份试运行 RLS 策略，返回其可见的行
+func (h *WorkspaceRLSHandler) TestPolicies(c echo.Context) error {
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "Invalid workspace ID")
+	}
+	if err := h.requireMemberAccess(c, workspaceID); err != nil {
+		return nil
+	}
+	if h.vmStore == nil {
+		return errorResponse(c, http.StatusServiceUnavailable, "VM_STORE_UNAVAILABLE", "Workspace database is not available")
+	}
+
+	var req testRLSPolicyRequest
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_BODY", "Invalid request body")
+	}
+	if req.TableName == "" {
+		return errorResponse(c, http.StatusBadRequest, "MISSING_FIELDS", "table_name is required")
+	}
+	operation := req.Operation
+	if operation == "" {
+		operation = entity.RLSOperationSelect
+	}
+	if !entity.IsValidRLSOperation(operation) || operation == entity.RLSOperationAll {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_POLICY", service.ErrInvalidRLSOperation.Error())
+	}
+
+	user, err := h.resolveTestUser(c, workspaceID, req)
+	if err != nil {
+		return nil
+	}
+
+	var policies []entity.RLSPolicy
+	if len(req.Policies) > 0 {
+		for _, draft := range req.Policies {
+			policies = append(policies, entity.RLSPolicy{
+				WorkspaceID: workspaceID,
+				TblName:     req.TableName,
+				Column:      draft.Column,
+				MatchField:  draft.MatchField,
+				Operation:   draft.Operation,
+				Expression:  draft.Expression,
+				Enabled:     true,
+			})
+		}
+	} else {
+		policies, err = h.rlsService.GetActivePoliciesForTable(c.Request().Context(), workspaceID, req.TableName)
+		if err != nil {
+			return errorResponse(c, http.StatusInternalServerError, "LIST_FAILED", err.Error())
+		}
+	}
+
+	predicate, err := service.CompileRLSPolicies(policies, operation, user)
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_POLICY", err.Error())
+	}
+
+	params := vmruntime.VMQueryParams{Page: req.Page, PageSize: req.PageSize}
+	if params.PageSize <= 0 || params.PageSize > 200 {
+		params.PageSize = 50
+	}
+	clause := ""
+	args := []interface{}{}
+	if predicate != nil {
+		clause, args = predicate.Clause, predicate.Args
+		params.Scope = &vmruntime.VMRowScope{Clause: clause, Args: args}
+	}
+	result, err := h.vmStore.QueryRows(c.Request().Context(), workspaceID.String(), req.TableName, params)
+	if err != nil {
+		return handleDBQueryError(c, err)
+	}
+
+	applied := make([]entity.RLSPolicy, 0, len(policies))
+	for _, policy := range policies {
+		if policy.Enabled && policy.AppliesTo(operation) {
+			applied = append(applied, policy)
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"code":    "OK",
+		"message": "ok",
+		"data": map[string]interface{}{
+			"table_name": req.TableName,
+			"operation":  operation,
+			"user":       user,
+			"policies":   applied,
+			"clause":     clause,
+			"args":       args,
+			"columns":    result.Columns,
+			"rows":       result.Rows,
+			"total":      result.TotalCount,
+		},
+	})
+}
+
+// resolveTestUser 加载 app_user_id 对应的用户，并叠加请求中的模拟属性
+func (h *WorkspaceRLSHandler) resolveTestUser(c echo.Context, workspaceID uuid.UUID, req testRLSPolicyRequest) (*entity.AppUser, error) {
+	user := &entity.AppUser{WorkspaceID: workspaceID, Role: "user", Status: "active"}
+	if req.AppUserID != "" {
+		userID, err := uuid.Parse(req.AppUserID)
+		if err != nil {
+			_ = errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "Invalid app_user_id")
+			return nil, err
+		}
+		if h.runtimeAuthService == nil {
+			_ = errorResponse(c, http.StatusServiceUnavailable, "AUTH_UNAVAILABLE", "App user lookup is not available")
+			return nil, fmt.Errorf("runtime auth service not configured")
+		}
+		loaded, err := h.runtimeAuthService.GetUser(c.Request().Context(), workspaceID, userID)
+		if err != nil {
+			_ = errorResponse(c, http.StatusNotFound, "USER_NOT_FOUND", "App user not found")
+			return nil, err
+		}
+		user = loaded
+	}
+	if req.User != nil {
+		if req.User.ID != "" {
+			userID, err := uuid.Parse(req.User.ID)
+			if err != nil {
+				_ = errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "Invalid user.id")
+				return nil, err
+			}
+			user.ID = userID
+		}
+		if req.User.Email != "" {
+			user.Email = req.User.Email
+		}
+		if req.User.Role != "" {
+			user.Role = req.User.Role
+		}
+		if req.User.DisplayName != "" {
+			user.DisplayName = &req.User.DisplayName
+		}
+	}
+	if req.AppUserID == "" && req.User == nil {
+		_ = errorResponse(c, http.StatusBadRequest, "MISSING_FIELDS", "app_user_id or user is required")
+		return nil, fmt.Errorf("missing user")
+	}
+	return user, nil
+}
+
+// isRLSValidationError 判断是否为策略校验错误（返回 400）
+func isRLSValidationError(err error) bool {
+	return errors.Is(err, service.ErrInvalidRLSOperation) ||
+		errors.Is(err, service.ErrInvalidRLSMatchField) ||
+		errors.Is(err, service.ErrInvalidRLSExpression)
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/vmruntime"
+)
+
+func newWorkspaceRLSTestContext(body interface{}, workspaceID uuid.UUID) (echo.Context, *httptest.ResponseRecorder) {
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/workspaces/"+workspaceID.String()+"/database/rls-policies/test", bytes.NewReader(b))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(workspaceID.String())
+	return c, rec
+}
+
+func TestWorkspaceRLS_TestPoliciesDryRun(t *testing.T) {
+	ctx := context.Background()
+	wsID := uuid.New()
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: t.TempDir()})
+	t.Cleanup(func() { store.Close() })
+
+	store.CreateTable(ctx, wsID.String(), vmruntime.VMCreateTableRequest{
+		Name: "tasks",
+		Columns: []vmruntime.VMCreateColumnDef{
+			{Name: "id", Type: "INTEGER"},
+			{Name: "team_id", Type: "INTEGER"},
+		},
+		PrimaryKey: []string{"id"},
+	})
+	store.CreateTable(ctx, wsID.String(), vmruntime.VMCreateTableRequest{
+		Name: "team_members",
+		Columns: []vmruntime.VMCreateColumnDef{
+			{Name: "team_id", Type: "INTEGER"},
+			{Name: "member_id", Type: "TEXT"},
+		},
+	})
+	manager := &entity.AppUser{ID: uuid.New(), WorkspaceID: wsID, Role: "manager"}
+	for i, team := range []int{1, 1, 2, 3} {
+		store.InsertRow(ctx, wsID.String(), "tasks", map[string]interface{}{"id": i + 1, "team_id": team})
+	}
+	store.InsertRow(ctx, wsID.String(), "team_members", map[string]interface{}{"team_id": 1, "member_id": manager.ID.String()})
+
+	h := NewWorkspaceRLSHandler(&stubRLSService{}, nil)
+	h.SetVMStore(store)
+	h.SetRuntimeAuthService(&stubRuntimeAuthService{})
+
+	c, rec := newWorkspaceRLSTestContext(map[string]interface{}{
+		"table_name": "tasks",
+		"user":       map[string]interface{}{"id": manager.ID.String(), "role": "manager"},
+		"policies": []map[string]interface{}{{
+			"expression": "has_role('admin') OR team_id IN (SELECT team_id FROM team_members WHERE member_id = user.id)",
+		}},
+	}, wsID)
+	if err := h.TestPolicies(c); err != nil {
+		t.Fatalf("TestPolicies: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	data := parseJSON(t, rec)["data"].(map[string]interface{})
+	if data["total"].(float64) != 2 {
+		t.Fatalf("total = %v, want 2 (team 1 only)", data["total"])
+	}
+	if data["clause"] == "" {
+		t.Fatal("expected compiled clause in response")
+	}
+
+	// Invalid draft expressions are reported as 400.
+	c, rec = newWorkspaceRLSTestContext(map[string]interface{}{
+		"table_name": "tasks",
+		"user":       map[string]interface{}{"id": manager.ID.String()},
+		"policies":   []map[string]interface{}{{"expression": "team_id = 1; DROP TABLE tasks"}},
+	}, wsID)
+	h.TestPolicies(c)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid expression status = %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/api/middleware"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/service"
+)
+
+// WorkspaceScheduleHandler 工作空间定时任务 Handler
+// 定时任务本身由 JS 逻辑的 exports.schedules 声明，这里只管理时区、启停、手动触发与执行记录
+type WorkspaceScheduleHandler struct {
+	scheduleService service.WorkspaceScheduleService
+	auditLogService service.AuditLogService
+}
+
+func NewWorkspaceScheduleHandler(scheduleService service.WorkspaceScheduleService, auditLogService service.AuditLogService) *WorkspaceScheduleHandler {
+	return &WorkspaceScheduleHandler{scheduleService: scheduleService, auditLogService: auditLogService}
+}
+
+type updateScheduleRequest struct {
+	Enabled *bool `json:"enabled"`
+}
+
+type updateScheduleTimezoneRequest struct {
+	Timezone string `json:"timezone"`
+}
+
+// parseIDs 解析路径中的工作空间 ID 与当前用户，失败时已写入错误响应
+func (h *WorkspaceScheduleHandler) parseIDs(c echo.Context) (uuid.UUID, uuid.UUID, bool) {
+	uid, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		_ = errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "用户 ID 无效")
+		return uuid.Nil, uuid.Nil, false
+	}
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = errorResponse(c, http.StatusBadRequest, "INVALID_ID", "工作空间 ID 无效")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return workspaceID, uid, true
+}
+
+// ListSchedules 列出定时任务、时区与下一次触发时间
+func (h *WorkspaceScheduleHandler) ListSchedules(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	list, err := h.scheduleService.List(c.Request().Context(), workspaceID, uid)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return successResponse(c, list)
+}
+
+// UpdateSchedule 启用 / 暂停定时任务
+func (h *WorkspaceScheduleHandler) UpdateSchedule(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	scheduleID, err := uuid.Parse(c.Param("scheduleId"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "定时任务 ID 无效")
+	}
+	var req updateScheduleRequest
+	if err := c.Bind(&req); err != nil || req.Enabled == nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	schedule, err := h.scheduleService.SetEnabled(c.Request().Context(), workspaceID, uid, scheduleID, *req.Enabled)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, uid, "workspace.schedule.update", &schedule.ID, entity.JSON{
+		"cron":    schedule.Cron,
+		"enabled": schedule.Enabled,
+	})
+
+	return successResponse(c, schedule)
+}
+
+// UpdateTimezone 设置定时任务使用的时区（IANA 名称）
+func (h *WorkspaceScheduleHandler) UpdateTimezone(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	var req updateScheduleTimezoneRequest
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	timezone, err := h.scheduleService.UpdateTimezone(c.Request().Context(), workspaceID, uid, req.Timezone)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, uid, "workspace.schedule.timezone", nil, entity.JSON{"timezone": timezone})
+
+	return successResponse(c, map[string]interface{}{
+		"timezone": timezone,
+	})
+}
+
+// RunSchedule 手动触发一次执行（异步），执行结果见执行记录
+func (h *WorkspaceScheduleHandler) RunSchedule(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	scheduleID, err := uuid.Parse(c.Param("scheduleId"))
+	if err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "定时任务 ID 无效")
+	}
+	result, err := h.scheduleService.RunNow(c.Request().Context(), workspaceID, uid, scheduleID)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, uid, "workspace.schedule.run", &scheduleID, entity.JSON{"task_id": result.TaskID})
+
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"code":    "OK",
+		"message": "accepted",
+		"data":    result,
+	})
+}
+
+// ListRuns 列出执行记录，可按 schedule_id 过滤
+func (h *WorkspaceScheduleHandler) ListRuns(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	var scheduleID *uuid.UUID
+	if raw := c.QueryParam("schedule_id"); raw != "" {
+		parsed, err := uuid.Parse(raw)
+		if err != nil {
+			return errorResponse(c, http.StatusBadRequest, "INVALID_ID", "定时任务 ID 无效")
+		}
+		scheduleID = &parsed
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	runs, err := h.scheduleService.ListRuns(c.Request().Context(), workspaceID, uid, scheduleID, limit)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"runs": runs,
+	})
+}
+
+func (h *WorkspaceScheduleHandler) handleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrWorkspaceNotFound):
+		return errorResponse(c, http.StatusNotFound, "NOT_FOUND", "工作空间不存在")
+	case errors.Is(err, service.ErrWorkspaceUnauthorized):
+		return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "无权限管理定时任务")
+	case errors.Is(err, service.ErrScheduleNotFound):
+		return errorResponse(c, http.StatusNotFound, "SCHEDULE_NOT_FOUND", "定时任务不存在")
+	case errors.Is(err, service.ErrInvalidTimezone):
+		return errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+	case errors.Is(err, service.ErrScheduleQueueUnavailable):
+		return errorResponse(c, http.StatusServiceUnavailable, "QUEUE_UNAVAILABLE", "任务队列不可用")
+	default:
+		return errorResponse(c, http.StatusInternalServerError, "INTERNAL_ERROR", "定时任务操作失败")
+	}
+}
+
+// recordAudit 记录定时任务审计日志
+func (h *WorkspaceScheduleHandler) recordAudit(c echo.Context, workspaceID, actorID uuid.UUID, action string, targetID *uuid.UUID, metadata entity.JSON) {
+	if h.auditLogService == nil {
+		return
+	}
+	_, _ = h.auditLogService.Record(c.Request().Context(), service.AuditLogRecordRequest{
+		WorkspaceID: workspaceID,
+		ActorUserID: &actorID,
+		Action:      action,
+		TargetType:  "schedule",
+		TargetID:    targetID,
+		Metadata:    buildAuditMetadata(c, metadata),
+	})
+}
@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/reverseai/server/internal/api/middleware"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/service"
+)
+
+// WorkspaceSecretHandler 工作空间密钥 Handler
+// 明文只在创建 / 轮换请求中提交，任何响应都只包含元数据
+type WorkspaceSecretHandler struct {
+	secretService   service.WorkspaceSecretService
+	auditLogService service.AuditLogService
+}
+
+func NewWorkspaceSecretHandler(secretService service.WorkspaceSecretService, auditLogService service.AuditLogService) *WorkspaceSecretHandler {
+	return &WorkspaceSecretHandler{secretService: secretService, auditLogService: auditLogService}
+}
+
+type createSecretRequest struct {
+	Name        string `json:"name"`
+	Value       string `json:"value"`
+	Description string `json:"description"`
+}
+
+type rotateSecretRequest struct {
+	Value       string  `json:"value"`
+	Description *string `json:"description"`
+}
+
+// parseIDs 解析路径中的工作空间 ID 与当前用户，失败时已写入错误响应
+func (h *WorkspaceSecretHandler) parseIDs(c echo.Context) (uuid.UUID, uuid.UUID, bool) {
+	uid, err := uuid.Parse(middleware.GetUserID(c))
+	if err != nil {
+		_ = errorResponse(c, http.StatusBadRequest, "INVALID_USER_ID", "用户 ID 无效")
+		return uuid.Nil, uuid.Nil, false
+	}
+	workspaceID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		_ = errorResponse(c, http.StatusBadRequest, "INVALID_ID", "工作空间 ID 无效")
+		return uuid.Nil, uuid.Nil, false
+	}
+	return workspaceID, uid, true
+}
+
+// ListSecrets 列出工作空间密钥（不含明文）
+func (h *WorkspaceSecretHandler) ListSecrets(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	secrets, err := h.secretService.List(c.Request().Context(), workspaceID, uid)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+	return successResponse(c, map[string]interface{}{
+		"secrets": secrets,
+	})
+}
+
+// CreateSecret 创建密钥
+func (h *WorkspaceSecretHandler) CreateSecret(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	var req createSecretRequest
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	secret, err := h.secretService.Create(c.Request().Context(), workspaceID, uid, req.Name, req.Value, req.Description)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, uid, "workspace.secret.create", secret, nil)
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"code":    "OK",
+		"message": "created",
+		"data":    secret,
+	})
+}
+
+// RotateSecret 轮换密钥明文（版本号 +1）
+func (h *WorkspaceSecretHandler) RotateSecret(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	var req rotateSecretRequest
+	if err := c.Bind(&req); err != nil {
+		return errorResponse(c, http.StatusBadRequest, "INVALID_REQUEST", "请求参数无效")
+	}
+	secret, err := h.secretService.Rotate(c.Request().Context(), workspaceID, uid, c.Param("name"), req.Value, req.Description)
+	if err != nil {
+		return h.handleError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, uid, "workspace.secret.rotate", secret, entity.JSON{"version": secret.Version})
+
+	return successResponse(c, secret)
+}
+
+// DeleteSecret 删除密钥
+func (h *WorkspaceSecretHandler) DeleteSecret(c echo.Context) error {
+	workspaceID, uid, ok := h.parseIDs(c)
+	if !ok {
+		return nil
+	}
+	name := c.Param("name")
+	if err := h.secretService.Delete(c.Request().Context(), workspaceID, uid, name); err != nil {
+		return h.handleError(c, err)
+	}
+
+	h.recordAudit(c, workspaceID, uid, "workspace.secret.delete", &entity.Secret{Name: name}, nil)
+
+	return successResponse(c, map[string]interface{}{
+		"deleted": name,
+	})
+}
+
+func (h *WorkspaceSecretHandler) handleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, service.ErrWorkspaceNotFound):
+		return errorResponse(c, http.StatusNotFound, "NOT_FOUND", "工作空间不存在")
+	case errors.Is(err, service.ErrWorkspaceUnauthorized):
+		return errorResponse(c, http.StatusForbidden, "FORBIDDEN", "无权限管理工作空间密钥")
+	case errors.Is(err, service.ErrSecretNotFound):
+		return errorResponse(c, http.StatusNotFound, "SECRET_NOT_FOUND", "密钥不存在")
+	case errors.Is(err, service.ErrSecretExists):
+		return errorResponse(c, http.StatusConflict, "SECRET_EXISTS", "同名密钥已存在")
+	case errors.Is(err, service.ErrInvalidSecretName), errors.Is(err, service.ErrInvalidSecretValue), errors.Is(err, service.ErrSecretLimit):
+		return errorResponse(c, http.StatusBadRequest, "INVALID_INPUT", err.Error())
+	default:
+		return errorResponse(c, http.StatusInternalServerError, "INTERNAL_ERROR", "密钥操作失败")
+	}
+}
+
+// recordAudit 记录密钥审计日志（只记录名称，不记录明文）
+func (h *WorkspaceSecretHandler) recordAudit(c echo.Context, workspaceID, actorID uuid.UUID, action string, secret *entity.Secret, metadata entity.JSON) {
+	if h.auditLogService == nil {
+		return
+	}
+	if metadata == nil {
+		metadata = entity.JSON{}
+	}
+	metadata["name"] = secret.Name
+	var targetID *uuid.UUID
+	if secret.ID != uuid.Nil {
+		targetID = &secret.ID
+	}
+	_, _ = h.auditLogService.Record(c.Request().Context(), service.AuditLogRecordRequest{
+		WorkspaceID: workspaceID,
+		ActorUserID: &actorID,
+		Action:      action,
+		TargetType:  "secret",
+		TargetID:    targetID,
+		Metadata:    buildAuditMetadata(c, metadata),
+	})
+}
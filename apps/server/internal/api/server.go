@@ -3,13 +3,17 @@ package api
 import (
 	"context"
 	"fmt"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	echoMiddleware "github.com/labstack/echo/v4/middleware"
 	"github.com/reverseai/server/internal/api/handler"
 	"github.com/reverseai/server/internal/api/middleware"
 	"github.com/reverseai/server/internal/config"
+	"github.com/reverseai/server/internal/domain/entity"
 	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/mail"
 	"github.com/reverseai/server/internal/pkg/queue"
 	"github.com/reverseai/server/internal/pkg/redis"
 	"github.com/reverseai/server/internal/pkg/websocket"
@@ -23,13 +27,16 @@ import (
 
 // Server API 服务器
 type Server struct {
-	echo      *echo.Echo
-	config    *config.Config
-	db        *gorm.DB
-	redis     *redis.Client
-	log       logger.Logger
-	wsHub     *websocket.Hub
-	taskQueue *queue.Queue
+	echo            *echo.Echo
+	config          *config.Config
+	db              *gorm.DB
+	redis           *redis.Client
+	log             logger.Logger
+	wsHub           *websocket.Hub
+	taskQueue       *queue.Queue
+	invalidationBus service.InvalidationBus
+	scheduleService service.WorkspaceScheduleService
+	taskWorker      *queue.Worker
 }
 
 // NewServer 创建新的 API 服务器
@@ -131,10 +138,85 @@ func (s *Server) setupRoutes() {
 		s.log.Error("Failed to initialize API key service", "error", err)
 		apiKeyService, _ = service.NewAPIKeyService(apiKeyRepo, workspaceService, "change-this-to-a-32-byte-secret!")
 	}
+	workspaceSecretService, err := service.NewWorkspaceSecretService(repository.NewSecretRepository(s.db), workspaceService, s.config.Encryption.Key)
+	if err != nil {
+		s.log.Error("Failed to initialize workspace secret service", "error", err)
+		workspaceSecretService, _ = service.NewWorkspaceSecretService(repository.NewSecretRepository(s.db), workspaceService, "change-this-to-a-32-byte-secret!")
+	}
 	// VM Runtime 初始化（SQLite store for workspace databases）
-	vmStore := vmruntime.NewVMStore(s.config.VMRuntime.BaseDir)
+	vmStore := vmruntime.NewVMStore(vmruntime.StoreOptionsFromConfig(s.config.VMRuntime))
 	vmCodeLoader := vmruntime.NewGORMCodeLoader(s.db)
-	vmPool := vmruntime.NewVMPool(vmStore, vmCodeLoader, s.config.VMRuntime.MaxVMs)
+	vmPool := vmruntime.NewVMPool(vmStore, vmCodeLoader, vmruntime.PoolOptionsFromConfig(s.config.VMRuntime))
+	vmStore.SetSnapshotListener(func(kind string, snap vmruntime.VMSnapshot) {
+		eventType := entity.EventDBBackupCreated
+		if kind == vmruntime.SnapshotEventRestored {
+			eventType = entity.EventDBRestored
+		}
+		wsID, err := uuid.Parse(snap.WorkspaceID)
+		if err != nil {
+			return
+		}
+		event := entity.NewRuntimeEvent(eventType).
+			WithWorkspace(wsID).
+			WithMessage(fmt.Sprintf("snapshot %s (%s)", snap.ID, snap.Reason)).
+			WithMetadata("snapshot_id", snap.ID).
+			WithMetadata("reason", snap.Reason).
+			WithMetadata("size_bytes", snap.SizeBytes).
+			Build()
+		if err := eventRecorder.Record(context.Background(), event); err != nil {
+			s.log.Warn("Failed to record snapshot event", "workspace_id", snap.WorkspaceID, "error", err)
+		}
+		// 数据库被整体替换：丢弃各实例缓存的 VM 与 Runtime 缓存
+		if kind == vmruntime.SnapshotEventRestored && s.invalidationBus != nil {
+			if err := s.invalidationBus.Publish(context.Background(), service.InvalidationEvent{
+				Kind:        service.InvalidationDatabase,
+				WorkspaceID: snap.WorkspaceID,
+			}); err != nil {
+				s.log.Warn("Failed to publish database restore invalidation", "workspace_id", snap.WorkspaceID, "error", err)
+			}
+		}
+	})
+	vmStore.SetMigrationListener(func(ev vmruntime.VMMigrationEvent) {
+		eventType := entity.EventDBMigrationStarted
+		switch ev.Kind {
+		case vmruntime.MigrationEventCompleted:
+			eventType = entity.EventDBMigrationCompleted
+		case vmruntime.MigrationEventFailed:
+			eventType = entity.EventDBMigrationFailed
+		case vmruntime.MigrationEventRestored:
+			eventType = entity.EventDBMigrationRestored
+		}
+		wsID, err := uuid.Parse(ev.WorkspaceID)
+		if err != nil {
+			return
+		}
+		message := fmt.Sprintf("migration %d %s (%s)", ev.Migration.Version, ev.Direction, ev.Migration.Name)
+		if ev.Kind == vmruntime.MigrationEventRestored {
+			message = fmt.Sprintf("migrations restored to version %d", ev.Migration.Version)
+		}
+		builder := entity.NewRuntimeEvent(eventType).
+			WithWorkspace(wsID).
+			WithMessage(message).
+			WithMetadata("version", ev.Migration.Version).
+			WithMetadata("name", ev.Migration.Name).
+			WithMetadata("direction", ev.Direction).
+			WithMetadata("source", ev.Migration.Source)
+		if ev.Err != nil {
+			builder = builder.WithError("MIGRATION_FAILED", ev.Err.Error(), "")
+		}
+		if err := eventRecorder.Record(context.Background(), builder.Build()); err != nil {
+			s.log.Warn("Failed to record migration event", "workspace_id", ev.WorkspaceID, "error", err)
+		}
+	})
+	workspaceService.SetSchemaMigrator(vmStore)
+	// 集群缓存失效：部署/发布/回滚等变更广播到所有实例
+	s.invalidationBus = service.NewInvalidationBus(s.redis)
+	s.invalidationBus.Subscribe(func(event service.InvalidationEvent) {
+		if event.AffectsVM() {
+			vmPool.Invalidate(event.WorkspaceID)
+		}
+	})
+	workspaceService.SetInvalidationBus(s.invalidationBus)
 
 	// 初始化 Dashboard 服务
 	dashboardService := service.NewDashboardService(activityRepo)
@@ -146,24 +228,36 @@ func (s *Server) setupRoutes() {
 	featureFlagsService := service.NewFeatureFlagsService(s.config.Features)
 	notificationService := service.NewNotificationService(notificationRepo, userRepo)
 
+	runtimeRateLimiter := service.NewRuntimeRateLimiter(s.redis)
 	runtimeService := service.NewRuntimeService(
 		workspaceRepo,
 		workspaceSlugAliasRepo,
 		workspaceMemberRepo,
 		eventRecorder,
+		runtimeRateLimiter,
 		s.config.Security.PIISanitizationEnabled,
 		service.RuntimeCacheSettings{
 			EntryTTL:    s.config.Cache.Runtime.EntryTTL,
 			NegativeTTL: s.config.Cache.Runtime.NegativeTTL,
 		},
 	)
+	s.invalidationBus.Subscribe(runtimeService.InvalidateCache)
+	workspaceSecretService.SetInvalidationBus(s.invalidationBus)
+	s.invalidationBus.Subscribe(workspaceSecretService.InvalidateCache)
+	runtimeService.SetSecretRedactor(workspaceSecretService)
 	captchaVerifier := service.NewCaptchaVerifier(&s.config.Captcha)
 
 	// 初始化处理器
 	authHandler := handler.NewAuthHandler(authService)
 	userHandler := handler.NewUserHandler(userService, apiKeyService)
 	workspaceHandler := handler.NewWorkspaceHandler(workspaceService, auditLogService)
+	workspaceSecretHandler := handler.NewWorkspaceSecretHandler(workspaceSecretService, auditLogService)
 	vmDatabaseHandler := handler.NewVMDatabaseHandler(vmStore, auditLogService, workspaceService)
+	queryHistoryService := service.NewQueryHistoryService(repository.NewQueryHistoryRepository(s.db), service.QueryHistoryRetention{
+		MaxEntries: s.config.VMRuntime.QueryHistoryMaxEntries,
+		MaxAge:     s.config.VMRuntime.QueryHistoryRetention,
+	})
+	vmDatabaseHandler.SetQueryHistoryService(queryHistoryService)
 	auditLogHandler := handler.NewAuditLogHandler(auditLogService, workspaceService)
 	runtimeHandler := handler.NewRuntimeHandler(
 		runtimeService,
@@ -206,6 +300,8 @@ func (s *Server) setupRoutes() {
 	_ = agentToolRegistry.Register(agent_tools.NewAttemptCompletionTool(workspaceService, vmStore))
 	_ = agentToolRegistry.Register(agent_tools.NewListComponentsTool(workspaceService))
 	_ = agentToolRegistry.Register(agent_tools.NewBatchTool(agentToolRegistry))
+	_ = agentToolRegistry.Register(agent_tools.NewListSecretsTool(workspaceSecretService))
+	agentToolRegistry.SetResultRedactor(workspaceSecretService.RedactToolResult)
 	agentSessionManager := service.NewAgentSessionManager()
 	agentSessionRepo := repository.NewAgentSessionRepository(s.db)
 	agentSessionManager.SetPersister(service.NewAgentSessionPersisterAdapter(agentSessionRepo))
@@ -219,6 +315,12 @@ func (s *Server) setupRoutes() {
 	// Task tool registered after engine creation (needs engine reference for sub-agent sessions)
 	_ = agentToolRegistry.Register(agent_tools.NewTaskTool(agentEngineInstance, agentSessionManager, personaRegistry))
 	agentChatHandler := handler.NewAgentChatHandler(agentEngineInstance, agentSessionManager, workspaceService, personaRegistry, skillRegistry)
+	// Agent 运行在后台进行，事件编号持久化并推送到 WebSocket 房间 agent:<sessionID>，断线后可回放
+	agentRunService := service.NewAgentRunService(agentEngineInstance, repository.NewAgentRunEventRepository(s.db), s.log)
+	agentRunService.SetPublisher(func(_, sessionID string, event service.AgentEvent) {
+		s.wsHub.BroadcastAgentEvent(sessionID, event)
+	})
+	agentChatHandler.SetRunService(agentRunService)
 
 	// 健康检查
 	s.echo.GET("/health", systemHandler.HealthCheck)
@@ -227,7 +329,10 @@ func (s *Server) setupRoutes() {
 	s.echo.GET("/metrics", middleware.MetricsHandler())
 
 	// WebSocket 处理器
-	wsHandler := handler.NewWebSocketHandler(s.wsHub, &s.config.JWT)
+	wsHandler := handler.NewWebSocketHandler(s.wsHub, &s.config.JWT, &s.config.WebSocket, workspaceService)
+	wsHandler.SetAgentSessions(agentSessionManager)
+	s.wsHub.SetAuthorizer(wsHandler.AuthorizeRoom)
+	s.wsHub.SetMaxSubscriptions(s.config.WebSocket.MaxSubscriptions)
 	s.echo.GET("/ws", wsHandler.HandleConnection)
 
 	// 文件存储服务
@@ -245,13 +350,59 @@ func (s *Server) setupRoutes() {
 
 	// 应用运行时认证服务
 	appUserRepo := repository.NewAppUserRepository(s.db)
-	runtimeAuthService := service.NewRuntimeAuthServiceWithDB(appUserRepo, workspaceRepo, sessionRepo, s.db)
+	runtimeAuthService := service.NewRuntimeAuthService(appUserRepo, workspaceRepo, repository.NewAppSessionRepository(s.db), repository.NewAppUserTokenRepository(s.db), s.log)
+	// 邮件：重置密码、验证邮箱与邀请；配置无效时不发送邮件，相关接口返回未配置
+	if mailer, err := mail.New(&s.config.Mail, s.log); err != nil {
+		s.log.Error("Failed to initialize mail sender", "error", err)
+	} else {
+		runtimeAuthService.SetMailer(mailer, s.config.Mail.AppBaseURL)
+	}
+	runtimeAuthService.SetRateLimiter(runtimeRateLimiter)
+	runtimeAuthService.SetInvalidationBus(s.invalidationBus)
 	runtimeAuthHandler := handler.NewRuntimeAuthHandler(runtimeAuthService, runtimeService)
 	runtimeAuthHandler.SetWorkspaceService(workspaceService)
 	runtimeDataHandler := handler.NewRuntimeDataHandler(runtimeService, vmStore, workspaceRLSService)
 	runtimeDataHandler.SetRuntimeAuthService(runtimeAuthService)
+	workspaceRLSHandler.SetRuntimeAuthService(runtimeAuthService)
+	workspaceRLSHandler.SetVMStore(vmStore)
 	runtimeDataHandler.SetVMPool(vmPool)
+	runtimeDataHandler.SetSecretService(workspaceSecretService)
+	// 数据钩子：after-* 经任务队列执行并重试，执行记录按工作空间保留
+	workspaceDataHookService := service.NewWorkspaceDataHookService(repository.NewWorkspaceDataHookRepository(s.db), workspaceRepo, workspaceService, s.log)
+	runtimeDataHandler.SetDataHookService(workspaceDataHookService)
+	workspaceDataHookHandler := handler.NewWorkspaceDataHookHandler(workspaceDataHookService, auditLogService)
+	// 实时订阅：VMStore 提交的行变更按订阅者 RLS 过滤后经 WebSocket 推送
+	runtimeRealtimeHandler := handler.NewRuntimeRealtimeHandler(s.wsHub, runtimeService, vmStore, workspaceRLSService, runtimeAuthService, workspaceService, &s.config.JWT, &s.config.WebSocket)
+	vmStore.SetChangeListener(runtimeRealtimeHandler.PublishChanges)
+	s.invalidationBus.Subscribe(runtimeRealtimeHandler.CloseRevokedSessions)
 	runtimeVMHandler := handler.NewRuntimeVMHandler(runtimeService, vmPool, runtimeAuthService)
+	runtimeVMHandler.SetRLSService(workspaceRLSService)
+	runtimeVMHandler.SetSecretService(workspaceSecretService)
+
+	// 定时任务：部署后同步 exports.schedules，调度循环按工作空间时区入队，Worker 在 VM 中执行
+	workspaceScheduleService := service.NewWorkspaceScheduleService(repository.NewWorkspaceScheduleRepository(s.db), workspaceRepo, workspaceService, s.log)
+	if s.taskQueue != nil {
+		workspaceScheduleService.SetEnqueuer(s.taskQueue)
+	}
+	runtimeVMHandler.SetScheduleService(workspaceScheduleService)
+	s.invalidationBus.Subscribe(func(event service.InvalidationEvent) {
+		if !event.AffectsLogic() {
+			return
+		}
+		workspaceID, err := uuid.Parse(event.WorkspaceID)
+		if err != nil {
+			return
+		}
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := runtimeVMHandler.SyncSchedules(ctx, workspaceID); err != nil {
+				s.log.Warn("Failed to sync workspace schedules", "workspace_id", workspaceID, "error", err)
+			}
+		}()
+	})
+	s.startTaskWorker(workspaceScheduleService, workspaceDataHookService)
+	workspaceScheduleHandler := handler.NewWorkspaceScheduleHandler(workspaceScheduleService, auditLogService)
 
 	// Runtime 公开访问入口（现在直接用 workspaceSlug）
 	runtime := s.echo.Group("/runtime", middleware.RequireFeature(featureFlagsService.IsWorkspaceRuntimeEnabled, "WORKSPACE_RUNTIME_DISABLED", "Workspace Runtime 暂未开放"))
@@ -262,12 +413,20 @@ func (s *Server) setupRoutes() {
 		runtime.POST("/:workspaceSlug/auth/register", runtimeAuthHandler.Register)
 		runtime.POST("/:workspaceSlug/auth/login", runtimeAuthHandler.Login)
 		runtime.POST("/:workspaceSlug/auth/logout", runtimeAuthHandler.Logout)
+		runtime.POST("/:workspaceSlug/auth/logout-all", runtimeAuthHandler.LogoutAll)
+		runtime.POST("/:workspaceSlug/auth/refresh", runtimeAuthHandler.Refresh)
+		runtime.POST("/:workspaceSlug/auth/forgot-password", runtimeAuthHandler.ForgotPassword)
+		runtime.POST("/:workspaceSlug/auth/reset-password", runtimeAuthHandler.ResetPassword)
+		runtime.POST("/:workspaceSlug/auth/verify-email", runtimeAuthHandler.VerifyEmail)
+		runtime.POST("/:workspaceSlug/auth/resend-verification", runtimeAuthHandler.ResendVerification)
+		runtime.POST("/:workspaceSlug/auth/accept-invitation", runtimeAuthHandler.AcceptInvitation)
 		runtime.GET("/:workspaceSlug/auth/me", runtimeAuthHandler.Me)
 		// Runtime Data API — 公开访问已发布 App 的数据库
 		runtime.GET("/:workspaceSlug/data/:table", runtimeDataHandler.QueryRows)
 		runtime.POST("/:workspaceSlug/data/:table", runtimeDataHandler.InsertRow)
 		runtime.PATCH("/:workspaceSlug/data/:table", runtimeDataHandler.UpdateRow)
 		runtime.DELETE("/:workspaceSlug/data/:table", runtimeDataHandler.DeleteRows)
+		runtime.GET("/:workspaceSlug/realtime", runtimeRealtimeHandler.HandleConnection)
 		// Runtime Storage — 文件上传和访问
 		runtime.POST("/:workspaceSlug/storage/upload", runtimeStorageHandler.Upload)
 		runtime.GET("/:workspaceSlug/storage/files/:objectId", runtimeStorageHandler.ServeFilePublic)
@@ -358,8 +517,18 @@ func (s *Server) setupRoutes() {
 			workspaces.DELETE("/:id/database/tables/:table/rows", vmDatabaseHandler.DeleteRows)
 			workspaces.POST("/:id/database/query", vmDatabaseHandler.ExecuteSQL)
 			workspaces.GET("/:id/database/query/history", vmDatabaseHandler.GetQueryHistory)
+			workspaces.PATCH("/:id/database/query/history/:historyId", vmDatabaseHandler.UpdateQueryHistory)
+			workspaces.DELETE("/:id/database/query/history/:historyId", vmDatabaseHandler.DeleteQueryHistory)
 			workspaces.GET("/:id/database/stats", vmDatabaseHandler.GetStats)
 			workspaces.GET("/:id/database/schema-graph", vmDatabaseHandler.GetSchemaGraph)
+			workspaces.GET("/:id/database/snapshots", vmDatabaseHandler.ListSnapshots)
+			workspaces.POST("/:id/database/snapshots", vmDatabaseHandler.CreateSnapshot)
+			workspaces.POST("/:id/database/snapshots/:snapshotId/restore", vmDatabaseHandler.RestoreSnapshot)
+			workspaces.GET("/:id/database/snapshots/:snapshotId/download", vmDatabaseHandler.DownloadSnapshot)
+			workspaces.DELETE("/:id/database/snapshots/:snapshotId", vmDatabaseHandler.DeleteSnapshot)
+			workspaces.GET("/:id/database/migrations", vmDatabaseHandler.ListMigrations)
+			workspaces.POST("/:id/database/migrations/:version/apply", vmDatabaseHandler.ApplyMigration)
+			workspaces.POST("/:id/database/migrations/:version/revert", vmDatabaseHandler.RevertMigration)
 			workspaces.POST("/:id/agent/chat", agentChatHandler.Chat)
 			workspaces.GET("/:id/agent/status", agentChatHandler.Status)
 			workspaces.GET("/:id/agent/skills", agentChatHandler.ListSkills)
@@ -371,6 +540,7 @@ func (s *Server) setupRoutes() {
 			workspaces.POST("/:id/agent/cancel", agentChatHandler.Cancel)
 			workspaces.GET("/:id/agent/sessions", agentChatHandler.ListSessions)
 			workspaces.GET("/:id/agent/sessions/:sessionId", agentChatHandler.GetSession)
+			workspaces.GET("/:id/agent/sessions/:sessionId/events", agentChatHandler.ListSessionEvents)
 			workspaces.DELETE("/:id/agent/sessions/:sessionId", agentChatHandler.DeleteSession)
 			workspaces.POST("/:id/agent/sessions/:sessionId/confirm-plan", agentChatHandler.ConfirmPlan)
 			workspaces.GET("/:id/agent/personas", agentChatHandler.ListPersonas)
@@ -381,7 +551,11 @@ func (s *Server) setupRoutes() {
 			workspaces.GET("/:id/audit-logs", auditLogHandler.List)
 			workspaces.POST("/:id/audit-logs/client", auditLogHandler.RecordClient)
 			workspaces.GET("/:id/app-users", runtimeAuthHandler.ListUsers)
+			workspaces.POST("/:id/app-users/invitations", runtimeAuthHandler.InviteUser)
 			workspaces.POST("/:id/app-users/:userId/block", runtimeAuthHandler.BlockUser)
+			workspaces.GET("/:id/app-users/:userId/sessions", runtimeAuthHandler.ListUserSessions)
+			workspaces.DELETE("/:id/app-users/:userId/sessions", runtimeAuthHandler.RevokeUserSessions)
+			workspaces.DELETE("/:id/app-users/:userId/sessions/:sessionId", runtimeAuthHandler.RevokeUserSession)
 			workspaces.GET("/:id/members", workspaceHandler.ListMembers)
 			workspaces.POST("/:id/members", workspaceHandler.AddMember)
 			workspaces.PATCH("/:id/members/:memberId", workspaceHandler.UpdateMemberRole)
@@ -403,6 +577,23 @@ func (s *Server) setupRoutes() {
 			workspaces.PATCH("/:id/llm-config/:endpointId", workspaceHandler.UpdateLLMEndpoint)
 			workspaces.DELETE("/:id/llm-config/:endpointId", workspaceHandler.DeleteLLMEndpoint)
 			workspaces.POST("/:id/llm-config/:endpointId/default", workspaceHandler.SetDefaultLLMEndpoint)
+			// Secrets — 工作空间密钥（明文不回显）
+			workspaces.GET("/:id/secrets", workspaceSecretHandler.ListSecrets)
+			workspaces.POST("/:id/secrets", workspaceSecretHandler.CreateSecret)
+			workspaces.PUT("/:id/secrets/:name", workspaceSecretHandler.RotateSecret)
+			workspaces.DELETE("/:id/secrets/:name", workspaceSecretHandler.DeleteSecret)
+			workspaces.GET("/:id/schedules", workspaceScheduleHandler.ListSchedules)
+			workspaces.PUT("/:id/schedules/timezone", workspaceScheduleHandler.UpdateTimezone)
+			workspaces.GET("/:id/schedules/runs", workspaceScheduleHandler.ListRuns)
+			workspaces.PATCH("/:id/schedules/:scheduleId", workspaceScheduleHandler.UpdateSchedule)
+			workspaces.POST("/:id/schedules/:scheduleId/run", workspaceScheduleHandler.RunSchedule)
+			workspaces.GET("/:id/data-hooks/runs", workspaceDataHookHandler.ListRuns)
+			workspaces.GET("/:id/data-hooks/dead", workspaceDataHookHandler.ListDeadTasks)
+			workspaces.POST("/:id/data-hooks/dead/:taskId/retry", workspaceDataHookHandler.RetryDeadTask)
+
+			// Egress — JS 逻辑 fetch 出站白名单
+			workspaces.GET("/:id/egress", workspaceHandler.GetEgressAllowlist)
+			workspaces.PUT("/:id/egress", workspaceHandler.UpdateEgressAllowlist)
 			// Components — 自定义组件
 			workspaces.GET("/:id/components", workspaceHandler.ListComponents)
 			workspaces.GET("/:id/components/:componentId", workspaceHandler.GetComponent)
@@ -414,6 +605,7 @@ func (s *Server) setupRoutes() {
 			// RLS — 行级安全策略
 			workspaces.POST("/:id/database/rls-policies", workspaceRLSHandler.CreatePolicy)
 			workspaces.GET("/:id/database/rls-policies", workspaceRLSHandler.ListPolicies)
+			workspaces.POST("/:id/database/rls-policies/test", workspaceRLSHandler.TestPolicies)
 			workspaces.PATCH("/:id/database/rls-policies/:policyId", workspaceRLSHandler.UpdatePolicy)
 			workspaces.DELETE("/:id/database/rls-policies/:policyId", workspaceRLSHandler.DeletePolicy)
 		}
@@ -442,6 +634,52 @@ func (s *Server) setupRoutes() {
 
 }
 
+// startTaskWorker 启动本进程的任务 Worker：执行定时任务（并启动调度循环）与 after-* 数据钩子
+// vm_runtime.schedule_concurrency / data_hook_concurrency 为 0 时不消费对应队列
+// 多个实例都会调度，同一触发时间按任务 ID 去重，只执行一次
+func (s *Server) startTaskWorker(scheduleService service.WorkspaceScheduleService, dataHookService service.WorkspaceDataHookService) {
+	if s.taskQueue == nil {
+		return
+	}
+	var scheduleRunner queue.ScheduleRunner
+	var dataHookRunner queue.DataHookRunner
+	concurrency := 0
+	if n := s.config.VMRuntime.ScheduleConcurrency; n > 0 {
+		scheduleRunner = scheduleService
+		concurrency += n
+	}
+	if n := s.config.VMRuntime.DataHookConcurrency; n > 0 {
+		dataHookRunner = dataHookService
+		concurrency += n
+	}
+	if concurrency == 0 {
+		return
+	}
+	worker, err := queue.NewWorker(&queue.WorkerConfig{
+		RedisAddr:     fmt.Sprintf("%s:%d", s.config.Redis.Host, s.config.Redis.Port),
+		RedisPassword: s.config.Redis.Password,
+		RedisDB:       s.config.Redis.DB,
+		Concurrency:   concurrency,
+	}, s.log, nil, nil, scheduleRunner, dataHookRunner)
+	if err != nil {
+		s.log.Error("Failed to initialize task worker", "error", err)
+		return
+	}
+	if err := worker.Start(); err != nil {
+		s.log.Error("Failed to start task worker", "error", err)
+		return
+	}
+	s.taskWorker = worker
+	if dataHookRunner != nil {
+		// Worker 启动后才入队，否则钩子会积压在没有消费者的队列中
+		dataHookService.SetQueue(s.taskQueue)
+	}
+	if scheduleRunner != nil {
+		scheduleService.Start()
+		s.scheduleService = scheduleService
+	}
+}
+
 // Start 启动服务器
 func (s *Server) Start(addr string) error {
 	return s.echo.Start(addr)
@@ -449,8 +687,17 @@ func (s *Server) Start(addr string) error {
 
 // Shutdown 关闭服务器
 func (s *Server) Shutdown(ctx context.Context) error {
+	if s.scheduleService != nil {
+		s.scheduleService.Stop()
+	}
+	if s.taskWorker != nil {
+		s.taskWorker.Shutdown()
+	}
 	if s.taskQueue != nil {
 		_ = s.taskQueue.Close()
 	}
+	if s.invalidationBus != nil {
+		_ = s.invalidationBus.Close()
+	}
 	return s.echo.Shutdown(ctx)
 }
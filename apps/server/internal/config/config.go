@@ -13,12 +13,14 @@ type Config struct {
 	Env               string                  `mapstructure:"env"`
 	Deployment        DeploymentConfig        `mapstructure:"deployment"`
 	Server            ServerConfig            `mapstructure:"server"`
+	WebSocket         WebSocketConfig         `mapstructure:"websocket"`
 	Database          DatabaseConfig          `mapstructure:"database"`
 	Redis             RedisConfig             `mapstructure:"redis"`
 	Execution         ExecutionConfig         `mapstructure:"execution"`
 	Queue             QueueConfig             `mapstructure:"queue"`
 	JWT               JWTConfig               `mapstructure:"jwt"`
 	Captcha           CaptchaConfig           `mapstructure:"captcha"`
+	Mail              MailConfig              `mapstructure:"mail"`
 	AI                AIConfig                `mapstructure:"ai"`
 	Encryption        EncryptionConfig        `mapstructure:"encryption"`
 	Features          FeatureFlagsConfig      `mapstructure:"features"`
@@ -42,6 +44,14 @@ type ServerConfig struct {
 	BaseURL string `mapstructure:"base_url"` // 公开访问的基础 URL
 }
 
+// WebSocketConfig WebSocket 连接配置
+type WebSocketConfig struct {
+	// AllowedOrigins 允许握手的 Origin（支持 "*"、http://localhost:* 与 https://*.example.com）；
+	// 同源请求与不带 Origin 的客户端始终放行，已发布 App 的实时订阅另外接受 Workspace 的 allowed_origins
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	MaxSubscriptions int      `mapstructure:"max_subscriptions"` // 每个连接最多订阅的频道数，0 为不限制
+}
+
 // DeploymentConfig 多地域部署配置
 type DeploymentConfig struct {
 	Region         string            `mapstructure:"region"`
@@ -94,6 +104,24 @@ type JWTConfig struct {
 	RefreshTokenExpire time.Duration `mapstructure:"refresh_token_expire"`
 }
 
+// MailConfig 邮件发送配置
+type MailConfig struct {
+	Driver     string     `mapstructure:"driver"`       // smtp | file | log
+	From       string     `mapstructure:"from"`         // 发件人，如 "ReverseAI <no-reply@reverseai.app>"
+	AppBaseURL string     `mapstructure:"app_base_url"` // 已发布 App 的前端地址，邮件链接指向 <app_base_url>/runtime/<slug>
+	FileDir    string     `mapstructure:"file_dir"`     // file 驱动写入 .eml 的目录
+	SMTP       SMTPConfig `mapstructure:"smtp"`
+}
+
+// SMTPConfig SMTP 服务器配置
+type SMTPConfig struct {
+	Host     string `mapstructure:"host"`
+	Port     int    `mapstructure:"port"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	TLS      bool   `mapstructure:"tls"` // 隐式 TLS（465 端口）；为 false 时在服务器支持时使用 STARTTLS
+}
+
 // CaptchaConfig 验证码服务配置
 type CaptchaConfig struct {
 	Provider       string `mapstructure:"provider"` // turnstile
@@ -257,6 +285,38 @@ type VMRuntimeConfig struct {
 	MaxCodeSize   int64         `mapstructure:"max_code_size"`
 	MaxDBSize     int64         `mapstructure:"max_db_size"`
 	EvictInterval time.Duration `mapstructure:"evict_interval"`
+	// 缓存的 VM 在此时间内不再查询逻辑代码（部署/发布/回滚会主动失效），0 表示每次请求都校验
+	CodeCacheTTL time.Duration `mapstructure:"code_cache_ttl"`
+	// 每个 Workspace 的并发运行时数量与排队上限
+	MaxConcurrency int           `mapstructure:"max_concurrency"`
+	MaxQueueDepth  int           `mapstructure:"max_queue_depth"`
+	QueueTimeout   time.Duration `mapstructure:"queue_timeout"`
+	// 单次执行的资源预算：步数、调用栈深度、db.query 结果集、字符串与数组长度
+	MaxSteps int64 `mapstructure:"max_steps"`
+	// 执行期间进程堆增长超过该值即中断（进程级保护，不是单次执行的内存预算）
+	HeapGuardBytes  int64 `mapstructure:"heap_guard_bytes"`
+	MaxCallStack    int   `mapstructure:"max_call_stack"`
+	MaxResultRows   int   `mapstructure:"max_result_rows"`
+	MaxResultBytes  int64 `mapstructure:"max_result_bytes"`
+	MaxStringLength int   `mapstructure:"max_string_length"`
+	MaxArrayLength  int   `mapstructure:"max_array_length"`
+	// 出站 fetch：单次调用超时、每次执行的调用次数、请求/响应体大小上限；允许访问内网地址仅用于本地开发
+	FetchTimeout      time.Duration `mapstructure:"fetch_timeout"`
+	MaxFetchCalls     int           `mapstructure:"max_fetch_calls"`
+	MaxFetchBytes     int64         `mapstructure:"max_fetch_bytes"`
+	FetchAllowPrivate bool          `mapstructure:"fetch_allow_private"`
+	// SQL 编辑器查询历史保留策略（固定的记录不受影响）
+	QueryHistoryMaxEntries int           `mapstructure:"query_history_max_entries"`
+	QueryHistoryRetention  time.Duration `mapstructure:"query_history_retention"`
+	// 数据库快照：目录（默认 base_dir/snapshots）、定时间隔（0 关闭）与保留策略
+	SnapshotDir      string        `mapstructure:"snapshot_dir"`
+	SnapshotInterval time.Duration `mapstructure:"snapshot_interval"`
+	SnapshotMaxCount int           `mapstructure:"snapshot_max_count"`
+	SnapshotMaxAge   time.Duration `mapstructure:"snapshot_max_age"`
+	// exports.schedules 定时任务：本进程执行定时任务的并发数，0 表示不在本进程调度与执行
+	ScheduleConcurrency int `mapstructure:"schedule_concurrency"`
+	// after-* 数据钩子：本进程消费钩子队列的并发数，0 表示钩子不入队，在写入请求后台直接执行（不重试）
+	DataHookConcurrency int `mapstructure:"data_hook_concurrency"`
 }
 
 // Load 加载配置
@@ -316,6 +376,17 @@ func setDefaults() {
 	viper.SetDefault("server.mode", "development")
 	viper.SetDefault("server.base_url", "http://localhost:3010")
 
+	// WebSocket
+	viper.SetDefault("websocket.allowed_origins", []string{"http://localhost:*", "http://127.0.0.1:*", "https://reverseai.app", "https://*.reverseai.app"})
+	viper.SetDefault("websocket.max_subscriptions", 100)
+
+	// Mail
+	viper.SetDefault("mail.driver", "log")
+	viper.SetDefault("mail.from", "ReverseAI <no-reply@reverseai.app>")
+	viper.SetDefault("mail.app_base_url", "http://localhost:3011")
+	viper.SetDefault("mail.file_dir", "data/mail")
+	viper.SetDefault("mail.smtp.port", 587)
+
 	// 多地域部署
 	viper.SetDefault("deployment.region", "local")
 	viper.SetDefault("deployment.primary_region", "local")
@@ -449,6 +520,29 @@ func setDefaults() {
 	viper.SetDefault("vm_runtime.max_code_size", 1048576)
 	viper.SetDefault("vm_runtime.max_db_size", 104857600)
 	viper.SetDefault("vm_runtime.evict_interval", "30m")
+	viper.SetDefault("vm_runtime.code_cache_ttl", "1m")
+	viper.SetDefault("vm_runtime.max_concurrency", 4)
+	viper.SetDefault("vm_runtime.max_queue_depth", 64)
+	viper.SetDefault("vm_runtime.queue_timeout", "10s")
+	viper.SetDefault("vm_runtime.max_steps", 10000000)
+	viper.SetDefault("vm_runtime.heap_guard_bytes", 268435456)
+	viper.SetDefault("vm_runtime.max_call_stack", 1000)
+	viper.SetDefault("vm_runtime.max_result_rows", 10000)
+	viper.SetDefault("vm_runtime.max_result_bytes", 16777216)
+	viper.SetDefault("vm_runtime.max_string_length", 16777216)
+	viper.SetDefault("vm_runtime.max_array_length", 1048576)
+	viper.SetDefault("vm_runtime.fetch_timeout", "5s")
+	viper.SetDefault("vm_runtime.max_fetch_calls", 20)
+	viper.SetDefault("vm_runtime.max_fetch_bytes", 5242880)
+	viper.SetDefault("vm_runtime.fetch_allow_private", false)
+	viper.SetDefault("vm_runtime.query_history_max_entries", 1000)
+	viper.SetDefault("vm_runtime.query_history_retention", "720h")
+	viper.SetDefault("vm_runtime.snapshot_dir", "")
+	viper.SetDefault("vm_runtime.snapshot_interval", "6h")
+	viper.SetDefault("vm_runtime.snapshot_max_count", 20)
+	viper.SetDefault("vm_runtime.snapshot_max_age", "168h")
+	viper.SetDefault("vm_runtime.schedule_concurrency", 2)
+	viper.SetDefault("vm_runtime.data_hook_concurrency", 4)
 
 	// Archive / Export
 	viper.SetDefault("archive.enabled", true)
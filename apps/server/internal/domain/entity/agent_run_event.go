@@ -0,0 +1,32 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AgentRunEvent Agent 运行事件，按会话从 1 开始递增编号，供断线重连与多端查看时回放
+type AgentRunEvent struct {
+	ID          uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	SessionID   string    `gorm:"size:64;not null;uniqueIndex:idx_agent_run_events_session_seq,priority:1" json:"session_id"`
+	WorkspaceID uuid.UUID `gorm:"type:char(36);not null;index" json:"workspace_id"`
+	Seq         int64     `gorm:"not null;uniqueIndex:idx_agent_run_events_session_seq,priority:2" json:"seq"`
+	Type        string    `gorm:"size:40;not null" json:"type"`
+	Payload     JSON      `gorm:"type:json" json:"payload"` // 完整的 AgentEvent
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// TableName 表名
+func (AgentRunEvent) TableName() string {
+	return "what_reverse_agent_run_events"
+}
+
+// BeforeCreate 创建前钩子
+func (e *AgentRunEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == uuid.Nil {
+		e.ID = uuid.New()
+	}
+	return nil
+}
@@ -9,16 +9,18 @@ import (
 
 // AppUser 应用运行时用户（应用自身的用户，区别于平台用户 User）
 type AppUser struct {
-	ID           uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
-	WorkspaceID  uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:uniq_app_user_email" json:"workspace_id"`
-	Email        string     `gorm:"size:255;not null;uniqueIndex:uniq_app_user_email" json:"email"`
-	PasswordHash string     `gorm:"size:255;not null" json:"-"`
-	DisplayName  *string    `gorm:"size:100" json:"display_name"`
-	Role         string     `gorm:"size:20;not null;default:'user'" json:"role"`
-	Status       string     `gorm:"size:20;not null;default:'active'" json:"status"`
-	LastLoginAt  *time.Time `json:"last_login_at"`
-	CreatedAt    time.Time  `json:"created_at"`
-	UpdatedAt    time.Time  `json:"updated_at"`
+	ID            uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID   uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:uniq_app_user_email" json:"workspace_id"`
+	Email         string     `gorm:"size:255;not null;uniqueIndex:uniq_app_user_email" json:"email"`
+	PasswordHash  string     `gorm:"size:255;not null" json:"-"`
+	DisplayName   *string    `gorm:"size:100" json:"display_name"`
+	Role          string     `gorm:"size:20;not null;default:'user'" json:"role"`
+	Status        string     `gorm:"size:20;not null;default:'active'" json:"status"` // active | blocked | invited
+	EmailVerified bool       `gorm:"not null;default:false" json:"email_verified"`
+	InvitedBy     *uuid.UUID `gorm:"type:char(36)" json:"invited_by,omitempty"` // 邀请该用户的平台用户
+	LastLoginAt   *time.Time `json:"last_login_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
 
 	Workspace *Workspace `gorm:"foreignKey:WorkspaceID" json:"workspace,omitempty"`
 }
@@ -33,3 +35,33 @@ func (u *AppUser) BeforeCreate(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// AppUserToken 用途
+const (
+	AppUserTokenResetPassword = "reset_password"
+	AppUserTokenVerifyEmail   = "verify_email"
+	AppUserTokenInvite        = "invite"
+)
+
+// AppUserToken 应用用户的一次性令牌（重置密码、验证邮箱、邀请），只保存哈希
+type AppUserToken struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID uuid.UUID  `gorm:"type:char(36);not null;index" json:"workspace_id"`
+	AppUserID   uuid.UUID  `gorm:"type:char(36);not null;index" json:"app_user_id"`
+	Purpose     string     `gorm:"size:20;not null" json:"purpose"`
+	TokenHash   string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+func (AppUserToken) TableName() string {
+	return "what_reverse_app_user_tokens"
+}
+
+func (t *AppUserToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
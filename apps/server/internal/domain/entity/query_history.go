@@ -0,0 +1,44 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// QueryHistory SQL 编辑器执行记录；Pinned 的记录即“保存的查询”，不受保留策略清理
+type QueryHistory struct {
+	ID           uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID  uuid.UUID `gorm:"type:char(36);not null;index:idx_query_history_ws_created,priority:1" json:"workspace_id"`
+	UserID       uuid.UUID `gorm:"type:char(36);not null;index" json:"user_id"`
+	SQL          string    `gorm:"column:sql_text;type:text;not null" json:"sql"`
+	DurationMs   int64     `gorm:"not null;default:0" json:"duration_ms"`
+	RowCount     int64     `gorm:"not null;default:0" json:"row_count"`
+	AffectedRows int64     `gorm:"not null;default:0" json:"affected_rows"`
+	Status       string    `gorm:"size:20;not null;index" json:"status"`
+	Error        string    `gorm:"type:text" json:"error,omitempty"`
+	Pinned       bool      `gorm:"not null;default:false;index" json:"pinned"`
+	Name         string    `gorm:"size:200" json:"name,omitempty"`
+	CreatedAt    time.Time `gorm:"index:idx_query_history_ws_created,priority:2" json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// TableName 表名
+func (QueryHistory) TableName() string {
+	return "what_reverse_workspace_query_history"
+}
+
+// BeforeCreate 创建前钩子
+func (h *QueryHistory) BeforeCreate(tx *gorm.DB) error {
+	if h.ID == uuid.Nil {
+		h.ID = uuid.New()
+	}
+	return nil
+}
+
+// 查询执行状态
+const (
+	QueryStatusSuccess = "success"
+	QueryStatusError   = "error"
+)
@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -8,7 +9,8 @@ import (
 )
 
 // RLSPolicy 行级安全策略
-// 定义某张表的某个列必须匹配当前登录用户的某个属性
+// 简单策略：某张表的某个列必须匹配当前登录用户的某个属性
+// 表达式策略：Expression 非空时使用表达式（支持 AND/OR、IN 子查询、user.* 属性与角色），忽略 Column/MatchField
 type RLSPolicy struct {
 	ID          uuid.UUID      `gorm:"type:char(36);primaryKey" json:"id"`
 	WorkspaceID uuid.UUID      `gorm:"type:char(36);not null;index" json:"workspace_id"`
@@ -16,6 +18,7 @@ type RLSPolicy struct {
 	Column      string         `gorm:"size:200;not null" json:"column"`
 	MatchField  string         `gorm:"size:100;not null;default:'app_user_id'" json:"match_field"`
 	Operation   string         `gorm:"size:50;not null;default:'all'" json:"operation"`
+	Expression  string         `gorm:"type:text" json:"expression,omitempty"`
 	Enabled     bool           `gorm:"default:true" json:"enabled"`
 	Description string         `gorm:"size:500" json:"description"`
 	CreatedAt   time.Time      `json:"created_at"`
@@ -26,3 +29,27 @@ type RLSPolicy struct {
 func (RLSPolicy) TableName() string {
 	return "rls_policies"
 }
+
+// RLS 策略适用的操作
+const (
+	RLSOperationSelect = "select"
+	RLSOperationInsert = "insert"
+	RLSOperationUpdate = "update"
+	RLSOperationDelete = "delete"
+	RLSOperationAll    = "all"
+)
+
+// IsValidRLSOperation 判断 operation 是否为合法取值
+func IsValidRLSOperation(operation string) bool {
+	switch operation {
+	case RLSOperationSelect, RLSOperationInsert, RLSOperationUpdate, RLSOperationDelete, RLSOperationAll:
+		return true
+	}
+	return false
+}
+
+// AppliesTo 判断策略是否作用于指定操作（空值视为 all）
+func (p *RLSPolicy) AppliesTo(operation string) bool {
+	op := strings.ToLower(strings.TrimSpace(p.Operation))
+	return op == "" || op == RLSOperationAll || op == operation
+}
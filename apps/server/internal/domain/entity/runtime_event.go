@@ -39,6 +39,7 @@ const (
 	EventDBMigrationStarted   RuntimeEventType = "db.migration.started"
 	EventDBMigrationCompleted RuntimeEventType = "db.migration.completed"
 	EventDBMigrationFailed    RuntimeEventType = "db.migration.failed"
+	EventDBMigrationRestored  RuntimeEventType = "db.migration.restored"
 	EventDBBackupCreated      RuntimeEventType = "db.backup.created"
 	EventDBRestored           RuntimeEventType = "db.restored"
 
@@ -371,6 +372,7 @@ func GetEventTypeMetadata() []EventTypeMeta {
 		{EventDBMigrationStarted, "database", "数据库迁移开始"},
 		{EventDBMigrationCompleted, "database", "数据库迁移完成"},
 		{EventDBMigrationFailed, "database", "数据库迁移失败"},
+		{EventDBMigrationRestored, "database", "数据库迁移状态随快照恢复"},
 		{EventDBBackupCreated, "database", "数据库备份创建"},
 		{EventDBRestored, "database", "数据库恢复"},
 
@@ -1,3 +1,36 @@
 package entity
 
-// secret.go — 已清理：Secret 实体未被任何 repo 或 service 使用（45 行死代码已移除）。
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Secret 工作空间密钥（第三方 API Token、Webhook 签名密钥等）
+// 明文只在创建 / 轮换时提交一次，落库前使用 crypto.Encryptor 加密，接口不再返回明文
+type Secret struct {
+	ID             uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID    uuid.UUID  `gorm:"type:char(36);not null;uniqueIndex:idx_workspace_secret_name" json:"workspace_id"`
+	Name           string     `gorm:"size:64;not null;uniqueIndex:idx_workspace_secret_name" json:"name"`
+	Description    string     `gorm:"size:500" json:"description"`
+	ValueEncrypted string     `gorm:"type:text;not null" json:"-"`
+	Version        int        `gorm:"not null;default:1" json:"version"`
+	CreatedBy      *uuid.UUID `gorm:"type:char(36)" json:"created_by,omitempty"`
+	RotatedAt      *time.Time `json:"rotated_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// TableName 表名
+func (Secret) TableName() string {
+	return "what_reverse_workspace_secrets"
+}
+
+// BeforeCreate 创建前钩子
+func (s *Secret) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
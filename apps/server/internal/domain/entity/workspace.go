@@ -126,6 +126,7 @@ type WorkspaceVersion struct {
 	Changelog      *string    `gorm:"type:text" json:"changelog"`
 	UISchema       JSON       `gorm:"column:ui_schema;type:json" json:"ui_schema"`
 	DBSchema       JSON       `gorm:"column:db_schema;type:json" json:"db_schema"`
+	SchemaVersion  *int64     `gorm:"column:schema_version" json:"schema_version"` // 工作空间数据库的迁移版本
 	ConfigJSON     JSON       `gorm:"column:config_json;type:json" json:"config_json"`
 	LogicCode      *string    `gorm:"column:logic_code;type:longtext" json:"logic_code"`
 	ComponentCode  *string    `gorm:"column:component_code;type:longtext" json:"component_code"`
@@ -199,7 +200,7 @@ type WorkspaceSession struct {
 	SessionType   string     `gorm:"size:20;default:'anon';index" json:"session_type"`
 	UserID        *uuid.UUID `gorm:"type:char(36);index" json:"user_id"`
 	AppUserID     *uuid.UUID `gorm:"type:char(36);index:idx_ws_sessions_app_user" json:"app_user_id"`
-	TokenHash     *string    `gorm:"size:255;index:idx_ws_sessions_token" json:"token_hash,omitempty"`
+	TokenHash     *string    `gorm:"size:255;index:idx_ws_sessions_token" json:"-"`
 	AuthMethod    *string    `gorm:"size:20;default:'password'" json:"auth_method,omitempty"`
 	IPHash        *string    `gorm:"size:100" json:"ip_hash"`
 	UserAgentHash *string    `gorm:"size:200" json:"user_agent_hash"`
@@ -208,6 +209,12 @@ type WorkspaceSession struct {
 	BlockedAt     *time.Time `json:"blocked_at"`
 	BlockedReason *string    `gorm:"size:255" json:"blocked_reason"`
 
+	// 应用用户登录会话（session_type=auth）：Refresh Token 轮换续期，撤销后 Token 与 Refresh Token 均失效
+	RefreshTokenHash *string    `gorm:"size:255;index:idx_ws_sessions_refresh" json:"-"`
+	RefreshExpiredAt *time.Time `json:"refresh_expired_at,omitempty"`
+	LastActiveAt     *time.Time `json:"last_active_at,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+
 	Workspace *Workspace `gorm:"foreignKey:WorkspaceID" json:"workspace,omitempty"`
 	User      *User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
 	AppUser   *AppUser   `gorm:"foreignKey:AppUserID" json:"app_user,omitempty"`
@@ -0,0 +1,58 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// 数据钩子：JS 逻辑中 "POST /hooks/<hook>/:table" 路由
+// before-* 在写入前同步执行，可拒绝或修改数据；after-* 写入后经任务队列异步执行并重试
+const (
+	DataHookBeforeInsert = "before-insert"
+	DataHookAfterInsert  = "after-insert"
+	DataHookBeforeUpdate = "before-update"
+	DataHookAfterUpdate  = "after-update"
+	DataHookBeforeDelete = "before-delete"
+	DataHookAfterDelete  = "after-delete"
+)
+
+// 数据钩子执行状态
+const (
+	DataHookRunStatusSucceeded = "succeeded"
+	// DataHookRunStatusFailed 本次尝试失败，稍后重试
+	DataHookRunStatusFailed = "failed"
+	// DataHookRunStatusDead 最后一次尝试失败，任务进入死信队列
+	DataHookRunStatusDead = "dead"
+)
+
+// WorkspaceDataHookRun after-* 数据钩子执行记录（每次尝试一条，未注册钩子的表不记录）
+type WorkspaceDataHookRun struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID uuid.UUID  `gorm:"type:char(36);not null;index:idx_data_hook_runs_ws_created,priority:1" json:"workspace_id"`
+	EventID     uuid.UUID  `gorm:"type:char(36);not null;index" json:"event_id"`
+	Hook        string     `gorm:"size:30;not null" json:"hook"`
+	Table       string     `gorm:"column:table_name;size:100;not null" json:"table"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"`
+	Attempt     int        `gorm:"not null;default:1" json:"attempt"`
+	OccurredAt  time.Time  `json:"occurred_at"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	DurationMs  int64      `gorm:"not null;default:0" json:"duration_ms"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	CreatedAt   time.Time  `gorm:"index:idx_data_hook_runs_ws_created,priority:2" json:"created_at"`
+}
+
+// TableName 表名
+func (WorkspaceDataHookRun) TableName() string {
+	return "what_reverse_workspace_data_hook_runs"
+}
+
+// BeforeCreate 创建前钩子
+func (r *WorkspaceDataHookRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WorkspaceSchedule JS 逻辑中 exports.schedules 声明的定时任务
+// 记录由部署后的同步生成（代码中删除的条目会被移除），Enabled 为用户暂停开关
+type WorkspaceSchedule struct {
+	ID          uuid.UUID `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID uuid.UUID `gorm:"type:char(36);not null;uniqueIndex:idx_workspace_schedule_cron" json:"workspace_id"`
+	Cron        string    `gorm:"size:100;not null;uniqueIndex:idx_workspace_schedule_cron" json:"cron"`
+	Enabled     bool      `gorm:"not null;default:true" json:"enabled"`
+	// RunningRunID 非空表示有执行中的任务（用于防止重叠执行），超过超时时间视为失效
+	RunningRunID *uuid.UUID `gorm:"type:char(36)" json:"running_run_id,omitempty"`
+	RunningSince *time.Time `json:"running_since,omitempty"`
+	LastRunAt    *time.Time `json:"last_run_at,omitempty"`
+	LastStatus   string     `gorm:"size:20" json:"last_status,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// TableName 表名
+func (WorkspaceSchedule) TableName() string {
+	return "what_reverse_workspace_schedules"
+}
+
+// BeforeCreate 创建前钩子
+func (s *WorkspaceSchedule) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// WorkspaceScheduleRun 定时任务执行记录（每次尝试一条）
+type WorkspaceScheduleRun struct {
+	ID          uuid.UUID  `gorm:"type:char(36);primaryKey" json:"id"`
+	WorkspaceID uuid.UUID  `gorm:"type:char(36);not null;index:idx_schedule_runs_ws_created,priority:1" json:"workspace_id"`
+	ScheduleID  uuid.UUID  `gorm:"type:char(36);not null;index" json:"schedule_id"`
+	Cron        string     `gorm:"size:100;not null" json:"cron"`
+	Trigger     string     `gorm:"size:20;not null" json:"trigger"`
+	Status      string     `gorm:"size:20;not null;index" json:"status"`
+	Attempt     int        `gorm:"not null;default:1" json:"attempt"`
+	ScheduledAt time.Time  `json:"scheduled_at"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+	DurationMs  int64      `gorm:"not null;default:0" json:"duration_ms"`
+	Error       string     `gorm:"type:text" json:"error,omitempty"`
+	TriggeredBy *uuid.UUID `gorm:"type:char(36)" json:"triggered_by,omitempty"`
+	CreatedAt   time.Time  `gorm:"index:idx_schedule_runs_ws_created,priority:2" json:"created_at"`
+}
+
+// TableName 表名
+func (WorkspaceScheduleRun) TableName() string {
+	return "what_reverse_workspace_schedule_runs"
+}
+
+// BeforeCreate 创建前钩子
+func (r *WorkspaceScheduleRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// 定时任务触发方式
+const (
+	ScheduleTriggerCron   = "cron"
+	ScheduleTriggerManual = "manual"
+)
+
+// 定时任务执行状态
+const (
+	ScheduleRunStatusRunning   = "running"
+	ScheduleRunStatusSucceeded = "succeeded"
+	ScheduleRunStatusFailed    = "failed"
+	// ScheduleRunStatusSkipped 上一次执行尚未结束，本次跳过
+	ScheduleRunStatusSkipped = "skipped"
+)
@@ -69,6 +69,18 @@ func Migrate(db *gorm.DB) error {
 		}
 	}
 
+	// 旧表名统一为 what_reverse_ 前缀，保留已有数据
+	tableRenames := map[string]string{
+		"workspace_query_history": "what_reverse_workspace_query_history",
+	}
+	for from, to := range tableRenames {
+		if migrator.HasTable(from) && !migrator.HasTable(to) {
+			if err := migrator.RenameTable(from, to); err != nil {
+				return fmt.Errorf("failed to rename table %s: %w", from, err)
+			}
+		}
+	}
+
 	return db.AutoMigrate(
 		// 用户相关
 		&entity.User{},
@@ -76,6 +88,7 @@ func Migrate(db *gorm.DB) error {
 		&entity.UserSession{},
 		&entity.AgentSession{},
 		&entity.AppUser{},
+		&entity.AppUserToken{},
 		&entity.Workspace{},
 		&entity.WorkspaceVersion{},
 		&entity.WorkspaceDomain{},
@@ -102,5 +115,21 @@ func Migrate(db *gorm.DB) error {
 
 		// RLS 策略
 		&entity.RLSPolicy{},
+
+		// 工作空间密钥
+		&entity.Secret{},
+
+		// 工作空间定时任务与执行记录
+		&entity.WorkspaceSchedule{},
+		&entity.WorkspaceScheduleRun{},
+
+		// 数据钩子执行记录
+		&entity.WorkspaceDataHookRun{},
+
+		// Agent 运行事件（断线重连回放）
+		&entity.AgentRunEvent{},
+
+		// SQL 查询历史
+		&entity.QueryHistory{},
 	)
 }
@@ -0,0 +1,51 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/reverseai/server/internal/pkg/logger"
+)
+
+// unsafeFileChars 收件人地址中不适合出现在文件名里的字符
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// FileSender 把邮件写成 .eml 文件，用于本地开发与测试
+type FileSender struct {
+	from string
+	dir  string
+	log  logger.Logger
+}
+
+// NewFileSender 创建文件发送器，目录不存在时自动创建
+func NewFileSender(from, dir string, log logger.Logger) (*FileSender, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("mail.file_dir is required for the file driver")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create mail dir: %w", err)
+	}
+	return &FileSender{from: from, dir: dir, log: log}, nil
+}
+
+// Send 写入 <时间>-<收件人>.eml
+func (s *FileSender) Send(_ context.Context, msg *Message) error {
+	now := time.Now()
+	body, err := build(s.from, msg, now)
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102T150405.000000000"), unsafeFileChars.ReplaceAllString(msg.To, "_"))
+	path := filepath.Join(s.dir, name)
+	if err := os.WriteFile(path, body, 0o600); err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+	if s.log != nil {
+		s.log.Info("Mail written to file", "to", msg.To, "subject", msg.Subject, "path", path)
+	}
+	return nil
+}
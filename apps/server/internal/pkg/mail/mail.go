@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/config"
+	"github.com/reverseai/server/internal/pkg/logger"
+)
+
+// 发送驱动
+const (
+	DriverSMTP = "smtp"
+	DriverFile = "file"
+	DriverLog  = "log"
+)
+
+// Message 纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+}
+
+// Sender 邮件发送接口
+type Sender interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// New 按配置创建发送器
+// smtp 经 SMTP 服务器投递；file 把邮件写成 .eml 文件（本地开发与测试）；log 只写日志
+func New(cfg *config.MailConfig, log logger.Logger) (Sender, error) {
+	from := strings.TrimSpace(cfg.From)
+	if _, err := mail.ParseAddress(from); err != nil {
+		return nil, fmt.Errorf("invalid mail from address %q: %w", from, err)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(cfg.Driver)) {
+	case DriverSMTP:
+		if cfg.SMTP.Host == "" {
+			return nil, fmt.Errorf("mail.smtp.host is required for the smtp driver")
+		}
+		return NewSMTPSender(from, cfg.SMTP), nil
+	case DriverFile:
+		return NewFileSender(from, cfg.FileDir, log)
+	case DriverLog, "":
+		return NewLogSender(log), nil
+	default:
+		return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+	}
+}
+
+// LogSender 只把邮件写入日志，不投递
+type LogSender struct {
+	log logger.Logger
+}
+
+// NewLogSender 创建日志发送器
+func NewLogSender(log logger.Logger) *LogSender {
+	return &LogSender{log: log}
+}
+
+// Send 记录邮件内容
+func (s *LogSender) Send(_ context.Context, msg *Message) error {
+	s.log.Info("Mail not delivered (log driver)",
+		"to", msg.To,
+		"subject", msg.Subject,
+		"text", msg.Text)
+	return nil
+}
+
+// build 生成 RFC 5322 邮件，正文为 UTF-8 quoted-printable（换行统一写为 CRLF）
+func build(from string, msg *Message, now time.Time) ([]byte, error) {
+	if _, err := mail.ParseAddress(msg.To); err != nil {
+		return nil, fmt.Errorf("invalid recipient %q: %w", msg.To, err)
+	}
+	if strings.ContainsAny(msg.To+msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("mail headers must not contain line breaks")
+	}
+
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if _, host, ok := strings.Cut(addr.Address, "@"); ok {
+			domain = host
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", uuid.New().String(), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	w := quotedprintable.NewWriter(&buf)
+	if _, err := w.Write([]byte(msg.Text)); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,97 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"time"
+
+	"github.com/reverseai/server/internal/config"
+)
+
+// smtpDialTimeout 连接 SMTP 服务器的超时
+const smtpDialTimeout = 10 * time.Second
+
+// SMTPSender 经 SMTP 服务器投递邮件
+// TLS 为 true 时使用隐式 TLS（通常为 465 端口），否则在服务器支持时升级为 STARTTLS
+type SMTPSender struct {
+	from string
+	cfg  config.SMTPConfig
+}
+
+// NewSMTPSender 创建 SMTP 发送器
+func NewSMTPSender(from string, cfg config.SMTPConfig) *SMTPSender {
+	return &SMTPSender{from: from, cfg: cfg}
+}
+
+// Send 投递邮件
+func (s *SMTPSender) Send(ctx context.Context, msg *Message) error {
+	body, err := build(s.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	fromAddr, err := mail.ParseAddress(s.from)
+	if err != nil {
+		return err
+	}
+	toAddr, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return err
+	}
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+	tlsConfig := &tls.Config{ServerName: s.cfg.Host}
+	dialer := &net.Dialer{Timeout: smtpDialTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("connect to smtp server: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if s.cfg.TLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("smtp handshake: %w", err)
+	}
+	defer client.Close()
+
+	if !s.cfg.TLS {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return fmt.Errorf("smtp starttls: %w", err)
+			}
+		}
+	}
+	if s.cfg.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)); err != nil {
+			return fmt.Errorf("smtp auth: %w", err)
+		}
+	}
+	if err := client.Mail(fromAddr.Address); err != nil {
+		return fmt.Errorf("smtp mail from: %w", err)
+	}
+	if err := client.Rcpt(toAddr.Address); err != nil {
+		return fmt.Errorf("smtp rcpt to: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	if _, err := w.Write(body); err != nil {
+		w.Close()
+		return fmt.Errorf("smtp write: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("smtp data: %w", err)
+	}
+	return client.Quit()
+}
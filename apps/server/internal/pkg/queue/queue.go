@@ -15,12 +15,16 @@ import (
 const (
 	QueueDomainVerify       = "domain_verify"
 	QueueMetricsAggregation = "metrics_aggregation"
+	QueueScheduled          = "scheduled"
+	QueueDataHooks          = "data_hooks"
 )
 
 // 任务类型常量
 const (
 	TaskTypeDomainVerify       = "app:domain:verify"
 	TaskTypeMetricsAggregation = "metrics:aggregate"
+	TaskTypeWorkspaceSchedule  = "workspace:schedule:run"
+	TaskTypeDataHook           = "workspace:data:hook"
 )
 
 // DomainVerifyPayload 域名验证载荷
@@ -35,6 +39,27 @@ type MetricsAggregationPayload struct {
 	WorkspaceID *string `json:"workspace_id,omitempty"`
 }
 
+// WorkspaceSchedulePayload 工作空间定时任务载荷
+type WorkspaceSchedulePayload struct {
+	WorkspaceID string    `json:"workspace_id"`
+	Schedule    string    `json:"schedule"`
+	Trigger     string    `json:"trigger"`
+	ScheduledAt time.Time `json:"scheduled_at"`
+	// TriggeredBy 手动触发的用户 ID
+	TriggeredBy *string `json:"triggered_by,omitempty"`
+}
+
+// DataHookPayload 数据 after-* 钩子载荷
+type DataHookPayload struct {
+	// EventID 数据变更事件 ID，同时作为任务 ID；重试时不变，钩子可据此幂等处理
+	EventID     string                 `json:"event_id"`
+	WorkspaceID string                 `json:"workspace_id"`
+	Hook        string                 `json:"hook"`
+	Table       string                 `json:"table"`
+	Data        map[string]interface{} `json:"data"`
+	OccurredAt  time.Time              `json:"occurred_at"`
+}
+
 // EnqueueResult 统一任务入队结果
 type EnqueueResult struct {
 	TaskID  string `json:"task_id,omitempty"`
@@ -50,6 +75,15 @@ const (
 	dedupMediumTTL             = 15 * time.Minute
 	maxRetryDomainVerify       = 5
 	maxRetryMetricsAggregation = 2
+	// WorkspaceScheduleTimeout 定时任务单次尝试的超时（JS 执行另受 exec_timeout 限制）
+	WorkspaceScheduleTimeout  = 5 * time.Minute
+	maxRetryWorkspaceSchedule = 3
+	// DataHookTimeout 数据钩子单次尝试的超时
+	DataHookTimeout = 2 * time.Minute
+	// MaxRetryDataHook 数据钩子的最大重试次数，用尽后进入死信队列
+	MaxRetryDataHook = 5
+	// dataHookRetention 死信保留时长，便于排查与手动重试
+	dataHookRetention = 7 * 24 * time.Hour
 )
 
 // Queue 任务队列管理器
@@ -165,6 +199,61 @@ func (q *Queue) EnqueueMetricsAggregation(ctx context.Context, payload *MetricsA
 	return &EnqueueResult{TaskID: info.ID, Queue: QueueMetricsAggregation}, nil
 }
 
+// EnqueueWorkspaceSchedule 将工作空间定时任务加入队列
+// taskID 非空时作为任务 ID：多个实例为同一触发时间入队时只有一个成功，其余视为去重
+func (q *Queue) EnqueueWorkspaceSchedule(ctx context.Context, payload *WorkspaceSchedulePayload, taskID string) (*EnqueueResult, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	opts := []asynq.Option{
+		asynq.MaxRetry(maxRetryWorkspaceSchedule),
+		asynq.Timeout(WorkspaceScheduleTimeout),
+		asynq.Retention(defaultTaskRetention),
+		asynq.Queue(QueueScheduled),
+	}
+	if taskID != "" {
+		opts = append(opts, asynq.TaskID(taskID))
+	}
+	task := asynq.NewTask(TaskTypeWorkspaceSchedule, data, opts...)
+	info, err := q.client.EnqueueContext(ctx, task)
+	if err != nil {
+		if isDuplicateTask(err) {
+			return &EnqueueResult{TaskID: taskID, Queue: QueueScheduled, Deduped: true}, nil
+		}
+		return nil, fmt.Errorf("failed to enqueue workspace schedule task: %w", err)
+	}
+	q.log.Info("Enqueued workspace schedule task",
+		"taskId", info.ID,
+		"workspaceId", payload.WorkspaceID,
+		"schedule", payload.Schedule,
+		"trigger", payload.Trigger)
+	return &EnqueueResult{TaskID: info.ID, Queue: QueueScheduled}, nil
+}
+
+// EnqueueDataHook 将数据钩子加入队列，任务 ID 为 payload.EventID
+func (q *Queue) EnqueueDataHook(ctx context.Context, payload *DataHookPayload) (*EnqueueResult, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal payload: %w", err)
+	}
+	task := asynq.NewTask(TaskTypeDataHook, data,
+		asynq.TaskID(payload.EventID),
+		asynq.MaxRetry(MaxRetryDataHook),
+		asynq.Timeout(DataHookTimeout),
+		asynq.Retention(dataHookRetention),
+		asynq.Queue(QueueDataHooks),
+	)
+	info, err := q.client.EnqueueContext(ctx, task)
+	if err != nil {
+		if isDuplicateTask(err) {
+			return &EnqueueResult{TaskID: payload.EventID, Queue: QueueDataHooks, Deduped: true}, nil
+		}
+		return nil, fmt.Errorf("failed to enqueue data hook task: %w", err)
+	}
+	return &EnqueueResult{TaskID: info.ID, Queue: QueueDataHooks}, nil
+}
+
 // ListDeadTasks 获取死信队列任务
 func (q *Queue) ListDeadTasks(queueName string, page, pageSize int) ([]*asynq.TaskInfo, error) {
 	return q.inspector.ListArchivedTasks(queueName, asynq.Page(page), asynq.PageSize(pageSize))
@@ -181,5 +270,5 @@ func (q *Queue) DeleteDeadTask(queueName, taskID string) error {
 }
 
 func isDuplicateTask(err error) bool {
-	return errors.Is(err, asynq.ErrDuplicateTask)
+	return errors.Is(err, asynq.ErrDuplicateTask) || errors.Is(err, asynq.ErrTaskIDConflict)
 }
@@ -22,6 +22,8 @@ type Worker struct {
 	log               logger.Logger
 	domainVerifier    DomainVerifier
 	metricsAggregator MetricsAggregator
+	scheduleRunner    ScheduleRunner
+	dataHookRunner    DataHookRunner
 }
 
 // WorkerConfig Worker 配置
@@ -43,12 +45,27 @@ type MetricsAggregator interface {
 	AggregateWorkspaceUsage(ctx context.Context, ownerID, workspaceID uuid.UUID) error
 }
 
+// ScheduleRunner 工作空间定时任务执行器
+// attempt 从 1 开始；返回错误时按 MaxRetry 重试
+type ScheduleRunner interface {
+	RunWorkspaceSchedule(ctx context.Context, payload *WorkspaceSchedulePayload, attempt int) error
+}
+
+// DataHookRunner 数据钩子执行器
+// final 表示最后一次尝试，失败后任务进入死信队列
+type DataHookRunner interface {
+	RunDataHook(ctx context.Context, payload *DataHookPayload, attempt int, final bool) error
+}
+
 // NewWorker 创建 Worker
+// 只消费已配置执行器的队列，避免与其他进程竞争取走无法处理的任务
 func NewWorker(
 	cfg *WorkerConfig,
 	log logger.Logger,
 	domainVerifier DomainVerifier,
 	metricsAggregator MetricsAggregator,
+	scheduleRunner ScheduleRunner,
+	dataHookRunner DataHookRunner,
 ) (*Worker, error) {
 	redisOpt := asynq.RedisClientOpt{
 		Addr:     cfg.RedisAddr,
@@ -62,7 +79,12 @@ func NewWorker(
 	if concurrency <= 0 {
 		concurrency = 10
 	}
-	queueWeights := normalizeQueueWeights(cfg.Queues)
+	queueWeights := normalizeQueueWeights(cfg.Queues, map[string]bool{
+		QueueDomainVerify:       domainVerifier != nil,
+		QueueMetricsAggregation: metricsAggregator != nil,
+		QueueScheduled:          scheduleRunner != nil,
+		QueueDataHooks:          dataHookRunner != nil,
+	})
 
 	server := asynq.NewServer(
 		redisOpt,
@@ -85,6 +107,8 @@ func NewWorker(
 		log:               log,
 		domainVerifier:    domainVerifier,
 		metricsAggregator: metricsAggregator,
+		scheduleRunner:    scheduleRunner,
+		dataHookRunner:    dataHookRunner,
 	}
 
 	// 注册任务处理器
@@ -94,17 +118,24 @@ func NewWorker(
 	if worker.metricsAggregator != nil {
 		worker.mux.HandleFunc(TaskTypeMetricsAggregation, worker.handleMetricsAggregation)
 	}
+	if worker.scheduleRunner != nil {
+		worker.mux.HandleFunc(TaskTypeWorkspaceSchedule, worker.handleWorkspaceSchedule)
+	}
+	if worker.dataHookRunner != nil {
+		worker.mux.HandleFunc(TaskTypeDataHook, worker.handleDataHook)
+	}
 
 	return worker, nil
 }
 
-func normalizeQueueWeights(overrides map[string]int) map[string]int {
+// normalizeQueueWeights 合并默认权重与配置覆盖，并去掉没有执行器的队列
+// 全部没有执行器时返回空映射，asynq 将只消费 default 队列
+func normalizeQueueWeights(overrides map[string]int, handled map[string]bool) map[string]int {
 	defaults := map[string]int{
 		QueueDomainVerify:       2,
 		QueueMetricsAggregation: 1,
-	}
-	if len(overrides) == 0 {
-		return defaults
+		QueueScheduled:          1,
+		QueueDataHooks:          2,
 	}
 
 	weights := map[string]int{}
@@ -116,6 +147,11 @@ func normalizeQueueWeights(overrides map[string]int) map[string]int {
 			weights[key] = value
 		}
 	}
+	for key := range weights {
+		if !handled[key] {
+			delete(weights, key)
+		}
+	}
 	return weights
 }
 
@@ -208,6 +244,45 @@ func (w *Worker) handleMetricsAggregation(ctx context.Context, task *asynq.Task)
 	return nil
 }
 
+// handleWorkspaceSchedule 处理工作空间定时任务
+func (w *Worker) handleWorkspaceSchedule(ctx context.Context, task *asynq.Task) error {
+	if w.scheduleRunner == nil {
+		return fmt.Errorf("schedule runner not configured")
+	}
+	var payload WorkspaceSchedulePayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+	retried, _ := asynq.GetRetryCount(ctx)
+	if err := w.scheduleRunner.RunWorkspaceSchedule(ctx, &payload, retried+1); err != nil {
+		if errors.Is(err, ErrTaskNoop) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// handleDataHook 处理数据钩子任务
+func (w *Worker) handleDataHook(ctx context.Context, task *asynq.Task) error {
+	if w.dataHookRunner == nil {
+		return fmt.Errorf("data hook runner not configured")
+	}
+	var payload DataHookPayload
+	if err := json.Unmarshal(task.Payload(), &payload); err != nil {
+		return fmt.Errorf("failed to unmarshal payload: %w: %w", err, asynq.SkipRetry)
+	}
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	if err := w.dataHookRunner.RunDataHook(ctx, &payload, retried+1, retried >= maxRetry); err != nil {
+		if errors.Is(err, ErrTaskNoop) {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
 const (
 	retryBaseDelay   = 500 * time.Millisecond
 	retryMaxDelay    = 10 * time.Minute
@@ -1,3 +1,85 @@
 package security
 
-// credential_manager.go -- cleaned: credential manager module never called by any service (~510 lines dead code removed).
+import (
+	"sort"
+	"strings"
+)
+
+// MinRedactableSecretLength 参与脱敏的最短密钥长度
+// 过短的值（如 "1"、"on"）替换会误伤普通文本，且本身不具备保密价值
+const MinRedactableSecretLength = 4
+
+// SecretRedactor 密钥明文脱敏器
+// 将文本中出现的密钥明文替换为 [REDACTED:NAME]，用于日志、运行时事件和 Agent 工具输出
+type SecretRedactor struct {
+	replacer *strings.Replacer
+}
+
+// NewSecretRedactor 根据密钥名称到明文的映射创建脱敏器
+// 较长的值优先替换，避免一个密钥是另一个密钥子串时只替换了一部分
+func NewSecretRedactor(secrets map[string]string) *SecretRedactor {
+	names := make([]string, 0, len(secrets))
+	for name, value := range secrets {
+		if len(value) >= MinRedactableSecretLength {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return &SecretRedactor{}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(secrets[names[i]]) != len(secrets[names[j]]) {
+			return len(secrets[names[i]]) > len(secrets[names[j]])
+		}
+		return names[i] < names[j]
+	})
+	pairs := make([]string, 0, len(names)*2)
+	for _, name := range names {
+		pairs = append(pairs, secrets[name], "[REDACTED:"+name+"]")
+	}
+	return &SecretRedactor{replacer: strings.NewReplacer(pairs...)}
+}
+
+// Empty 是否没有需要脱敏的密钥
+func (r *SecretRedactor) Empty() bool {
+	return r == nil || r.replacer == nil
+}
+
+// Redact 替换文本中的密钥明文
+func (r *SecretRedactor) Redact(text string) string {
+	if r.Empty() || text == "" {
+		return text
+	}
+	return r.replacer.Replace(text)
+}
+
+// RedactValue 递归替换 map / slice / string 中的密钥明文，返回新值，不修改入参
+func (r *SecretRedactor) RedactValue(value interface{}) interface{} {
+	if r.Empty() {
+		return value
+	}
+	switch v := value.(type) {
+	case string:
+		return r.Redact(v)
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			out[key] = r.RedactValue(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = r.RedactValue(item)
+		}
+		return out
+	case []string:
+		out := make([]string, len(v))
+		for i, item := range v {
+			out[i] = r.Redact(item)
+		}
+		return out
+	default:
+		return value
+	}
+}
@@ -22,6 +22,12 @@ const (
 
 	// 批量消息最大数量 (限制批量以减少延迟)
 	maxBatchSize = 10
+
+	// CloseTokenExpired 连接凭证过期时的关闭码，客户端应换新 Token 重连
+	CloseTokenExpired = 4001
+
+	// CloseSessionRevoked 连接所属会话被撤销时的关闭码，客户端应重新登录
+	CloseSessionRevoked = 4003
 )
 
 // NewClient 创建新的客户端
@@ -33,6 +39,16 @@ func NewClient(id, userID string, conn *websocket.Conn, hub *Hub) *Client {
 		Hub:    hub,
 		Send:   make(chan []byte, 256),
 		Rooms:  make(map[string]bool),
+		close:  make(chan []byte, 1),
+	}
+}
+
+// Close 发送关闭帧后断开连接，ReadPump 随之退出并注销客户端
+func (c *Client) Close(code int, reason string) {
+	select {
+	case c.close <- websocket.FormatCloseMessage(code, reason):
+	default:
+		// 已在关闭中
 	}
 }
 
@@ -73,6 +89,13 @@ func (c *Client) WritePump() {
 		c.Conn.Close()
 	}()
 
+	var expired <-chan time.Time
+	if !c.ExpiresAt.IsZero() {
+		timer := time.NewTimer(time.Until(c.ExpiresAt))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
 	for {
 		select {
 		case message, ok := <-c.Send:
@@ -114,6 +137,17 @@ func (c *Client) WritePump() {
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+
+		case <-expired:
+			// 凭证过期，关闭连接后 ReadPump 随之退出并注销客户端
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(CloseTokenExpired, "token expired"))
+			return
+
+		case msg := <-c.close:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.WriteMessage(websocket.CloseMessage, msg)
+			return
 		}
 	}
 }
@@ -127,6 +161,16 @@ type ClientMessage struct {
 // SubscribePayload 订阅载荷
 type SubscribePayload struct {
 	ExecutionID string `json:"executionId"`
+	// Channel 订阅的频道（如 workspace:<id>:table:<name>），优先于 ExecutionID
+	Channel string `json:"channel"`
+}
+
+// room 返回载荷指定的房间
+func (p SubscribePayload) room() string {
+	if p.Channel != "" {
+		return p.Channel
+	}
+	return p.ExecutionID
 }
 
 func (c *Client) handleMessage(data []byte) {
@@ -150,8 +194,24 @@ func (c *Client) handleMessage(data []byte) {
 				"error", err)
 			return
 		}
-		if payload.ExecutionID != "" {
-			c.Hub.Subscribe(c, payload.ExecutionID)
+		room := payload.room()
+		if room == "" {
+			return
+		}
+		if err := c.Hub.Subscribe(c, room); err != nil {
+			c.SendMessage(&Message{
+				Type:      MessageTypeError,
+				Payload:   SubscriptionPayload{Channel: room, Error: err.Error()},
+				Timestamp: time.Now(),
+			})
+			return
+		}
+		if payload.Channel != "" {
+			c.SendMessage(&Message{
+				Type:      MessageTypeSubscribed,
+				Payload:   SubscriptionPayload{Channel: room},
+				Timestamp: time.Now(),
+			})
 		}
 
 	case "unsubscribe":
@@ -159,8 +219,8 @@ func (c *Client) handleMessage(data []byte) {
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
 			return
 		}
-		if payload.ExecutionID != "" {
-			c.Hub.Unsubscribe(c, payload.ExecutionID)
+		if room := payload.room(); room != "" {
+			c.Hub.Unsubscribe(c, room)
 		}
 	}
 }
@@ -173,11 +233,7 @@ func (c *Client) sendPong() {
 	}
 
 	data, _ := json.Marshal(msg)
-	select {
-	case c.Send <- data:
-	default:
-		// 发送缓冲区满
-	}
+	c.trySend(data)
 }
 
 // SendMessage 发送消息给客户端
@@ -187,10 +243,21 @@ func (c *Client) SendMessage(msg *Message) error {
 		return err
 	}
 
+	c.trySend(data) // 缓冲区满或连接已注销时丢弃消息
+	return nil
+}
+
+// trySend 非阻塞写入发送缓冲区，通道已被 Hub 关闭时不再写入
+func (c *Client) trySend(data []byte) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closed {
+		return false
+	}
 	select {
 	case c.Send <- data:
-		return nil
+		return true
 	default:
-		return nil // 缓冲区满，丢弃消息
+		return false
 	}
 }
@@ -2,6 +2,7 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -37,6 +38,13 @@ const (
 	MessageTypeExecutionLog           MessageType = "execution.log"
 	MessageTypeExecutionProgress      MessageType = "execution.progress"
 
+	// 实时数据消息类型
+	MessageTypeSubscribed  MessageType = "subscribed"
+	MessageTypeTableChange MessageType = "table.change"
+
+	// Agent 运行事件（payload 为带 seq 的 AgentEvent）
+	MessageTypeAgentEvent MessageType = "agent.event"
+
 	// 系统消息类型
 	MessageTypePing  MessageType = "ping"
 	MessageTypePong  MessageType = "pong"
@@ -75,6 +83,22 @@ type LogPayload struct {
 	Timestamp   time.Time `json:"timestamp"`
 }
 
+// TableChangePayload 数据表变更载荷
+type TableChangePayload struct {
+	Channel         string                 `json:"channel"`
+	Table           string                 `json:"table"`
+	Type            string                 `json:"type"` // insert / update / delete
+	Record          map[string]interface{} `json:"record,omitempty"`
+	OldRecord       map[string]interface{} `json:"oldRecord,omitempty"`
+	CommitTimestamp time.Time              `json:"commitTimestamp"`
+}
+
+// SubscriptionPayload 订阅结果载荷
+type SubscriptionPayload struct {
+	Channel string `json:"channel"`
+	Error   string `json:"error,omitempty"`
+}
+
 // Client WebSocket 客户端
 type Client struct {
 	ID     string
@@ -83,7 +107,15 @@ type Client struct {
 	Hub    *Hub
 	Send   chan []byte
 	Rooms  map[string]bool // 订阅的房间
-	mu     sync.RWMutex
+	// Authorize 校验客户端能否订阅房间，设置时代替 Hub 的房间鉴权
+	Authorize func(room string) error
+	// ExpiresAt 连接凭证的过期时间，到期后服务端关闭连接；零值不过期
+	ExpiresAt time.Time
+	// Session 创建方附加的连接信息（如运行时用户），发布方据此过滤消息
+	Session interface{}
+	close   chan []byte // Close 请求的关闭帧
+	closed  bool        // Send 已关闭
+	mu      sync.RWMutex
 }
 
 // Hub WebSocket 连接管理中心
@@ -115,13 +147,26 @@ type Hub struct {
 	// 延迟监控指标
 	metrics *LatencyMetrics
 
+	// 房间鉴权，客户端未设置 Authorize 时使用
+	authorizer RoomAuthorizer
+
+	// 每个连接最多订阅的房间数，0 为不限制
+	maxSubscriptions int
+
 	mu sync.RWMutex
 }
 
+// RoomAuthorizer 校验客户端能否订阅房间，返回错误时拒绝订阅
+type RoomAuthorizer func(client *Client, room string) error
+
+// ErrTooManySubscriptions 连接订阅的房间数已达上限
+var ErrTooManySubscriptions = errors.New("too many subscriptions on this connection")
+
 // BroadcastMessage 广播消息
 type BroadcastMessage struct {
-	Room      string // 目标房间 (executionId)，为空则广播给所有人
-	UserID    string // 目标用户，为空则广播给房间内所有人
+	Room      string    // 目标房间 (executionId)，为空则广播给所有人
+	UserID    string    // 目标用户，为空则广播给房间内所有人
+	Clients   []*Client // 目标客户端，非空时只发送给其中仍在线的客户端
 	Message   []byte
 	CreatedAt time.Time // 消息创建时间，用于延迟监控
 	Priority  bool      // 是否高优先级(执行事件)
@@ -244,7 +289,10 @@ func (h *Hub) unregisterClient(client *Client) {
 
 	if _, ok := h.clients[client]; ok {
 		delete(h.clients, client)
+		client.mu.Lock()
+		client.closed = true
 		close(client.Send)
+		client.mu.Unlock()
 
 		// 从用户客户端列表中移除
 		clients := h.userClients[client.UserID]
@@ -273,60 +321,92 @@ func (h *Hub) unregisterClient(client *Client) {
 }
 
 func (h *Hub) broadcastMessage(msg *BroadcastMessage) {
+	// 发送缓冲区满的客户端在释放读锁后注销，避免读锁下修改映射或重复关闭通道
+	var slow []*Client
 	h.mu.RLock()
-	defer h.mu.RUnlock()
+	send := func(client *Client) {
+		if !client.trySend(msg.Message) {
+			slow = append(slow, client)
+		}
+	}
 
-	// 如果指定了房间，只发送给房间内的客户端
-	if msg.Room != "" {
-		if roomClients, ok := h.rooms[msg.Room]; ok {
-			for client := range roomClients {
-				// 如果指定了用户 ID，只发送给该用户
-				if msg.UserID != "" && client.UserID != msg.UserID {
-					continue
-				}
-				select {
-				case client.Send <- msg.Message:
-				default:
-					// 发送缓冲区满，关闭连接
-					close(client.Send)
-					delete(h.clients, client)
-				}
+	switch {
+	case len(msg.Clients) > 0:
+		// 只发送给指定且仍在线的客户端
+		for _, client := range msg.Clients {
+			if h.clients[client] {
+				send(client)
 			}
 		}
-		return
-	}
-
-	// 如果只指定了用户 ID，发送给该用户的所有连接
-	if msg.UserID != "" {
-		if clients, ok := h.userClients[msg.UserID]; ok {
-			for _, client := range clients {
-				select {
-				case client.Send <- msg.Message:
-				default:
-					close(client.Send)
-					delete(h.clients, client)
-				}
+
+	case msg.Room != "":
+		// 如果指定了房间，只发送给房间内的客户端
+		for client := range h.rooms[msg.Room] {
+			// 如果指定了用户 ID，只发送给该用户
+			if msg.UserID != "" && client.UserID != msg.UserID {
+				continue
 			}
+			send(client)
 		}
-		return
-	}
 
-	// 广播给所有人
-	for client := range h.clients {
-		select {
-		case client.Send <- msg.Message:
-		default:
-			close(client.Send)
-			delete(h.clients, client)
+	case msg.UserID != "":
+		// 如果只指定了用户 ID，发送给该用户的所有连接
+		for _, client := range h.userClients[msg.UserID] {
+			send(client)
 		}
+
+	default:
+		// 广播给所有人
+		for client := range h.clients {
+			send(client)
+		}
+	}
+	h.mu.RUnlock()
+
+	for _, client := range slow {
+		h.log.Warn("WebSocket send buffer full, disconnecting client",
+			"clientId", client.ID,
+			"room", msg.Room)
+		h.unregisterClient(client)
 	}
 }
 
-// Subscribe 订阅房间 (执行 ID)
-func (h *Hub) Subscribe(client *Client, room string) {
+// SetAuthorizer 设置房间鉴权，需在 Run 之前调用
+func (h *Hub) SetAuthorizer(authorizer RoomAuthorizer) {
+	h.authorizer = authorizer
+}
+
+// SetMaxSubscriptions 设置每个连接最多订阅的房间数，需在 Run 之前调用
+func (h *Hub) SetMaxSubscriptions(n int) {
+	h.maxSubscriptions = n
+}
+
+// Subscribe 订阅房间
+// 先经客户端的 Authorize（未设置时为 Hub 的房间鉴权）校验，再检查连接的订阅数上限
+func (h *Hub) Subscribe(client *Client, room string) error {
+	authorize := client.Authorize
+	if authorize == nil && h.authorizer != nil {
+		authorize = func(room string) error { return h.authorizer(client, room) }
+	}
+	if authorize != nil {
+		if err := authorize(room); err != nil {
+			return err
+		}
+	}
+
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	client.mu.Lock()
+	subscribed, count := client.Rooms[room], len(client.Rooms)
+	client.mu.Unlock()
+	if subscribed {
+		return nil
+	}
+	if h.maxSubscriptions > 0 && count >= h.maxSubscriptions {
+		return ErrTooManySubscriptions
+	}
+
 	if _, ok := h.rooms[room]; !ok {
 		h.rooms[room] = make(map[*Client]bool)
 	}
@@ -339,6 +419,7 @@ func (h *Hub) Subscribe(client *Client, room string) {
 	h.log.Debug("Client subscribed to room",
 		"clientId", client.ID,
 		"room", room)
+	return nil
 }
 
 // Unsubscribe 取消订阅房间
@@ -460,6 +541,72 @@ func (h *Hub) BroadcastExecutionEvent(executionID string, msgType MessageType, p
 	}
 }
 
+// AgentRoom 返回 Agent 会话的房间名
+func AgentRoom(sessionID string) string {
+	return "agent:" + sessionID
+}
+
+// BroadcastAgentEvent 广播 Agent 运行事件 (使用高优先级通道)
+func (h *Hub) BroadcastAgentEvent(sessionID string, payload interface{}) {
+	msg := &Message{
+		Type:      MessageTypeAgentEvent,
+		Payload:   payload,
+		Timestamp: time.Now(),
+	}
+
+	if err := h.SendToRoomPriority(AgentRoom(sessionID), msg); err != nil {
+		h.log.Error("Failed to broadcast agent event",
+			"sessionId", sessionID,
+			"error", err)
+	}
+}
+
+// RoomClients 获取房间内的客户端
+func (h *Hub) RoomClients(room string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	clients := make([]*Client, 0, len(h.rooms[room]))
+	for client := range h.rooms[room] {
+		clients = append(clients, client)
+	}
+	return clients
+}
+
+// UserClients 获取用户的全部客户端
+func (h *Hub) UserClients(userID string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append([]*Client(nil), h.userClients[userID]...)
+}
+
+// SendToClients 发送消息给指定客户端（已断开的客户端会被跳过）
+func (h *Hub) SendToClients(clients []*Client, msg *Message) error {
+	if len(clients) == 0 {
+		return nil
+	}
+	// 添加发送时间戳
+	msg.SentAt = time.Now().UnixMilli()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	broadcastMsg := &BroadcastMessage{
+		Clients:   clients,
+		Message:   data,
+		CreatedAt: time.Now(),
+	}
+
+	select {
+	case h.broadcast <- broadcastMsg:
+	default:
+		h.log.Warn("Broadcast channel full, dropping client message",
+			"clientCount", len(clients))
+	}
+	return nil
+}
+
 // GetClientCount 获取客户端数量
 func (h *Hub) GetClientCount() int {
 	h.mu.RLock()
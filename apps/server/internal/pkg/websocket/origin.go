@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// OriginAllowed 校验 WebSocket 握手的 Origin
+// 没有 Origin 的非浏览器客户端与同源请求直接放行，其余须匹配 patterns 之一
+func OriginAllowed(r *http.Request, patterns ...[]string) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, list := range patterns {
+		for _, pattern := range list {
+			if MatchOrigin(origin, pattern) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MatchOrigin 判断 Origin 是否匹配模式
+// 支持 "*"、完整 Origin（https://app.example.com）、任意端口（http://localhost:*）
+// 与子域名通配（https://*.example.com，不含根域名本身）；模式不带协议时匹配任意协议
+func MatchOrigin(origin, pattern string) bool {
+	pattern = strings.TrimRight(strings.TrimSpace(pattern), "/")
+	if pattern == "" {
+		return false
+	}
+	if pattern == "*" {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	pattern = strings.ToLower(pattern)
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := u.Host
+	if scheme, rest, ok := strings.Cut(pattern, "://"); ok {
+		if scheme != u.Scheme {
+			return false
+		}
+		pattern = rest
+	}
+
+	if hostPattern, ok := strings.CutSuffix(pattern, ":*"); ok {
+		pattern = hostPattern
+		host = u.Hostname()
+	}
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == pattern
+}
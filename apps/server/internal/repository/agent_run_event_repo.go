@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// AgentRunEventRepository Agent 运行事件仓储接口
+type AgentRunEventRepository interface {
+	Create(ctx context.Context, event *entity.AgentRunEvent) error
+	// ListAfter 按编号升序列出会话中 seq 大于 afterSeq 的事件
+	ListAfter(ctx context.Context, sessionID string, afterSeq int64, limit int) ([]entity.AgentRunEvent, error)
+	// MaxSeq 返回会话最后一个事件的编号，没有事件时为 0
+	MaxSeq(ctx context.Context, sessionID string) (int64, error)
+	DeleteBySession(ctx context.Context, sessionID string) error
+}
+
+type agentRunEventRepository struct {
+	db *gorm.DB
+}
+
+func NewAgentRunEventRepository(db *gorm.DB) AgentRunEventRepository {
+	return &agentRunEventRepository{db: db}
+}
+
+func (r *agentRunEventRepository) Create(ctx context.Context, event *entity.AgentRunEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+func (r *agentRunEventRepository) ListAfter(ctx context.Context, sessionID string, afterSeq int64, limit int) ([]entity.AgentRunEvent, error) {
+	var events []entity.AgentRunEvent
+	err := r.db.WithContext(ctx).
+		Where("session_id = ? AND seq > ?", sessionID, afterSeq).
+		Order("seq ASC").
+		Limit(limit).
+		Find(&events).Error
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *agentRunEventRepository) MaxSeq(ctx context.Context, sessionID string) (int64, error) {
+	var seq int64
+	err := r.db.WithContext(ctx).
+		Model(&entity.AgentRunEvent{}).
+		Where("session_id = ?", sessionID).
+		Select("COALESCE(MAX(seq), 0)").
+		Scan(&seq).Error
+	return seq, err
+}
+
+func (r *agentRunEventRepository) DeleteBySession(ctx context.Context, sessionID string) error {
+	return r.db.WithContext(ctx).Where("session_id = ?", sessionID).Delete(&entity.AgentRunEvent{}).Error
+}
@@ -0,0 +1,124 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// appSessionType 应用用户登录会话的 session_type
+const appSessionType = "auth"
+
+// AppSessionRepository 应用用户登录会话仓储接口（workspace_sessions 中 session_type=auth 的记录）
+type AppSessionRepository interface {
+	Create(ctx context.Context, session *entity.WorkspaceSession) error
+	GetByID(ctx context.Context, id uuid.UUID) (*entity.WorkspaceSession, error)
+	// GetByTokenHash 按 Token 哈希查找工作空间内的会话，不判断是否过期或撤销
+	GetByTokenHash(ctx context.Context, workspaceID uuid.UUID, tokenHash string) (*entity.WorkspaceSession, error)
+	// GetByRefreshTokenHash 按 Refresh Token 哈希查找工作空间内的会话，不判断是否过期或撤销
+	GetByRefreshTokenHash(ctx context.Context, workspaceID uuid.UUID, refreshTokenHash string) (*entity.WorkspaceSession, error)
+	// Rotate 以 session 中的新 Token 与过期时间替换会话凭证；仅当 Refresh Token 仍为 oldRefreshTokenHash 且未撤销时生效
+	Rotate(ctx context.Context, session *entity.WorkspaceSession, oldRefreshTokenHash string) (bool, error)
+	Touch(ctx context.Context, id uuid.UUID, at time.Time) error
+	// ListActiveByUser 列出应用用户未撤销且 Refresh Token 未过期的会话
+	ListActiveByUser(ctx context.Context, workspaceID, appUserID uuid.UUID, now time.Time) ([]entity.WorkspaceSession, error)
+	Revoke(ctx context.Context, id uuid.UUID, at time.Time) error
+	// RevokeByUser 撤销应用用户的全部会话，返回撤销数量
+	RevokeByUser(ctx context.Context, workspaceID, appUserID uuid.UUID, at time.Time) (int64, error)
+}
+
+type appSessionRepository struct {
+	db *gorm.DB
+}
+
+func NewAppSessionRepository(db *gorm.DB) AppSessionRepository {
+	return &appSessionRepository{db: db}
+}
+
+func (r *appSessionRepository) Create(ctx context.Context, session *entity.WorkspaceSession) error {
+	session.SessionType = appSessionType
+	return r.db.WithContext(ctx).Create(session).Error
+}
+
+func (r *appSessionRepository) GetByID(ctx context.Context, id uuid.UUID) (*entity.WorkspaceSession, error) {
+	var session entity.WorkspaceSession
+	if err := r.db.WithContext(ctx).Where("id = ? AND session_type = ?", id, appSessionType).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *appSessionRepository) GetByTokenHash(ctx context.Context, workspaceID uuid.UUID, tokenHash string) (*entity.WorkspaceSession, error) {
+	var session entity.WorkspaceSession
+	if err := r.db.WithContext(ctx).
+		Where("workspace_id = ? AND token_hash = ? AND session_type = ?", workspaceID, tokenHash, appSessionType).
+		First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *appSessionRepository) GetByRefreshTokenHash(ctx context.Context, workspaceID uuid.UUID, refreshTokenHash string) (*entity.WorkspaceSession, error) {
+	var session entity.WorkspaceSession
+	if err := r.db.WithContext(ctx).
+		Where("workspace_id = ? AND refresh_token_hash = ? AND session_type = ?", workspaceID, refreshTokenHash, appSessionType).
+		First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *appSessionRepository) Rotate(ctx context.Context, session *entity.WorkspaceSession, oldRefreshTokenHash string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&entity.WorkspaceSession{}).
+		Where("id = ? AND refresh_token_hash = ? AND revoked_at IS NULL", session.ID, oldRefreshTokenHash).
+		Updates(map[string]interface{}{
+			"token_hash":         session.TokenHash,
+			"refresh_token_hash": session.RefreshTokenHash,
+			"expired_at":         session.ExpiredAt,
+			"refresh_expired_at": session.RefreshExpiredAt,
+			"last_active_at":     session.LastActiveAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *appSessionRepository) Touch(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&entity.WorkspaceSession{}).
+		Where("id = ?", id).
+		Update("last_active_at", at).Error
+}
+
+func (r *appSessionRepository) ListActiveByUser(ctx context.Context, workspaceID, appUserID uuid.UUID, now time.Time) ([]entity.WorkspaceSession, error) {
+	var sessions []entity.WorkspaceSession
+	err := r.db.WithContext(ctx).
+		Where("workspace_id = ? AND app_user_id = ? AND session_type = ? AND revoked_at IS NULL", workspaceID, appUserID, appSessionType).
+		Where("refresh_expired_at > ? OR (refresh_expired_at IS NULL AND expired_at > ?)", now, now).
+		Order("created_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (r *appSessionRepository) Revoke(ctx context.Context, id uuid.UUID, at time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&entity.WorkspaceSession{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Updates(map[string]interface{}{"revoked_at": at, "expired_at": at}).Error
+}
+
+func (r *appSessionRepository) RevokeByUser(ctx context.Context, workspaceID, appUserID uuid.UUID, at time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Model(&entity.WorkspaceSession{}).
+		Where("workspace_id = ? AND app_user_id = ? AND session_type = ? AND revoked_at IS NULL", workspaceID, appUserID, appSessionType).
+		Updates(map[string]interface{}{"revoked_at": at, "expired_at": at})
+	return result.RowsAffected, result.Error
+}
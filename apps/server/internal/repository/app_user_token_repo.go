@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// AppUserTokenRepository 应用用户一次性令牌仓储接口
+type AppUserTokenRepository interface {
+	Create(ctx context.Context, token *entity.AppUserToken) error
+	// GetByHash 按哈希查找工作空间内指定用途的令牌，不判断是否过期或已使用
+	GetByHash(ctx context.Context, workspaceID uuid.UUID, purpose, tokenHash string) (*entity.AppUserToken, error)
+	// MarkUsed 标记令牌已使用；令牌已被使用时返回 false
+	MarkUsed(ctx context.Context, id uuid.UUID, at time.Time) (bool, error)
+	// InvalidateByUser 作废应用用户指定用途的全部未使用令牌
+	InvalidateByUser(ctx context.Context, appUserID uuid.UUID, purpose string, at time.Time) error
+}
+
+type appUserTokenRepository struct {
+	db *gorm.DB
+}
+
+func NewAppUserTokenRepository(db *gorm.DB) AppUserTokenRepository {
+	return &appUserTokenRepository{db: db}
+}
+
+func (r *appUserTokenRepository) Create(ctx context.Context, token *entity.AppUserToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+func (r *appUserTokenRepository) GetByHash(ctx context.Context, workspaceID uuid.UUID, purpose, tokenHash string) (*entity.AppUserToken, error) {
+	var token entity.AppUserToken
+	if err := r.db.WithContext(ctx).
+		Where("workspace_id = ? AND purpose = ? AND token_hash = ?", workspaceID, purpose, tokenHash).
+		First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (r *appUserTokenRepository) MarkUsed(ctx context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&entity.AppUserToken{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", at)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *appUserTokenRepository) InvalidateByUser(ctx context.Context, appUserID uuid.UUID, purpose string, at time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&entity.AppUserToken{}).
+		Where("app_user_id = ? AND purpose = ? AND used_at IS NULL", appUserID, purpose).
+		Update("used_at", at).Error
+}
@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// QueryHistoryRepository SQL 查询历史仓储接口
+type QueryHistoryRepository interface {
+	Create(ctx context.Context, item *entity.QueryHistory) error
+	GetByID(ctx context.Context, id uuid.UUID) (*entity.QueryHistory, error)
+	List(ctx context.Context, params QueryHistoryListParams) ([]entity.QueryHistory, int64, error)
+	Update(ctx context.Context, item *entity.QueryHistory) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	// Prune 删除未固定的记录：早于 before 的，以及超出最新 keep 条的
+	Prune(ctx context.Context, workspaceID uuid.UUID, keep int, before time.Time) (int64, error)
+}
+
+// QueryHistoryListParams 查询历史查询参数
+type QueryHistoryListParams struct {
+	WorkspaceID uuid.UUID
+	UserID      *uuid.UUID
+	Search      string
+	Status      string
+	Pinned      *bool
+	Page        int
+	PageSize    int
+}
+
+type queryHistoryRepository struct {
+	db *gorm.DB
+}
+
+// NewQueryHistoryRepository 创建查询历史仓储实例
+func NewQueryHistoryRepository(db *gorm.DB) QueryHistoryRepository {
+	return &queryHistoryRepository{db: db}
+}
+
+func (r *queryHistoryRepository) Create(ctx context.Context, item *entity.QueryHistory) error {
+	return r.db.WithContext(ctx).Create(item).Error
+}
+
+func (r *queryHistoryRepository) GetByID(ctx context.Context, id uuid.UUID) (*entity.QueryHistory, error) {
+	var item entity.QueryHistory
+	if err := r.db.WithContext(ctx).Where("id = ?", id).First(&item).Error; err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func (r *queryHistoryRepository) List(ctx context.Context, params QueryHistoryListParams) ([]entity.QueryHistory, int64, error) {
+	var items []entity.QueryHistory
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&entity.QueryHistory{}).Where("workspace_id = ?", params.WorkspaceID)
+	if params.UserID != nil {
+		query = query.Where("user_id = ?", *params.UserID)
+	}
+	if params.Status != "" {
+		query = query.Where("status = ?", params.Status)
+	}
+	if params.Pinned != nil {
+		query = query.Where("pinned = ?", *params.Pinned)
+	}
+	if search := strings.TrimSpace(params.Search); search != "" {
+		like := "%" + escapeLike(search) + "%"
+		// MySQL 字符串字面量中 '\\' 表示单个反斜杠
+		query = query.Where(`(sql_text LIKE ? ESCAPE '\\' OR name LIKE ? ESCAPE '\\')`, like, like)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	page := params.Page
+	pageSize := params.PageSize
+	if page <= 0 {
+		page = 1
+	}
+	if pageSize <= 0 {
+		pageSize = 20
+	}
+
+	offset := (page - 1) * pageSize
+	if err := query.Order("created_at DESC").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&items).Error; err != nil {
+		return nil, 0, err
+	}
+	return items, total, nil
+}
+
+func (r *queryHistoryRepository) Update(ctx context.Context, item *entity.QueryHistory) error {
+	return r.db.WithContext(ctx).Save(item).Error
+}
+
+func (r *queryHistoryRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&entity.QueryHistory{}, "id = ?", id).Error
+}
+
+func (r *queryHistoryRepository) Prune(ctx context.Context, workspaceID uuid.UUID, keep int, before time.Time) (int64, error) {
+	unpinned := func() *gorm.DB {
+		return r.db.WithContext(ctx).Where("workspace_id = ? AND pinned = ?", workspaceID, false)
+	}
+
+	var deleted int64
+	if !before.IsZero() {
+		result := unpinned().Where("created_at < ?", before).Delete(&entity.QueryHistory{})
+		if result.Error != nil {
+			return deleted, result.Error
+		}
+		deleted += result.RowsAffected
+	}
+	if keep > 0 {
+		var oldest entity.QueryHistory
+		err := unpinned().Model(&entity.QueryHistory{}).
+			Select("created_at").
+			Order("created_at DESC").
+			Offset(keep - 1).
+			Take(&oldest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return deleted, nil
+		}
+		if err != nil {
+			return deleted, err
+		}
+		result := unpinned().Where("created_at < ?", oldest.CreatedAt).Delete(&entity.QueryHistory{})
+		if result.Error != nil {
+			return deleted, result.Error
+		}
+		deleted += result.RowsAffected
+	}
+	return deleted, nil
+}
+
+// likeEscaper 转义 LIKE 通配符，使搜索词按字面匹配
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+// escapeLike 转义 s 中的 LIKE 通配符与转义符，配合 ESCAPE '\' 使用
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
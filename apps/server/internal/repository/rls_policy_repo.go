@@ -48,7 +48,7 @@ func (r *rlsPolicyRepository) ListByWorkspace(ctx context.Context, workspaceID u
 
 func (r *rlsPolicyRepository) ListByTable(ctx context.Context, workspaceID uuid.UUID, tableName string) ([]entity.RLSPolicy, error) {
 	var policies []entity.RLSPolicy
-	if err := r.db.WithContext(ctx).Where("workspace_id = ? AND table_name = ? AND enabled = ?", workspaceID, tableName, true).Find(&policies).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("workspace_id = ? AND LOWER(table_name) = LOWER(?) AND enabled = ?", workspaceID, tableName, true).Find(&policies).Error; err != nil {
 		return nil, err
 	}
 	return policies, nil
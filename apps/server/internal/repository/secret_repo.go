@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// SecretRepository 工作空间密钥仓储接口
+type SecretRepository interface {
+	Create(ctx context.Context, secret *entity.Secret) error
+	GetByName(ctx context.Context, workspaceID uuid.UUID, name string) (*entity.Secret, error)
+	ListByWorkspace(ctx context.Context, workspaceID uuid.UUID) ([]entity.Secret, error)
+	CountByWorkspace(ctx context.Context, workspaceID uuid.UUID) (int64, error)
+	Update(ctx context.Context, secret *entity.Secret) error
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+type secretRepository struct {
+	db *gorm.DB
+}
+
+func NewSecretRepository(db *gorm.DB) SecretRepository {
+	return &secretRepository{db: db}
+}
+
+func (r *secretRepository) Create(ctx context.Context, secret *entity.Secret) error {
+	return r.db.WithContext(ctx).Create(secret).Error
+}
+
+func (r *secretRepository) GetByName(ctx context.Context, workspaceID uuid.UUID, name string) (*entity.Secret, error) {
+	var secret entity.Secret
+	if err := r.db.WithContext(ctx).Where("workspace_id = ? AND name = ?", workspaceID, name).First(&secret).Error; err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+func (r *secretRepository) ListByWorkspace(ctx context.Context, workspaceID uuid.UUID) ([]entity.Secret, error) {
+	var secrets []entity.Secret
+	if err := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("name ASC").Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func (r *secretRepository) CountByWorkspace(ctx context.Context, workspaceID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&entity.Secret{}).Where("workspace_id = ?", workspaceID).Count(&count).Error
+	return count, err
+}
+
+func (r *secretRepository) Update(ctx context.Context, secret *entity.Secret) error {
+	return r.db.WithContext(ctx).Save(secret).Error
+}
+
+func (r *secretRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&entity.Secret{}, "id = ?", id).Error
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// DataHookRunFilter 数据钩子执行记录过滤条件
+type DataHookRunFilter struct {
+	Table   string
+	Hook    string
+	Status  string
+	EventID *uuid.UUID
+	Limit   int
+}
+
+// WorkspaceDataHookRepository 数据钩子执行记录仓储接口
+type WorkspaceDataHookRepository interface {
+	CreateRun(ctx context.Context, run *entity.WorkspaceDataHookRun) error
+	// ListRuns 按创建时间倒序列出工作空间的执行记录
+	ListRuns(ctx context.Context, workspaceID uuid.UUID, filter DataHookRunFilter) ([]entity.WorkspaceDataHookRun, error)
+	// PruneRuns 每个工作空间只保留最近 keep 条执行记录
+	PruneRuns(ctx context.Context, workspaceID uuid.UUID, keep int) error
+}
+
+type workspaceDataHookRepository struct {
+	db *gorm.DB
+}
+
+func NewWorkspaceDataHookRepository(db *gorm.DB) WorkspaceDataHookRepository {
+	return &workspaceDataHookRepository{db: db}
+}
+
+func (r *workspaceDataHookRepository) CreateRun(ctx context.Context, run *entity.WorkspaceDataHookRun) error {
+	return r.db.WithContext(ctx).Create(run).Error
+}
+
+func (r *workspaceDataHookRepository) ListRuns(ctx context.Context, workspaceID uuid.UUID, filter DataHookRunFilter) ([]entity.WorkspaceDataHookRun, error) {
+	query := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID)
+	if filter.Table != "" {
+		query = query.Where("table_name = ?", filter.Table)
+	}
+	if filter.Hook != "" {
+		query = query.Where("hook = ?", filter.Hook)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.EventID != nil {
+		query = query.Where("event_id = ?", *filter.EventID)
+	}
+	var runs []entity.WorkspaceDataHookRun
+	if err := query.Order("created_at DESC").Limit(filter.Limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *workspaceDataHookRepository) PruneRuns(ctx context.Context, workspaceID uuid.UUID, keep int) error {
+	var cutoff entity.WorkspaceDataHookRun
+	err := r.db.WithContext(ctx).
+		Where("workspace_id = ?", workspaceID).
+		Order("created_at DESC").
+		Offset(keep - 1).
+		Limit(1).
+		Find(&cutoff).Error
+	if err != nil || cutoff.ID == uuid.Nil {
+		return err
+	}
+	return r.db.WithContext(ctx).
+		Where("workspace_id = ? AND created_at < ?", workspaceID, cutoff.CreatedAt).
+		Delete(&entity.WorkspaceDataHookRun{}).Error
+}
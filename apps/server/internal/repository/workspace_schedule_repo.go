@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// WorkspaceScheduleRepository 工作空间定时任务仓储接口
+type WorkspaceScheduleRepository interface {
+	Create(ctx context.Context, schedule *entity.WorkspaceSchedule) error
+	GetByID(ctx context.Context, id uuid.UUID) (*entity.WorkspaceSchedule, error)
+	GetByCron(ctx context.Context, workspaceID uuid.UUID, cron string) (*entity.WorkspaceSchedule, error)
+	ListByWorkspace(ctx context.Context, workspaceID uuid.UUID) ([]entity.WorkspaceSchedule, error)
+	// ListEnabled 列出所有工作空间中启用的定时任务（调度循环使用）
+	ListEnabled(ctx context.Context) ([]entity.WorkspaceSchedule, error)
+	SetEnabled(ctx context.Context, id uuid.UUID, enabled bool) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	// TryAcquire 在没有执行中的任务（或执行中的任务早于 staleBefore）时标记 runID 为执行中
+	TryAcquire(ctx context.Context, id, runID uuid.UUID, now, staleBefore time.Time) (bool, error)
+	// Release 清除 runID 的执行中标记并记录最近一次执行结果
+	Release(ctx context.Context, id, runID uuid.UUID, finishedAt time.Time, status string) error
+
+	CreateRun(ctx context.Context, run *entity.WorkspaceScheduleRun) error
+	UpdateRun(ctx context.Context, run *entity.WorkspaceScheduleRun) error
+	// ListRuns 按创建时间倒序列出执行记录，scheduleID 为空表示全部定时任务
+	ListRuns(ctx context.Context, workspaceID uuid.UUID, scheduleID *uuid.UUID, limit int) ([]entity.WorkspaceScheduleRun, error)
+	// PruneRuns 每个定时任务只保留最近 keep 条执行记录
+	PruneRuns(ctx context.Context, scheduleID uuid.UUID, keep int) error
+}
+
+type workspaceScheduleRepository struct {
+	db *gorm.DB
+}
+
+func NewWorkspaceScheduleRepository(db *gorm.DB) WorkspaceScheduleRepository {
+	return &workspaceScheduleRepository{db: db}
+}
+
+func (r *workspaceScheduleRepository) Create(ctx context.Context, schedule *entity.WorkspaceSchedule) error {
+	return r.db.WithContext(ctx).Create(schedule).Error
+}
+
+func (r *workspaceScheduleRepository) GetByID(ctx context.Context, id uuid.UUID) (*entity.WorkspaceSchedule, error) {
+	var schedule entity.WorkspaceSchedule
+	if err := r.db.WithContext(ctx).First(&schedule, "id = ?", id).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (r *workspaceScheduleRepository) GetByCron(ctx context.Context, workspaceID uuid.UUID, cron string) (*entity.WorkspaceSchedule, error) {
+	var schedule entity.WorkspaceSchedule
+	if err := r.db.WithContext(ctx).Where("workspace_id = ? AND cron = ?", workspaceID, cron).First(&schedule).Error; err != nil {
+		return nil, err
+	}
+	return &schedule, nil
+}
+
+func (r *workspaceScheduleRepository) ListByWorkspace(ctx context.Context, workspaceID uuid.UUID) ([]entity.WorkspaceSchedule, error) {
+	var schedules []entity.WorkspaceSchedule
+	if err := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID).Order("cron ASC").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (r *workspaceScheduleRepository) ListEnabled(ctx context.Context) ([]entity.WorkspaceSchedule, error) {
+	var schedules []entity.WorkspaceSchedule
+	if err := r.db.WithContext(ctx).Where("enabled = ?", true).Order("workspace_id ASC").Find(&schedules).Error; err != nil {
+		return nil, err
+	}
+	return schedules, nil
+}
+
+func (r *workspaceScheduleRepository) SetEnabled(ctx context.Context, id uuid.UUID, enabled bool) error {
+	return r.db.WithContext(ctx).Model(&entity.WorkspaceSchedule{}).Where("id = ?", id).Update("enabled", enabled).Error
+}
+
+func (r *workspaceScheduleRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&entity.WorkspaceSchedule{}, "id = ?", id).Error
+}
+
+func (r *workspaceScheduleRepository) TryAcquire(ctx context.Context, id, runID uuid.UUID, now, staleBefore time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&entity.WorkspaceSchedule{}).
+		Where("id = ? AND (running_run_id IS NULL OR running_since < ?)", id, staleBefore).
+		Updates(map[string]interface{}{"running_run_id": runID, "running_since": now})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *workspaceScheduleRepository) Release(ctx context.Context, id, runID uuid.UUID, finishedAt time.Time, status string) error {
+	return r.db.WithContext(ctx).Model(&entity.WorkspaceSchedule{}).
+		Where("id = ? AND running_run_id = ?", id, runID).
+		Updates(map[string]interface{}{
+			"running_run_id": nil,
+			"running_since":  nil,
+			"last_run_at":    finishedAt,
+			"last_status":    status,
+		}).Error
+}
+
+func (r *workspaceScheduleRepository) CreateRun(ctx context.Context, run *entity.WorkspaceScheduleRun) error {
+	return r.db.WithContext(ctx).Create(run).Error
+}
+
+func (r *workspaceScheduleRepository) UpdateRun(ctx context.Context, run *entity.WorkspaceScheduleRun) error {
+	return r.db.WithContext(ctx).Save(run).Error
+}
+
+func (r *workspaceScheduleRepository) ListRuns(ctx context.Context, workspaceID uuid.UUID, scheduleID *uuid.UUID, limit int) ([]entity.WorkspaceScheduleRun, error) {
+	query := r.db.WithContext(ctx).Where("workspace_id = ?", workspaceID)
+	if scheduleID != nil {
+		query = query.Where("schedule_id = ?", *scheduleID)
+	}
+	var runs []entity.WorkspaceScheduleRun
+	if err := query.Order("created_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *workspaceScheduleRepository) PruneRuns(ctx context.Context, scheduleID uuid.UUID, keep int) error {
+	var cutoff entity.WorkspaceScheduleRun
+	err := r.db.WithContext(ctx).
+		Where("schedule_id = ?", scheduleID).
+		Order("created_at DESC").
+		Offset(keep - 1).
+		Limit(1).
+		Find(&cutoff).Error
+	if err != nil || cutoff.ID == uuid.Nil {
+		return err
+	}
+	return r.db.WithContext(ctx).
+		Where("schedule_id = ? AND created_at < ?", scheduleID, cutoff.CreatedAt).
+		Delete(&entity.WorkspaceScheduleRun{}).Error
+}
@@ -46,6 +46,8 @@ type AgentEvent struct {
 	Error            string           `json:"error,omitempty"`
 	SessionID        string           `json:"session_id,omitempty"`
 	AffectedResource AffectedResource `json:"affected_resource,omitempty"`
+	// Seq 事件在会话中的编号，由 AgentRunService 分配，客户端据此去重与续传
+	Seq int64 `json:"seq,omitempty"`
 }
 
 // AgentEngineConfig Agent 引擎配置
@@ -502,6 +504,9 @@ func (e *agentEngine) Confirm(ctx context.Context, sessionID, actionID string, a
 	}
 
 	// Execute the confirmed tool
+	if GetTaskContext(ctx) == nil {
+		ctx = WithTaskContext(ctx, &TaskContext{WorkspaceID: session.WorkspaceID, UserID: session.UserID})
+	}
 	result, err := e.registry.Execute(ctx, pending.ToolName, pending.ToolArgs)
 	if err != nil {
 		result = &AgentToolResult{Success: false, Error: err.Error()}
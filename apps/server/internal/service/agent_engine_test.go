@@ -533,3 +533,29 @@ func TestCallLLM_EngineConfigKey_SkipsHeuristic(t *testing.T) {
 		t.Fatal("callLLM with invalid endpoint should return error, not heuristic fallback")
 	}
 }
+
+// ── Tool Result Redaction ──
+
+func TestAgentToolRegistry_ResultRedactor(t *testing.T) {
+	registry := NewAgentToolRegistry()
+	registry.MustRegister(newMockTool("get_logic"))
+	var seen []string
+	registry.SetResultRedactor(func(_ context.Context, workspaceID string, result *AgentToolResult) *AgentToolResult {
+		seen = append(seen, workspaceID)
+		return &AgentToolResult{Success: result.Success, Output: "[redacted]"}
+	})
+
+	params := json.RawMessage(`{"workspace_id":"ws-param"}`)
+	result, _ := registry.Execute(context.Background(), "get_logic", params)
+	if result.Output != "[redacted]" {
+		t.Fatalf("Output = %q, want the redacted result", result.Output)
+	}
+	ctx := WithTaskContext(context.Background(), &TaskContext{WorkspaceID: "ws-task"})
+	_, _ = registry.Execute(ctx, "get_logic", params)
+	// No workspace can be determined: the result is returned as is
+	_, _ = registry.Execute(context.Background(), "get_logic", json.RawMessage(`{}`))
+
+	if len(seen) != 2 || seen[0] != "ws-param" || seen[1] != "ws-task" {
+		t.Fatalf("redactor saw %v, want [ws-param ws-task]", seen)
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/repository"
+)
+
+const (
+	// agentRunReplayMaxLimit 单次回放最多返回的事件数
+	agentRunReplayMaxLimit = 1000
+	// agentRunStoreTimeout 单个事件写库的超时
+	agentRunStoreTimeout = 5 * time.Second
+)
+
+// ErrAgentRunInProgress 会话已有正在进行的运行
+var ErrAgentRunInProgress = errors.New("an agent run is already in progress for this session")
+
+// AgentRunPublisher 把已编号的事件发布给实时订阅者（如 WebSocket 房间 agent:<sessionID>）
+type AgentRunPublisher func(workspaceID, sessionID string, event AgentEvent)
+
+// AgentRunService 在后台执行 Agent 运行，事件按会话编号持久化并发布
+// 运行不随发起请求断开而结束，重连或其他查看者通过 EventsAfter 回放缺失的事件
+type AgentRunService interface {
+	// Start 启动一次运行；返回的 AgentRun 向发起方转发事件，发起方离开时须调用 Detach
+	Start(ctx context.Context, workspaceID, userID, message, sessionID, personaID string) (*AgentRun, error)
+	// Cancel 停止会话正在进行的运行，没有运行时返回 false
+	Cancel(sessionID string) bool
+	// IsRunning 会话是否有正在进行的运行
+	IsRunning(sessionID string) bool
+	// EventsAfter 按编号升序返回 seq 大于 afterSeq 的事件
+	EventsAfter(ctx context.Context, sessionID string, afterSeq int64, limit int) ([]AgentEvent, error)
+	// DeleteEvents 删除会话的事件记录
+	DeleteEvents(ctx context.Context, sessionID string) error
+	// SetPublisher 设置事件发布器，需在 Start 之前调用
+	SetPublisher(publisher AgentRunPublisher)
+}
+
+// AgentRun 一次进行中的运行
+type AgentRun struct {
+	// Events 发起方的事件流，运行结束后关闭
+	Events <-chan AgentEvent
+
+	cancel   context.CancelFunc
+	detached chan struct{}
+	once     sync.Once
+}
+
+// Detach 发起方不再读取事件，运行继续在后台进行
+func (r *AgentRun) Detach() {
+	r.once.Do(func() { close(r.detached) })
+}
+
+type agentRunService struct {
+	engine    AgentEngine
+	repo      repository.AgentRunEventRepository
+	log       logger.Logger
+	publisher AgentRunPublisher
+
+	mu      sync.Mutex
+	running map[string]*AgentRun
+}
+
+// NewAgentRunService 创建 Agent 运行服务
+func NewAgentRunService(engine AgentEngine, repo repository.AgentRunEventRepository, log logger.Logger) AgentRunService {
+	return &agentRunService{
+		engine:  engine,
+		repo:    repo,
+		log:     log,
+		running: make(map[string]*AgentRun),
+	}
+}
+
+func (s *agentRunService) SetPublisher(publisher AgentRunPublisher) {
+	s.publisher = publisher
+}
+
+func (s *agentRunService) Start(ctx context.Context, workspaceID, userID, message, sessionID, personaID string) (*AgentRun, error) {
+	// 保留请求上下文中的值（如工作空间 LLM 配置），但不随请求取消
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	out := make(chan AgentEvent, 32)
+	run := &AgentRun{Events: out, cancel: cancel, detached: make(chan struct{})}
+
+	s.mu.Lock()
+	if _, ok := s.running[sessionID]; ok {
+		s.mu.Unlock()
+		cancel()
+		return nil, ErrAgentRunInProgress
+	}
+	s.running[sessionID] = run
+	s.mu.Unlock()
+
+	seq, err := s.repo.MaxSeq(ctx, sessionID)
+	if err != nil {
+		s.finish(sessionID)
+		cancel()
+		return nil, err
+	}
+	workspaceUUID, _ := uuid.Parse(workspaceID)
+
+	go func() {
+		defer close(out)
+		defer s.finish(sessionID)
+		defer cancel()
+
+		for event := range s.engine.Run(runCtx, workspaceID, userID, message, sessionID, personaID) {
+			seq++
+			event.Seq = seq
+			event.SessionID = sessionID
+			s.store(workspaceUUID, event)
+			if s.publisher != nil {
+				s.publisher(workspaceID, sessionID, event)
+			}
+			select {
+			case out <- event:
+			case <-run.detached:
+			}
+		}
+	}()
+
+	return run, nil
+}
+
+// store 持久化事件；失败只记录日志，实时推送不受影响
+func (s *agentRunService) store(workspaceID uuid.UUID, event AgentEvent) {
+	var payload entity.JSON
+	data, _ := json.Marshal(event)
+	_ = json.Unmarshal(data, &payload)
+
+	ctx, cancel := context.WithTimeout(context.Background(), agentRunStoreTimeout)
+	defer cancel()
+	if err := s.repo.Create(ctx, &entity.AgentRunEvent{
+		SessionID:   event.SessionID,
+		WorkspaceID: workspaceID,
+		Seq:         event.Seq,
+		Type:        string(event.Type),
+		Payload:     payload,
+	}); err != nil && s.log != nil {
+		s.log.Warn("Failed to store agent run event", "session_id", event.SessionID, "seq", event.Seq, "error", err)
+	}
+}
+
+func (s *agentRunService) finish(sessionID string) {
+	s.mu.Lock()
+	delete(s.running, sessionID)
+	s.mu.Unlock()
+}
+
+func (s *agentRunService) Cancel(sessionID string) bool {
+	s.mu.Lock()
+	run, ok := s.running[sessionID]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+	run.cancel()
+	return true
+}
+
+func (s *agentRunService) IsRunning(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.running[sessionID]
+	return ok
+}
+
+func (s *agentRunService) EventsAfter(ctx context.Context, sessionID string, afterSeq int64, limit int) ([]AgentEvent, error) {
+	if limit <= 0 || limit > agentRunReplayMaxLimit {
+		limit = agentRunReplayMaxLimit
+	}
+	records, err := s.repo.ListAfter(ctx, sessionID, afterSeq, limit)
+	if err != nil {
+		return nil, err
+	}
+	events := make([]AgentEvent, 0, len(records))
+	for _, record := range records {
+		var event AgentEvent
+		data, _ := json.Marshal(record.Payload)
+		if err := json.Unmarshal(data, &event); err != nil {
+			continue
+		}
+		event.Seq = record.Seq
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (s *agentRunService) DeleteEvents(ctx context.Context, sessionID string) error {
+	return s.repo.DeleteBySession(ctx, sessionID)
+}
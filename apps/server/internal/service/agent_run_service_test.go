@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+)
+
+// memoryAgentRunEventRepo 内存版 AgentRunEventRepository
+type memoryAgentRunEventRepo struct {
+	mu     sync.Mutex
+	events []entity.AgentRunEvent
+}
+
+func (r *memoryAgentRunEventRepo) Create(_ context.Context, event *entity.AgentRunEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range r.events {
+		if e.SessionID == event.SessionID && e.Seq == event.Seq {
+			return errors.New("duplicate seq")
+		}
+	}
+	r.events = append(r.events, *event)
+	return nil
+}
+
+func (r *memoryAgentRunEventRepo) ListAfter(_ context.Context, sessionID string, afterSeq int64, limit int) ([]entity.AgentRunEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []entity.AgentRunEvent
+	for _, e := range r.events {
+		if e.SessionID == sessionID && e.Seq > afterSeq && len(out) < limit {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (r *memoryAgentRunEventRepo) MaxSeq(_ context.Context, sessionID string) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var seq int64
+	for _, e := range r.events {
+		if e.SessionID == sessionID && e.Seq > seq {
+			seq = e.Seq
+		}
+	}
+	return seq, nil
+}
+
+func (r *memoryAgentRunEventRepo) DeleteBySession(_ context.Context, sessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	kept := r.events[:0]
+	for _, e := range r.events {
+		if e.SessionID != sessionID {
+			kept = append(kept, e)
+		}
+	}
+	r.events = kept
+	return nil
+}
+
+// scriptedAgentEngine 发出一条思考后等待 release 或取消，再结束运行
+type scriptedAgentEngine struct {
+	AgentEngine
+	release chan struct{}
+}
+
+func (e *scriptedAgentEngine) Run(ctx context.Context, _, _, message, sessionID, _ string) <-chan AgentEvent {
+	events := make(chan AgentEvent)
+	go func() {
+		defer close(events)
+		events <- AgentEvent{Type: AgentEventThought, Content: message, SessionID: sessionID}
+		select {
+		case <-e.release:
+			events <- AgentEvent{Type: AgentEventMessage, Content: "ok", SessionID: sessionID}
+			events <- AgentEvent{Type: AgentEventDone, SessionID: sessionID}
+		case <-ctx.Done():
+			events <- AgentEvent{Type: AgentEventError, Error: "cancelled", SessionID: sessionID}
+		}
+	}()
+	return events
+}
+
+// collectPublished 记录发布的事件，done 在收到 done / error 时关闭
+func collectPublished(svc AgentRunService) (func() []AgentEvent, <-chan struct{}) {
+	var mu sync.Mutex
+	var published []AgentEvent
+	done := make(chan struct{})
+	svc.SetPublisher(func(_, _ string, event AgentEvent) {
+		mu.Lock()
+		published = append(published, event)
+		mu.Unlock()
+		if event.Type == AgentEventDone || event.Type == AgentEventError {
+			close(done)
+		}
+	})
+	return func() []AgentEvent {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]AgentEvent(nil), published...)
+	}, done
+}
+
+func waitClosed(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run did not finish")
+	}
+}
+
+func TestAgentRunService_RunSurvivesDetachAndReplays(t *testing.T) {
+	repo := &memoryAgentRunEventRepo{}
+	sessionID := uuid.New().String()
+	// 会话中已有上一次运行的事件，新运行的编号接着递增
+	repo.events = append(repo.events, entity.AgentRunEvent{SessionID: sessionID, Seq: 3, Type: "done"})
+
+	engine := &scriptedAgentEngine{release: make(chan struct{})}
+	svc := NewAgentRunService(engine, repo, nil)
+	published, done := collectPublished(svc)
+
+	reqCtx, cancelReq := context.WithCancel(context.Background())
+	run, err := svc.Start(reqCtx, uuid.New().String(), uuid.New().String(), "build a todo app", sessionID, "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if first := <-run.Events; first.Seq != 4 || first.Type != AgentEventThought {
+		t.Fatalf("first event = %+v", first)
+	}
+	// 发起请求断开：运行继续，后续事件仍被编号、持久化与发布
+	run.Detach()
+	cancelReq()
+	if !svc.IsRunning(sessionID) {
+		t.Fatal("run should still be in progress")
+	}
+	if _, err := svc.Start(context.Background(), "", "", "again", sessionID, ""); !errors.Is(err, ErrAgentRunInProgress) {
+		t.Fatalf("concurrent Start err = %v", err)
+	}
+	close(engine.release)
+	waitClosed(t, done)
+
+	got := published()
+	if len(got) != 3 || got[1].Seq != 5 || got[2].Seq != 6 || got[2].Type != AgentEventDone {
+		t.Fatalf("published = %+v", got)
+	}
+
+	replay, err := svc.EventsAfter(context.Background(), sessionID, 4, 0)
+	if err != nil {
+		t.Fatalf("EventsAfter: %v", err)
+	}
+	if len(replay) != 2 || replay[0].Seq != 5 || replay[0].Content != "ok" || replay[0].SessionID != sessionID || replay[1].Type != AgentEventDone {
+		t.Fatalf("replay = %+v", replay)
+	}
+
+	if err := svc.DeleteEvents(context.Background(), sessionID); err != nil {
+		t.Fatalf("DeleteEvents: %v", err)
+	}
+	if replay, _ := svc.EventsAfter(context.Background(), sessionID, 0, 0); len(replay) != 0 {
+		t.Fatalf("events after delete = %+v", replay)
+	}
+}
+
+func TestAgentRunService_Cancel(t *testing.T) {
+	engine := &scriptedAgentEngine{release: make(chan struct{})}
+	svc := NewAgentRunService(engine, &memoryAgentRunEventRepo{}, nil)
+	published, done := collectPublished(svc)
+	sessionID := uuid.New().String()
+
+	run, err := svc.Start(context.Background(), uuid.New().String(), "", "hi", sessionID, "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	run.Detach()
+	if !svc.Cancel(sessionID) {
+		t.Fatal("Cancel should find the run")
+	}
+	waitClosed(t, done)
+	if got := published(); got[len(got)-1].Error != "cancelled" {
+		t.Fatalf("published = %+v", got)
+	}
+	// 运行结束后从进行中列表移除
+	for i := 0; svc.IsRunning(sessionID) && i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if svc.IsRunning(sessionID) || svc.Cancel(sessionID) {
+		t.Fatal("finished run should be gone")
+	}
+}
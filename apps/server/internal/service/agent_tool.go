@@ -43,10 +43,14 @@ type AgentToolResult struct {
 
 // AgentToolRegistry 工具注册表
 type AgentToolRegistry struct {
-	mu    sync.RWMutex
-	tools map[string]AgentTool
+	mu       sync.RWMutex
+	tools    map[string]AgentTool
+	redactor AgentToolResultRedactor
 }
 
+// AgentToolResultRedactor 工具结果脱敏函数，在结果返回给 LLM 之前替换工作空间密钥明文
+type AgentToolResultRedactor func(ctx context.Context, workspaceID string, result *AgentToolResult) *AgentToolResult
+
 // NewAgentToolRegistry 创建工具注册表
 func NewAgentToolRegistry() *AgentToolRegistry {
 	return &AgentToolRegistry{
@@ -66,6 +70,13 @@ func (r *AgentToolRegistry) Register(tool AgentTool) error {
 	return nil
 }
 
+// SetResultRedactor 设置工具结果脱敏函数
+func (r *AgentToolRegistry) SetResultRedactor(redactor AgentToolResultRedactor) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.redactor = redactor
+}
+
 // MustRegister 注册工具（失败时 panic）
 func (r *AgentToolRegistry) MustRegister(tool AgentTool) {
 	if err := r.Register(tool); err != nil {
@@ -127,7 +138,29 @@ func (r *AgentToolRegistry) Execute(ctx context.Context, name string, params jso
 			Error:   fmt.Sprintf("unknown tool: %s", name),
 		}, fmt.Errorf("unknown tool: %s", name)
 	}
-	return tool.Execute(ctx, params)
+	result, err := tool.Execute(ctx, params)
+	r.mu.RLock()
+	redactor := r.redactor
+	r.mu.RUnlock()
+	if redactor == nil || result == nil {
+		return result, err
+	}
+	if workspaceID := toolWorkspaceID(ctx, params); workspaceID != "" {
+		result = redactor(ctx, workspaceID, result)
+	}
+	return result, err
+}
+
+// toolWorkspaceID 确定工具调用所属的工作空间：优先 TaskContext，其次参数中的 workspace_id
+func toolWorkspaceID(ctx context.Context, params json.RawMessage) string {
+	if tc := GetTaskContext(ctx); tc != nil && tc.WorkspaceID != "" {
+		return tc.WorkspaceID
+	}
+	var p struct {
+		WorkspaceID string `json:"workspace_id"`
+	}
+	_ = json.Unmarshal(params, &p)
+	return p.WorkspaceID
 }
 
 // ToolCount 返回已注册工具数量
@@ -3,7 +3,9 @@ package agent_tools
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/reverseai/server/internal/service"
 	"github.com/reverseai/server/internal/vmruntime"
@@ -20,7 +22,8 @@ func NewAlterTableTool(vmStore *vmruntime.VMStore) *AlterTableTool {
 func (t *AlterTableTool) Name() string { return "alter_table" }
 
 func (t *AlterTableTool) Description() string {
-	return "Alter an existing database table: add/rename/drop columns."
+	return "Alter an existing database table: add/rename/drop columns and change column type, nullability or default. " +
+		"Type/nullability/default changes rebuild the table; use dry_run to preview the SQL and rows that would be lost."
 }
 
 func (t *AlterTableTool) Parameters() json.RawMessage {
@@ -48,12 +51,17 @@ func (t *AlterTableTool) Parameters() json.RawMessage {
 					"type": "object",
 					"properties": {
 						"name": {"type": "string"},
-						"new_name": {"type": "string"}
+						"new_name": {"type": "string"},
+						"type": {"type": "string", "description": "New column type"},
+						"nullable": {"type": "boolean", "description": "New nullability"},
+						"default_value": {"type": "string", "description": "New default value as a SQL literal: number, 'quoted string', NULL or CURRENT_TIMESTAMP"}
 					},
 					"required": ["name"]
 				}
 			},
-			"drop_columns": {"type": "array", "items": {"type": "string"}}
+			"drop_columns": {"type": "array", "items": {"type": "string"}},
+			"dry_run": {"type": "boolean", "description": "Only preview the generated SQL and data-loss report", "default": false},
+			"allow_data_loss": {"type": "boolean", "description": "Apply even if rows would be dropped or values changed by a type cast", "default": false}
 		},
 		"required": ["workspace_id", "table_name"]
 	}`)
@@ -62,11 +70,13 @@ func (t *AlterTableTool) Parameters() json.RawMessage {
 func (t *AlterTableTool) RequiresConfirmation() bool { return false }
 
 type alterTableParams struct {
-	WorkspaceID  string                        `json:"workspace_id"`
-	TableName    string                        `json:"table_name"`
-	AddColumns   []vmruntime.VMCreateColumnDef `json:"add_columns"`
-	AlterColumns []vmruntime.VMAlterColumnDef  `json:"alter_columns"`
-	DropColumns  []string                      `json:"drop_columns"`
+	WorkspaceID   string                        `json:"workspace_id"`
+	TableName     string                        `json:"table_name"`
+	AddColumns    []vmruntime.VMCreateColumnDef `json:"add_columns"`
+	AlterColumns  []vmruntime.VMAlterColumnDef  `json:"alter_columns"`
+	DropColumns   []string                      `json:"drop_columns"`
+	DryRun        bool                          `json:"dry_run"`
+	AllowDataLoss bool                          `json:"allow_data_loss"`
 }
 
 func (t *AlterTableTool) Execute(ctx context.Context, params json.RawMessage) (*service.AgentToolResult, error) {
@@ -76,12 +86,38 @@ func (t *AlterTableTool) Execute(ctx context.Context, params json.RawMessage) (*
 	}
 
 	req := vmruntime.VMAlterTableRequest{
-		AddColumns:   p.AddColumns,
-		AlterColumns: p.AlterColumns,
-		DropColumns:  p.DropColumns,
+		AddColumns:    p.AddColumns,
+		AlterColumns:  p.AlterColumns,
+		DropColumns:   p.DropColumns,
+		AllowDataLoss: p.AllowDataLoss,
 	}
 
-	if err := t.vmStore.AlterTable(ctx, p.WorkspaceID, p.TableName, req); err != nil {
+	if p.DryRun {
+		plan, err := t.vmStore.PreviewAlterTable(ctx, p.WorkspaceID, p.TableName, req)
+		if err != nil {
+			return &service.AgentToolResult{
+				Success: false,
+				Error:   fmt.Sprintf("failed to preview altering table %q: %v", p.TableName, err),
+			}, nil
+		}
+		return &service.AgentToolResult{
+			Success: true,
+			Output:  fmt.Sprintf("Preview of altering table %q (nothing changed):\n%s", p.TableName, describeAlterPlan(plan)),
+			Data:    plan,
+		}, nil
+	}
+
+	plan, err := t.vmStore.AlterTable(vmruntime.WithMigrationSource(ctx, vmruntime.MigrationSourceAgent), p.WorkspaceID, p.TableName, req)
+	if err != nil {
+		var lossErr *vmruntime.VMDataLossError
+		if errors.As(err, &lossErr) {
+			return &service.AgentToolResult{
+				Success: false,
+				Error: fmt.Sprintf("altering table %q would lose data; nothing was changed. Confirm with the user, then retry with allow_data_loss.\n%s",
+					p.TableName, describeAlterPlan(lossErr.Plan)),
+				Data: lossErr.Plan,
+			}, nil
+		}
 		return &service.AgentToolResult{
 			Success: false,
 			Error:   fmt.Sprintf("failed to alter table %q: %v", p.TableName, err),
@@ -89,8 +125,35 @@ func (t *AlterTableTool) Execute(ctx context.Context, params json.RawMessage) (*
 	}
 
 	changes := len(p.AddColumns) + len(p.AlterColumns) + len(p.DropColumns)
+	output := fmt.Sprintf("Successfully altered table %q with %d changes.", p.TableName, changes)
+	if len(plan.DataLoss) > 0 {
+		output += "\n" + describeAlterPlan(plan)
+	}
 	return &service.AgentToolResult{
 		Success: true,
-		Output:  fmt.Sprintf("Successfully altered table %q with %d changes.", p.TableName, changes),
+		Output:  output,
 	}, nil
 }
+
+// describeAlterPlan renders the statements and data-loss report of a plan.
+func describeAlterPlan(plan *vmruntime.VMAlterPlan) string {
+	var sb strings.Builder
+	sb.WriteString("SQL:\n")
+	for _, stmt := range plan.Statements {
+		sb.WriteString(stmt + ";\n")
+	}
+	if len(plan.DataLoss) == 0 {
+		sb.WriteString("Data loss: none")
+		return sb.String()
+	}
+	sb.WriteString("Data loss:")
+	for _, loss := range plan.DataLoss {
+		switch loss.Reason {
+		case vmruntime.DataLossNotNull:
+			fmt.Fprintf(&sb, "\n- %s: %d rows with NULL would be dropped", loss.Column, loss.Rows)
+		default:
+			fmt.Fprintf(&sb, "\n- %s: %d values change when cast to the new type", loss.Column, loss.Rows)
+		}
+	}
+	return sb.String()
+}
@@ -71,7 +71,7 @@ func (t *CreateTableTool) Execute(ctx context.Context, params json.RawMessage) (
 		PrimaryKey: p.PrimaryKey,
 	}
 
-	if err := t.vmStore.CreateTable(ctx, p.WorkspaceID, req); err != nil {
+	if err := t.vmStore.CreateTable(vmruntime.WithMigrationSource(ctx, vmruntime.MigrationSourceAgent), p.WorkspaceID, req); err != nil {
 		return &service.AgentToolResult{
 			Success: false,
 			Error:   fmt.Sprintf("failed to create table %q: %v", p.Name, err),
@@ -51,7 +51,7 @@ func (t *DeleteTableTool) Execute(ctx context.Context, params json.RawMessage) (
 		return &service.AgentToolResult{Success: false, Error: "table_name is required"}, nil
 	}
 
-	if err := t.vmStore.DropTable(ctx, p.WorkspaceID, p.TableName); err != nil {
+	if err := t.vmStore.DropTable(vmruntime.WithMigrationSource(ctx, vmruntime.MigrationSourceAgent), p.WorkspaceID, p.TableName); err != nil {
 		return &service.AgentToolResult{
 			Success: false,
 			Error:   fmt.Sprintf("failed to drop table %q: %v", p.TableName, err),
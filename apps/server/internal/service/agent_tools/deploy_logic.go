@@ -28,7 +28,7 @@ func NewDeployLogicTool(
 func (t *DeployLogicTool) Name() string { return "deploy_logic" }
 
 func (t *DeployLogicTool) Description() string {
-	return `Deploy JavaScript business logic code to the workspace's VM runtime. The code defines API routes that handle HTTP requests. The code has access to a 'db' object for SQLite operations and a 'console' object for logging. Routes are defined via exports.routes = { "METHOD /path": function(ctx) { ... } }. Example:
+	return fmt.Sprintf(`Deploy JavaScript business logic code to the workspace's VM runtime. The code defines API routes that handle HTTP requests. The code has access to a 'db' object for SQLite operations, a 'console' object for logging, and the standard library modules listed below (there is no require/import or Node.js API). Routes are defined via exports.routes = { "METHOD /path": function(ctx) { ... } }. Example:
 exports.routes = {
   "GET /tasks": function(ctx) {
     return db.query("SELECT * FROM tasks");
@@ -41,7 +41,39 @@ exports.routes = {
     return db.queryOne("SELECT * FROM tasks WHERE id = ?", [ctx.params.id]);
   }
 };
-Available db methods: db.query(sql, params?), db.queryOne(sql, params?), db.insert(table, data), db.update(table, data, where), db.delete(table, where), db.execute(sql, params?).`
+Available db methods: db.query(sql, params?), db.queryOne(sql, params?), db.insert(table, data), db.update(table, data, where), db.delete(table, where), db.execute(sql, params?), db.transaction(fn) (runs fn(tx) in a transaction: commits when fn returns, rolls back when it throws; use tx.* inside).
+Row level security: 'db' automatically applies the workspace's RLS policies for the signed-in app user (ctx.user), so routes only see and modify that user's rows; raw db.execute is rejected on RLS-protected tables. Use db.admin (same methods) only for trusted, user-independent logic.
+Standard library (globals, use these instead of hand-rolling):
+- crypto: crypto.uuid(), crypto.sha256(data, enc?), crypto.hmac(key, data, algorithm?, enc?) with algorithm "sha256" (default) | "sha1" | "sha512", crypto.randomBytes(n, enc?) (n <= 1024), crypto.hashPassword(password) (bcrypt), crypto.verifyPassword(password, hash), crypto.timingSafeEqual(a, b). enc is "hex" (default) | "base64" | "base64url".
+- validate(schema, value): JSON Schema validation, returns { valid, errors: [{ field, message }] }; only local "#/..." $refs. Example: var r = validate({ type: "object", required: ["email"], properties: { email: { type: "string", format: "email" } } }, ctx.body); if (!r.valid) return { status: 400, body: { errors: r.errors } };
+- time: time.now(tz?) (ISO string), time.format(value, layout?, tz?), time.parse(text, layout?, tz?) (epoch ms), time.add(value, amount, unit, tz?) (epoch ms; unit year|month|week|day|hour|minute|second), time.startOf(value, unit, tz?) (epoch ms; weeks start Monday). Values are epoch ms, Date objects or date strings; tz is an IANA name (default "UTC"); layout uses tokens YYYY MM DD HH mm ss SSS ZZ (e.g. "YYYY-MM-DD HH:mm") or "iso" | "date" | "datetime".
+- kv: workspace-wide key/value store (not per user, no RLS): kv.get(key) (null if missing), kv.set(key, jsonValue, { ttl: seconds }?), kv.delete(key), kv.incr(key, by?) (returns new number), kv.list(prefix?, limit?). Joins the open db.transaction. Use it for counters, caches and settings, not for relational data.
+- fetch(url, { method?, headers?, body?, timeout? }): synchronous outbound HTTP (no Promise, no await). Returns { status, ok, headers, url, text(), json() }. An object body is sent as JSON. Only hosts and methods in the workspace egress allowlist are reachable (the owner configures it); private and loopback addresses are always refused. Reference workspace secrets as "{{secrets.NAME}}" in header values or string bodies instead of hard-coding credentials. %s Example: var res = fetch("https://api.example.com/hook", { method: "POST", headers: { Authorization: "Bearer {{secrets.API_TOKEN}}" }, body: { id: ctx.params.id } }); if (!res.ok) return { status: 502, body: { error: res.text() } };
+- secrets: secrets.get(name) returns a workspace secret (string) or null if it is not defined; use list_secrets to see which names exist. Never return or log secret values; they are redacted from console output and runtime events.
+Schedules: exports.schedules = { "0 2 * * *": function(ctx) { ... } } runs a function on a standard 5-field cron expression (or @hourly/@daily/@weekly/@monthly/@yearly) in the workspace time zone (default UTC, set by the owner). ctx has { schedule, scheduledAt, trigger ("cron" | "manual"), runId, attempt }; there is no ctx.user and db is not RLS-scoped. A run is skipped while the previous run of the same schedule is still going; a throw fails the run and it is retried up to 3 times, so keep schedules idempotent. At most 20 schedules; an invalid expression makes the whole code fail to load.`, t.fetchLimits())
+}
+
+// fetchLimits describes the configured fetch budget, or the defaults when no
+// pool is set.
+func (t *DeployLogicTool) fetchLimits() string {
+	limits := vmruntime.DefaultVMLimits()
+	if t.vmPool != nil {
+		limits = t.vmPool.Limits()
+	}
+	return fmt.Sprintf("At most %d calls per request, %s per body, %s per call.",
+		limits.MaxFetchCalls, formatByteSize(limits.MaxFetchBytes), limits.FetchTimeout)
+}
+
+// formatByteSize renders n as whole MB or KB when it divides evenly.
+func formatByteSize(n int64) string {
+	switch {
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return fmt.Sprintf("%dMB", n>>20)
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return fmt.Sprintf("%dKB", n>>10)
+	default:
+		return fmt.Sprintf("%d bytes", n)
+	}
 }
 
 func (t *DeployLogicTool) Parameters() json.RawMessage {
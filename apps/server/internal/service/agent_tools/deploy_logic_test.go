@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -56,6 +57,29 @@ func TestDeployLogicTool_Metadata(t *testing.T) {
 	}
 }
 
+func TestDeployLogicTool_DescribesConfiguredFetchLimits(t *testing.T) {
+	if desc := NewDeployLogicTool(nil, nil).Description(); !strings.Contains(desc, "At most 20 calls per request, 5MB per body, 5s per call.") {
+		t.Fatalf("default description does not state the default fetch limits:\n%s", desc)
+	}
+
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: t.TempDir()})
+	defer store.Close()
+	limits := vmruntime.DefaultVMLimits()
+	limits.MaxFetchCalls = 3
+	limits.MaxFetchBytes = 512 << 10
+	limits.FetchTimeout = 2 * time.Second
+	pool := vmruntime.NewVMPool(store, nil, vmruntime.VMPoolOptions{MaxVMs: 1, Limits: limits})
+	defer pool.Close()
+
+	desc := NewDeployLogicTool(nil, pool).Description()
+	if !strings.Contains(desc, "At most 3 calls per request, 512KB per body, 2s per call.") {
+		t.Fatalf("description does not state the configured fetch limits:\n%s", desc)
+	}
+	if strings.Contains(desc, "%!") {
+		t.Fatalf("description has a formatting error:\n%s", desc)
+	}
+}
+
 func TestDeployLogicTool_Success(t *testing.T) {
 	wsID := uuid.New()
 	userID := uuid.New()
@@ -79,9 +103,9 @@ func TestDeployLogicTool_Success(t *testing.T) {
 	}
 
 	dir := t.TempDir()
-	store := vmruntime.NewVMStore(dir)
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: dir})
 	defer store.Close()
-	pool := vmruntime.NewVMPool(store, nil, 10)
+	pool := vmruntime.NewVMPool(store, nil, vmruntime.VMPoolOptions{MaxVMs: 10})
 	defer pool.Close()
 
 	tool := NewDeployLogicTool(mockWS, pool)
@@ -197,9 +221,9 @@ func TestDeployLogicTool_UpdateLogicCodeError(t *testing.T) {
 	}
 
 	dir := t.TempDir()
-	store := vmruntime.NewVMStore(dir)
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: dir})
 	defer store.Close()
-	pool := vmruntime.NewVMPool(store, nil, 10)
+	pool := vmruntime.NewVMPool(store, nil, vmruntime.VMPoolOptions{MaxVMs: 10})
 	defer pool.Close()
 
 	tool := NewDeployLogicTool(mockWS, pool)
@@ -235,14 +259,14 @@ func TestDeployLogicTool_VMPoolInvalidation(t *testing.T) {
 	}
 
 	dir := t.TempDir()
-	store := vmruntime.NewVMStore(dir)
+	store := vmruntime.NewVMStore(vmruntime.VMStoreOptions{BaseDir: dir})
 	defer store.Close()
 
 	// Use a mock code loader that returns code for our workspace
 	loader := &testCodeLoader{codes: map[string]string{
 		wsID.String(): `exports.routes = { "GET /old": function() { return 1; } };`,
 	}}
-	pool := vmruntime.NewVMPool(store, loader, 10)
+	pool := vmruntime.NewVMPool(store, loader, vmruntime.VMPoolOptions{MaxVMs: 10})
 	defer pool.Close()
 
 	// Pre-populate the VM cache
@@ -0,0 +1,93 @@
+package agent_tools
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/service"
+)
+
+type ListSecretsTool struct {
+	secretService service.WorkspaceSecretService
+}
+
+func NewListSecretsTool(secretService service.WorkspaceSecretService) *ListSecretsTool {
+	return &ListSecretsTool{secretService: secretService}
+}
+
+func (t *ListSecretsTool) Name() string { return "list_secrets" }
+
+func (t *ListSecretsTool) Description() string {
+	return "List the names and descriptions of the workspace secrets (API tokens, webhook signing keys). Values are never returned. Reference a secret in logic code with secrets.get(\"NAME\") or inside fetch headers/body as {{secrets.NAME}}; never hard-code credentials. If a required secret is missing, ask the user to create it in the workspace settings."
+}
+
+func (t *ListSecretsTool) Parameters() json.RawMessage {
+	return json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"workspace_id": {"type": "string", "description": "Workspace ID"},
+			"user_id": {"type": "string", "description": "User ID (owner)"}
+		},
+		"required": ["workspace_id", "user_id"]
+	}`)
+}
+
+func (t *ListSecretsTool) RequiresConfirmation() bool { return false }
+
+type listSecretsParams struct {
+	WorkspaceID string `json:"workspace_id"`
+	UserID      string `json:"user_id"`
+}
+
+func (t *ListSecretsTool) Execute(ctx context.Context, params json.RawMessage) (*service.AgentToolResult, error) {
+	var p listSecretsParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return &service.AgentToolResult{Success: false, Error: "invalid parameters: " + err.Error()}, nil
+	}
+
+	wsID, err := uuid.Parse(p.WorkspaceID)
+	if err != nil {
+		return &service.AgentToolResult{Success: false, Error: "invalid workspace_id"}, nil
+	}
+	userID, err := uuid.Parse(p.UserID)
+	if err != nil {
+		return &service.AgentToolResult{Success: false, Error: "invalid user_id"}, nil
+	}
+
+	secrets, err := t.secretService.List(ctx, wsID, userID)
+	if err != nil {
+		return &service.AgentToolResult{Success: false, Error: "failed to list secrets: " + err.Error()}, nil
+	}
+
+	if len(secrets) == 0 {
+		return &service.AgentToolResult{
+			Success: true,
+			Output:  "No secrets defined yet.",
+			Data:    map[string]interface{}{"count": 0, "secrets": []interface{}{}},
+		}, nil
+	}
+
+	lines := []string{fmt.Sprintf("Found %d secret(s):", len(secrets))}
+	summaries := make([]map[string]interface{}, 0, len(secrets))
+	for _, secret := range secrets {
+		line := "  - " + secret.Name
+		if secret.Description != "" {
+			line += ": " + secret.Description
+		}
+		lines = append(lines, line)
+		summaries = append(summaries, map[string]interface{}{
+			"name":        secret.Name,
+			"description": secret.Description,
+			"version":     secret.Version,
+		})
+	}
+
+	return &service.AgentToolResult{
+		Success: true,
+		Output:  strings.Join(lines, "\n"),
+		Data:    map[string]interface{}{"count": len(secrets), "secrets": summaries},
+	}, nil
+}
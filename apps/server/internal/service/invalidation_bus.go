@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/reverseai/server/internal/pkg/redis"
+)
+
+// InvalidationKind 缓存失效事件类型
+type InvalidationKind string
+
+const (
+	InvalidationLogic        InvalidationKind = "logic"         // 逻辑代码部署
+	InvalidationUISchema     InvalidationKind = "ui_schema"     // UI Schema / 组件更新
+	InvalidationVersion      InvalidationKind = "version"       // 创建新版本
+	InvalidationPublish      InvalidationKind = "publish"       // 发布
+	InvalidationRollback     InvalidationKind = "rollback"      // 回滚
+	InvalidationSlug         InvalidationKind = "slug"          // Slug 变更
+	InvalidationAccessPolicy InvalidationKind = "access_policy" // 访问策略变更
+	InvalidationWorkspace    InvalidationKind = "workspace"     // 其他工作空间属性（名称、设置、状态、删除）
+	InvalidationSecrets      InvalidationKind = "secrets"       // 工作空间密钥变更
+	InvalidationAppSessions  InvalidationKind = "app_sessions"  // 应用用户会话撤销（登出、封禁、重置密码）
+	InvalidationDatabase     InvalidationKind = "database"      // 工作空间数据库从快照恢复
+)
+
+// invalidationChannel 失效事件广播的 Redis 频道
+const invalidationChannel = "cache:invalidate"
+
+// InvalidationEvent 工作空间级缓存失效事件
+type InvalidationEvent struct {
+	Kind        InvalidationKind `json:"kind"`
+	WorkspaceID string           `json:"workspace_id"`
+	// Slugs 需要额外清理的 slug（例如变更前后的 slug，用于清理负缓存）
+	Slugs []string `json:"slugs,omitempty"`
+	// AppUserID、SessionID 会话撤销事件的应用用户与会话；SessionID 为空表示该用户的全部会话
+	AppUserID string `json:"app_user_id,omitempty"`
+	SessionID string `json:"session_id,omitempty"`
+	// Origin 发布事件的实例 ID，用于跳过本实例的回环消息
+	Origin string `json:"origin,omitempty"`
+}
+
+// AffectsLogic 事件是否可能改变当前版本的逻辑代码（需要丢弃缓存的 VM）
+func (e InvalidationEvent) AffectsLogic() bool {
+	switch e.Kind {
+	case InvalidationLogic, InvalidationVersion, InvalidationPublish, InvalidationRollback:
+		return true
+	}
+	return false
+}
+
+// AffectsVM 事件是否需要丢弃缓存的 VM（逻辑代码变化或数据库被整体替换）
+func (e InvalidationEvent) AffectsVM() bool {
+	return e.AffectsLogic() || e.Kind == InvalidationDatabase
+}
+
+// InvalidationHandler 失效事件处理函数
+type InvalidationHandler func(event InvalidationEvent)
+
+// InvalidationBus 集群缓存失效总线
+// Publish 先同步应用到本实例的订阅者，再广播给其他实例
+type InvalidationBus interface {
+	Publish(ctx context.Context, event InvalidationEvent) error
+	Subscribe(handler InvalidationHandler)
+	Close() error
+}
+
+// NewInvalidationBus 创建缓存失效总线
+// client 为空时使用进程内实现（单机 / 开发环境）
+func NewInvalidationBus(client *redis.Client) InvalidationBus {
+	if client == nil || client.Client == nil {
+		return NewMemoryInvalidationBus()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bus := &redisInvalidationBus{
+		client:     client,
+		local:      NewMemoryInvalidationBus(),
+		instanceID: uuid.NewString(),
+		cancel:     cancel,
+		done:       make(chan struct{}),
+	}
+	go bus.listen(ctx, client.Subscribe(ctx, invalidationChannel))
+	return bus
+}
+
+// ==================== 进程内实现 ====================
+
+// MemoryInvalidationBus 进程内失效总线，同步调用订阅者
+type MemoryInvalidationBus struct {
+	mu       sync.RWMutex
+	handlers []InvalidationHandler
+}
+
+// NewMemoryInvalidationBus 创建进程内失效总线
+func NewMemoryInvalidationBus() *MemoryInvalidationBus {
+	return &MemoryInvalidationBus{}
+}
+
+func (b *MemoryInvalidationBus) Publish(ctx context.Context, event InvalidationEvent) error {
+	b.deliver(event)
+	return nil
+}
+
+func (b *MemoryInvalidationBus) Subscribe(handler InvalidationHandler) {
+	if handler == nil {
+		return
+	}
+	b.mu.Lock()
+	b.handlers = append(b.handlers, handler)
+	b.mu.Unlock()
+}
+
+func (b *MemoryInvalidationBus) Close() error {
+	return nil
+}
+
+func (b *MemoryInvalidationBus) deliver(event InvalidationEvent) {
+	if event.WorkspaceID == "" {
+		return
+	}
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// ==================== Redis 实现 ====================
+
+type redisInvalidationBus struct {
+	client     *redis.Client
+	local      *MemoryInvalidationBus
+	instanceID string
+	cancel     context.CancelFunc
+	done       chan struct{}
+	closeOnce  sync.Once
+}
+
+// Publish 本实例立即生效；广播失败时其他实例最迟在缓存 TTL 到期后生效
+func (b *redisInvalidationBus) Publish(ctx context.Context, event InvalidationEvent) error {
+	event.Origin = b.instanceID
+	b.local.deliver(event)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, invalidationChannel, payload).Err()
+}
+
+func (b *redisInvalidationBus) Subscribe(handler InvalidationHandler) {
+	b.local.Subscribe(handler)
+}
+
+func (b *redisInvalidationBus) Close() error {
+	b.closeOnce.Do(b.cancel)
+	<-b.done
+	return nil
+}
+
+// listen 应用其他实例发布的失效事件
+func (b *redisInvalidationBus) listen(ctx context.Context, sub *goredis.PubSub) {
+	defer close(b.done)
+	defer sub.Close()
+	ch := sub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			var event InvalidationEvent
+			if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+				continue
+			}
+			if event.Origin != b.instanceID {
+				b.local.deliver(event)
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+)
+
+func TestMemoryInvalidationBus_Deliver(t *testing.T) {
+	bus := NewInvalidationBus(nil)
+	defer bus.Close()
+
+	var got []InvalidationEvent
+	bus.Subscribe(func(event InvalidationEvent) { got = append(got, event) })
+	bus.Subscribe(nil)
+
+	ctx := context.Background()
+	if err := bus.Publish(ctx, InvalidationEvent{Kind: InvalidationLogic, WorkspaceID: "ws-1"}); err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+	// 没有工作空间的事件被忽略
+	_ = bus.Publish(ctx, InvalidationEvent{Kind: InvalidationLogic})
+
+	if len(got) != 1 || got[0].WorkspaceID != "ws-1" || got[0].Kind != InvalidationLogic {
+		t.Fatalf("events = %+v, want one logic event for ws-1", got)
+	}
+}
+
+func TestInvalidationEvent_AffectsLogic(t *testing.T) {
+	for kind, want := range map[InvalidationKind]bool{
+		InvalidationLogic:        true,
+		InvalidationVersion:      true,
+		InvalidationPublish:      true,
+		InvalidationRollback:     true,
+		InvalidationUISchema:     false,
+		InvalidationSlug:         false,
+		InvalidationAccessPolicy: false,
+		InvalidationWorkspace:    false,
+		InvalidationSecrets:      false,
+	} {
+		if got := (InvalidationEvent{Kind: kind}).AffectsLogic(); got != want {
+			t.Fatalf("AffectsLogic(%s) = %v, want %v", kind, got, want)
+		}
+	}
+}
+
+func TestRuntimeService_InvalidateCache(t *testing.T) {
+	svc := &runtimeService{cache: newRuntimeCache(RuntimeCacheSettings{EntryTTL: time.Minute, NegativeTTL: time.Minute})}
+	bus := NewMemoryInvalidationBus()
+	bus.Subscribe(svc.InvalidateCache)
+
+	ws := &entity.Workspace{ID: uuid.New(), Slug: "old-slug"}
+	other := &entity.Workspace{ID: uuid.New(), Slug: "other"}
+	svc.cacheWorkspace(ws, "alias")
+	svc.cacheWorkspace(other)
+	svc.cache.versionByID.Set("v1", &entity.WorkspaceVersion{ID: uuid.New(), WorkspaceID: ws.ID})
+	svc.cache.versionByID.Set("v2", &entity.WorkspaceVersion{ID: uuid.New(), WorkspaceID: other.ID})
+	cacheMissSet(svc.cache.workspaceBySlugMiss, "new-slug")
+
+	ws.Slug = "new-slug"
+	_ = bus.Publish(context.Background(), InvalidationEvent{
+		Kind:        InvalidationSlug,
+		WorkspaceID: ws.ID.String(),
+		Slugs:       []string{"old-slug", "new-slug"},
+	})
+
+	for _, slug := range []string{"old-slug", "alias"} {
+		if _, ok := svc.cache.workspaceBySlug.Get(slug); ok {
+			t.Fatalf("slug %q still cached", slug)
+		}
+	}
+	if _, ok := svc.cache.workspaceByID.Get(ws.ID.String()); ok {
+		t.Fatal("workspace still cached by id")
+	}
+	if _, ok := svc.cache.versionByID.Get("v1"); ok {
+		t.Fatal("version still cached")
+	}
+	if cacheMissHit(svc.cache.workspaceBySlugMiss, "new-slug") {
+		t.Fatal("new slug still cached as missing")
+	}
+
+	// 其他工作空间的缓存不受影响
+	if _, ok := svc.cache.workspaceBySlug.Get("other"); !ok {
+		t.Fatal("other workspace evicted")
+	}
+	if _, ok := svc.cache.versionByID.Get("v2"); !ok {
+		t.Fatal("other version evicted")
+	}
+}
@@ -20,6 +20,7 @@ const (
 	PermissionPlanView             = "plan_view"
 	PermissionPlanManage           = "plan_manage"
 	PermissionConnectorsManage     = "connectors_manage"
+	PermissionSecretsManage        = "secrets_manage"
 )
 
 var defaultWorkspaceRolePermissions = map[string]entity.JSON{
@@ -36,6 +37,7 @@ var defaultWorkspaceRolePermissions = map[string]entity.JSON{
 		PermissionPlanView:             true,
 		PermissionPlanManage:           true,
 		PermissionConnectorsManage:     true,
+		PermissionSecretsManage:        true,
 	},
 	"admin": {
 		PermissionMembersManage:        true,
@@ -48,6 +50,7 @@ var defaultWorkspaceRolePermissions = map[string]entity.JSON{
 		PermissionPlanView:             true,
 		PermissionPlanManage:           true,
 		PermissionConnectorsManage:     true,
+		PermissionSecretsManage:        true,
 	},
 	"member": {
 		PermissionWorkspaceEdit:        true,
@@ -58,6 +61,7 @@ var defaultWorkspaceRolePermissions = map[string]entity.JSON{
 		PermissionPlanView:             true,
 		PermissionPlanManage:           false,
 		PermissionConnectorsManage:     false,
+		PermissionSecretsManage:        false,
 	},
 }
 
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/repository"
+	"gorm.io/gorm"
+)
+
+// QueryHistoryService SQL 编辑器查询历史服务接口
+type QueryHistoryService interface {
+	// Record 记录一次执行，并按保留策略清理旧记录（每个 Workspace 限频清理）
+	Record(ctx context.Context, item *entity.QueryHistory) error
+	List(ctx context.Context, params QueryHistoryListParams) ([]entity.QueryHistory, int64, error)
+	// Update 固定/取消固定记录并设置名称（保存的查询）
+	Update(ctx context.Context, workspaceID, id uuid.UUID, pinned *bool, name *string) (*entity.QueryHistory, error)
+	Delete(ctx context.Context, workspaceID, id uuid.UUID) error
+}
+
+// QueryHistoryListParams 查询历史查询参数
+type QueryHistoryListParams = repository.QueryHistoryListParams
+
+// QueryHistoryRetention 查询历史保留策略；零值表示不限制
+type QueryHistoryRetention struct {
+	// MaxEntries 每个 Workspace 保留的未固定记录条数
+	MaxEntries int
+	// MaxAge 未固定记录的最长保留时间
+	MaxAge time.Duration
+}
+
+var (
+	// ErrQueryHistoryNotFound 记录不存在或不属于该 Workspace
+	ErrQueryHistoryNotFound = errors.New("query history not found")
+	// ErrQueryHistoryNameTooLong 保存的查询名称过长
+	ErrQueryHistoryNameTooLong = errors.New("query name is too long")
+)
+
+// 单条记录中 SQL 与错误信息的最大长度（字节）
+const (
+	maxQueryHistorySQLBytes   = 64 << 10
+	maxQueryHistoryErrorBytes = 2000
+	maxQueryHistoryNameRunes  = 200
+)
+
+// queryHistoryPruneInterval 同一 Workspace 两次清理的最小间隔；
+// 间隔内记录数可能短暂超过 MaxEntries
+const queryHistoryPruneInterval = time.Minute
+
+type queryHistoryService struct {
+	repo      repository.QueryHistoryRepository
+	retention QueryHistoryRetention
+
+	pruneMu  sync.Mutex
+	prunedAt map[uuid.UUID]time.Time
+}
+
+// NewQueryHistoryService 创建查询历史服务
+func NewQueryHistoryService(repo repository.QueryHistoryRepository, retention QueryHistoryRetention) QueryHistoryService {
+	return &queryHistoryService{
+		repo:      repo,
+		retention: retention,
+		prunedAt:  make(map[uuid.UUID]time.Time),
+	}
+}
+
+func (s *queryHistoryService) Record(ctx context.Context, item *entity.QueryHistory) error {
+	item.SQL = truncateUTF8(item.SQL, maxQueryHistorySQLBytes)
+	item.Error = truncateUTF8(item.Error, maxQueryHistoryErrorBytes)
+	if item.Status == "" {
+		item.Status = entity.QueryStatusSuccess
+	}
+	if err := s.repo.Create(ctx, item); err != nil {
+		return fmt.Errorf("failed to record query history: %w", err)
+	}
+
+	if s.retention.MaxEntries <= 0 && s.retention.MaxAge <= 0 {
+		return nil
+	}
+	now := time.Now()
+	if !s.shouldPrune(item.WorkspaceID, now) {
+		return nil
+	}
+	var before time.Time
+	if s.retention.MaxAge > 0 {
+		before = now.Add(-s.retention.MaxAge)
+	}
+	if _, err := s.repo.Prune(ctx, item.WorkspaceID, s.retention.MaxEntries, before); err != nil {
+		return fmt.Errorf("failed to prune query history: %w", err)
+	}
+	return nil
+}
+
+// shouldPrune 对同一 Workspace 的清理限频，避免每次执行 SQL 都扫描历史表
+func (s *queryHistoryService) shouldPrune(workspaceID uuid.UUID, now time.Time) bool {
+	s.pruneMu.Lock()
+	defer s.pruneMu.Unlock()
+	if last, ok := s.prunedAt[workspaceID]; ok && now.Sub(last) < queryHistoryPruneInterval {
+		return false
+	}
+	s.prunedAt[workspaceID] = now
+	return true
+}
+
+func (s *queryHistoryService) List(ctx context.Context, params QueryHistoryListParams) ([]entity.QueryHistory, int64, error) {
+	return s.repo.List(ctx, params)
+}
+
+func (s *queryHistoryService) Update(ctx context.Context, workspaceID, id uuid.UUID, pinned *bool, name *string) (*entity.QueryHistory, error) {
+	item, err := s.get(ctx, workspaceID, id)
+	if err != nil {
+		return nil, err
+	}
+	if pinned != nil {
+		item.Pinned = *pinned
+	}
+	if name != nil {
+		trimmed := strings.TrimSpace(*name)
+		if utf8.RuneCountInString(trimmed) > maxQueryHistoryNameRunes {
+			return nil, ErrQueryHistoryNameTooLong
+		}
+		item.Name = trimmed
+	}
+	if err := s.repo.Update(ctx, item); err != nil {
+		return nil, fmt.Errorf("failed to update query history: %w", err)
+	}
+	return item, nil
+}
+
+func (s *queryHistoryService) Delete(ctx context.Context, workspaceID, id uuid.UUID) error {
+	if _, err := s.get(ctx, workspaceID, id); err != nil {
+		return err
+	}
+	return s.repo.Delete(ctx, id)
+}
+
+// get 读取记录并校验其所属 Workspace
+func (s *queryHistoryService) get(ctx context.Context, workspaceID, id uuid.UUID) (*entity.QueryHistory, error) {
+	item, err := s.repo.GetByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrQueryHistoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if item.WorkspaceID != workspaceID {
+		return nil, ErrQueryHistoryNotFound
+	}
+	return item, nil
+}
+
+// truncateUTF8 截断到最多 maxBytes 字节，不拆分多字节字符
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := maxBytes
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
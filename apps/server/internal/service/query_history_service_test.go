@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/repository"
+)
+
+// countingQueryHistoryRepo 只统计 Create 与 Prune 的调用次数
+type countingQueryHistoryRepo struct {
+	repository.QueryHistoryRepository
+	created int
+	pruned  map[uuid.UUID]int
+}
+
+func (r *countingQueryHistoryRepo) Create(_ context.Context, _ *entity.QueryHistory) error {
+	r.created++
+	return nil
+}
+
+func (r *countingQueryHistoryRepo) Prune(_ context.Context, workspaceID uuid.UUID, _ int, _ time.Time) (int64, error) {
+	r.pruned[workspaceID]++
+	return 0, nil
+}
+
+func TestQueryHistoryService_PruneIsThrottledPerWorkspace(t *testing.T) {
+	repo := &countingQueryHistoryRepo{pruned: map[uuid.UUID]int{}}
+	svc := NewQueryHistoryService(repo, QueryHistoryRetention{MaxEntries: 10}).(*queryHistoryService)
+	ctx := context.Background()
+	wsA, wsB := uuid.New(), uuid.New()
+
+	for i := 0; i < 5; i++ {
+		if err := svc.Record(ctx, &entity.QueryHistory{WorkspaceID: wsA, SQL: "SELECT 1"}); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	if err := svc.Record(ctx, &entity.QueryHistory{WorkspaceID: wsB, SQL: "SELECT 1"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if repo.created != 6 || repo.pruned[wsA] != 1 || repo.pruned[wsB] != 1 {
+		t.Fatalf("created=%d pruned=%v, want 6 records and one prune per workspace", repo.created, repo.pruned)
+	}
+
+	// 间隔过后再次清理
+	svc.pruneMu.Lock()
+	svc.prunedAt[wsA] = time.Now().Add(-queryHistoryPruneInterval)
+	svc.pruneMu.Unlock()
+	if err := svc.Record(ctx, &entity.QueryHistory{WorkspaceID: wsA, SQL: "SELECT 1"}); err != nil {
+		t.Fatalf("Record: %v", err)
+	}
+	if repo.pruned[wsA] != 2 {
+		t.Fatalf("pruned[wsA] = %d after the interval, want 2", repo.pruned[wsA])
+	}
+}
@@ -0,0 +1,608 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/reverseai/server/internal/domain/entity"
+)
+
+// RLS 表达式语言
+//
+// 表达式被编译成参数化的 SQLite WHERE 片段，只允许下列语法：
+//
+//	expr       := or
+//	or         := and { OR and }
+//	and        := not { AND not }
+//	not        := NOT not | primary
+//	primary    := '(' expr ')' | has_role '(' string ')' | comparison
+//	comparison := operand ( cmp operand | [NOT] LIKE operand | IS [NOT] NULL
+//	                      | [NOT] IN '(' ( subquery | operand { ',' operand } ) ')' )
+//	subquery   := SELECT column FROM table [ WHERE expr ]
+//	operand    := column | table.column | user.<attr> | 'string' | number | TRUE | FALSE
+//
+// user.<attr> 支持 id / email / role / display_name / status，编译时以参数绑定当前 AppUser 的值；
+// has_role('x') 在编译期求值为 1 或 0（AppUser.Role 可用逗号分隔多个角色）。
+// 列名与表名只允许字母、数字和下划线，并总是以双引号包裹。
+
+const (
+	rlsExpressionMaxLength   = 2000
+	rlsExpressionMaxDepth    = 32
+	rlsExpressionMaxSubquery = 2
+)
+
+// ErrInvalidRLSExpression RLS 表达式语法或语义错误
+var ErrInvalidRLSExpression = errors.New("invalid RLS expression")
+
+// RLSPredicate 编译后的 RLS 条件
+type RLSPredicate struct {
+	// Clause 参数化 SQLite WHERE 片段，为空表示不限制
+	Clause string
+	Args   []interface{}
+	// Owner 简单策略的归属列 → 当前用户的值，用于 insert 时自动写入
+	Owner map[string]string
+}
+
+// CompileRLSPolicies 将作用于 operation 的策略编译为单个条件，多条策略之间为 AND 关系
+// 返回 nil 表示没有策略作用于该操作
+func CompileRLSPolicies(policies []entity.RLSPolicy, operation string, user *entity.AppUser) (*RLSPredicate, error) {
+	var clauses []string
+	predicate := &RLSPredicate{Owner: map[string]string{}}
+	for _, policy := range policies {
+		if !policy.AppliesTo(operation) {
+			continue
+		}
+		if strings.TrimSpace(policy.Expression) != "" {
+			clause, args, err := CompileRLSExpression(policy.Expression, user)
+			if err != nil {
+				return nil, fmt.Errorf("policy %s: %w", policy.ID, err)
+			}
+			clauses = append(clauses, "("+clause+")")
+			predicate.Args = append(predicate.Args, args...)
+			continue
+		}
+		if !isRLSIdentifier(policy.Column) {
+			return nil, fmt.Errorf("policy %s: %w: invalid column %q", policy.ID, ErrInvalidRLSExpression, policy.Column)
+		}
+		value := rlsMatchValue(policy.MatchField, user)
+		clauses = append(clauses, fmt.Sprintf("%q = ?", policy.Column))
+		predicate.Args = append(predicate.Args, value)
+		predicate.Owner[policy.Column] = value
+	}
+	if len(clauses) == 0 {
+		return nil, nil
+	}
+	predicate.Clause = strings.Join(clauses, " AND ")
+	return predicate, nil
+}
+
+// CompileRLSExpression 将单条表达式编译为参数化 SQL 片段
+func CompileRLSExpression(expression string, user *entity.AppUser) (string, []interface{}, error) {
+	if len(expression) > rlsExpressionMaxLength {
+		return "", nil, fmt.Errorf("%w: expression exceeds %d characters", ErrInvalidRLSExpression, rlsExpressionMaxLength)
+	}
+	tokens, err := tokenizeRLSExpression(expression)
+	if err != nil {
+		return "", nil, err
+	}
+	if user == nil {
+		user = &entity.AppUser{}
+	}
+	p := &rlsParser{tokens: tokens, user: user}
+	clause, err := p.parseExpr()
+	if err != nil {
+		return "", nil, err
+	}
+	if !p.atEnd() {
+		return "", nil, p.errorf("unexpected %q", p.peek().text)
+	}
+	return clause, p.args, nil
+}
+
+// ValidateRLSExpression 校验表达式语法
+func ValidateRLSExpression(expression string) error {
+	_, _, err := CompileRLSExpression(expression, nil)
+	return err
+}
+
+// rlsMatchValue 返回简单策略中 match_field 对应的用户属性值
+func rlsMatchValue(matchField string, user *entity.AppUser) string {
+	if user == nil {
+		return ""
+	}
+	switch matchField {
+	case "email":
+		return user.Email
+	case "role":
+		return user.Role
+	default:
+		return user.ID.String()
+	}
+}
+
+// rlsUserAttribute 返回表达式中 user.<attr> 的值
+func rlsUserAttribute(user *entity.AppUser, attr string) (interface{}, bool) {
+	switch attr {
+	case "id":
+		return user.ID.String(), true
+	case "email":
+		return user.Email, true
+	case "role":
+		return user.Role, true
+	case "status":
+		return user.Status, true
+	case "display_name":
+		if user.DisplayName == nil {
+			return nil, true
+		}
+		return *user.DisplayName, true
+	}
+	return nil, false
+}
+
+// rlsUserHasRole 判断用户是否拥有角色（Role 可为逗号分隔列表）
+func rlsUserHasRole(user *entity.AppUser, role string) bool {
+	for _, r := range strings.Split(user.Role, ",") {
+		if strings.EqualFold(strings.TrimSpace(r), role) {
+			return true
+		}
+	}
+	return false
+}
+
+func isRLSIdentifier(s string) bool {
+	if s == "" || len(s) > 128 {
+		return false
+	}
+	for i, r := range s {
+		if r == '_' || (r < unicode.MaxASCII && unicode.IsLetter(r)) || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// ==================== 词法分析 ====================
+
+type rlsTokenKind int
+
+const (
+	rlsTokenIdent rlsTokenKind = iota
+	rlsTokenString
+	rlsTokenNumber
+	rlsTokenSymbol
+)
+
+type rlsToken struct {
+	kind rlsTokenKind
+	text string
+	pos  int
+}
+
+func tokenizeRLSExpression(input string) ([]rlsToken, error) {
+	var tokens []rlsToken
+	i := 0
+	for i < len(input) {
+		ch := input[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '\'':
+			start := i
+			var sb strings.Builder
+			i++
+			closed := false
+			for i < len(input) {
+				if input[i] == '\'' {
+					if i+1 < len(input) && input[i+1] == '\'' {
+						sb.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					closed = true
+					break
+				}
+				sb.WriteByte(input[i])
+				i++
+			}
+			if !closed {
+				return nil, fmt.Errorf("%w: unterminated string at %d", ErrInvalidRLSExpression, start)
+			}
+			tokens = append(tokens, rlsToken{kind: rlsTokenString, text: sb.String(), pos: start})
+		case ch >= '0' && ch <= '9' || (ch == '-' && i+1 < len(input) && input[i+1] >= '0' && input[i+1] <= '9'):
+			start := i
+			i++
+			for i < len(input) && (input[i] >= '0' && input[i] <= '9' || input[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, rlsToken{kind: rlsTokenNumber, text: input[start:i], pos: start})
+		case ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z'):
+			start := i
+			for i < len(input) && (input[i] == '_' || input[i] == '.' ||
+				(input[i] >= 'a' && input[i] <= 'z') || (input[i] >= 'A' && input[i] <= 'Z') ||
+				(input[i] >= '0' && input[i] <= '9')) {
+				i++
+			}
+			tokens = append(tokens, rlsToken{kind: rlsTokenIdent, text: input[start:i], pos: start})
+		default:
+			start := i
+			two := ""
+			if i+1 < len(input) {
+				two = input[i : i+2]
+			}
+			switch two {
+			case "!=", "<>", "<=", ">=":
+				tokens = append(tokens, rlsToken{kind: rlsTokenSymbol, text: two, pos: start})
+				i += 2
+				continue
+			}
+			switch ch {
+			case '=', '<', '>', '(', ')', ',':
+				tokens = append(tokens, rlsToken{kind: rlsTokenSymbol, text: string(ch), pos: start})
+				i++
+			default:
+				return nil, fmt.Errorf("%w: unexpected character %q at %d", ErrInvalidRLSExpression, ch, start)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// ==================== 语法分析 / 编译 ====================
+
+type rlsParser struct {
+	tokens   []rlsToken
+	pos      int
+	user     *entity.AppUser
+	args     []interface{}
+	depth    int
+	subquery int
+}
+
+func (p *rlsParser) atEnd() bool { return p.pos >= len(p.tokens) }
+
+func (p *rlsParser) peek() rlsToken {
+	if p.atEnd() {
+		return rlsToken{text: "<end>"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *rlsParser) next() rlsToken {
+	tok := p.peek()
+	if !p.atEnd() {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *rlsParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s at %d", ErrInvalidRLSExpression, fmt.Sprintf(format, args...), p.peek().pos)
+}
+
+// isKeyword 判断下一个 token 是否为指定关键字（大小写不敏感）
+func (p *rlsParser) isKeyword(kw string) bool {
+	tok := p.peek()
+	return !p.atEnd() && tok.kind == rlsTokenIdent && strings.EqualFold(tok.text, kw)
+}
+
+func (p *rlsParser) acceptKeyword(kw string) bool {
+	if p.isKeyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *rlsParser) isSymbol(sym string) bool {
+	tok := p.peek()
+	return !p.atEnd() && tok.kind == rlsTokenSymbol && tok.text == sym
+}
+
+func (p *rlsParser) expectSymbol(sym string) error {
+	if !p.isSymbol(sym) {
+		return p.errorf("expected %q, got %q", sym, p.peek().text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *rlsParser) enter() error {
+	p.depth++
+	if p.depth > rlsExpressionMaxDepth {
+		return p.errorf("expression nested too deeply")
+	}
+	return nil
+}
+
+func (p *rlsParser) leave() { p.depth-- }
+
+func (p *rlsParser) parseExpr() (string, error) {
+	if err := p.enter(); err != nil {
+		return "", err
+	}
+	defer p.leave()
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = left + " OR " + right
+	}
+	return left, nil
+}
+
+func (p *rlsParser) parseAnd() (string, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return "", err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		left = left + " AND " + right
+	}
+	return left, nil
+}
+
+func (p *rlsParser) parseNot() (string, error) {
+	if p.acceptKeyword("NOT") {
+		if err := p.enter(); err != nil {
+			return "", err
+		}
+		defer p.leave()
+		inner, err := p.parseNot()
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *rlsParser) parsePrimary() (string, error) {
+	if p.isSymbol("(") {
+		p.pos++
+		inner, err := p.parseExpr()
+		if err != nil {
+			return "", err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return "", err
+		}
+		return "(" + inner + ")", nil
+	}
+	if p.isKeyword("has_role") {
+		p.pos++
+		if err := p.expectSymbol("("); err != nil {
+			return "", err
+		}
+		tok := p.next()
+		if tok.kind != rlsTokenString {
+			return "", p.errorf("has_role expects a string literal")
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return "", err
+		}
+		if rlsUserHasRole(p.user, tok.text) {
+			return "1", nil
+		}
+		return "0", nil
+	}
+	return p.parseComparison()
+}
+
+func (p *rlsParser) parseComparison() (string, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+
+	if p.acceptKeyword("IS") {
+		if p.acceptKeyword("NOT") {
+			if !p.acceptKeyword("NULL") {
+				return "", p.errorf("expected NULL")
+			}
+			return left + " IS NOT NULL", nil
+		}
+		if !p.acceptKeyword("NULL") {
+			return "", p.errorf("expected NULL")
+		}
+		return left + " IS NULL", nil
+	}
+
+	negate := ""
+	if p.acceptKeyword("NOT") {
+		negate = "NOT "
+		if !p.isKeyword("IN") && !p.isKeyword("LIKE") {
+			return "", p.errorf("expected IN or LIKE after NOT")
+		}
+	}
+
+	if p.acceptKeyword("LIKE") {
+		right, err := p.parseOperand()
+		if err != nil {
+			return "", err
+		}
+		return left + " " + negate + "LIKE " + right, nil
+	}
+
+	if p.acceptKeyword("IN") {
+		if err := p.expectSymbol("("); err != nil {
+			return "", err
+		}
+		var list string
+		if p.isKeyword("SELECT") {
+			list, err = p.parseSubquery()
+		} else {
+			list, err = p.parseOperandList()
+		}
+		if err != nil {
+			return "", err
+		}
+		if err := p.expectSymbol(")"); err != nil {
+			return "", err
+		}
+		return left + " " + negate + "IN (" + list + ")", nil
+	}
+
+	tok := p.peek()
+	if tok.kind != rlsTokenSymbol {
+		return "", p.errorf("expected comparison operator, got %q", tok.text)
+	}
+	switch tok.text {
+	case "=", "!=", "<>", "<", "<=", ">", ">=":
+		p.pos++
+	default:
+		return "", p.errorf("expected comparison operator, got %q", tok.text)
+	}
+	right, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+	return left + " " + tok.text + " " + right, nil
+}
+
+func (p *rlsParser) parseOperandList() (string, error) {
+	var items []string
+	for {
+		item, err := p.parseOperand()
+		if err != nil {
+			return "", err
+		}
+		items = append(items, item)
+		if !p.isSymbol(",") {
+			break
+		}
+		p.pos++
+	}
+	return strings.Join(items, ", "), nil
+}
+
+func (p *rlsParser) parseSubquery() (string, error) {
+	p.subquery++
+	defer func() { p.subquery-- }()
+	if p.subquery > rlsExpressionMaxSubquery {
+		return "", p.errorf("subqueries nested too deeply")
+	}
+
+	p.pos++ // SELECT
+	column, err := p.parseColumn()
+	if err != nil {
+		return "", err
+	}
+	if !p.acceptKeyword("FROM") {
+		return "", p.errorf("expected FROM")
+	}
+	tok := p.next()
+	if tok.kind != rlsTokenIdent || !isRLSIdentifier(tok.text) {
+		return "", p.errorf("invalid table name %q", tok.text)
+	}
+	sql := fmt.Sprintf("SELECT %s FROM %q", column, tok.text)
+	if p.acceptKeyword("WHERE") {
+		where, err := p.parseExpr()
+		if err != nil {
+			return "", err
+		}
+		sql += " WHERE " + where
+	}
+	return sql, nil
+}
+
+// parseColumn 解析列引用（column 或 table.column），不允许 user.*
+func (p *rlsParser) parseColumn() (string, error) {
+	tok := p.peek()
+	if tok.kind != rlsTokenIdent || p.isReserved(tok.text) {
+		return "", p.errorf("expected column name, got %q", tok.text)
+	}
+	if strings.HasPrefix(strings.ToLower(tok.text), "user.") {
+		return "", p.errorf("user attributes cannot be selected")
+	}
+	p.pos++
+	return quoteRLSColumn(tok.text, p)
+}
+
+func (p *rlsParser) parseOperand() (string, error) {
+	tok := p.peek()
+	if p.atEnd() {
+		return "", p.errorf("unexpected end of expression")
+	}
+	switch tok.kind {
+	case rlsTokenString:
+		p.pos++
+		p.args = append(p.args, tok.text)
+		return "?", nil
+	case rlsTokenNumber:
+		p.pos++
+		if n, err := strconv.ParseInt(tok.text, 10, 64); err == nil {
+			p.args = append(p.args, n)
+			return "?", nil
+		}
+		f, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return "", p.errorf("invalid number %q", tok.text)
+		}
+		p.args = append(p.args, f)
+		return "?", nil
+	case rlsTokenIdent:
+		lower := strings.ToLower(tok.text)
+		switch lower {
+		case "true":
+			p.pos++
+			return "1", nil
+		case "false":
+			p.pos++
+			return "0", nil
+		}
+		if strings.HasPrefix(lower, "user.") {
+			value, ok := rlsUserAttribute(p.user, lower[len("user."):])
+			if !ok {
+				return "", p.errorf("unknown user attribute %q", tok.text)
+			}
+			p.pos++
+			p.args = append(p.args, value)
+			return "?", nil
+		}
+		if p.isReserved(tok.text) {
+			return "", p.errorf("unexpected keyword %q", tok.text)
+		}
+		p.pos++
+		return quoteRLSColumn(tok.text, p)
+	}
+	return "", p.errorf("unexpected %q", tok.text)
+}
+
+func (p *rlsParser) isReserved(word string) bool {
+	switch strings.ToUpper(word) {
+	case "AND", "OR", "NOT", "IN", "IS", "NULL", "LIKE", "SELECT", "FROM", "WHERE", "HAS_ROLE":
+		return true
+	}
+	return false
+}
+
+func quoteRLSColumn(ref string, p *rlsParser) (string, error) {
+	parts := strings.Split(ref, ".")
+	if len(parts) > 2 {
+		return "", p.errorf("invalid column reference %q", ref)
+	}
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		if !isRLSIdentifier(part) {
+			return "", p.errorf("invalid column reference %q", ref)
+		}
+		quoted[i] = fmt.Sprintf("%q", part)
+	}
+	return strings.Join(quoted, "."), nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+)
+
+func TestCompileRLSExpression_UserAttributesAreParameters(t *testing.T) {
+	user := &entity.AppUser{ID: uuid.New(), Email: "a@example.com", Role: "manager"}
+
+	clause, args, err := CompileRLSExpression("is_public = 1 OR owner_id = user.id", user)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if clause != `"is_public" = ? OR "owner_id" = ?` {
+		t.Fatalf("clause = %s", clause)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(1), user.ID.String()}) {
+		t.Fatalf("args = %v", args)
+	}
+}
+
+func TestCompileRLSExpression_SubqueryAndRoles(t *testing.T) {
+	user := &entity.AppUser{ID: uuid.New(), Role: "user,manager"}
+
+	clause, args, err := CompileRLSExpression(
+		"has_role('manager') AND team_id IN (SELECT team_id FROM team_members WHERE member_id = user.id)", user)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	want := `1 AND "team_id" IN (SELECT "team_id" FROM "team_members" WHERE "member_id" = ?)`
+	if clause != want {
+		t.Fatalf("clause = %s\nwant    %s", clause, want)
+	}
+	if len(args) != 1 || args[0] != user.ID.String() {
+		t.Fatalf("args = %v", args)
+	}
+
+	clause, _, err = CompileRLSExpression("has_role('admin') OR NOT (status IN ('draft', 'hidden'))", user)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if clause != `0 OR NOT ("status" IN (?, ?))` {
+		t.Fatalf("clause = %s", clause)
+	}
+}
+
+func TestCompileRLSExpression_RejectsUnsafeInput(t *testing.T) {
+	cases := []string{
+		"owner_id = user.id; DROP TABLE notes",
+		"owner_id = user.password_hash",
+		`"owner_id" = 1`,
+		"owner_id = 1 -- comment",
+		"id IN (SELECT id FROM notes UNION SELECT id FROM secrets)",
+		"a.b.c = 1",
+		"owner_id = ",
+		"owner_id = 'unterminated",
+		"(owner_id = 1",
+		"SELECT = 1",
+		"owner_id = sqlite_version()",
+	}
+	for _, expr := range cases {
+		if _, _, err := CompileRLSExpression(expr, nil); !errors.Is(err, ErrInvalidRLSExpression) {
+			t.Errorf("expected ErrInvalidRLSExpression for %q, got %v", expr, err)
+		}
+	}
+}
+
+func TestCompileRLSExpression_StringLiteralsAreBound(t *testing.T) {
+	clause, args, err := CompileRLSExpression("title = 'x'' OR 1=1 --'", nil)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if clause != `"title" = ?` || args[0] != "x' OR 1=1 --" {
+		t.Fatalf("clause = %s args = %v", clause, args)
+	}
+}
+
+func TestCompileRLSPolicies_CombinesApplicablePolicies(t *testing.T) {
+	user := &entity.AppUser{ID: uuid.New(), Email: "a@example.com"}
+	policies := []entity.RLSPolicy{
+		{Column: "owner_email", MatchField: "email", Operation: entity.RLSOperationAll},
+		{Expression: "archived = 0", Operation: entity.RLSOperationSelect},
+		{Expression: "locked = 0", Operation: entity.RLSOperationUpdate},
+	}
+
+	predicate, err := CompileRLSPolicies(policies, entity.RLSOperationSelect, user)
+	if err != nil {
+		t.Fatalf("compile: %v", err)
+	}
+	if predicate.Clause != `"owner_email" = ? AND ("archived" = ?)` {
+		t.Fatalf("clause = %s", predicate.Clause)
+	}
+	if predicate.Owner["owner_email"] != user.Email {
+		t.Fatalf("owner = %v", predicate.Owner)
+	}
+
+	predicate, err = CompileRLSPolicies(policies[1:2], entity.RLSOperationDelete, user)
+	if err != nil || predicate != nil {
+		t.Fatalf("expected no predicate for delete, got %+v, %v", predicate, err)
+	}
+}
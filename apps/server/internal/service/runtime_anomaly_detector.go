@@ -0,0 +1,235 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// runtimeAnomalyDetector 基于滚动窗口的异常流量检测器（进程内）
+// 以秒为粒度统计请求数与失败数，按 IP 与 Workspace 两个维度聚合
+// 每个序列有独立的锁，全局锁只保护序列表本身
+type runtimeAnomalyDetector struct {
+	mu        sync.RWMutex
+	series    map[string]*trafficSeries
+	reportMu  sync.Mutex
+	reported  map[string]time.Time
+	retention time.Duration
+	cooldown  time.Duration
+	lastSweep atomic.Int64
+}
+
+// trafficSeries 按时间升序保存有流量的秒，计数为累计值，
+// 任意窗口内的数量等于两端累计值之差，无需逐秒求和
+type trafficSeries struct {
+	mu       sync.Mutex
+	buckets  []trafficBucket
+	pruned   trafficBucket // 已清理的秒的累计值
+	lastSeen time.Time
+}
+
+type trafficBucket struct {
+	second   int64
+	requests int
+	failures int
+}
+
+const (
+	anomalyDetectorRetention      = time.Hour
+	anomalyDetectorReportCooldown = time.Minute
+	anomalyDetectorSweepInterval  = time.Minute
+
+	RuntimeSignalHighFrequency = "high_frequency"
+	RuntimeSignalRateSpike     = "rate_spike"
+	RuntimeSignalFailureRate   = "failure_rate"
+)
+
+func newRuntimeAnomalyDetector() *runtimeAnomalyDetector {
+	return &runtimeAnomalyDetector{
+		series:    make(map[string]*trafficSeries),
+		reported:  make(map[string]time.Time),
+		retention: anomalyDetectorRetention,
+		cooldown:  anomalyDetectorReportCooldown,
+	}
+}
+
+func anomalyIPKey(workspaceID, ipHash string) string {
+	return workspaceID + ":ip:" + ipHash
+}
+
+func anomalyWorkspaceKey(workspaceID string) string {
+	return workspaceID + ":workspace"
+}
+
+// observeRequest 记录一次请求
+func (d *runtimeAnomalyDetector) observeRequest(key string, now time.Time) {
+	d.observe(key, now, false)
+}
+
+// observeFailure 记录一次失败（限流拒绝、4xx/5xx 等）
+// 失败是对已记录请求的补充标记，不重复累加请求数
+func (d *runtimeAnomalyDetector) observeFailure(key string, now time.Time) {
+	d.observe(key, now, true)
+}
+
+func (d *runtimeAnomalyDetector) observe(key string, now time.Time, failure bool) {
+	if d == nil || key == "" {
+		return
+	}
+	d.sweep(now)
+
+	d.mu.RLock()
+	series, ok := d.series[key]
+	d.mu.RUnlock()
+	if !ok {
+		d.mu.Lock()
+		if series, ok = d.series[key]; !ok {
+			series = &trafficSeries{}
+			d.series[key] = series
+		}
+		d.mu.Unlock()
+	}
+
+	series.mu.Lock()
+	defer series.mu.Unlock()
+	series.prune(now.Add(-d.retention).Unix())
+	second := now.Unix()
+	n := len(series.buckets)
+	// 乱序到达的旧时间计入最新的一秒，保持累计值单调
+	if n == 0 || series.buckets[n-1].second < second {
+		last := series.pruned
+		if n > 0 {
+			last = series.buckets[n-1]
+		}
+		last.second = second
+		series.buckets = append(series.buckets, last)
+		n++
+	}
+	if failure {
+		series.buckets[n-1].failures++
+	} else {
+		series.buckets[n-1].requests++
+	}
+	series.lastSeen = now
+}
+
+// prune 清理 cutoff 及之前的秒，累计值并入 pruned
+func (s *trafficSeries) prune(cutoff int64) {
+	i := sort.Search(len(s.buckets), func(i int) bool { return s.buckets[i].second > cutoff })
+	if i == 0 {
+		return
+	}
+	s.pruned = s.buckets[i-1]
+	s.buckets = s.buckets[i:]
+}
+
+// totalAt 返回截至 second（含）的累计请求数与失败数
+func (s *trafficSeries) totalAt(second int64) (int, int) {
+	i := sort.Search(len(s.buckets), func(i int) bool { return s.buckets[i].second > second })
+	if i == 0 {
+		return s.pruned.requests, s.pruned.failures
+	}
+	return s.buckets[i-1].requests, s.buckets[i-1].failures
+}
+
+// counts 返回 (end-window, end] 区间内的请求数与失败数
+func (d *runtimeAnomalyDetector) counts(key string, end time.Time, window time.Duration) (int, int) {
+	if d == nil || key == "" || window <= 0 {
+		return 0, 0
+	}
+	d.mu.RLock()
+	series, ok := d.series[key]
+	d.mu.RUnlock()
+	if !ok {
+		return 0, 0
+	}
+
+	series.mu.Lock()
+	defer series.mu.Unlock()
+	endRequests, endFailures := series.totalAt(end.Unix())
+	startRequests, startFailures := series.totalAt(end.Add(-window).Unix())
+	return endRequests - startRequests, endFailures - startFailures
+}
+
+// evaluate 根据配置计算当前请求触发的风险信号
+func (d *runtimeAnomalyDetector) evaluate(workspaceID, ipHash string, config runtimeAnomalyConfig, now time.Time) []string {
+	if d == nil {
+		return nil
+	}
+	var signals []string
+	ipKey := ""
+	if ipHash != "" {
+		ipKey = anomalyIPKey(workspaceID, ipHash)
+	}
+	workspaceKey := anomalyWorkspaceKey(workspaceID)
+
+	if ipKey != "" && config.highFreq.enabled() {
+		requests, _ := d.counts(ipKey, now, config.highFreq.window)
+		if requests >= config.highFreq.maxRequests {
+			signals = append(signals, RuntimeSignalHighFrequency)
+		}
+	}
+
+	if ipKey != "" && config.failureRate.window > 0 && config.failureRate.threshold > 0 {
+		requests, failures := d.counts(ipKey, now, config.failureRate.window)
+		if requests > 0 && requests >= config.failureRate.minRequests {
+			if float64(failures)/float64(requests) >= config.failureRate.threshold {
+				signals = append(signals, RuntimeSignalFailureRate)
+			}
+		}
+	}
+
+	if config.spike.window > 0 && config.spike.ratio > 0 {
+		current, _ := d.counts(workspaceKey, now, config.spike.window)
+		previous, _ := d.counts(workspaceKey, now.Add(-config.spike.window), config.spike.window)
+		if previous >= config.spike.minPrevious && previous > 0 {
+			if float64(current) >= float64(previous)*config.spike.ratio {
+				signals = append(signals, RuntimeSignalRateSpike)
+			}
+		}
+	}
+
+	return signals
+}
+
+// shouldReport 对同一 key 的告警做冷却，避免攻击期间每个请求都写一条事件
+func (d *runtimeAnomalyDetector) shouldReport(key string, now time.Time) bool {
+	if d == nil {
+		return false
+	}
+	d.reportMu.Lock()
+	defer d.reportMu.Unlock()
+	if last, ok := d.reported[key]; ok && now.Sub(last) < d.cooldown {
+		return false
+	}
+	d.reported[key] = now
+	return true
+}
+
+// sweep 定期删除长时间没有流量的序列与过期的冷却记录，同一时刻只有一个调用方执行
+func (d *runtimeAnomalyDetector) sweep(now time.Time) {
+	last := d.lastSweep.Load()
+	if now.UnixNano()-last < int64(anomalyDetectorSweepInterval) || !d.lastSweep.CompareAndSwap(last, now.UnixNano()) {
+		return
+	}
+
+	d.mu.Lock()
+	for key, series := range d.series {
+		series.mu.Lock()
+		idle := now.Sub(series.lastSeen) > d.retention
+		series.mu.Unlock()
+		if idle {
+			delete(d.series, key)
+		}
+	}
+	d.mu.Unlock()
+
+	d.reportMu.Lock()
+	for key, reportedAt := range d.reported {
+		if now.Sub(reportedAt) > d.cooldown {
+			delete(d.reported, key)
+		}
+	}
+	d.reportMu.Unlock()
+}
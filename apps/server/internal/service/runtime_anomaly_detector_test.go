@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+)
+
+func hasSignal(signals []string, want string) bool {
+	for _, signal := range signals {
+		if signal == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAnomalyDetector_HighFrequency(t *testing.T) {
+	d := newRuntimeAnomalyDetector()
+	now := time.Unix(1_700_000_000, 0)
+	config := runtimeAnomalyConfig{highFreq: rateLimitRule{maxRequests: 5, window: time.Minute}}
+
+	for i := 0; i < 4; i++ {
+		d.observeRequest(anomalyIPKey("ws", "ip"), now)
+	}
+	if signals := d.evaluate("ws", "ip", config, now); hasSignal(signals, RuntimeSignalHighFrequency) {
+		t.Fatalf("unexpected high_frequency below threshold: %v", signals)
+	}
+
+	d.observeRequest(anomalyIPKey("ws", "ip"), now)
+	if signals := d.evaluate("ws", "ip", config, now); !hasSignal(signals, RuntimeSignalHighFrequency) {
+		t.Fatalf("expected high_frequency, got %v", signals)
+	}
+
+	// Requests from another IP are tracked independently.
+	if signals := d.evaluate("ws", "other-ip", config, now); hasSignal(signals, RuntimeSignalHighFrequency) {
+		t.Fatalf("high_frequency leaked across IPs: %v", signals)
+	}
+
+	// Outside the rolling window the signal clears.
+	if signals := d.evaluate("ws", "ip", config, now.Add(2*time.Minute)); hasSignal(signals, RuntimeSignalHighFrequency) {
+		t.Fatalf("expected signal to expire, got %v", signals)
+	}
+}
+
+func TestAnomalyDetector_FailureRate(t *testing.T) {
+	d := newRuntimeAnomalyDetector()
+	now := time.Unix(1_700_000_000, 0)
+	config := runtimeAnomalyConfig{failureRate: failureRateRule{threshold: 0.5, minRequests: 4, window: time.Minute}}
+	key := anomalyIPKey("ws", "ip")
+
+	for i := 0; i < 4; i++ {
+		d.observeRequest(key, now)
+	}
+	d.observeFailure(key, now)
+	if signals := d.evaluate("ws", "ip", config, now); hasSignal(signals, RuntimeSignalFailureRate) {
+		t.Fatalf("unexpected failure_rate at 25%%: %v", signals)
+	}
+
+	d.observeFailure(key, now)
+	if signals := d.evaluate("ws", "ip", config, now); !hasSignal(signals, RuntimeSignalFailureRate) {
+		t.Fatalf("expected failure_rate at 50%%, got %v", signals)
+	}
+}
+
+func TestAnomalyDetector_FailureRateRequiresMinRequests(t *testing.T) {
+	d := newRuntimeAnomalyDetector()
+	now := time.Unix(1_700_000_000, 0)
+	config := runtimeAnomalyConfig{failureRate: failureRateRule{threshold: 0.5, minRequests: 5, window: time.Minute}}
+	key := anomalyIPKey("ws", "ip")
+
+	d.observeRequest(key, now)
+	d.observeFailure(key, now)
+	if signals := d.evaluate("ws", "ip", config, now); len(signals) != 0 {
+		t.Fatalf("expected no signals below min_requests, got %v", signals)
+	}
+}
+
+func TestAnomalyDetector_RateSpike(t *testing.T) {
+	d := newRuntimeAnomalyDetector()
+	start := time.Unix(1_700_000_000, 0)
+	config := runtimeAnomalyConfig{spike: spikeRule{ratio: 3, minPrevious: 5, window: time.Minute}}
+	key := anomalyWorkspaceKey("ws")
+
+	// Previous window: 5 requests.
+	for i := 0; i < 5; i++ {
+		d.observeRequest(key, start.Add(time.Duration(i)*time.Second))
+	}
+	now := start.Add(90 * time.Second)
+	for i := 0; i < 14; i++ {
+		d.observeRequest(key, now)
+	}
+	if signals := d.evaluate("ws", "", config, now); hasSignal(signals, RuntimeSignalRateSpike) {
+		t.Fatalf("unexpected rate_spike below ratio: %v", signals)
+	}
+
+	d.observeRequest(key, now)
+	if signals := d.evaluate("ws", "", config, now); !hasSignal(signals, RuntimeSignalRateSpike) {
+		t.Fatalf("expected rate_spike, got %v", signals)
+	}
+}
+
+func TestAnomalyDetector_ReportCooldown(t *testing.T) {
+	d := newRuntimeAnomalyDetector()
+	now := time.Unix(1_700_000_000, 0)
+
+	if !d.shouldReport("k", now) {
+		t.Fatal("first report should pass")
+	}
+	if d.shouldReport("k", now.Add(10*time.Second)) {
+		t.Fatal("report within cooldown should be suppressed")
+	}
+	if !d.shouldReport("k", now.Add(2*time.Minute)) {
+		t.Fatal("report after cooldown should pass")
+	}
+}
+
+func TestTrackAnonymousAccess_LoadShedsHighFrequencyExecute(t *testing.T) {
+	svc := &runtimeService{
+		rateLimiter: NewMemoryRateLimiter(),
+		anomaly:     newRuntimeAnomalyDetector(),
+	}
+	entry := &RuntimeEntry{Workspace: &entity.Workspace{
+		ID:         uuid.New(),
+		AccessMode: "public_anonymous",
+		RateLimitJSON: entity.JSON{
+			"per_ip": map[string]interface{}{"max_requests": 10, "window_seconds": 60},
+		},
+	}}
+	meta := RuntimeAccessMeta{IP: "203.0.113.7", EventType: RuntimeEventExecute, SkipSession: true}
+
+	// high_frequency defaults to 80% of per_ip, so the 8th request trips it.
+	for i := 0; i < 7; i++ {
+		if _, err := svc.TrackAnonymousAccess(context.Background(), entry, meta); err != nil {
+			t.Fatalf("request %d: unexpected error %v", i, err)
+		}
+	}
+	_, err := svc.TrackAnonymousAccess(context.Background(), entry, meta)
+	if !errors.Is(err, ErrRuntimeOverloaded) {
+		t.Fatalf("expected ErrRuntimeOverloaded, got %v", err)
+	}
+
+	// Non-execute access is flagged but not shed.
+	meta.EventType = RuntimeEventEntry
+	result, err := svc.TrackAnonymousAccess(context.Background(), entry, meta)
+	if err != nil {
+		t.Fatalf("entry access should not be shed: %v", err)
+	}
+	if !result.Decision.RequireCaptcha || !hasSignal(result.Decision.RiskSignals, RuntimeSignalHighFrequency) {
+		t.Fatalf("expected captcha + high_frequency signal, got %+v", result.Decision)
+	}
+}
+
+func TestAnomalyDetector_CountsAcrossRetention(t *testing.T) {
+	d := newRuntimeAnomalyDetector()
+	start := time.Unix(1_700_000_000, 0)
+	key := anomalyIPKey("ws", "ip")
+
+	for i := 0; i < 3; i++ {
+		d.observeRequest(key, start.Add(time.Duration(i)*time.Second))
+	}
+	d.observeFailure(key, start.Add(2*time.Second))
+	if requests, failures := d.counts(key, start.Add(2*time.Second), 2*time.Second); requests != 2 || failures != 1 {
+		t.Fatalf("counts = %d/%d, want 2/1", requests, failures)
+	}
+
+	// Buckets older than the retention are dropped without skewing later windows.
+	later := start.Add(2 * time.Hour)
+	d.observeRequest(key, later)
+	if requests, failures := d.counts(key, later, time.Minute); requests != 1 || failures != 0 {
+		t.Fatalf("counts after retention = %d/%d, want 1/0", requests, failures)
+	}
+	if requests, _ := d.counts(key, start.Add(time.Minute), time.Minute); requests != 0 {
+		t.Fatalf("pruned window still counts %d requests", requests)
+	}
+}
+
+func TestRecordExecutionResult_OnlyCountsAnonymousFailures(t *testing.T) {
+	svc := &runtimeService{anomaly: newRuntimeAnomalyDetector()}
+	meta := RuntimeAccessMeta{IP: "203.0.113.7"}
+
+	for _, mode := range []string{"private", "public_auth", "public_anonymous"} {
+		entry := &RuntimeEntry{Workspace: &entity.Workspace{ID: uuid.New(), AccessMode: mode}}
+		if err := svc.RecordExecutionResult(context.Background(), entry, nil, meta, true, nil); err != nil {
+			t.Fatalf("%s: RecordExecutionResult: %v", mode, err)
+		}
+		_, failures := svc.anomaly.counts(anomalyIPKey(entry.Workspace.ID.String(), hashValue(meta.IP)), time.Now(), time.Minute)
+		want := 0
+		if mode == "public_anonymous" {
+			want = 1
+		}
+		if failures != want {
+			t.Fatalf("%s: failures = %d, want %d", mode, failures, want)
+		}
+	}
+}
@@ -7,68 +7,171 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/mail"
 	"github.com/reverseai/server/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// 应用用户会话有效期：Token 用于访问，Refresh Token 每次刷新时轮换并顺延（滑动过期）
+const (
+	appSessionTokenTTL      = 24 * time.Hour
+	appSessionRefreshTTL    = 30 * 24 * time.Hour
+	appSessionTouchInterval = 5 * time.Minute
+)
+
+// 一次性令牌有效期（与平台用户的重置密码、验证邮箱一致）
+const (
+	appResetPasswordTTL = time.Hour
+	appVerifyEmailTTL   = 24 * time.Hour
+	appInviteTTL        = 7 * 24 * time.Hour
+)
+
+// 邮件限流：同一 IP 每小时最多触发 appMailIPLimit 封邮件，同一工作空间的同一邮箱在冷却期内只发一封
+const (
+	appMailIPLimit   = 20
+	appMailIPWindow  = time.Hour
+	appMailCooldown  = time.Minute
+	appMailSendLimit = 30 * time.Second
+)
+
+// appUserRolePattern 角色名，多个角色以逗号分隔（与 RLS 的 has_role 一致）
+var appUserRolePattern = regexp.MustCompile(`^[a-z0-9_-]+(,[a-z0-9_-]+)*$`)
+
+var (
+	// ErrInvalidAppSession Token 或 Refresh Token 无效、过期、已撤销或不属于该工作空间
+	ErrInvalidAppSession = errors.New("invalid or expired session")
+	// ErrAppUserNotFound 应用用户不存在或不属于该工作空间
+	ErrAppUserNotFound = errors.New("user not found in workspace")
+	// ErrAppSessionNotFound 会话不存在或不属于该应用用户
+	ErrAppSessionNotFound = errors.New("session not found")
+	// ErrAppUserExists 邮箱已注册（或已接受邀请）
+	ErrAppUserExists = errors.New("email already registered")
+	// ErrInvalidAppUserToken 重置密码、验证邮箱或邀请令牌无效、过期或已使用
+	ErrInvalidAppUserToken = errors.New("invalid or expired token")
+	// ErrAppEmailAlreadyVerified 邮箱已验证
+	ErrAppEmailAlreadyVerified = errors.New("email already verified")
+	// ErrInvalidAppUserRole 角色名不合法
+	ErrInvalidAppUserRole = errors.New("invalid role")
+	// ErrMailNotConfigured 未配置邮件发送
+	ErrMailNotConfigured = errors.New("mail delivery is not configured")
+)
+
 // RuntimeAuthService 应用运行时认证服务
+// 会话绑定到登录时的工作空间，校验、刷新与登出都须携带访问的工作空间
 type RuntimeAuthService interface {
 	Register(ctx context.Context, workspaceID uuid.UUID, email, password, displayName string) (*entity.AppUser, error)
 	Login(ctx context.Context, workspaceID uuid.UUID, email, password string) (*RuntimeAuthResult, error)
-	ValidateSession(ctx context.Context, token string) (*entity.AppUser, error)
-	Logout(ctx context.Context, token string) error
+	// Refresh 用 Refresh Token 换取新的 Token 与 Refresh Token，旧的 Refresh Token 随即失效
+	Refresh(ctx context.Context, workspaceID uuid.UUID, refreshToken string) (*RuntimeAuthResult, error)
+	// ValidateSession 校验 Token，返回会话所属的应用用户与会话（用于会话 ID 与过期时间）
+	ValidateSession(ctx context.Context, workspaceID uuid.UUID, token string) (*entity.AppUser, *entity.WorkspaceSession, error)
+	Logout(ctx context.Context, workspaceID uuid.UUID, token string) error
+	// LogoutAll 撤销 Token 所属应用用户的全部会话
+	LogoutAll(ctx context.Context, workspaceID uuid.UUID, token string) error
 	ListUsers(ctx context.Context, workspaceID uuid.UUID, page, pageSize int) ([]entity.AppUser, int64, error)
-	BlockUser(ctx context.Context, userID uuid.UUID) error
+	GetUser(ctx context.Context, workspaceID, userID uuid.UUID) (*entity.AppUser, error)
+	// BlockUser 封禁应用用户并撤销其全部会话
+	BlockUser(ctx context.Context, workspaceID, userID uuid.UUID) error
+	ListSessions(ctx context.Context, workspaceID, userID uuid.UUID) ([]entity.WorkspaceSession, error)
+	RevokeSession(ctx context.Context, workspaceID, userID, sessionID uuid.UUID) error
+	RevokeUserSessions(ctx context.Context, workspaceID, userID uuid.UUID) (int64, error)
+
+	// ForgotPassword 向邮箱发送重置密码链接；邮箱不存在时同样返回成功，避免泄露注册情况
+	// 邮件在后台发送，两种情况耗时一致；ip 为请求方 IP，用于限流
+	ForgotPassword(ctx context.Context, workspaceID uuid.UUID, email, ip string) error
+	// ResetPassword 用重置令牌设置新密码，并撤销该用户的全部会话
+	ResetPassword(ctx context.Context, workspaceID uuid.UUID, token, newPassword string) error
+	VerifyEmail(ctx context.Context, workspaceID uuid.UUID, token string) error
+	// ResendVerification 向当前会话的用户重新发送验证邮件
+	ResendVerification(ctx context.Context, workspaceID uuid.UUID, sessionToken, ip string) error
+	// InviteUser 由工作空间成员邀请应用用户并预设角色；重复邀请未接受的用户会刷新角色并重新发送
+	InviteUser(ctx context.Context, workspaceID, inviterID uuid.UUID, email, role, displayName, ip string) (*entity.AppUser, error)
+	// AcceptInvitation 用邀请令牌设置密码并登录
+	AcceptInvitation(ctx context.Context, workspaceID uuid.UUID, token, password, displayName string) (*RuntimeAuthResult, error)
+
+	// SetMailer 设置邮件发送器与邮件链接指向的 App 前端地址；未设置时不发送验证邮件，其余需要邮件的操作返回 ErrMailNotConfigured
+	SetMailer(sender mail.Sender, appBaseURL string)
+	// SetRateLimiter 设置邮件限流器；未设置时不限流
+	SetRateLimiter(limiter RuntimeRateLimiter)
+	// SetInvalidationBus 设置失效总线，会话撤销时广播给各实例以关闭对应的实时连接
+	SetInvalidationBus(bus InvalidationBus)
 }
 
-// RuntimeAuthResult 登录结果
+// RuntimeAuthResult 登录/刷新结果
 type RuntimeAuthResult struct {
-	User      *entity.AppUser `json:"user"`
-	Token     string          `json:"token"`
-	ExpiresAt time.Time       `json:"expires_at"`
+	User             *entity.AppUser `json:"user"`
+	SessionID        uuid.UUID       `json:"session_id"`
+	Token            string          `json:"token"`
+	ExpiresAt        time.Time       `json:"expires_at"`
+	RefreshToken     string          `json:"refresh_token"`
+	RefreshExpiresAt time.Time       `json:"refresh_expires_at"`
 }
 
 type runtimeAuthService struct {
 	appUserRepo   repository.AppUserRepository
 	workspaceRepo repository.WorkspaceRepository
-	sessionRepo   repository.SessionRepository
-	db            *gorm.DB
+	sessionRepo   repository.AppSessionRepository
+	tokenRepo     repository.AppUserTokenRepository
+	log           logger.Logger
+	mailer        mail.Sender
+	appBaseURL    string
+	limiter       RuntimeRateLimiter
+	invalidation  InvalidationBus
 	tokenTTL      time.Duration
+	refreshTTL    time.Duration
 }
 
 // NewRuntimeAuthService 创建运行时认证服务
 func NewRuntimeAuthService(
 	appUserRepo repository.AppUserRepository,
 	workspaceRepo repository.WorkspaceRepository,
-	sessionRepo repository.SessionRepository,
+	sessionRepo repository.AppSessionRepository,
+	tokenRepo repository.AppUserTokenRepository,
+	log logger.Logger,
 ) RuntimeAuthService {
 	return &runtimeAuthService{
 		appUserRepo:   appUserRepo,
 		workspaceRepo: workspaceRepo,
 		sessionRepo:   sessionRepo,
-		tokenTTL:      24 * time.Hour,
+		tokenRepo:     tokenRepo,
+		log:           log,
+		tokenTTL:      appSessionTokenTTL,
+		refreshTTL:    appSessionRefreshTTL,
 	}
 }
 
-// NewRuntimeAuthServiceWithDB 创建带 DB 的运行时认证服务（用于直接操作 WorkspaceSession）
-func NewRuntimeAuthServiceWithDB(
-	appUserRepo repository.AppUserRepository,
-	workspaceRepo repository.WorkspaceRepository,
-	sessionRepo repository.SessionRepository,
-	db *gorm.DB,
-) RuntimeAuthService {
-	return &runtimeAuthService{
-		appUserRepo:   appUserRepo,
-		workspaceRepo: workspaceRepo,
-		sessionRepo:   sessionRepo,
-		db:            db,
-		tokenTTL:      24 * time.Hour,
+func (s *runtimeAuthService) SetMailer(sender mail.Sender, appBaseURL string) {
+	s.mailer = sender
+	s.appBaseURL = strings.TrimRight(appBaseURL, "/")
+}
+
+func (s *runtimeAuthService) SetRateLimiter(limiter RuntimeRateLimiter) {
+	s.limiter = limiter
+}
+
+func (s *runtimeAuthService) SetInvalidationBus(bus InvalidationBus) {
+	s.invalidation = bus
+}
+
+// sessionsRevoked 广播会话撤销；sessionID 为 uuid.Nil 时表示该用户的全部会话
+func (s *runtimeAuthService) sessionsRevoked(ctx context.Context, workspaceID, userID, sessionID uuid.UUID) {
+	if s.invalidation == nil {
+		return
+	}
+	event := InvalidationEvent{Kind: InvalidationAppSessions, WorkspaceID: workspaceID.String(), AppUserID: userID.String()}
+	if sessionID != uuid.Nil {
+		event.SessionID = sessionID.String()
 	}
+	_ = s.invalidation.Publish(ctx, event)
 }
 
 func (s *runtimeAuthService) Register(ctx context.Context, workspaceID uuid.UUID, email, password, displayName string) (*entity.AppUser, error) {
@@ -87,7 +190,7 @@ func (s *runtimeAuthService) Register(ctx context.Context, workspaceID uuid.UUID
 	// Check if email already exists
 	existing, err := s.appUserRepo.GetByEmail(ctx, workspaceID, email)
 	if err == nil && existing != nil {
-		return nil, errors.New("email already registered")
+		return nil, ErrAppUserExists
 	}
 
 	// Hash password
@@ -110,6 +213,16 @@ func (s *runtimeAuthService) Register(ctx context.Context, workspaceID uuid.UUID
 	if err := s.appUserRepo.Create(ctx, user); err != nil {
 		return nil, fmt.Errorf("failed to create app user: %w", err)
 	}
+
+	// 注册已完成，验证邮件发送失败时用户可稍后重新发送
+	if s.mailer != nil {
+		if err := s.sendVerification(ctx, ws, user); err != nil {
+			s.log.Warn("Failed to send app user verification email",
+				"workspaceId", workspaceID,
+				"appUserId", user.ID,
+				"error", err)
+		}
+	}
 	return user, nil
 }
 
@@ -125,112 +238,572 @@ func (s *runtimeAuthService) Login(ctx context.Context, workspaceID uuid.UUID, e
 	if user.Status == "blocked" {
 		return nil, errors.New("account is blocked")
 	}
+	if user.Status == "invited" {
+		return nil, errors.New("invitation has not been accepted")
+	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
 		return nil, errors.New("invalid email or password")
 	}
 
-	// Generate session token
-	token, err := generateSecureToken(32)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate token: %w", err)
-	}
+	return s.startSession(ctx, user, "password")
+}
 
+// startSession 记录登录时间并创建新会话
+func (s *runtimeAuthService) startSession(ctx context.Context, user *entity.AppUser, authMethod string) (*RuntimeAuthResult, error) {
 	// Update last login
 	now := time.Now()
 	user.LastLoginAt = &now
 	_ = s.appUserRepo.Update(ctx, user)
 
-	expiresAt := now.Add(s.tokenTTL)
-
-	// Persist session to workspace_sessions with token_hash
-	tokenHash := hashToken(token)
-	authMethod := "password"
+	// Persist session to workspace_sessions with token hashes
 	session := &entity.WorkspaceSession{
-		WorkspaceID: workspaceID,
+		WorkspaceID: user.WorkspaceID,
 		SessionType: "auth",
 		AppUserID:   &user.ID,
-		TokenHash:   &tokenHash,
 		AuthMethod:  &authMethod,
-		ExpiredAt:   &expiresAt,
 	}
-	if s.db != nil {
-		if err := s.db.WithContext(ctx).Create(session).Error; err != nil {
-			return nil, fmt.Errorf("failed to persist session: %w", err)
+	result, err := s.issueTokens(session, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to persist session: %w", err)
+	}
+	result.User = user
+	result.SessionID = session.ID
+	return result, nil
+}
+
+func (s *runtimeAuthService) Refresh(ctx context.Context, workspaceID uuid.UUID, refreshToken string) (*RuntimeAuthResult, error) {
+	if refreshToken == "" {
+		return nil, errors.New("refresh token is required")
+	}
+	oldHash := hashToken(refreshToken)
+	session, err := s.sessionRepo.GetByRefreshTokenHash(ctx, workspaceID, oldHash)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAppSession
 		}
+		return nil, err
+	}
+	now := time.Now()
+	if session.RevokedAt != nil || session.RefreshExpiredAt == nil || !session.RefreshExpiredAt.After(now) || session.AppUserID == nil {
+		return nil, ErrInvalidAppSession
+	}
+	user, err := s.activeUser(ctx, workspaceID, *session.AppUserID)
+	if err != nil {
+		return nil, err
 	}
 
-	return &RuntimeAuthResult{
-		User:      user,
-		Token:     token,
-		ExpiresAt: expiresAt,
-	}, nil
+	result, err := s.issueTokens(session, now)
+	if err != nil {
+		return nil, err
+	}
+	// 并发刷新时只有一个请求能轮换成功，其余请求持有的旧 Refresh Token 已失效
+	rotated, err := s.sessionRepo.Rotate(ctx, session, oldHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate session: %w", err)
+	}
+	if !rotated {
+		return nil, ErrInvalidAppSession
+	}
+	result.User = user
+	result.SessionID = session.ID
+	return result, nil
 }
 
-func (s *runtimeAuthService) ValidateSession(ctx context.Context, token string) (*entity.AppUser, error) {
+func (s *runtimeAuthService) ValidateSession(ctx context.Context, workspaceID uuid.UUID, token string) (*entity.AppUser, *entity.WorkspaceSession, error) {
 	if token == "" {
-		return nil, errors.New("token is required")
-	}
-	if s.db == nil {
-		return nil, errors.New("database not configured for session validation")
+		return nil, nil, errors.New("token is required")
 	}
 
-	tokenHash := hashToken(token)
-
-	var session entity.WorkspaceSession
-	if err := s.db.WithContext(ctx).
-		Where("token_hash = ? AND session_type = ? AND (expired_at IS NULL OR expired_at > ?)", tokenHash, "auth", time.Now()).
-		First(&session).Error; err != nil {
+	session, err := s.sessionRepo.GetByTokenHash(ctx, workspaceID, hashToken(token))
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid or expired session")
+			return nil, nil, ErrInvalidAppSession
 		}
-		return nil, err
+		return nil, nil, err
+	}
+	now := time.Now()
+	if !appSessionActive(session, now) {
+		return nil, nil, ErrInvalidAppSession
 	}
-
 	if session.AppUserID == nil {
-		return nil, errors.New("session has no associated app user")
+		return nil, nil, errors.New("session has no associated app user")
 	}
 
-	user, err := s.appUserRepo.GetByID(ctx, *session.AppUserID)
+	user, err := s.activeUser(ctx, workspaceID, *session.AppUserID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load app user: %w", err)
-	}
-	if user.Status == "blocked" {
-		return nil, errors.New("account is blocked")
+		return nil, nil, err
 	}
 
-	return user, nil
+	// 记录最近活跃时间供会话列表展示，按间隔节流避免每个请求都写库
+	if session.LastActiveAt == nil || now.Sub(*session.LastActiveAt) >= appSessionTouchInterval {
+		_ = s.sessionRepo.Touch(ctx, session.ID, now)
+	}
+	return user, session, nil
 }
 
-func (s *runtimeAuthService) Logout(ctx context.Context, token string) error {
+func (s *runtimeAuthService) Logout(ctx context.Context, workspaceID uuid.UUID, token string) error {
 	if token == "" {
 		return errors.New("token is required")
 	}
-	if s.db == nil {
-		return nil
+
+	session, err := s.sessionRepo.GetByTokenHash(ctx, workspaceID, hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if err := s.sessionRepo.Revoke(ctx, session.ID, time.Now()); err != nil {
+		return err
 	}
+	if session.AppUserID != nil {
+		s.sessionsRevoked(ctx, workspaceID, *session.AppUserID, session.ID)
+	}
+	return nil
+}
 
-	tokenHash := hashToken(token)
-	now := time.Now()
-	return s.db.WithContext(ctx).
-		Model(&entity.WorkspaceSession{}).
-		Where("token_hash = ? AND session_type = ?", tokenHash, "auth").
-		Update("expired_at", now).Error
+func (s *runtimeAuthService) LogoutAll(ctx context.Context, workspaceID uuid.UUID, token string) error {
+	user, _, err := s.ValidateSession(ctx, workspaceID, token)
+	if err != nil {
+		return err
+	}
+	if _, err := s.sessionRepo.RevokeByUser(ctx, workspaceID, user.ID, time.Now()); err != nil {
+		return err
+	}
+	s.sessionsRevoked(ctx, workspaceID, user.ID, uuid.Nil)
+	return nil
 }
 
 func (s *runtimeAuthService) ListUsers(ctx context.Context, workspaceID uuid.UUID, page, pageSize int) ([]entity.AppUser, int64, error) {
 	return s.appUserRepo.ListByWorkspace(ctx, workspaceID, page, pageSize)
 }
 
-func (s *runtimeAuthService) BlockUser(ctx context.Context, userID uuid.UUID) error {
+func (s *runtimeAuthService) GetUser(ctx context.Context, workspaceID, userID uuid.UUID) (*entity.AppUser, error) {
 	user, err := s.appUserRepo.GetByID(ctx, userID)
 	if err != nil {
-		return fmt.Errorf("user not found: %w", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAppUserNotFound
+		}
+		return nil, fmt.Errorf("failed to load app user: %w", err)
+	}
+	if user.WorkspaceID != workspaceID {
+		return nil, ErrAppUserNotFound
+	}
+	return user, nil
+}
+
+func (s *runtimeAuthService) BlockUser(ctx context.Context, workspaceID, userID uuid.UUID) error {
+	user, err := s.GetUser(ctx, workspaceID, userID)
+	if err != nil {
+		return err
 	}
 	user.Status = "blocked"
+	if err := s.appUserRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	if _, err := s.sessionRepo.RevokeByUser(ctx, workspaceID, userID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	s.sessionsRevoked(ctx, workspaceID, userID, uuid.Nil)
+	return nil
+}
+
+func (s *runtimeAuthService) ListSessions(ctx context.Context, workspaceID, userID uuid.UUID) ([]entity.WorkspaceSession, error) {
+	if _, err := s.GetUser(ctx, workspaceID, userID); err != nil {
+		return nil, err
+	}
+	return s.sessionRepo.ListActiveByUser(ctx, workspaceID, userID, time.Now())
+}
+
+func (s *runtimeAuthService) RevokeSession(ctx context.Context, workspaceID, userID, sessionID uuid.UUID) error {
+	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAppSessionNotFound
+		}
+		return err
+	}
+	if session.WorkspaceID != workspaceID || session.AppUserID == nil || *session.AppUserID != userID {
+		return ErrAppSessionNotFound
+	}
+	if err := s.sessionRepo.Revoke(ctx, session.ID, time.Now()); err != nil {
+		return err
+	}
+	s.sessionsRevoked(ctx, workspaceID, userID, session.ID)
+	return nil
+}
+
+func (s *runtimeAuthService) RevokeUserSessions(ctx context.Context, workspaceID, userID uuid.UUID) (int64, error) {
+	if _, err := s.GetUser(ctx, workspaceID, userID); err != nil {
+		return 0, err
+	}
+	revoked, err := s.sessionRepo.RevokeByUser(ctx, workspaceID, userID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	s.sessionsRevoked(ctx, workspaceID, userID, uuid.Nil)
+	return revoked, nil
+}
+
+// activeUser 加载会话关联的应用用户，须属于该工作空间且未被封禁
+func (s *runtimeAuthService) activeUser(ctx context.Context, workspaceID, userID uuid.UUID) (*entity.AppUser, error) {
+	user, err := s.appUserRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load app user: %w", err)
+	}
+	if user.WorkspaceID != workspaceID {
+		return nil, ErrInvalidAppSession
+	}
+	if user.Status != "active" {
+		return nil, errors.New("account is " + user.Status)
+	}
+	return user, nil
+}
+
+// issueTokens 为会话生成新的 Token 与 Refresh Token，并把哈希与过期时间写入 session
+func (s *runtimeAuthService) issueTokens(session *entity.WorkspaceSession, now time.Time) (*RuntimeAuthResult, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	refreshToken, err := generateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+
+	tokenHash := hashToken(token)
+	refreshHash := hashToken(refreshToken)
+	expiresAt := now.Add(s.tokenTTL)
+	refreshExpiresAt := now.Add(s.refreshTTL)
+	session.TokenHash = &tokenHash
+	session.RefreshTokenHash = &refreshHash
+	session.ExpiredAt = &expiresAt
+	session.RefreshExpiredAt = &refreshExpiresAt
+	session.LastActiveAt = &now
+
+	return &RuntimeAuthResult{
+		Token:            token,
+		ExpiresAt:        expiresAt,
+		RefreshToken:     refreshToken,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// appSessionActive 会话未撤销且 Token 未过期
+func appSessionActive(session *entity.WorkspaceSession, now time.Time) bool {
+	if session.RevokedAt != nil {
+		return false
+	}
+	return session.ExpiredAt == nil || session.ExpiredAt.After(now)
+}
+
+func (s *runtimeAuthService) ForgotPassword(ctx context.Context, workspaceID uuid.UUID, email, ip string) error {
+	if s.mailer == nil {
+		return ErrMailNotConfigured
+	}
+	if err := s.allowMail(ctx, workspaceID, email, ip); err != nil {
+		return err
+	}
+	// 查询用户、签发令牌与发送都在后台完成，邮箱是否注册不影响响应时间
+	go func() {
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), appMailSendLimit)
+		defer cancel()
+		if err := s.sendPasswordReset(sendCtx, workspaceID, email); err != nil {
+			s.log.Error("Failed to send password reset mail", "workspace_id", workspaceID, "error", err)
+		}
+	}()
+	return nil
+}
+
+// sendPasswordReset 向已注册且可用的用户发送重置密码邮件；邮箱未注册时什么也不做
+func (s *runtimeAuthService) sendPasswordReset(ctx context.Context, workspaceID uuid.UUID, email string) error {
+	user, err := s.appUserRepo.GetByEmail(ctx, workspaceID, email)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	if user.Status != "active" {
+		return nil
+	}
+	ws, err := s.workspaceRepo.GetByID(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("workspace not found: %w", err)
+	}
+
+	token, err := s.issueUserToken(ctx, user, entity.AppUserTokenResetPassword, appResetPasswordTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("Reset your %s password", ws.Name),
+		Text: fmt.Sprintf("We received a request to reset the password for your %s account.\n\n"+
+			"Open the link below to choose a new password. It expires in 1 hour.\n\n%s\n\n"+
+			"If you did not request this, you can ignore this email.\n",
+			ws.Name, s.appLink(ws, "reset-password", token)),
+	})
+}
+
+// allowMail 按请求方 IP 与（工作空间, 邮箱）限制邮件发送频率，拒绝时返回 RuntimeRateLimitError
+// 限流在查询用户之前进行，邮箱是否注册不影响结果
+func (s *runtimeAuthService) allowMail(ctx context.Context, workspaceID uuid.UUID, email, ip string) error {
+	if s.limiter == nil {
+		return nil
+	}
+	checks := []RuntimeRateLimitCheck{
+		{Key: "mail:email:" + workspaceID.String() + ":" + hashValue(strings.ToLower(email)), Limit: 1, Window: appMailCooldown},
+	}
+	scopes := []string{"email"}
+	if ipHash := hashValue(ip); ipHash != "" {
+		checks = append(checks, RuntimeRateLimitCheck{Key: "mail:ip:" + ipHash, Limit: appMailIPLimit, Window: appMailIPWindow})
+		scopes = append(scopes, "ip")
+	}
+	denied, decision, err := s.limiter.AllowAll(ctx, rateLimitAlgorithmSlidingWindow, checks, time.Now())
+	if err != nil {
+		return fmt.Errorf("mail rate limit: %w", err)
+	}
+	if denied < 0 {
+		return nil
+	}
+	return &RuntimeRateLimitError{
+		Scope:      "mail_" + scopes[denied],
+		Limit:      checks[denied].Limit,
+		Window:     checks[denied].Window,
+		RetryAfter: decision.RetryAfter,
+	}
+}
+
+func (s *runtimeAuthService) ResetPassword(ctx context.Context, workspaceID uuid.UUID, token, newPassword string) error {
+	user, err := s.consumeUserToken(ctx, workspaceID, entity.AppUserTokenResetPassword, token)
+	if err != nil {
+		return err
+	}
+	if user.Status != "active" {
+		return errors.New("account is " + user.Status)
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	user.PasswordHash = string(hash)
+	// 能收到重置邮件即证明拥有该邮箱
+	user.EmailVerified = true
+	if err := s.appUserRepo.Update(ctx, user); err != nil {
+		return err
+	}
+	if _, err := s.sessionRepo.RevokeByUser(ctx, workspaceID, user.ID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	s.sessionsRevoked(ctx, workspaceID, user.ID, uuid.Nil)
+	return nil
+}
+
+func (s *runtimeAuthService) VerifyEmail(ctx context.Context, workspaceID uuid.UUID, token string) error {
+	user, err := s.consumeUserToken(ctx, workspaceID, entity.AppUserTokenVerifyEmail, token)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return nil
+	}
+	user.EmailVerified = true
 	return s.appUserRepo.Update(ctx, user)
 }
 
+func (s *runtimeAuthService) ResendVerification(ctx context.Context, workspaceID uuid.UUID, sessionToken, ip string) error {
+	if s.mailer == nil {
+		return ErrMailNotConfigured
+	}
+	user, _, err := s.ValidateSession(ctx, workspaceID, sessionToken)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrAppEmailAlreadyVerified
+	}
+	if err := s.allowMail(ctx, workspaceID, user.Email, ip); err != nil {
+		return err
+	}
+	ws, err := s.workspaceRepo.GetByID(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("workspace not found: %w", err)
+	}
+	return s.sendVerification(ctx, ws, user)
+}
+
+func (s *runtimeAuthService) InviteUser(ctx context.Context, workspaceID, inviterID uuid.UUID, email, role, displayName, ip string) (*entity.AppUser, error) {
+	if s.mailer == nil {
+		return nil, ErrMailNotConfigured
+	}
+	role = strings.ToLower(strings.TrimSpace(role))
+	if role == "" {
+		role = "user"
+	}
+	if len(role) > 20 || !appUserRolePattern.MatchString(role) {
+		return nil, fmt.Errorf("%w %q", ErrInvalidAppUserRole, role)
+	}
+	ws, err := s.workspaceRepo.GetByID(ctx, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("workspace not found: %w", err)
+	}
+
+	user, err := s.appUserRepo.GetByEmail(ctx, workspaceID, email)
+	switch {
+	case err == nil && user.Status != "invited":
+		return nil, ErrAppUserExists
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, err
+	}
+	if err := s.allowMail(ctx, workspaceID, email, ip); err != nil {
+		return nil, err
+	}
+
+	if err == nil {
+		user.Role = role
+		user.InvitedBy = &inviterID
+		if displayName != "" {
+			user.DisplayName = &displayName
+		}
+		if err := s.appUserRepo.Update(ctx, user); err != nil {
+			return nil, err
+		}
+	} else {
+		user = &entity.AppUser{
+			WorkspaceID: workspaceID,
+			Email:       email,
+			Role:        role,
+			Status:      "invited",
+			InvitedBy:   &inviterID,
+		}
+		if displayName != "" {
+			user.DisplayName = &displayName
+		}
+		if err := s.appUserRepo.Create(ctx, user); err != nil {
+			return nil, fmt.Errorf("failed to create app user: %w", err)
+		}
+	}
+
+	token, err := s.issueUserToken(ctx, user, entity.AppUserTokenInvite, appInviteTTL)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("You're invited to %s", ws.Name),
+		Text: fmt.Sprintf("You have been invited to join %s.\n\n"+
+			"Open the link below to set your password. It expires in 7 days.\n\n%s\n",
+			ws.Name, s.appLink(ws, "accept-invitation", token)),
+	}); err != nil {
+		return nil, fmt.Errorf("failed to send invitation: %w", err)
+	}
+	return user, nil
+}
+
+func (s *runtimeAuthService) AcceptInvitation(ctx context.Context, workspaceID uuid.UUID, token, password, displayName string) (*RuntimeAuthResult, error) {
+	user, err := s.consumeUserToken(ctx, workspaceID, entity.AppUserTokenInvite, token)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status != "invited" {
+		return nil, ErrInvalidAppUserToken
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+	user.PasswordHash = string(hash)
+	user.Status = "active"
+	user.EmailVerified = true
+	if displayName != "" {
+		user.DisplayName = &displayName
+	}
+	if err := s.appUserRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return s.startSession(ctx, user, "invite")
+}
+
+// sendVerification 发送验证邮箱邮件
+func (s *runtimeAuthService) sendVerification(ctx context.Context, ws *entity.Workspace, user *entity.AppUser) error {
+	token, err := s.issueUserToken(ctx, user, entity.AppUserTokenVerifyEmail, appVerifyEmailTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(ctx, &mail.Message{
+		To:      user.Email,
+		Subject: fmt.Sprintf("Verify your email for %s", ws.Name),
+		Text: fmt.Sprintf("Please confirm the email address for your %s account.\n\n"+
+			"Open the link below to verify it. It expires in 24 hours.\n\n%s\n",
+			ws.Name, s.appLink(ws, "verify-email", token)),
+	})
+}
+
+// issueUserToken 生成一次性令牌，同一用户同一用途之前未使用的令牌随即作废
+func (s *runtimeAuthService) issueUserToken(ctx context.Context, user *entity.AppUser, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	now := time.Now()
+	if err := s.tokenRepo.InvalidateByUser(ctx, user.ID, purpose, now); err != nil {
+		return "", err
+	}
+	if err := s.tokenRepo.Create(ctx, &entity.AppUserToken{
+		WorkspaceID: user.WorkspaceID,
+		AppUserID:   user.ID,
+		Purpose:     purpose,
+		TokenHash:   hashToken(token),
+		ExpiresAt:   now.Add(ttl),
+	}); err != nil {
+		return "", fmt.Errorf("failed to persist token: %w", err)
+	}
+	return token, nil
+}
+
+// consumeUserToken 校验并使用一次性令牌，返回令牌所属的应用用户
+func (s *runtimeAuthService) consumeUserToken(ctx context.Context, workspaceID uuid.UUID, purpose, token string) (*entity.AppUser, error) {
+	if token == "" {
+		return nil, ErrInvalidAppUserToken
+	}
+	record, err := s.tokenRepo.GetByHash(ctx, workspaceID, purpose, hashToken(token))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAppUserToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if record.UsedAt != nil || !record.ExpiresAt.After(now) {
+		return nil, ErrInvalidAppUserToken
+	}
+	used, err := s.tokenRepo.MarkUsed(ctx, record.ID, now)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		return nil, ErrInvalidAppUserToken
+	}
+	user, err := s.GetUser(ctx, workspaceID, record.AppUserID)
+	if err != nil {
+		return nil, ErrInvalidAppUserToken
+	}
+	return user, nil
+}
+
+// appLink 邮件中指向已发布 App 的链接，由 App 前端按 auth_action 展示对应页面
+func (s *runtimeAuthService) appLink(ws *entity.Workspace, action, token string) string {
+	query := url.Values{"auth_action": {action}, "token": {token}}
+	return fmt.Sprintf("%s/runtime/%s?%s", s.appBaseURL, url.PathEscape(ws.Slug), query.Encode())
+}
+
 // generateSecureToken generates a cryptographically secure random token
 func generateSecureToken(length int) (string, error) {
 	bytes := make([]byte, length)
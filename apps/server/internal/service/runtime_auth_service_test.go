@@ -0,0 +1,653 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/mail"
+	"github.com/reverseai/server/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// memoryAppUserRepo 内存版 AppUserRepository（重置密码在后台查询用户，需加锁）
+type memoryAppUserRepo struct {
+	repository.AppUserRepository
+	mu    sync.Mutex
+	users map[uuid.UUID]*entity.AppUser
+}
+
+func (r *memoryAppUserRepo) Create(_ context.Context, user *entity.AppUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	copied := *user
+	r.users[user.ID] = &copied
+	return nil
+}
+
+func (r *memoryAppUserRepo) GetByID(_ context.Context, id uuid.UUID) (*entity.AppUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *memoryAppUserRepo) GetByEmail(_ context.Context, workspaceID uuid.UUID, email string) (*entity.AppUser, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, user := range r.users {
+		if user.WorkspaceID == workspaceID && user.Email == email {
+			copied := *user
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryAppUserRepo) Update(_ context.Context, user *entity.AppUser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *user
+	r.users[user.ID] = &copied
+	return nil
+}
+
+// memoryAppSessionRepo 内存版 AppSessionRepository
+type memoryAppSessionRepo struct {
+	sessions map[uuid.UUID]*entity.WorkspaceSession
+}
+
+func (r *memoryAppSessionRepo) Create(_ context.Context, session *entity.WorkspaceSession) error {
+	if session.ID == uuid.Nil {
+		session.ID = uuid.New()
+	}
+	session.CreatedAt = time.Now()
+	copied := *session
+	r.sessions[session.ID] = &copied
+	return nil
+}
+
+func (r *memoryAppSessionRepo) GetByID(_ context.Context, id uuid.UUID) (*entity.WorkspaceSession, error) {
+	session, ok := r.sessions[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+func (r *memoryAppSessionRepo) find(match func(*entity.WorkspaceSession) bool) (*entity.WorkspaceSession, error) {
+	for _, session := range r.sessions {
+		if match(session) {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryAppSessionRepo) GetByTokenHash(_ context.Context, workspaceID uuid.UUID, tokenHash string) (*entity.WorkspaceSession, error) {
+	return r.find(func(s *entity.WorkspaceSession) bool {
+		return s.WorkspaceID == workspaceID && s.TokenHash != nil && *s.TokenHash == tokenHash
+	})
+}
+
+func (r *memoryAppSessionRepo) GetByRefreshTokenHash(_ context.Context, workspaceID uuid.UUID, refreshTokenHash string) (*entity.WorkspaceSession, error) {
+	return r.find(func(s *entity.WorkspaceSession) bool {
+		return s.WorkspaceID == workspaceID && s.RefreshTokenHash != nil && *s.RefreshTokenHash == refreshTokenHash
+	})
+}
+
+func (r *memoryAppSessionRepo) Rotate(_ context.Context, session *entity.WorkspaceSession, oldRefreshTokenHash string) (bool, error) {
+	stored, ok := r.sessions[session.ID]
+	if !ok || stored.RevokedAt != nil || stored.RefreshTokenHash == nil || *stored.RefreshTokenHash != oldRefreshTokenHash {
+		return false, nil
+	}
+	stored.TokenHash = session.TokenHash
+	stored.RefreshTokenHash = session.RefreshTokenHash
+	stored.ExpiredAt = session.ExpiredAt
+	stored.RefreshExpiredAt = session.RefreshExpiredAt
+	stored.LastActiveAt = session.LastActiveAt
+	return true, nil
+}
+
+func (r *memoryAppSessionRepo) Touch(_ context.Context, id uuid.UUID, at time.Time) error {
+	if session, ok := r.sessions[id]; ok {
+		session.LastActiveAt = &at
+	}
+	return nil
+}
+
+func (r *memoryAppSessionRepo) ListActiveByUser(_ context.Context, workspaceID, appUserID uuid.UUID, now time.Time) ([]entity.WorkspaceSession, error) {
+	var list []entity.WorkspaceSession
+	for _, s := range r.sessions {
+		if s.WorkspaceID != workspaceID || s.AppUserID == nil || *s.AppUserID != appUserID || s.RevokedAt != nil {
+			continue
+		}
+		if s.RefreshExpiredAt != nil && s.RefreshExpiredAt.After(now) {
+			list = append(list, *s)
+		}
+	}
+	return list, nil
+}
+
+func (r *memoryAppSessionRepo) Revoke(_ context.Context, id uuid.UUID, at time.Time) error {
+	if session, ok := r.sessions[id]; ok && session.RevokedAt == nil {
+		session.RevokedAt = &at
+		session.ExpiredAt = &at
+	}
+	return nil
+}
+
+func (r *memoryAppSessionRepo) RevokeByUser(ctx context.Context, workspaceID, appUserID uuid.UUID, at time.Time) (int64, error) {
+	var n int64
+	for _, s := range r.sessions {
+		if s.WorkspaceID == workspaceID && s.AppUserID != nil && *s.AppUserID == appUserID && s.RevokedAt == nil {
+			_ = r.Revoke(ctx, s.ID, at)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// memoryAppUserTokenRepo 内存版 AppUserTokenRepository
+type memoryAppUserTokenRepo struct {
+	tokens map[uuid.UUID]*entity.AppUserToken
+}
+
+func (r *memoryAppUserTokenRepo) Create(_ context.Context, token *entity.AppUserToken) error {
+	if token.ID == uuid.Nil {
+		token.ID = uuid.New()
+	}
+	copied := *token
+	r.tokens[token.ID] = &copied
+	return nil
+}
+
+func (r *memoryAppUserTokenRepo) GetByHash(_ context.Context, workspaceID uuid.UUID, purpose, tokenHash string) (*entity.AppUserToken, error) {
+	for _, t := range r.tokens {
+		if t.WorkspaceID == workspaceID && t.Purpose == purpose && t.TokenHash == tokenHash {
+			copied := *t
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryAppUserTokenRepo) MarkUsed(_ context.Context, id uuid.UUID, at time.Time) (bool, error) {
+	t, ok := r.tokens[id]
+	if !ok || t.UsedAt != nil {
+		return false, nil
+	}
+	t.UsedAt = &at
+	return true, nil
+}
+
+func (r *memoryAppUserTokenRepo) InvalidateByUser(_ context.Context, appUserID uuid.UUID, purpose string, at time.Time) error {
+	for _, t := range r.tokens {
+		if t.AppUserID == appUserID && t.Purpose == purpose && t.UsedAt == nil {
+			t.UsedAt = &at
+		}
+	}
+	return nil
+}
+
+// stubAppWorkspaceRepo 只实现 GetByID
+type stubAppWorkspaceRepo struct {
+	repository.WorkspaceRepository
+	workspace *entity.Workspace
+}
+
+func (r *stubAppWorkspaceRepo) GetByID(_ context.Context, id uuid.UUID) (*entity.Workspace, error) {
+	if id != r.workspace.ID {
+		return nil, gorm.ErrRecordNotFound
+	}
+	copied := *r.workspace
+	return &copied, nil
+}
+
+// recordingMailer 记录发送的邮件（重置密码邮件在后台发送，需加锁）
+type recordingMailer struct {
+	mu   sync.Mutex
+	sent []*mail.Message
+}
+
+func (m *recordingMailer) Send(_ context.Context, msg *mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *recordingMailer) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sent)
+}
+
+// waitSent 等待后台发送的邮件累计达到 n 封
+func (m *recordingMailer) waitSent(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for m.count() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("sent %d mails, want %d", m.count(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// lastToken 从最近一封邮件的链接中取出令牌
+func (m *recordingMailer) lastToken(t *testing.T, action string) string {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sent) == 0 {
+		t.Fatal("no mail sent")
+	}
+	msg := m.sent[len(m.sent)-1]
+	for _, field := range strings.Fields(msg.Text) {
+		link, err := url.Parse(field)
+		if err != nil || link.Query().Get("auth_action") != action {
+			continue
+		}
+		return link.Query().Get("token")
+	}
+	t.Fatalf("no %s link in mail: %q", action, msg.Text)
+	return ""
+}
+
+type runtimeAuthTestEnv struct {
+	svc         RuntimeAuthService
+	users       *memoryAppUserRepo
+	sessions    *memoryAppSessionRepo
+	mailer      *recordingMailer
+	workspaceID uuid.UUID
+	user        *entity.AppUser
+}
+
+func newRuntimeAuthTestEnv(t *testing.T) *runtimeAuthTestEnv {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+	workspaceID := uuid.New()
+	user := &entity.AppUser{ID: uuid.New(), WorkspaceID: workspaceID, Email: "alice@example.com", PasswordHash: string(hash), Status: "active"}
+	users := &memoryAppUserRepo{users: map[uuid.UUID]*entity.AppUser{user.ID: user}}
+	sessions := &memoryAppSessionRepo{sessions: map[uuid.UUID]*entity.WorkspaceSession{}}
+	tokens := &memoryAppUserTokenRepo{tokens: map[uuid.UUID]*entity.AppUserToken{}}
+	workspaces := &stubAppWorkspaceRepo{workspace: &entity.Workspace{ID: workspaceID, Name: "Fleet", Slug: "fleet", AppStatus: "published"}}
+	log, err := logger.New(false)
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	mailer := &recordingMailer{}
+	svc := NewRuntimeAuthService(users, workspaces, sessions, tokens, log)
+	svc.SetMailer(mailer, "https://apps.example.com/")
+	return &runtimeAuthTestEnv{
+		svc:         svc,
+		users:       users,
+		sessions:    sessions,
+		mailer:      mailer,
+		workspaceID: workspaceID,
+		user:        user,
+	}
+}
+
+func (env *runtimeAuthTestEnv) login(t *testing.T) *RuntimeAuthResult {
+	t.Helper()
+	result, err := env.svc.Login(context.Background(), env.workspaceID, env.user.Email, "secret123")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	return result
+}
+
+func TestRuntimeAuth_SessionBoundToWorkspace(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	result := env.login(t)
+
+	if user, _, err := env.svc.ValidateSession(ctx, env.workspaceID, result.Token); err != nil || user.ID != env.user.ID {
+		t.Fatalf("validate = %v, %v", user, err)
+	}
+	// 其他已发布 App 不接受该 Token 与 Refresh Token
+	other := uuid.New()
+	if _, _, err := env.svc.ValidateSession(ctx, other, result.Token); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("validate in other workspace err = %v", err)
+	}
+	if _, err := env.svc.Refresh(ctx, other, result.RefreshToken); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("refresh in other workspace err = %v", err)
+	}
+	// Refresh Token 不能当作 Token 使用
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, result.RefreshToken); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("refresh token as access token err = %v", err)
+	}
+}
+
+func TestRuntimeAuth_RefreshRotatesTokens(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	first := env.login(t)
+
+	// 模拟 Token 已过期、Refresh Token 仍有效
+	past := time.Now().Add(-time.Minute)
+	env.sessions.sessions[first.SessionID].ExpiredAt = &past
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, first.Token); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("expired token err = %v", err)
+	}
+
+	second, err := env.svc.Refresh(ctx, env.workspaceID, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if second.SessionID != first.SessionID || second.Token == first.Token || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh result = %+v", second)
+	}
+	if !second.RefreshExpiresAt.After(time.Now().Add(appSessionRefreshTTL - time.Minute)) {
+		t.Fatalf("refresh expiry not extended: %v", second.RefreshExpiresAt)
+	}
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, second.Token); err != nil {
+		t.Fatalf("validate refreshed token: %v", err)
+	}
+
+	// 旧 Refresh Token 已轮换失效
+	if _, err := env.svc.Refresh(ctx, env.workspaceID, first.RefreshToken); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("reused refresh token err = %v", err)
+	}
+
+	// Refresh Token 过期后不能再刷新
+	env.sessions.sessions[first.SessionID].RefreshExpiredAt = &past
+	if _, err := env.svc.Refresh(ctx, env.workspaceID, second.RefreshToken); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("expired refresh token err = %v", err)
+	}
+}
+
+func TestRuntimeAuth_ListAndRevokeSessions(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	phone, laptop := env.login(t), env.login(t)
+
+	sessions, err := env.svc.ListSessions(ctx, env.workspaceID, env.user.ID)
+	if err != nil || len(sessions) != 2 {
+		t.Fatalf("list = %d sessions, %v", len(sessions), err)
+	}
+	if _, err := env.svc.ListSessions(ctx, uuid.New(), env.user.ID); !errors.Is(err, ErrAppUserNotFound) {
+		t.Fatalf("list in other workspace err = %v", err)
+	}
+	if err := env.svc.RevokeSession(ctx, env.workspaceID, uuid.New(), phone.SessionID); !errors.Is(err, ErrAppSessionNotFound) {
+		t.Fatalf("revoke other user's session err = %v", err)
+	}
+
+	if err := env.svc.RevokeSession(ctx, env.workspaceID, env.user.ID, phone.SessionID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, phone.Token); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("revoked token err = %v", err)
+	}
+	if _, err := env.svc.Refresh(ctx, env.workspaceID, phone.RefreshToken); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("revoked refresh token err = %v", err)
+	}
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, laptop.Token); err != nil {
+		t.Fatalf("other session revoked too: %v", err)
+	}
+
+	// 退出所有设备
+	tablet := env.login(t)
+	if err := env.svc.LogoutAll(ctx, env.workspaceID, tablet.Token); err != nil {
+		t.Fatalf("logout all: %v", err)
+	}
+	for _, result := range []*RuntimeAuthResult{laptop, tablet} {
+		if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, result.Token); !errors.Is(err, ErrInvalidAppSession) {
+			t.Fatalf("session %s still valid: %v", result.SessionID, err)
+		}
+	}
+	if sessions, _ := env.svc.ListSessions(ctx, env.workspaceID, env.user.ID); len(sessions) != 0 {
+		t.Fatalf("sessions after logout all = %d", len(sessions))
+	}
+}
+
+func TestRuntimeAuth_BlockUserRevokesSessions(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	result := env.login(t)
+
+	if err := env.svc.BlockUser(ctx, uuid.New(), env.user.ID); !errors.Is(err, ErrAppUserNotFound) {
+		t.Fatalf("block from other workspace err = %v", err)
+	}
+	if err := env.svc.BlockUser(ctx, env.workspaceID, env.user.ID); err != nil {
+		t.Fatalf("block: %v", err)
+	}
+	if session := env.sessions.sessions[result.SessionID]; session.RevokedAt == nil {
+		t.Fatal("session not revoked on block")
+	}
+	if _, err := env.svc.Refresh(ctx, env.workspaceID, result.RefreshToken); err == nil {
+		t.Fatal("blocked user refreshed session")
+	}
+	if _, err := env.svc.Login(ctx, env.workspaceID, env.user.Email, "secret123"); err == nil {
+		t.Fatal("blocked user logged in")
+	}
+}
+
+func TestRuntimeAuth_RevocationsArePublished(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	bus := NewMemoryInvalidationBus()
+	var events []InvalidationEvent
+	bus.Subscribe(func(event InvalidationEvent) { events = append(events, event) })
+	env.svc.SetInvalidationBus(bus)
+
+	phone, laptop := env.login(t), env.login(t)
+	if err := env.svc.Logout(ctx, env.workspaceID, phone.Token); err != nil {
+		t.Fatalf("logout: %v", err)
+	}
+	if err := env.svc.RevokeSession(ctx, env.workspaceID, env.user.ID, laptop.SessionID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	if _, err := env.svc.RevokeUserSessions(ctx, env.workspaceID, env.user.ID); err != nil {
+		t.Fatalf("revoke all: %v", err)
+	}
+	if err := env.svc.BlockUser(ctx, env.workspaceID, env.user.ID); err != nil {
+		t.Fatalf("block: %v", err)
+	}
+
+	wantSessions := []string{phone.SessionID.String(), laptop.SessionID.String(), "", ""}
+	if len(events) != len(wantSessions) {
+		t.Fatalf("events = %+v, want %d", events, len(wantSessions))
+	}
+	for i, event := range events {
+		if event.Kind != InvalidationAppSessions || event.WorkspaceID != env.workspaceID.String() ||
+			event.AppUserID != env.user.ID.String() || event.SessionID != wantSessions[i] {
+			t.Fatalf("event %d = %+v, want session %q", i, event, wantSessions[i])
+		}
+	}
+}
+
+func TestRuntimeAuth_ForgotAndResetPassword(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	session := env.login(t)
+
+	// 未注册的邮箱不发邮件，也不报错
+	if err := env.svc.ForgotPassword(ctx, env.workspaceID, "nobody@example.com", ""); err != nil {
+		t.Fatalf("unknown email: %v", err)
+	}
+	if err := env.svc.ForgotPassword(ctx, env.workspaceID, env.user.Email, ""); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	env.mailer.waitSent(t, 1)
+	msg := env.mailer.sent[0]
+	if msg.To != env.user.Email || !strings.Contains(msg.Text, "https://apps.example.com/runtime/fleet?") {
+		t.Fatalf("reset mail = %+v", msg)
+	}
+	first := env.mailer.lastToken(t, "reset-password")
+
+	// 再次申请后旧链接失效
+	if err := env.svc.ForgotPassword(ctx, env.workspaceID, env.user.Email, ""); err != nil {
+		t.Fatalf("forgot password again: %v", err)
+	}
+	env.mailer.waitSent(t, 2)
+	token := env.mailer.lastToken(t, "reset-password")
+	if err := env.svc.ResetPassword(ctx, env.workspaceID, first, "newpass123"); !errors.Is(err, ErrInvalidAppUserToken) {
+		t.Fatalf("superseded token err = %v", err)
+	}
+	if err := env.svc.ResetPassword(ctx, uuid.New(), token, "newpass123"); !errors.Is(err, ErrInvalidAppUserToken) {
+		t.Fatalf("token in other workspace err = %v", err)
+	}
+
+	if err := env.svc.ResetPassword(ctx, env.workspaceID, token, "newpass123"); err != nil {
+		t.Fatalf("reset password: %v", err)
+	}
+	if err := env.svc.ResetPassword(ctx, env.workspaceID, token, "again123"); !errors.Is(err, ErrInvalidAppUserToken) {
+		t.Fatalf("reused token err = %v", err)
+	}
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, session.Token); !errors.Is(err, ErrInvalidAppSession) {
+		t.Fatalf("session survived password reset: %v", err)
+	}
+	if _, err := env.svc.Login(ctx, env.workspaceID, env.user.Email, "secret123"); err == nil {
+		t.Fatal("old password still works")
+	}
+	if _, err := env.svc.Login(ctx, env.workspaceID, env.user.Email, "newpass123"); err != nil {
+		t.Fatalf("login with new password: %v", err)
+	}
+}
+
+func TestRuntimeAuth_MailCooldown(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	env.svc.SetRateLimiter(NewMemoryRateLimiter())
+	ctx := context.Background()
+
+	if err := env.svc.ForgotPassword(ctx, env.workspaceID, env.user.Email, "203.0.113.7"); err != nil {
+		t.Fatalf("forgot password: %v", err)
+	}
+	env.mailer.waitSent(t, 1)
+
+	// 冷却期内再次申请（换 IP、大小写不同）被拒绝，不再发邮件
+	err := env.svc.ForgotPassword(ctx, env.workspaceID, strings.ToUpper(env.user.Email), "198.51.100.9")
+	var limitErr *RuntimeRateLimitError
+	if !errors.As(err, &limitErr) || limitErr.Scope != "mail_email" {
+		t.Fatalf("second request err = %v, want email cooldown", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := env.mailer.count(); n != 1 {
+		t.Fatalf("sent %d mails, want 1", n)
+	}
+
+	// 未注册的邮箱同样受冷却限制，结果与已注册邮箱一致
+	if err := env.svc.ForgotPassword(ctx, env.workspaceID, "nobody@example.com", "203.0.113.7"); err != nil {
+		t.Fatalf("unknown email: %v", err)
+	}
+	if err := env.svc.ForgotPassword(ctx, env.workspaceID, "nobody@example.com", "203.0.113.7"); !errors.As(err, &limitErr) {
+		t.Fatalf("unknown email inside cooldown err = %v", err)
+	}
+
+	// 同一 IP 换不同邮箱也受每小时上限约束
+	for i := 0; i < appMailIPLimit-2; i++ {
+		if err := env.svc.ForgotPassword(ctx, env.workspaceID, fmt.Sprintf("user%d@example.com", i), "203.0.113.7"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	err = env.svc.ForgotPassword(ctx, env.workspaceID, "last@example.com", "203.0.113.7")
+	if !errors.As(err, &limitErr) || limitErr.Scope != "mail_ip" {
+		t.Fatalf("request over the IP limit err = %v", err)
+	}
+}
+
+func TestRuntimeAuth_RegisterSendsVerification(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+
+	user, err := env.svc.Register(ctx, env.workspaceID, "carol@example.com", "secret123", "")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if user.EmailVerified {
+		t.Fatal("new user already verified")
+	}
+	token := env.mailer.lastToken(t, "verify-email")
+	if err := env.svc.VerifyEmail(ctx, env.workspaceID, token); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if !env.users.users[user.ID].EmailVerified {
+		t.Fatal("email not marked verified")
+	}
+	if err := env.svc.VerifyEmail(ctx, env.workspaceID, token); !errors.Is(err, ErrInvalidAppUserToken) {
+		t.Fatalf("reused verify token err = %v", err)
+	}
+
+	result, err := env.svc.Login(ctx, env.workspaceID, "carol@example.com", "secret123")
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if err := env.svc.ResendVerification(ctx, env.workspaceID, result.Token, ""); !errors.Is(err, ErrAppEmailAlreadyVerified) {
+		t.Fatalf("resend for verified user err = %v", err)
+	}
+}
+
+func TestRuntimeAuth_InvitationAssignsRole(t *testing.T) {
+	env := newRuntimeAuthTestEnv(t)
+	ctx := context.Background()
+	inviter := uuid.New()
+
+	if _, err := env.svc.InviteUser(ctx, env.workspaceID, inviter, env.user.Email, "admin", "", ""); !errors.Is(err, ErrAppUserExists) {
+		t.Fatalf("invite existing user err = %v", err)
+	}
+	if _, err := env.svc.InviteUser(ctx, env.workspaceID, inviter, "dave@example.com", "Admin Users", "", ""); !errors.Is(err, ErrInvalidAppUserRole) {
+		t.Fatalf("invalid role err = %v", err)
+	}
+
+	invited, err := env.svc.InviteUser(ctx, env.workspaceID, inviter, "dave@example.com", "editor", "Dave", "")
+	if err != nil {
+		t.Fatalf("invite: %v", err)
+	}
+	if invited.Status != "invited" || invited.Role != "editor" || invited.InvitedBy == nil || *invited.InvitedBy != inviter {
+		t.Fatalf("invited user = %+v", invited)
+	}
+	if _, err := env.svc.Login(ctx, env.workspaceID, "dave@example.com", ""); err == nil {
+		t.Fatal("invited user logged in before accepting")
+	}
+	if _, err := env.svc.Register(ctx, env.workspaceID, "dave@example.com", "secret123", ""); !errors.Is(err, ErrAppUserExists) {
+		t.Fatalf("register over invitation err = %v", err)
+	}
+
+	// 重新邀请会更新角色，之前的邀请链接失效
+	first := env.mailer.lastToken(t, "accept-invitation")
+	if _, err := env.svc.InviteUser(ctx, env.workspaceID, inviter, "dave@example.com", "admin,editor", "", ""); err != nil {
+		t.Fatalf("re-invite: %v", err)
+	}
+	token := env.mailer.lastToken(t, "accept-invitation")
+	if _, err := env.svc.AcceptInvitation(ctx, env.workspaceID, first, "secret123", ""); !errors.Is(err, ErrInvalidAppUserToken) {
+		t.Fatalf("superseded invitation err = %v", err)
+	}
+
+	result, err := env.svc.AcceptInvitation(ctx, env.workspaceID, token, "secret123", "")
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	if result.User.Status != "active" || result.User.Role != "admin,editor" || !result.User.EmailVerified || *result.User.DisplayName != "Dave" {
+		t.Fatalf("accepted user = %+v", result.User)
+	}
+	if _, _, err := env.svc.ValidateSession(ctx, env.workspaceID, result.Token); err != nil {
+		t.Fatalf("session from invitation: %v", err)
+	}
+	if _, err := env.svc.AcceptInvitation(ctx, env.workspaceID, token, "other123", ""); !errors.Is(err, ErrInvalidAppUserToken) {
+		t.Fatalf("reused invitation err = %v", err)
+	}
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -103,6 +104,40 @@ func (c *ttlCache[T]) Delete(key string) {
 	c.mu.Unlock()
 }
 
+// DeleteFunc 删除所有满足 match 的条目
+func (c *ttlCache[T]) DeleteFunc(match func(key string, value T) bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	for key, entry := range c.items {
+		if match(key, entry.value) {
+			delete(c.items, key)
+		}
+	}
+	c.mu.Unlock()
+}
+
+// invalidateWorkspace 清理工作空间的全部缓存：按 ID、按 slug（含别名）以及版本，
+// 并清理 slugs 的负缓存（新 slug 之前可能被缓存为不存在）
+func (c *runtimeCache) invalidateWorkspace(workspaceID string, slugs ...string) {
+	if c == nil || workspaceID == "" {
+		return
+	}
+	matchWorkspace := func(_ string, workspace *entity.Workspace) bool {
+		return workspace != nil && workspace.ID.String() == workspaceID
+	}
+	c.workspaceByID.Delete(workspaceID)
+	c.workspaceBySlug.DeleteFunc(matchWorkspace)
+	c.versionByID.DeleteFunc(func(_ string, version *entity.WorkspaceVersion) bool {
+		return version != nil && version.WorkspaceID.String() == workspaceID
+	})
+	cacheMissClear(c.workspaceByIDMiss, workspaceID)
+	for _, slug := range slugs {
+		cacheMissClear(c.workspaceBySlugMiss, strings.TrimSpace(slug))
+	}
+}
+
 func cacheMissHit(cache *ttlCache[bool], key string) bool {
 	if cache == nil || key == "" {
 		return false
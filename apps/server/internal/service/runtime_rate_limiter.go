@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/reverseai/server/internal/pkg/redis"
+)
+
+// RuntimeRateLimiter Runtime 限流器接口
+// key 由调用方拼好（包含 workspace 与维度），limiter 只负责计数
+type RuntimeRateLimiter interface {
+	Allow(ctx context.Context, key string, algorithm string, limit int, window time.Duration, now time.Time) (RuntimeRateLimitDecision, error)
+	// AllowAll 一次判定多个维度：全部放行时各消耗一次配额，任一维度拒绝时都不消耗；
+	// 返回被拒绝维度的下标，全部放行时为 -1
+	AllowAll(ctx context.Context, algorithm string, checks []RuntimeRateLimitCheck, now time.Time) (int, RuntimeRateLimitDecision, error)
+}
+
+// RuntimeRateLimitCheck 一个限流维度的 key 与配额；limit 或 window 不为正时不限流
+type RuntimeRateLimitCheck struct {
+	Key    string
+	Limit  int
+	Window time.Duration
+}
+
+func (c RuntimeRateLimitCheck) enabled() bool {
+	return c.Limit > 0 && c.Window > 0
+}
+
+// RuntimeRateLimitDecision 限流判定结果
+type RuntimeRateLimitDecision struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// RuntimeRateLimitError 触发限流时返回的错误，包含重试等待时间
+type RuntimeRateLimitError struct {
+	Scope      string
+	Limit      int
+	Window     time.Duration
+	RetryAfter time.Duration
+}
+
+func (e *RuntimeRateLimitError) Error() string {
+	return fmt.Sprintf("runtime rate limited: scope=%s limit=%d window=%s retry_after=%s", e.Scope, e.Limit, e.Window, e.RetryAfter)
+}
+
+func (e *RuntimeRateLimitError) Unwrap() error {
+	return ErrRuntimeRateLimited
+}
+
+// RetryAfterSeconds 返回向上取整的重试秒数（至少 1 秒）
+func (e *RuntimeRateLimitError) RetryAfterSeconds() int {
+	if e == nil || e.RetryAfter <= 0 {
+		return 1
+	}
+	seconds := int((e.RetryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
+const (
+	rateLimitAlgorithmSlidingWindow = "sliding_window"
+	runtimeRateLimitKeyPrefix       = "runtime:ratelimit:"
+)
+
+// NewRuntimeRateLimiter 创建 Runtime 限流器
+// client 为空时使用进程内实现（单机 / 开发环境），Redis 不可用时自动降级到进程内计数
+func NewRuntimeRateLimiter(client *redis.Client) RuntimeRateLimiter {
+	memory := NewMemoryRateLimiter()
+	if client == nil || client.Client == nil {
+		return memory
+	}
+	return &redisRateLimiter{
+		client:   client,
+		fallback: memory,
+	}
+}
+
+// ==================== Redis 实现 ====================
+
+type redisRateLimiter struct {
+	client   *redis.Client
+	fallback RuntimeRateLimiter
+}
+
+// slidingWindowScript 基于 ZSET 的滑动窗口日志，KEYS 为各维度的 key，
+// ARGV = {now, member, window1, limit1, window2, limit2, ...}
+// 先检查全部维度再统一写入，返回 {rejected, remaining, retry_after_ms}，
+// rejected 为被拒绝维度的序号（从 1 开始），全部放行时为 0
+var slidingWindowScript = goredis.NewScript(`
+local now = tonumber(ARGV[1])
+local member = ARGV[2]
+local remaining = -1
+for i = 1, #KEYS do
+  local window = tonumber(ARGV[i * 2 + 1])
+  local limit = tonumber(ARGV[i * 2 + 2])
+  redis.call('ZREMRANGEBYSCORE', KEYS[i], '-inf', now - window)
+  local count = redis.call('ZCARD', KEYS[i])
+  if count >= limit then
+    local retry = window
+    local oldest = redis.call('ZRANGE', KEYS[i], 0, 0, 'WITHSCORES')
+    if oldest[2] then
+      retry = tonumber(oldest[2]) + window - now
+    end
+    return {i, 0, retry}
+  end
+  if remaining < 0 or limit - count - 1 < remaining then
+    remaining = limit - count - 1
+  end
+end
+for i = 1, #KEYS do
+  redis.call('ZADD', KEYS[i], now, member)
+  redis.call('PEXPIRE', KEYS[i], tonumber(ARGV[i * 2 + 1]))
+end
+return {0, remaining, 0}
+`)
+
+// fixedWindowScript 固定窗口计数，KEYS 为各维度当前窗口的 key，
+// ARGV = {window1, limit1, window2, limit2, ...}，返回格式同 slidingWindowScript
+var fixedWindowScript = goredis.NewScript(`
+local remaining = -1
+for i = 1, #KEYS do
+  local window = tonumber(ARGV[i * 2 - 1])
+  local limit = tonumber(ARGV[i * 2])
+  local count = tonumber(redis.call('GET', KEYS[i]) or '0')
+  if count >= limit then
+    local ttl = redis.call('PTTL', KEYS[i])
+    if ttl < 0 then
+      ttl = window
+    end
+    return {i, 0, ttl}
+  end
+  if remaining < 0 or limit - count - 1 < remaining then
+    remaining = limit - count - 1
+  end
+end
+for i = 1, #KEYS do
+  local count = redis.call('INCR', KEYS[i])
+  if count == 1 or redis.call('PTTL', KEYS[i]) < 0 then
+    redis.call('PEXPIRE', KEYS[i], tonumber(ARGV[i * 2 - 1]))
+  end
+end
+return {0, remaining, 0}
+`)
+
+func (l *redisRateLimiter) Allow(ctx context.Context, key string, algorithm string, limit int, window time.Duration, now time.Time) (RuntimeRateLimitDecision, error) {
+	_, decision, err := l.AllowAll(ctx, algorithm, []RuntimeRateLimitCheck{{Key: key, Limit: limit, Window: window}}, now)
+	return decision, err
+}
+
+func (l *redisRateLimiter) AllowAll(ctx context.Context, algorithm string, checks []RuntimeRateLimitCheck, now time.Time) (int, RuntimeRateLimitDecision, error) {
+	indexes := make([]int, 0, len(checks))
+	keys := make([]string, 0, len(checks))
+	var args []interface{}
+	if algorithm == rateLimitAlgorithmSlidingWindow {
+		// 同一毫秒内多个实例的请求需要不同的 member，否则 ZADD 会互相覆盖
+		args = append(args, now.UnixMilli(), strconv.FormatInt(now.UnixNano(), 10)+"-"+uuid.NewString())
+	}
+	for i, check := range checks {
+		if !check.enabled() {
+			continue
+		}
+		windowMs := check.Window.Milliseconds()
+		key := runtimeRateLimitKeyPrefix + check.Key
+		if algorithm != rateLimitAlgorithmSlidingWindow {
+			key += ":" + strconv.FormatInt(now.UnixMilli()/windowMs, 10)
+		}
+		indexes = append(indexes, i)
+		keys = append(keys, key)
+		args = append(args, windowMs, check.Limit)
+	}
+	if len(keys) == 0 {
+		return -1, RuntimeRateLimitDecision{Allowed: true}, nil
+	}
+
+	script := fixedWindowScript
+	if algorithm == rateLimitAlgorithmSlidingWindow {
+		script = slidingWindowScript
+	}
+	raw, err := script.Run(ctx, l.client.Client, keys, args...).Result()
+	if err != nil {
+		if l.fallback != nil {
+			return l.fallback.AllowAll(ctx, algorithm, checks, now)
+		}
+		return -1, RuntimeRateLimitDecision{}, err
+	}
+	rejected, decision, err := parseRateLimitScriptResult(raw)
+	if err != nil || rejected == 0 {
+		return -1, decision, err
+	}
+	return indexes[rejected-1], decision, nil
+}
+
+func parseRateLimitScriptResult(raw interface{}) (int, RuntimeRateLimitDecision, error) {
+	values, ok := raw.([]interface{})
+	if !ok || len(values) < 3 {
+		return 0, RuntimeRateLimitDecision{}, fmt.Errorf("unexpected rate limit script result: %v", raw)
+	}
+	rejected, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	retryMs, _ := values[2].(int64)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return int(rejected), RuntimeRateLimitDecision{
+		Allowed:    rejected == 0,
+		Remaining:  int(remaining),
+		RetryAfter: time.Duration(retryMs) * time.Millisecond,
+	}, nil
+}
+
+// ==================== 进程内实现 ====================
+
+type memoryRateLimiter struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryRateLimitEntry
+	lastSweep time.Time
+}
+
+type memoryRateLimitEntry struct {
+	hits      []time.Time
+	expiresAt time.Time
+}
+
+const memoryRateLimitSweepInterval = time.Minute
+
+// NewMemoryRateLimiter 创建进程内限流器，仅对当前实例生效
+func NewMemoryRateLimiter() RuntimeRateLimiter {
+	return &memoryRateLimiter{
+		entries: make(map[string]*memoryRateLimitEntry),
+	}
+}
+
+func (l *memoryRateLimiter) Allow(ctx context.Context, key string, algorithm string, limit int, window time.Duration, now time.Time) (RuntimeRateLimitDecision, error) {
+	_, decision, err := l.AllowAll(ctx, algorithm, []RuntimeRateLimitCheck{{Key: key, Limit: limit, Window: window}}, now)
+	return decision, err
+}
+
+func (l *memoryRateLimiter) AllowAll(ctx context.Context, algorithm string, checks []RuntimeRateLimitCheck, now time.Time) (int, RuntimeRateLimitDecision, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	// 先检查全部维度，任一拒绝时不记录任何命中
+	remaining := -1
+	for i, check := range checks {
+		if !check.enabled() {
+			continue
+		}
+		entry := l.prune(check.Key, algorithm, check.Window, now)
+		if len(entry.hits) >= check.Limit {
+			var retryAfter time.Duration
+			if algorithm == rateLimitAlgorithmSlidingWindow {
+				retryAfter = entry.hits[0].Add(check.Window).Sub(now)
+			} else {
+				retryAfter = now.Truncate(check.Window).Add(check.Window).Sub(now)
+			}
+			if retryAfter <= 0 {
+				retryAfter = time.Millisecond
+			}
+			return i, RuntimeRateLimitDecision{Allowed: false, RetryAfter: retryAfter}, nil
+		}
+		if left := check.Limit - len(entry.hits) - 1; remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+
+	for _, check := range checks {
+		if !check.enabled() {
+			continue
+		}
+		entry := l.entries[check.Key]
+		entry.hits = append(entry.hits, now)
+		entry.expiresAt = now.Add(check.Window)
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return -1, RuntimeRateLimitDecision{Allowed: true, Remaining: remaining}, nil
+}
+
+// prune 返回 key 的计数并丢弃当前窗口之前的命中
+func (l *memoryRateLimiter) prune(key, algorithm string, window time.Duration, now time.Time) *memoryRateLimitEntry {
+	var windowStart time.Time
+	if algorithm == rateLimitAlgorithmSlidingWindow {
+		windowStart = now.Add(-window).Add(time.Nanosecond)
+	} else {
+		windowStart = now.Truncate(window)
+	}
+
+	entry, ok := l.entries[key]
+	if !ok {
+		entry = &memoryRateLimitEntry{}
+		l.entries[key] = entry
+	}
+	kept := entry.hits[:0]
+	for _, hit := range entry.hits {
+		if !hit.Before(windowStart) {
+			kept = append(kept, hit)
+		}
+	}
+	entry.hits = kept
+	return entry
+}
+
+// sweep 定期清理过期的计数，避免 key 无限增长
+func (l *memoryRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < memoryRateLimitSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, entry := range l.entries {
+		if now.After(entry.expiresAt) {
+			delete(l.entries, key)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/repository"
+)
+
+func TestMemoryRateLimiter_FixedWindow(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	for i := 0; i < 3; i++ {
+		decision, err := limiter.Allow(ctx, "k", rateLimitAlgorithmFixedWindow, 3, time.Minute, now.Add(time.Duration(i)*time.Second))
+		if err != nil {
+			t.Fatalf("allow: %v", err)
+		}
+		if !decision.Allowed {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+
+	decision, _ := limiter.Allow(ctx, "k", rateLimitAlgorithmFixedWindow, 3, time.Minute, now.Add(10*time.Second))
+	if decision.Allowed {
+		t.Fatal("4th request in window should be denied")
+	}
+	if decision.RetryAfter <= 0 || decision.RetryAfter > time.Minute {
+		t.Fatalf("unexpected retry after %s", decision.RetryAfter)
+	}
+
+	decision, _ = limiter.Allow(ctx, "k", rateLimitAlgorithmFixedWindow, 3, time.Minute, now.Add(decision.RetryAfter+10*time.Second))
+	if !decision.Allowed {
+		t.Fatal("request in next window should be allowed")
+	}
+}
+
+func TestMemoryRateLimiter_SlidingWindow(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+
+	_, _ = limiter.Allow(ctx, "k", rateLimitAlgorithmSlidingWindow, 2, 10*time.Second, now)
+	_, _ = limiter.Allow(ctx, "k", rateLimitAlgorithmSlidingWindow, 2, 10*time.Second, now.Add(4*time.Second))
+
+	decision, _ := limiter.Allow(ctx, "k", rateLimitAlgorithmSlidingWindow, 2, 10*time.Second, now.Add(6*time.Second))
+	if decision.Allowed {
+		t.Fatal("3rd request should be denied")
+	}
+	if decision.RetryAfter != 4*time.Second {
+		t.Fatalf("retry after = %s, want 4s", decision.RetryAfter)
+	}
+
+	decision, _ = limiter.Allow(ctx, "k", rateLimitAlgorithmSlidingWindow, 2, 10*time.Second, now.Add(10*time.Second))
+	if !decision.Allowed {
+		t.Fatal("request after oldest hit expired should be allowed")
+	}
+}
+
+func TestMemoryRateLimiter_KeysAreIndependent(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	ctx := context.Background()
+	now := time.Now()
+
+	_, _ = limiter.Allow(ctx, "a", rateLimitAlgorithmFixedWindow, 1, time.Minute, now)
+	decision, _ := limiter.Allow(ctx, "b", rateLimitAlgorithmFixedWindow, 1, time.Minute, now)
+	if !decision.Allowed {
+		t.Fatal("different keys must not share quota")
+	}
+}
+
+func TestEnforceRateLimit_ReturnsTypedError(t *testing.T) {
+	svc := &runtimeService{rateLimiter: NewMemoryRateLimiter()}
+	entry := &RuntimeEntry{Workspace: &entity.Workspace{ID: uuid.New()}}
+	rule := rateLimitRule{maxRequests: 2, window: time.Minute}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if err := svc.enforceRateLimit(context.Background(), entry, nil, RuntimeAccessMeta{}, "iphash", "", rateLimitAlgorithmFixedWindow, rule, rateLimitRule{}, rateLimitRule{}, now); err != nil {
+			t.Fatalf("request %d: unexpected error %v", i, err)
+		}
+	}
+
+	err := svc.enforceRateLimit(context.Background(), entry, nil, RuntimeAccessMeta{}, "iphash", "", rateLimitAlgorithmFixedWindow, rule, rateLimitRule{}, rateLimitRule{}, now)
+	if !errors.Is(err, ErrRuntimeRateLimited) {
+		t.Fatalf("expected ErrRuntimeRateLimited, got %v", err)
+	}
+	var limitErr *RuntimeRateLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("expected *RuntimeRateLimitError, got %T", err)
+	}
+	if limitErr.Scope != "ip" || limitErr.Limit != 2 {
+		t.Fatalf("unexpected error details: %+v", limitErr)
+	}
+	if limitErr.RetryAfterSeconds() < 1 {
+		t.Fatalf("retry after seconds must be positive, got %d", limitErr.RetryAfterSeconds())
+	}
+}
+
+func TestEnforceRateLimit_WorkspaceScopeSharedAcrossIPs(t *testing.T) {
+	svc := &runtimeService{rateLimiter: NewMemoryRateLimiter()}
+	entry := &RuntimeEntry{Workspace: &entity.Workspace{ID: uuid.New()}}
+	perWorkspace := rateLimitRule{maxRequests: 1, window: time.Minute}
+	now := time.Now()
+
+	if err := svc.enforceRateLimit(context.Background(), entry, nil, RuntimeAccessMeta{}, "ip-a", "", rateLimitAlgorithmFixedWindow, rateLimitRule{}, rateLimitRule{}, perWorkspace, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	err := svc.enforceRateLimit(context.Background(), entry, nil, RuntimeAccessMeta{}, "ip-b", "", rateLimitAlgorithmFixedWindow, rateLimitRule{}, rateLimitRule{}, perWorkspace, now)
+	var limitErr *RuntimeRateLimitError
+	if !errors.As(err, &limitErr) || limitErr.Scope != "workspace" {
+		t.Fatalf("expected workspace scope rate limit, got %v", err)
+	}
+}
+
+func TestResolveRateLimitConfig_AlgorithmAndGrayPolicy(t *testing.T) {
+	workspace := &entity.Workspace{RateLimitJSON: entity.JSON{
+		"algorithm": "sliding_window",
+		"per_ip":    map[string]interface{}{"max_requests": 10, "window_seconds": 30},
+		"graylist":  []interface{}{"10.0.0.1"},
+		"gray_policy": map[string]interface{}{
+			"max_requests": 3,
+		},
+	}}
+	config := resolveRateLimitConfigFromWorkspace(workspace)
+	if config.algorithm != rateLimitAlgorithmSlidingWindow {
+		t.Fatalf("algorithm = %q, want sliding_window", config.algorithm)
+	}
+	if !isListed("10.0.0.1", "", config.graylist) {
+		t.Fatal("expected ip to be graylisted")
+	}
+	adjusted := applyGrayPolicy(config.perIP, config.grayPolicy)
+	if adjusted.maxRequests != 3 || adjusted.window != 30*time.Second {
+		t.Fatalf("unexpected gray-adjusted rule: %+v", adjusted)
+	}
+}
+
+func TestMemoryRateLimiter_AllowAllRejectionConsumesNothing(t *testing.T) {
+	limiter := NewMemoryRateLimiter()
+	ctx := context.Background()
+	now := time.Unix(1_700_000_000, 0)
+	checks := []RuntimeRateLimitCheck{
+		{Key: "ip", Limit: 2, Window: time.Minute},
+		{Key: "workspace", Limit: 1, Window: time.Minute},
+	}
+
+	if rejected, _, _ := limiter.AllowAll(ctx, rateLimitAlgorithmFixedWindow, checks, now); rejected != -1 {
+		t.Fatalf("first request rejected by check %d", rejected)
+	}
+	rejected, decision, _ := limiter.AllowAll(ctx, rateLimitAlgorithmFixedWindow, checks, now)
+	if rejected != 1 || decision.Allowed {
+		t.Fatalf("rejected = %d, decision = %+v, want workspace rejection", rejected, decision)
+	}
+	// The ip check passed but must not have been charged for the rejected request.
+	if decision, _ := limiter.Allow(ctx, "ip", rateLimitAlgorithmFixedWindow, 2, time.Minute, now); !decision.Allowed {
+		t.Fatal("ip quota was consumed by a request the workspace limit rejected")
+	}
+}
+
+// failingEventRepo 写入事件总是失败
+type failingEventRepo struct {
+	repository.WorkspaceRepository
+	events int
+}
+
+func (r *failingEventRepo) CreateEvent(context.Context, *entity.WorkspaceEvent) error {
+	r.events++
+	return errors.New("db down")
+}
+
+func TestEnforceRateLimit_RecordsEventsWithCooldownAndIgnoresFailures(t *testing.T) {
+	repo := &failingEventRepo{}
+	svc := &runtimeService{
+		rateLimiter:   NewMemoryRateLimiter(),
+		anomaly:       newRuntimeAnomalyDetector(),
+		workspaceRepo: repo,
+	}
+	entry := &RuntimeEntry{Workspace: &entity.Workspace{ID: uuid.New()}}
+	session := &entity.WorkspaceSession{ID: uuid.New()}
+	rule := rateLimitRule{maxRequests: 1, window: time.Minute}
+	now := time.Now()
+
+	if err := svc.enforceRateLimit(context.Background(), entry, session, RuntimeAccessMeta{}, "iphash", "", rateLimitAlgorithmFixedWindow, rule, rateLimitRule{}, rateLimitRule{}, now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		err := svc.enforceRateLimit(context.Background(), entry, session, RuntimeAccessMeta{}, "iphash", "", rateLimitAlgorithmFixedWindow, rule, rateLimitRule{}, rateLimitRule{}, now)
+		var limitErr *RuntimeRateLimitError
+		if !errors.As(err, &limitErr) {
+			t.Fatalf("rejection %d: expected *RuntimeRateLimitError, got %v", i, err)
+		}
+	}
+	if repo.events != 1 {
+		t.Fatalf("recorded %d rate limit events, want 1 within the cooldown", repo.events)
+	}
+}
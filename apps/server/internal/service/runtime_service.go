@@ -12,6 +12,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/security"
 	"github.com/reverseai/server/internal/repository"
 	"gorm.io/gorm"
 )
@@ -25,6 +26,16 @@ type RuntimeService interface {
 	GetSchemaByDomain(ctx context.Context, domain string, userID *uuid.UUID) (*RuntimeSchema, error)
 	TrackAnonymousAccess(ctx context.Context, entry *RuntimeEntry, meta RuntimeAccessMeta) (*RuntimeAccessResult, error)
 	RecordRuntimeEvent(ctx context.Context, entry *RuntimeEntry, session *entity.WorkspaceSession, eventType string, payload entity.JSON) error
+	RecordExecutionResult(ctx context.Context, entry *RuntimeEntry, session *entity.WorkspaceSession, meta RuntimeAccessMeta, failed bool, payload entity.JSON) error
+	// InvalidateCache 处理缓存失效事件（订阅 InvalidationBus）
+	InvalidateCache(event InvalidationEvent)
+	// SetSecretRedactor 设置密钥脱敏来源，运行时事件落库前替换其中的密钥明文
+	SetSecretRedactor(source SecretRedactorSource)
+}
+
+// SecretRedactorSource 按工作空间提供密钥脱敏器
+type SecretRedactorSource interface {
+	Redactor(ctx context.Context, workspaceID uuid.UUID) *security.SecretRedactor
 }
 
 // RuntimeEntry Runtime 入口信息
@@ -67,9 +78,12 @@ type runtimeService struct {
 	slugAliasRepo       repository.WorkspaceSlugAliasRepository
 	workspaceMemberRepo repository.WorkspaceMemberRepository
 	eventRecorder       EventRecorderService
+	rateLimiter         RuntimeRateLimiter
+	anomaly             *runtimeAnomalyDetector
 	pii                 *piiSanitizer
 	cache               *runtimeCache
 	cacheGroup          *cacheGroup
+	secrets             SecretRedactorSource
 }
 
 // NewRuntimeService 创建 Runtime 服务实例
@@ -78,6 +92,7 @@ func NewRuntimeService(
 	slugAliasRepo repository.WorkspaceSlugAliasRepository,
 	workspaceMemberRepo repository.WorkspaceMemberRepository,
 	eventRecorder EventRecorderService,
+	rateLimiter RuntimeRateLimiter,
 	piiEnabled bool,
 	cacheSettings RuntimeCacheSettings,
 ) RuntimeService {
@@ -86,11 +101,16 @@ func NewRuntimeService(
 	if runtimeCache != nil {
 		cacheGroup = newCacheGroup()
 	}
+	if rateLimiter == nil {
+		rateLimiter = NewMemoryRateLimiter()
+	}
 	return &runtimeService{
 		workspaceRepo:       workspaceRepo,
 		slugAliasRepo:       slugAliasRepo,
 		workspaceMemberRepo: workspaceMemberRepo,
 		eventRecorder:       eventRecorder,
+		rateLimiter:         rateLimiter,
+		anomaly:             newRuntimeAnomalyDetector(),
 		pii:                 newPIISanitizer(piiEnabled),
 		cache:               runtimeCache,
 		cacheGroup:          cacheGroup,
@@ -298,6 +318,18 @@ func (s *runtimeService) cacheWorkspace(workspace *entity.Workspace, slugs ...st
 	}
 }
 
+func (s *runtimeService) InvalidateCache(event InvalidationEvent) {
+	if event.Kind == InvalidationSecrets || event.Kind == InvalidationAppSessions {
+		// 密钥与应用用户会话不影响入口与版本缓存
+		return
+	}
+	s.cache.invalidateWorkspace(event.WorkspaceID, event.Slugs...)
+}
+
+func (s *runtimeService) SetSecretRedactor(source SecretRedactorSource) {
+	s.secrets = source
+}
+
 func cloneWorkspaceVersion(version *entity.WorkspaceVersion) *entity.WorkspaceVersion {
 	if version == nil {
 		return nil
@@ -476,7 +508,12 @@ func (s *runtimeService) TrackAnonymousAccess(ctx context.Context, entry *Runtim
 		}
 	}
 
-	if err := s.enforceRateLimit(ctx, entry, session, ipHash, perIP, perSession, perWorkspace, now); err != nil {
+	s.observeTraffic(entry.Workspace.ID, ipHash, false, now)
+
+	if err := s.enforceRateLimit(ctx, entry, session, meta, ipHash, userAgentHash, config.algorithm, perIP, perSession, perWorkspace, now); err != nil {
+		if errors.Is(err, ErrRuntimeRateLimited) {
+			s.observeTraffic(entry.Workspace.ID, ipHash, true, now)
+		}
 		return nil, err
 	}
 
@@ -487,12 +524,14 @@ func (s *runtimeService) TrackAnonymousAccess(ctx context.Context, entry *Runtim
 	if len(riskSignals) > 0 {
 		decision.RiskSignals = append(decision.RiskSignals, riskSignals...)
 		decision.RequireCaptcha = true
+		loadShed := shouldLoadShed(eventType, riskSignals)
 		if err := s.recordRuntimeEvent(ctx, entry.Workspace.ID, session, RuntimeEventRiskDetected, buildRiskPayload(meta, ipHash, userAgentHash, riskSignals, entity.JSON{
 			"reason": "anomaly",
 		})); err != nil {
 			return nil, err
 		}
-		if shouldLoadShed(eventType, riskSignals) {
+		s.recordAbuseDetected(ctx, entry, session, meta, ipHash, userAgentHash, riskSignals, loadShed, now)
+		if loadShed {
 			if err := s.recordRuntimeEvent(ctx, entry.Workspace.ID, session, RuntimeEventLoadShed, buildRiskPayload(meta, ipHash, userAgentHash, riskSignals, entity.JSON{
 				"reason": "load_shed",
 			})); err != nil {
@@ -659,6 +698,11 @@ func (s *runtimeService) recordRuntimeEvent(ctx context.Context, workspaceID uui
 	if s.pii != nil {
 		payloadForLog = s.pii.sanitizeJSON(payload)
 	}
+	if s.secrets != nil && payloadForLog != nil {
+		if redacted, ok := s.secrets.Redactor(ctx, workspaceID).RedactValue(map[string]interface{}(payloadForLog)).(map[string]interface{}); ok {
+			payloadForLog = entity.JSON(redacted)
+		}
+	}
 	return s.workspaceRepo.CreateEvent(ctx, &entity.WorkspaceEvent{
 		WorkspaceID: workspaceID,
 		SessionID:   session.ID,
@@ -795,7 +839,7 @@ func resolveRateLimitConfigFromWorkspace(workspace *entity.Workspace) runtimeRat
 			}
 		}
 	}
-	if config.algorithm == "" || config.algorithm != rateLimitAlgorithmFixedWindow {
+	if config.algorithm != rateLimitAlgorithmFixedWindow && config.algorithm != rateLimitAlgorithmSlidingWindow {
 		config.algorithm = rateLimitAlgorithmFixedWindow
 	}
 	if rawMax, ok := raw["max_requests"]; ok {
@@ -1023,24 +1067,104 @@ func (s *runtimeService) enforceRateLimit(
 	ctx context.Context,
 	entry *RuntimeEntry,
 	session *entity.WorkspaceSession,
+	meta RuntimeAccessMeta,
 	ipHash string,
+	userAgentHash string,
+	algorithm string,
 	perIP rateLimitRule,
 	perSession rateLimitRule,
 	perWorkspace rateLimitRule,
 	now time.Time,
 ) error {
-	if entry == nil || entry.Workspace == nil {
+	if entry == nil || entry.Workspace == nil || s.rateLimiter == nil {
 		return nil
 	}
-	// 简化版：暂时跳过详细的速率限制检查
-	// 完整实现需要在 workspace_repo 中添加事件计数方法
-	_ = perIP
-	_ = perSession
-	_ = perWorkspace
-	_ = ipHash
-	_ = session
-	_ = now
-	return nil
+
+	workspaceID := entry.Workspace.ID.String()
+	sessionKey := ""
+	if session != nil {
+		sessionKey = session.ID.String()
+	}
+	candidates := []struct {
+		scope   string
+		subject string
+		rule    rateLimitRule
+	}{
+		{scope: "ip", subject: ipHash, rule: perIP},
+		{scope: "session", subject: sessionKey, rule: perSession},
+		{scope: "workspace", subject: workspaceID, rule: perWorkspace},
+	}
+
+	// 各维度一次性判定：任一维度拒绝时，已通过的维度也不消耗配额
+	scopes := make([]int, 0, len(candidates))
+	checks := make([]RuntimeRateLimitCheck, 0, len(candidates))
+	for i, candidate := range candidates {
+		if !candidate.rule.enabled() || candidate.subject == "" {
+			continue
+		}
+		scopes = append(scopes, i)
+		checks = append(checks, RuntimeRateLimitCheck{
+			Key:    workspaceID + ":" + candidate.scope + ":" + candidate.subject,
+			Limit:  candidate.rule.maxRequests,
+			Window: candidate.rule.window,
+		})
+	}
+	if len(checks) == 0 {
+		return nil
+	}
+	rejected, decision, err := s.rateLimiter.AllowAll(ctx, algorithm, checks, now)
+	if err != nil {
+		return err
+	}
+	if rejected < 0 || decision.Allowed {
+		return nil
+	}
+
+	check := candidates[scopes[rejected]]
+	limitErr := &RuntimeRateLimitError{
+		Scope:      check.scope,
+		Limit:      check.rule.maxRequests,
+		Window:     check.rule.window,
+		RetryAfter: decision.RetryAfter,
+	}
+	s.recordRateLimited(ctx, entry, session, meta, ipHash, userAgentHash, algorithm, check.scope+":"+check.subject, limitErr, now)
+	return limitErr
+}
+
+// recordRateLimited 记录限流事件（同一维度按冷却时间去重）
+// 记录失败不影响限流结果，避免 429 变成 500
+func (s *runtimeService) recordRateLimited(
+	ctx context.Context,
+	entry *RuntimeEntry,
+	session *entity.WorkspaceSession,
+	meta RuntimeAccessMeta,
+	ipHash string,
+	userAgentHash string,
+	algorithm string,
+	subject string,
+	limitErr *RuntimeRateLimitError,
+	now time.Time,
+) {
+	reportKey := entry.Workspace.ID.String() + ":rate_limited:" + subject
+	if s.anomaly != nil && !s.anomaly.shouldReport(reportKey, now) {
+		return
+	}
+	payload := buildRiskPayload(meta, ipHash, userAgentHash, nil, entity.JSON{
+		"reason":              "rate_limited",
+		"scope":               limitErr.Scope,
+		"algorithm":           algorithm,
+		"max_requests":        limitErr.Limit,
+		"window_seconds":      int(limitErr.Window.Seconds()),
+		"retry_after_seconds": limitErr.RetryAfterSeconds(),
+	})
+	_ = s.recordRuntimeEvent(ctx, entry.Workspace.ID, session, RuntimeEventRateLimited, payload)
+	if s.eventRecorder != nil {
+		var sessionID *uuid.UUID
+		if session != nil {
+			sessionID = &session.ID
+		}
+		_ = s.eventRecorder.RecordWorkspaceEvent(ctx, entity.EventWorkspaceRateLimited, entry.Workspace.ID, sessionID, "workspace rate limited", payload)
+	}
 }
 
 func (s *runtimeService) detectAnomalies(
@@ -1051,16 +1175,74 @@ func (s *runtimeService) detectAnomalies(
 	config runtimeRateLimitConfig,
 	now time.Time,
 ) ([]string, error) {
-	if entry == nil || entry.Workspace == nil {
+	if entry == nil || entry.Workspace == nil || s.anomaly == nil {
 		return nil, nil
 	}
-	// 简化版：暂时跳过异常检测
-	// 完整实现需要在 workspace_repo 中添加事件计数方法
-	_ = session
-	_ = ipHash
-	_ = config
-	_ = now
-	return nil, nil
+	return s.anomaly.evaluate(entry.Workspace.ID.String(), ipHash, config.anomaly, now), nil
+}
+
+// observeTraffic 将请求（或失败）计入 IP 与 Workspace 两个维度的滚动窗口
+func (s *runtimeService) observeTraffic(workspaceID uuid.UUID, ipHash string, failed bool, now time.Time) {
+	if s.anomaly == nil {
+		return
+	}
+	keys := []string{anomalyWorkspaceKey(workspaceID.String())}
+	if ipHash != "" {
+		keys = append(keys, anomalyIPKey(workspaceID.String(), ipHash))
+	}
+	for _, key := range keys {
+		if failed {
+			s.anomaly.observeFailure(key, now)
+		} else {
+			s.anomaly.observeRequest(key, now)
+		}
+	}
+}
+
+// recordAbuseDetected 记录可在 Workspace 事件中查看的风险事件（同一来源按冷却时间去重）
+func (s *runtimeService) recordAbuseDetected(
+	ctx context.Context,
+	entry *RuntimeEntry,
+	session *entity.WorkspaceSession,
+	meta RuntimeAccessMeta,
+	ipHash string,
+	userAgentHash string,
+	signals []string,
+	loadShed bool,
+	now time.Time,
+) {
+	if s.eventRecorder == nil || s.anomaly == nil || entry == nil || entry.Workspace == nil {
+		return
+	}
+	reportKey := entry.Workspace.ID.String() + ":" + ipHash + ":" + strings.Join(signals, ",")
+	if !s.anomaly.shouldReport(reportKey, now) {
+		return
+	}
+	var sessionID *uuid.UUID
+	if session != nil {
+		sessionID = &session.ID
+	}
+	payload := buildRiskPayload(meta, ipHash, userAgentHash, signals, entity.JSON{
+		"reason":    "anomaly",
+		"load_shed": loadShed,
+	})
+	_ = s.eventRecorder.RecordWorkspaceEvent(ctx, entity.EventSecurityAbuseDetected, entry.Workspace.ID, sessionID, "runtime anomaly detected", payload)
+}
+
+// RecordExecutionResult 记录一次 Runtime 执行结果，并把失败计入异常检测
+func (s *runtimeService) RecordExecutionResult(ctx context.Context, entry *RuntimeEntry, session *entity.WorkspaceSession, meta RuntimeAccessMeta, failed bool, payload entity.JSON) error {
+	if entry == nil || entry.Workspace == nil {
+		return nil
+	}
+	// 请求数只在匿名公开访问时统计，失败也只统计同一范围，否则失败率会虚高
+	if failed && strings.ToLower(strings.TrimSpace(entry.Workspace.AccessMode)) == "public_anonymous" {
+		s.observeTraffic(entry.Workspace.ID, hashValue(meta.IP), true, time.Now())
+	}
+	eventType := RuntimeEventExecuteSuccess
+	if failed {
+		eventType = RuntimeEventExecuteFailed
+	}
+	return s.recordRuntimeEvent(ctx, entry.Workspace.ID, session, eventType, payload)
 }
 
 func shouldLoadShed(eventType string, signals []string) bool {
@@ -1077,7 +1259,7 @@ func shouldLoadShed(eventType string, signals []string) bool {
 
 func isLoadShedSignal(signal string) bool {
 	switch strings.ToLower(strings.TrimSpace(signal)) {
-	case "high_frequency", "rate_spike", "failure_rate":
+	case RuntimeSignalHighFrequency, RuntimeSignalRateSpike, RuntimeSignalFailureRate:
 		return true
 	default:
 		return false
@@ -1289,4 +1471,6 @@ const (
 	RuntimeEventRiskDetected    = "runtime_risk_detected"
 	RuntimeEventCaptchaRequired = "runtime_captcha_required"
 	RuntimeEventLoadShed        = "runtime_load_shed"
+	RuntimeEventLimitExceeded   = "runtime_limit_exceeded"
+	RuntimeEventFetch           = "runtime_fetch"
 )
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/queue"
+	"github.com/reverseai/server/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxDataHookRunsKept 每个工作空间保留的数据钩子执行记录条数
+	maxDataHookRunsKept = 500
+	// maxDataHookRunError 执行记录中错误信息的长度上限
+	maxDataHookRunError      = 4 << 10
+	defaultDataHookRunsLimit = 50
+	maxDataHookRunsLimit     = 200
+	// dataHookDeadScanPage / maxDataHookDeadScanned 死信队列为所有工作空间共享，按页扫描并过滤，最多扫描的任务数
+	dataHookDeadScanPage   = 100
+	maxDataHookDeadScanned = 1000
+	// dataHookEnqueueTimeout 入队超时，超时后回退为本进程异步执行
+	dataHookEnqueueTimeout = 3 * time.Second
+)
+
+var (
+	ErrDataHookTaskNotFound     = errors.New("data hook task not found")
+	ErrDataHookQueueUnavailable = errors.New("data hook queue unavailable")
+)
+
+// DataHookExecution 一次 after-* 数据钩子执行的上下文
+type DataHookExecution struct {
+	EventID uuid.UUID
+	Hook    string
+	Table   string
+	Data    map[string]interface{}
+	Attempt int
+}
+
+// DataHookExecutor 在工作空间 JS 运行时中执行数据钩子（由数据 Handler 提供）
+// handled 为 false 表示该表没有注册此钩子，不记录执行记录
+type DataHookExecutor func(ctx context.Context, workspace *entity.Workspace, exec DataHookExecution) (handled bool, err error)
+
+// DataHookQueue 数据钩子任务队列（*queue.Queue 实现）
+type DataHookQueue interface {
+	EnqueueDataHook(ctx context.Context, payload *queue.DataHookPayload) (*queue.EnqueueResult, error)
+	GetTaskInfo(queueName, taskID string) (*asynq.TaskInfo, error)
+	ListDeadTasks(queueName string, page, pageSize int) ([]*asynq.TaskInfo, error)
+	RetryDeadTask(queueName, taskID string) error
+}
+
+// DataHookDeadTask 重试次数用尽、进入死信队列的数据钩子
+type DataHookDeadTask struct {
+	TaskID       string    `json:"task_id"`
+	Hook         string    `json:"hook"`
+	Table        string    `json:"table"`
+	OccurredAt   time.Time `json:"occurred_at"`
+	Retried      int       `json:"retried"`
+	LastError    string    `json:"last_error,omitempty"`
+	LastFailedAt time.Time `json:"last_failed_at"`
+}
+
+// WorkspaceDataHookService 数据钩子服务接口
+// after-insert / after-update / after-delete 钩子经任务队列执行，失败按退避重试，用尽后进入死信队列
+type WorkspaceDataHookService interface {
+	// Dispatch 投递一次 after-* 钩子；队列不可用时回退为本进程异步执行（不重试）
+	Dispatch(ctx context.Context, workspaceID uuid.UUID, hook, table string, data map[string]interface{})
+	ListRuns(ctx context.Context, workspaceID, userID uuid.UUID, filter repository.DataHookRunFilter) ([]entity.WorkspaceDataHookRun, error)
+	// ListDeadTasks 列出工作空间在死信队列中的钩子任务
+	ListDeadTasks(ctx context.Context, workspaceID, userID uuid.UUID, limit int) ([]DataHookDeadTask, error)
+	// RetryDeadTask 将死信任务重新入队
+	RetryDeadTask(ctx context.Context, workspaceID, userID uuid.UUID, taskID string) error
+	// RunDataHook 执行一次钩子（实现 queue.DataHookRunner）
+	RunDataHook(ctx context.Context, payload *queue.DataHookPayload, attempt int, final bool) error
+	SetExecutor(executor DataHookExecutor)
+	SetQueue(q DataHookQueue)
+}
+
+type workspaceDataHookService struct {
+	repo             repository.WorkspaceDataHookRepository
+	workspaceRepo    repository.WorkspaceRepository
+	workspaceService WorkspaceService
+	log              logger.Logger
+	executor         DataHookExecutor
+	queue            DataHookQueue
+}
+
+// NewWorkspaceDataHookService 创建数据钩子服务
+func NewWorkspaceDataHookService(
+	repo repository.WorkspaceDataHookRepository,
+	workspaceRepo repository.WorkspaceRepository,
+	workspaceService WorkspaceService,
+	log logger.Logger,
+) WorkspaceDataHookService {
+	return &workspaceDataHookService{
+		repo:             repo,
+		workspaceRepo:    workspaceRepo,
+		workspaceService: workspaceService,
+		log:              log,
+	}
+}
+
+// SetExecutor 设置执行器；未设置时钩子执行失败并按队列策略重试
+func (s *workspaceDataHookService) SetExecutor(executor DataHookExecutor) {
+	s.executor = executor
+}
+
+// SetQueue 设置任务队列；未设置时钩子在本进程异步执行，失败不重试
+func (s *workspaceDataHookService) SetQueue(q DataHookQueue) {
+	s.queue = q
+}
+
+// authorize 校验用户拥有 permission 权限
+func (s *workspaceDataHookService) authorize(ctx context.Context, workspaceID, userID uuid.UUID, permission string) error {
+	access, err := s.workspaceService.GetWorkspaceAccess(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if !access.IsOwner && !hasPermission(access.Permissions, permission) {
+		return ErrWorkspaceUnauthorized
+	}
+	return nil
+}
+
+func (s *workspaceDataHookService) Dispatch(ctx context.Context, workspaceID uuid.UUID, hook, table string, data map[string]interface{}) {
+	payload := &queue.DataHookPayload{
+		EventID:     uuid.New().String(),
+		WorkspaceID: workspaceID.String(),
+		Hook:        hook,
+		Table:       table,
+		Data:        data,
+		OccurredAt:  time.Now().UTC(),
+	}
+	if s.queue != nil {
+		// 请求结束不应取消入队
+		enqueueCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), dataHookEnqueueTimeout)
+		_, err := s.queue.EnqueueDataHook(enqueueCtx, payload)
+		cancel()
+		if err == nil {
+			return
+		}
+		s.log.Warn("Failed to enqueue data hook, running in process", "workspace_id", workspaceID, "hook", hook, "table", table, "error", err)
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), queue.DataHookTimeout)
+		defer cancel()
+		if err := s.RunDataHook(ctx, payload, 1, true); err != nil && !errors.Is(err, queue.ErrTaskNoop) {
+			s.log.Warn("Data hook failed", "workspace_id", workspaceID, "hook", hook, "table", table, "error", err)
+		}
+	}()
+}
+
+func (s *workspaceDataHookService) RunDataHook(ctx context.Context, payload *queue.DataHookPayload, attempt int, final bool) error {
+	workspaceID, err := uuid.Parse(payload.WorkspaceID)
+	if err != nil {
+		return queue.ErrTaskNoop
+	}
+	eventID, err := uuid.Parse(payload.EventID)
+	if err != nil {
+		return queue.ErrTaskNoop
+	}
+	if s.executor == nil {
+		return errors.New("data hook executor not configured")
+	}
+	workspace, err := s.workspaceRepo.GetByID(ctx, workspaceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return queue.ErrTaskNoop
+		}
+		return err
+	}
+	if isWorkspaceSuspended(workspace) {
+		return queue.ErrTaskNoop
+	}
+
+	started := time.Now()
+	handled, execErr := s.executor(ctx, workspace, DataHookExecution{
+		EventID: eventID,
+		Hook:    payload.Hook,
+		Table:   payload.Table,
+		Data:    payload.Data,
+		Attempt: attempt,
+	})
+	if !handled && execErr == nil {
+		return nil
+	}
+
+	finished := time.Now()
+	run := &entity.WorkspaceDataHookRun{
+		WorkspaceID: workspaceID,
+		EventID:     eventID,
+		Hook:        payload.Hook,
+		Table:       payload.Table,
+		Status:      entity.DataHookRunStatusSucceeded,
+		Attempt:     attempt,
+		OccurredAt:  payload.OccurredAt,
+		StartedAt:   started,
+		FinishedAt:  &finished,
+		DurationMs:  finished.Sub(started).Milliseconds(),
+	}
+	if execErr != nil {
+		run.Status = entity.DataHookRunStatusFailed
+		if final {
+			run.Status = entity.DataHookRunStatusDead
+		}
+		run.Error = truncateUTF8(execErr.Error(), maxDataHookRunError)
+	}
+
+	// 任务超时会取消 ctx，执行结果仍需落库
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := s.repo.CreateRun(saveCtx, run); err != nil {
+		s.log.Warn("Failed to record data hook run", "workspace_id", workspaceID, "hook", payload.Hook, "error", err)
+	}
+	if err := s.repo.PruneRuns(saveCtx, workspaceID, maxDataHookRunsKept); err != nil {
+		s.log.Warn("Failed to prune data hook runs", "workspace_id", workspaceID, "error", err)
+	}
+
+	if execErr != nil {
+		return fmt.Errorf("data hook %s/%s failed: %w", payload.Hook, payload.Table, execErr)
+	}
+	return nil
+}
+
+func (s *workspaceDataHookService) ListRuns(ctx context.Context, workspaceID, userID uuid.UUID, filter repository.DataHookRunFilter) ([]entity.WorkspaceDataHookRun, error) {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionLogsView); err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultDataHookRunsLimit
+	}
+	if filter.Limit > maxDataHookRunsLimit {
+		filter.Limit = maxDataHookRunsLimit
+	}
+	return s.repo.ListRuns(ctx, workspaceID, filter)
+}
+
+func (s *workspaceDataHookService) ListDeadTasks(ctx context.Context, workspaceID, userID uuid.UUID, limit int) ([]DataHookDeadTask, error) {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionLogsView); err != nil {
+		return nil, err
+	}
+	if s.queue == nil {
+		return []DataHookDeadTask{}, nil
+	}
+	if limit <= 0 {
+		limit = defaultDataHookRunsLimit
+	}
+	if limit > maxDataHookRunsLimit {
+		limit = maxDataHookRunsLimit
+	}
+
+	tasks := []DataHookDeadTask{}
+	for page := 1; (page-1)*dataHookDeadScanPage < maxDataHookDeadScanned && len(tasks) < limit; page++ {
+		infos, err := s.queue.ListDeadTasks(queue.QueueDataHooks, page, dataHookDeadScanPage)
+		if err != nil {
+			if errors.Is(err, asynq.ErrQueueNotFound) {
+				break
+			}
+			return nil, err
+		}
+		for _, info := range infos {
+			payload, ok := decodeDataHookTask(info, workspaceID)
+			if !ok {
+				continue
+			}
+			tasks = append(tasks, DataHookDeadTask{
+				TaskID:       info.ID,
+				Hook:         payload.Hook,
+				Table:        payload.Table,
+				OccurredAt:   payload.OccurredAt,
+				Retried:      info.Retried,
+				LastError:    info.LastErr,
+				LastFailedAt: info.LastFailedAt,
+			})
+			if len(tasks) >= limit {
+				break
+			}
+		}
+		if len(infos) < dataHookDeadScanPage {
+			break
+		}
+	}
+	return tasks, nil
+}
+
+func (s *workspaceDataHookService) RetryDeadTask(ctx context.Context, workspaceID, userID uuid.UUID, taskID string) error {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionWorkspaceEdit); err != nil {
+		return err
+	}
+	if s.queue == nil {
+		return ErrDataHookQueueUnavailable
+	}
+	info, err := s.queue.GetTaskInfo(queue.QueueDataHooks, taskID)
+	if err != nil {
+		if errors.Is(err, asynq.ErrTaskNotFound) || errors.Is(err, asynq.ErrQueueNotFound) {
+			return ErrDataHookTaskNotFound
+		}
+		return err
+	}
+	if info.State != asynq.TaskStateArchived {
+		return ErrDataHookTaskNotFound
+	}
+	if _, ok := decodeDataHookTask(info, workspaceID); !ok {
+		return ErrDataHookTaskNotFound
+	}
+	return s.queue.RetryDeadTask(queue.QueueDataHooks, taskID)
+}
+
+// decodeDataHookTask 解析钩子任务载荷，不属于该工作空间时返回 false
+func decodeDataHookTask(info *asynq.TaskInfo, workspaceID uuid.UUID) (*queue.DataHookPayload, bool) {
+	if info == nil || info.Type != queue.TaskTypeDataHook {
+		return nil, false
+	}
+	var payload queue.DataHookPayload
+	if err := json.Unmarshal(info.Payload, &payload); err != nil {
+		return nil, false
+	}
+	if payload.WorkspaceID != workspaceID.String() {
+		return nil, false
+	}
+	return &payload, true
+}
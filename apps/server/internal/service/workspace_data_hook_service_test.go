@@ -0,0 +1,283 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/queue"
+	"github.com/reverseai/server/internal/repository"
+)
+
+// memoryDataHookRepo 内存版 WorkspaceDataHookRepository
+type memoryDataHookRepo struct {
+	mu   sync.Mutex
+	runs []entity.WorkspaceDataHookRun
+}
+
+func (r *memoryDataHookRepo) CreateRun(_ context.Context, run *entity.WorkspaceDataHookRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs = append(r.runs, *run)
+	return nil
+}
+
+func (r *memoryDataHookRepo) ListRuns(_ context.Context, workspaceID uuid.UUID, filter repository.DataHookRunFilter) ([]entity.WorkspaceDataHookRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var runs []entity.WorkspaceDataHookRun
+	for i := len(r.runs) - 1; i >= 0 && len(runs) < filter.Limit; i-- {
+		run := r.runs[i]
+		if run.WorkspaceID == workspaceID && (filter.Status == "" || run.Status == filter.Status) &&
+			(filter.Table == "" || run.Table == filter.Table) {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (r *memoryDataHookRepo) PruneRuns(context.Context, uuid.UUID, int) error {
+	return nil
+}
+
+// memoryDataHookQueue 记录入队的钩子；dead 为死信队列中的任务
+type memoryDataHookQueue struct {
+	mu         sync.Mutex
+	payloads   []queue.DataHookPayload
+	dead       []*asynq.TaskInfo
+	retried    []string
+	enqueueErr error
+}
+
+func (q *memoryDataHookQueue) EnqueueDataHook(_ context.Context, payload *queue.DataHookPayload) (*queue.EnqueueResult, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.enqueueErr != nil {
+		return nil, q.enqueueErr
+	}
+	q.payloads = append(q.payloads, *payload)
+	return &queue.EnqueueResult{TaskID: payload.EventID, Queue: queue.QueueDataHooks}, nil
+}
+
+func (q *memoryDataHookQueue) GetTaskInfo(_, taskID string) (*asynq.TaskInfo, error) {
+	for _, info := range q.dead {
+		if info.ID == taskID {
+			return info, nil
+		}
+	}
+	return nil, asynq.ErrTaskNotFound
+}
+
+func (q *memoryDataHookQueue) ListDeadTasks(_ string, page, pageSize int) ([]*asynq.TaskInfo, error) {
+	start := (page - 1) * pageSize
+	if start >= len(q.dead) {
+		return nil, nil
+	}
+	end := start + pageSize
+	if end > len(q.dead) {
+		end = len(q.dead)
+	}
+	return q.dead[start:end], nil
+}
+
+func (q *memoryDataHookQueue) RetryDeadTask(_, taskID string) error {
+	q.retried = append(q.retried, taskID)
+	return nil
+}
+
+// addDead 向死信队列加入一个属于 workspaceID 的钩子任务
+func (q *memoryDataHookQueue) addDead(t *testing.T, workspaceID uuid.UUID, table string) string {
+	t.Helper()
+	payload := queue.DataHookPayload{EventID: uuid.New().String(), WorkspaceID: workspaceID.String(), Hook: entity.DataHookAfterInsert, Table: table}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	q.dead = append(q.dead, &asynq.TaskInfo{
+		ID: payload.EventID, Type: queue.TaskTypeDataHook, Payload: data,
+		State: asynq.TaskStateArchived, Retried: queue.MaxRetryDataHook, LastErr: "boom",
+	})
+	return payload.EventID
+}
+
+type dataHookServiceFixture struct {
+	svc       WorkspaceDataHookService
+	repo      *memoryDataHookRepo
+	queue     *memoryDataHookQueue
+	workspace *entity.Workspace
+	owner     uuid.UUID
+	viewer    uuid.UUID
+}
+
+func newDataHookServiceFixture(t *testing.T) *dataHookServiceFixture {
+	t.Helper()
+	log, err := logger.New(false)
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	owner, viewer := uuid.New(), uuid.New()
+	workspace := &entity.Workspace{ID: uuid.New(), OwnerUserID: owner, Status: "active", Settings: entity.JSON{}}
+	workspaces := &stubWorkspaceRepo{workspaces: map[uuid.UUID]*entity.Workspace{workspace.ID: workspace}}
+	access := &stubAccessWorkspaceService{access: map[uuid.UUID]*WorkspaceAccess{
+		owner:  {Workspace: workspace, IsOwner: true},
+		viewer: {Workspace: workspace, Permissions: entity.JSON{PermissionLogsView: true}},
+	}}
+	repo := &memoryDataHookRepo{}
+	q := &memoryDataHookQueue{}
+	svc := NewWorkspaceDataHookService(repo, workspaces, access, log)
+	svc.SetQueue(q)
+	return &dataHookServiceFixture{svc: svc, repo: repo, queue: q, workspace: workspace, owner: owner, viewer: viewer}
+}
+
+func TestWorkspaceDataHookService_RunAndHistory(t *testing.T) {
+	f := newDataHookServiceFixture(t)
+	ctx := context.Background()
+
+	var attempts []int
+	f.svc.SetExecutor(func(_ context.Context, _ *entity.Workspace, exec DataHookExecution) (bool, error) {
+		attempts = append(attempts, exec.Attempt)
+		switch exec.Table {
+		case "unhooked":
+			return false, nil
+		case "flaky":
+			if exec.Attempt < 2 {
+				return true, errors.New("webhook unavailable")
+			}
+			return true, nil
+		default:
+			return true, errors.New("always fails")
+		}
+	})
+
+	payload := func(table string) *queue.DataHookPayload {
+		return &queue.DataHookPayload{
+			EventID: uuid.New().String(), WorkspaceID: f.workspace.ID.String(),
+			Hook: entity.DataHookAfterInsert, Table: table, OccurredAt: time.Now(),
+		}
+	}
+
+	// 未注册钩子的表不留执行记录
+	if err := f.svc.RunDataHook(ctx, payload("unhooked"), 1, false); err != nil {
+		t.Fatalf("unhooked: %v", err)
+	}
+
+	// 失败返回错误以便队列重试，重试成功后记录 succeeded
+	flaky := payload("flaky")
+	if err := f.svc.RunDataHook(ctx, flaky, 1, false); err == nil {
+		t.Fatal("first attempt should fail")
+	}
+	if err := f.svc.RunDataHook(ctx, flaky, 2, false); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+
+	// 最后一次尝试失败记为 dead
+	if err := f.svc.RunDataHook(ctx, payload("broken"), queue.MaxRetryDataHook+1, true); err == nil {
+		t.Fatal("final attempt should fail")
+	}
+
+	runs, err := f.svc.ListRuns(ctx, f.workspace.ID, f.viewer, repository.DataHookRunFilter{})
+	if err != nil {
+		t.Fatalf("ListRuns: %v", err)
+	}
+	if len(runs) != 3 {
+		t.Fatalf("runs = %+v, want 3", runs)
+	}
+	if runs[0].Status != entity.DataHookRunStatusDead || runs[1].Status != entity.DataHookRunStatusSucceeded ||
+		runs[2].Status != entity.DataHookRunStatusFailed || runs[2].Error != "webhook unavailable" {
+		t.Fatalf("runs = %+v", runs)
+	}
+	if runs[1].EventID != runs[2].EventID || runs[1].Attempt != 2 {
+		t.Fatalf("retry should keep the event id: %+v", runs[1:])
+	}
+
+	dead, err := f.svc.ListRuns(ctx, f.workspace.ID, f.viewer, repository.DataHookRunFilter{Status: entity.DataHookRunStatusDead})
+	if err != nil || len(dead) != 1 || dead[0].Table != "broken" {
+		t.Fatalf("dead runs = %+v, %v", dead, err)
+	}
+
+	// 停用的工作空间不再执行
+	f.workspace.Status = "suspended"
+	if err := f.svc.RunDataHook(ctx, payload("broken"), 1, false); !errors.Is(err, queue.ErrTaskNoop) {
+		t.Fatalf("suspended: %v, want ErrTaskNoop", err)
+	}
+}
+
+func TestWorkspaceDataHookService_DispatchFallback(t *testing.T) {
+	f := newDataHookServiceFixture(t)
+	ctx := context.Background()
+
+	ran := make(chan DataHookExecution, 1)
+	f.svc.SetExecutor(func(_ context.Context, _ *entity.Workspace, exec DataHookExecution) (bool, error) {
+		ran <- exec
+		return true, nil
+	})
+
+	f.svc.Dispatch(ctx, f.workspace.ID, entity.DataHookAfterUpdate, "orders", map[string]interface{}{"id": 1})
+	if len(f.queue.payloads) != 1 {
+		t.Fatalf("payloads = %+v", f.queue.payloads)
+	}
+	p := f.queue.payloads[0]
+	if p.Hook != entity.DataHookAfterUpdate || p.Table != "orders" || p.WorkspaceID != f.workspace.ID.String() || p.EventID == "" {
+		t.Fatalf("payload = %+v", p)
+	}
+	select {
+	case exec := <-ran:
+		t.Fatalf("enqueued hook ran in process: %+v", exec)
+	default:
+	}
+
+	// 入队失败时回退为本进程异步执行，钩子不丢失
+	f.queue.enqueueErr = errors.New("redis down")
+	f.svc.Dispatch(ctx, f.workspace.ID, entity.DataHookAfterDelete, "orders", map[string]interface{}{"ids": []interface{}{1}})
+	select {
+	case exec := <-ran:
+		if exec.Hook != entity.DataHookAfterDelete || exec.Attempt != 1 {
+			t.Fatalf("exec = %+v", exec)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("fallback hook did not run")
+	}
+}
+
+func TestWorkspaceDataHookService_DeadTasks(t *testing.T) {
+	f := newDataHookServiceFixture(t)
+	ctx := context.Background()
+
+	other := uuid.New()
+	f.queue.addDead(t, other, "orders")
+	own := f.queue.addDead(t, f.workspace.ID, "orders")
+	foreign := f.queue.addDead(t, other, "payments")
+
+	// 死信队列为所有工作空间共享，只返回本工作空间的任务
+	tasks, err := f.svc.ListDeadTasks(ctx, f.workspace.ID, f.viewer, 0)
+	if err != nil {
+		t.Fatalf("ListDeadTasks: %v", err)
+	}
+	if len(tasks) != 1 || tasks[0].TaskID != own || tasks[0].LastError != "boom" || tasks[0].Retried != queue.MaxRetryDataHook {
+		t.Fatalf("tasks = %+v", tasks)
+	}
+
+	// 重试需要 workspace_edit，且不能重试其他工作空间的任务
+	if err := f.svc.RetryDeadTask(ctx, f.workspace.ID, f.viewer, own); !errors.Is(err, ErrWorkspaceUnauthorized) {
+		t.Fatalf("viewer retry: %v, want ErrWorkspaceUnauthorized", err)
+	}
+	if err := f.svc.RetryDeadTask(ctx, f.workspace.ID, f.owner, foreign); !errors.Is(err, ErrDataHookTaskNotFound) {
+		t.Fatalf("foreign retry: %v, want ErrDataHookTaskNotFound", err)
+	}
+	if err := f.svc.RetryDeadTask(ctx, f.workspace.ID, f.owner, "missing"); !errors.Is(err, ErrDataHookTaskNotFound) {
+		t.Fatalf("missing retry: %v, want ErrDataHookTaskNotFound", err)
+	}
+	if err := f.svc.RetryDeadTask(ctx, f.workspace.ID, f.owner, own); err != nil {
+		t.Fatalf("RetryDeadTask: %v", err)
+	}
+	if len(f.queue.retried) != 1 || f.queue.retried[0] != own {
+		t.Fatalf("retried = %v", f.queue.retried)
+	}
+}
@@ -2,7 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/reverseai/server/internal/domain/entity"
@@ -11,13 +13,20 @@ import (
 
 // WorkspaceRLSService RLS 策略服务接口
 type WorkspaceRLSService interface {
-	CreatePolicy(ctx context.Context, workspaceID uuid.UUID, tableName, column, matchField, operation, description string) (*entity.RLSPolicy, error)
+	CreatePolicy(ctx context.Context, workspaceID uuid.UUID, tableName, column, matchField, operation, expression, description string) (*entity.RLSPolicy, error)
 	ListPolicies(ctx context.Context, workspaceID uuid.UUID) ([]entity.RLSPolicy, error)
 	GetActivePoliciesForTable(ctx context.Context, workspaceID uuid.UUID, tableName string) ([]entity.RLSPolicy, error)
-	UpdatePolicy(ctx context.Context, workspaceID uuid.UUID, policyID uuid.UUID, enabled *bool, column, matchField, operation, description *string) (*entity.RLSPolicy, error)
+	UpdatePolicy(ctx context.Context, workspaceID uuid.UUID, policyID uuid.UUID, enabled *bool, column, matchField, operation, expression, description *string) (*entity.RLSPolicy, error)
 	DeletePolicy(ctx context.Context, workspaceID uuid.UUID, policyID uuid.UUID) error
 }
 
+var (
+	// ErrInvalidRLSOperation operation 不在 select/insert/update/delete/all 中
+	ErrInvalidRLSOperation = errors.New("invalid RLS operation, must be one of select, insert, update, delete, all")
+	// ErrInvalidRLSMatchField match_field 不在 app_user_id/email/role 中
+	ErrInvalidRLSMatchField = errors.New("invalid RLS match_field, must be one of app_user_id, email, role")
+)
+
 type workspaceRLSService struct {
 	repo repository.RLSPolicyRepository
 }
@@ -26,15 +35,25 @@ func NewWorkspaceRLSService(repo repository.RLSPolicyRepository) WorkspaceRLSSer
 	return &workspaceRLSService{repo: repo}
 }
 
-func (s *workspaceRLSService) CreatePolicy(ctx context.Context, workspaceID uuid.UUID, tableName, column, matchField, operation, description string) (*entity.RLSPolicy, error) {
-	if tableName == "" || column == "" {
-		return nil, fmt.Errorf("table_name and column are required")
+func (s *workspaceRLSService) CreatePolicy(ctx context.Context, workspaceID uuid.UUID, tableName, column, matchField, operation, expression, description string) (*entity.RLSPolicy, error) {
+	expression = strings.TrimSpace(expression)
+	if tableName == "" || (column == "" && expression == "") {
+		return nil, fmt.Errorf("table_name and column or expression are required")
 	}
 	if matchField == "" {
 		matchField = "app_user_id"
 	}
-	if operation == "" {
-		operation = "all"
+	if !isValidRLSMatchField(matchField) {
+		return nil, ErrInvalidRLSMatchField
+	}
+	if expression != "" {
+		if err := ValidateRLSExpression(expression); err != nil {
+			return nil, err
+		}
+	}
+	operation, err := normalizeRLSOperation(operation)
+	if err != nil {
+		return nil, err
 	}
 
 	policy := &entity.RLSPolicy{
@@ -44,6 +63,7 @@ func (s *workspaceRLSService) CreatePolicy(ctx context.Context, workspaceID uuid
 		Column:      column,
 		MatchField:  matchField,
 		Operation:   operation,
+		Expression:  expression,
 		Enabled:     true,
 		Description: description,
 	}
@@ -62,7 +82,7 @@ func (s *workspaceRLSService) GetActivePoliciesForTable(ctx context.Context, wor
 	return s.repo.ListByTable(ctx, workspaceID, tableName)
 }
 
-func (s *workspaceRLSService) UpdatePolicy(ctx context.Context, workspaceID uuid.UUID, policyID uuid.UUID, enabled *bool, column, matchField, operation, description *string) (*entity.RLSPolicy, error) {
+func (s *workspaceRLSService) UpdatePolicy(ctx context.Context, workspaceID uuid.UUID, policyID uuid.UUID, enabled *bool, column, matchField, operation, expression, description *string) (*entity.RLSPolicy, error) {
 	policy, err := s.repo.GetByID(ctx, policyID)
 	if err != nil {
 		return nil, err
@@ -78,14 +98,33 @@ func (s *workspaceRLSService) UpdatePolicy(ctx context.Context, workspaceID uuid
 		policy.Column = *column
 	}
 	if matchField != nil {
+		if !isValidRLSMatchField(*matchField) {
+			return nil, ErrInvalidRLSMatchField
+		}
 		policy.MatchField = *matchField
 	}
 	if operation != nil {
-		policy.Operation = *operation
+		op, err := normalizeRLSOperation(*operation)
+		if err != nil {
+			return nil, err
+		}
+		policy.Operation = op
+	}
+	if expression != nil {
+		expr := strings.TrimSpace(*expression)
+		if expr != "" {
+			if err := ValidateRLSExpression(expr); err != nil {
+				return nil, err
+			}
+		}
+		policy.Expression = expr
 	}
 	if description != nil {
 		policy.Description = *description
 	}
+	if policy.Column == "" && policy.Expression == "" {
+		return nil, fmt.Errorf("policy requires a column or an expression")
+	}
 
 	if err := s.repo.Update(ctx, policy); err != nil {
 		return nil, fmt.Errorf("failed to update RLS policy: %w", err)
@@ -103,3 +142,23 @@ func (s *workspaceRLSService) DeletePolicy(ctx context.Context, workspaceID uuid
 	}
 	return s.repo.Delete(ctx, policyID)
 }
+
+// normalizeRLSOperation 统一小写并校验 operation，空值默认为 all
+func normalizeRLSOperation(operation string) (string, error) {
+	op := strings.ToLower(strings.TrimSpace(operation))
+	if op == "" {
+		return entity.RLSOperationAll, nil
+	}
+	if !entity.IsValidRLSOperation(op) {
+		return "", ErrInvalidRLSOperation
+	}
+	return op, nil
+}
+
+func isValidRLSMatchField(matchField string) bool {
+	switch matchField {
+	case "app_user_id", "email", "role":
+		return true
+	}
+	return false
+}
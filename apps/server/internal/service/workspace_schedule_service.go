@@ -0,0 +1,523 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/queue"
+	"github.com/reverseai/server/internal/repository"
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+)
+
+const (
+	// scheduleTimezoneSettingsKey Workspace.Settings 中保存定时任务时区的键（IANA 名称，默认 UTC）
+	scheduleTimezoneSettingsKey = "timezone"
+	// scheduleRunStaleAfter 执行中标记的失效时间，略大于任务超时，避免进程崩溃后该任务一直被跳过
+	scheduleRunStaleAfter = queue.WorkspaceScheduleTimeout + time.Minute
+	// maxScheduleRunsKept 每个定时任务保留的执行记录条数
+	maxScheduleRunsKept = 200
+	// maxScheduleRunError 执行记录中错误信息的长度上限
+	maxScheduleRunError      = 4 << 10
+	defaultScheduleRunsLimit = 50
+	maxScheduleRunsLimit     = 200
+)
+
+var (
+	ErrScheduleNotFound         = errors.New("schedule not found")
+	ErrInvalidTimezone          = errors.New("invalid timezone, must be an IANA name such as Asia/Shanghai")
+	ErrScheduleQueueUnavailable = errors.New("schedule queue unavailable")
+)
+
+// ScheduleExecution 一次定时任务执行的上下文
+type ScheduleExecution struct {
+	RunID       uuid.UUID
+	Cron        string
+	Trigger     string
+	ScheduledAt time.Time
+	Attempt     int
+}
+
+// ScheduleExecutor 在工作空间 JS 运行时中执行定时任务（由运行时 Handler 提供）
+type ScheduleExecutor func(ctx context.Context, workspace *entity.Workspace, exec ScheduleExecution) error
+
+// ScheduleEnqueuer 定时任务入队（*queue.Queue 实现）
+type ScheduleEnqueuer interface {
+	EnqueueWorkspaceSchedule(ctx context.Context, payload *queue.WorkspaceSchedulePayload, taskID string) (*queue.EnqueueResult, error)
+}
+
+// WorkspaceScheduleInfo 定时任务及其下一次触发时间
+type WorkspaceScheduleInfo struct {
+	entity.WorkspaceSchedule
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+}
+
+// WorkspaceScheduleList 工作空间的定时任务列表
+type WorkspaceScheduleList struct {
+	Timezone  string                  `json:"timezone"`
+	Schedules []WorkspaceScheduleInfo `json:"schedules"`
+}
+
+// WorkspaceScheduleService 工作空间定时任务服务接口
+// 定时任务由 JS 逻辑的 exports.schedules 声明，部署后同步到数据库；
+// 调度循环按工作空间时区为到期的任务入队，由队列 Worker 调用 RunWorkspaceSchedule 执行
+type WorkspaceScheduleService interface {
+	List(ctx context.Context, workspaceID, userID uuid.UUID) (*WorkspaceScheduleList, error)
+	SetEnabled(ctx context.Context, workspaceID, userID, scheduleID uuid.UUID, enabled bool) (*entity.WorkspaceSchedule, error)
+	UpdateTimezone(ctx context.Context, workspaceID, userID uuid.UUID, timezone string) (string, error)
+	ListRuns(ctx context.Context, workspaceID, userID uuid.UUID, scheduleID *uuid.UUID, limit int) ([]entity.WorkspaceScheduleRun, error)
+	// RunNow 手动触发一次执行（异步入队）
+	RunNow(ctx context.Context, workspaceID, userID, scheduleID uuid.UUID) (*queue.EnqueueResult, error)
+	// Sync 将已部署代码声明的定时任务同步到数据库，specs 为空表示没有定时任务
+	Sync(ctx context.Context, workspaceID uuid.UUID, specs []string) error
+	// Tick 为 (from, to] 内到期的定时任务入队，返回新入队的数量；多个实例同时调用时按任务 ID 去重
+	// 任一定时任务入队失败时返回错误，调用方应重试该时间段
+	Tick(ctx context.Context, from, to time.Time) (int, error)
+	// Start 启动每分钟一次的调度循环，Stop 停止
+	Start()
+	Stop()
+	// RunWorkspaceSchedule 执行一次定时任务（实现 queue.ScheduleRunner）
+	RunWorkspaceSchedule(ctx context.Context, payload *queue.WorkspaceSchedulePayload, attempt int) error
+	SetExecutor(executor ScheduleExecutor)
+	SetEnqueuer(enqueuer ScheduleEnqueuer)
+}
+
+type workspaceScheduleService struct {
+	repo             repository.WorkspaceScheduleRepository
+	workspaceRepo    repository.WorkspaceRepository
+	workspaceService WorkspaceService
+	log              logger.Logger
+	executor         ScheduleExecutor
+	enqueuer         ScheduleEnqueuer
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewWorkspaceScheduleService 创建工作空间定时任务服务
+func NewWorkspaceScheduleService(
+	repo repository.WorkspaceScheduleRepository,
+	workspaceRepo repository.WorkspaceRepository,
+	workspaceService WorkspaceService,
+	log logger.Logger,
+) WorkspaceScheduleService {
+	return &workspaceScheduleService{
+		repo:             repo,
+		workspaceRepo:    workspaceRepo,
+		workspaceService: workspaceService,
+		log:              log,
+		stop:             make(chan struct{}),
+		done:             make(chan struct{}),
+	}
+}
+
+// SetExecutor 设置执行器；未设置时任务执行失败并按队列策略重试
+func (s *workspaceScheduleService) SetExecutor(executor ScheduleExecutor) {
+	s.executor = executor
+}
+
+// SetEnqueuer 设置任务队列；未设置时调度循环与手动触发不可用
+func (s *workspaceScheduleService) SetEnqueuer(enqueuer ScheduleEnqueuer) {
+	s.enqueuer = enqueuer
+}
+
+// authorize 校验用户拥有 permission 权限
+func (s *workspaceScheduleService) authorize(ctx context.Context, workspaceID, userID uuid.UUID, permission string) (*WorkspaceAccess, error) {
+	access, err := s.workspaceService.GetWorkspaceAccess(ctx, workspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !access.IsOwner && !hasPermission(access.Permissions, permission) {
+		return nil, ErrWorkspaceUnauthorized
+	}
+	return access, nil
+}
+
+func (s *workspaceScheduleService) List(ctx context.Context, workspaceID, userID uuid.UUID) (*WorkspaceScheduleList, error) {
+	access, err := s.authorize(ctx, workspaceID, userID, PermissionLogsView)
+	if err != nil {
+		return nil, err
+	}
+	schedules, err := s.repo.ListByWorkspace(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	timezone := scheduleTimezone(access.Workspace.Settings)
+	loc, _ := time.LoadLocation(timezone)
+	now := time.Now()
+	list := &WorkspaceScheduleList{Timezone: timezone, Schedules: make([]WorkspaceScheduleInfo, 0, len(schedules))}
+	for _, schedule := range schedules {
+		info := WorkspaceScheduleInfo{WorkspaceSchedule: schedule}
+		if schedule.Enabled {
+			if parsed, err := parseScheduleSpec(schedule.Cron, loc); err == nil {
+				next := parsed.Next(now)
+				info.NextRunAt = &next
+			}
+		}
+		list.Schedules = append(list.Schedules, info)
+	}
+	return list, nil
+}
+
+func (s *workspaceScheduleService) SetEnabled(ctx context.Context, workspaceID, userID, scheduleID uuid.UUID, enabled bool) (*entity.WorkspaceSchedule, error) {
+	if _, err := s.authorize(ctx, workspaceID, userID, PermissionWorkspaceEdit); err != nil {
+		return nil, err
+	}
+	schedule, err := s.getSchedule(ctx, workspaceID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.SetEnabled(ctx, schedule.ID, enabled); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+	schedule.Enabled = enabled
+	return schedule, nil
+}
+
+// UpdateTimezone 设置工作空间定时任务时区，返回规范化后的名称
+func (s *workspaceScheduleService) UpdateTimezone(ctx context.Context, workspaceID, userID uuid.UUID, timezone string) (string, error) {
+	if timezone == "" || timezone == "Local" {
+		return "", ErrInvalidTimezone
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return "", ErrInvalidTimezone
+	}
+	workspace, err := s.workspaceService.GetByID(ctx, workspaceID, userID)
+	if err != nil {
+		return "", err
+	}
+	settings := entity.JSON{}
+	for k, v := range workspace.Settings {
+		settings[k] = v
+	}
+	settings[scheduleTimezoneSettingsKey] = loc.String()
+	if err := s.workspaceService.UpdateSettings(ctx, workspaceID, userID, settings); err != nil {
+		return "", err
+	}
+	return loc.String(), nil
+}
+
+func (s *workspaceScheduleService) ListRuns(ctx context.Context, workspaceID, userID uuid.UUID, scheduleID *uuid.UUID, limit int) ([]entity.WorkspaceScheduleRun, error) {
+	if _, err := s.authorize(ctx, workspaceID, userID, PermissionLogsView); err != nil {
+		return nil, err
+	}
+	if limit <= 0 {
+		limit = defaultScheduleRunsLimit
+	}
+	if limit > maxScheduleRunsLimit {
+		limit = maxScheduleRunsLimit
+	}
+	return s.repo.ListRuns(ctx, workspaceID, scheduleID, limit)
+}
+
+func (s *workspaceScheduleService) RunNow(ctx context.Context, workspaceID, userID, scheduleID uuid.UUID) (*queue.EnqueueResult, error) {
+	if _, err := s.authorize(ctx, workspaceID, userID, PermissionWorkspaceEdit); err != nil {
+		return nil, err
+	}
+	schedule, err := s.getSchedule(ctx, workspaceID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+	if s.enqueuer == nil {
+		return nil, ErrScheduleQueueUnavailable
+	}
+	triggeredBy := userID.String()
+	return s.enqueuer.EnqueueWorkspaceSchedule(ctx, &queue.WorkspaceSchedulePayload{
+		WorkspaceID: workspaceID.String(),
+		Schedule:    schedule.Cron,
+		Trigger:     entity.ScheduleTriggerManual,
+		ScheduledAt: time.Now().UTC(),
+		TriggeredBy: &triggeredBy,
+	}, "")
+}
+
+func (s *workspaceScheduleService) Sync(ctx context.Context, workspaceID uuid.UUID, specs []string) error {
+	existing, err := s.repo.ListByWorkspace(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	wanted := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		wanted[spec] = true
+	}
+	for _, schedule := range existing {
+		if wanted[schedule.Cron] {
+			delete(wanted, schedule.Cron)
+			continue
+		}
+		if err := s.repo.Delete(ctx, schedule.ID); err != nil {
+			return fmt.Errorf("failed to delete schedule: %w", err)
+		}
+	}
+	for _, spec := range specs {
+		if !wanted[spec] {
+			continue
+		}
+		if err := s.repo.Create(ctx, &entity.WorkspaceSchedule{WorkspaceID: workspaceID, Cron: spec, Enabled: true}); err != nil {
+			// 多个实例收到同一失效事件时会并发同步，已被其他实例创建则忽略
+			if _, getErr := s.repo.GetByCron(ctx, workspaceID, spec); getErr == nil {
+				continue
+			}
+			return fmt.Errorf("failed to create schedule: %w", err)
+		}
+	}
+	return nil
+}
+
+func (s *workspaceScheduleService) Tick(ctx context.Context, from, to time.Time) (int, error) {
+	if s.enqueuer == nil {
+		return 0, ErrScheduleQueueUnavailable
+	}
+	schedules, err := s.repo.ListEnabled(ctx)
+	if err != nil {
+		return 0, err
+	}
+	locations := make(map[uuid.UUID]*time.Location)
+	enqueued, failed := 0, 0
+	var enqueueErr error
+	for _, schedule := range schedules {
+		loc, ok := locations[schedule.WorkspaceID]
+		if !ok {
+			loc = s.workspaceLocation(ctx, schedule.WorkspaceID)
+			locations[schedule.WorkspaceID] = loc
+		}
+		if loc == nil {
+			continue
+		}
+		parsed, err := parseScheduleSpec(schedule.Cron, loc)
+		if err != nil {
+			continue
+		}
+		// 调度循环延迟时可能错过多个触发点，只补最近的一次
+		var fire time.Time
+		for next := parsed.Next(from); !next.IsZero() && !next.After(to); next = parsed.Next(next) {
+			fire = next
+		}
+		if fire.IsZero() {
+			continue
+		}
+		result, err := s.enqueuer.EnqueueWorkspaceSchedule(ctx, &queue.WorkspaceSchedulePayload{
+			WorkspaceID: schedule.WorkspaceID.String(),
+			Schedule:    schedule.Cron,
+			Trigger:     entity.ScheduleTriggerCron,
+			ScheduledAt: fire.UTC(),
+		}, fmt.Sprintf("schedule:%s:%d", schedule.ID, fire.Unix()))
+		if err != nil {
+			s.log.Warn("Failed to enqueue workspace schedule", "workspace_id", schedule.WorkspaceID, "cron", schedule.Cron, "error", err)
+			failed++
+			enqueueErr = err
+			continue
+		}
+		if !result.Deduped {
+			enqueued++
+		}
+	}
+	if failed > 0 {
+		return enqueued, fmt.Errorf("failed to enqueue %d schedules: %w", failed, enqueueErr)
+	}
+	return enqueued, nil
+}
+
+// workspaceLocation 返回工作空间的定时任务时区；工作空间不存在或已停用时返回 nil
+func (s *workspaceScheduleService) workspaceLocation(ctx context.Context, workspaceID uuid.UUID) *time.Location {
+	workspace, err := s.workspaceRepo.GetByID(ctx, workspaceID)
+	if err != nil || isWorkspaceSuspended(workspace) {
+		return nil
+	}
+	loc, err := time.LoadLocation(scheduleTimezone(workspace.Settings))
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+func (s *workspaceScheduleService) Start() {
+	go s.loop()
+}
+
+func (s *workspaceScheduleService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	<-s.done
+}
+
+// loop 在每分钟开始后不久调度一次，覆盖上次成功调度至今的时间段
+func (s *workspaceScheduleService) loop() {
+	defer close(s.done)
+	last := time.Now()
+	wake := last
+	for {
+		wait := time.Until(wake.Truncate(time.Minute).Add(time.Minute + time.Second))
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(wait):
+		}
+		wake = time.Now()
+		last = s.tickSince(last, wake)
+	}
+}
+
+// tickSince 调度 (last, now] 时间段：成功时返回 now 作为下次的起点；
+// 失败时返回 last，下一分钟重试该时间段（已入队的触发点按任务 ID 去重）
+func (s *workspaceScheduleService) tickSince(last, now time.Time) time.Time {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if _, err := s.Tick(ctx, last, now); err != nil {
+		s.log.Warn("Workspace schedule tick failed", "from", last, "error", err)
+		return last
+	}
+	return now
+}
+
+func (s *workspaceScheduleService) RunWorkspaceSchedule(ctx context.Context, payload *queue.WorkspaceSchedulePayload, attempt int) error {
+	workspaceID, err := uuid.Parse(payload.WorkspaceID)
+	if err != nil {
+		return queue.ErrTaskNoop
+	}
+	if s.executor == nil {
+		return errors.New("schedule executor not configured")
+	}
+	workspace, err := s.workspaceRepo.GetByID(ctx, workspaceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return queue.ErrTaskNoop
+		}
+		return err
+	}
+	if isWorkspaceSuspended(workspace) {
+		return queue.ErrTaskNoop
+	}
+	schedule, err := s.repo.GetByCron(ctx, workspaceID, payload.Schedule)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// 入队后代码已重新部署并删除了该定时任务
+			return queue.ErrTaskNoop
+		}
+		return err
+	}
+	if !schedule.Enabled && payload.Trigger != entity.ScheduleTriggerManual {
+		return queue.ErrTaskNoop
+	}
+
+	now := time.Now()
+	run := &entity.WorkspaceScheduleRun{
+		ID:          uuid.New(),
+		WorkspaceID: workspaceID,
+		ScheduleID:  schedule.ID,
+		Cron:        schedule.Cron,
+		Trigger:     payload.Trigger,
+		Status:      entity.ScheduleRunStatusRunning,
+		Attempt:     attempt,
+		ScheduledAt: payload.ScheduledAt,
+		StartedAt:   now,
+	}
+	if payload.TriggeredBy != nil {
+		if userID, err := uuid.Parse(*payload.TriggeredBy); err == nil {
+			run.TriggeredBy = &userID
+		}
+	}
+
+	acquired, err := s.repo.TryAcquire(ctx, schedule.ID, run.ID, now, now.Add(-scheduleRunStaleAfter))
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		run.Status = entity.ScheduleRunStatusSkipped
+		run.Error = "previous run is still in progress"
+		run.FinishedAt = &now
+		if err := s.repo.CreateRun(ctx, run); err != nil {
+			s.log.Warn("Failed to record skipped schedule run", "workspace_id", workspaceID, "cron", schedule.Cron, "error", err)
+		}
+		return nil
+	}
+
+	// 任务超时会取消 ctx，执行结果与执行中标记仍需落库
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+	if err := s.repo.CreateRun(ctx, run); err != nil {
+		_ = s.repo.Release(saveCtx, schedule.ID, run.ID, now, entity.ScheduleRunStatusFailed)
+		return err
+	}
+
+	execErr := s.executor(ctx, workspace, ScheduleExecution{
+		RunID:       run.ID,
+		Cron:        schedule.Cron,
+		Trigger:     payload.Trigger,
+		ScheduledAt: payload.ScheduledAt,
+		Attempt:     attempt,
+	})
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.DurationMs = finished.Sub(now).Milliseconds()
+	run.Status = entity.ScheduleRunStatusSucceeded
+	if execErr != nil {
+		run.Status = entity.ScheduleRunStatusFailed
+		run.Error = truncateUTF8(execErr.Error(), maxScheduleRunError)
+	}
+	if err := s.repo.UpdateRun(saveCtx, run); err != nil {
+		s.log.Warn("Failed to update schedule run", "run_id", run.ID, "error", err)
+	}
+	if err := s.repo.Release(saveCtx, schedule.ID, run.ID, finished, run.Status); err != nil {
+		s.log.Warn("Failed to release schedule", "schedule_id", schedule.ID, "error", err)
+	}
+	if err := s.repo.PruneRuns(saveCtx, schedule.ID, maxScheduleRunsKept); err != nil {
+		s.log.Warn("Failed to prune schedule runs", "schedule_id", schedule.ID, "error", err)
+	}
+
+	if execErr != nil {
+		return fmt.Errorf("schedule %q failed: %w", schedule.Cron, execErr)
+	}
+	return nil
+}
+
+func (s *workspaceScheduleService) getSchedule(ctx context.Context, workspaceID, scheduleID uuid.UUID) (*entity.WorkspaceSchedule, error) {
+	schedule, err := s.repo.GetByID(ctx, scheduleID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrScheduleNotFound
+		}
+		return nil, err
+	}
+	if schedule.WorkspaceID != workspaceID {
+		return nil, ErrScheduleNotFound
+	}
+	return schedule, nil
+}
+
+// scheduleTimezone 读取工作空间定时任务时区，未设置或无效时为 UTC
+func scheduleTimezone(settings entity.JSON) string {
+	if name, ok := settings[scheduleTimezoneSettingsKey].(string); ok && name != "" && name != "Local" {
+		if _, err := time.LoadLocation(name); err == nil {
+			return name
+		}
+	}
+	return "UTC"
+}
+
+// parseScheduleSpec 按工作空间时区解析 cron 表达式（格式已在加载 JS 代码时校验）
+func parseScheduleSpec(spec string, loc *time.Location) (cron.Schedule, error) {
+	parsed, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	if specSchedule, ok := parsed.(*cron.SpecSchedule); ok && loc != nil {
+		specSchedule.Location = loc
+	}
+	return parsed, nil
+}
+
+func isWorkspaceSuspended(workspace *entity.Workspace) bool {
+	return strings.EqualFold(strings.TrimSpace(workspace.Status), "suspended")
+}
@@ -0,0 +1,410 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/logger"
+	"github.com/reverseai/server/internal/pkg/queue"
+	"github.com/reverseai/server/internal/repository"
+	"gorm.io/gorm"
+)
+
+// memoryScheduleRepo 内存版 WorkspaceScheduleRepository
+type memoryScheduleRepo struct {
+	mu        sync.Mutex
+	schedules map[uuid.UUID]*entity.WorkspaceSchedule
+	runs      []*entity.WorkspaceScheduleRun
+}
+
+func newMemoryScheduleRepo() *memoryScheduleRepo {
+	return &memoryScheduleRepo{schedules: map[uuid.UUID]*entity.WorkspaceSchedule{}}
+}
+
+func (r *memoryScheduleRepo) Create(_ context.Context, schedule *entity.WorkspaceSchedule) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if schedule.ID == uuid.Nil {
+		schedule.ID = uuid.New()
+	}
+	copied := *schedule
+	r.schedules[schedule.ID] = &copied
+	return nil
+}
+
+func (r *memoryScheduleRepo) GetByID(_ context.Context, id uuid.UUID) (*entity.WorkspaceSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if s, ok := r.schedules[id]; ok {
+		copied := *s
+		return &copied, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryScheduleRepo) GetByCron(_ context.Context, workspaceID uuid.UUID, cron string) (*entity.WorkspaceSchedule, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.schedules {
+		if s.WorkspaceID == workspaceID && s.Cron == cron {
+			copied := *s
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memoryScheduleRepo) list(match func(*entity.WorkspaceSchedule) bool) []entity.WorkspaceSchedule {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var list []entity.WorkspaceSchedule
+	for _, s := range r.schedules {
+		if match(s) {
+			list = append(list, *s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Cron < list[j].Cron })
+	return list
+}
+
+func (r *memoryScheduleRepo) ListByWorkspace(_ context.Context, workspaceID uuid.UUID) ([]entity.WorkspaceSchedule, error) {
+	return r.list(func(s *entity.WorkspaceSchedule) bool { return s.WorkspaceID == workspaceID }), nil
+}
+
+func (r *memoryScheduleRepo) ListEnabled(_ context.Context) ([]entity.WorkspaceSchedule, error) {
+	return r.list(func(s *entity.WorkspaceSchedule) bool { return s.Enabled }), nil
+}
+
+func (r *memoryScheduleRepo) SetEnabled(_ context.Context, id uuid.UUID, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedules[id].Enabled = enabled
+	return nil
+}
+
+func (r *memoryScheduleRepo) Delete(_ context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.schedules, id)
+	return nil
+}
+
+func (r *memoryScheduleRepo) TryAcquire(_ context.Context, id, runID uuid.UUID, now, staleBefore time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.schedules[id]
+	if s.RunningRunID != nil && !s.RunningSince.Before(staleBefore) {
+		return false, nil
+	}
+	s.RunningRunID, s.RunningSince = &runID, &now
+	return true, nil
+}
+
+func (r *memoryScheduleRepo) Release(_ context.Context, id, runID uuid.UUID, finishedAt time.Time, status string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.schedules[id]
+	if s != nil && s.RunningRunID != nil && *s.RunningRunID == runID {
+		s.RunningRunID, s.RunningSince = nil, nil
+		s.LastRunAt, s.LastStatus = &finishedAt, status
+	}
+	return nil
+}
+
+func (r *memoryScheduleRepo) CreateRun(_ context.Context, run *entity.WorkspaceScheduleRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	copied := *run
+	r.runs = append(r.runs, &copied)
+	return nil
+}
+
+func (r *memoryScheduleRepo) UpdateRun(_ context.Context, run *entity.WorkspaceScheduleRun) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, existing := range r.runs {
+		if existing.ID == run.ID {
+			copied := *run
+			r.runs[i] = &copied
+		}
+	}
+	return nil
+}
+
+func (r *memoryScheduleRepo) ListRuns(_ context.Context, workspaceID uuid.UUID, scheduleID *uuid.UUID, limit int) ([]entity.WorkspaceScheduleRun, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var runs []entity.WorkspaceScheduleRun
+	for i := len(r.runs) - 1; i >= 0 && len(runs) < limit; i-- {
+		run := r.runs[i]
+		if run.WorkspaceID == workspaceID && (scheduleID == nil || run.ScheduleID == *scheduleID) {
+			runs = append(runs, *run)
+		}
+	}
+	return runs, nil
+}
+
+func (r *memoryScheduleRepo) PruneRuns(context.Context, uuid.UUID, int) error {
+	return nil
+}
+
+// stubWorkspaceRepo 只实现 GetByID
+type stubWorkspaceRepo struct {
+	repository.WorkspaceRepository
+	workspaces map[uuid.UUID]*entity.Workspace
+}
+
+func (r *stubWorkspaceRepo) GetByID(_ context.Context, id uuid.UUID) (*entity.Workspace, error) {
+	if ws, ok := r.workspaces[id]; ok {
+		return ws, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// memoryScheduleQueue 记录入队的定时任务，按任务 ID 去重；err 不为空时入队失败
+type memoryScheduleQueue struct {
+	payloads []queue.WorkspaceSchedulePayload
+	taskIDs  map[string]bool
+	err      error
+}
+
+func (q *memoryScheduleQueue) EnqueueWorkspaceSchedule(_ context.Context, payload *queue.WorkspaceSchedulePayload, taskID string) (*queue.EnqueueResult, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	if taskID != "" && q.taskIDs[taskID] {
+		return &queue.EnqueueResult{TaskID: taskID, Queue: queue.QueueScheduled, Deduped: true}, nil
+	}
+	if q.taskIDs == nil {
+		q.taskIDs = map[string]bool{}
+	}
+	q.taskIDs[taskID] = true
+	q.payloads = append(q.payloads, *payload)
+	return &queue.EnqueueResult{TaskID: taskID, Queue: queue.QueueScheduled}, nil
+}
+
+type scheduleServiceFixture struct {
+	svc        WorkspaceScheduleService
+	repo       *memoryScheduleRepo
+	queue      *memoryScheduleQueue
+	workspace  *entity.Workspace
+	owner      uuid.UUID
+	viewer     uuid.UUID
+	workspaces *stubWorkspaceRepo
+}
+
+func newScheduleServiceFixture(t *testing.T, timezone string) *scheduleServiceFixture {
+	t.Helper()
+	log, err := logger.New(false)
+	if err != nil {
+		t.Fatalf("logger: %v", err)
+	}
+	owner, viewer := uuid.New(), uuid.New()
+	workspace := &entity.Workspace{ID: uuid.New(), OwnerUserID: owner, Status: "active", Settings: entity.JSON{}}
+	if timezone != "" {
+		workspace.Settings[scheduleTimezoneSettingsKey] = timezone
+	}
+	workspaces := &stubWorkspaceRepo{workspaces: map[uuid.UUID]*entity.Workspace{workspace.ID: workspace}}
+	access := &stubAccessWorkspaceService{access: map[uuid.UUID]*WorkspaceAccess{
+		owner:  {Workspace: workspace, IsOwner: true},
+		viewer: {Workspace: workspace, Permissions: entity.JSON{PermissionLogsView: true}},
+	}}
+	repo := newMemoryScheduleRepo()
+	q := &memoryScheduleQueue{}
+	svc := NewWorkspaceScheduleService(repo, workspaces, access, log)
+	svc.SetEnqueuer(q)
+	return &scheduleServiceFixture{svc: svc, repo: repo, queue: q, workspace: workspace, owner: owner, viewer: viewer, workspaces: workspaces}
+}
+
+func TestWorkspaceScheduleService_SyncAndTick(t *testing.T) {
+	if _, err := time.LoadLocation("Asia/Shanghai"); err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	f := newScheduleServiceFixture(t, "Asia/Shanghai")
+	ctx := context.Background()
+
+	if err := f.svc.Sync(ctx, f.workspace.ID, []string{"0 2 * * *", "0 3 * * *"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	// 上海时间 02:00 即前一天 UTC 18:00
+	from := time.Date(2026, 1, 1, 17, 59, 30, 0, time.UTC)
+	to := from.Add(time.Minute)
+	n, err := f.svc.Tick(ctx, from, to)
+	if err != nil || n != 1 {
+		t.Fatalf("Tick = %d, %v; want 1 enqueued", n, err)
+	}
+	payload := f.queue.payloads[0]
+	if payload.Schedule != "0 2 * * *" || payload.Trigger != entity.ScheduleTriggerCron ||
+		!payload.ScheduledAt.Equal(time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC)) {
+		t.Fatalf("payload = %+v", payload)
+	}
+
+	// 其他实例调度同一时间段时按任务 ID 去重
+	if n, _ := f.svc.Tick(ctx, from, to); n != 0 {
+		t.Fatalf("second Tick enqueued %d, want 0", n)
+	}
+
+	// 暂停的定时任务与停用的工作空间不入队
+	schedule, _ := f.repo.GetByCron(ctx, f.workspace.ID, "0 2 * * *")
+	if _, err := f.svc.SetEnabled(ctx, f.workspace.ID, f.owner, schedule.ID, false); err != nil {
+		t.Fatalf("SetEnabled: %v", err)
+	}
+	next := from.Add(24 * time.Hour)
+	if n, _ := f.svc.Tick(ctx, next, next.Add(time.Minute)); n != 0 {
+		t.Fatalf("disabled schedule enqueued %d", n)
+	}
+	f.workspace.Status = "suspended"
+	later := from.Add(time.Hour)
+	if n, _ := f.svc.Tick(ctx, later, later.Add(time.Minute)); n != 0 {
+		t.Fatalf("suspended workspace enqueued %d", n)
+	}
+	f.workspace.Status = "active"
+
+	// 重新部署时代码中删除的定时任务被移除
+	if err := f.svc.Sync(ctx, f.workspace.ID, []string{"0 3 * * *"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	list, err := f.svc.List(ctx, f.workspace.ID, f.owner)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if list.Timezone != "Asia/Shanghai" || len(list.Schedules) != 1 || list.Schedules[0].Cron != "0 3 * * *" || list.Schedules[0].NextRunAt == nil {
+		t.Fatalf("list = %+v", list)
+	}
+}
+
+func TestWorkspaceScheduleService_FailedTickIsRetried(t *testing.T) {
+	f := newScheduleServiceFixture(t, "")
+	ctx := context.Background()
+	svc := f.svc.(*workspaceScheduleService)
+
+	if err := f.svc.Sync(ctx, f.workspace.ID, []string{"0 2 * * *"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	last := time.Date(2026, 1, 1, 1, 59, 30, 0, time.UTC)
+	now := last.Add(time.Minute)
+
+	// 入队失败时不推进起点
+	f.queue.err = errors.New("redis unavailable")
+	if _, err := f.svc.Tick(ctx, last, now); err == nil {
+		t.Fatal("Tick succeeded while the queue is down")
+	}
+	if got := svc.tickSince(last, now); !got.Equal(last) {
+		t.Fatalf("tickSince = %v after a failure, want %v", got, last)
+	}
+
+	// 下一分钟重试时仍覆盖失败的时间段
+	f.queue.err = nil
+	later := now.Add(time.Minute)
+	if got := svc.tickSince(last, later); !got.Equal(later) {
+		t.Fatalf("tickSince = %v, want %v", got, later)
+	}
+	if len(f.queue.payloads) != 1 || !f.queue.payloads[0].ScheduledAt.Equal(time.Date(2026, 1, 1, 2, 0, 0, 0, time.UTC)) {
+		t.Fatalf("payloads = %+v, want the 02:00 run enqueued on retry", f.queue.payloads)
+	}
+}
+
+func TestWorkspaceScheduleService_RunOverlapAndRetry(t *testing.T) {
+	f := newScheduleServiceFixture(t, "")
+	ctx := context.Background()
+	if err := f.svc.Sync(ctx, f.workspace.ID, []string{"*/5 * * * *"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	started, release := make(chan struct{}), make(chan struct{})
+	var fail bool
+	f.svc.SetExecutor(func(ctx context.Context, workspace *entity.Workspace, exec ScheduleExecution) error {
+		if exec.Trigger == entity.ScheduleTriggerManual {
+			close(started)
+			<-release
+		}
+		if fail {
+			return errors.New("boom")
+		}
+		return nil
+	})
+	payload := func(trigger string) *queue.WorkspaceSchedulePayload {
+		return &queue.WorkspaceSchedulePayload{WorkspaceID: f.workspace.ID.String(), Schedule: "*/5 * * * *", Trigger: trigger, ScheduledAt: time.Now()}
+	}
+
+	done := make(chan error)
+	go func() { done <- f.svc.RunWorkspaceSchedule(ctx, payload(entity.ScheduleTriggerManual), 1) }()
+	<-started
+	if err := f.svc.RunWorkspaceSchedule(ctx, payload(entity.ScheduleTriggerCron), 1); err != nil {
+		t.Fatalf("overlapping run: %v", err)
+	}
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatalf("manual run: %v", err)
+	}
+
+	fail = true
+	err := f.svc.RunWorkspaceSchedule(ctx, payload(entity.ScheduleTriggerCron), 2)
+	if err == nil {
+		t.Fatal("failing run should return an error so the queue retries it")
+	}
+
+	runs, _ := f.svc.ListRuns(ctx, f.workspace.ID, f.owner, nil, 0)
+	if len(runs) != 3 {
+		t.Fatalf("runs = %d, want 3", len(runs))
+	}
+	// 按时间倒序：失败的重试、重叠跳过、成功的手动执行
+	if runs[0].Status != entity.ScheduleRunStatusFailed || runs[0].Attempt != 2 || runs[0].Error != "boom" {
+		t.Fatalf("failed run = %+v", runs[0])
+	}
+	if runs[1].Status != entity.ScheduleRunStatusSkipped || runs[1].Trigger != entity.ScheduleTriggerCron {
+		t.Fatalf("overlapping run = %+v", runs[1])
+	}
+	if runs[2].Status != entity.ScheduleRunStatusSucceeded || runs[2].Trigger != entity.ScheduleTriggerManual {
+		t.Fatalf("manual run = %+v", runs[2])
+	}
+	schedule, _ := f.repo.GetByCron(ctx, f.workspace.ID, "*/5 * * * *")
+	if schedule.RunningRunID != nil || schedule.LastStatus != entity.ScheduleRunStatusFailed {
+		t.Fatalf("schedule = %+v", schedule)
+	}
+
+	// 入队后定时任务已被删除时视为无需处理
+	if err := f.svc.RunWorkspaceSchedule(ctx, &queue.WorkspaceSchedulePayload{WorkspaceID: f.workspace.ID.String(), Schedule: "0 0 * * *", Trigger: entity.ScheduleTriggerCron}, 1); !errors.Is(err, queue.ErrTaskNoop) {
+		t.Fatalf("removed schedule: %v", err)
+	}
+}
+
+func TestWorkspaceScheduleService_RunNowAndTimezone(t *testing.T) {
+	f := newScheduleServiceFixture(t, "")
+	ctx := context.Background()
+	if err := f.svc.Sync(ctx, f.workspace.ID, []string{"@daily"}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	schedule, _ := f.repo.GetByCron(ctx, f.workspace.ID, "@daily")
+
+	if _, err := f.svc.RunNow(ctx, f.workspace.ID, f.viewer, schedule.ID); !errors.Is(err, ErrWorkspaceUnauthorized) {
+		t.Fatalf("viewer RunNow: %v", err)
+	}
+	if _, err := f.svc.RunNow(ctx, f.workspace.ID, f.owner, uuid.New()); !errors.Is(err, ErrScheduleNotFound) {
+		t.Fatalf("unknown schedule: %v", err)
+	}
+	if _, err := f.svc.RunNow(ctx, f.workspace.ID, f.owner, schedule.ID); err != nil {
+		t.Fatalf("RunNow: %v", err)
+	}
+	payload := f.queue.payloads[0]
+	if payload.Trigger != entity.ScheduleTriggerManual || payload.TriggeredBy == nil || *payload.TriggeredBy != f.owner.String() {
+		t.Fatalf("payload = %+v", payload)
+	}
+
+	// 只有日志查看权限的成员仍可查看执行记录
+	if _, err := f.svc.ListRuns(ctx, f.workspace.ID, f.viewer, &schedule.ID, 10); err != nil {
+		t.Fatalf("viewer ListRuns: %v", err)
+	}
+
+	for _, tz := range []string{"", "Local", "Mars/Olympus"} {
+		if _, err := f.svc.UpdateTimezone(ctx, f.workspace.ID, f.owner, tz); !errors.Is(err, ErrInvalidTimezone) {
+			t.Errorf("UpdateTimezone(%q) = %v", tz, err)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"github.com/reverseai/server/internal/pkg/crypto"
+	"github.com/reverseai/server/internal/pkg/security"
+	"github.com/reverseai/server/internal/repository"
+	"gorm.io/gorm"
+)
+
+const (
+	// maxWorkspaceSecrets 单个工作空间的密钥数量上限
+	maxWorkspaceSecrets = 100
+	// maxSecretValueBytes 单个密钥明文的长度上限
+	maxSecretValueBytes = 8 << 10
+	// secretCacheTTL 解密结果的本地缓存时间，变更时通过 InvalidationBus 主动清理
+	secretCacheTTL = time.Minute
+)
+
+var (
+	ErrSecretNotFound     = errors.New("secret not found")
+	ErrSecretExists       = errors.New("secret already exists")
+	ErrSecretLimit        = fmt.Errorf("workspace secrets limit reached (max %d)", maxWorkspaceSecrets)
+	ErrInvalidSecretName  = errors.New("invalid secret name, must match ^[A-Z][A-Z0-9_]{0,63}$")
+	ErrInvalidSecretValue = fmt.Errorf("invalid secret value, must be non-empty and at most %d bytes", maxSecretValueBytes)
+)
+
+var secretNamePattern = regexp.MustCompile(`^[A-Z][A-Z0-9_]{0,63}$`)
+
+// WorkspaceSecretService 工作空间密钥服务接口
+// 管理接口只返回元数据；明文仅供运行时（JS 逻辑 secrets.get / fetch）在服务端解析
+type WorkspaceSecretService interface {
+	List(ctx context.Context, workspaceID, userID uuid.UUID) ([]entity.Secret, error)
+	Create(ctx context.Context, workspaceID, userID uuid.UUID, name, value, description string) (*entity.Secret, error)
+	Rotate(ctx context.Context, workspaceID, userID uuid.UUID, name, value string, description *string) (*entity.Secret, error)
+	Delete(ctx context.Context, workspaceID, userID uuid.UUID, name string) error
+	// Resolve 解析密钥明文，ok=false 表示密钥不存在
+	Resolve(ctx context.Context, workspaceID uuid.UUID, name string) (value string, ok bool, err error)
+	// Redactor 返回工作空间的密钥脱敏器，读取失败时返回空脱敏器
+	Redactor(ctx context.Context, workspaceID uuid.UUID) *security.SecretRedactor
+	// RedactToolResult 脱敏 Agent 工具输出中的密钥明文
+	RedactToolResult(ctx context.Context, workspaceID string, result *AgentToolResult) *AgentToolResult
+	// InvalidateCache 处理缓存失效事件（订阅 InvalidationBus）
+	InvalidateCache(event InvalidationEvent)
+	SetInvalidationBus(bus InvalidationBus)
+}
+
+// workspaceSecretSet 一个工作空间全部密钥的解密结果
+type workspaceSecretSet struct {
+	values   map[string]string
+	redactor *security.SecretRedactor
+}
+
+type workspaceSecretService struct {
+	repo             repository.SecretRepository
+	workspaceService WorkspaceService
+	encryptor        *crypto.Encryptor
+	invalidation     InvalidationBus
+	cache            *ttlCache[*workspaceSecretSet]
+}
+
+// NewWorkspaceSecretService 创建工作空间密钥服务
+func NewWorkspaceSecretService(repo repository.SecretRepository, workspaceService WorkspaceService, encryptionKey string) (WorkspaceSecretService, error) {
+	encryptor, err := crypto.NewEncryptor(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return &workspaceSecretService{
+		repo:             repo,
+		workspaceService: workspaceService,
+		encryptor:        encryptor,
+		cache:            newTTLCache[*workspaceSecretSet](secretCacheTTL),
+	}, nil
+}
+
+// SetInvalidationBus 设置缓存失效总线；未设置时其他实例最迟在缓存 TTL 到期后读到新值
+func (s *workspaceSecretService) SetInvalidationBus(bus InvalidationBus) {
+	s.invalidation = bus
+}
+
+func (s *workspaceSecretService) InvalidateCache(event InvalidationEvent) {
+	if event.Kind == InvalidationSecrets || event.Kind == InvalidationWorkspace {
+		s.cache.Delete(event.WorkspaceID)
+	}
+}
+
+// invalidate 清理本实例缓存并广播密钥变更
+func (s *workspaceSecretService) invalidate(ctx context.Context, workspaceID uuid.UUID) {
+	s.cache.Delete(workspaceID.String())
+	if s.invalidation != nil {
+		_ = s.invalidation.Publish(ctx, InvalidationEvent{Kind: InvalidationSecrets, WorkspaceID: workspaceID.String()})
+	}
+}
+
+// authorize 校验用户拥有 permissions 中的任一权限
+func (s *workspaceSecretService) authorize(ctx context.Context, workspaceID, userID uuid.UUID, permissions ...string) error {
+	access, err := s.workspaceService.GetWorkspaceAccess(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if access.IsOwner {
+		return nil
+	}
+	for _, permission := range permissions {
+		if hasPermission(access.Permissions, permission) {
+			return nil
+		}
+	}
+	return ErrWorkspaceUnauthorized
+}
+
+// List 列出密钥元数据；编写逻辑的成员需要知道可用的密钥名称，因此编辑权限即可查看
+func (s *workspaceSecretService) List(ctx context.Context, workspaceID, userID uuid.UUID) ([]entity.Secret, error) {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionSecretsManage, PermissionWorkspaceEdit); err != nil {
+		return nil, err
+	}
+	return s.repo.ListByWorkspace(ctx, workspaceID)
+}
+
+func (s *workspaceSecretService) Create(ctx context.Context, workspaceID, userID uuid.UUID, name, value, description string) (*entity.Secret, error) {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionSecretsManage); err != nil {
+		return nil, err
+	}
+	name = strings.TrimSpace(name)
+	if !secretNamePattern.MatchString(name) {
+		return nil, ErrInvalidSecretName
+	}
+	if err := validateSecretValue(value); err != nil {
+		return nil, err
+	}
+	if _, err := s.repo.GetByName(ctx, workspaceID, name); err == nil {
+		return nil, ErrSecretExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	count, err := s.repo.CountByWorkspace(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	if count >= maxWorkspaceSecrets {
+		return nil, ErrSecretLimit
+	}
+
+	encrypted, err := s.encryptor.Encrypt(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	secret := &entity.Secret{
+		WorkspaceID:    workspaceID,
+		Name:           name,
+		Description:    strings.TrimSpace(description),
+		ValueEncrypted: encrypted,
+		Version:        1,
+		CreatedBy:      &userID,
+	}
+	if err := s.repo.Create(ctx, secret); err != nil {
+		return nil, fmt.Errorf("failed to create secret: %w", err)
+	}
+	s.invalidate(ctx, workspaceID)
+	return secret, nil
+}
+
+func (s *workspaceSecretService) Rotate(ctx context.Context, workspaceID, userID uuid.UUID, name, value string, description *string) (*entity.Secret, error) {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionSecretsManage); err != nil {
+		return nil, err
+	}
+	if err := validateSecretValue(value); err != nil {
+		return nil, err
+	}
+	secret, err := s.getByName(ctx, workspaceID, name)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := s.encryptor.Encrypt(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	now := time.Now()
+	secret.ValueEncrypted = encrypted
+	secret.Version++
+	secret.RotatedAt = &now
+	if description != nil {
+		secret.Description = strings.TrimSpace(*description)
+	}
+	if err := s.repo.Update(ctx, secret); err != nil {
+		return nil, fmt.Errorf("failed to rotate secret: %w", err)
+	}
+	s.invalidate(ctx, workspaceID)
+	return secret, nil
+}
+
+func (s *workspaceSecretService) Delete(ctx context.Context, workspaceID, userID uuid.UUID, name string) error {
+	if err := s.authorize(ctx, workspaceID, userID, PermissionSecretsManage); err != nil {
+		return err
+	}
+	secret, err := s.getByName(ctx, workspaceID, name)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.Delete(ctx, secret.ID); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	s.invalidate(ctx, workspaceID)
+	return nil
+}
+
+func (s *workspaceSecretService) Resolve(ctx context.Context, workspaceID uuid.UUID, name string) (string, bool, error) {
+	set, err := s.load(ctx, workspaceID)
+	if err != nil {
+		return "", false, err
+	}
+	value, ok := set.values[name]
+	return value, ok, nil
+}
+
+func (s *workspaceSecretService) Redactor(ctx context.Context, workspaceID uuid.UUID) *security.SecretRedactor {
+	set, err := s.load(ctx, workspaceID)
+	if err != nil {
+		return nil
+	}
+	return set.redactor
+}
+
+func (s *workspaceSecretService) RedactToolResult(ctx context.Context, workspaceID string, result *AgentToolResult) *AgentToolResult {
+	if result == nil {
+		return nil
+	}
+	wsID, err := uuid.Parse(workspaceID)
+	if err != nil {
+		return result
+	}
+	redactor := s.Redactor(ctx, wsID)
+	if redactor.Empty() {
+		return result
+	}
+	redacted := *result
+	redacted.Output = redactor.Redact(result.Output)
+	redacted.Error = redactor.Redact(result.Error)
+	if result.Data != nil {
+		// Data 可能是任意结构体，先转成通用 JSON 结构再脱敏
+		if raw, err := json.Marshal(result.Data); err == nil {
+			var generic interface{}
+			if json.Unmarshal(raw, &generic) == nil {
+				redacted.Data = redactor.RedactValue(generic)
+			}
+		}
+	}
+	return &redacted
+}
+
+// load 读取并解密工作空间的全部密钥（带本地缓存）
+func (s *workspaceSecretService) load(ctx context.Context, workspaceID uuid.UUID) (*workspaceSecretSet, error) {
+	key := workspaceID.String()
+	if set, ok := s.cache.Get(key); ok {
+		return set, nil
+	}
+	secrets, err := s.repo.ListByWorkspace(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(secrets))
+	for _, secret := range secrets {
+		value, err := s.encryptor.Decrypt(secret.ValueEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt secret %s: %w", secret.Name, err)
+		}
+		values[secret.Name] = value
+	}
+	set := &workspaceSecretSet{values: values, redactor: security.NewSecretRedactor(values)}
+	s.cache.Set(key, set)
+	return set, nil
+}
+
+func (s *workspaceSecretService) getByName(ctx context.Context, workspaceID uuid.UUID, name string) (*entity.Secret, error) {
+	secret, err := s.repo.GetByName(ctx, workspaceID, strings.TrimSpace(name))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSecretNotFound
+		}
+		return nil, err
+	}
+	return secret, nil
+}
+
+func validateSecretValue(value string) error {
+	if value == "" || len(value) > maxSecretValueBytes {
+		return ErrInvalidSecretValue
+	}
+	return nil
+}
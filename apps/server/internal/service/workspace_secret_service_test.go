@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/reverseai/server/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// memorySecretRepo 内存版 SecretRepository
+type memorySecretRepo struct {
+	secrets map[uuid.UUID]*entity.Secret
+}
+
+func (r *memorySecretRepo) Create(_ context.Context, secret *entity.Secret) error {
+	if secret.ID == uuid.Nil {
+		secret.ID = uuid.New()
+	}
+	copied := *secret
+	r.secrets[secret.ID] = &copied
+	return nil
+}
+
+func (r *memorySecretRepo) GetByName(_ context.Context, workspaceID uuid.UUID, name string) (*entity.Secret, error) {
+	for _, s := range r.secrets {
+		if s.WorkspaceID == workspaceID && s.Name == name {
+			copied := *s
+			return &copied, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+func (r *memorySecretRepo) ListByWorkspace(_ context.Context, workspaceID uuid.UUID) ([]entity.Secret, error) {
+	var list []entity.Secret
+	for _, s := range r.secrets {
+		if s.WorkspaceID == workspaceID {
+			list = append(list, *s)
+		}
+	}
+	return list, nil
+}
+
+func (r *memorySecretRepo) CountByWorkspace(ctx context.Context, workspaceID uuid.UUID) (int64, error) {
+	list, _ := r.ListByWorkspace(ctx, workspaceID)
+	return int64(len(list)), nil
+}
+
+func (r *memorySecretRepo) Update(_ context.Context, secret *entity.Secret) error {
+	copied := *secret
+	r.secrets[secret.ID] = &copied
+	return nil
+}
+
+func (r *memorySecretRepo) Delete(_ context.Context, id uuid.UUID) error {
+	delete(r.secrets, id)
+	return nil
+}
+
+// stubAccessWorkspaceService 只实现 GetWorkspaceAccess，按用户返回权限
+type stubAccessWorkspaceService struct {
+	WorkspaceService
+	access map[uuid.UUID]*WorkspaceAccess
+}
+
+func (s *stubAccessWorkspaceService) GetWorkspaceAccess(_ context.Context, _ uuid.UUID, userID uuid.UUID) (*WorkspaceAccess, error) {
+	if access, ok := s.access[userID]; ok {
+		return access, nil
+	}
+	return nil, ErrWorkspaceUnauthorized
+}
+
+func newTestSecretService(t *testing.T) (WorkspaceSecretService, *memorySecretRepo, uuid.UUID, uuid.UUID) {
+	t.Helper()
+	owner, member := uuid.New(), uuid.New()
+	repo := &memorySecretRepo{secrets: map[uuid.UUID]*entity.Secret{}}
+	svc, err := NewWorkspaceSecretService(repo, &stubAccessWorkspaceService{access: map[uuid.UUID]*WorkspaceAccess{
+		owner:  {IsOwner: true},
+		member: {Permissions: defaultWorkspaceRolePermissions["member"]},
+	}}, "0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatalf("NewWorkspaceSecretService: %v", err)
+	}
+	return svc, repo, owner, member
+}
+
+func TestWorkspaceSecretService_Lifecycle(t *testing.T) {
+	svc, repo, owner, member := newTestSecretService(t)
+	ctx := context.Background()
+	wsID := uuid.New()
+
+	secret, err := svc.Create(ctx, wsID, owner, "API_TOKEN", "tok-12345", "payment provider")
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if stored := repo.secrets[secret.ID]; stored.ValueEncrypted == "" || strings.Contains(stored.ValueEncrypted, "tok-12345") {
+		t.Fatalf("value stored as %q, want ciphertext", stored.ValueEncrypted)
+	}
+	if _, err := svc.Create(ctx, wsID, owner, "API_TOKEN", "x", ""); !errors.Is(err, ErrSecretExists) {
+		t.Fatalf("duplicate err = %v", err)
+	}
+	if _, err := svc.Create(ctx, wsID, owner, "api-token", "x", ""); !errors.Is(err, ErrInvalidSecretName) {
+		t.Fatalf("name err = %v", err)
+	}
+	if _, err := svc.Create(ctx, wsID, member, "OTHER", "x", ""); !errors.Is(err, ErrWorkspaceUnauthorized) {
+		t.Fatalf("member create err = %v, want unauthorized", err)
+	}
+	// 编辑权限可以查看名称
+	if list, err := svc.List(ctx, wsID, member); err != nil || len(list) != 1 || list[0].Name != "API_TOKEN" {
+		t.Fatalf("member list = %+v, err = %v", list, err)
+	}
+
+	if value, ok, err := svc.Resolve(ctx, wsID, "API_TOKEN"); err != nil || !ok || value != "tok-12345" {
+		t.Fatalf("Resolve = %q, %v, %v", value, ok, err)
+	}
+	rotated, err := svc.Rotate(ctx, wsID, owner, "API_TOKEN", "tok-67890", nil)
+	if err != nil || rotated.Version != 2 || rotated.RotatedAt == nil {
+		t.Fatalf("Rotate = %+v, %v", rotated, err)
+	}
+	if value, _, _ := svc.Resolve(ctx, wsID, "API_TOKEN"); value != "tok-67890" {
+		t.Fatalf("Resolve after rotate = %q, want the new value", value)
+	}
+
+	if err := svc.Delete(ctx, wsID, owner, "API_TOKEN"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok, _ := svc.Resolve(ctx, wsID, "API_TOKEN"); ok {
+		t.Fatalf("secret still resolvable after delete")
+	}
+	if err := svc.Delete(ctx, wsID, owner, "API_TOKEN"); !errors.Is(err, ErrSecretNotFound) {
+		t.Fatalf("delete missing err = %v", err)
+	}
+}
+
+func TestWorkspaceSecretService_Redaction(t *testing.T) {
+	svc, _, owner, _ := newTestSecretService(t)
+	ctx := context.Background()
+	wsID := uuid.New()
+	if _, err := svc.Create(ctx, wsID, owner, "WEBHOOK_KEY", "whsec-abcdef", ""); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	result := svc.RedactToolResult(ctx, wsID.String(), &AgentToolResult{
+		Success: true,
+		Output:  "rows: whsec-abcdef",
+		Data:    map[string]interface{}{"rows": []interface{}{map[string]interface{}{"key": "whsec-abcdef"}}},
+	})
+	if result.Output != "rows: [REDACTED:WEBHOOK_KEY]" {
+		t.Fatalf("Output = %q", result.Output)
+	}
+	row := result.Data.(map[string]interface{})["rows"].([]interface{})[0].(map[string]interface{})
+	if row["key"] != "[REDACTED:WEBHOOK_KEY]" {
+		t.Fatalf("Data = %+v", result.Data)
+	}
+
+	// 其他实例广播的失效事件清理本地缓存
+	svc.InvalidateCache(InvalidationEvent{Kind: InvalidationSecrets, WorkspaceID: wsID.String()})
+	if got := svc.Redactor(ctx, wsID).Redact("whsec-abcdef"); got != "[REDACTED:WEBHOOK_KEY]" {
+		t.Fatalf("Redact = %q", got)
+	}
+}
@@ -74,6 +74,18 @@ type WorkspaceService interface {
 	ListPublic(ctx context.Context, page, pageSize int) ([]entity.Workspace, int64, error)
 	GetPublic(ctx context.Context, id uuid.UUID) (*entity.Workspace, error)
 	ListRatings(ctx context.Context, workspaceID uuid.UUID, page, pageSize int) ([]entity.WorkspaceRating, int64, error)
+
+	// 数据库 Schema 迁移（发布/回滚时同步）
+	SetSchemaMigrator(migrator WorkspaceSchemaMigrator)
+	// 缓存失效总线（部署/发布/回滚/Slug/访问策略等变更时广播）
+	SetInvalidationBus(bus InvalidationBus)
+}
+
+// WorkspaceSchemaMigrator 工作空间数据库的 Schema 迁移器（由 VMStore 实现）
+type WorkspaceSchemaMigrator interface {
+	SchemaVersion(ctx context.Context, workspaceID string) (int64, error)
+	SchemaSummary(ctx context.Context, workspaceID string) (map[string]interface{}, error)
+	MigrateTo(ctx context.Context, workspaceID string, version int64) error
 }
 
 // ComponentEntry represents a single component in multi-component storage
@@ -92,6 +104,8 @@ type workspaceService struct {
 	memberRepo    repository.WorkspaceMemberRepository
 	eventRecorder EventRecorderService
 	retentionCfg  config.RetentionConfig
+	migrator      WorkspaceSchemaMigrator
+	invalidation  InvalidationBus
 }
 
 // NewWorkspaceService 创建工作空间服务实例
@@ -117,6 +131,32 @@ func NewWorkspaceService(
 	}
 }
 
+// SetSchemaMigrator 设置数据库 Schema 迁移器；未设置时版本不关联数据库 Schema
+func (s *workspaceService) SetSchemaMigrator(migrator WorkspaceSchemaMigrator) {
+	s.migrator = migrator
+}
+
+// SetInvalidationBus 设置缓存失效总线；未设置时运行时缓存只依赖 TTL 过期
+func (s *workspaceService) SetInvalidationBus(bus InvalidationBus) {
+	s.invalidation = bus
+}
+
+// invalidate 广播工作空间缓存失效事件
+// 广播失败不影响本次变更，其他实例最迟在缓存 TTL 到期后生效
+func (s *workspaceService) invalidate(ctx context.Context, kind InvalidationKind, workspace *entity.Workspace, slugs ...string) {
+	if s.invalidation == nil || workspace == nil {
+		return
+	}
+	if workspace.Slug != "" {
+		slugs = append(slugs, workspace.Slug)
+	}
+	_ = s.invalidation.Publish(ctx, InvalidationEvent{
+		Kind:        kind,
+		WorkspaceID: workspace.ID.String(),
+		Slugs:       slugs,
+	})
+}
+
 func (s *workspaceService) EnsureDefaultWorkspace(ctx context.Context, user *entity.User) (*entity.Workspace, error) {
 	if user == nil {
 		return nil, errors.New("user is nil")
@@ -343,6 +383,19 @@ type WorkspaceAccess struct {
 	IsOwner     bool                  `json:"is_owner"`
 }
 
+// Can 是否为 owner 或拥有 permissions 中的任一权限
+func (a *WorkspaceAccess) Can(permissions ...string) bool {
+	if a.IsOwner {
+		return true
+	}
+	for _, permission := range permissions {
+		if hasPermission(a.Permissions, permission) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *workspaceService) GetByID(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (*entity.Workspace, error) {
 	access, err := s.GetWorkspaceAccess(ctx, id, ownerID)
 	if err != nil {
@@ -420,6 +473,9 @@ func (s *workspaceService) Update(ctx context.Context, id uuid.UUID, ownerID uui
 	}
 	if slugChanged {
 		s.ensureSlugAlias(ctx, workspace.ID, oldSlug)
+		s.invalidate(ctx, InvalidationSlug, workspace, oldSlug)
+	} else {
+		s.invalidate(ctx, InvalidationWorkspace, workspace)
 	}
 	return workspace, nil
 }
@@ -431,7 +487,11 @@ func (s *workspaceService) UpdateSettings(ctx context.Context, id uuid.UUID, own
 	}
 	workspace := access.Workspace
 	workspace.Settings = settings
-	return s.workspaceRepo.Update(ctx, workspace)
+	if err := s.workspaceRepo.Update(ctx, workspace); err != nil {
+		return err
+	}
+	s.invalidate(ctx, InvalidationWorkspace, workspace)
+	return nil
 }
 
 func (s *workspaceService) Delete(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (*WorkspaceDeletionResult, error) {
@@ -448,6 +508,7 @@ func (s *workspaceService) Delete(ctx context.Context, id uuid.UUID, ownerID uui
 	if err := s.workspaceRepo.Delete(ctx, workspace.ID); err != nil {
 		return nil, err
 	}
+	s.invalidate(ctx, InvalidationWorkspace, workspace)
 
 	deletedAt := time.Now()
 	restoreUntil := s.restoreDeadline(deletedAt)
@@ -482,6 +543,7 @@ func (s *workspaceService) Restore(ctx context.Context, id uuid.UUID, ownerID uu
 	if err := s.workspaceRepo.Restore(ctx, workspace.ID); err != nil {
 		return nil, err
 	}
+	s.invalidate(ctx, InvalidationWorkspace, workspace)
 
 	return &WorkspaceDeletionResult{
 		WorkspaceID: workspace.ID,
@@ -903,7 +965,11 @@ func (s *workspaceService) Publish(ctx context.Context, id uuid.UUID, ownerID uu
 	if err != nil {
 		return nil, err
 	}
+	if err := s.syncVersionSchema(ctx, ws); err != nil {
+		return nil, err
+	}
 	if ws.AppStatus == "published" {
+		s.invalidate(ctx, InvalidationPublish, ws)
 		return ws, nil
 	}
 	now := time.Now()
@@ -915,6 +981,7 @@ func (s *workspaceService) Publish(ctx context.Context, id uuid.UUID, ownerID uu
 	if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 		return nil, fmt.Errorf("failed to publish workspace: %w", err)
 	}
+	s.invalidate(ctx, InvalidationPublish, ws)
 	return ws, nil
 }
 
@@ -930,13 +997,83 @@ func (s *workspaceService) Rollback(ctx context.Context, id uuid.UUID, ownerID u
 	if version.WorkspaceID != ws.ID {
 		return nil, errors.New("version does not belong to this workspace")
 	}
+
+	// 数据库 Schema 随版本一起回滚
+	var fromSchema int64
+	migrated := false
+	if s.migrator != nil && version.SchemaVersion != nil {
+		fromSchema, err = s.migrator.SchemaVersion(ctx, ws.ID.String())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read database schema version: %w", err)
+		}
+		if fromSchema != *version.SchemaVersion {
+			if err := s.migrator.MigrateTo(ctx, ws.ID.String(), *version.SchemaVersion); err != nil {
+				return nil, fmt.Errorf("failed to migrate database schema: %w", err)
+			}
+			migrated = true
+		}
+	}
+
 	ws.CurrentVersionID = &version.ID
 	if err := s.workspaceRepo.Update(ctx, ws); err != nil {
+		if migrated {
+			// 恢复数据库 Schema，使其与未切换的版本一致（MigrateTo 整体在一个事务内，失败时 Schema 不变）
+			if restoreErr := s.migrator.MigrateTo(ctx, ws.ID.String(), fromSchema); restoreErr != nil {
+				return nil, fmt.Errorf("failed to rollback workspace: %w (restoring database schema %d also failed: %v)", err, fromSchema, restoreErr)
+			}
+		}
 		return nil, fmt.Errorf("failed to rollback workspace: %w", err)
 	}
+	s.invalidate(ctx, InvalidationRollback, ws)
 	return ws, nil
 }
 
+// syncVersionSchema 发布前同步当前版本与数据库 Schema：
+// 版本记录的 Schema 领先于数据库时（例如回滚后重新发布）先迁移数据库，
+// 然后将数据库的实际 Schema 写入当前版本。
+func (s *workspaceService) syncVersionSchema(ctx context.Context, ws *entity.Workspace) error {
+	if s.migrator == nil || ws.CurrentVersionID == nil {
+		return nil
+	}
+	version, err := s.workspaceRepo.GetVersionByID(ctx, *ws.CurrentVersionID)
+	if err != nil {
+		return fmt.Errorf("version not found: %w", err)
+	}
+	workspaceID := ws.ID.String()
+	current, err := s.migrator.SchemaVersion(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to read database schema version: %w", err)
+	}
+	if version.SchemaVersion != nil && *version.SchemaVersion > current {
+		if err := s.migrator.MigrateTo(ctx, workspaceID, *version.SchemaVersion); err != nil {
+			return fmt.Errorf("failed to migrate database schema: %w", err)
+		}
+	}
+	if err := s.stampVersionSchema(ctx, version); err != nil {
+		return err
+	}
+	if err := s.workspaceRepo.UpdateVersion(ctx, version); err != nil {
+		return fmt.Errorf("failed to update version schema: %w", err)
+	}
+	return nil
+}
+
+// stampVersionSchema 将数据库当前的迁移版本和表结构记录到版本上
+func (s *workspaceService) stampVersionSchema(ctx context.Context, version *entity.WorkspaceVersion) error {
+	workspaceID := version.WorkspaceID.String()
+	schemaVersion, err := s.migrator.SchemaVersion(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to read database schema version: %w", err)
+	}
+	summary, err := s.migrator.SchemaSummary(ctx, workspaceID)
+	if err != nil {
+		return fmt.Errorf("failed to read database schema: %w", err)
+	}
+	version.SchemaVersion = &schemaVersion
+	version.DBSchema = entity.JSON(summary)
+	return nil
+}
+
 func (s *workspaceService) Deprecate(ctx context.Context, id uuid.UUID, ownerID uuid.UUID) (*entity.Workspace, error) {
 	ws, err := s.getAuthorizedWorkspace(ctx, id, ownerID)
 	if err != nil {
@@ -946,6 +1083,7 @@ func (s *workspaceService) Deprecate(ctx context.Context, id uuid.UUID, ownerID
 	if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 		return nil, fmt.Errorf("failed to deprecate workspace: %w", err)
 	}
+	s.invalidate(ctx, InvalidationWorkspace, ws)
 	return ws, nil
 }
 
@@ -958,6 +1096,7 @@ func (s *workspaceService) Archive(ctx context.Context, id uuid.UUID, ownerID uu
 	if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 		return nil, fmt.Errorf("failed to archive workspace: %w", err)
 	}
+	s.invalidate(ctx, InvalidationWorkspace, ws)
 	return ws, nil
 }
 
@@ -992,7 +1131,12 @@ func (s *workspaceService) CreateVersion(ctx context.Context, id uuid.UUID, owne
 	} else if prevVersion != nil && prevVersion.UISchema != nil {
 		version.UISchema = prevVersion.UISchema
 	}
-	if req.DBSchema != nil {
+	if s.migrator != nil {
+		// 数据库 Schema 以工作空间数据库的实际状态为准
+		if err := s.stampVersionSchema(ctx, version); err != nil {
+			return nil, err
+		}
+	} else if req.DBSchema != nil {
 		version.DBSchema = entity.JSON(req.DBSchema)
 	} else if prevVersion != nil && prevVersion.DBSchema != nil {
 		version.DBSchema = prevVersion.DBSchema
@@ -1010,6 +1154,7 @@ func (s *workspaceService) CreateVersion(ctx context.Context, id uuid.UUID, owne
 	if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 		return nil, fmt.Errorf("failed to update current version: %w", err)
 	}
+	s.invalidate(ctx, InvalidationVersion, ws)
 	return version, nil
 }
 
@@ -1120,6 +1265,7 @@ func (s *workspaceService) UpdateAccessPolicy(ctx context.Context, id uuid.UUID,
 	if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 		return nil, fmt.Errorf("failed to update access policy: %w", err)
 	}
+	s.invalidate(ctx, InvalidationAccessPolicy, ws)
 	return &AccessPolicyResponse{
 		AccessMode:         ws.AccessMode,
 		DataClassification: ws.DataClassification,
@@ -1151,6 +1297,7 @@ func (s *workspaceService) UpdateUISchema(ctx context.Context, id uuid.UUID, own
 		if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 			return nil, fmt.Errorf("failed to set current version: %w", err)
 		}
+		s.invalidate(ctx, InvalidationUISchema, ws)
 		return initVersion, nil
 	}
 	version, err := s.workspaceRepo.GetVersionByID(ctx, *ws.CurrentVersionID)
@@ -1161,6 +1308,7 @@ func (s *workspaceService) UpdateUISchema(ctx context.Context, id uuid.UUID, own
 	if err := s.workspaceRepo.UpdateVersion(ctx, version); err != nil {
 		return nil, fmt.Errorf("failed to update UI schema: %w", err)
 	}
+	s.invalidate(ctx, InvalidationUISchema, ws)
 	return version, nil
 }
 
@@ -1185,6 +1333,7 @@ func (s *workspaceService) UpdateLogicCode(ctx context.Context, id uuid.UUID, ow
 		if err := s.workspaceRepo.Update(ctx, ws); err != nil {
 			return nil, fmt.Errorf("failed to set current version: %w", err)
 		}
+		s.invalidate(ctx, InvalidationLogic, ws)
 		return initVersion, nil
 	}
 	version, err := s.workspaceRepo.GetVersionByID(ctx, *ws.CurrentVersionID)
@@ -1195,6 +1344,7 @@ func (s *workspaceService) UpdateLogicCode(ctx context.Context, id uuid.UUID, ow
 	if err := s.workspaceRepo.UpdateVersion(ctx, version); err != nil {
 		return nil, fmt.Errorf("failed to update logic code: %w", err)
 	}
+	s.invalidate(ctx, InvalidationLogic, ws)
 	return version, nil
 }
 
@@ -1232,6 +1382,7 @@ func (s *workspaceService) UpdateComponentCode(ctx context.Context, id uuid.UUID
 	if err := s.workspaceRepo.UpdateVersion(ctx, version); err != nil {
 		return nil, fmt.Errorf("failed to update component code: %w", err)
 	}
+	s.invalidate(ctx, InvalidationUISchema, ws)
 	return version, nil
 }
 
@@ -1293,6 +1444,7 @@ func (s *workspaceService) DeployComponent(ctx context.Context, id uuid.UUID, ow
 	if err := s.workspaceRepo.UpdateVersion(ctx, version); err != nil {
 		return nil, "", fmt.Errorf("failed to update components: %w", err)
 	}
+	s.invalidate(ctx, InvalidationUISchema, ws)
 	return version, componentID, nil
 }
 
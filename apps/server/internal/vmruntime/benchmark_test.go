@@ -2,10 +2,13 @@ package vmruntime
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
 	"fmt"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -316,11 +319,11 @@ func TestVMMemoryUsage(t *testing.T) {
 
 func BenchmarkVMPool_CacheHit(b *testing.B) {
 	dir := b.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	defer store.Close()
 
 	loader := &benchCodeLoader{code: `exports.routes = { "GET /x": function() { return 1; } };`}
-	pool := NewVMPool(store, loader, 100)
+	pool := NewVMPool(store, loader, VMPoolOptions{MaxVMs: 100})
 	defer pool.Close()
 
 	ctx := context.Background()
@@ -335,11 +338,11 @@ func BenchmarkVMPool_CacheHit(b *testing.B) {
 
 func BenchmarkVMPool_CacheMiss(b *testing.B) {
 	dir := b.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	defer store.Close()
 
 	loader := &benchCodeLoader{code: `exports.routes = { "GET /x": function() { return 1; } };`}
-	pool := NewVMPool(store, loader, 1000)
+	pool := NewVMPool(store, loader, VMPoolOptions{MaxVMs: 1000})
 	defer pool.Close()
 
 	ctx := context.Background()
@@ -349,6 +352,58 @@ func BenchmarkVMPool_CacheMiss(b *testing.B) {
 	}
 }
 
+// ── Benchmark: VMPool hot path with a 1 MB deployment ────────────────
+//
+// hashingCodeLoader mirrors GORMCodeLoader's per-call cost minus the MySQL
+// round trip: it copies and hashes the whole code on every call. Without a
+// code TTL every GetOrCreate pays that cost; with one, cache hits are served
+// from memory.
+
+func BenchmarkVMPool_CacheHit_LargeCode_NoTTL(b *testing.B) {
+	benchLargeCodePool(b, 0)
+}
+
+func BenchmarkVMPool_CacheHit_LargeCode_CodeTTL(b *testing.B) {
+	benchLargeCodePool(b, time.Minute)
+}
+
+func benchLargeCodePool(b *testing.B, codeTTL time.Duration) {
+	store, cleanup := benchStore(b)
+	defer cleanup()
+
+	code := `exports.routes = { "GET /x": function() { return 1; } }; // ` + strings.Repeat("x", 1<<20)
+	loader := &hashingCodeLoader{code: []byte(code)}
+	pool := NewVMPool(store, loader, VMPoolOptions{
+		MaxVMs:  100,
+		CodeTTL: codeTTL,
+		Limits:  VMLimits{MaxCodeSize: 2 << 20},
+	})
+	defer pool.Close()
+
+	ctx := context.Background()
+	if _, err := pool.GetOrCreate(ctx, "ws-bench-large"); err != nil {
+		b.Fatalf("GetOrCreate failed: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := pool.GetOrCreate(ctx, "ws-bench-large"); err != nil {
+			b.Fatalf("GetOrCreate failed: %v", err)
+		}
+	}
+}
+
+type hashingCodeLoader struct {
+	code []byte
+}
+
+func (l *hashingCodeLoader) GetLogicCode(_ context.Context, _ string) (string, string, error) {
+	code := string(l.code)
+	h := sha256.Sum256([]byte(code))
+	return code, fmt.Sprintf("%x", h[:]), nil
+}
+
 // ── helpers ──────────────────────────────────────────────────────────
 
 func benchDB(b *testing.B) *sql.DB {
@@ -364,7 +419,7 @@ func benchDB(b *testing.B) *sql.DB {
 func benchStore(b *testing.B) (*VMStore, func()) {
 	b.Helper()
 	dir := b.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	return store, func() { store.Close() }
 }
 
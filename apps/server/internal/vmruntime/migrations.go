@@ -0,0 +1,579 @@
+package vmruntime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrMigrationNotFound is returned for an unknown migration version.
+var ErrMigrationNotFound = errors.New("vmstore: migration not found")
+
+// migrationsTable holds a workspace's schema migrations inside its own
+// database, so snapshots restore the schema and its history together.
+const migrationsTable = "_vm_migrations"
+
+// internalTablePrefix marks tables the store manages; they are hidden from
+// table listings and cannot be created through CreateTable.
+const internalTablePrefix = "_vm_"
+
+// Migration statuses recorded in VMMigration.Status.
+const (
+	MigrationStatusApplied    = "applied"
+	MigrationStatusReverted   = "reverted"
+	MigrationStatusSuperseded = "superseded"
+)
+
+// Migration sources recorded in VMMigration.Source.
+const (
+	MigrationSourceDashboard = "dashboard"
+	MigrationSourceAgent     = "agent"
+)
+
+// Migration event kinds and directions passed to the migration listener.
+const (
+	MigrationEventStarted   = "started"
+	MigrationEventCompleted = "completed"
+	MigrationEventFailed    = "failed"
+	// MigrationEventRestored reports that a snapshot restore replaced the
+	// migration history; Migration is the latest applied one, if any.
+	MigrationEventRestored = "restored"
+
+	MigrationUp   = "up"
+	MigrationDown = "down"
+)
+
+// SnapshotReasonMigrate is recorded for the snapshot taken before reverting
+// migrations.
+const SnapshotReasonMigrate = "pre_migrate"
+
+// VMMigration is one recorded schema change. Up holds the statements the
+// change ran; Down the statements that undo its schema (not dropped data).
+type VMMigration struct {
+	Version    int64      `json:"version"`
+	Name       string     `json:"name"`
+	Source     string     `json:"source"`
+	Up         []string   `json:"up"`
+	Down       []string   `json:"down"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AppliedAt  *time.Time `json:"applied_at,omitempty"`
+	RevertedAt *time.Time `json:"reverted_at,omitempty"`
+}
+
+// VMMigrationEvent reports progress of a migration in one direction.
+type VMMigrationEvent struct {
+	Kind        string
+	Direction   string
+	WorkspaceID string
+	Migration   VMMigration
+	Err         error
+}
+
+// VMMigrationListener is notified as migrations start, complete or fail.
+type VMMigrationListener func(event VMMigrationEvent)
+
+type migrationSourceKey struct{}
+
+// WithMigrationSource tags schema changes made with ctx with their origin.
+func WithMigrationSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, migrationSourceKey{}, source)
+}
+
+func migrationSource(ctx context.Context) string {
+	if source, ok := ctx.Value(migrationSourceKey{}).(string); ok && source != "" {
+		return source
+	}
+	return MigrationSourceDashboard
+}
+
+// SetMigrationListener registers a callback for migration events.
+func (s *VMStore) SetMigrationListener(listener VMMigrationListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.migrationListener = listener
+}
+
+func (s *VMStore) notifyMigration(event VMMigrationEvent) {
+	s.mu.RLock()
+	listener := s.migrationListener
+	s.mu.RUnlock()
+	if listener != nil {
+		listener(event)
+	}
+}
+
+// schemaChange is the transaction a schema change runs in. exec records each
+// statement as an up step of the migration; started runs before the first.
+type schemaChange struct {
+	ctx        context.Context
+	tx         *sql.Tx
+	statements []string
+	started    func()
+}
+
+func (c *schemaChange) exec(stmt string) error {
+	if len(c.statements) == 0 && c.started != nil {
+		c.started()
+	}
+	c.statements = append(c.statements, stmt)
+	return execSchemaStatement(c.ctx, c.tx, stmt)
+}
+
+// execSchemaStatement runs one migration statement. Renaming a rebuilt table
+// over its original uses the legacy rename, which leaves views and triggers
+// naming the dropped original untouched instead of failing on them.
+func execSchemaStatement(ctx context.Context, tx *sql.Tx, stmt string) error {
+	swap := strings.HasPrefix(stmt, `ALTER TABLE "`+rebuildTablePrefix) && strings.Contains(stmt, " RENAME TO ")
+	if swap {
+		if _, err := tx.ExecContext(ctx, "PRAGMA legacy_alter_table = ON"); err != nil {
+			return err
+		}
+		defer tx.ExecContext(ctx, "PRAGMA legacy_alter_table = OFF")
+	}
+	_, err := tx.ExecContext(ctx, stmt)
+	return quotaError(err)
+}
+
+// withSchemaTx runs fn in a transaction on a dedicated connection. With
+// deferForeignKeys, foreign keys are off during fn (they cannot be toggled
+// inside a transaction) and checked afterwards. fn returning commit=false
+// rolls back.
+func (s *VMStore) withSchemaTx(ctx context.Context, workspaceID string, deferForeignKeys bool, fn func(tx *sql.Tx) (commit bool, err error)) error {
+	db, err := s.GetDB(workspaceID)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("vmstore: schema connection: %w", err)
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if deferForeignKeys {
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			return fmt.Errorf("vmstore: read foreign_keys: %w", err)
+		}
+		if foreignKeys {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+				return fmt.Errorf("vmstore: disable foreign keys: %w", err)
+			}
+			defer conn.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+		}
+	}
+
+	// Rows copied by a table rebuild are not changes of their own.
+	defer changesOf(db).suspend()()
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("vmstore: begin schema change: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := ensureMigrationsTable(ctx, tx); err != nil {
+		return err
+	}
+	commit, err := fn(tx)
+	if err != nil {
+		return err
+	}
+	if foreignKeys {
+		if err := checkForeignKeys(ctx, tx); err != nil {
+			return err
+		}
+	}
+	if !commit {
+		return nil
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("vmstore: commit schema change: %w", quotaError(err))
+	}
+	return nil
+}
+
+// changeSchema runs fn in a schema transaction and records the statements it
+// executes as a new migration, with the down steps fn returns. Reverted
+// migrations are marked superseded and never run again, so history stays
+// linear while versions are never reused. A preview always rolls back and
+// records nothing; so does a change that executes no statement.
+func (s *VMStore) changeSchema(ctx context.Context, workspaceID, name string, deferForeignKeys, preview bool, fn func(c *schemaChange) (down []string, err error)) error {
+	migration := VMMigration{Name: name, Source: migrationSource(ctx), Status: MigrationStatusApplied}
+	started := false
+
+	err := s.withSchemaTx(ctx, workspaceID, deferForeignKeys, func(tx *sql.Tx) (bool, error) {
+		change := &schemaChange{ctx: ctx, tx: tx}
+		if !preview {
+			change.started = func() {
+				started = true
+				s.notifyMigration(VMMigrationEvent{Kind: MigrationEventStarted, Direction: MigrationUp, WorkspaceID: workspaceID, Migration: migration})
+			}
+		}
+		down, err := fn(change)
+		if err != nil || preview || len(change.statements) == 0 {
+			return false, err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf("UPDATE %q SET status = ? WHERE status = ?", migrationsTable),
+			MigrationStatusSuperseded, MigrationStatusReverted); err != nil {
+			return false, fmt.Errorf("vmstore: supersede reverted migrations: %w", err)
+		}
+		now := time.Now().UTC()
+		migration.Up = change.statements
+		migration.Down = down
+		migration.CreatedAt = now
+		migration.AppliedAt = &now
+		version, err := insertMigration(ctx, tx, migration)
+		migration.Version = version
+		return err == nil, err
+	})
+	if !started {
+		return err
+	}
+	if err != nil {
+		s.notifyMigration(VMMigrationEvent{Kind: MigrationEventFailed, Direction: MigrationUp, WorkspaceID: workspaceID, Migration: migration, Err: err})
+		return err
+	}
+	s.notifyMigration(VMMigrationEvent{Kind: MigrationEventCompleted, Direction: MigrationUp, WorkspaceID: workspaceID, Migration: migration})
+	return nil
+}
+
+func ensureMigrationsTable(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %q (
+  "version" INTEGER PRIMARY KEY,
+  "name" TEXT NOT NULL,
+  "source" TEXT NOT NULL,
+  "up_sql" TEXT NOT NULL,
+  "down_sql" TEXT NOT NULL,
+  "status" TEXT NOT NULL,
+  "created_at" TEXT NOT NULL,
+  "applied_at" TEXT,
+  "reverted_at" TEXT
+)`, migrationsTable))
+	if err != nil {
+		return fmt.Errorf("vmstore: create migrations table: %w", quotaError(err))
+	}
+	return nil
+}
+
+func insertMigration(ctx context.Context, tx *sql.Tx, m VMMigration) (int64, error) {
+	up, _ := json.Marshal(m.Up)
+	down, _ := json.Marshal(m.Down)
+	res, err := tx.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %q (version, name, source, up_sql, down_sql, status, created_at, applied_at)
+		 VALUES ((SELECT COALESCE(MAX(version), 0) + 1 FROM %q), ?, ?, ?, ?, ?, ?, ?)`, migrationsTable, migrationsTable),
+		m.Name, m.Source, string(up), string(down), m.Status, formatMigrationTime(&m.CreatedAt), formatMigrationTime(m.AppliedAt))
+	if err != nil {
+		return 0, fmt.Errorf("vmstore: record migration: %w", quotaError(err))
+	}
+	return res.LastInsertId()
+}
+
+// ListMigrations returns a workspace's migrations, oldest first. A workspace
+// without a database has none.
+func (s *VMStore) ListMigrations(ctx context.Context, workspaceID string) ([]VMMigration, error) {
+	if !s.Exists(workspaceID) {
+		return []VMMigration{}, nil
+	}
+	db, err := s.GetDB(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	return listMigrations(ctx, db)
+}
+
+type sqlContextQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func listMigrations(ctx context.Context, q sqlContextQueryer) ([]VMMigration, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf(
+		`SELECT version, name, source, up_sql, down_sql, status, created_at, applied_at, reverted_at FROM %q ORDER BY version`, migrationsTable))
+	if err != nil {
+		if strings.Contains(err.Error(), "no such table") {
+			return []VMMigration{}, nil
+		}
+		return nil, fmt.Errorf("vmstore: list migrations: %w", err)
+	}
+	defer rows.Close()
+
+	migrations := []VMMigration{}
+	for rows.Next() {
+		var m VMMigration
+		var up, down, createdAt string
+		var appliedAt, revertedAt sql.NullString
+		if err := rows.Scan(&m.Version, &m.Name, &m.Source, &up, &down, &m.Status, &createdAt, &appliedAt, &revertedAt); err != nil {
+			return nil, fmt.Errorf("vmstore: scan migration: %w", err)
+		}
+		json.Unmarshal([]byte(up), &m.Up)
+		json.Unmarshal([]byte(down), &m.Down)
+		m.CreatedAt, _ = time.Parse(time.RFC3339Nano, createdAt)
+		m.AppliedAt = parseMigrationTime(appliedAt)
+		m.RevertedAt = parseMigrationTime(revertedAt)
+		migrations = append(migrations, m)
+	}
+	return migrations, rows.Err()
+}
+
+// SchemaVersion returns the version of the latest applied migration, or 0.
+func (s *VMStore) SchemaVersion(ctx context.Context, workspaceID string) (int64, error) {
+	migrations, err := s.ListMigrations(ctx, workspaceID)
+	if err != nil {
+		return 0, err
+	}
+	return appliedVersion(migrations), nil
+}
+
+// latestVersion returns the highest recorded version, whatever its status.
+func latestVersion(migrations []VMMigration) int64 {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+func appliedVersion(migrations []VMMigration) int64 {
+	var version int64
+	for _, m := range migrations {
+		if m.Status == MigrationStatusApplied && m.Version > version {
+			version = m.Version
+		}
+	}
+	return version
+}
+
+// SchemaSummary describes the current schema: its migration version and
+// the DDL of every user table.
+func (s *VMStore) SchemaSummary(ctx context.Context, workspaceID string) (map[string]interface{}, error) {
+	version, err := s.SchemaVersion(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	tables := []VMTableInfo{}
+	if s.Exists(workspaceID) {
+		if tables, err = s.ListTables(ctx, workspaceID); err != nil {
+			return nil, err
+		}
+	}
+	ddl := make(map[string]interface{}, len(tables))
+	for _, t := range tables {
+		schema, err := s.GetTableSchema(ctx, workspaceID, t.Name)
+		if err != nil {
+			return nil, err
+		}
+		ddl[t.Name] = schema.DDL
+	}
+	return map[string]interface{}{
+		"version": version,
+		"tables":  ddl,
+	}, nil
+}
+
+// MigrateTo applies or reverts migrations until the schema is at version.
+// Superseded migrations are skipped. The whole range runs in one transaction,
+// so a failing step leaves the schema as it was. Reverting always takes a
+// snapshot first since down steps may drop tables and columns.
+func (s *VMStore) MigrateTo(ctx context.Context, workspaceID string, version int64) error {
+	migrations, err := s.ListMigrations(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	if version < 0 || version > latestVersion(migrations) {
+		return ErrMigrationNotFound
+	}
+
+	type step struct {
+		migration VMMigration
+		direction string
+	}
+	var steps []step
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if m := migrations[i]; m.Version > version && m.Status == MigrationStatusApplied {
+			steps = append(steps, step{m, MigrationDown})
+		}
+	}
+	reverting := len(steps) > 0
+	for _, m := range migrations {
+		if m.Version <= version && m.Status == MigrationStatusReverted {
+			steps = append(steps, step{m, MigrationUp})
+		}
+	}
+	if len(steps) == 0 {
+		return nil
+	}
+
+	if reverting {
+		if _, err := s.CreateSnapshot(ctx, workspaceID, SnapshotReasonMigrate, fmt.Sprintf("migrate to %d", version)); err != nil {
+			return fmt.Errorf("vmstore: snapshot before migrate to %d: %w", version, err)
+		}
+	}
+
+	var done []step
+	var failed *step
+	err = s.withSchemaTx(ctx, workspaceID, true, func(tx *sql.Tx) (bool, error) {
+		for _, st := range steps {
+			s.notifyMigration(VMMigrationEvent{Kind: MigrationEventStarted, Direction: st.direction, WorkspaceID: workspaceID, Migration: st.migration})
+			m, err := runMigration(ctx, tx, st.migration, st.direction)
+			if err != nil {
+				failed = &st
+				return false, err
+			}
+			done = append(done, step{m, st.direction})
+		}
+		return true, nil
+	})
+	if err != nil {
+		if failed == nil {
+			// The transaction failed outside a step, e.g. on commit.
+			failed = &steps[min(len(done), len(steps)-1)]
+		}
+		s.notifyMigration(VMMigrationEvent{Kind: MigrationEventFailed, Direction: failed.direction, WorkspaceID: workspaceID, Migration: failed.migration, Err: err})
+		return err
+	}
+	for _, st := range done {
+		s.notifyMigration(VMMigrationEvent{Kind: MigrationEventCompleted, Direction: st.direction, WorkspaceID: workspaceID, Migration: st.migration})
+	}
+	return nil
+}
+
+// runMigration runs one migration's up or down steps in tx and updates its
+// status, returning the migration as recorded.
+func runMigration(ctx context.Context, tx *sql.Tx, m VMMigration, direction string) (VMMigration, error) {
+	now := time.Now().UTC()
+	steps, status, column := m.Up, MigrationStatusApplied, "applied_at"
+	if direction == MigrationDown {
+		steps, status, column = m.Down, MigrationStatusReverted, "reverted_at"
+	}
+	for _, stmt := range steps {
+		if err := execSchemaStatement(ctx, tx, stmt); err != nil {
+			return m, fmt.Errorf("vmstore: migration %d %s: %w", m.Version, direction, err)
+		}
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE %q SET status = ?, %s = ? WHERE version = ?`, migrationsTable, column),
+		status, formatMigrationTime(&now), m.Version)
+	if err != nil {
+		return m, fmt.Errorf("vmstore: update migration %d: %w", m.Version, err)
+	}
+	if direction == MigrationDown {
+		m.Status, m.RevertedAt = status, &now
+	} else {
+		m.Status, m.AppliedAt = status, &now
+	}
+	return m, nil
+}
+
+func formatMigrationTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseMigrationTime(s sql.NullString) *time.Time {
+	if !s.Valid {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s.String)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// tableObjects is the DDL of a table with its columns, indexes and triggers.
+type tableObjects struct {
+	name     string
+	sql      string
+	columns  []string
+	indexes  []string
+	triggers []string
+}
+
+// readTableObjects captures a table's schema objects, or nil if it is missing.
+func readTableObjects(ctx context.Context, tx *sql.Tx, table string) (*tableObjects, error) {
+	obj := &tableObjects{name: table}
+	err := tx.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&obj.sql)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: read table ddl: %w", err)
+	}
+	columns, err := tableColumns(ctx, tx, table)
+	if err != nil {
+		return nil, err
+	}
+	for _, col := range columns {
+		obj.columns = append(obj.columns, col.name)
+	}
+
+	rows, err := tx.QueryContext(ctx,
+		"SELECT type, sql FROM sqlite_master WHERE type IN ('index', 'trigger') AND tbl_name=? AND sql IS NOT NULL ORDER BY rowid", table)
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: read table objects: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var typ, stmt string
+		if err := rows.Scan(&typ, &stmt); err != nil {
+			return nil, fmt.Errorf("vmstore: read table objects: %w", err)
+		}
+		if typ == "index" {
+			obj.indexes = append(obj.indexes, stmt)
+		} else {
+			obj.triggers = append(obj.triggers, stmt)
+		}
+	}
+	return obj, rows.Err()
+}
+
+// createStatements recreates the table's schema (without its rows).
+func (o *tableObjects) createStatements() []string {
+	stmts := []string{o.sql}
+	stmts = append(stmts, o.indexes...)
+	return append(stmts, o.triggers...)
+}
+
+// restoreStatements rebuild the table now named current into o's definition
+// under the same name, copying the columns both share once the columns in
+// renamed (current name to o's name) are renamed back. Indexes and triggers
+// are left to the caller, since they name o's table.
+func (o *tableObjects) restoreStatements(ctx context.Context, tx *sql.Tx, current string, renamed map[string]string) ([]string, error) {
+	columns, err := tableColumns(ctx, tx, current)
+	if err != nil {
+		return nil, err
+	}
+	have := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		name := col.name
+		if old, ok := renamed[name]; ok {
+			name = old
+		}
+		have[name] = true
+	}
+	var names []string
+	for _, name := range o.columns {
+		if have[name] {
+			names = append(names, fmt.Sprintf("%q", name))
+		}
+	}
+
+	open := strings.Index(o.sql, "(")
+	if open < 0 {
+		return nil, fmt.Errorf("vmstore: unexpected ddl for %q", o.name)
+	}
+	tmp := rebuildTablePrefix + current
+	stmts := []string{fmt.Sprintf("CREATE TABLE %q %s", tmp, o.sql[open:])}
+	if len(names) > 0 {
+		cols := strings.Join(names, ", ")
+		stmts = append(stmts, fmt.Sprintf("INSERT INTO %q (%s) SELECT %s FROM %q", tmp, cols, cols, current))
+	}
+	return append(stmts,
+		fmt.Sprintf("DROP TABLE %q", current),
+		fmt.Sprintf("ALTER TABLE %q RENAME TO %q", tmp, current),
+	), nil
+}
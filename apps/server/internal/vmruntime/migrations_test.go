@@ -0,0 +1,271 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestVMStore_MigrationsRecorded(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-migrations"
+
+	var mu sync.Mutex
+	var events []string
+	store.SetMigrationListener(func(ev VMMigrationEvent) {
+		mu.Lock()
+		events = append(events, ev.Kind+":"+ev.Direction)
+		mu.Unlock()
+	})
+
+	req := VMCreateTableRequest{
+		Name:       "users",
+		Columns:    []VMCreateColumnDef{{Name: "id", Type: "INTEGER"}, {Name: "email", Type: "TEXT", Nullable: true}},
+		PrimaryKey: []string{"id"},
+	}
+	if err := store.CreateTable(ctx, wsID, req); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	// Creating an existing table fails and records nothing.
+	if err := store.CreateTable(ctx, wsID, req); !errors.Is(err, ErrTableExists) {
+		t.Fatalf("CreateTable(existing) = %v, want ErrTableExists", err)
+	}
+	agentCtx := WithMigrationSource(ctx, MigrationSourceAgent)
+	if _, err := store.AlterTable(agentCtx, wsID, "users", VMAlterTableRequest{
+		AddColumns: []VMCreateColumnDef{{Name: "name", Type: "TEXT", Nullable: true}},
+	}); err != nil {
+		t.Fatalf("AlterTable: %v", err)
+	}
+	if _, err := store.PreviewAlterTable(ctx, wsID, "users", VMAlterTableRequest{DropColumns: []string{"name"}}); err != nil {
+		t.Fatalf("PreviewAlterTable: %v", err)
+	}
+	if err := store.DropTable(ctx, wsID, "users"); err != nil {
+		t.Fatalf("DropTable: %v", err)
+	}
+
+	migrations, err := store.ListMigrations(ctx, wsID)
+	if err != nil {
+		t.Fatalf("ListMigrations: %v", err)
+	}
+	if len(migrations) != 3 {
+		t.Fatalf("migrations = %+v, want 3", migrations)
+	}
+	wantNames := []string{"create table users", "alter table users", "drop table users"}
+	wantSources := []string{MigrationSourceDashboard, MigrationSourceAgent, MigrationSourceDashboard}
+	for i, m := range migrations {
+		if m.Version != int64(i+1) || m.Name != wantNames[i] || m.Source != wantSources[i] ||
+			m.Status != MigrationStatusApplied || len(m.Up) == 0 || len(m.Down) == 0 {
+			t.Fatalf("migration %d = %+v", i, m)
+		}
+	}
+	if v, _ := store.SchemaVersion(ctx, wsID); v != 3 {
+		t.Fatalf("SchemaVersion = %d, want 3", v)
+	}
+
+	tables, _ := store.ListTables(ctx, wsID)
+	if len(tables) != 0 {
+		t.Fatalf("tables = %+v, want none (migrations table hidden)", tables)
+	}
+	if err := store.CreateTable(ctx, wsID, VMCreateTableRequest{Name: "_vm_x", Columns: req.Columns}); err == nil {
+		t.Fatal("CreateTable accepted a reserved name")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 6 {
+		t.Fatalf("events = %v, want started/completed for 3 migrations", events)
+	}
+	for i := 0; i < len(events); i += 2 {
+		if events[i] != "started:up" || events[i+1] != "completed:up" {
+			t.Fatalf("events = %v", events)
+		}
+	}
+}
+
+func TestVMStore_MigrateTo(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-migrate-to"
+
+	store.CreateTable(ctx, wsID, VMCreateTableRequest{
+		Name:       "people",
+		Columns:    []VMCreateColumnDef{{Name: "id", Type: "INTEGER"}, {Name: "age", Type: "TEXT", Nullable: true}},
+		PrimaryKey: []string{"id"},
+		Indexes:    []VMCreateIndexDef{{Name: "idx_people_age", Columns: []string{"age"}}},
+	})
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO people (age) VALUES ('30'), ('41')`)
+	if _, err := store.AlterTable(ctx, wsID, "people", VMAlterTableRequest{
+		Rename:       "members",
+		AlterColumns: []VMAlterColumnDef{{Name: "age", NewName: "years", Type: "INTEGER"}},
+	}); err != nil {
+		t.Fatalf("AlterTable: %v", err)
+	}
+	store.CreateTable(ctx, wsID, VMCreateTableRequest{Name: "tags", Columns: []VMCreateColumnDef{{Name: "label", Type: "TEXT"}}})
+
+	var events []string
+	store.SetMigrationListener(func(ev VMMigrationEvent) {
+		if ev.Kind == MigrationEventCompleted {
+			events = append(events, ev.Direction)
+		}
+	})
+
+	if err := store.MigrateTo(ctx, wsID, 1); err != nil {
+		t.Fatalf("MigrateTo(1): %v", err)
+	}
+	tables, _ := store.ListTables(ctx, wsID)
+	if len(tables) != 1 || tables[0].Name != "people" {
+		t.Fatalf("tables at version 1 = %+v, want people", tables)
+	}
+	if age := columnInfo(t, store, wsID, "people", "age"); age.Type != "TEXT" {
+		t.Fatalf("age at version 1 = %+v", age)
+	}
+	if n := countRows(t, store, wsID, "people"); n != 2 {
+		t.Fatalf("rows at version 1 = %d, want 2", n)
+	}
+	schema, _ := store.GetTableSchema(ctx, wsID, "people")
+	if len(schema.Indexes) != 1 || schema.Indexes[0].Name != "idx_people_age" {
+		t.Fatalf("indexes at version 1 = %+v", schema.Indexes)
+	}
+	if snaps, _ := store.ListSnapshots(wsID); len(snaps) == 0 || snaps[0].Reason != SnapshotReasonMigrate {
+		t.Fatalf("snapshots = %+v, want a pre_migrate snapshot first", snaps)
+	}
+
+	if err := store.MigrateTo(ctx, wsID, 3); err != nil {
+		t.Fatalf("MigrateTo(3): %v", err)
+	}
+	if years := columnInfo(t, store, wsID, "members", "years"); years.Type != "INTEGER" {
+		t.Fatalf("years at version 3 = %+v", years)
+	}
+	if n := countRows(t, store, wsID, "members"); n != 2 {
+		t.Fatalf("rows at version 3 = %d, want 2", n)
+	}
+	if got, want := strings.Join(events, ","), "down,down,up,up"; got != want {
+		t.Fatalf("completed events = %s, want %s", got, want)
+	}
+
+	// A new change after a revert supersedes the reverted migrations and
+	// takes a fresh version.
+	store.MigrateTo(ctx, wsID, 2)
+	store.CreateTable(ctx, wsID, VMCreateTableRequest{Name: "notes", Columns: []VMCreateColumnDef{{Name: "body", Type: "TEXT"}}})
+	migrations, _ := store.ListMigrations(ctx, wsID)
+	if len(migrations) != 4 || migrations[2].Status != MigrationStatusSuperseded ||
+		migrations[3].Name != "create table notes" || migrations[3].Version != 4 {
+		t.Fatalf("migrations = %+v, want tags superseded by notes at version 4", migrations)
+	}
+
+	// Superseded migrations are never applied again.
+	if err := store.MigrateTo(ctx, wsID, 4); err != nil {
+		t.Fatalf("MigrateTo(4): %v", err)
+	}
+	tables, _ = store.ListTables(ctx, wsID)
+	for _, table := range tables {
+		if table.Name == "tags" {
+			t.Fatalf("tables = %+v, superseded tags migration was applied", tables)
+		}
+	}
+	if v, _ := store.SchemaVersion(ctx, wsID); v != 4 {
+		t.Fatalf("SchemaVersion = %d, want 4", v)
+	}
+
+	// Reverting the newest migration and making another change never reuses
+	// its version.
+	store.MigrateTo(ctx, wsID, 2)
+	store.CreateTable(ctx, wsID, VMCreateTableRequest{Name: "labels", Columns: []VMCreateColumnDef{{Name: "name", Type: "TEXT"}}})
+	migrations, _ = store.ListMigrations(ctx, wsID)
+	if last := migrations[len(migrations)-1]; last.Version != 5 || last.Name != "create table labels" {
+		t.Fatalf("latest migration = %+v, want labels at version 5", last)
+	}
+
+	if err := store.MigrateTo(ctx, wsID, 9); !errors.Is(err, ErrMigrationNotFound) {
+		t.Fatalf("MigrateTo(9) err = %v, want ErrMigrationNotFound", err)
+	}
+}
+
+func TestVMStore_TableNamesAreCaseInsensitive(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-table-case"
+
+	if err := store.CreateTable(ctx, wsID, VMCreateTableRequest{
+		Name:       "users",
+		Columns:    []VMCreateColumnDef{{Name: "id", Type: "INTEGER"}},
+		PrimaryKey: []string{"id"},
+	}); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO users (id) VALUES (1), (2)`)
+
+	err := store.CreateTable(ctx, wsID, VMCreateTableRequest{Name: "Users", Columns: []VMCreateColumnDef{{Name: "id", Type: "INTEGER"}}})
+	if !errors.Is(err, ErrTableExists) {
+		t.Fatalf("CreateTable(Users) = %v, want ErrTableExists", err)
+	}
+	migrations, _ := store.ListMigrations(ctx, wsID)
+	if len(migrations) != 1 {
+		t.Fatalf("migrations = %+v, want only the original create", migrations)
+	}
+	// Rolling back to version 1 must keep the original table and its rows.
+	if err := store.MigrateTo(ctx, wsID, 1); err != nil {
+		t.Fatalf("MigrateTo(1): %v", err)
+	}
+	if n := countRows(t, store, wsID, "users"); n != 2 {
+		t.Fatalf("rows = %d, want 2", n)
+	}
+
+	if err := store.DropTable(ctx, wsID, "USERS"); err != nil {
+		t.Fatalf("DropTable(USERS): %v", err)
+	}
+	if tables, _ := store.ListTables(ctx, wsID); len(tables) != 0 {
+		t.Fatalf("tables after DropTable(USERS) = %+v, want none", tables)
+	}
+	if err := store.DropTable(ctx, wsID, "users"); !errors.Is(err, ErrTableNotFound) {
+		t.Fatalf("DropTable(missing) = %v, want ErrTableNotFound", err)
+	}
+}
+
+func TestVMStore_MigrateToIsAtomic(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-migrate-atomic"
+
+	for _, name := range []string{"a", "b"} {
+		if err := store.CreateTable(ctx, wsID, VMCreateTableRequest{Name: name, Columns: []VMCreateColumnDef{{Name: "v", Type: "TEXT"}}}); err != nil {
+			t.Fatalf("CreateTable(%s): %v", name, err)
+		}
+	}
+	db, _ := store.GetDB(wsID)
+	if _, err := db.Exec(`UPDATE "_vm_migrations" SET down_sql = '["DROP TABLE missing"]' WHERE version = 1`); err != nil {
+		t.Fatalf("corrupt migration: %v", err)
+	}
+
+	var failed []int64
+	store.SetMigrationListener(func(ev VMMigrationEvent) {
+		if ev.Kind == MigrationEventFailed {
+			failed = append(failed, ev.Migration.Version)
+		}
+	})
+	if err := store.MigrateTo(ctx, wsID, 0); err == nil {
+		t.Fatal("MigrateTo(0) succeeded with a failing down step")
+	}
+	if len(failed) != 1 || failed[0] != 1 {
+		t.Fatalf("failed events = %v, want [1]", failed)
+	}
+
+	// Reverting b is rolled back with the failing step.
+	tables, _ := store.ListTables(ctx, wsID)
+	if len(tables) != 2 {
+		t.Fatalf("tables = %+v, want a and b", tables)
+	}
+	if v, _ := store.SchemaVersion(ctx, wsID); v != 2 {
+		t.Fatalf("SchemaVersion = %d, want 2", v)
+	}
+	if snaps, _ := store.ListSnapshots(wsID); len(snaps) == 0 || snaps[0].Reason != SnapshotReasonMigrate {
+		t.Fatalf("snapshots = %+v, want a pre_migrate snapshot", snaps)
+	}
+}
@@ -0,0 +1,191 @@
+package vmruntime
+
+import (
+	"time"
+
+	"github.com/reverseai/server/internal/config"
+)
+
+// VMStoreOptions configures a VMStore.
+type VMStoreOptions struct {
+	// BaseDir is the directory holding one SQLite file per workspace.
+	BaseDir string
+	// MaxDBSize caps each workspace database in bytes; 0 means unlimited.
+	MaxDBSize int64
+
+	// SnapshotDir holds snapshots as {workspace}/{id}.db; defaults to
+	// BaseDir/snapshots.
+	SnapshotDir string
+	// SnapshotInterval is how often changed databases are snapshotted;
+	// 0 disables scheduled snapshots.
+	SnapshotInterval time.Duration
+	// SnapshotRetention bounds the snapshots kept per workspace.
+	SnapshotRetention VMSnapshotRetention
+}
+
+// VMLimits bounds code loading and request execution of a WorkspaceVM.
+// Exceeding any per-execution budget aborts the run with a *VMLimitError.
+type VMLimits struct {
+	ExecTimeout time.Duration
+	LoadTimeout time.Duration
+	MaxCodeSize int
+	Concurrency VMConcurrency
+
+	// MaxSteps caps loop iterations plus function calls per execution.
+	MaxSteps int64
+	// HeapGuardBytes interrupts an execution with ErrVMHeapPressure when the
+	// process heap grows this much while it runs. The heap is shared by all
+	// runtimes, so this guards the process and is not a per-execution budget.
+	HeapGuardBytes int64
+	// MaxCallStackSize caps the JS call depth.
+	MaxCallStackSize int
+	// MaxResultRows and MaxResultBytes cap a single db.query result set.
+	MaxResultRows  int
+	MaxResultBytes int64
+	// MaxStringLength caps strings built by string builtins, Array join,
+	// concatenation and template literals.
+	MaxStringLength int
+	// MaxArrayLength caps the length of arrays created or grown by the Array
+	// builtins and by assignments to a length property.
+	MaxArrayLength int
+
+	// FetchTimeout caps a single fetch call; the remaining exec time caps it
+	// further. MaxFetchCalls caps fetch calls per execution and
+	// MaxFetchBytes the size of each request and response body.
+	FetchTimeout  time.Duration
+	MaxFetchCalls int
+	MaxFetchBytes int64
+	// FetchAllowPrivate lets fetch reach loopback, private and link-local
+	// addresses. For local development only.
+	FetchAllowPrivate bool
+}
+
+// VMPoolOptions configures a VMPool.
+type VMPoolOptions struct {
+	MaxVMs int
+	// IdleTimeout is how long a VM or database handle may go unused before
+	// the background evictor closes it; 0 disables the evictor.
+	IdleTimeout time.Duration
+	// CodeTTL is how long a cached VM is served without asking the code
+	// loader whether its code changed; 0 asks on every call. Deploys,
+	// publishes and rollbacks invalidate the VM explicitly, so the TTL only
+	// bounds staleness when an invalidation is lost.
+	CodeTTL time.Duration
+	Limits  VMLimits
+}
+
+// DefaultVMLimits returns the limits used when none are configured.
+func DefaultVMLimits() VMLimits {
+	return VMLimits{
+		ExecTimeout: VMExecTimeout,
+		LoadTimeout: VMLoadTimeout,
+		MaxCodeSize: VMMaxCodeSize,
+		Concurrency: DefaultVMConcurrency(),
+
+		MaxSteps:         VMMaxSteps,
+		HeapGuardBytes:   VMHeapGuardBytes,
+		MaxCallStackSize: VMMaxCallStackSize,
+		MaxResultRows:    VMMaxResultRows,
+		MaxResultBytes:   VMMaxResultBytes,
+		MaxStringLength:  VMMaxStringLength,
+		MaxArrayLength:   VMMaxArrayLength,
+
+		FetchTimeout:  VMFetchTimeout,
+		MaxFetchCalls: VMMaxFetchCalls,
+		MaxFetchBytes: VMMaxFetchBytes,
+	}
+}
+
+// normalize fills zero or negative fields with defaults.
+func (l VMLimits) normalize() VMLimits {
+	def := DefaultVMLimits()
+	if l.ExecTimeout <= 0 {
+		l.ExecTimeout = def.ExecTimeout
+	}
+	if l.LoadTimeout <= 0 {
+		l.LoadTimeout = def.LoadTimeout
+	}
+	if l.MaxCodeSize <= 0 {
+		l.MaxCodeSize = def.MaxCodeSize
+	}
+	if l.MaxSteps <= 0 {
+		l.MaxSteps = def.MaxSteps
+	}
+	if l.HeapGuardBytes <= 0 {
+		l.HeapGuardBytes = def.HeapGuardBytes
+	}
+	if l.MaxCallStackSize <= 0 {
+		l.MaxCallStackSize = def.MaxCallStackSize
+	}
+	if l.MaxResultRows <= 0 {
+		l.MaxResultRows = def.MaxResultRows
+	}
+	if l.MaxResultBytes <= 0 {
+		l.MaxResultBytes = def.MaxResultBytes
+	}
+	if l.MaxStringLength <= 0 {
+		l.MaxStringLength = def.MaxStringLength
+	}
+	if l.MaxArrayLength <= 0 {
+		l.MaxArrayLength = def.MaxArrayLength
+	}
+	if l.FetchTimeout <= 0 {
+		l.FetchTimeout = def.FetchTimeout
+	}
+	if l.MaxFetchCalls <= 0 {
+		l.MaxFetchCalls = def.MaxFetchCalls
+	}
+	if l.MaxFetchBytes <= 0 {
+		l.MaxFetchBytes = def.MaxFetchBytes
+	}
+	if l.Concurrency.QueueTimeout <= 0 {
+		l.Concurrency.QueueTimeout = l.ExecTimeout
+	}
+	l.Concurrency = l.Concurrency.normalize()
+	return l
+}
+
+// StoreOptionsFromConfig maps the vm_runtime config section to VMStoreOptions.
+func StoreOptionsFromConfig(cfg config.VMRuntimeConfig) VMStoreOptions {
+	return VMStoreOptions{
+		BaseDir:          cfg.BaseDir,
+		MaxDBSize:        cfg.MaxDBSize,
+		SnapshotDir:      cfg.SnapshotDir,
+		SnapshotInterval: cfg.SnapshotInterval,
+		SnapshotRetention: VMSnapshotRetention{
+			MaxCount: cfg.SnapshotMaxCount,
+			MaxAge:   cfg.SnapshotMaxAge,
+		},
+	}
+}
+
+// PoolOptionsFromConfig maps the vm_runtime config section to VMPoolOptions.
+func PoolOptionsFromConfig(cfg config.VMRuntimeConfig) VMPoolOptions {
+	return VMPoolOptions{
+		MaxVMs:      cfg.MaxVMs,
+		IdleTimeout: cfg.EvictInterval,
+		CodeTTL:     cfg.CodeCacheTTL,
+		Limits: VMLimits{
+			ExecTimeout: cfg.ExecTimeout,
+			LoadTimeout: cfg.LoadTimeout,
+			MaxCodeSize: int(cfg.MaxCodeSize),
+			Concurrency: VMConcurrency{
+				MaxConcurrent: cfg.MaxConcurrency,
+				MaxQueue:      cfg.MaxQueueDepth,
+				QueueTimeout:  cfg.QueueTimeout,
+			},
+			MaxSteps:         cfg.MaxSteps,
+			HeapGuardBytes:   cfg.HeapGuardBytes,
+			MaxCallStackSize: cfg.MaxCallStack,
+			MaxResultRows:    cfg.MaxResultRows,
+			MaxResultBytes:   cfg.MaxResultBytes,
+			MaxStringLength:  cfg.MaxStringLength,
+			MaxArrayLength:   cfg.MaxArrayLength,
+
+			FetchTimeout:      cfg.FetchTimeout,
+			MaxFetchCalls:     cfg.MaxFetchCalls,
+			MaxFetchBytes:     cfg.MaxFetchBytes,
+			FetchAllowPrivate: cfg.FetchAllowPrivate,
+		},
+	}
+}
@@ -0,0 +1,159 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrRowScopeViolation is returned when a scoped insert or update would leave
+// a row that no longer satisfies the scope.
+var ErrRowScopeViolation = errors.New("vmstore: row violates scope")
+
+// predicate returns the scope as a single SQL predicate, or "" when the
+// scope does not restrict anything.
+func (sc *VMRowScope) predicate() (string, []interface{}) {
+	if sc == nil {
+		return "", nil
+	}
+	if sc.DenyAll {
+		return "0", nil
+	}
+	var parts []string
+	var args []interface{}
+	if len(sc.Filters) > 0 {
+		clause, filterArgs := buildWhereClause(sc.Filters, "and")
+		parts = append(parts, "("+clause+")")
+		args = append(args, filterArgs...)
+	}
+	if strings.TrimSpace(sc.Clause) != "" {
+		parts = append(parts, "("+sc.Clause+")")
+		args = append(args, sc.Args...)
+	}
+	return strings.Join(parts, " AND "), args
+}
+
+// and appends the scope predicate to an existing WHERE clause. The existing
+// clause is parenthesized so an OR combinator cannot escape the scope.
+func (sc *VMRowScope) and(clause string, args []interface{}) (string, []interface{}) {
+	scopeClause, scopeArgs := sc.predicate()
+	if scopeClause == "" {
+		return clause, args
+	}
+	merged := make([]interface{}, 0, len(args)+len(scopeArgs))
+	merged = append(merged, args...)
+	merged = append(merged, scopeArgs...)
+	if clause == "" {
+		return scopeClause, merged
+	}
+	return "(" + clause + ") AND " + scopeClause, merged
+}
+
+// selectRowIDs returns the rowids of rows matching where.
+func selectRowIDs(ctx context.Context, tx sqlConn, tableName, where string, args []interface{}) ([]interface{}, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT rowid FROM %q WHERE %s", tableName, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []interface{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// checkRowsInScope verifies that every row matched by where still satisfies
+// the scope after a write (the equivalent of a WITH CHECK clause).
+func checkRowsInScope(ctx context.Context, tx sqlConn, tableName, where string, whereArgs []interface{}, scope *VMRowScope) error {
+	scopeClause, scopeArgs := scope.predicate()
+	if scopeClause == "" {
+		return nil
+	}
+	args := append(append([]interface{}{}, whereArgs...), scopeArgs...)
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %q WHERE (%s) AND NOT COALESCE(%s, 0)", tableName, where, scopeClause)
+	var violations int64
+	if err := tx.QueryRowContext(ctx, query, args...).Scan(&violations); err != nil {
+		return fmt.Errorf("vmstore: scope check: %w", err)
+	}
+	if violations > 0 {
+		return ErrRowScopeViolation
+	}
+	return nil
+}
+
+// scopedInsert runs an INSERT statement. With a non-empty scope the insert
+// runs in a transaction and is rolled back if the stored row (including
+// column defaults) does not satisfy the scope.
+func scopedInsert(ctx context.Context, conn sqlConn, tableName, query string, values []interface{}, scope *VMRowScope) (int64, int64, error) {
+	if scopeClause, _ := scope.predicate(); scopeClause == "" {
+		result, err := conn.ExecContext(ctx, query, values...)
+		if err != nil {
+			return 0, 0, fmt.Errorf("vmstore: insert row: %w", quotaError(err))
+		}
+		lastID, _ := result.LastInsertId()
+		affected, _ := result.RowsAffected()
+		return lastID, affected, nil
+	}
+
+	var lastID, affected int64
+	err := atomically(ctx, conn, func(tx sqlConn) error {
+		result, err := tx.ExecContext(ctx, query, values...)
+		if err != nil {
+			return fmt.Errorf("vmstore: insert row: %w", quotaError(err))
+		}
+		lastID, _ = result.LastInsertId()
+		affected, _ = result.RowsAffected()
+		return checkRowsInScope(ctx, tx, tableName, "rowid = ?", []interface{}{lastID}, scope)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return lastID, affected, nil
+}
+
+// scopedUpdate runs "UPDATE table SET setClause WHERE where". With a non-empty
+// scope it pins the in-scope rows first, updates exactly those, then verifies
+// none of them was moved out of the scope by the new values.
+func scopedUpdate(ctx context.Context, conn sqlConn, tableName, setClause string, setArgs []interface{}, where string, whereArgs []interface{}, scope *VMRowScope) (int64, error) {
+	if scopeClause, _ := scope.predicate(); scopeClause == "" {
+		query := fmt.Sprintf("UPDATE %q SET %s WHERE %s", tableName, setClause, where)
+		result, err := conn.ExecContext(ctx, query, append(append([]interface{}{}, setArgs...), whereArgs...)...)
+		if err != nil {
+			return 0, fmt.Errorf("vmstore: update row: %w", quotaError(err))
+		}
+		affected, _ := result.RowsAffected()
+		return affected, nil
+	}
+
+	whereClause, scopedArgs := scope.and(where, whereArgs)
+
+	var affected int64
+	err := atomically(ctx, conn, func(tx sqlConn) error {
+		rowIDs, err := selectRowIDs(ctx, tx, tableName, whereClause, scopedArgs)
+		if err != nil {
+			return fmt.Errorf("vmstore: update row: %w", quotaError(err))
+		}
+		if len(rowIDs) == 0 {
+			return nil
+		}
+		pinned := "rowid IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(rowIDs)), ", ") + ")"
+
+		query := fmt.Sprintf("UPDATE %q SET %s WHERE %s", tableName, setClause, pinned)
+		result, err := tx.ExecContext(ctx, query, append(append([]interface{}{}, setArgs...), rowIDs...)...)
+		if err != nil {
+			return fmt.Errorf("vmstore: update row: %w", quotaError(err))
+		}
+		affected, _ = result.RowsAffected()
+		return checkRowsInScope(ctx, tx, tableName, pinned, rowIDs, scope)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return affected, nil
+}
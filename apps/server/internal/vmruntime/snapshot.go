@@ -0,0 +1,429 @@
+package vmruntime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// ErrSnapshotNotFound is returned for an unknown snapshot ID.
+var ErrSnapshotNotFound = errors.New("vmstore: snapshot not found")
+
+// Snapshot reasons recorded in VMSnapshot.Reason.
+const (
+	SnapshotReasonManual     = "manual"
+	SnapshotReasonScheduled  = "scheduled"
+	SnapshotReasonDropTable  = "pre_drop_table"
+	SnapshotReasonAlterTable = "pre_alter_table"
+	SnapshotReasonRestore    = "pre_restore"
+)
+
+// Snapshot event kinds passed to the snapshot listener.
+const (
+	SnapshotEventCreated  = "created"
+	SnapshotEventRestored = "restored"
+)
+
+// VMSnapshot describes a point-in-time copy of a workspace database.
+type VMSnapshot struct {
+	ID          string    `json:"id"`
+	WorkspaceID string    `json:"workspace_id"`
+	Reason      string    `json:"reason"`
+	Label       string    `json:"label,omitempty"`
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// VMSnapshotRetention bounds the snapshots kept per workspace; zero fields
+// mean unlimited. The oldest snapshots are removed first.
+type VMSnapshotRetention struct {
+	MaxCount int
+	MaxAge   time.Duration
+}
+
+// VMSnapshotListener is notified after a snapshot is created or restored.
+type VMSnapshotListener func(event string, snap VMSnapshot)
+
+// snapshotIDFormat sorts lexically in creation order.
+const snapshotIDFormat = "20060102T150405.000000000Z"
+
+var snapshotIDPattern = regexp.MustCompile(`^\d{8}T\d{6}\.\d{9}Z$`)
+
+// backuper is implemented by the modernc.org/sqlite driver connection.
+type backuper interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+	NewRestore(srcURI string) (*sqlite.Backup, error)
+}
+
+// SetSnapshotListener registers a callback for snapshot events.
+func (s *VMStore) SetSnapshotListener(listener VMSnapshotListener) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.snapshotListener = listener
+}
+
+// snapshotsEnabled reports whether the store keeps snapshots at all.
+func (s *VMStore) snapshotsEnabled() bool {
+	return s.snapshotDir != ""
+}
+
+// snapshotPath returns the database path of a snapshot.
+func (s *VMStore) snapshotPath(workspaceID, snapshotID string) string {
+	return filepath.Join(s.snapshotDir, workspaceID, snapshotID+".db")
+}
+
+// CreateSnapshot takes an online backup of the workspace database using
+// SQLite's backup API. It runs on a dedicated connection inside one read
+// transaction, so writers are not blocked and the copy is consistent.
+func (s *VMStore) CreateSnapshot(ctx context.Context, workspaceID, reason, label string) (*VMSnapshot, error) {
+	if !s.snapshotsEnabled() {
+		return nil, fmt.Errorf("vmstore: snapshots are disabled")
+	}
+	if _, err := s.GetDB(workspaceID); err != nil {
+		return nil, err
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+
+	now := time.Now().UTC()
+	for {
+		if _, err := os.Stat(s.snapshotPath(workspaceID, now.Format(snapshotIDFormat))); err != nil {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+	}
+	snap := VMSnapshot{
+		ID:          now.Format(snapshotIDFormat),
+		WorkspaceID: workspaceID,
+		Reason:      reason,
+		Label:       label,
+		CreatedAt:   now,
+	}
+	dest := s.snapshotPath(workspaceID, snap.ID)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, fmt.Errorf("vmstore: create snapshot dir: %w", err)
+	}
+	if err := s.backupTo(ctx, workspaceID, dest); err != nil {
+		os.Remove(dest)
+		return nil, err
+	}
+	if info, err := os.Stat(dest); err == nil {
+		snap.SizeBytes = info.Size()
+	}
+	if err := writeSnapshotMeta(dest, snap); err != nil {
+		os.Remove(dest)
+		return nil, err
+	}
+
+	s.pruneSnapshots(workspaceID)
+	s.notifySnapshot(SnapshotEventCreated, snap)
+	return &snap, nil
+}
+
+// backupTo copies the live database to destPath with the backup API.
+func (s *VMStore) backupTo(ctx context.Context, workspaceID, destPath string) error {
+	// Read-only, so closing the last connection never checkpoints the WAL
+	// and bumps the file's mtime, which the scheduler compares against.
+	src, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_busy_timeout=5000", s.DBPath(workspaceID)))
+	if err != nil {
+		return fmt.Errorf("vmstore: open backup source: %w", err)
+	}
+	defer src.Close()
+
+	conn, err := src.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("vmstore: backup connection: %w", err)
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn interface{}) error {
+		b, ok := driverConn.(backuper)
+		if !ok {
+			return fmt.Errorf("vmstore: sqlite driver does not support backups")
+		}
+		bck, err := b.NewBackup(destPath)
+		if err != nil {
+			return fmt.Errorf("vmstore: start backup: %w", err)
+		}
+		// A single step copies every page within one read transaction.
+		if _, err := bck.Step(-1); err != nil {
+			bck.Finish()
+			return fmt.Errorf("vmstore: backup: %w", err)
+		}
+		if err := bck.Finish(); err != nil {
+			return fmt.Errorf("vmstore: finish backup: %w", err)
+		}
+		return nil
+	})
+}
+
+// RestoreSnapshot replaces the live workspace database with a snapshot. A
+// pre_restore snapshot of the current state is taken first so the restore
+// itself can be undone. Listeners get a SnapshotEventRestored and a
+// MigrationEventRestored carrying the latest applied migration afterwards.
+func (s *VMStore) RestoreSnapshot(ctx context.Context, workspaceID, snapshotID string) (*VMSnapshot, error) {
+	snap, err := s.GetSnapshot(workspaceID, snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.CreateSnapshot(ctx, workspaceID, SnapshotReasonRestore, "before restoring "+snapshotID); err != nil {
+		return nil, err
+	}
+
+	db, err := s.GetDB(workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: restore connection: %w", err)
+	}
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		b, ok := driverConn.(backuper)
+		if !ok {
+			return fmt.Errorf("vmstore: sqlite driver does not support backups")
+		}
+		bck, err := b.NewRestore(s.snapshotPath(workspaceID, snapshotID))
+		if err != nil {
+			return fmt.Errorf("vmstore: start restore: %w", err)
+		}
+		if _, err := bck.Step(-1); err != nil {
+			bck.Finish()
+			return fmt.Errorf("vmstore: restore: %w", quotaError(err))
+		}
+		if err := bck.Finish(); err != nil {
+			return fmt.Errorf("vmstore: finish restore: %w", err)
+		}
+		return nil
+	})
+	// Release the connection first: the migration listener reads the
+	// restored database and the pool may allow a single connection.
+	conn.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	s.notifySnapshot(SnapshotEventRestored, *snap)
+	s.notifyMigrationRestored(ctx, workspaceID)
+	return snap, nil
+}
+
+// notifyMigrationRestored reports the migration state a restore left behind:
+// the restored database carries the snapshot's migration history.
+func (s *VMStore) notifyMigrationRestored(ctx context.Context, workspaceID string) {
+	migrations, err := s.ListMigrations(ctx, workspaceID)
+	if err != nil {
+		log.Printf("[VMStore] read migrations of %s after restore: %v", workspaceID, err)
+		return
+	}
+	event := VMMigrationEvent{Kind: MigrationEventRestored, WorkspaceID: workspaceID}
+	for _, m := range migrations {
+		if m.Status == MigrationStatusApplied && m.Version > event.Migration.Version {
+			event.Migration = m
+		}
+	}
+	s.notifyMigration(event)
+}
+
+// ListSnapshots returns a workspace's snapshots, newest first.
+func (s *VMStore) ListSnapshots(workspaceID string) ([]VMSnapshot, error) {
+	if !s.snapshotsEnabled() {
+		return []VMSnapshot{}, nil
+	}
+	entries, err := os.ReadDir(filepath.Join(s.snapshotDir, workspaceID))
+	if errors.Is(err, os.ErrNotExist) {
+		return []VMSnapshot{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: list snapshots: %w", err)
+	}
+
+	snaps := []VMSnapshot{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".db")
+		if !ok || !snapshotIDPattern.MatchString(id) {
+			continue
+		}
+		snap, err := readSnapshotMeta(s.snapshotPath(workspaceID, id))
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].ID > snaps[j].ID })
+	return snaps, nil
+}
+
+// GetSnapshot returns one snapshot's metadata.
+func (s *VMStore) GetSnapshot(workspaceID, snapshotID string) (*VMSnapshot, error) {
+	if !s.snapshotsEnabled() || !snapshotIDPattern.MatchString(snapshotID) {
+		return nil, ErrSnapshotNotFound
+	}
+	path := s.snapshotPath(workspaceID, snapshotID)
+	if _, err := os.Stat(path); err != nil {
+		return nil, ErrSnapshotNotFound
+	}
+	snap, err := readSnapshotMeta(path)
+	if err != nil {
+		return nil, err
+	}
+	return &snap, nil
+}
+
+// SnapshotFile returns the path of a snapshot's database file for download.
+func (s *VMStore) SnapshotFile(workspaceID, snapshotID string) (string, error) {
+	if _, err := s.GetSnapshot(workspaceID, snapshotID); err != nil {
+		return "", err
+	}
+	return s.snapshotPath(workspaceID, snapshotID), nil
+}
+
+// DeleteSnapshot removes a snapshot and its metadata.
+func (s *VMStore) DeleteSnapshot(workspaceID, snapshotID string) error {
+	if _, err := s.GetSnapshot(workspaceID, snapshotID); err != nil {
+		return err
+	}
+	path := s.snapshotPath(workspaceID, snapshotID)
+	os.Remove(metaPath(path))
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("vmstore: delete snapshot: %w", err)
+	}
+	return nil
+}
+
+// snapshotBefore takes a pre-operation snapshot when snapshots are enabled.
+func (s *VMStore) snapshotBefore(ctx context.Context, workspaceID, reason, label string) error {
+	if !s.snapshotsEnabled() {
+		return nil
+	}
+	if _, err := s.CreateSnapshot(ctx, workspaceID, reason, label); err != nil {
+		return fmt.Errorf("vmstore: snapshot before %s: %w", label, err)
+	}
+	return nil
+}
+
+// pruneSnapshots applies the retention policy to a workspace. MaxCount
+// applies to each reason separately, so frequent scheduled or pre-operation
+// snapshots never push out manual ones.
+func (s *VMStore) pruneSnapshots(workspaceID string) {
+	snaps, err := s.ListSnapshots(workspaceID)
+	if err != nil {
+		return
+	}
+	cutoff := time.Time{}
+	if s.snapshotRetention.MaxAge > 0 {
+		cutoff = time.Now().Add(-s.snapshotRetention.MaxAge)
+	}
+	kept := make(map[string]int)
+	for _, snap := range snaps {
+		kept[snap.Reason]++
+		tooMany := s.snapshotRetention.MaxCount > 0 && kept[snap.Reason] > s.snapshotRetention.MaxCount
+		tooOld := !cutoff.IsZero() && snap.CreatedAt.Before(cutoff)
+		if tooMany || tooOld {
+			s.DeleteSnapshot(workspaceID, snap.ID)
+		}
+	}
+}
+
+func (s *VMStore) notifySnapshot(event string, snap VMSnapshot) {
+	s.mu.RLock()
+	listener := s.snapshotListener
+	s.mu.RUnlock()
+	if listener != nil {
+		listener(event, snap)
+	}
+}
+
+// runSnapshotScheduler snapshots every workspace database modified since its
+// latest snapshot, once per interval, until the store is closed.
+func (s *VMStore) runSnapshotScheduler(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.SnapshotChanged(context.Background())
+		}
+	}
+}
+
+// SnapshotChanged takes a scheduled snapshot of each workspace database
+// written to since its latest snapshot, judged by file mtimes; a WAL
+// checkpoint on handle close also counts. Returns the number taken.
+func (s *VMStore) SnapshotChanged(ctx context.Context) int {
+	entries, err := os.ReadDir(s.baseDir)
+	if err != nil {
+		return 0
+	}
+	taken := 0
+	for _, entry := range entries {
+		workspaceID, ok := strings.CutSuffix(entry.Name(), ".db")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		modified := lastModified(s.DBPath(workspaceID))
+		snaps, err := s.ListSnapshots(workspaceID)
+		if err != nil || (len(snaps) > 0 && !modified.After(snaps[0].CreatedAt)) {
+			continue
+		}
+		if _, err := s.CreateSnapshot(ctx, workspaceID, SnapshotReasonScheduled, ""); err != nil {
+			log.Printf("[VMStore] scheduled snapshot of %s failed: %v", workspaceID, err)
+			continue
+		}
+		taken++
+	}
+	return taken
+}
+
+// lastModified returns the latest mtime of a database and its WAL.
+func lastModified(dbPath string) time.Time {
+	var latest time.Time
+	for _, suffix := range []string{"", "-wal"} {
+		if info, err := os.Stat(dbPath + suffix); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func metaPath(snapshotPath string) string {
+	return strings.TrimSuffix(snapshotPath, ".db") + ".json"
+}
+
+func writeSnapshotMeta(snapshotPath string, snap VMSnapshot) error {
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(metaPath(snapshotPath), data, 0644); err != nil {
+		return fmt.Errorf("vmstore: write snapshot metadata: %w", err)
+	}
+	return nil
+}
+
+func readSnapshotMeta(snapshotPath string) (VMSnapshot, error) {
+	var snap VMSnapshot
+	data, err := os.ReadFile(metaPath(snapshotPath))
+	if err != nil {
+		return snap, fmt.Errorf("vmstore: read snapshot metadata: %w", err)
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("vmstore: parse snapshot metadata: %w", err)
+	}
+	return snap, nil
+}
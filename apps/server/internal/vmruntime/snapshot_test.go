@@ -0,0 +1,237 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func countRows(t *testing.T, store *VMStore, wsID, table string) int {
+	t.Helper()
+	db, err := store.GetDB(wsID)
+	if err != nil {
+		t.Fatalf("GetDB: %v", err)
+	}
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+		t.Fatalf("count %s: %v", table, err)
+	}
+	return n
+}
+
+func TestVMStore_SnapshotAndRestore(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-snap"
+
+	var mu sync.Mutex
+	var events []string
+	store.SetSnapshotListener(func(event string, snap VMSnapshot) {
+		mu.Lock()
+		events = append(events, event+":"+snap.Reason)
+		mu.Unlock()
+	})
+	var migrationRestores int
+	store.SetMigrationListener(func(ev VMMigrationEvent) {
+		if ev.Kind == MigrationEventRestored && ev.WorkspaceID == wsID {
+			mu.Lock()
+			migrationRestores++
+			mu.Unlock()
+		}
+	})
+
+	store.ExecuteSQL(ctx, wsID, `CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)`)
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO notes (body) VALUES ('a'), ('b')`)
+
+	snap, err := store.CreateSnapshot(ctx, wsID, SnapshotReasonManual, "two notes")
+	if err != nil {
+		t.Fatalf("CreateSnapshot: %v", err)
+	}
+	if snap.SizeBytes == 0 || snap.Label != "two notes" {
+		t.Fatalf("snapshot = %+v", snap)
+	}
+
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO notes (body) VALUES ('c')`)
+	if n := countRows(t, store, wsID, "notes"); n != 3 {
+		t.Fatalf("rows before restore = %d, want 3", n)
+	}
+
+	if _, err := store.RestoreSnapshot(ctx, wsID, snap.ID); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if n := countRows(t, store, wsID, "notes"); n != 2 {
+		t.Fatalf("rows after restore = %d, want 2", n)
+	}
+
+	snaps, err := store.ListSnapshots(wsID)
+	if err != nil {
+		t.Fatalf("ListSnapshots: %v", err)
+	}
+	if len(snaps) != 2 || snaps[0].Reason != SnapshotReasonRestore || snaps[1].ID != snap.ID {
+		t.Fatalf("snapshots = %+v, want pre_restore then manual", snaps)
+	}
+
+	// Undo the restore with the pre_restore snapshot.
+	if _, err := store.RestoreSnapshot(ctx, wsID, snaps[0].ID); err != nil {
+		t.Fatalf("RestoreSnapshot(pre_restore): %v", err)
+	}
+	if n := countRows(t, store, wsID, "notes"); n != 3 {
+		t.Fatalf("rows after undo = %d, want 3", n)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if migrationRestores != 2 {
+		t.Fatalf("migration restored events = %d, want 2", migrationRestores)
+	}
+	want := []string{"created:manual", "created:pre_restore", "restored:manual", "created:pre_restore", "restored:pre_restore"}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("events = %v, want %v", events, want)
+		}
+	}
+}
+
+func TestVMStore_SnapshotConcurrentWrites(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-snap-concurrent"
+	store.ExecuteSQL(ctx, wsID, `CREATE TABLE hits (id INTEGER PRIMARY KEY, n INTEGER)`)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			if _, err := store.InsertRow(ctx, wsID, "hits", map[string]interface{}{"n": i}); err != nil {
+				t.Errorf("InsertRow: %v", err)
+				return
+			}
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if _, err := store.CreateSnapshot(ctx, wsID, SnapshotReasonManual, ""); err != nil {
+			t.Fatalf("CreateSnapshot during writes: %v", err)
+		}
+	}
+	<-done
+}
+
+func TestVMStore_DropTableTakesSnapshot(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-snap-drop"
+
+	store.ExecuteSQL(ctx, wsID, `CREATE TABLE temp (id INTEGER PRIMARY KEY)`)
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO temp (id) VALUES (1)`)
+
+	// Dropping a missing table does not snapshot.
+	if err := store.DropTable(ctx, wsID, "missing"); !errors.Is(err, ErrTableNotFound) {
+		t.Fatalf("DropTable(missing) = %v, want ErrTableNotFound", err)
+	}
+	if snaps, _ := store.ListSnapshots(wsID); len(snaps) != 0 {
+		t.Fatalf("snapshots after missing-table drop = %d, want 0", len(snaps))
+	}
+
+	if err := store.DropTable(ctx, wsID, "temp"); err != nil {
+		t.Fatalf("DropTable: %v", err)
+	}
+	snaps, _ := store.ListSnapshots(wsID)
+	if len(snaps) != 1 || snaps[0].Reason != SnapshotReasonDropTable {
+		t.Fatalf("snapshots = %+v, want one pre_drop_table", snaps)
+	}
+
+	if _, err := store.RestoreSnapshot(ctx, wsID, snaps[0].ID); err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if n := countRows(t, store, wsID, "temp"); n != 1 {
+		t.Fatalf("rows after restore = %d, want 1", n)
+	}
+}
+
+func TestVMStore_SnapshotRetention(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{
+		BaseDir:           t.TempDir(),
+		SnapshotRetention: VMSnapshotRetention{MaxCount: 2},
+	})
+	defer store.Close()
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		snap, err := store.CreateSnapshot(ctx, "ws-retention", SnapshotReasonManual, "")
+		if err != nil {
+			t.Fatalf("CreateSnapshot: %v", err)
+		}
+		ids = append(ids, snap.ID)
+	}
+	snaps, _ := store.ListSnapshots("ws-retention")
+	if len(snaps) != 2 || snaps[0].ID != ids[3] || snaps[1].ID != ids[2] {
+		t.Fatalf("snapshots = %+v, want the newest two", snaps)
+	}
+	if _, err := os.Stat(store.snapshotPath("ws-retention", ids[0])); !os.IsNotExist(err) {
+		t.Fatalf("pruned snapshot file still present: %v", err)
+	}
+
+	// Scheduled snapshots are counted on their own and never push out manual ones.
+	for i := 0; i < 3; i++ {
+		if _, err := store.CreateSnapshot(ctx, "ws-retention", SnapshotReasonScheduled, ""); err != nil {
+			t.Fatalf("CreateSnapshot(scheduled): %v", err)
+		}
+	}
+	snaps, _ = store.ListSnapshots("ws-retention")
+	byReason := map[string]int{}
+	for _, snap := range snaps {
+		byReason[snap.Reason]++
+	}
+	if byReason[SnapshotReasonManual] != 2 || byReason[SnapshotReasonScheduled] != 2 {
+		t.Fatalf("snapshots by reason = %v, want 2 manual and 2 scheduled", byReason)
+	}
+}
+
+func TestVMStore_SnapshotChanged(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	store.ExecuteSQL(ctx, "ws-a", `CREATE TABLE t (id INTEGER)`)
+	store.ExecuteSQL(ctx, "ws-b", `CREATE TABLE t (id INTEGER)`)
+
+	// Closing a handle checkpoints the WAL, which counts as a write.
+	store.CloseDB("ws-b")
+	if n := store.SnapshotChanged(ctx); n != 2 {
+		t.Fatalf("first pass took %d snapshots, want 2", n)
+	}
+	// Snapshotting reads the database read-only and never bumps its mtime.
+	if n := store.SnapshotChanged(ctx); n != 0 {
+		t.Fatalf("unchanged pass took %d snapshots, want 0", n)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	store.ExecuteSQL(ctx, "ws-a", `INSERT INTO t (id) VALUES (1)`)
+	if n := store.SnapshotChanged(ctx); n != 1 {
+		t.Fatalf("pass after write took %d snapshots, want 1", n)
+	}
+}
+
+func TestVMStore_SnapshotNotFound(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+
+	for _, id := range []string{"missing", "../ws-x", "20250101T000000.000000000Z"} {
+		if _, err := store.GetSnapshot("ws-x", id); !errors.Is(err, ErrSnapshotNotFound) {
+			t.Errorf("GetSnapshot(%q) err = %v, want ErrSnapshotNotFound", id, err)
+		}
+		if err := store.DeleteSnapshot("ws-x", id); !errors.Is(err, ErrSnapshotNotFound) {
+			t.Errorf("DeleteSnapshot(%q) err = %v, want ErrSnapshotNotFound", id, err)
+		}
+	}
+}
@@ -1,35 +1,82 @@
 package vmruntime
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	_ "modernc.org/sqlite"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 )
 
+// ErrDBQuotaExceeded is returned when a write would grow a workspace database
+// past VMStoreOptions.MaxDBSize.
+var ErrDBQuotaExceeded = errors.New("vmstore: workspace database size quota exceeded")
+
+// sqlitePageSize is SQLite's default page size, used to turn MaxDBSize into
+// a max_page_count.
+const sqlitePageSize = 4096
+
 // VMStore manages per-workspace SQLite database connections.
 type VMStore struct {
-	mu      sync.RWMutex
-	baseDir string
-	dbs     map[string]*sql.DB
+	mu        sync.RWMutex
+	baseDir   string
+	maxDBSize int64
+	dbs       map[string]*sql.DB
+	lastUsed  map[string]*atomic.Int64 // unix nanos of the last GetDB or release
+	refs      map[string]*atomic.Int64 // executions currently using the handle
+
+	snapshotDir       string
+	snapshotRetention VMSnapshotRetention
+	snapshotListener  VMSnapshotListener
+	snapshotMu        sync.Mutex // serializes snapshot creation and pruning
+
+	migrationListener VMMigrationListener
+
+	changeListener VMChangeListener
+	changesEnabled atomic.Bool
+	changeOnce     sync.Once // starts the change dispatcher
+	changeQueue    chan changeBatch
+
+	stop     chan struct{}
+	stopOnce sync.Once
 }
 
-// NewVMStore creates a new VMStore with the given base directory for SQLite files.
-func NewVMStore(baseDir string) *VMStore {
-	return &VMStore{
-		baseDir: baseDir,
-		dbs:     make(map[string]*sql.DB),
+// NewVMStore creates a new VMStore.
+// A positive SnapshotInterval starts the snapshot scheduler, which runs until
+// Close.
+func NewVMStore(opts VMStoreOptions) *VMStore {
+	s := &VMStore{
+		baseDir:           opts.BaseDir,
+		maxDBSize:         opts.MaxDBSize,
+		dbs:               make(map[string]*sql.DB),
+		lastUsed:          make(map[string]*atomic.Int64),
+		refs:              make(map[string]*atomic.Int64),
+		snapshotDir:       opts.SnapshotDir,
+		snapshotRetention: opts.SnapshotRetention,
+		changeQueue:       make(chan changeBatch, changeQueueSize),
+		stop:              make(chan struct{}),
+	}
+	if s.snapshotDir == "" {
+		s.snapshotDir = filepath.Join(s.baseDir, "snapshots")
 	}
+	if opts.SnapshotInterval > 0 && s.snapshotsEnabled() {
+		go s.runSnapshotScheduler(opts.SnapshotInterval)
+	}
+	return s
 }
 
 // GetDB returns the SQLite *sql.DB for a workspace, creating the file if needed.
 func (s *VMStore) GetDB(workspaceID string) (*sql.DB, error) {
 	s.mu.RLock()
 	if db, ok := s.dbs[workspaceID]; ok {
+		s.lastUsed[workspaceID].Store(time.Now().UnixNano())
 		s.mu.RUnlock()
 		return db, nil
 	}
@@ -39,6 +86,7 @@ func (s *VMStore) GetDB(workspaceID string) (*sql.DB, error) {
 	defer s.mu.Unlock()
 
 	if db, ok := s.dbs[workspaceID]; ok {
+		s.lastUsed[workspaceID].Store(time.Now().UnixNano())
 		return db, nil
 	}
 
@@ -48,23 +96,84 @@ func (s *VMStore) GetDB(workspaceID string) (*sql.DB, error) {
 	}
 
 	dsn := fmt.Sprintf("file:%s?_journal_mode=WAL&_busy_timeout=5000&_foreign_keys=on", dbPath)
-	db, err := sql.Open("sqlite", dsn)
-	if err != nil {
-		return nil, fmt.Errorf("vmstore: failed to open sqlite: %w", err)
+	if s.maxDBSize > 0 {
+		// Applied by the driver on every new connection.
+		dsn += fmt.Sprintf("&_pragma=max_page_count(%d)", maxPageCount(s.maxDBSize))
 	}
+	// The connector captures row changes for SetChangeListener.
+	db := sql.OpenDB(&vmConnector{store: s, workspaceID: workspaceID, dsn: dsn})
 
 	db.SetMaxOpenConns(1)
 	db.SetMaxIdleConns(1)
 
 	if err := configurePragmas(db); err != nil {
-		db.Close()
+		closeDB(db)
 		return nil, fmt.Errorf("vmstore: failed to configure pragmas: %w", err)
 	}
 
 	s.dbs[workspaceID] = db
+	used := &atomic.Int64{}
+	used.Store(time.Now().UnixNano())
+	s.lastUsed[workspaceID] = used
+	s.refs[workspaceID] = &atomic.Int64{}
 	return db, nil
 }
 
+// retainDB marks db as in use by an execution so that EvictIdle keeps it
+// open until release is called. It reports false if db is no longer the
+// workspace's open handle.
+func (s *VMStore) retainDB(workspaceID string, db *sql.DB) (release func(), ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.dbs[workspaceID] != db {
+		return nil, false
+	}
+	// Taken under the read lock, so EvictIdle either sees the reference or
+	// has already closed the handle and removed it.
+	refs, used := s.refs[workspaceID], s.lastUsed[workspaceID]
+	refs.Add(1)
+	return func() {
+		used.Store(time.Now().UnixNano())
+		refs.Add(-1)
+	}, true
+}
+
+// maxPageCount converts a byte quota into a page count of at least 1.
+func maxPageCount(maxBytes int64) int64 {
+	if pages := maxBytes / sqlitePageSize; pages > 0 {
+		return pages
+	}
+	return 1
+}
+
+// quotaError maps SQLite's "database or disk is full" to ErrDBQuotaExceeded.
+func quotaError(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_FULL {
+		return fmt.Errorf("%w: %v", ErrDBQuotaExceeded, err)
+	}
+	return err
+}
+
+// EvictIdle closes database handles not used for longer than idle, skipping
+// workspaces in keep and handles retained by running executions. Returns the
+// number of handles closed.
+func (s *VMStore) EvictIdle(idle time.Duration, keep map[string]bool) int {
+	cutoff := time.Now().Add(-idle).UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	evicted := 0
+	for id, db := range s.dbs {
+		if keep[id] || s.lastUsed[id].Load() > cutoff || s.refs[id].Load() > 0 {
+			continue
+		}
+		closeDB(db)
+		s.forget(id)
+		evicted++
+	}
+	return evicted
+}
+
 // configurePragmas sets SQLite PRAGMA options for performance and safety.
 func configurePragmas(db *sql.DB) error {
 	pragmas := []string{
@@ -82,13 +191,15 @@ func configurePragmas(db *sql.DB) error {
 	return nil
 }
 
-// Close closes all cached database connections.
+// Close stops the snapshot scheduler and closes all cached database
+// connections.
 func (s *VMStore) Close() {
+	s.stopOnce.Do(func() { close(s.stop) })
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	for id, db := range s.dbs {
-		db.Close()
-		delete(s.dbs, id)
+		closeDB(db)
+		s.forget(id)
 	}
 }
 
@@ -97,13 +208,20 @@ func (s *VMStore) CloseDB(workspaceID string) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if db, ok := s.dbs[workspaceID]; ok {
-		err := db.Close()
-		delete(s.dbs, workspaceID)
+		err := closeDB(db)
+		s.forget(workspaceID)
 		return err
 	}
 	return nil
 }
 
+// forget drops a closed handle's bookkeeping. Must be called with s.mu held.
+func (s *VMStore) forget(workspaceID string) {
+	delete(s.dbs, workspaceID)
+	delete(s.lastUsed, workspaceID)
+	delete(s.refs, workspaceID)
+}
+
 // Exists checks whether a SQLite file exists for the given workspace.
 func (s *VMStore) Exists(workspaceID string) bool {
 	_, err := os.Stat(s.DBPath(workspaceID))
@@ -127,35 +245,14 @@ func (s *VMStore) DBPath(workspaceID string) string {
 	return filepath.Join(s.baseDir, workspaceID+".db")
 }
 
-// BackupTo copies the SQLite database file to the destination path.
+// BackupTo writes a consistent copy of the workspace database to destPath
+// using SQLite's online backup API, without blocking concurrent writers.
 func (s *VMStore) BackupTo(workspaceID, destPath string) error {
-	srcPath := s.DBPath(workspaceID)
-
-	db, err := s.GetDB(workspaceID)
-	if err != nil {
+	if _, err := s.GetDB(workspaceID); err != nil {
 		return fmt.Errorf("vmstore: backup get db: %w", err)
 	}
-	if _, err := db.Exec("PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
-		return fmt.Errorf("vmstore: wal checkpoint: %w", err)
-	}
-
-	src, err := os.Open(srcPath)
-	if err != nil {
-		return fmt.Errorf("vmstore: open source: %w", err)
-	}
-	defer src.Close()
-
 	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
 		return fmt.Errorf("vmstore: create dest dir: %w", err)
 	}
-	dst, err := os.Create(destPath)
-	if err != nil {
-		return fmt.Errorf("vmstore: create dest: %w", err)
-	}
-	defer dst.Close()
-
-	if _, err := io.Copy(dst, src); err != nil {
-		return fmt.Errorf("vmstore: copy file: %w", err)
-	}
-	return nil
+	return s.backupTo(context.Background(), workspaceID, destPath)
 }
@@ -0,0 +1,581 @@
+package vmruntime
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Data-loss reasons reported in VMDataLoss.Reason.
+const (
+	DataLossNotNull  = "not_null"  // NULLs in a column made NOT NULL without a default
+	DataLossTypeCast = "type_cast" // values the cast to the new type changes
+)
+
+// VMDataLossError is returned when an alteration would lose data and the
+// request did not set AllowDataLoss. Nothing has been changed.
+type VMDataLossError struct {
+	Plan *VMAlterPlan
+}
+
+func (e *VMDataLossError) Error() string {
+	parts := make([]string, len(e.Plan.DataLoss))
+	for i, loss := range e.Plan.DataLoss {
+		parts[i] = fmt.Sprintf("%s: %d rows (%s)", loss.Column, loss.Rows, loss.Reason)
+	}
+	return fmt.Sprintf("vmstore: altering %q would lose data (%s); set allow_data_loss to proceed",
+		e.Plan.Table, strings.Join(parts, ", "))
+}
+
+// rebuildTablePrefix names the temporary table a rebuild copies into.
+const rebuildTablePrefix = "_rebuild_"
+
+var (
+	columnTypePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_ ]*(\(\s*\d+\s*(,\s*\d+\s*)?\))?$`)
+	autoincrementDDL  = regexp.MustCompile(`(?i)\bAUTOINCREMENT\b`)
+	// Column defaults are spliced into DDL, so only literals are accepted:
+	// numbers, quoted strings, blobs and the keyword constants.
+	defaultValuePattern = regexp.MustCompile(`(?i)^(` +
+		`[+-]?(\d+(\.\d*)?|\.\d+)(e[+-]?\d+)?|0x[0-9a-f]+|` +
+		`'([^']|'')*'|x'([0-9a-f]{2})*'|` +
+		`NULL|TRUE|FALSE|CURRENT_TIMESTAMP|CURRENT_DATE|CURRENT_TIME)$`)
+	// Table features a rebuild cannot reconstruct from PRAGMA output.
+	unsupportedRebuildDDL = regexp.MustCompile(`(?i)\bCHECK\s*\(|\bGENERATED\s+ALWAYS\b|\bAS\s*\(|\bCOLLATE\b`)
+)
+
+// defaultClause returns the DEFAULT clause for a column default, rejecting
+// anything that is not a single SQL literal.
+func defaultClause(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if !defaultValuePattern.MatchString(value) {
+		return "", fmt.Errorf("vmstore: invalid default value %q: use a number, a quoted string, NULL, TRUE, FALSE or CURRENT_TIMESTAMP", value)
+	}
+	return " DEFAULT " + value, nil
+}
+
+// AlterTable alters an existing table in the workspace's SQLite database.
+// A snapshot is taken first; the alteration is aborted if that fails. All
+// changes run in one transaction. Type, nullability and default changes use
+// SQLite's table rebuild; if it would lose data and req.AllowDataLoss is not
+// set, a *VMDataLossError carrying the plan is returned.
+func (s *VMStore) AlterTable(ctx context.Context, workspaceID, tableName string, req VMAlterTableRequest) (*VMAlterPlan, error) {
+	if _, err := s.GetDB(workspaceID); err != nil {
+		return nil, err
+	}
+	if err := s.snapshotBefore(ctx, workspaceID, SnapshotReasonAlterTable, "alter table "+tableName); err != nil {
+		return nil, err
+	}
+	return s.alterTable(ctx, workspaceID, tableName, req, false)
+}
+
+// PreviewAlterTable runs the alteration in a transaction that is rolled back
+// and returns the generated statements with the data-loss report.
+func (s *VMStore) PreviewAlterTable(ctx context.Context, workspaceID, tableName string, req VMAlterTableRequest) (*VMAlterPlan, error) {
+	return s.alterTable(ctx, workspaceID, tableName, req, true)
+}
+
+func (s *VMStore) alterTable(ctx context.Context, workspaceID, tableName string, req VMAlterTableRequest, dryRun bool) (*VMAlterPlan, error) {
+	plan := &VMAlterPlan{Table: tableName, Statements: []string{}, DataLoss: []VMDataLoss{}}
+	var rebuild []VMAlterColumnDef
+	for _, col := range req.AlterColumns {
+		if col.Type != "" || col.Nullable != nil || col.DefaultValue != nil {
+			rebuild = append(rebuild, col)
+		}
+	}
+	plan.Rebuild = len(rebuild) > 0
+
+	// A rebuild runs with foreign keys deferred to a check before commit.
+	err := s.changeSchema(ctx, workspaceID, "alter table "+tableName, plan.Rebuild, dryRun, func(c *schemaChange) ([]string, error) {
+		defer func() { plan.Statements = append(plan.Statements, c.statements...) }()
+
+		before, err := readTableObjects(ctx, c.tx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		if before == nil {
+			return nil, fmt.Errorf("vmstore: no such table: %s", tableName)
+		}
+		current := tableName
+
+		// Rename table
+		if req.Rename != "" {
+			if err := c.exec(fmt.Sprintf("ALTER TABLE %q RENAME TO %q", current, req.Rename)); err != nil {
+				return nil, fmt.Errorf("vmstore: rename table: %w", err)
+			}
+			current = req.Rename
+		}
+
+		// Add columns
+		for _, col := range req.AddColumns {
+			def := fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s", current, col.Name, mapColumnType(col.Type))
+			if !col.Nullable {
+				def += " NOT NULL"
+			}
+			if col.DefaultValue != nil {
+				clause, err := defaultClause(*col.DefaultValue)
+				if err != nil {
+					return nil, err
+				}
+				def += clause
+			}
+			if err := c.exec(def); err != nil {
+				return nil, fmt.Errorf("vmstore: add column %q: %w", col.Name, err)
+			}
+		}
+
+		// Rename columns (SQLite 3.25+)
+		for _, col := range req.AlterColumns {
+			if col.NewName != "" && col.NewName != col.Name {
+				if err := c.exec(fmt.Sprintf("ALTER TABLE %q RENAME COLUMN %q TO %q", current, col.Name, col.NewName)); err != nil {
+					return nil, fmt.Errorf("vmstore: rename column %q: %w", col.Name, err)
+				}
+			}
+		}
+
+		// Change column types, nullability and defaults
+		if plan.Rebuild {
+			if err := rebuildTable(ctx, c.tx, current, rebuild, plan, c.exec); err != nil {
+				return nil, err
+			}
+		}
+
+		// Drop columns (SQLite 3.35+)
+		for _, colName := range req.DropColumns {
+			if err := c.exec(fmt.Sprintf("ALTER TABLE %q DROP COLUMN %q", current, colName)); err != nil {
+				return nil, fmt.Errorf("vmstore: drop column %q: %w", colName, err)
+			}
+		}
+
+		if len(plan.DataLoss) > 0 && !req.AllowDataLoss && !dryRun {
+			return nil, &VMDataLossError{Plan: plan}
+		}
+		return alterDownStatements(ctx, c.tx, before, current, req, plan.Rebuild)
+	})
+	if err != nil {
+		var lossErr *VMDataLossError
+		if errors.As(err, &lossErr) {
+			return plan, err
+		}
+		return nil, err
+	}
+	plan.Applied = !dryRun
+	return plan, nil
+}
+
+// alterDownStatements undo an alteration of before, whose table is now named
+// current. Renames are reversed in place; retyped or dropped columns need a
+// rebuild into the old definition, after which the old indexes and triggers
+// are recreated.
+func alterDownStatements(ctx context.Context, tx *sql.Tx, before *tableObjects, current string, req VMAlterTableRequest, rebuilt bool) ([]string, error) {
+	dropped := make(map[string]bool, len(req.DropColumns))
+	for _, name := range req.DropColumns {
+		dropped[name] = true
+	}
+
+	var down []string
+	renamed := make(map[string]string)
+	for i := len(req.AlterColumns) - 1; i >= 0; i-- {
+		col := req.AlterColumns[i]
+		if col.NewName == "" || col.NewName == col.Name || dropped[col.NewName] {
+			continue
+		}
+		down = append(down, fmt.Sprintf("ALTER TABLE %q RENAME COLUMN %q TO %q", current, col.NewName, col.Name))
+		renamed[col.NewName] = col.Name
+	}
+
+	restore := rebuilt || len(req.DropColumns) > 0
+	if restore {
+		stmts, err := before.restoreStatements(ctx, tx, current, renamed)
+		if err != nil {
+			return nil, err
+		}
+		down = append(down, stmts...)
+	} else {
+		for i := len(req.AddColumns) - 1; i >= 0; i-- {
+			down = append(down, fmt.Sprintf("ALTER TABLE %q DROP COLUMN %q", current, req.AddColumns[i].Name))
+		}
+	}
+	if current != before.name {
+		down = append(down, fmt.Sprintf("ALTER TABLE %q RENAME TO %q", current, before.name))
+	}
+	if restore {
+		down = append(down, before.indexes...)
+		down = append(down, before.triggers...)
+	}
+	return down, nil
+}
+
+// tableColumn is one row of PRAGMA table_info.
+type tableColumn struct {
+	name    string
+	typ     string
+	notNull bool
+	dflt    sql.NullString
+	pk      int
+}
+
+// rebuildTable applies column changes SQLite cannot make in place: it creates
+// a new table with the altered definition, copies the rows with casts, drops
+// the old table, renames the new one and recreates indexes and triggers.
+// Rows that violate a new NOT NULL are not copied; both they and lossy casts
+// are added to plan.DataLoss.
+func rebuildTable(ctx context.Context, tx *sql.Tx, table string, alters []VMAlterColumnDef, plan *VMAlterPlan, exec func(string) error) error {
+	var ddl string
+	err := tx.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type='table' AND name=?", table).Scan(&ddl)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("vmstore: no such table: %s", table)
+	}
+	if err != nil {
+		return fmt.Errorf("vmstore: read table ddl: %w", err)
+	}
+	if unsupportedRebuildDDL.MatchString(ddl) {
+		return fmt.Errorf("vmstore: cannot alter columns of %q: CHECK, COLLATE and generated columns are not supported", table)
+	}
+
+	columns, err := tableColumns(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*tableColumn, len(columns))
+	for i := range columns {
+		byName[columns[i].name] = &columns[i]
+	}
+
+	type change struct {
+		typeChanged bool
+		nowNotNull  bool
+	}
+	changes := make(map[string]change)
+	for _, alter := range alters {
+		name := alter.Name
+		if alter.NewName != "" {
+			name = alter.NewName
+		}
+		col, ok := byName[name]
+		if !ok {
+			return fmt.Errorf("vmstore: no such column: %s", name)
+		}
+		ch := changes[name]
+		if alter.Type != "" {
+			typ := mapColumnType(alter.Type)
+			if !columnTypePattern.MatchString(typ) {
+				return fmt.Errorf("vmstore: invalid column type %q", alter.Type)
+			}
+			ch.typeChanged = !strings.EqualFold(typ, col.typ)
+			col.typ = typ
+		}
+		if alter.Nullable != nil {
+			ch.nowNotNull = !*alter.Nullable && !col.notNull
+			col.notNull = !*alter.Nullable
+		}
+		if alter.DefaultValue != nil {
+			if _, err := defaultClause(*alter.DefaultValue); err != nil {
+				return err
+			}
+			col.dflt = sql.NullString{String: strings.TrimSpace(*alter.DefaultValue), Valid: true}
+		}
+		changes[name] = ch
+	}
+
+	var pkCols []tableColumn
+	for _, col := range columns {
+		if col.pk > 0 {
+			pkCols = append(pkCols, col)
+		}
+	}
+	sort.Slice(pkCols, func(i, j int) bool { return pkCols[i].pk < pkCols[j].pk })
+	rowidPK := len(pkCols) == 1 && strings.EqualFold(pkCols[0].typ, "INTEGER")
+
+	var defs, names, exprs, filters []string
+	for _, col := range columns {
+		def := fmt.Sprintf("%q", col.name)
+		if col.typ != "" {
+			def += " " + col.typ
+		}
+		if rowidPK && col.pk > 0 {
+			def += " PRIMARY KEY"
+			if autoincrementDDL.MatchString(ddl) {
+				def += " AUTOINCREMENT"
+			}
+		}
+		if col.notNull {
+			def += " NOT NULL"
+		}
+		if col.dflt.Valid {
+			def += " DEFAULT " + col.dflt.String
+		}
+		defs = append(defs, def)
+
+		quoted := fmt.Sprintf("%q", col.name)
+		expr := quoted
+		ch := changes[col.name]
+		if ch.typeChanged && col.typ != "" {
+			expr = fmt.Sprintf("CAST(%s AS %s)", quoted, col.typ)
+			lost, err := countMatching(ctx, tx, fmt.Sprintf(
+				"SELECT COUNT(*) FROM %q WHERE %s IS NOT NULL AND CAST(%s AS TEXT) IS NOT CAST(%s AS TEXT)",
+				table, quoted, expr, quoted))
+			if err != nil {
+				return fmt.Errorf("vmstore: check cast of %q: %w", col.name, err)
+			}
+			if lost > 0 {
+				plan.DataLoss = append(plan.DataLoss, VMDataLoss{Column: col.name, Reason: DataLossTypeCast, Rows: lost})
+			}
+		}
+		if ch.nowNotNull {
+			if col.dflt.Valid {
+				expr = fmt.Sprintf("COALESCE(%s, %s)", expr, col.dflt.String)
+			} else {
+				lost, err := countMatching(ctx, tx, fmt.Sprintf("SELECT COUNT(*) FROM %q WHERE %s IS NULL", table, quoted))
+				if err != nil {
+					return fmt.Errorf("vmstore: check nulls of %q: %w", col.name, err)
+				}
+				if lost > 0 {
+					plan.DataLoss = append(plan.DataLoss, VMDataLoss{Column: col.name, Reason: DataLossNotNull, Rows: lost})
+					filters = append(filters, quoted+" IS NOT NULL")
+				}
+			}
+		}
+		names = append(names, quoted)
+		exprs = append(exprs, expr)
+	}
+
+	if len(pkCols) > 0 && !rowidPK {
+		pkNames := make([]string, len(pkCols))
+		for i, col := range pkCols {
+			pkNames[i] = col.name
+		}
+		defs = append(defs, fmt.Sprintf("PRIMARY KEY (%s)", quoteColumns(pkNames)))
+	}
+
+	uniques, indexSQL, err := tableIndexes(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	for _, cols := range uniques {
+		defs = append(defs, fmt.Sprintf("UNIQUE (%s)", quoteColumns(cols)))
+	}
+	foreignKeys, err := tableForeignKeys(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	defs = append(defs, foreignKeys...)
+
+	var triggerSQL []string
+	rows, err := tx.QueryContext(ctx, "SELECT sql FROM sqlite_master WHERE type='trigger' AND tbl_name=? AND sql IS NOT NULL", table)
+	if err != nil {
+		return fmt.Errorf("vmstore: read triggers: %w", err)
+	}
+	for rows.Next() {
+		var stmt string
+		if err := rows.Scan(&stmt); err != nil {
+			rows.Close()
+			return fmt.Errorf("vmstore: read triggers: %w", err)
+		}
+		triggerSQL = append(triggerSQL, stmt)
+	}
+	rows.Close()
+
+	var seq sql.NullInt64
+	if autoincrementDDL.MatchString(ddl) {
+		tx.QueryRowContext(ctx, "SELECT seq FROM sqlite_sequence WHERE name=?", table).Scan(&seq)
+	}
+
+	tmp := rebuildTablePrefix + table
+	copySQL := fmt.Sprintf("INSERT INTO %q (%s) SELECT %s FROM %q", tmp, strings.Join(names, ", "), strings.Join(exprs, ", "), table)
+	if len(filters) > 0 {
+		copySQL += " WHERE " + strings.Join(filters, " AND ")
+	}
+	steps := []string{
+		fmt.Sprintf("CREATE TABLE %q (\n  %s\n)", tmp, strings.Join(defs, ",\n  ")),
+		copySQL,
+		fmt.Sprintf("DROP TABLE %q", table),
+		fmt.Sprintf("ALTER TABLE %q RENAME TO %q", tmp, table),
+	}
+	for _, stmt := range steps {
+		if err := exec(stmt); err != nil {
+			return fmt.Errorf("vmstore: rebuild %q: %w", table, err)
+		}
+	}
+
+	if seq.Valid {
+		stmt := fmt.Sprintf("UPDATE sqlite_sequence SET seq = MAX(seq, %d) WHERE name = '%s'", seq.Int64, strings.ReplaceAll(table, "'", "''"))
+		if err := exec(stmt); err != nil {
+			return fmt.Errorf("vmstore: rebuild %q: %w", table, err)
+		}
+	}
+	for _, stmt := range append(indexSQL, triggerSQL...) {
+		if err := exec(stmt); err != nil {
+			return fmt.Errorf("vmstore: rebuild %q: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func tableColumns(ctx context.Context, tx *sql.Tx, table string) ([]tableColumn, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA table_info(%q)", table))
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: read columns: %w", err)
+	}
+	defer rows.Close()
+	var columns []tableColumn
+	for rows.Next() {
+		var cid int
+		var col tableColumn
+		if err := rows.Scan(&cid, &col.name, &col.typ, &col.notNull, &col.dflt, &col.pk); err != nil {
+			return nil, fmt.Errorf("vmstore: read columns: %w", err)
+		}
+		columns = append(columns, col)
+	}
+	return columns, rows.Err()
+}
+
+// tableIndexes returns the columns of each UNIQUE constraint and the SQL of
+// each explicitly created index.
+func tableIndexes(ctx context.Context, tx *sql.Tx, table string) ([][]string, []string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA index_list(%q)", table))
+	if err != nil {
+		return nil, nil, fmt.Errorf("vmstore: read indexes: %w", err)
+	}
+	type indexEntry struct {
+		name   string
+		origin string
+	}
+	var entries []indexEntry
+	for rows.Next() {
+		var seq, unique, partial int
+		var e indexEntry
+		if err := rows.Scan(&seq, &e.name, &unique, &e.origin, &partial); err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("vmstore: read indexes: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	rows.Close()
+
+	var uniques [][]string
+	var created []string
+	for _, e := range entries {
+		switch e.origin {
+		case "u":
+			cols, err := indexColumns(ctx, tx, e.name)
+			if err != nil {
+				return nil, nil, err
+			}
+			uniques = append(uniques, cols)
+		case "c":
+			var stmt string
+			if err := tx.QueryRowContext(ctx, "SELECT sql FROM sqlite_master WHERE type='index' AND name=?", e.name).Scan(&stmt); err != nil {
+				return nil, nil, fmt.Errorf("vmstore: read index %q: %w", e.name, err)
+			}
+			created = append(created, stmt)
+		}
+	}
+	// index_list lists the newest index first.
+	for i, j := 0, len(uniques)-1; i < j; i, j = i+1, j-1 {
+		uniques[i], uniques[j] = uniques[j], uniques[i]
+	}
+	return uniques, created, nil
+}
+
+func indexColumns(ctx context.Context, tx *sql.Tx, index string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA index_info(%q)", index))
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: read index %q: %w", index, err)
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var seqno, cid int
+		var name sql.NullString
+		if err := rows.Scan(&seqno, &cid, &name); err != nil {
+			return nil, fmt.Errorf("vmstore: read index %q: %w", index, err)
+		}
+		cols = append(cols, name.String)
+	}
+	return cols, rows.Err()
+}
+
+// tableForeignKeys returns a FOREIGN KEY clause per constraint of table.
+func tableForeignKeys(ctx context.Context, tx *sql.Tx, table string) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("PRAGMA foreign_key_list(%q)", table))
+	if err != nil {
+		return nil, fmt.Errorf("vmstore: read foreign keys: %w", err)
+	}
+	defer rows.Close()
+
+	type foreignKey struct {
+		parent             string
+		from, to           []string
+		onUpdate, onDelete string
+	}
+	var order []int
+	keys := make(map[int]*foreignKey)
+	for rows.Next() {
+		var id, seq int
+		var parent, from, onUpdate, onDelete, match string
+		var to sql.NullString
+		if err := rows.Scan(&id, &seq, &parent, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, fmt.Errorf("vmstore: read foreign keys: %w", err)
+		}
+		fk, ok := keys[id]
+		if !ok {
+			fk = &foreignKey{parent: parent, onUpdate: onUpdate, onDelete: onDelete}
+			keys[id] = fk
+			order = append(order, id)
+		}
+		fk.from = append(fk.from, from)
+		if to.Valid {
+			fk.to = append(fk.to, to.String)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Sort(sort.Reverse(sort.IntSlice(order)))
+	clauses := make([]string, 0, len(order))
+	for _, id := range order {
+		fk := keys[id]
+		clause := fmt.Sprintf("FOREIGN KEY (%s) REFERENCES %q", quoteColumns(fk.from), fk.parent)
+		if len(fk.to) > 0 {
+			clause += fmt.Sprintf(" (%s)", quoteColumns(fk.to))
+		}
+		if fk.onUpdate != "" && fk.onUpdate != "NO ACTION" {
+			clause += " ON UPDATE " + fk.onUpdate
+		}
+		if fk.onDelete != "" && fk.onDelete != "NO ACTION" {
+			clause += " ON DELETE " + fk.onDelete
+		}
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+// checkForeignKeys fails if any row violates a foreign key.
+func checkForeignKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return fmt.Errorf("vmstore: foreign key check: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var child, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&child, &rowid, &parent, &fkid); err != nil {
+			return fmt.Errorf("vmstore: foreign key check: %w", err)
+		}
+		return fmt.Errorf("vmstore: schema change breaks foreign key from %q to %q", child, parent)
+	}
+	return rows.Err()
+}
+
+func countMatching(ctx context.Context, tx *sql.Tx, query string) (int64, error) {
+	var n int64
+	err := tx.QueryRowContext(ctx, query).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,219 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func boolPtr(b bool) *bool    { return &b }
+func strPtr(s string) *string { return &s }
+
+func columnInfo(t *testing.T, store *VMStore, wsID, table, column string) VMColumnInfo {
+	t.Helper()
+	schema, err := store.GetTableSchema(context.Background(), wsID, table)
+	if err != nil {
+		t.Fatalf("GetTableSchema: %v", err)
+	}
+	for _, col := range schema.Columns {
+		if col.Name == column {
+			return col
+		}
+	}
+	t.Fatalf("column %s.%s not found", table, column)
+	return VMColumnInfo{}
+}
+
+func TestVMStore_AlterColumnRebuild(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-alter-rebuild"
+
+	for _, stmt := range []string{
+		`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, email TEXT UNIQUE, age TEXT)`,
+		`CREATE INDEX idx_users_age ON users (age)`,
+		`CREATE TABLE audit (user_id INTEGER, note TEXT)`,
+		`CREATE TRIGGER users_ai AFTER INSERT ON users BEGIN INSERT INTO audit (user_id, note) VALUES (new.id, 'created'); END`,
+		`CREATE TABLE posts (id INTEGER PRIMARY KEY, user_id INTEGER REFERENCES users (id) ON DELETE CASCADE)`,
+		`INSERT INTO users (email, age) VALUES ('a@x.io', '30'), ('b@x.io', '41'), ('c@x.io', '52')`,
+		`DELETE FROM users WHERE email = 'c@x.io'`,
+		`INSERT INTO posts (user_id) VALUES (1)`,
+	} {
+		if _, err := store.ExecuteSQL(ctx, wsID, stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+
+	plan, err := store.AlterTable(ctx, wsID, "users", VMAlterTableRequest{
+		AlterColumns: []VMAlterColumnDef{{Name: "age", Type: "INT", Nullable: boolPtr(false), DefaultValue: strPtr("0")}},
+	})
+	if err != nil {
+		t.Fatalf("AlterTable: %v", err)
+	}
+	if !plan.Applied || !plan.Rebuild || len(plan.DataLoss) != 0 {
+		t.Fatalf("plan = %+v", plan)
+	}
+
+	age := columnInfo(t, store, wsID, "users", "age")
+	if age.Type != "INTEGER" || age.Nullable || age.DefaultValue == nil || *age.DefaultValue != "0" {
+		t.Fatalf("age column = %+v", age)
+	}
+
+	db, _ := store.GetDB(wsID)
+	var typ string
+	db.QueryRow(`SELECT typeof(age) FROM users WHERE id = 1`).Scan(&typ)
+	if typ != "integer" {
+		t.Fatalf("typeof(age) = %s, want integer", typ)
+	}
+
+	// Indexes, unique constraints, triggers and AUTOINCREMENT survive.
+	schema, _ := store.GetTableSchema(ctx, wsID, "users")
+	names := map[string]bool{}
+	for _, idx := range schema.Indexes {
+		names[idx.Name] = true
+	}
+	if !names["idx_users_age"] || !columnInfo(t, store, wsID, "users", "email").IsUnique {
+		t.Fatalf("indexes after rebuild = %+v", schema.Indexes)
+	}
+	if _, err := store.ExecuteSQL(ctx, wsID, `INSERT INTO users (email, age) VALUES ('a@x.io', 1)`); err == nil {
+		t.Fatal("duplicate email accepted after rebuild")
+	}
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO users (email) VALUES ('d@x.io')`)
+	var id, audits int
+	db.QueryRow(`SELECT id FROM users WHERE email = 'd@x.io'`).Scan(&id)
+	if id != 4 {
+		t.Fatalf("new id = %d, want 4 (AUTOINCREMENT high-water mark kept)", id)
+	}
+	db.QueryRow(`SELECT COUNT(*) FROM audit`).Scan(&audits)
+	if audits != 4 {
+		t.Fatalf("audit rows = %d, want 4 (trigger kept)", audits)
+	}
+
+	// Foreign keys from other tables still point at the rebuilt table.
+	store.ExecuteSQL(ctx, wsID, `DELETE FROM users WHERE id = 1`)
+	var posts int
+	db.QueryRow(`SELECT COUNT(*) FROM posts`).Scan(&posts)
+	if posts != 0 {
+		t.Fatalf("posts = %d, want 0 after cascading delete", posts)
+	}
+}
+
+func TestVMStore_AlterColumnDataLoss(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-alter-loss"
+
+	store.ExecuteSQL(ctx, wsID, `CREATE TABLE items (id INTEGER PRIMARY KEY, qty TEXT, note TEXT)`)
+	store.ExecuteSQL(ctx, wsID, `INSERT INTO items (qty, note) VALUES ('3', 'a'), ('lots', NULL), ('2.5', 'c')`)
+	req := VMAlterTableRequest{
+		AlterColumns: []VMAlterColumnDef{
+			{Name: "qty", Type: "INTEGER"},
+			{Name: "note", Nullable: boolPtr(false)},
+		},
+	}
+
+	plan, err := store.PreviewAlterTable(ctx, wsID, "items", req)
+	if err != nil {
+		t.Fatalf("PreviewAlterTable: %v", err)
+	}
+	if plan.Applied || len(plan.Statements) == 0 || !strings.HasPrefix(plan.Statements[0], `CREATE TABLE "_rebuild_items"`) {
+		t.Fatalf("preview plan = %+v", plan)
+	}
+	losses := map[string]int64{}
+	for _, loss := range plan.DataLoss {
+		losses[loss.Column+":"+loss.Reason] = loss.Rows
+	}
+	if losses["qty:"+DataLossTypeCast] != 2 || losses["note:"+DataLossNotNull] != 1 {
+		t.Fatalf("data loss = %+v", plan.DataLoss)
+	}
+
+	_, err = store.AlterTable(ctx, wsID, "items", req)
+	var lossErr *VMDataLossError
+	if !errors.As(err, &lossErr) {
+		t.Fatalf("err = %v, want VMDataLossError", err)
+	}
+	if col := columnInfo(t, store, wsID, "items", "qty"); col.Type != "TEXT" {
+		t.Fatalf("qty type after refused alter = %s, want TEXT", col.Type)
+	}
+	if n := countRows(t, store, wsID, "items"); n != 3 {
+		t.Fatalf("rows after refused alter = %d, want 3", n)
+	}
+
+	req.AllowDataLoss = true
+	if _, err := store.AlterTable(ctx, wsID, "items", req); err != nil {
+		t.Fatalf("AlterTable(allow_data_loss): %v", err)
+	}
+	if n := countRows(t, store, wsID, "items"); n != 2 {
+		t.Fatalf("rows after alter = %d, want 2", n)
+	}
+}
+
+func TestVMStore_AlterColumnUnknown(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	store.ExecuteSQL(ctx, "ws-alter-unknown", `CREATE TABLE t (id INTEGER PRIMARY KEY)`)
+	_, err := store.AlterTable(ctx, "ws-alter-unknown", "t", VMAlterTableRequest{
+		AlterColumns: []VMAlterColumnDef{{Name: "missing", Type: "TEXT"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "no such column") {
+		t.Fatalf("err = %v, want no such column", err)
+	}
+	_, err = store.AlterTable(ctx, "ws-alter-unknown", "t", VMAlterTableRequest{
+		AlterColumns: []VMAlterColumnDef{{Name: "id", Type: "TEXT); DROP TABLE t; --"}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "invalid column type") {
+		t.Fatalf("err = %v, want invalid column type", err)
+	}
+}
+
+func TestVMStore_DefaultValueMustBeLiteral(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-alter-default"
+
+	if _, err := store.ExecuteSQL(ctx, wsID, `CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	for i, value := range []string{"0", "-1.5e3", "'it''s'", "NULL", "current_timestamp", "X'00ff'"} {
+		name := fmt.Sprintf("c%d", i)
+		_, err := store.AlterTable(ctx, wsID, "notes", VMAlterTableRequest{
+			AddColumns: []VMCreateColumnDef{{Name: name, Type: "TEXT", Nullable: true, DefaultValue: strPtr(value)}},
+		})
+		if err != nil {
+			t.Fatalf("default %s: %v", value, err)
+		}
+	}
+
+	for _, value := range []string{"0, evil TEXT", "(SELECT 1)", "'a' || 'b'", "0; DROP TABLE notes", "hello"} {
+		_, err := store.AlterTable(ctx, wsID, "notes", VMAlterTableRequest{
+			AddColumns: []VMCreateColumnDef{{Name: "bad", Type: "TEXT", Nullable: true, DefaultValue: strPtr(value)}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid default value") {
+			t.Fatalf("add column default %q: err = %v, want invalid default value", value, err)
+		}
+		_, err = store.AlterTable(ctx, wsID, "notes", VMAlterTableRequest{
+			AlterColumns: []VMAlterColumnDef{{Name: "body", DefaultValue: strPtr(value)}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid default value") {
+			t.Fatalf("alter column default %q: err = %v, want invalid default value", value, err)
+		}
+		err = store.CreateTable(ctx, wsID, VMCreateTableRequest{
+			Name:    "other",
+			Columns: []VMCreateColumnDef{{Name: "body", Type: "TEXT", Nullable: true, DefaultValue: strPtr(value)}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "invalid default value") {
+			t.Fatalf("create table default %q: err = %v, want invalid default value", value, err)
+		}
+	}
+	if _, err := store.GetTableSchema(ctx, wsID, "notes"); err != nil {
+		t.Fatalf("notes table damaged: %v", err)
+	}
+}
@@ -0,0 +1,411 @@
+package vmruntime
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Row change operations reported in VMTableChange.Op.
+const (
+	VMChangeInsert = "insert"
+	VMChangeUpdate = "update"
+	VMChangeDelete = "delete"
+)
+
+// changeQueueSize bounds the committed transactions waiting for the change
+// listener; further transactions are dropped until it catches up.
+const changeQueueSize = 256
+
+// VMTableChange is one committed row change in a workspace table. Old is set
+// for updates and deletes, New for inserts and updates.
+type VMTableChange struct {
+	WorkspaceID string                 `json:"workspace_id"`
+	Table       string                 `json:"table"`
+	Op          string                 `json:"op"`
+	RowID       int64                  `json:"row_id"`
+	Old         map[string]interface{} `json:"old,omitempty"`
+	New         map[string]interface{} `json:"new,omitempty"`
+	CommittedAt time.Time              `json:"committed_at"`
+}
+
+// VMChangeListener receives the row changes of one committed transaction, in
+// commit order. It runs on a single background goroutine per store.
+type VMChangeListener func(changes []VMTableChange)
+
+// SetChangeListener registers a callback for committed row changes. Changes
+// are captured with SQLite's update hooks, so every write path — the data
+// API, the dashboard and the JS db API, including raw SQL and triggers — is
+// reported. Schema changes and migrations are not: rows they copy or rewrite
+// are part of the schema change.
+func (s *VMStore) SetChangeListener(listener VMChangeListener) {
+	s.mu.Lock()
+	s.changeListener = listener
+	s.mu.Unlock()
+	s.changesEnabled.Store(listener != nil)
+	if listener != nil {
+		s.changeOnce.Do(func() { go s.runChangeDispatcher() })
+	}
+}
+
+// capturedChange is a row change as seen by the preupdate hook, before
+// column names are resolved.
+type capturedChange struct {
+	table string
+	op    string
+	rowID int64
+	old   []interface{}
+	new   []interface{}
+}
+
+// changeBatch is the changes of one committed transaction.
+type changeBatch struct {
+	workspaceID string
+	committedAt time.Time
+	changes     []capturedChange
+}
+
+// vmConnector opens the connections of one workspace database and captures
+// their row changes. A workspace database has a single connection, so the
+// pending changes are those of its current transaction.
+type vmConnector struct {
+	store       *VMStore
+	workspaceID string
+	dsn         string
+	closed      atomic.Bool // set once the *sql.DB is closed; hooks of its connections go quiet
+
+	mu        sync.Mutex
+	pending   []capturedChange
+	suspended int
+}
+
+// Connect implements driver.Connector.
+func (c *vmConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.Open(c.dsn)
+}
+
+// Driver implements driver.Connector. *sql.DB.Driver returns the connector,
+// which is how transactions find it (see changesOf).
+func (c *vmConnector) Driver() driver.Driver {
+	return c
+}
+
+// Open implements driver.Driver.
+func (c *vmConnector) Open(string) (driver.Conn, error) {
+	conn, err := (&sqlite.Driver{}).Open(c.dsn)
+	if err != nil {
+		return nil, err
+	}
+	if hooks, ok := conn.(sqlite.HookRegisterer); ok {
+		hooks.RegisterPreUpdateHook(c.preUpdate)
+		hooks.RegisterCommitHook(c.commit)
+		hooks.RegisterRollbackHook(c.rollback)
+	}
+	return conn, nil
+}
+
+// changesOf returns the connector of a store database, or nil for a database
+// opened elsewhere.
+func changesOf(db *sql.DB) *vmConnector {
+	c, _ := db.Driver().(*vmConnector)
+	return c
+}
+
+// closeDB closes a store database and silences its change hooks: the driver
+// keeps them by connection handle, which a later connection may reuse.
+func closeDB(db *sql.DB) error {
+	if c := changesOf(db); c != nil {
+		c.closed.Store(true)
+	}
+	return db.Close()
+}
+
+// capturing reports whether row changes should be recorded right now.
+func (c *vmConnector) capturing() bool {
+	return c.store.changesEnabled.Load() && !c.closed.Load()
+}
+
+// preUpdate records a row change of the current transaction. It runs inside
+// sqlite3_step and must not use the connection.
+func (c *vmConnector) preUpdate(data sqlite.SQLitePreUpdateData) {
+	if data.DatabaseName != "main" || IsInternalTable(data.TableName) || !c.capturing() {
+		return
+	}
+	change := capturedChange{table: data.TableName}
+	n := data.Count()
+	switch data.Op {
+	case sqlite3.SQLITE_INSERT:
+		change.op, change.rowID = VMChangeInsert, data.NewRowID
+		change.new = make([]interface{}, n)
+		if data.New(change.new...) != nil {
+			return
+		}
+	case sqlite3.SQLITE_UPDATE:
+		change.op, change.rowID = VMChangeUpdate, data.NewRowID
+		change.old = make([]interface{}, n)
+		change.new = make([]interface{}, n)
+		if data.Old(change.old...) != nil || data.New(change.new...) != nil {
+			return
+		}
+	case sqlite3.SQLITE_DELETE:
+		change.op, change.rowID = VMChangeDelete, data.OldRowID
+		change.old = make([]interface{}, n)
+		if data.Old(change.old...) != nil {
+			return
+		}
+	default:
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.suspended == 0 {
+		c.pending = append(c.pending, change)
+	}
+}
+
+// commit hands the transaction's changes to the dispatcher. Returning 0 lets
+// the commit proceed.
+func (c *vmConnector) commit() int32 {
+	c.mu.Lock()
+	changes := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	if len(changes) > 0 && c.capturing() {
+		c.store.enqueueChanges(changeBatch{workspaceID: c.workspaceID, committedAt: time.Now(), changes: changes})
+	}
+	return 0
+}
+
+// rollback discards the transaction's changes.
+func (c *vmConnector) rollback() {
+	c.mu.Lock()
+	c.pending = nil
+	c.mu.Unlock()
+}
+
+// mark returns a position in the pending changes for rollbackTo. SQLite has
+// no hook for ROLLBACK TO, so savepoints record their start themselves.
+func (c *vmConnector) mark() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending)
+}
+
+// rollbackTo discards the changes recorded after mark.
+func (c *vmConnector) rollbackTo(mark int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if mark < len(c.pending) {
+		c.pending = c.pending[:mark]
+	}
+}
+
+// suspend stops recording changes until the returned function is called.
+func (c *vmConnector) suspend() func() {
+	if c == nil {
+		return func() {}
+	}
+	c.mu.Lock()
+	c.suspended++
+	c.mu.Unlock()
+	return func() {
+		c.mu.Lock()
+		c.suspended--
+		c.mu.Unlock()
+	}
+}
+
+// IsInternalTable reports whether table is managed by SQLite or the store
+// rather than by the workspace.
+func IsInternalTable(table string) bool {
+	return strings.HasPrefix(table, "sqlite_") || strings.HasPrefix(table, internalTablePrefix)
+}
+
+// enqueueChanges queues a committed transaction without blocking the commit.
+func (s *VMStore) enqueueChanges(batch changeBatch) {
+	select {
+	case s.changeQueue <- batch:
+	default:
+	}
+}
+
+// runChangeDispatcher resolves column names and calls the change listener
+// for each committed transaction until the store is closed.
+func (s *VMStore) runChangeDispatcher() {
+	for {
+		select {
+		case <-s.stop:
+			return
+		case batch := <-s.changeQueue:
+			s.mu.RLock()
+			listener := s.changeListener
+			s.mu.RUnlock()
+			if listener == nil {
+				continue
+			}
+			if changes := s.resolveChanges(batch); len(changes) > 0 {
+				listener(changes)
+			}
+		}
+	}
+}
+
+// resolveChanges names the captured values with the tables' current columns.
+// A change whose table no longer exists or no longer has the captured number
+// of columns is dropped.
+func (s *VMStore) resolveChanges(batch changeBatch) []VMTableChange {
+	s.mu.RLock()
+	db := s.dbs[batch.workspaceID]
+	s.mu.RUnlock()
+	if db == nil {
+		// Closed since the commit (deleted or evicted); do not reopen it.
+		return nil
+	}
+
+	ctx := context.Background()
+	columns := make(map[string][]string)
+	changes := make([]VMTableChange, 0, len(batch.changes))
+	for _, captured := range batch.changes {
+		cols, ok := columns[captured.table]
+		if !ok {
+			cols, _, _ = tableColumnNames(ctx, db, captured.table)
+			columns[captured.table] = cols
+		}
+		values := captured.new
+		if values == nil {
+			values = captured.old
+		}
+		if len(cols) == 0 || len(cols) != len(values) {
+			continue
+		}
+		changes = append(changes, VMTableChange{
+			WorkspaceID: batch.workspaceID,
+			Table:       captured.table,
+			Op:          captured.op,
+			RowID:       captured.rowID,
+			Old:         namedRow(cols, captured.old),
+			New:         namedRow(cols, captured.new),
+			CommittedAt: batch.committedAt,
+		})
+	}
+	return changes
+}
+
+// tableColumnNames returns every column of a table in storage order,
+// including generated columns, with its declared type.
+func tableColumnNames(ctx context.Context, conn sqlConn, table string) (names, types []string, err error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("PRAGMA table_xinfo(%q)", table))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notNull, pk, hidden int
+		var name, colType string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk, &hidden); err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		types = append(types, colType)
+	}
+	return names, types, rows.Err()
+}
+
+// affinityPlaceholder returns the placeholder for a value of a column with
+// the declared type declType. A value already stored in the column's affinity
+// is cast to it, which is lossless and gives the placeholder that affinity in
+// comparisons; other values are left as they are.
+func affinityPlaceholder(declType string, value interface{}) string {
+	// Affinity rules from https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+	upper := strings.ToUpper(declType)
+	var textual bool
+	switch {
+	case strings.Contains(upper, "INT"):
+	case strings.Contains(upper, "CHAR"), strings.Contains(upper, "CLOB"), strings.Contains(upper, "TEXT"):
+		textual = true
+	case upper == "", strings.Contains(upper, "BLOB"):
+		return "?"
+	}
+	switch value.(type) {
+	case int64:
+		if !textual {
+			return "CAST(? AS INTEGER)"
+		}
+	case float64:
+		if !textual {
+			return "CAST(? AS REAL)"
+		}
+	case string:
+		if textual {
+			return "CAST(? AS TEXT)"
+		}
+	}
+	return "?"
+}
+
+// namedRow zips column names with values; nil values give a nil row.
+func namedRow(cols []string, values []interface{}) map[string]interface{} {
+	if values == nil {
+		return nil
+	}
+	row := make(map[string]interface{}, len(cols))
+	for i, col := range cols {
+		row[col] = values[i]
+	}
+	return row
+}
+
+// RowInScope reports whether a row of table — typically the Old or New of a
+// VMTableChange — satisfies scope. The row is evaluated on its own, as a
+// one-row stand-in for the table, so it need not exist any more; subqueries
+// on the table itself see only that row. A nil scope matches every row.
+func (s *VMStore) RowInScope(ctx context.Context, workspaceID, table string, row map[string]interface{}, scope *VMRowScope) (bool, error) {
+	clause, args := scope.predicate()
+	if clause == "" {
+		return true, nil
+	}
+	if scope.DenyAll || row == nil {
+		return false, nil
+	}
+	db, err := s.GetDB(workspaceID)
+	if err != nil {
+		return false, err
+	}
+	cols, types, err := tableColumnNames(ctx, db, table)
+	if err != nil {
+		return false, fmt.Errorf("vmstore: read columns: %w", err)
+	}
+	if len(cols) == 0 {
+		return false, fmt.Errorf("vmstore: table %q not found", table)
+	}
+
+	placeholders := make([]string, len(cols))
+	values := make([]interface{}, 0, len(cols)+len(args))
+	for i, col := range cols {
+		placeholders[i] = affinityPlaceholder(types[i], row[col])
+		values = append(values, row[col])
+	}
+	query := fmt.Sprintf("WITH %q(%s) AS (VALUES (%s)) SELECT COUNT(*) FROM %q WHERE COALESCE(%s, 0)",
+		table, quoteColumns(cols), strings.Join(placeholders, ", "), table, clause)
+	var matched int64
+	if err := db.QueryRowContext(ctx, query, append(values, args...)...).Scan(&matched); err != nil {
+		return false, fmt.Errorf("vmstore: scope check: %w", err)
+	}
+	return matched > 0, nil
+}
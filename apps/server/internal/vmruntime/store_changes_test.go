@@ -0,0 +1,160 @@
+package vmruntime
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// collectChanges registers a change listener and returns a function that
+// waits for the next committed transaction.
+func collectChanges(t *testing.T, store *VMStore) func() []VMTableChange {
+	t.Helper()
+	batches := make(chan []VMTableChange, 16)
+	store.SetChangeListener(func(changes []VMTableChange) { batches <- changes })
+	return func() []VMTableChange {
+		t.Helper()
+		select {
+		case changes := <-batches:
+			return changes
+		case <-time.After(5 * time.Second):
+			t.Fatal("no change batch delivered")
+			return nil
+		}
+	}
+}
+
+func TestVMStore_ChangeListener(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-changes"
+
+	if _, err := store.ExecuteSQL(ctx, wsID, `CREATE TABLE notes (id INTEGER PRIMARY KEY AUTOINCREMENT, owner_id INTEGER, body TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	next := collectChanges(t, store)
+
+	if _, err := store.InsertRow(ctx, wsID, "notes", map[string]interface{}{"owner_id": 7, "body": "hi"}); err != nil {
+		t.Fatalf("InsertRow: %v", err)
+	}
+	changes := next()
+	if len(changes) != 1 || changes[0].Op != VMChangeInsert || changes[0].Table != "notes" || changes[0].WorkspaceID != wsID {
+		t.Fatalf("insert changes = %+v", changes)
+	}
+	if row := changes[0].New; row["id"] != int64(1) || row["owner_id"] != int64(7) || row["body"] != "hi" || changes[0].Old != nil {
+		t.Fatalf("insert row = %+v", changes[0])
+	}
+
+	if _, err := store.UpdateRow(ctx, wsID, "notes", map[string]interface{}{"body": "edited"}, map[string]interface{}{"id": 1}); err != nil {
+		t.Fatalf("UpdateRow: %v", err)
+	}
+	changes = next()
+	if len(changes) != 1 || changes[0].Op != VMChangeUpdate || changes[0].Old["body"] != "hi" || changes[0].New["body"] != "edited" {
+		t.Fatalf("update changes = %+v", changes)
+	}
+
+	// Schema changes do not report the rows a table rebuild copies.
+	if _, err := store.AlterTable(ctx, wsID, "notes", VMAlterTableRequest{
+		AlterColumns: []VMAlterColumnDef{{Name: "body", Type: "TEXT", Nullable: boolPtr(false), DefaultValue: strPtr("''")}},
+	}); err != nil {
+		t.Fatalf("AlterTable: %v", err)
+	}
+
+	if _, err := store.DeleteRows(ctx, wsID, "notes", []interface{}{1}); err != nil {
+		t.Fatalf("DeleteRows: %v", err)
+	}
+	changes = next()
+	if len(changes) != 1 || changes[0].Op != VMChangeDelete || changes[0].RowID != 1 || changes[0].Old["body"] != "edited" || changes[0].New != nil {
+		t.Fatalf("delete changes = %+v", changes)
+	}
+}
+
+func TestVMStore_ChangeListenerTransactions(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-changes-tx"
+
+	if _, err := store.ExecuteSQL(ctx, wsID, `CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, customer TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	db, _ := store.GetDB(wsID)
+	vm, err := NewWorkspaceVM(wsID, `
+		exports.routes = {
+			"POST /nested": function(ctx) {
+				db.transaction(function(tx) {
+					tx.insert("orders", { customer: "outer" });
+					try {
+						tx.transaction(function(inner) {
+							inner.insert("orders", { customer: "inner" });
+							throw new Error("inner failed");
+						});
+					} catch (e) {}
+					tx.execute("UPDATE orders SET customer = 'outer!' WHERE customer = 'outer'");
+					kv.set("last", "outer");
+				});
+			},
+			"POST /rollback": function(ctx) {
+				db.transaction(function(tx) {
+					tx.insert("orders", { customer: "gone" });
+					throw new Error("abort");
+				});
+			}
+		};
+	`, db)
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM: %v", err)
+	}
+	next := collectChanges(t, store)
+
+	if _, err := vm.Handle(VMRequest{Method: "POST", Path: "/rollback"}); err == nil {
+		t.Fatal("expected the transaction to throw")
+	}
+
+	// One batch per commit: the rolled-back savepoint and the internal kv
+	// table are left out, the rolled-back transaction never shows up.
+	if _, err := vm.Handle(VMRequest{Method: "POST", Path: "/nested"}); err != nil {
+		t.Fatalf("Handle: %v", err)
+	}
+	changes := next()
+	if len(changes) != 2 {
+		t.Fatalf("changes = %+v, want insert and update", changes)
+	}
+	if changes[0].Op != VMChangeInsert || changes[0].New["customer"] != "outer" ||
+		changes[1].Op != VMChangeUpdate || changes[1].New["customer"] != "outer!" || changes[0].RowID != changes[1].RowID {
+		t.Fatalf("changes = %+v", changes)
+	}
+}
+
+func TestVMStore_RowInScope(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	ctx := context.Background()
+	wsID := "ws-row-scope"
+
+	if _, err := store.ExecuteSQL(ctx, wsID, `CREATE TABLE notes (id INTEGER PRIMARY KEY, owner_id INTEGER, body TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	row := map[string]interface{}{"id": int64(1), "owner_id": int64(7), "body": "hi"}
+
+	// The text argument compares like it would against the INTEGER column.
+	scope := &VMRowScope{Clause: `"notes"."owner_id" = ?`, Args: []interface{}{"7"}}
+	if ok, err := store.RowInScope(ctx, wsID, "notes", row, scope); err != nil || !ok {
+		t.Fatalf("owner row: %v, %v", ok, err)
+	}
+	scope.Args = []interface{}{"8"}
+	if ok, err := store.RowInScope(ctx, wsID, "notes", row, scope); err != nil || ok {
+		t.Fatalf("other owner: %v, %v", ok, err)
+	}
+	if ok, _ := store.RowInScope(ctx, wsID, "notes", row, nil); !ok {
+		t.Fatal("nil scope should match")
+	}
+	if ok, _ := store.RowInScope(ctx, wsID, "notes", row, &VMRowScope{DenyAll: true}); ok {
+		t.Fatal("DenyAll should not match")
+	}
+	// The row is evaluated on its own; the table stays empty.
+	if ok, _ := store.RowInScope(ctx, wsID, "notes", row, &VMRowScope{Filters: []VMQueryFilter{{Column: "body", Operator: "eq", Value: "hi"}}}); !ok {
+		t.Fatal("filter scope should match")
+	}
+}
@@ -3,12 +3,21 @@ package vmruntime
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"time"
 )
 
+var (
+	// ErrTableExists is returned by CreateTable when a table with the same
+	// name, compared case-insensitively, already exists.
+	ErrTableExists = errors.New("vmstore: table already exists")
+	// ErrTableNotFound is returned by DropTable when no table matches.
+	ErrTableNotFound = errors.New("vmstore: table not found")
+)
+
 // ListTables returns all user tables in the workspace's SQLite database.
 func (s *VMStore) ListTables(ctx context.Context, workspaceID string) ([]VMTableInfo, error) {
 	db, err := s.GetDB(workspaceID)
@@ -19,7 +28,7 @@ func (s *VMStore) ListTables(ctx context.Context, workspaceID string) ([]VMTable
 	// Collect table names first, then close the rows iterator before issuing
 	// secondary queries. This avoids deadlock with MaxOpenConns(1).
 	rows, err := db.QueryContext(ctx,
-		`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+		`SELECT name FROM sqlite_master WHERE type='table' AND name NOT LIKE 'sqlite_%' AND name NOT LIKE '\_vm\_%' ESCAPE '\' ORDER BY name`)
 	if err != nil {
 		return nil, fmt.Errorf("vmstore: list tables: %w", err)
 	}
@@ -298,6 +307,7 @@ func (s *VMStore) QueryRows(ctx context.Context, workspaceID, tableName string,
 	}
 
 	whereClause, whereArgs := buildWhereClause(params.Filters, params.FilterCombinator)
+	whereClause, whereArgs = params.Scope.and(whereClause, whereArgs)
 
 	// Count total
 	countSQL := fmt.Sprintf("SELECT COUNT(*) FROM %q", tableName)
@@ -341,6 +351,12 @@ func (s *VMStore) QueryRows(ctx context.Context, workspaceID, tableName string,
 
 // InsertRow inserts a row into the specified table.
 func (s *VMStore) InsertRow(ctx context.Context, workspaceID, tableName string, data map[string]interface{}) (*VMExecResult, error) {
+	return s.InsertRowScoped(ctx, workspaceID, tableName, data, nil)
+}
+
+// InsertRowScoped inserts a row and rejects it with ErrRowScopeViolation when
+// the stored row (including column defaults) does not satisfy scope.
+func (s *VMStore) InsertRowScoped(ctx context.Context, workspaceID, tableName string, data map[string]interface{}, scope *VMRowScope) (*VMExecResult, error) {
 	db, err := s.GetDB(workspaceID)
 	if err != nil {
 		return nil, err
@@ -362,18 +378,21 @@ func (s *VMStore) InsertRow(ctx context.Context, workspaceID, tableName string,
 	query := fmt.Sprintf("INSERT INTO %q (%s) VALUES (%s)",
 		tableName, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
 
-	result, err := db.ExecContext(ctx, query, values...)
+	lastID, affected, err := scopedInsert(ctx, db, tableName, query, values, scope)
 	if err != nil {
-		return nil, fmt.Errorf("vmstore: insert row: %w", err)
+		return nil, err
 	}
-
-	lastID, _ := result.LastInsertId()
-	affected, _ := result.RowsAffected()
 	return &VMExecResult{LastInsertID: lastID, AffectedRows: affected}, nil
 }
 
 // UpdateRow updates rows in the specified table.
 func (s *VMStore) UpdateRow(ctx context.Context, workspaceID, tableName string, data map[string]interface{}, where map[string]interface{}) (*VMExecResult, error) {
+	return s.UpdateRowScoped(ctx, workspaceID, tableName, data, where, nil)
+}
+
+// UpdateRowScoped updates rows matching where that also satisfy scope.
+// Rows outside the scope are left untouched and not counted as affected.
+func (s *VMStore) UpdateRowScoped(ctx context.Context, workspaceID, tableName string, data map[string]interface{}, where map[string]interface{}, scope *VMRowScope) (*VMExecResult, error) {
 	db, err := s.GetDB(workspaceID)
 	if err != nil {
 		return nil, err
@@ -399,20 +418,23 @@ func (s *VMStore) UpdateRow(ctx context.Context, workspaceID, tableName string,
 		args = append(args, val)
 	}
 
-	query := fmt.Sprintf("UPDATE %q SET %s WHERE %s",
-		tableName, strings.Join(setClauses, ", "), strings.Join(whereClauses, " AND "))
-
-	result, err := db.ExecContext(ctx, query, args...)
+	affected, err := scopedUpdate(ctx, db, tableName,
+		strings.Join(setClauses, ", "), args[:len(data)],
+		strings.Join(whereClauses, " AND "), args[len(data):], scope)
 	if err != nil {
-		return nil, fmt.Errorf("vmstore: update row: %w", err)
+		return nil, err
 	}
-
-	affected, _ := result.RowsAffected()
 	return &VMExecResult{AffectedRows: affected}, nil
 }
 
 // DeleteRows deletes rows from the specified table by IDs.
 func (s *VMStore) DeleteRows(ctx context.Context, workspaceID, tableName string, ids []interface{}) (*VMExecResult, error) {
+	return s.DeleteRowsScoped(ctx, workspaceID, tableName, ids, nil)
+}
+
+// DeleteRowsScoped deletes rows by IDs, restricted to rows that satisfy scope.
+// The IDs of the rows actually deleted are returned in DeletedIDs.
+func (s *VMStore) DeleteRowsScoped(ctx context.Context, workspaceID, tableName string, ids []interface{}, scope *VMRowScope) (*VMExecResult, error) {
 	db, err := s.GetDB(workspaceID)
 	if err != nil {
 		return nil, err
@@ -427,16 +449,28 @@ func (s *VMStore) DeleteRows(ctx context.Context, workspaceID, tableName string,
 		placeholders[i] = "?"
 	}
 
-	query := fmt.Sprintf("DELETE FROM %q WHERE id IN (%s)",
-		tableName, strings.Join(placeholders, ", "))
+	whereClause, args := scope.and(fmt.Sprintf("id IN (%s)", strings.Join(placeholders, ", ")), ids)
+
+	query := fmt.Sprintf("DELETE FROM %q WHERE %s RETURNING id", tableName, whereClause)
 
-	result, err := db.ExecContext(ctx, query, ids...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("vmstore: delete rows: %w", err)
+		return nil, fmt.Errorf("vmstore: delete rows: %w", quotaError(err))
 	}
+	defer rows.Close()
 
-	affected, _ := result.RowsAffected()
-	return &VMExecResult{AffectedRows: affected}, nil
+	deleted := make([]interface{}, 0, len(ids))
+	for rows.Next() {
+		var id interface{}
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("vmstore: delete rows: %w", err)
+		}
+		deleted = append(deleted, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("vmstore: delete rows: %w", quotaError(err))
+	}
+	return &VMExecResult{AffectedRows: int64(len(deleted)), DeletedIDs: deleted}, nil
 }
 
 // ExecuteSQL executes an arbitrary SQL statement against the workspace database.
@@ -464,7 +498,7 @@ func (s *VMStore) ExecuteSQL(ctx context.Context, workspaceID, sqlStr string, pa
 
 	result, err := db.ExecContext(ctx, sqlStr, params...)
 	if err != nil {
-		return nil, fmt.Errorf("vmstore: execute sql: %w", err)
+		return nil, fmt.Errorf("vmstore: execute sql: %w", quotaError(err))
 	}
 	affected, _ := result.RowsAffected()
 	return &VMQueryResult{
@@ -473,12 +507,20 @@ func (s *VMStore) ExecuteSQL(ctx context.Context, workspaceID, sqlStr string, pa
 	}, nil
 }
 
-// CreateTable creates a new table in the workspace's SQLite database.
+// CreateTable creates a new table in the workspace's SQLite database and
+// records it as a migration. Table names are case-insensitive; creating a
+// table whose name matches an existing one returns ErrTableExists.
 func (s *VMStore) CreateTable(ctx context.Context, workspaceID string, req VMCreateTableRequest) error {
-	db, err := s.GetDB(workspaceID)
+	if strings.HasPrefix(req.Name, internalTablePrefix) {
+		return fmt.Errorf("vmstore: table names starting with %q are reserved", internalTablePrefix)
+	}
+	existing, err := s.lookupTable(ctx, workspaceID, req.Name)
 	if err != nil {
 		return err
 	}
+	if existing != "" {
+		return fmt.Errorf("%w: %q", ErrTableExists, existing)
+	}
 
 	var colDefs []string
 	for _, col := range req.Columns {
@@ -487,7 +529,11 @@ func (s *VMStore) CreateTable(ctx context.Context, workspaceID string, req VMCre
 			def += " NOT NULL"
 		}
 		if col.DefaultValue != nil {
-			def += " DEFAULT " + *col.DefaultValue
+			clause, err := defaultClause(*col.DefaultValue)
+			if err != nil {
+				return err
+			}
+			def += clause
 		}
 		if col.Unique {
 			def += " UNIQUE"
@@ -518,92 +564,77 @@ func (s *VMStore) CreateTable(ctx context.Context, workspaceID string, req VMCre
 		}
 	}
 
-	ddl := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %q (\n  %s\n)", req.Name, strings.Join(colDefs, ",\n  "))
-	if _, err := db.ExecContext(ctx, ddl); err != nil {
-		return fmt.Errorf("vmstore: create table: %w", err)
-	}
-
-	// Create indexes
-	for _, idx := range req.Indexes {
-		unique := ""
-		if idx.Unique {
-			unique = "UNIQUE "
+	return s.changeSchema(ctx, workspaceID, "create table "+req.Name, false, false, func(c *schemaChange) ([]string, error) {
+		// Without IF NOT EXISTS a table created concurrently fails the
+		// change instead of recording a migration that would drop it.
+		ddl := fmt.Sprintf("CREATE TABLE %q (\n  %s\n)", req.Name, strings.Join(colDefs, ",\n  "))
+		if err := c.exec(ddl); err != nil {
+			return nil, fmt.Errorf("vmstore: create table: %w", err)
 		}
-		idxSQL := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %q ON %q (%s)",
-			unique, idx.Name, req.Name, quoteColumns(idx.Columns))
-		if _, err := db.ExecContext(ctx, idxSQL); err != nil {
-			return fmt.Errorf("vmstore: create index %q: %w", idx.Name, err)
-		}
-	}
 
-	return nil
+		// Create indexes
+		for _, idx := range req.Indexes {
+			unique := ""
+			if idx.Unique {
+				unique = "UNIQUE "
+			}
+			idxSQL := fmt.Sprintf("CREATE %sINDEX IF NOT EXISTS %q ON %q (%s)",
+				unique, idx.Name, req.Name, quoteColumns(idx.Columns))
+			if err := c.exec(idxSQL); err != nil {
+				return nil, fmt.Errorf("vmstore: create index %q: %w", idx.Name, err)
+			}
+		}
+		return []string{fmt.Sprintf("DROP TABLE %q", req.Name)}, nil
+	})
 }
 
-// AlterTable alters an existing table in the workspace's SQLite database.
-func (s *VMStore) AlterTable(ctx context.Context, workspaceID, tableName string, req VMAlterTableRequest) error {
-	db, err := s.GetDB(workspaceID)
+// DropTable drops a table from the workspace's SQLite database and records
+// it as a migration whose down steps recreate the table's schema. tableName
+// matches case-insensitively; a missing table returns ErrTableNotFound. The
+// table is snapshotted first; the drop is aborted if that fails.
+func (s *VMStore) DropTable(ctx context.Context, workspaceID, name string) error {
+	tableName, err := s.lookupTable(ctx, workspaceID, name)
 	if err != nil {
 		return err
 	}
-
-	// Rename table
-	if req.Rename != "" {
-		if _, err := db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %q RENAME TO %q", tableName, req.Rename)); err != nil {
-			return fmt.Errorf("vmstore: rename table: %w", err)
-		}
-		tableName = req.Rename
+	if tableName == "" {
+		return fmt.Errorf("%w: %q", ErrTableNotFound, name)
 	}
-
-	// Add columns
-	for _, col := range req.AddColumns {
-		def := fmt.Sprintf("ALTER TABLE %q ADD COLUMN %q %s", tableName, col.Name, mapColumnType(col.Type))
-		if !col.Nullable {
-			def += " NOT NULL"
-		}
-		if col.DefaultValue != nil {
-			def += " DEFAULT " + *col.DefaultValue
-		}
-		if _, err := db.ExecContext(ctx, def); err != nil {
-			return fmt.Errorf("vmstore: add column %q: %w", col.Name, err)
-		}
+	if err := s.snapshotBefore(ctx, workspaceID, SnapshotReasonDropTable, "drop table "+tableName); err != nil {
+		return err
 	}
-
-	// Rename columns (SQLite 3.25+)
-	for _, col := range req.AlterColumns {
-		if col.NewName != "" && col.NewName != col.Name {
-			sql := fmt.Sprintf("ALTER TABLE %q RENAME COLUMN %q TO %q", tableName, col.Name, col.NewName)
-			if _, err := db.ExecContext(ctx, sql); err != nil {
-				return fmt.Errorf("vmstore: rename column %q: %w", col.Name, err)
-			}
+	return s.changeSchema(ctx, workspaceID, "drop table "+tableName, false, false, func(c *schemaChange) ([]string, error) {
+		before, err := readTableObjects(ctx, c.tx, tableName)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	// Drop columns (SQLite 3.35+)
-	for _, colName := range req.DropColumns {
-		sql := fmt.Sprintf("ALTER TABLE %q DROP COLUMN %q", tableName, colName)
-		if _, err := db.ExecContext(ctx, sql); err != nil {
-			return fmt.Errorf("vmstore: drop column %q: %w", colName, err)
+		if before == nil {
+			return nil, fmt.Errorf("%w: %q", ErrTableNotFound, name)
 		}
-	}
-
-	return nil
+		if err := c.exec(fmt.Sprintf("DROP TABLE %q", tableName)); err != nil {
+			return nil, fmt.Errorf("vmstore: drop table: %w", err)
+		}
+		return before.createStatements(), nil
+	})
 }
 
-// DropTable drops a table from the workspace's SQLite database.
-func (s *VMStore) DropTable(ctx context.Context, workspaceID, tableName string) error {
+// lookupTable returns the stored name of the table matching tableName the way
+// SQLite resolves it, case-insensitively, or "" if there is none.
+func (s *VMStore) lookupTable(ctx context.Context, workspaceID, tableName string) (string, error) {
 	db, err := s.GetDB(workspaceID)
 	if err != nil {
-		return err
+		return "", err
 	}
-	if _, err := db.ExecContext(ctx, fmt.Sprintf("DROP TABLE IF EXISTS %q", tableName)); err != nil {
-		return fmt.Errorf("vmstore: drop table: %w", err)
+	var name string
+	err = db.QueryRowContext(ctx,
+		"SELECT name FROM sqlite_master WHERE type='table' AND name=? COLLATE NOCASE", tableName).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
-	return nil
-}
-
-// GetQueryHistory returns an empty history (SQLite has no built-in query log).
-func (s *VMStore) GetQueryHistory(_ context.Context, _ string) []VMQueryHistoryItem {
-	return []VMQueryHistoryItem{}
+	if err != nil {
+		return "", fmt.Errorf("vmstore: lookup table: %w", err)
+	}
+	return name, nil
 }
 
 // mapColumnType maps common SQL types to SQLite-compatible types.
@@ -2,8 +2,10 @@ package vmruntime
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -11,7 +13,7 @@ import (
 func newTestStore(t *testing.T) (*VMStore, func()) {
 	t.Helper()
 	dir := t.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	return store, func() { store.Close() }
 }
 
@@ -92,7 +94,7 @@ func TestVMStore_Delete(t *testing.T) {
 
 func TestVMStore_DBPath(t *testing.T) {
 	dir := t.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	defer store.Close()
 
 	path := store.DBPath("abc-123")
@@ -267,6 +269,9 @@ func TestVMStore_CRUD_Operations(t *testing.T) {
 	if del.AffectedRows != 1 {
 		t.Fatalf("DeleteRows affected = %d, want 1", del.AffectedRows)
 	}
+	if len(del.DeletedIDs) != 1 || del.DeletedIDs[0] != res.LastInsertID {
+		t.Fatalf("DeleteRows deleted ids = %v, want [%d]", del.DeletedIDs, res.LastInsertID)
+	}
 
 	// Verify remaining
 	qr3, _ := store.QueryRows(ctx, wsID, "tasks", VMQueryParams{Page: 1, PageSize: 10})
@@ -329,7 +334,7 @@ func TestVMStore_AlterTable(t *testing.T) {
 	})
 
 	// Add column
-	err := store.AlterTable(ctx, wsID, "orders", VMAlterTableRequest{
+	_, err := store.AlterTable(ctx, wsID, "orders", VMAlterTableRequest{
 		AddColumns: []VMCreateColumnDef{
 			{Name: "status", Type: "TEXT", Nullable: true},
 		},
@@ -344,7 +349,7 @@ func TestVMStore_AlterTable(t *testing.T) {
 	}
 
 	// Rename table
-	err = store.AlterTable(ctx, wsID, "orders", VMAlterTableRequest{
+	_, err = store.AlterTable(ctx, wsID, "orders", VMAlterTableRequest{
 		Rename: "purchases",
 	})
 	if err != nil {
@@ -604,3 +609,24 @@ func TestMapColumnType(t *testing.T) {
 		}
 	}
 }
+
+// ── Size quota ───────────────────────────────────────────────────────
+
+func TestVMStore_MaxDBSize(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{BaseDir: t.TempDir(), MaxDBSize: 64 * 1024})
+	defer store.Close()
+	ctx := context.Background()
+
+	if _, err := store.ExecuteSQL(ctx, "ws-quota", `CREATE TABLE blobs (id INTEGER PRIMARY KEY, data TEXT)`); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	payload := strings.Repeat("x", 8*1024)
+	var err error
+	for i := 0; i < 64 && err == nil; i++ {
+		_, err = store.InsertRow(ctx, "ws-quota", "blobs", map[string]interface{}{"data": payload})
+	}
+	if !errors.Is(err, ErrDBQuotaExceeded) {
+		t.Fatalf("err = %v, want ErrDBQuotaExceeded", err)
+	}
+}
@@ -88,6 +88,20 @@ type VMQueryParams struct {
 	OrderDir         string          `json:"order_dir"`
 	Filters          []VMQueryFilter `json:"filters,omitempty"`
 	FilterCombinator string          `json:"filter_combinator,omitempty"`
+	// Scope is always ANDed with Filters, regardless of FilterCombinator.
+	Scope *VMRowScope `json:"-"`
+}
+
+// VMRowScope is a mandatory row predicate (e.g. derived from RLS policies)
+// that restricts which rows a query, update or delete may touch.
+type VMRowScope struct {
+	Filters []VMQueryFilter
+	// Clause is a trusted, parameterized SQLite predicate (e.g. a compiled
+	// policy expression) with its positional Args.
+	Clause string
+	Args   []interface{}
+	// DenyAll makes the scope match no rows at all.
+	DenyAll bool
 }
 
 // VMQueryFilter represents a single filter condition.
@@ -110,6 +124,9 @@ type VMQueryResult struct {
 type VMExecResult struct {
 	LastInsertID int64 `json:"last_insert_id,omitempty"`
 	AffectedRows int64 `json:"affected_rows"`
+	// DeletedIDs lists the IDs removed by DeleteRows, in the order SQLite
+	// returned them.
+	DeletedIDs []interface{} `json:"deleted_ids,omitempty"`
 }
 
 // VMCreateTableRequest represents a request to create a new table.
@@ -142,6 +159,9 @@ type VMAlterTableRequest struct {
 	AlterColumns []VMAlterColumnDef  `json:"alter_columns,omitempty"`
 	DropColumns  []string            `json:"drop_columns,omitempty"`
 	Rename       string              `json:"rename,omitempty"`
+	// AllowDataLoss applies a column change even when the data-loss report
+	// is not empty: NOT NULL violators are dropped, lossy casts are kept.
+	AllowDataLoss bool `json:"allow_data_loss,omitempty"`
 }
 
 // VMAlterColumnDef represents a column alteration definition.
@@ -153,11 +173,19 @@ type VMAlterColumnDef struct {
 	DefaultValue *string `json:"default_value,omitempty"`
 }
 
-// VMQueryHistoryItem represents a query history entry.
-type VMQueryHistoryItem struct {
-	SQL        string `json:"sql"`
-	DurationMs int64  `json:"duration_ms"`
-	Status     string `json:"status"`
-	CreatedAt  string `json:"created_at"`
-	Error      string `json:"error,omitempty"`
+// VMAlterPlan describes the statements an AlterTable runs and the data they
+// would lose. Type, nullability and default changes need a table rebuild.
+type VMAlterPlan struct {
+	Table      string       `json:"table"`
+	Statements []string     `json:"statements"`
+	Rebuild    bool         `json:"rebuild"`
+	DataLoss   []VMDataLoss `json:"data_loss"`
+	Applied    bool         `json:"applied"`
+}
+
+// VMDataLoss counts the rows of one column a rebuild cannot carry over as-is.
+type VMDataLoss struct {
+	Column string `json:"column"`
+	Reason string `json:"reason"` // DataLossNotNull or DataLossTypeCast
+	Rows   int64  `json:"rows"`
 }
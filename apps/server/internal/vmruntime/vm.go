@@ -1,13 +1,17 @@
 package vmruntime
 
 import (
+	"context"
 	"crypto/sha256"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/dop251/goja"
+	"github.com/reverseai/server/internal/pkg/security"
 )
 
 // VMRequest represents the request context passed to JS route handlers.
@@ -19,6 +23,19 @@ type VMRequest struct {
 	Body    map[string]interface{} `json:"body"`
 	Headers map[string]string      `json:"headers"`
 	User    *VMUser                `json:"user"`
+	// Scope resolves row scopes for User. When set, the `db` global applies
+	// them for the duration of the request; nil leaves `db` unrestricted.
+	Scope VMScopeResolver `json:"-"`
+	// Egress is the workspace's outbound policy for `fetch`; nil disables it.
+	Egress *VMEgress `json:"-"`
+	// Secrets resolves the workspace secrets read by `secrets.get` and
+	// referenced by `fetch`; nil makes both fail.
+	Secrets VMSecretResolver `json:"-"`
+	// Redactor holds every secret of the workspace. Console output, handler
+	// errors and fetch events are redacted with it as well as with the
+	// values resolved during the request, so secrets the handler obtained
+	// some other way do not leak either.
+	Redactor *security.SecretRedactor `json:"-"`
 }
 
 // VMUser represents an authenticated app user in the VM context.
@@ -34,33 +51,117 @@ type VMResponse struct {
 	Body   interface{} `json:"body"`
 }
 
-// WorkspaceVM holds a goja JS runtime with loaded routes for a workspace.
+// WorkspaceVM serves a workspace's deployed code from a bounded set of goja
+// runtimes. A goja.Runtime is not goroutine-safe, so every request checks out
+// a runtime exclusively; requests beyond the limit queue in FIFO order.
 type WorkspaceVM struct {
 	workspaceID string
-	runtime     *goja.Runtime
-	routes      map[string]goja.Callable // "GET /tasks" → JS function
+	code        string
+	db          *sql.DB
+	routes      map[string]struct{} // "GET /tasks"
+	schedules   []string            // cron specs of exports.schedules, sorted
 	codeHash    string
 	loadedAt    time.Time
+	limits      VMLimits
+	// store, when set by the pool, owns db; executions retain the handle
+	// through it so idle eviction cannot close it under them.
+	store      *VMStore
+	lastAccess atomic.Int64 // unix nanos of the last pool hand-out
+
+	idle    chan *vmInstance
+	spawn   chan struct{} // one token per runtime that may still be created
+	waiting atomic.Int64
 }
 
-// NewWorkspaceVM creates a new VM, sets up the sandbox, injects APIs, and executes the code.
+// vmInstance is one goja runtime with the workspace code loaded.
+type vmInstance struct {
+	runtime   *goja.Runtime
+	db        *vmDB
+	fetch     *vmFetch
+	secrets   *vmSecrets
+	routes    map[string]goja.Callable // "GET /tasks" → JS function
+	schedules map[string]goja.Callable // "0 2 * * *" → JS function
+	limits    VMLimits
+	steps     int64 // steps consumed by the current execution
+}
+
+// NewWorkspaceVM creates a new VM with the default limits.
 func NewWorkspaceVM(workspaceID string, code string, db *sql.DB) (*WorkspaceVM, error) {
-	if err := validateCodeSize(code); err != nil {
+	return newWorkspaceVM(workspaceID, code, db, DefaultVMLimits())
+}
+
+// newWorkspaceVM instruments the code for step budgets and validates it by
+// warming up the first runtime.
+func newWorkspaceVM(workspaceID string, code string, db *sql.DB, limits VMLimits) (*WorkspaceVM, error) {
+	limits = limits.normalize()
+	if err := validateCodeSize(code, limits.MaxCodeSize); err != nil {
 		return nil, fmt.Errorf("vm: %w", err)
 	}
+	instrumented, err := instrumentCode(code)
+	if err != nil {
+		return nil, fmt.Errorf("vm: %w", err)
+	}
+	inst, err := newVMInstance(workspaceID, instrumented, db, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	routes := make(map[string]struct{}, len(inst.routes))
+	for key := range inst.routes {
+		routes[key] = struct{}{}
+	}
 
+	h := sha256.Sum256([]byte(code))
+	w := &WorkspaceVM{
+		workspaceID: workspaceID,
+		code:        instrumented,
+		db:          db,
+		routes:      routes,
+		schedules:   scheduleSpecs(inst.schedules),
+		codeHash:    fmt.Sprintf("%x", h[:]),
+		loadedAt:    time.Now(),
+		limits:      limits,
+		idle:        make(chan *vmInstance, limits.Concurrency.MaxConcurrent),
+		spawn:       make(chan struct{}, limits.Concurrency.MaxConcurrent),
+	}
+	w.idle <- inst
+	for i := 1; i < limits.Concurrency.MaxConcurrent; i++ {
+		w.spawn <- struct{}{}
+	}
+	return w, nil
+}
+
+// newVMInstance creates a runtime, sets up the sandbox, injects APIs, and
+// executes the (already instrumented) code.
+func newVMInstance(workspaceID string, code string, db *sql.DB, limits VMLimits) (*vmInstance, error) {
 	vm := goja.New()
+	vm.SetMaxCallStackSize(limits.MaxCallStackSize)
 
 	setupSandbox(vm)
-	injectConsoleAPI(vm, workspaceID)
-	injectDBAPI(vm, db)
+	limitStringBuiltins(vm, limits.MaxStringLength)
+	limitArrayBuiltins(vm, limits.MaxArrayLength, limits.MaxStringLength)
+	inst := &vmInstance{runtime: vm, limits: limits}
+	inst.secrets = injectSecretsAPI(vm)
+	injectConsoleAPI(vm, workspaceID, inst.secrets.redact)
+	inst.db = injectDBAPI(vm, db, resultLimits{maxRows: limits.MaxResultRows, maxBytes: limits.MaxResultBytes})
+	injectStdlib(vm, inst.db)
+	inst.fetch = injectFetchAPI(vm, limits, inst.secrets)
+	for name, fn := range map[string]func(goja.FunctionCall) goja.Value{
+		vmStepFunc:   inst.step,
+		vmLengthFunc: inst.lengthGuard,
+		vmStringFunc: inst.stringGuard,
+	} {
+		if err := vm.GlobalObject().DefineDataProperty(name, vm.ToValue(fn), goja.FLAG_FALSE, goja.FLAG_FALSE, goja.FLAG_FALSE); err != nil {
+			return nil, fmt.Errorf("vm: %w", err)
+		}
+	}
 
 	// Create exports object
 	vm.Set("exports", vm.NewObject())
 
 	// Execute the user code with timeout
 	var execErr error
-	if err := withTimeout(vm, VMLoadTimeout, func() error {
+	if err := inst.run(VMLimitLoadTimeout, limits.LoadTimeout, func() error {
 		_, execErr = vm.RunString(code)
 		return execErr
 	}); err != nil {
@@ -71,15 +172,13 @@ func NewWorkspaceVM(workspaceID string, code string, db *sql.DB) (*WorkspaceVM,
 	if err != nil {
 		return nil, fmt.Errorf("vm: %w", err)
 	}
-
-	h := sha256.Sum256([]byte(code))
-	return &WorkspaceVM{
-		workspaceID: workspaceID,
-		runtime:     vm,
-		routes:      routes,
-		codeHash:    fmt.Sprintf("%x", h[:]),
-		loadedAt:    time.Now(),
-	}, nil
+	inst.routes = routes
+	schedules, err := extractSchedules(vm)
+	if err != nil {
+		return nil, fmt.Errorf("vm: %w", err)
+	}
+	inst.schedules = schedules
+	return inst, nil
 }
 
 // extractRoutes reads exports.routes from the VM and extracts callable route handlers.
@@ -112,6 +211,11 @@ func extractRoutes(vm *goja.Runtime) (map[string]goja.Callable, error) {
 
 // Handle processes an HTTP request by matching it to a route and executing the JS handler.
 func (w *WorkspaceVM) Handle(req VMRequest) (*VMResponse, error) {
+	return w.HandleContext(context.Background(), req)
+}
+
+// HandleContext is like Handle; ctx bounds the time spent waiting for a free runtime.
+func (w *WorkspaceVM) HandleContext(ctx context.Context, req VMRequest) (*VMResponse, error) {
 	routeKey, params := w.matchRoute(req.Method, req.Path)
 	if routeKey == "" {
 		return &VMResponse{Status: 404, Body: map[string]interface{}{
@@ -119,8 +223,6 @@ func (w *WorkspaceVM) Handle(req VMRequest) (*VMResponse, error) {
 		}}, nil
 	}
 
-	fn := w.routes[routeKey]
-
 	// Merge matched path params into request params
 	if req.Params == nil {
 		req.Params = make(map[string]string)
@@ -129,23 +231,56 @@ func (w *WorkspaceVM) Handle(req VMRequest) (*VMResponse, error) {
 		req.Params[k] = v
 	}
 
+	return w.invoke(ctx, req, func(inst *vmInstance) goja.Callable {
+		return inst.routes[routeKey]
+	}, map[string]interface{}{
+		"method":  req.Method,
+		"path":    req.Path,
+		"params":  req.Params,
+		"query":   req.Query,
+		"body":    req.Body,
+		"headers": req.Headers,
+		"user":    req.User,
+	})
+}
+
+// invoke checks out a runtime, calls the function picked from it with the
+// given ctx argument under the request's scope, egress and secrets, and
+// converts the result into a VMResponse.
+func (w *WorkspaceVM) invoke(ctx context.Context, req VMRequest, pick func(*vmInstance) goja.Callable, arg map[string]interface{}) (*VMResponse, error) {
+	releaseDB, err := w.retainDB()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseDB()
+	inst, err := w.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer w.release(inst)
+
+	fn := pick(inst)
+	inst.db.resolver = req.Scope
+	defer inst.db.reset()
+	inst.secrets.begin(req.Secrets, req.Redactor)
+	defer inst.secrets.reset()
+	inst.fetch.begin(ctx, req, time.Now().Add(w.limits.ExecTimeout))
+	defer inst.fetch.reset()
+
 	var result goja.Value
-	err := withTimeout(w.runtime, VMExecTimeout, func() error {
-		ctxVal := w.runtime.ToValue(map[string]interface{}{
-			"method":  req.Method,
-			"path":    req.Path,
-			"params":  req.Params,
-			"query":   req.Query,
-			"body":    req.Body,
-			"headers": req.Headers,
-			"user":    req.User,
-		})
+	err = inst.run(VMLimitExecTimeout, w.limits.ExecTimeout, func() error {
 		var callErr error
-		result, callErr = fn(goja.Undefined(), ctxVal)
+		result, callErr = fn(goja.Undefined(), inst.runtime.ToValue(arg))
 		return callErr
 	})
 
 	if err != nil {
+		// Errors may quote secret values the handler read; limit and
+		// heap-pressure errors carry none and keep their type for the caller.
+		var limitErr *VMLimitError
+		if !errors.As(err, &limitErr) && !errors.Is(err, ErrVMHeapPressure) {
+			err = errors.New(inst.secrets.redact(err.Error()))
+		}
 		return nil, fmt.Errorf("vm handler error: %w", err)
 	}
 
@@ -1,6 +1,7 @@
 package vmruntime
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"sort"
@@ -9,53 +10,93 @@ import (
 	"github.com/dop251/goja"
 )
 
+// vmDB backs the `db` global of one goja runtime. resolver is swapped in by
+// WorkspaceVM.Handle for the duration of a request; nil means unrestricted.
+// tx is the open transaction while db.transaction runs.
+type vmDB struct {
+	vm       *goja.Runtime
+	db       *sql.DB
+	limits   resultLimits
+	resolver VMScopeResolver
+	tx       *vmTx
+	txDepth  int // nesting depth of db.transaction calls inside tx
+}
+
 // injectDBAPI injects the `db` global object into the goja VM runtime.
-func injectDBAPI(vm *goja.Runtime, db *sql.DB) {
-	dbObj := vm.NewObject()
-	dbObj.Set("query", func(call goja.FunctionCall) goja.Value {
-		return dbQuery(vm, db, call)
+//
+// `db` runs in secure mode: every call applies the row scopes of the current
+// request's app user (see VMRequest.Scope). `db.admin` exposes the same API
+// without any row scope, and `db.asUser()` returns the secure object so code
+// holding `db.admin` can opt back in. Secure `db.query` is read-only and
+// refuses views while any table is scoped, since views bypass the scoping.
+func injectDBAPI(vm *goja.Runtime, db *sql.DB, limits resultLimits) *vmDB {
+	d := &vmDB{vm: vm, db: db, limits: limits}
+	secure := d.newObject(func() VMScopeResolver { return d.resolver })
+	admin := d.newObject(func() VMScopeResolver { return nil })
+
+	asUser := func(call goja.FunctionCall) goja.Value { return secure }
+	secure.Set("asUser", asUser)
+	admin.Set("asUser", asUser)
+	secure.Set("admin", admin)
+	vm.Set("db", secure)
+	return d
+}
+
+// newObject builds a db API object whose calls are restricted by the resolver
+// returned from rls at call time.
+func (d *vmDB) newObject(rls func() VMScopeResolver) *goja.Object {
+	obj := d.vm.NewObject()
+	obj.Set("query", func(call goja.FunctionCall) goja.Value {
+		return dbQuery(d, rls(), call)
 	})
-	dbObj.Set("queryOne", func(call goja.FunctionCall) goja.Value {
-		return dbQueryOne(vm, db, call)
+	obj.Set("queryOne", func(call goja.FunctionCall) goja.Value {
+		return dbQueryOne(d, rls(), call)
 	})
-	dbObj.Set("insert", func(call goja.FunctionCall) goja.Value {
-		return dbInsert(vm, db, call)
+	obj.Set("insert", func(call goja.FunctionCall) goja.Value {
+		return dbInsert(d, rls(), call)
 	})
-	dbObj.Set("update", func(call goja.FunctionCall) goja.Value {
-		return dbUpdate(vm, db, call)
+	obj.Set("update", func(call goja.FunctionCall) goja.Value {
+		return dbUpdate(d, rls(), call)
 	})
-	dbObj.Set("delete", func(call goja.FunctionCall) goja.Value {
-		return dbDelete(vm, db, call)
+	obj.Set("delete", func(call goja.FunctionCall) goja.Value {
+		return dbDelete(d, rls(), call)
 	})
-	dbObj.Set("execute", func(call goja.FunctionCall) goja.Value {
-		return dbExecute(vm, db, call)
+	obj.Set("execute", func(call goja.FunctionCall) goja.Value {
+		return dbExecute(d, rls(), call)
 	})
-	vm.Set("db", dbObj)
+	obj.Set("transaction", func(call goja.FunctionCall) goja.Value {
+		return dbTransaction(d, call)
+	})
+	return obj
 }
 
 // dbQuery implements db.query(sql, params?) — returns array of row objects.
-func dbQuery(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
+func dbQuery(d *vmDB, rls VMScopeResolver, call goja.FunctionCall) goja.Value {
+	vm := d.vm
 	sqlStr, params, err := extractSQLAndParams(vm, call)
 	if err != nil {
 		panic(vm.NewGoError(err))
 	}
 
-	rows, err := scanRows(db, sqlStr, params...)
+	rows, err := scopedSelect(d.conn(), d.limits, rls, sqlStr, params)
 	if err != nil {
+		interruptOnLimit(vm, err)
 		panic(vm.NewGoError(fmt.Errorf("db.query: %w", err)))
 	}
 	return vm.ToValue(rows)
 }
 
 // dbQueryOne implements db.queryOne(sql, params?) — returns single row object or null.
-func dbQueryOne(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
+func dbQueryOne(d *vmDB, rls VMScopeResolver, call goja.FunctionCall) goja.Value {
+	vm := d.vm
 	sqlStr, params, err := extractSQLAndParams(vm, call)
 	if err != nil {
 		panic(vm.NewGoError(err))
 	}
 
-	rows, err := scanRows(db, sqlStr, params...)
+	rows, err := scopedSelect(d.conn(), d.limits, rls, sqlStr, params)
 	if err != nil {
+		interruptOnLimit(vm, err)
 		panic(vm.NewGoError(fmt.Errorf("db.queryOne: %w", err)))
 	}
 	if len(rows) == 0 {
@@ -65,7 +106,8 @@ func dbQueryOne(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value
 }
 
 // dbInsert implements db.insert(table, data) — returns { lastInsertId, affectedRows }.
-func dbInsert(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
+func dbInsert(d *vmDB, rls VMScopeResolver, call goja.FunctionCall) goja.Value {
+	vm := d.vm
 	if len(call.Arguments) < 2 {
 		panic(vm.NewGoError(fmt.Errorf("db.insert requires (table, data)")))
 	}
@@ -75,15 +117,22 @@ func dbInsert(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
 		panic(vm.NewGoError(fmt.Errorf("db.insert: data must be a non-empty object")))
 	}
 
+	scope, owner, err := resolveScope(rls, table, VMScopeInsert)
+	if err != nil {
+		panic(vm.NewGoError(fmt.Errorf("db.insert: %w", err)))
+	}
+	// Owner columns always carry the current user's value.
+	for col, v := range owner {
+		data[col] = v
+	}
+
 	columns, placeholders, values := buildInsertParts(data)
 	query := fmt.Sprintf("INSERT INTO %q (%s) VALUES (%s)", table, columns, placeholders)
 
-	result, err := db.Exec(query, values...)
+	lastID, affected, err := scopedInsert(context.Background(), d.conn(), table, query, values, scope)
 	if err != nil {
 		panic(vm.NewGoError(fmt.Errorf("db.insert: %w", err)))
 	}
-	lastID, _ := result.LastInsertId()
-	affected, _ := result.RowsAffected()
 	return vm.ToValue(map[string]interface{}{
 		"lastInsertId": lastID,
 		"affectedRows": affected,
@@ -91,7 +140,8 @@ func dbInsert(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
 }
 
 // dbUpdate implements db.update(table, data, where) — returns { affectedRows }.
-func dbUpdate(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
+func dbUpdate(d *vmDB, rls VMScopeResolver, call goja.FunctionCall) goja.Value {
+	vm := d.vm
 	if len(call.Arguments) < 3 {
 		panic(vm.NewGoError(fmt.Errorf("db.update requires (table, data, where)")))
 	}
@@ -105,23 +155,32 @@ func dbUpdate(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
 		panic(vm.NewGoError(fmt.Errorf("db.update: where must be a non-empty object")))
 	}
 
+	scope, owner, err := resolveScope(rls, table, VMScopeUpdate)
+	if err != nil {
+		panic(vm.NewGoError(fmt.Errorf("db.update: %w", err)))
+	}
+	// Rows cannot be handed over to another user.
+	for col, want := range owner {
+		if v, ok := data[col]; ok && fmt.Sprint(v) != want {
+			panic(vm.NewGoError(fmt.Errorf("db.update: column %q: %w", col, ErrRowScopeViolation)))
+		}
+	}
+
 	setClause, setArgs := buildSetClause(data)
 	whereClause, whereArgs := buildWhereFromMap(where)
-	args := append(setArgs, whereArgs...)
 
-	query := fmt.Sprintf("UPDATE %q SET %s WHERE %s", table, setClause, whereClause)
-	result, err := db.Exec(query, args...)
+	affected, err := scopedUpdate(context.Background(), d.conn(), table, setClause, setArgs, whereClause, whereArgs, scope)
 	if err != nil {
 		panic(vm.NewGoError(fmt.Errorf("db.update: %w", err)))
 	}
-	affected, _ := result.RowsAffected()
 	return vm.ToValue(map[string]interface{}{
 		"affectedRows": affected,
 	})
 }
 
 // dbDelete implements db.delete(table, where) — returns { affectedRows }.
-func dbDelete(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
+func dbDelete(d *vmDB, rls VMScopeResolver, call goja.FunctionCall) goja.Value {
+	vm := d.vm
 	if len(call.Arguments) < 2 {
 		panic(vm.NewGoError(fmt.Errorf("db.delete requires (table, where)")))
 	}
@@ -131,12 +190,17 @@ func dbDelete(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
 		panic(vm.NewGoError(fmt.Errorf("db.delete: where must be a non-empty object")))
 	}
 
-	whereClause, whereArgs := buildWhereFromMap(where)
-	query := fmt.Sprintf("DELETE FROM %q WHERE %s", table, whereClause)
-	result, err := db.Exec(query, args(whereArgs)...)
+	scope, _, err := resolveScope(rls, table, VMScopeDelete)
 	if err != nil {
 		panic(vm.NewGoError(fmt.Errorf("db.delete: %w", err)))
 	}
+
+	whereClause, whereArgs := scope.and(buildWhereFromMap(where))
+	query := fmt.Sprintf("DELETE FROM %q WHERE %s", table, whereClause)
+	result, err := d.conn().ExecContext(context.Background(), query, args(whereArgs)...)
+	if err != nil {
+		panic(vm.NewGoError(fmt.Errorf("db.delete: %w", quotaError(err))))
+	}
 	affected, _ := result.RowsAffected()
 	return vm.ToValue(map[string]interface{}{
 		"affectedRows": affected,
@@ -144,16 +208,22 @@ func dbDelete(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
 }
 
 // dbExecute implements db.execute(sql, params?) — returns { affectedRows }.
-func dbExecute(vm *goja.Runtime, db *sql.DB, call goja.FunctionCall) goja.Value {
+// In secure mode raw SQL may not touch tables that have a row scope.
+func dbExecute(d *vmDB, rls VMScopeResolver, call goja.FunctionCall) goja.Value {
+	vm := d.vm
 	sqlStr, params, err := extractSQLAndParams(vm, call)
 	if err != nil {
 		panic(vm.NewGoError(err))
 	}
 
-	result, err := db.Exec(sqlStr, params...)
-	if err != nil {
+	if err := checkRawSQLUnscoped(d.conn(), rls, sqlStr); err != nil {
 		panic(vm.NewGoError(fmt.Errorf("db.execute: %w", err)))
 	}
+
+	result, err := d.conn().ExecContext(context.Background(), sqlStr, params...)
+	if err != nil {
+		panic(vm.NewGoError(fmt.Errorf("db.execute: %w", quotaError(err))))
+	}
 	affected, _ := result.RowsAffected()
 	return vm.ToValue(map[string]interface{}{
 		"affectedRows": affected,
@@ -191,8 +261,14 @@ func exportObject(vm *goja.Runtime, val goja.Value) map[string]interface{} {
 	return nil
 }
 
+// sqlQueryer is satisfied by both *sql.DB and *sql.Tx.
+type sqlQueryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
 // scanRows executes a SELECT query and returns rows as []map[string]interface{}.
-func scanRows(db *sql.DB, query string, args ...interface{}) ([]map[string]interface{}, error) {
+// It fails with a *VMLimitError once the result exceeds limits.
+func scanRows(db sqlQueryer, limits resultLimits, query string, args ...interface{}) ([]map[string]interface{}, error) {
 	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
@@ -205,7 +281,11 @@ func scanRows(db *sql.DB, query string, args ...interface{}) ([]map[string]inter
 	}
 
 	var result []map[string]interface{}
+	var size int64
 	for rows.Next() {
+		if len(result) >= limits.maxRows {
+			return nil, &VMLimitError{Limit: VMLimitResultRows, Max: int64(limits.maxRows)}
+		}
 		values := make([]interface{}, len(columns))
 		valuePtrs := make([]interface{}, len(columns))
 		for i := range values {
@@ -217,12 +297,16 @@ func scanRows(db *sql.DB, query string, args ...interface{}) ([]map[string]inter
 		row := make(map[string]interface{}, len(columns))
 		for i, col := range columns {
 			val := values[i]
+			size += int64(len(col)) + valueSize(val)
 			if b, ok := val.([]byte); ok {
 				row[col] = string(b)
 			} else {
 				row[col] = val
 			}
 		}
+		if size > limits.maxBytes {
+			return nil, &VMLimitError{Limit: VMLimitResultBytes, Max: limits.maxBytes}
+		}
 		result = append(result, row)
 	}
 	if err := rows.Err(); err != nil {
@@ -0,0 +1,228 @@
+package vmruntime
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Operations passed to a VMScopeResolver (same values as entity.RLSOperation*).
+const (
+	VMScopeSelect = "select"
+	VMScopeInsert = "insert"
+	VMScopeUpdate = "update"
+	VMScopeDelete = "delete"
+)
+
+// VMScopeResolver returns the row scope the current app user is subject to for
+// an operation on a table, or a nil scope when the table is unrestricted.
+// owner maps columns that must hold the user's value (stamped on insert,
+// immutable on update).
+type VMScopeResolver func(table, operation string) (scope *VMRowScope, owner map[string]string, err error)
+
+// schemaQualifierPattern matches "main." / "temp." style references, which
+// would reach past the scoped CTEs of a secure query.
+var schemaQualifierPattern = regexp.MustCompile(`(?i)(^|[^A-Za-z0-9_])["\x60\[]?(main|temp)["\x60\]]?\s*\.`)
+
+// resolveScope calls rls, treating a nil resolver as unrestricted.
+func resolveScope(rls VMScopeResolver, table, operation string) (*VMRowScope, map[string]string, error) {
+	if rls == nil {
+		return nil, nil, nil
+	}
+	return rls(table, operation)
+}
+
+// errReadOnlyQuery is returned when a secure query tries to modify data.
+var errReadOnlyQuery = fmt.Errorf("db.query only runs read-only statements; use db.insert/update/delete or db.admin")
+
+// scopedSelect runs a read query. In secure mode every table with a select
+// scope is shadowed by a CTE of the same name that only exposes in-scope
+// rows, and the statement runs in a transaction (a savepoint inside
+// db.transaction) so the table list and the query see the same schema.
+// A CTE cannot shadow the target of INSERT/UPDATE/DELETE, so secure queries
+// must be read-only: the leading keyword is checked up front and the
+// statement runs with PRAGMA query_only, which SQLite enforces for anything
+// the keyword check misses (e.g. WITH ... DELETE).
+func scopedSelect(conn sqlConn, limits resultLimits, rls VMScopeResolver, query string, params []interface{}) ([]map[string]interface{}, error) {
+	if rls == nil {
+		return scanRows(conn, limits, query, params...)
+	}
+	if schemaQualifierPattern.MatchString(query) {
+		return nil, fmt.Errorf("schema-qualified table names are not allowed; use db.admin for unrestricted access")
+	}
+	if !readOnlyStatement(query) {
+		return nil, errReadOnlyQuery
+	}
+
+	var rows []map[string]interface{}
+	err := atomically(context.Background(), conn, func(tx sqlConn) error {
+		ctx := context.Background()
+		if _, err := tx.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return err
+		}
+		defer tx.ExecContext(ctx, "PRAGMA query_only = OFF")
+
+		tables, err := listUserTables(tx)
+		if err != nil {
+			return err
+		}
+		var ctes []string
+		var cteArgs []interface{}
+		for _, table := range tables {
+			scope, _, err := rls(table, VMScopeSelect)
+			if err != nil {
+				return err
+			}
+			clause, args := scope.predicate()
+			if clause == "" {
+				continue
+			}
+			ctes = append(ctes, fmt.Sprintf("%q AS (SELECT * FROM main.%q WHERE %s)", table, table, clause))
+			cteArgs = append(cteArgs, args...)
+		}
+		if len(ctes) == 0 {
+			rows, err = scanRows(tx, limits, query, params...)
+		} else {
+			// Views read the base tables directly and would bypass the CTE
+			// shadowing, so they are refused while any table is scoped.
+			views, viewErr := listSchemaObjects(tx, "view")
+			if viewErr != nil {
+				return viewErr
+			}
+			for _, view := range views {
+				if referencesTable(query, view) {
+					return fmt.Errorf("view %q cannot be queried with row-level security; use db.admin for unrestricted access", view)
+				}
+			}
+			rows, err = scanRows(tx, limits, withCTEs(ctes, query), append(cteArgs, params...)...)
+		}
+		if err != nil && strings.Contains(strings.ToLower(err.Error()), "readonly") {
+			return errReadOnlyQuery
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// readOnlyStatement reports whether query starts with SELECT, WITH or VALUES
+// (after leading comments and parentheses).
+func readOnlyStatement(query string) bool {
+	s := query
+	for {
+		s = strings.TrimLeft(s, " \t\r\n(")
+		switch {
+		case strings.HasPrefix(s, "--"):
+			if i := strings.IndexByte(s, '\n'); i >= 0 {
+				s = s[i+1:]
+				continue
+			}
+			return false
+		case strings.HasPrefix(s, "/*"):
+			if i := strings.Index(s, "*/"); i >= 0 {
+				s = s[i+2:]
+				continue
+			}
+			return false
+		}
+		break
+	}
+	for _, keyword := range []string{"SELECT", "WITH", "VALUES"} {
+		if len(s) >= len(keyword) && strings.EqualFold(s[:len(keyword)], keyword) &&
+			(len(s) == len(keyword) || !isIdentChar(s[len(keyword)])) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIdentChar reports whether c can continue an SQL identifier.
+func isIdentChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// withCTEs prepends ctes to query, merging them into a leading WITH clause.
+func withCTEs(ctes []string, query string) string {
+	list := strings.Join(ctes, ", ")
+	trimmed := strings.TrimSpace(query)
+	if rest, ok := cutKeyword(trimmed, "WITH"); ok {
+		if recursive, ok := cutKeyword(rest, "RECURSIVE"); ok {
+			return "WITH RECURSIVE " + list + ", " + recursive
+		}
+		return "WITH " + list + ", " + rest
+	}
+	return "WITH " + list + " " + trimmed
+}
+
+// cutKeyword strips a leading case-insensitive keyword followed by whitespace.
+func cutKeyword(s, keyword string) (string, bool) {
+	if len(s) <= len(keyword) || !strings.EqualFold(s[:len(keyword)], keyword) {
+		return s, false
+	}
+	switch s[len(keyword)] {
+	case ' ', '\t', '\n', '\r':
+		return strings.TrimSpace(s[len(keyword):]), true
+	}
+	return s, false
+}
+
+// checkRawSQLUnscoped rejects raw SQL that mentions a table with any row
+// scope, since arbitrary statements cannot be rewritten safely.
+func checkRawSQLUnscoped(db sqlQueryer, rls VMScopeResolver, query string) error {
+	if rls == nil {
+		return nil
+	}
+	tables, err := listUserTables(db)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		if !referencesTable(query, table) {
+			continue
+		}
+		for _, op := range []string{VMScopeSelect, VMScopeInsert, VMScopeUpdate, VMScopeDelete} {
+			scope, _, err := rls(table, op)
+			if err != nil {
+				return err
+			}
+			if clause, _ := scope.predicate(); clause != "" {
+				return fmt.Errorf("table %q is protected by row level security; use db.insert/update/delete or db.admin.execute", table)
+			}
+		}
+	}
+	return nil
+}
+
+// referencesTable reports whether name appears as an identifier in query.
+// It errs on the side of matching (e.g. a column with the same name).
+func referencesTable(query, name string) bool {
+	pattern := `(?i)(^|[^A-Za-z0-9_$])` + regexp.QuoteMeta(name) + `($|[^A-Za-z0-9_$])`
+	matched, _ := regexp.MatchString(pattern, query)
+	return matched
+}
+
+// listUserTables returns the names of all user tables.
+func listUserTables(db sqlQueryer) ([]string, error) {
+	return listSchemaObjects(db, "table")
+}
+
+// listSchemaObjects returns the names of all user schema objects of a type.
+func listSchemaObjects(db sqlQueryer, kind string) ([]string, error) {
+	rows, err := db.Query("SELECT name FROM sqlite_master WHERE type = ? AND name NOT LIKE 'sqlite_%'", kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
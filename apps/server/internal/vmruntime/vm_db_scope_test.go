@@ -0,0 +1,278 @@
+package vmruntime
+
+import (
+	"strings"
+	"testing"
+)
+
+// ownerScope restricts "notes" to rows owned by userID on every operation.
+func ownerScope(userID string) VMScopeResolver {
+	return func(table, operation string) (*VMRowScope, map[string]string, error) {
+		if table != "notes" {
+			return nil, nil, nil
+		}
+		return &VMRowScope{Clause: `"owner_id" = ?`, Args: []interface{}{userID}},
+			map[string]string{"owner_id": userID}, nil
+	}
+}
+
+// setupScopedVM creates a VM over a "notes" table with rows for two users.
+func setupScopedVM(t *testing.T) *WorkspaceVM {
+	t.Helper()
+	db := newTestDB(t)
+	db.SetMaxOpenConns(1)
+	db.Exec(`CREATE TABLE notes (id INTEGER PRIMARY KEY AUTOINCREMENT, owner_id TEXT, body TEXT)`)
+	db.Exec(`INSERT INTO notes (owner_id, body) VALUES ('alice', 'a1'), ('alice', 'a2'), ('bob', 'b1')`)
+
+	code := `
+		exports.routes = {
+			"GET /notes": function(ctx) {
+				return db.query("SELECT * FROM notes ORDER BY id");
+			},
+			"GET /notes/with": function(ctx) {
+				return db.query("WITH n AS (SELECT * FROM notes) SELECT * FROM n WHERE body LIKE ?", ["%1"]);
+			},
+			"GET /notes/qualified": function(ctx) {
+				return db.query("SELECT * FROM main.notes");
+			},
+			"POST /notes/query": function(ctx) {
+				return db.query(ctx.body.sql);
+			},
+			"GET /notes/admin": function(ctx) {
+				return db.admin.query("SELECT * FROM notes");
+			},
+			"POST /notes/admin": function(ctx) {
+				return db.admin.execute(ctx.body.sql);
+			},
+			"POST /notes": function(ctx) {
+				return db.insert("notes", ctx.body);
+			},
+			"PUT /notes": function(ctx) {
+				return db.update("notes", { body: "changed" }, { id: ctx.body.id });
+			},
+			"PUT /notes/steal": function(ctx) {
+				return db.update("notes", { owner_id: "alice" }, { id: ctx.body.id });
+			},
+			"DELETE /notes": function(ctx) {
+				return db.asUser().delete("notes", { id: ctx.body.id });
+			},
+			"POST /notes/execute": function(ctx) {
+				return db.execute("DELETE FROM notes");
+			}
+		};
+	`
+	vm, err := NewWorkspaceVM("ws-db-scope", code, db)
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM failed: %v", err)
+	}
+	return vm
+}
+
+func TestDBScope_QueryFiltersRows(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/notes", Scope: ownerScope("alice")})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 2 {
+		t.Fatalf("scoped rows = %d, want 2", len(rows))
+	}
+
+	resp, err = vm.Handle(VMRequest{Method: "GET", Path: "/notes/with", Scope: ownerScope("bob")})
+	if err != nil {
+		t.Fatalf("Handle with CTE failed: %v", err)
+	}
+	rows := toSliceOfMaps(t, resp.Body)
+	if len(rows) != 1 || rows[0]["body"] != "b1" {
+		t.Fatalf("scoped CTE rows = %v, want only b1", rows)
+	}
+
+	// Without a scope (e.g. data hooks) db stays unrestricted.
+	resp, _ = vm.Handle(VMRequest{Method: "GET", Path: "/notes"})
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 3 {
+		t.Fatalf("unscoped rows = %d, want 3", len(rows))
+	}
+}
+
+func TestDBScope_AdminBypassesScope(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/notes/admin", Scope: ownerScope("alice")})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 3 {
+		t.Fatalf("admin rows = %d, want 3", len(rows))
+	}
+}
+
+func TestDBScope_RejectsSchemaQualifiedQuery(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	_, err := vm.Handle(VMRequest{Method: "GET", Path: "/notes/qualified", Scope: ownerScope("alice")})
+	if err == nil || !strings.Contains(err.Error(), "schema-qualified") {
+		t.Fatalf("err = %v, want schema-qualified rejection", err)
+	}
+}
+
+func TestDBScope_QueryRejectsWrites(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	for _, sql := range []string{
+		"DELETE FROM notes RETURNING id",
+		"WITH x AS (SELECT 1) DELETE FROM notes RETURNING id",
+		"/* c */ UPDATE notes SET body = 'x' RETURNING id",
+		"INSERT INTO notes (owner_id, body) VALUES ('bob', 'x') RETURNING id",
+	} {
+		_, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes/query", Body: map[string]interface{}{"sql": sql}, Scope: ownerScope("alice")})
+		if err == nil || !strings.Contains(err.Error(), "read-only") {
+			t.Fatalf("%s: err = %v, want read-only rejection", sql, err)
+		}
+	}
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/notes/admin"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 3 {
+		t.Fatalf("rows after rejected writes = %d, want 3", len(rows))
+	}
+	// The connection is usable for writes again after a secure query.
+	if _, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes", Body: map[string]interface{}{"body": "a3"}, Scope: ownerScope("alice")}); err != nil {
+		t.Fatalf("insert after secure query: %v", err)
+	}
+}
+
+func TestDBScope_QueryIgnoresTableCase(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	resp, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes/query", Body: map[string]interface{}{"sql": "SELECT * FROM NOTES"}, Scope: ownerScope("alice")})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 2 {
+		t.Fatalf("rows = %d, want 2 (alice's only)", len(rows))
+	}
+}
+
+func TestDBScope_QueryRejectsViews(t *testing.T) {
+	vm := setupScopedVM(t)
+	if _, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes/admin", Body: map[string]interface{}{"sql": "CREATE VIEW all_notes AS SELECT * FROM notes"}}); err != nil {
+		t.Fatalf("create view: %v", err)
+	}
+
+	_, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes/query", Body: map[string]interface{}{"sql": "SELECT * FROM all_notes"}, Scope: ownerScope("alice")})
+	if err == nil || !strings.Contains(err.Error(), "all_notes") {
+		t.Fatalf("err = %v, want view rejection", err)
+	}
+
+	// Unscoped callers can still read the view.
+	resp, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes/query", Body: map[string]interface{}{"sql": "SELECT * FROM all_notes"}})
+	if err != nil {
+		t.Fatalf("unscoped view query: %v", err)
+	}
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 3 {
+		t.Fatalf("rows = %d, want 3", len(rows))
+	}
+}
+
+func TestReadOnlyStatement(t *testing.T) {
+	for query, want := range map[string]bool{
+		"SELECT 1":                               true,
+		"  (select * from notes)":                true,
+		"-- note\nWITH n AS (SELECT 1) SELECT 1": true,
+		"VALUES (1)":                             true,
+		"SELECTED":                               false,
+		"DELETE FROM notes":                      false,
+		"/* unterminated":                        false,
+		"PRAGMA query_only = OFF":                false,
+	} {
+		if got := readOnlyStatement(query); got != want {
+			t.Errorf("readOnlyStatement(%q) = %v, want %v", query, got, want)
+		}
+	}
+}
+
+func TestDBScope_InsertStampsOwner(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	_, err := vm.Handle(VMRequest{
+		Method: "POST",
+		Path:   "/notes",
+		Body:   map[string]interface{}{"owner_id": "bob", "body": "spoofed"},
+		Scope:  ownerScope("alice"),
+	})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+
+	resp, _ := vm.Handle(VMRequest{Method: "GET", Path: "/notes", Scope: ownerScope("alice")})
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 3 {
+		t.Fatalf("alice rows after insert = %d, want 3", len(rows))
+	}
+}
+
+func TestDBScope_UpdateAndDeleteOnlyOwnRows(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	// Row 3 belongs to bob.
+	resp, err := vm.Handle(VMRequest{Method: "PUT", Path: "/notes", Body: map[string]interface{}{"id": int64(3)}, Scope: ownerScope("alice")})
+	if err != nil {
+		t.Fatalf("update failed: %v", err)
+	}
+	if n := toInt64(resp.Body.(map[string]interface{})["affectedRows"]); n != 0 {
+		t.Fatalf("update other user's row affected %d rows, want 0", n)
+	}
+
+	resp, err = vm.Handle(VMRequest{Method: "DELETE", Path: "/notes", Body: map[string]interface{}{"id": int64(3)}, Scope: ownerScope("alice")})
+	if err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if n := toInt64(resp.Body.(map[string]interface{})["affectedRows"]); n != 0 {
+		t.Fatalf("delete other user's row affected %d rows, want 0", n)
+	}
+
+	resp, err = vm.Handle(VMRequest{Method: "DELETE", Path: "/notes", Body: map[string]interface{}{"id": int64(1)}, Scope: ownerScope("alice")})
+	if err != nil {
+		t.Fatalf("delete own row failed: %v", err)
+	}
+	if n := toInt64(resp.Body.(map[string]interface{})["affectedRows"]); n != 1 {
+		t.Fatalf("delete own row affected %d rows, want 1", n)
+	}
+}
+
+func TestDBScope_UpdateCannotReassignOwner(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	_, err := vm.Handle(VMRequest{Method: "PUT", Path: "/notes/steal", Body: map[string]interface{}{"id": int64(3)}, Scope: ownerScope("bob")})
+	if err == nil || !strings.Contains(err.Error(), "violates scope") {
+		t.Fatalf("err = %v, want scope violation", err)
+	}
+}
+
+func TestDBScope_ExecuteRejectsScopedTable(t *testing.T) {
+	vm := setupScopedVM(t)
+
+	_, err := vm.Handle(VMRequest{Method: "POST", Path: "/notes/execute", Scope: ownerScope("alice")})
+	if err == nil || !strings.Contains(err.Error(), "row level security") {
+		t.Fatalf("err = %v, want row level security rejection", err)
+	}
+}
+
+func TestWithCTEs(t *testing.T) {
+	ctes := []string{`"t" AS (SELECT 1)`}
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM t", `WITH "t" AS (SELECT 1) SELECT * FROM t`},
+		{"with x AS (SELECT 2) SELECT * FROM x", `WITH "t" AS (SELECT 1), x AS (SELECT 2) SELECT * FROM x`},
+		{"WITH RECURSIVE r(n) AS (SELECT 1) SELECT n FROM r", `WITH RECURSIVE "t" AS (SELECT 1), r(n) AS (SELECT 1) SELECT n FROM r`},
+	}
+	for _, tc := range tests {
+		if got := withCTEs(ctes, tc.query); got != tc.want {
+			t.Errorf("withCTEs(%q) = %q, want %q", tc.query, got, tc.want)
+		}
+	}
+}
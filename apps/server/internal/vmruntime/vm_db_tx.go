@@ -0,0 +1,159 @@
+package vmruntime
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/dop251/goja"
+)
+
+// sqlConn is satisfied by both *sql.DB and *sql.Tx. db API calls run on the
+// open transaction while db.transaction is active: the workspace database has
+// a single connection, so a call on *sql.DB would wait for the transaction
+// forever.
+type sqlConn interface {
+	sqlQueryer
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// vmTx is a transaction together with the connector capturing its row
+// changes, so a savepoint rolled back inside it can drop the changes it made.
+type vmTx struct {
+	*sql.Tx
+	changes *vmConnector
+}
+
+// conn returns the connection db API calls run on.
+func (d *vmDB) conn() sqlConn {
+	if d.tx != nil {
+		return d.tx
+	}
+	return d.db
+}
+
+// atomically runs fn in a transaction on conn — a new transaction on *sql.DB,
+// a savepoint on *sql.Tx — keeping its changes only if it returns nil.
+func atomically(ctx context.Context, conn sqlConn, fn func(tx sqlConn) error) error {
+	db, ok := conn.(*sql.DB)
+	if !ok {
+		return withSavepoint(ctx, conn, "vm_atomic", fn)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("vmstore: begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(&vmTx{Tx: tx, changes: changesOf(db)}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("vmstore: commit: %w", quotaError(err))
+	}
+	return nil
+}
+
+// withSavepoint runs fn inside a savepoint of the transaction tx, rolling
+// back to it if fn returns an error or panics.
+func withSavepoint(ctx context.Context, tx sqlConn, name string, fn func(tx sqlConn) error) error {
+	var changes *vmConnector
+	if t, ok := tx.(*vmTx); ok {
+		changes = t.changes
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SAVEPOINT %q", name)); err != nil {
+		return fmt.Errorf("vmstore: savepoint: %w", err)
+	}
+	mark := changes.mark()
+	released := false
+	defer func() {
+		if !released {
+			// ROLLBACK TO keeps the savepoint open; RELEASE removes it.
+			tx.ExecContext(ctx, fmt.Sprintf("ROLLBACK TO %q", name))
+			tx.ExecContext(ctx, fmt.Sprintf("RELEASE %q", name))
+			changes.rollbackTo(mark)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("RELEASE %q", name)); err != nil {
+		return fmt.Errorf("vmstore: release savepoint: %w", quotaError(err))
+	}
+	released = true
+	return nil
+}
+
+// dbTransaction implements db.transaction(fn) — calls fn(db) in a transaction
+// and returns its result. The transaction commits when fn returns and rolls
+// back when it throws or the execution is interrupted (timeout, step or
+// memory limit). Nested calls use savepoints, so an inner failure caught by
+// the outer function only undoes the inner work.
+func dbTransaction(d *vmDB, call goja.FunctionCall) goja.Value {
+	vm := d.vm
+	fn, ok := goja.AssertFunction(call.Argument(0))
+	if !ok {
+		panic(vm.NewTypeError("db.transaction requires a function"))
+	}
+	ctx := context.Background()
+
+	var result goja.Value
+	var jsErr error
+	run := func(sqlConn) error {
+		result, jsErr = fn(goja.Undefined(), call.This)
+		return jsErr
+	}
+
+	var err error
+	if d.tx != nil {
+		d.txDepth++
+		name := fmt.Sprintf("vm_tx_%d", d.txDepth)
+		err = withSavepoint(ctx, d.tx, name, run)
+		d.txDepth--
+	} else {
+		err = d.runTransaction(ctx, run)
+	}
+	if jsErr != nil {
+		// Rethrow exceptions and interrupts from fn unchanged.
+		panic(jsErr)
+	}
+	if err != nil {
+		panic(vm.NewGoError(fmt.Errorf("db.transaction: %w", err)))
+	}
+	return result
+}
+
+// runTransaction opens the outermost transaction and keeps it as d.tx while
+// fn runs. The transaction is always finished before returning, even if fn
+// panics, so the workspace's connection is never left holding it.
+func (d *vmDB) runTransaction(ctx context.Context, fn func(sqlConn) error) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin: %w", err)
+	}
+	d.tx = &vmTx{Tx: tx, changes: changesOf(d.db)}
+	defer func() {
+		d.tx = nil
+		d.txDepth = 0
+		tx.Rollback()
+	}()
+	if err := fn(d.tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit: %w", quotaError(err))
+	}
+	return nil
+}
+
+// reset rolls back a transaction left open by an execution that did not
+// unwind through db.transaction.
+func (d *vmDB) reset() {
+	if d.tx != nil {
+		d.tx.Rollback()
+		d.tx = nil
+		d.txDepth = 0
+	}
+	d.resolver = nil
+}
@@ -0,0 +1,197 @@
+package vmruntime
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// setupTxVM creates a VM over "orders" and "items" tables, where items need
+// a non-null product, on a single-connection database like VMStore's.
+func setupTxVM(t *testing.T, limits VMLimits) (*WorkspaceVM, func() int64) {
+	t.Helper()
+	db := newTestDB(t)
+	db.SetMaxOpenConns(1)
+	db.Exec(`CREATE TABLE orders (id INTEGER PRIMARY KEY AUTOINCREMENT, customer TEXT)`)
+	db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, order_id INTEGER, product TEXT NOT NULL, owner_id TEXT)`)
+
+	code := `
+		function placeOrder(tx, body) {
+			var order = tx.insert("orders", { customer: body.customer });
+			body.items.forEach(function(product) {
+				tx.insert("items", { order_id: order.lastInsertId, product: product });
+			});
+			return { orderId: order.lastInsertId };
+		}
+		exports.routes = {
+			"POST /orders": function(ctx) {
+				return db.transaction(function(tx) { return placeOrder(tx, ctx.body); });
+			},
+			"POST /orders/try": function(ctx) {
+				try {
+					db.transaction(function(tx) { placeOrder(tx, ctx.body); });
+				} catch (e) {
+					return { error: String(e) };
+				}
+				return { error: null };
+			},
+			"POST /orders/nested": function(ctx) {
+				return db.transaction(function(tx) {
+					tx.insert("orders", { customer: "outer" });
+					try {
+						tx.transaction(function(inner) {
+							inner.insert("orders", { customer: "inner" });
+							throw new Error("inner failed");
+						});
+					} catch (e) {}
+					tx.transaction(function(inner) {
+						inner.insert("orders", { customer: "inner-ok" });
+					});
+					return db.query("SELECT customer FROM orders ORDER BY id");
+				});
+			},
+			"POST /orders/scoped": function(ctx) {
+				return db.transaction(function(tx) {
+					tx.insert("orders", { customer: "scoped" });
+					tx.insert("items", { order_id: 1, product: "pen" });
+					return tx.query("SELECT * FROM items");
+				});
+			},
+			"POST /orders/spin": function(ctx) {
+				db.transaction(function(tx) {
+					tx.insert("orders", { customer: "spin" });
+					while (true) {}
+				});
+			}
+		};
+	`
+	vm, err := newWorkspaceVM("ws-db-tx", code, db, limits)
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+	countOrders := func() int64 {
+		t.Helper()
+		done := make(chan int64, 1)
+		go func() {
+			var n int64
+			db.QueryRow(`SELECT COUNT(*) FROM orders`).Scan(&n)
+			done <- n
+		}()
+		select {
+		case n := <-done:
+			return n
+		case <-time.After(5 * time.Second):
+			t.Fatal("database connection still held by a transaction")
+			return 0
+		}
+	}
+	return vm, countOrders
+}
+
+func TestDBTransaction_Commit(t *testing.T) {
+	vm, countOrders := setupTxVM(t, DefaultVMLimits())
+
+	resp, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders", Body: map[string]interface{}{
+		"customer": "ann",
+		"items":    []interface{}{"pen", "ink"},
+	}})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if body := resp.Body.(map[string]interface{}); toInt64(body["orderId"]) != 1 {
+		t.Fatalf("body = %+v, want orderId 1", body)
+	}
+	if n := countOrders(); n != 1 {
+		t.Fatalf("orders = %d, want 1", n)
+	}
+}
+
+func TestDBTransaction_RollbackOnThrow(t *testing.T) {
+	vm, countOrders := setupTxVM(t, DefaultVMLimits())
+
+	// The second item violates NOT NULL, so the order must not be kept.
+	resp, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders/try", Body: map[string]interface{}{
+		"customer": "ann",
+		"items":    []interface{}{"pen", nil},
+	}})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if body := resp.Body.(map[string]interface{}); body["error"] == nil {
+		t.Fatal("expected the failed insert to throw")
+	}
+	if n := countOrders(); n != 0 {
+		t.Fatalf("orders = %d, want 0 after rollback", n)
+	}
+
+	// An uncaught exception also rolls back.
+	if _, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders", Body: map[string]interface{}{
+		"customer": "bob",
+		"items":    []interface{}{nil},
+	}}); err == nil {
+		t.Fatal("expected handler error")
+	}
+	if n := countOrders(); n != 0 {
+		t.Fatalf("orders = %d, want 0 after rollback", n)
+	}
+}
+
+func TestDBTransaction_NestedSavepoints(t *testing.T) {
+	vm, _ := setupTxVM(t, DefaultVMLimits())
+
+	resp, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders/nested"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	rows := toSliceOfMaps(t, resp.Body)
+	if len(rows) != 2 || rows[0]["customer"] != "outer" || rows[1]["customer"] != "inner-ok" {
+		t.Fatalf("rows = %+v, want outer and inner-ok", rows)
+	}
+}
+
+func TestDBTransaction_RowScope(t *testing.T) {
+	vm, countOrders := setupTxVM(t, DefaultVMLimits())
+	scope := func(table, operation string) (*VMRowScope, map[string]string, error) {
+		if table != "items" {
+			return nil, nil, nil
+		}
+		return &VMRowScope{Clause: `"owner_id" = ?`, Args: []interface{}{"ann"}},
+			map[string]string{"owner_id": "ann"}, nil
+	}
+
+	resp, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders/scoped", Scope: scope})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	rows := toSliceOfMaps(t, resp.Body)
+	if len(rows) != 1 || rows[0]["owner_id"] != "ann" {
+		t.Fatalf("rows = %+v, want the scoped item", rows)
+	}
+	if n := countOrders(); n != 1 {
+		t.Fatalf("orders = %d, want 1", n)
+	}
+}
+
+func TestDBTransaction_InterruptRollsBack(t *testing.T) {
+	vm, countOrders := setupTxVM(t, VMLimits{MaxSteps: 10000})
+
+	_, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders/spin"})
+	var limitErr *VMLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != VMLimitSteps {
+		t.Fatalf("err = %v, want steps limit", err)
+	}
+	if n := countOrders(); n != 0 {
+		t.Fatalf("orders = %d, want 0 after interrupt", n)
+	}
+
+	// The runtime keeps working and can open new transactions.
+	if _, err := vm.Handle(VMRequest{Method: "POST", Path: "/orders", Body: map[string]interface{}{
+		"customer": "ann",
+		"items":    []interface{}{"pen"},
+	}}); err != nil {
+		t.Fatalf("Handle after interrupt failed: %v", err)
+	}
+	if n := countOrders(); n != 1 {
+		t.Fatalf("orders = %d, want 1", n)
+	}
+}
@@ -0,0 +1,122 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrVMBusy is returned when every runtime of a workspace is in use and the
+// wait queue is full or the queue timeout expires.
+var ErrVMBusy = errors.New("vm: workspace is busy")
+
+// ErrVMClosed is returned when a VM dropped from the pool is used after its
+// database handle has been closed.
+var ErrVMClosed = errors.New("vm: workspace database handle is closed")
+
+// VMConcurrency bounds how many requests one workspace executes at a time.
+type VMConcurrency struct {
+	// MaxConcurrent is the number of goja runtimes kept per workspace.
+	MaxConcurrent int
+	// MaxQueue is the number of requests that may wait for a free runtime.
+	MaxQueue int
+	// QueueTimeout is how long a queued request waits before ErrVMBusy.
+	QueueTimeout time.Duration
+}
+
+// DefaultVMConcurrency returns the limits used when none are configured.
+func DefaultVMConcurrency() VMConcurrency {
+	return VMConcurrency{
+		MaxConcurrent: 4,
+		MaxQueue:      64,
+		QueueTimeout:  VMExecTimeout,
+	}
+}
+
+// normalize fills zero or negative fields with defaults.
+func (c VMConcurrency) normalize() VMConcurrency {
+	def := DefaultVMConcurrency()
+	if c.MaxConcurrent <= 0 {
+		c.MaxConcurrent = def.MaxConcurrent
+	}
+	if c.MaxQueue < 0 {
+		c.MaxQueue = 0
+	}
+	if c.QueueTimeout <= 0 {
+		c.QueueTimeout = def.QueueTimeout
+	}
+	return c
+}
+
+// acquire checks out a runtime: an idle one, a newly created one while under
+// MaxConcurrent, or else the next one released. Waiters are served in FIFO
+// order because a release hands the runtime to the longest-blocked receiver.
+func (w *WorkspaceVM) acquire(ctx context.Context) (*vmInstance, error) {
+	select {
+	case inst := <-w.idle:
+		return inst, nil
+	default:
+	}
+	select {
+	case <-w.spawn:
+		return w.spawnInstance()
+	default:
+	}
+
+	if w.waiting.Add(1) > int64(w.limits.Concurrency.MaxQueue) {
+		w.waiting.Add(-1)
+		return nil, ErrVMBusy
+	}
+	defer w.waiting.Add(-1)
+
+	timer := time.NewTimer(w.limits.Concurrency.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case inst := <-w.idle:
+		return inst, nil
+	case <-w.spawn:
+		return w.spawnInstance()
+	case <-timer.C:
+		return nil, ErrVMBusy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// spawnInstance creates a runtime for a spawn token, returning the token on failure.
+func (w *WorkspaceVM) spawnInstance() (*vmInstance, error) {
+	inst, err := newVMInstance(w.workspaceID, w.code, w.db, w.limits)
+	if err != nil {
+		w.spawn <- struct{}{}
+		return nil, err
+	}
+	return inst, nil
+}
+
+// touch records a pool hand-out for LRU and idle eviction.
+func (w *WorkspaceVM) touch() {
+	w.lastAccess.Store(time.Now().UnixNano())
+}
+
+// retainDB keeps the workspace database open for one execution. VMs created
+// outside a pool own no store and need no retention.
+func (w *WorkspaceVM) retainDB() (func(), error) {
+	if w.store == nil {
+		return func() {}, nil
+	}
+	release, ok := w.store.retainDB(w.workspaceID, w.db)
+	if !ok {
+		return nil, ErrVMClosed
+	}
+	return release, nil
+}
+
+// release returns a runtime to the idle set.
+func (w *WorkspaceVM) release(inst *vmInstance) {
+	w.idle <- inst
+}
+
+// Stats returns the number of runtimes currently created and requests waiting.
+func (w *WorkspaceVM) Stats() (runtimes int, waiting int) {
+	return w.limits.Concurrency.MaxConcurrent - len(w.spawn), int(w.waiting.Load())
+}
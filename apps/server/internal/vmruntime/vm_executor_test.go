@@ -0,0 +1,179 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ── Concurrency ──────────────────────────────────────────────────────
+
+func TestWorkspaceVM_ConcurrentHandle(t *testing.T) {
+	db := newTestDB(t)
+	db.SetMaxOpenConns(1)
+	db.Exec(`CREATE TABLE hits (id INTEGER PRIMARY KEY AUTOINCREMENT, n INTEGER)`)
+	code := `
+		var counter = 0;
+		exports.routes = {
+			"POST /hit/:n": function(ctx) {
+				counter++;
+				var n = parseInt(ctx.params.n, 10);
+				db.insert("hits", { n: n });
+				var arr = [];
+				for (var i = 0; i < 200; i++) { arr.push(i * n); }
+				return { n: n, sum: arr.length };
+			}
+		};
+	`
+	vm, err := newWorkspaceVM("ws-concurrent", code, db, VMLimits{Concurrency: VMConcurrency{MaxConcurrent: 3, MaxQueue: 1000, QueueTimeout: 30 * time.Second}})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	const goroutines = 32
+	const perGoroutine = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines*perGoroutine)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				n := g*perGoroutine + i
+				resp, err := vm.Handle(VMRequest{Method: "POST", Path: fmt.Sprintf("/hit/%d", n)})
+				if err != nil {
+					errs <- err
+					continue
+				}
+				body := resp.Body.(map[string]interface{})
+				if toInt64(body["n"]) != int64(n) {
+					errs <- fmt.Errorf("response n = %v, want %d", body["n"], n)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM hits`).Scan(&count)
+	if count != goroutines*perGoroutine {
+		t.Fatalf("rows = %d, want %d", count, goroutines*perGoroutine)
+	}
+	if runtimes, waiting := vm.Stats(); runtimes > 3 || waiting != 0 {
+		t.Fatalf("Stats() = (%d, %d), want at most 3 runtimes and 0 waiting", runtimes, waiting)
+	}
+}
+
+func TestWorkspaceVM_QueueFull(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		exports.routes = {
+			"GET /slow": function(ctx) {
+				var end = Date.now() + 200;
+				while (Date.now() < end) {}
+				return { ok: true };
+			}
+		};
+	`
+	vm, err := newWorkspaceVM("ws-queue", code, db, VMLimits{Concurrency: VMConcurrency{MaxConcurrent: 1, MaxQueue: 0, QueueTimeout: time.Second}})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		close(started)
+		vm.Handle(VMRequest{Method: "GET", Path: "/slow"})
+		close(done)
+	}()
+	<-started
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/slow"})
+	if !errors.Is(err, ErrVMBusy) {
+		t.Fatalf("err = %v, want ErrVMBusy", err)
+	}
+	<-done
+}
+
+func TestWorkspaceVM_QueueTimeout(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		exports.routes = {
+			"GET /slow": function(ctx) {
+				var end = Date.now() + 300;
+				while (Date.now() < end) {}
+				return { ok: true };
+			}
+		};
+	`
+	vm, err := newWorkspaceVM("ws-queue-timeout", code, db, VMLimits{Concurrency: VMConcurrency{MaxConcurrent: 1, MaxQueue: 10, QueueTimeout: 20 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		vm.Handle(VMRequest{Method: "GET", Path: "/slow"})
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/slow"})
+	if !errors.Is(err, ErrVMBusy) {
+		t.Fatalf("err = %v, want ErrVMBusy", err)
+	}
+	<-done
+
+	// The runtime is released and serves the next request.
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/slow"})
+	if err != nil || resp.Status != 200 {
+		t.Fatalf("Handle after release = %v, %v", resp, err)
+	}
+}
+
+func TestVMPool_ConcurrentGetOrCreateAndHandle(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{BaseDir: t.TempDir()})
+	defer store.Close()
+	loader := &mockCodeLoader{codes: map[string]string{
+		"ws-hammer": `exports.routes = { "GET /echo/:v": function(ctx) { return { v: ctx.params.v }; } };`,
+	}}
+	pool := NewVMPool(store, loader, VMPoolOptions{
+		MaxVMs: 10,
+		Limits: VMLimits{Concurrency: VMConcurrency{MaxConcurrent: 2, MaxQueue: 1000, QueueTimeout: 30 * time.Second}},
+	})
+	defer pool.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				vm, err := pool.GetOrCreate(context.Background(), "ws-hammer")
+				if err != nil {
+					t.Errorf("GetOrCreate failed: %v", err)
+					return
+				}
+				want := fmt.Sprintf("%d-%d", g, i)
+				resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/echo/" + want})
+				if err != nil {
+					t.Errorf("Handle failed: %v", err)
+					return
+				}
+				if got := resp.Body.(map[string]interface{})["v"]; got != want {
+					t.Errorf("v = %v, want %s", got, want)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,478 @@
+package vmruntime
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// VMEgress is the outbound network policy of a request. fetch refuses every
+// call when VMRequest.Egress is nil.
+type VMEgress struct {
+	// Allow lists the hosts and methods fetch may use; anything else is
+	// refused.
+	Allow []VMEgressRule
+	// OnFetch, if set, is called after every fetch attempt, including
+	// refused and failed ones. It runs on the request's goroutine.
+	OnFetch func(VMFetchEvent)
+}
+
+// VMEgressRule allows requests to Host — an exact name ("api.example.com") or
+// a wildcard for its subdomains ("*.example.com") — with any of Methods, or
+// with any method when Methods is empty.
+type VMEgressRule struct {
+	Host    string   `json:"host"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// fetchMethods are the methods fetch accepts.
+var fetchMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// Normalize lower-cases the host and upper-cases the methods, and checks that
+// the rule is well formed.
+func (r VMEgressRule) Normalize() (VMEgressRule, error) {
+	host := strings.ToLower(strings.TrimSpace(r.Host))
+	name := strings.TrimPrefix(host, "*.")
+	if name == "" || strings.ContainsAny(name, "*/:@ ") || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".") {
+		return r, fmt.Errorf("invalid egress host %q", r.Host)
+	}
+	methods := make([]string, 0, len(r.Methods))
+	for _, m := range r.Methods {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if !fetchMethods[m] {
+			return r, fmt.Errorf("invalid egress method %q", m)
+		}
+		methods = append(methods, m)
+	}
+	return VMEgressRule{Host: host, Methods: methods}, nil
+}
+
+// matches reports whether the rule allows method on host (lower case).
+func (r VMEgressRule) matches(method, host string) bool {
+	if suffix, ok := strings.CutPrefix(r.Host, "*."); ok {
+		if !strings.HasSuffix(host, "."+suffix) {
+			return false
+		}
+	} else if host != r.Host {
+		return false
+	}
+	if len(r.Methods) == 0 {
+		return true
+	}
+	for _, m := range r.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// allows reports whether any rule allows method on host.
+func (e *VMEgress) allows(method, host string) bool {
+	host = strings.ToLower(host)
+	for _, rule := range e.Allow {
+		if rule.matches(method, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// VMFetchEvent describes one fetch call. The query string is left out of
+// Path, and headers and bodies are never reported, since they may carry
+// secrets.
+type VMFetchEvent struct {
+	Method     string
+	Host       string
+	Path       string
+	Status     int   // 0 if no response was received
+	Bytes      int64 // response body bytes read
+	DurationMs int64
+	// Blocked is set when the egress policy or the private address check
+	// refused the call.
+	Blocked bool
+	Error   string
+}
+
+// secretRefPattern matches "{{secrets.NAME}}" references, which fetch
+// replaces in header values and string bodies so secret values never reach
+// the workspace code.
+var secretRefPattern = regexp.MustCompile(`\{\{\s*secrets\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// errFetchBlocked is returned by the dialer for private, loopback and other
+// non-public addresses.
+var errFetchBlocked = errors.New("destination address is not public")
+
+// Redirects followed by a single fetch call; every hop is checked against
+// the egress policy.
+const maxFetchRedirects = 5
+
+// blockedPrefixes are non-public ranges that netip's predicates miss.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64 can reach IPv4 private ranges
+}
+
+// isPublicAddr reports whether fetch may connect to ip.
+func isPublicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return false
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// The fetch transports are shared by every runtime, so connections are pooled
+// per process. The public one checks each address right before connecting,
+// after DNS resolution, which also defeats DNS rebinding. Neither uses the
+// environment's proxy, which would hide the destination from the check.
+var (
+	publicFetchTransport  = newFetchTransport(false)
+	privateFetchTransport = newFetchTransport(true)
+)
+
+func newFetchTransport(allowPrivate bool) *http.Transport {
+	dialer := &net.Dialer{Timeout: VMFetchTimeout, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !isPublicAddr(ip) {
+				return errFetchBlocked
+			}
+			return nil
+		}
+	}
+	return &http.Transport{
+		Proxy:                 nil,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   4,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   VMFetchTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// vmFetch backs the `fetch` global of one goja runtime. The per-request
+// fields are set by WorkspaceVM.HandleContext and cleared by reset.
+type vmFetch struct {
+	vm     *goja.Runtime
+	limits VMLimits
+
+	ctx      context.Context
+	deadline time.Time // end of the execution's time budget
+	egress   *VMEgress
+	secrets  *vmSecrets
+	calls    int
+}
+
+// injectFetchAPI injects the `fetch` global. Unlike the browser API it is
+// synchronous and returns the response directly:
+//
+//	var res = fetch(url, { method?, headers?, body?, timeout? });
+//	res.status, res.ok, res.headers, res.url, res.text(), res.json()
+//
+// An object body is sent as JSON. "{{secrets.NAME}}" in header values and
+// string bodies is replaced with the workspace secret NAME, and is not
+// carried over a redirect to another host. timeout is in
+// milliseconds and cannot exceed the configured fetch timeout.
+func injectFetchAPI(vm *goja.Runtime, limits VMLimits, secrets *vmSecrets) *vmFetch {
+	f := &vmFetch{vm: vm, limits: limits, secrets: secrets}
+	vm.Set("fetch", f.fetch)
+	return f
+}
+
+// begin prepares the fetch API for one request whose execution must finish
+// by deadline.
+func (f *vmFetch) begin(ctx context.Context, req VMRequest, deadline time.Time) {
+	f.ctx = ctx
+	f.deadline = deadline
+	f.egress = req.Egress
+	f.calls = 0
+}
+
+// reset drops the request's policy so it cannot leak into the next request.
+func (f *vmFetch) reset() {
+	f.ctx = nil
+	f.egress = nil
+}
+
+func (f *vmFetch) fetch(call goja.FunctionCall) goja.Value {
+	vm := f.vm
+	rawURL := requireString(vm, call, 0, "fetch(url, options?)")
+	if f.egress == nil {
+		panic(vm.NewGoError(errors.New("fetch: outbound requests are not enabled for this workspace")))
+	}
+	f.calls++
+	if f.calls > f.limits.MaxFetchCalls {
+		err := &VMLimitError{Limit: VMLimitFetchCalls, Max: int64(f.limits.MaxFetchCalls)}
+		vm.Interrupt(err)
+		panic(vm.NewGoError(err))
+	}
+
+	opts := exportObject(vm, call.Argument(1))
+	req, timeout, refs, err := f.buildRequest(rawURL, opts)
+	if err != nil {
+		interruptOnLimit(vm, err)
+		panic(vm.NewGoError(fmt.Errorf("fetch: %w", err)))
+	}
+	event := VMFetchEvent{Method: req.Method, Host: req.URL.Hostname(), Path: req.URL.Path}
+	if !f.egress.allows(req.Method, req.URL.Hostname()) {
+		event.Blocked = true
+		event.Error = "not in the egress allowlist"
+		f.report(event)
+		panic(vm.NewGoError(fmt.Errorf("fetch: %s %s is not in the workspace egress allowlist", req.Method, req.URL.Hostname())))
+	}
+
+	start := time.Now()
+	resp, body, err := f.do(req, timeout, refs)
+	event.DurationMs = time.Since(start).Milliseconds()
+	if resp != nil {
+		event.Status = resp.StatusCode
+		event.Bytes = int64(len(body))
+	}
+	if err != nil {
+		event.Blocked = errors.Is(err, errFetchBlocked)
+		event.Error = err.Error()
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			event.Error = urlErr.Err.Error() // without the URL and its query
+		}
+		event.Error = f.secrets.redact(event.Error)
+		f.report(event)
+		interruptOnLimit(vm, err)
+		panic(vm.NewGoError(fmt.Errorf("fetch: %w", err)))
+	}
+	f.report(event)
+	return f.response(resp, body)
+}
+
+// fetchSecretRefs records which parts of a request had secret references
+// resolved into them; only the original host may receive them.
+type fetchSecretRefs struct {
+	headers []string
+	body    bool
+}
+
+// redirectKeepsSecrets reports whether a redirect from orig to next may carry the
+// request's secrets: the host must not change and https must not be
+// downgraded to plain http.
+func redirectKeepsSecrets(orig, next *url.URL) bool {
+	return strings.EqualFold(next.Host, orig.Host) && (orig.Scheme != "https" || next.Scheme == "https")
+}
+
+// buildRequest validates the URL and options and resolves secret references.
+func (f *vmFetch) buildRequest(rawURL string, opts map[string]interface{}) (*http.Request, time.Duration, fetchSecretRefs, error) {
+	var refs fetchSecretRefs
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, refs, fmt.Errorf("invalid url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, 0, refs, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+	if u.Hostname() == "" || u.User != nil {
+		return nil, 0, refs, errors.New("url must have a host and no credentials")
+	}
+
+	method := http.MethodGet
+	if m, ok := opts["method"].(string); ok && m != "" {
+		method = strings.ToUpper(m)
+	}
+	if !fetchMethods[method] {
+		return nil, 0, refs, fmt.Errorf("unsupported method %q", method)
+	}
+
+	var body []byte
+	contentType := ""
+	switch b := opts["body"].(type) {
+	case nil:
+	case string:
+		resolved, err := f.resolveSecrets(b)
+		if err != nil {
+			return nil, 0, refs, err
+		}
+		refs.body = resolved != b
+		body = []byte(resolved)
+	default:
+		if body, err = json.Marshal(b); err != nil {
+			return nil, 0, refs, fmt.Errorf("body is not JSON serializable: %w", err)
+		}
+		contentType = "application/json"
+	}
+	if int64(len(body)) > f.limits.MaxFetchBytes {
+		return nil, 0, refs, &VMLimitError{Limit: VMLimitFetchBytes, Max: f.limits.MaxFetchBytes}
+	}
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, 0, refs, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if headers, ok := opts["headers"].(map[string]interface{}); ok {
+		for name, v := range headers {
+			raw := fmt.Sprint(v)
+			value, err := f.resolveSecrets(raw)
+			if err != nil {
+				return nil, 0, refs, err
+			}
+			if value != raw {
+				refs.headers = append(refs.headers, name)
+			}
+			req.Header.Set(name, value)
+		}
+	}
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", "reverseai-vm/1")
+	}
+
+	timeout := f.limits.FetchTimeout
+	if ms, ok := toInt(opts["timeout"]); ok && ms > 0 && time.Duration(ms)*time.Millisecond < timeout {
+		timeout = time.Duration(ms) * time.Millisecond
+	}
+	return req, timeout, refs, nil
+}
+
+// resolveSecrets replaces secret references in s.
+func (f *vmFetch) resolveSecrets(s string) (string, error) {
+	var resolveErr error
+	out := secretRefPattern.ReplaceAllStringFunc(s, func(ref string) string {
+		if resolveErr != nil {
+			return ""
+		}
+		name := secretRefPattern.FindStringSubmatch(ref)[1]
+		value, ok, err := f.secrets.lookup(name)
+		switch {
+		case err != nil:
+			resolveErr = err
+		case !ok:
+			resolveErr = fmt.Errorf("secret %q is not defined", name)
+		}
+		return value
+	})
+	return out, resolveErr
+}
+
+// do sends req within timeout and the remaining execution time, and reads
+// the response body up to MaxFetchBytes. A redirect to another host, or
+// from https to http, drops the headers in refs and is refused if it would
+// resend a body in refs.
+func (f *vmFetch) do(req *http.Request, timeout time.Duration, refs fetchSecretRefs) (*http.Response, []byte, error) {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	if !f.deadline.IsZero() {
+		var cancelExec context.CancelFunc
+		ctx, cancelExec = context.WithDeadline(ctx, f.deadline)
+		defer cancelExec()
+	}
+
+	transport := publicFetchTransport
+	if f.limits.FetchAllowPrivate {
+		transport = privateFetchTransport
+	}
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(next *http.Request, via []*http.Request) error {
+			if len(via) >= maxFetchRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxFetchRedirects)
+			}
+			if !f.egress.allows(next.Method, next.URL.Hostname()) {
+				return fmt.Errorf("redirect to %s is not in the workspace egress allowlist", next.URL.Hostname())
+			}
+			if redirectKeepsSecrets(req.URL, next.URL) {
+				return nil
+			}
+			if refs.body && next.ContentLength != 0 {
+				return fmt.Errorf("redirect to %s would resend a body containing secrets", next.URL.Hostname())
+			}
+			// Headers are copied from the original request on every hop.
+			for _, name := range refs.headers {
+				next.Header.Del(name)
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, f.limits.MaxFetchBytes+1))
+	if err != nil {
+		return resp, body, err
+	}
+	if int64(len(body)) > f.limits.MaxFetchBytes {
+		return resp, body, &VMLimitError{Limit: VMLimitFetchBytes, Max: f.limits.MaxFetchBytes}
+	}
+	return resp, body, nil
+}
+
+// response builds the JS response object.
+func (f *vmFetch) response(resp *http.Response, body []byte) goja.Value {
+	vm := f.vm
+	headers := make(map[string]interface{}, len(resp.Header))
+	for name, values := range resp.Header {
+		headers[strings.ToLower(name)] = strings.Join(values, ", ")
+	}
+
+	obj := vm.NewObject()
+	obj.Set("status", resp.StatusCode)
+	obj.Set("ok", resp.StatusCode >= 200 && resp.StatusCode < 300)
+	obj.Set("url", resp.Request.URL.String())
+	obj.Set("headers", headers)
+	text := string(body)
+	obj.Set("text", func(goja.FunctionCall) goja.Value {
+		return vm.ToValue(text)
+	})
+	obj.Set("json", func(goja.FunctionCall) goja.Value {
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("fetch: response is not JSON: %w", err)))
+		}
+		return vm.ToValue(v)
+	})
+	return obj
+}
+
+// report passes event to the request's OnFetch callback.
+func (f *vmFetch) report(event VMFetchEvent) {
+	if f.egress != nil && f.egress.OnFetch != nil {
+		f.egress.OnFetch(event)
+	}
+}
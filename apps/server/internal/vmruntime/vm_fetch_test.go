@@ -0,0 +1,295 @@
+package vmruntime
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setupFetchVM creates a VM whose single route runs body against an httptest
+// server reachable as `base`. Private addresses are allowed, since the test
+// server listens on loopback.
+func setupFetchVM(t *testing.T, handler http.HandlerFunc, body string, limits VMLimits) (*WorkspaceVM, string) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	limits.FetchAllowPrivate = true
+	code := `var base = ` + fmt.Sprintf("%q", srv.URL) + `;
+		exports.routes = { "GET /test": function(ctx) {` + body + `} };`
+	vm, err := newWorkspaceVM("ws-fetch", code, newTestDB(t), limits)
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+	u, _ := url.Parse(srv.URL)
+	return vm, u.Hostname()
+}
+
+// fetchEgress allows rules and records fetch events into events.
+func fetchEgress(events *[]VMFetchEvent, rules ...VMEgressRule) *VMEgress {
+	return &VMEgress{Allow: rules, OnFetch: func(e VMFetchEvent) { *events = append(*events, e) }}
+}
+
+func TestFetch_AllowedWithSecrets(t *testing.T) {
+	vm, host := setupFetchVM(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		sent, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, `{"auth":%q,"method":%q,"body":%q}`, r.Header.Get("Authorization"), r.Method, sent)
+	}, `
+		var res = fetch(base + "/hook?token=abc", {
+			method: "POST",
+			headers: { Authorization: "Bearer {{secrets.API_KEY}}" },
+			body: { id: 1 }
+		});
+		var missing;
+		try { fetch(base, { headers: { "X-Key": "{{secrets.NOPE}}" } }); } catch (e) { missing = String(e); }
+		return { code: res.status, ok: res.ok, type: res.headers["content-type"], data: res.json(), missing: missing };
+	`, DefaultVMLimits())
+
+	var events []VMFetchEvent
+	secrets := func(name string) (string, bool, error) {
+		if name == "API_KEY" {
+			return "s3cret", true, nil
+		}
+		return "", false, nil
+	}
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/test",
+		Egress: fetchEgress(&events, VMEgressRule{Host: host, Methods: []string{"GET", "POST"}}), Secrets: secrets})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	body := resp.Body.(map[string]interface{})
+	data := body["data"].(map[string]interface{})
+	if data["auth"] != "Bearer s3cret" || data["method"] != "POST" || data["body"] != `{"id":1}` {
+		t.Fatalf("server saw %+v", data)
+	}
+	if toInt64(body["code"]) != 200 || body["ok"] != true || body["type"] != "application/json" {
+		t.Fatalf("body = %+v", body)
+	}
+	if missing, _ := body["missing"].(string); !strings.Contains(missing, `secret "NOPE" is not defined`) {
+		t.Fatalf("missing = %v", body["missing"])
+	}
+	if len(events) != 1 {
+		t.Fatalf("events = %+v, want 1 (the missing secret fails before sending)", events)
+	}
+	if e := events[0]; e.Method != "POST" || e.Path != "/hook" || e.Status != 200 || e.Blocked {
+		t.Fatalf("event = %+v", e)
+	}
+}
+
+func TestFetch_EgressPolicy(t *testing.T) {
+	hits := 0
+	vm, host := setupFetchVM(t, func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		if r.URL.Path == "/redirect" {
+			http.Redirect(w, r, "http://example.com/", http.StatusFound)
+		}
+	}, `
+		function attempt(url, method) {
+			try { fetch(url, { method: method }); return "ok"; } catch (e) { return String(e); }
+		}
+		return {
+			get:      attempt(base, "GET"),
+			post:     attempt(base, "POST"),
+			redirect: attempt(base + "/redirect", "GET"),
+			scheme:   attempt("file:///etc/passwd", "GET")
+		};
+	`, DefaultVMLimits())
+
+	var events []VMFetchEvent
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/test",
+		Egress: fetchEgress(&events, VMEgressRule{Host: host, Methods: []string{"GET"}})})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	body := resp.Body.(map[string]interface{})
+	if body["get"] != "ok" {
+		t.Fatalf("get = %v", body["get"])
+	}
+	if post, _ := body["post"].(string); !strings.Contains(post, "egress allowlist") {
+		t.Fatalf("post = %v, want refused", body["post"])
+	}
+	if redirect, _ := body["redirect"].(string); !strings.Contains(redirect, "redirect to example.com") {
+		t.Fatalf("redirect = %v, want refused", body["redirect"])
+	}
+	if scheme, _ := body["scheme"].(string); !strings.Contains(scheme, "unsupported url scheme") {
+		t.Fatalf("scheme = %v", body["scheme"])
+	}
+	if hits != 2 {
+		t.Fatalf("server hits = %d, want 2 (the refused POST is never sent)", hits)
+	}
+	if len(events) != 3 || !events[1].Blocked {
+		t.Fatalf("events = %+v, want the POST recorded as blocked", events)
+	}
+
+	// Without an egress policy fetch is disabled.
+	resp, err = vm.Handle(VMRequest{Method: "GET", Path: "/test"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if get, _ := resp.Body.(map[string]interface{})["get"].(string); !strings.Contains(get, "not enabled") {
+		t.Fatalf("get = %v, want fetch disabled", get)
+	}
+}
+
+func TestFetch_CrossHostRedirectDropsSecrets(t *testing.T) {
+	vm, host := setupFetchVM(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/echo", http.StatusTemporaryRedirect)
+		case "/hop":
+			_, port, _ := strings.Cut(r.Host, ":")
+			http.Redirect(w, r, "http://localhost:"+port+"/echo", http.StatusTemporaryRedirect)
+		default:
+			sent, _ := io.ReadAll(r.Body)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"key":%q,"trace":%q,"body":%q}`, r.Header.Get("X-Api-Key"), r.Header.Get("X-Trace"), sent)
+		}
+	}, `
+		var headers = { "X-Api-Key": "{{secrets.API_KEY}}", "X-Trace": "t1" };
+		var same = fetch(base + "/same", { method: "POST", headers: headers, body: "plain" }).json();
+		var cross = fetch(base + "/hop", { method: "POST", headers: headers, body: "plain" }).json();
+		var refused;
+		try { fetch(base + "/hop", { method: "POST", body: "key={{secrets.API_KEY}}" }); } catch (e) { refused = String(e); }
+		return { same: same, cross: cross, refused: refused };
+	`, DefaultVMLimits())
+
+	var events []VMFetchEvent
+	secrets := func(name string) (string, bool, error) { return "s3cret", true, nil }
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/test", Secrets: secrets,
+		Egress: fetchEgress(&events, VMEgressRule{Host: host}, VMEgressRule{Host: "localhost"})})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	body := resp.Body.(map[string]interface{})
+	if same := body["same"].(map[string]interface{}); same["key"] != "s3cret" || same["body"] != "plain" {
+		t.Fatalf("same-host redirect: server saw %+v, want the secret header kept", same)
+	}
+	if cross := body["cross"].(map[string]interface{}); cross["key"] != "" || cross["trace"] != "t1" || cross["body"] != "plain" {
+		t.Fatalf("cross-host redirect: server saw %+v, want only the secret header dropped", cross)
+	}
+	if refused, _ := body["refused"].(string); !strings.Contains(refused, "would resend a body containing secrets") {
+		t.Fatalf("refused = %v, want the secret body not resent", body["refused"])
+	}
+}
+
+func TestFetch_RedirectDowngradeDropsSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		from, to string
+		keep     bool
+	}{
+		{"https://api.example.com/a", "https://api.example.com/b", true},
+		{"https://api.example.com/a", "https://API.example.com/b", true},
+		{"http://api.example.com/a", "https://api.example.com/b", true},
+		{"http://api.example.com/a", "http://api.example.com/b", true},
+		{"https://api.example.com/a", "http://api.example.com/b", false},
+		{"https://api.example.com/a", "https://api.example.com:8443/b", false},
+		{"https://api.example.com/a", "https://evil.example.com/b", false},
+	} {
+		from, _ := url.Parse(tc.from)
+		to, _ := url.Parse(tc.to)
+		if got := redirectKeepsSecrets(from, to); got != tc.keep {
+			t.Errorf("redirectKeepsSecrets(%s, %s) = %v, want %v", tc.from, tc.to, got, tc.keep)
+		}
+	}
+}
+
+func TestFetch_BlocksPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("request reached a loopback server")
+	}))
+	defer srv.Close()
+	code := `exports.routes = { "GET /test": function(ctx) { return fetch(ctx.query.url).status; } };`
+	vm, err := newWorkspaceVM("ws-fetch-ssrf", code, newTestDB(t), DefaultVMLimits())
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	var events []VMFetchEvent
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/test", Query: map[string]string{"url": srv.URL},
+		Egress: fetchEgress(&events, VMEgressRule{Host: "127.0.0.1"})})
+	if err == nil || !strings.Contains(err.Error(), "not public") {
+		t.Fatalf("err = %v, want the loopback address refused", err)
+	}
+	if len(events) != 1 || !events[0].Blocked {
+		t.Fatalf("events = %+v, want a blocked event", events)
+	}
+
+	for addr, public := range map[string]bool{
+		"8.8.8.8":         true,
+		"2606:4700::1111": true,
+		"127.0.0.1":       false,
+		"10.1.2.3":        false,
+		"169.254.169.254": false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"::1":             false,
+		"fd00::1":         false,
+		"::ffff:10.0.0.1": false,
+	} {
+		if got := isPublicAddr(netip.MustParseAddr(addr)); got != public {
+			t.Errorf("isPublicAddr(%s) = %v, want %v", addr, got, public)
+		}
+	}
+}
+
+func TestFetch_Budgets(t *testing.T) {
+	vm, host := setupFetchVM(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Write([]byte(strings.Repeat("x", 2048)))
+		case "/slow":
+			time.Sleep(500 * time.Millisecond)
+		}
+	}, `
+		switch (ctx.query.case) {
+		case "big":
+			return fetch(base + "/big").text();
+		case "slow":
+			try { fetch(base + "/slow", { timeout: 50 }); } catch (e) { return String(e); }
+			return "no timeout";
+		case "calls":
+			for (var i = 0; i < 5; i++) { fetch(base); }
+		}
+	`, VMLimits{MaxFetchBytes: 1024, MaxFetchCalls: 3})
+	egress := &VMEgress{Allow: []VMEgressRule{{Host: host}}}
+	handle := func(c string) (*VMResponse, error) {
+		return vm.Handle(VMRequest{Method: "GET", Path: "/test", Query: map[string]string{"case": c}, Egress: egress})
+	}
+
+	var limitErr *VMLimitError
+	if _, err := handle("big"); !errors.As(err, &limitErr) || limitErr.Limit != VMLimitFetchBytes {
+		t.Fatalf("err = %v, want fetch_bytes limit", err)
+	}
+	if _, err := handle("calls"); !errors.As(err, &limitErr) || limitErr.Limit != VMLimitFetchCalls {
+		t.Fatalf("err = %v, want fetch_calls limit", err)
+	}
+	resp, err := handle("slow")
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if msg, _ := resp.Body.(string); !strings.Contains(msg, "deadline exceeded") {
+		t.Fatalf("body = %v, want a timeout", resp.Body)
+	}
+}
+
+func TestVMEgressRule_Normalize(t *testing.T) {
+	rule, err := VMEgressRule{Host: " *.Example.com ", Methods: []string{"post"}}.Normalize()
+	if err != nil || rule.Host != "*.example.com" || rule.Methods[0] != "POST" {
+		t.Fatalf("rule = %+v, err = %v", rule, err)
+	}
+	if !rule.matches("POST", "api.example.com") || rule.matches("POST", "example.com") || rule.matches("GET", "api.example.com") {
+		t.Fatalf("wildcard rule matched wrongly")
+	}
+	for _, bad := range []VMEgressRule{{Host: ""}, {Host: "*"}, {Host: "a.com/path"}, {Host: "a.com:8080"}, {Host: "a.com", Methods: []string{"TRACE"}}} {
+		if _, err := bad.Normalize(); err == nil {
+			t.Errorf("Normalize(%+v) succeeded", bad)
+		}
+	}
+}
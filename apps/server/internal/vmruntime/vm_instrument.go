@@ -0,0 +1,243 @@
+package vmruntime
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dop251/goja/ast"
+	"github.com/dop251/goja/file"
+	"github.com/dop251/goja/parser"
+	"github.com/dop251/goja/token"
+)
+
+// Globals that instrumented code calls: vmStepFunc consumes one step,
+// vmLengthFunc checks a value assigned to a length property and vmStringFunc
+// the result of a string concatenation or template literal.
+const (
+	vmStepFunc   = "__vmStep"
+	vmLengthFunc = "__vmLen"
+	vmStringFunc = "__vmStr"
+)
+
+var vmReservedPattern = regexp.MustCompile(`\b(` + vmStepFunc + `|` + vmLengthFunc + `|` + vmStringFunc + `)\b`)
+
+// instrumentCode inserts a __vmStep() call at the top of every loop body and
+// function body so that instruction budgets can be enforced without touching
+// goja internals. Non-block loop bodies are wrapped in braces and concise
+// arrow bodies in a comma expression. Values assigned to length properties
+// are wrapped in __vmLen(...), and `+`, `+=` and template literals in
+// __vmStr(...), so array and string growth limits also hold for code that
+// grows them without calling a builtin. Code that cannot be instrumented is
+// rejected rather than run without these budgets.
+func instrumentCode(code string) (string, error) {
+	if m := vmReservedPattern.FindString(code); m != "" {
+		return "", fmt.Errorf("identifier %s is reserved", m)
+	}
+	program, err := parser.ParseFile(nil, "", code, 0)
+	if err != nil {
+		// Leave syntax errors to goja so they are reported as usual.
+		return code, nil
+	}
+
+	var edits []codeEdit
+	add := func(idx file.Idx, text string, closer bool) {
+		edits = append(edits, codeEdit{offset: int(idx) - 1, text: text, closer: closer, seq: len(edits)})
+	}
+	stepCall := vmStepFunc + "();"
+	var unplaced []int
+	wrapStatement := func(body ast.Statement) {
+		if block, ok := body.(*ast.BlockStatement); ok {
+			add(block.LeftBrace+1, stepCall, false)
+			return
+		}
+		start := statementStart(code, body)
+		if start < 1 {
+			unplaced = append(unplaced, int(body.Idx0())-1)
+			return
+		}
+		add(start, "{"+stepCall, false)
+		end := int(body.Idx1()) - 1
+		// Keep the statement's terminating semicolon inside the braces.
+		for end < len(code) && (code[end] == ' ' || code[end] == '\t') {
+			end++
+		}
+		if end < len(code) && code[end] == ';' {
+			add(file.Idx(end+2), "}", true)
+		} else {
+			add(body.Idx1(), "}", true)
+		}
+	}
+
+	// The guards return their last argument, so an expression whose start
+	// lies inside a parenthesized comma expression keeps its value.
+	wrapExpression := func(e ast.Expression, fn string) {
+		add(e.Idx0(), fn+"(", false)
+		add(e.Idx1(), ")", true)
+	}
+
+	visited := make(map[astPtr]bool)
+	walkAST(reflect.ValueOf(program), visited, func(node ast.Node) {
+		switch n := node.(type) {
+		case *ast.ForStatement:
+			wrapStatement(n.Body)
+		case *ast.ForInStatement:
+			wrapStatement(n.Body)
+		case *ast.ForOfStatement:
+			wrapStatement(n.Body)
+		case *ast.WhileStatement:
+			wrapStatement(n.Body)
+		case *ast.DoWhileStatement:
+			wrapStatement(n.Body)
+		case *ast.FunctionLiteral:
+			if n.Body != nil {
+				add(n.Body.LeftBrace+1, stepCall, false)
+			}
+		case *ast.AssignExpression:
+			if n.Operator == token.PLUS {
+				wrapExpression(n, vmStringFunc)
+			} else if n.Operator == token.ASSIGN && isLengthTarget(n.Left) {
+				wrapExpression(n.Right, vmLengthFunc)
+			}
+		case *ast.BinaryExpression:
+			if n.Operator == token.PLUS {
+				wrapExpression(n, vmStringFunc)
+			}
+		case *ast.TemplateLiteral:
+			if n.Tag == nil {
+				wrapExpression(n, vmStringFunc)
+			}
+		case *ast.ArrowFunctionLiteral:
+			switch body := n.Body.(type) {
+			case *ast.BlockStatement:
+				add(body.LeftBrace+1, stepCall, false)
+			case *ast.ExpressionBody:
+				add(body.Idx0(), "("+vmStepFunc+"(), ", false)
+				add(body.Idx1(), ")", true)
+			}
+		}
+	})
+	if len(unplaced) > 0 {
+		return "", fmt.Errorf("step instrumentation failed: cannot locate loop body at offset %d", unplaced[0])
+	}
+	if len(edits) == 0 {
+		return code, nil
+	}
+
+	out := applyEdits(code, edits)
+	if _, err := parser.ParseFile(nil, "", out, 0); err != nil {
+		return "", fmt.Errorf("step instrumentation failed: %w", err)
+	}
+	return out, nil
+}
+
+// isLengthTarget reports whether e is a `x.length` or `x["length"]` target.
+func isLengthTarget(e ast.Expression) bool {
+	switch t := e.(type) {
+	case *ast.DotExpression:
+		return t.Identifier.Name == "length"
+	case *ast.BracketExpression:
+		lit, ok := t.Member.(*ast.StringLiteral)
+		return ok && lit.Value.String() == "length"
+	}
+	return false
+}
+
+// statementStart returns where s begins, or 0 if unknown. goja does not record
+// the position of the `if` keyword, so it is found before the condition.
+func statementStart(code string, s ast.Statement) file.Idx {
+	ifStmt, ok := s.(*ast.IfStatement)
+	if !ok || ifStmt.If > 0 {
+		return s.Idx0()
+	}
+	i := int(ifStmt.Test.Idx0()) - 2
+	for i >= 0 && (code[i] == '(' || code[i] == ' ' || code[i] == '\t' || code[i] == '\n' || code[i] == '\r') {
+		i--
+	}
+	if i >= 1 && code[i-1:i+1] == "if" {
+		return file.Idx(i)
+	}
+	return 0
+}
+
+// codeEdit is a text insertion at a byte offset of the source.
+type codeEdit struct {
+	offset int
+	text   string
+	closer bool
+	seq    int // discovery order; outer nodes are discovered first
+}
+
+// applyEdits inserts all edits. At the same offset closers go before openers,
+// inner closers before outer ones and outer openers before inner ones.
+func applyEdits(code string, edits []codeEdit) string {
+	sort.SliceStable(edits, func(i, j int) bool {
+		a, b := edits[i], edits[j]
+		if a.offset != b.offset {
+			return a.offset < b.offset
+		}
+		if a.closer != b.closer {
+			return a.closer
+		}
+		if a.closer {
+			return a.seq > b.seq
+		}
+		return a.seq < b.seq
+	})
+
+	var sb strings.Builder
+	last := 0
+	for _, e := range edits {
+		if e.offset < last || e.offset > len(code) {
+			continue
+		}
+		sb.WriteString(code[last:e.offset])
+		sb.WriteString(e.text)
+		last = e.offset
+	}
+	sb.WriteString(code[last:])
+	return sb.String()
+}
+
+var astNodeType = reflect.TypeOf((*ast.Node)(nil)).Elem()
+
+// astPtr identifies a visited pointer; the type tells apart a struct and its
+// first field, which share an address.
+type astPtr struct {
+	typ  reflect.Type
+	addr uintptr
+}
+
+// walkAST visits every AST node reachable from v in pre-order. Nodes shared
+// between fields (e.g. DeclarationList) are visited once.
+func walkAST(v reflect.Value, visited map[astPtr]bool, visit func(ast.Node)) {
+	switch v.Kind() {
+	case reflect.Interface:
+		if !v.IsNil() {
+			walkAST(v.Elem(), visited, visit)
+		}
+	case reflect.Ptr:
+		if v.IsNil() {
+			return
+		}
+		key := astPtr{v.Type(), v.Pointer()}
+		if visited[key] {
+			return
+		}
+		visited[key] = true
+		if v.Type().Implements(astNodeType) && v.CanInterface() {
+			visit(v.Interface().(ast.Node))
+		}
+		walkAST(v.Elem(), visited, visit)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			walkAST(v.Field(i), visited, visit)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			walkAST(v.Index(i), visited, visit)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package vmruntime
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dop251/goja"
+)
+
+// kvTable stores the `kv` module's entries in the workspace database. The
+// internal prefix hides it from the dashboard and migrations.
+const kvTable = internalTablePrefix + "kv"
+
+// Bounds of the kv module.
+const (
+	maxKVKeyLength  = 512     // bytes
+	maxKVValueBytes = 1 << 20 // bytes of JSON per value
+	maxKVListKeys   = 1000    // keys per kv.list call
+)
+
+// injectKVAPI injects the `kv` global, a small key/value store shared by all
+// requests of the workspace. Values are stored as JSON; calls join the open
+// db.transaction, if any. Row level security does not apply.
+//
+//	kv.get(key)                     value, or null if missing or expired
+//	kv.set(key, value, { ttl? })    ttl in seconds
+//	kv.delete(key)                  true if the key existed
+//	kv.incr(key, by?)               adds by (default 1) and returns the new number
+//	kv.list(prefix?, limit?)        keys in order
+func injectKVAPI(vm *goja.Runtime, d *vmDB) {
+	obj := vm.NewObject()
+	obj.Set("get", func(call goja.FunctionCall) goja.Value {
+		key := kvKey(vm, call, "kv.get(key)")
+		var value interface{}
+		found, err := kvGet(d.conn(), key, &value)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("kv.get: %w", err)))
+		}
+		if !found {
+			return goja.Null()
+		}
+		return vm.ToValue(value)
+	})
+	obj.Set("set", func(call goja.FunctionCall) goja.Value {
+		key := kvKey(vm, call, "kv.set(key, value)")
+		value := call.Argument(1)
+		if goja.IsUndefined(value) {
+			panic(vm.NewTypeError("kv.set(key, value) requires a value"))
+		}
+		var ttl time.Duration
+		if opts := exportObject(vm, call.Argument(2)); opts != nil {
+			if seconds, ok := toInt(opts["ttl"]); ok && seconds > 0 {
+				ttl = time.Duration(seconds) * time.Second
+			}
+		}
+		if err := kvSet(d.conn(), key, value.Export(), ttl); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("kv.set: %w", err)))
+		}
+		return goja.Undefined()
+	})
+	obj.Set("delete", func(call goja.FunctionCall) goja.Value {
+		key := kvKey(vm, call, "kv.delete(key)")
+		deleted, err := kvDelete(d.conn(), key)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("kv.delete: %w", err)))
+		}
+		return vm.ToValue(deleted)
+	})
+	obj.Set("incr", func(call goja.FunctionCall) goja.Value {
+		key := kvKey(vm, call, "kv.incr(key)")
+		by := 1.0
+		if arg := call.Argument(1); !goja.IsUndefined(arg) {
+			by = arg.ToFloat()
+		}
+		n, err := kvIncr(d.conn(), key, by)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("kv.incr: %w", err)))
+		}
+		return vm.ToValue(n)
+	})
+	obj.Set("list", func(call goja.FunctionCall) goja.Value {
+		prefix := ""
+		if arg := call.Argument(0); !goja.IsUndefined(arg) && !goja.IsNull(arg) {
+			prefix = arg.String()
+		}
+		limit := maxKVListKeys
+		if n, ok := toInt(call.Argument(1).Export()); ok && n > 0 && n < limit {
+			limit = n
+		}
+		keys, err := kvList(d.conn(), prefix, limit)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("kv.list: %w", err)))
+		}
+		return vm.ToValue(keys)
+	})
+	vm.Set("kv", obj)
+}
+
+// kvKey returns the key argument, checking its length.
+func kvKey(vm *goja.Runtime, call goja.FunctionCall, usage string) string {
+	key := requireString(vm, call, 0, usage)
+	if key == "" || len(key) > maxKVKeyLength {
+		panic(vm.NewTypeError(fmt.Sprintf("%s: key must be 1 to %d bytes", usage, maxKVKeyLength)))
+	}
+	return key
+}
+
+// ensureKVTable creates the kv table if needed. It runs on every call since
+// a snapshot restore can replace the database underneath a cached VM.
+func ensureKVTable(conn sqlConn) error {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS %q (key TEXT PRIMARY KEY, value TEXT NOT NULL, expires_at INTEGER)`, kvTable)); err != nil {
+		return quotaError(err)
+	}
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(
+		`CREATE INDEX IF NOT EXISTS %q ON %q (expires_at)`, kvTable+"_expires", kvTable))
+	return quotaError(err)
+}
+
+// kvGet decodes the live value of key into dest.
+func kvGet(conn sqlConn, key string, dest interface{}) (bool, error) {
+	if err := ensureKVTable(conn); err != nil {
+		return false, err
+	}
+	var raw string
+	err := conn.QueryRowContext(context.Background(), fmt.Sprintf(
+		`SELECT value FROM %q WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`, kvTable),
+		key, time.Now().UnixMilli()).Scan(&raw)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal([]byte(raw), dest)
+}
+
+// kvSet stores value under key, expiring after ttl when positive. Expired
+// entries are purged on the way.
+func kvSet(conn sqlConn, key string, value interface{}, ttl time.Duration) error {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("value is not JSON serializable: %w", err)
+	}
+	if len(raw) > maxKVValueBytes {
+		return fmt.Errorf("value is %d bytes, max %d", len(raw), maxKVValueBytes)
+	}
+	if err := ensureKVTable(conn); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	now := time.Now()
+	var expiresAt interface{}
+	if ttl > 0 {
+		expiresAt = now.Add(ttl).UnixMilli()
+	}
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`DELETE FROM %q WHERE expires_at <= ?`, kvTable), now.UnixMilli()); err != nil {
+		return quotaError(err)
+	}
+	_, err = conn.ExecContext(ctx, fmt.Sprintf(
+		`INSERT INTO %q (key, value, expires_at) VALUES (?, ?, ?)
+		 ON CONFLICT(key) DO UPDATE SET value = excluded.value, expires_at = excluded.expires_at`, kvTable),
+		key, string(raw), expiresAt)
+	return quotaError(err)
+}
+
+// kvDelete removes key, reporting whether a live entry existed.
+func kvDelete(conn sqlConn, key string) (bool, error) {
+	if err := ensureKVTable(conn); err != nil {
+		return false, err
+	}
+	result, err := conn.ExecContext(context.Background(), fmt.Sprintf(
+		`DELETE FROM %q WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`, kvTable),
+		key, time.Now().UnixMilli())
+	if err != nil {
+		return false, quotaError(err)
+	}
+	affected, _ := result.RowsAffected()
+	return affected > 0, nil
+}
+
+// kvIncr adds by to the number stored under key (0 if missing or expired),
+// keeping its expiry.
+func kvIncr(conn sqlConn, key string, by float64) (float64, error) {
+	var n float64
+	err := atomically(context.Background(), conn, func(tx sqlConn) error {
+		var current interface{}
+		found, err := kvGet(tx, key, &current)
+		if err != nil {
+			return err
+		}
+		if found {
+			number, ok := current.(float64)
+			if !ok {
+				return fmt.Errorf("value of %q is not a number", key)
+			}
+			n = number
+		}
+		n += by
+		raw, _ := json.Marshal(n)
+		_, err = tx.ExecContext(context.Background(), fmt.Sprintf(
+			`INSERT INTO %q (key, value, expires_at) VALUES (?, ?, NULL)
+			 ON CONFLICT(key) DO UPDATE SET value = excluded.value,
+			   expires_at = CASE WHEN expires_at > ? THEN expires_at END`, kvTable),
+			key, string(raw), time.Now().UnixMilli())
+		return quotaError(err)
+	})
+	return n, err
+}
+
+// kvList returns up to limit live keys starting with prefix, in order.
+func kvList(conn sqlConn, prefix string, limit int) ([]string, error) {
+	if err := ensureKVTable(conn); err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(context.Background(), fmt.Sprintf(
+		`SELECT key FROM %q WHERE substr(key, 1, ?) = ? AND (expires_at IS NULL OR expires_at > ?) ORDER BY key LIMIT ?`, kvTable),
+		utf8.RuneCountInString(prefix), prefix, time.Now().UnixMilli(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
@@ -0,0 +1,375 @@
+package vmruntime
+
+import (
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// Limit names reported in VMLimitError.Limit.
+const (
+	VMLimitExecTimeout  = "exec_timeout"  // Max in milliseconds
+	VMLimitLoadTimeout  = "load_timeout"  // Max in milliseconds
+	VMLimitSteps        = "steps"         // Max in loop iterations + function calls
+	VMLimitCallStack    = "call_stack"    // Max in frames
+	VMLimitResultRows   = "result_rows"   // Max in rows
+	VMLimitResultBytes  = "result_bytes"  // Max in bytes
+	VMLimitStringLength = "string_length" // Max in characters
+	VMLimitArrayLength  = "array_length"  // Max in elements
+	VMLimitFetchCalls   = "fetch_calls"   // Max in calls
+	VMLimitFetchBytes   = "fetch_bytes"   // Max in bytes
+)
+
+// VMLimitError reports that an execution exceeded one of its VMLimits.
+// It aborts the whole execution: user code cannot catch it.
+type VMLimitError struct {
+	Limit string
+	Max   int64
+}
+
+func (e *VMLimitError) Error() string {
+	return fmt.Sprintf("vm: %s limit exceeded (max %d)", e.Limit, e.Max)
+}
+
+// ErrVMHeapPressure is returned when an execution is interrupted because the
+// process heap grew past VMLimits.HeapGuardBytes while it ran. The heap is
+// shared by every runtime, so this reflects server load rather than a budget
+// of the execution itself, and the request may be retried.
+var ErrVMHeapPressure = errors.New("vm: interrupted under process heap pressure")
+
+// interruptOnLimit turns a VMLimitError raised inside a Go callback into an
+// interrupt, so the exception thrown next cannot be swallowed by try/catch.
+func interruptOnLimit(vm *goja.Runtime, err error) {
+	var limitErr *VMLimitError
+	if errors.As(err, &limitErr) {
+		vm.Interrupt(limitErr)
+	}
+}
+
+// run executes fn on the instance's runtime under the wall-clock and step
+// budgets and the heap-pressure guard. The step counter is reset for every
+// execution.
+func (inst *vmInstance) run(limit string, timeout time.Duration, fn func() error) error {
+	inst.steps = 0
+	err := withTimeout(inst.runtime, limit, timeout, func() error {
+		// Stopped before withTimeout clears pending interrupts.
+		stop := watchHeap(inst.runtime, inst.limits.HeapGuardBytes)
+		defer stop()
+		return fn()
+	})
+	var overflow *goja.StackOverflowError
+	if errors.As(err, &overflow) {
+		return &VMLimitError{Limit: VMLimitCallStack, Max: int64(inst.limits.MaxCallStackSize)}
+	}
+	return err
+}
+
+// step backs the __vmStep global that instrumented code calls at the top of
+// every loop body and function body.
+func (inst *vmInstance) step(goja.FunctionCall) goja.Value {
+	inst.steps++
+	if inst.steps == inst.limits.MaxSteps+1 {
+		inst.runtime.Interrupt(&VMLimitError{Limit: VMLimitSteps, Max: inst.limits.MaxSteps})
+	}
+	return goja.Undefined()
+}
+
+// heapSampleInterval is how often watchHeap samples the heap.
+const heapSampleInterval = 10 * time.Millisecond
+
+// liveHeapMetric is the heap occupied by objects that survived the last GC.
+const liveHeapMetric = "/gc/heap/live:bytes"
+
+// watchHeap interrupts vm with ErrVMHeapPressure when the live heap grows more
+// than maxBytes over its size at the start of the execution. The heap is
+// shared by every runtime in the process and only updates after a GC, so the
+// growth is not attributable to vm: this is a process-wide runaway guard, not
+// a per-execution memory budget. stop returns once the watcher can no longer
+// interrupt vm.
+func watchHeap(vm *goja.Runtime, maxBytes int64) (stop func()) {
+	if maxBytes <= 0 {
+		return func() {}
+	}
+	sample := []metrics.Sample{{Name: liveHeapMetric}}
+	readHeap := func() int64 {
+		metrics.Read(sample)
+		if sample[0].Value.Kind() != metrics.KindUint64 {
+			return 0
+		}
+		return int64(sample[0].Value.Uint64())
+	}
+	baseline := readHeap()
+
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(heapSampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if readHeap()-baseline > maxBytes {
+					vm.Interrupt(ErrVMHeapPressure)
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
+// limitExceeded aborts the execution with a VMLimitError from inside a Go
+// callback.
+func limitExceeded(vm *goja.Runtime, limit string, max int64) {
+	err := &VMLimitError{Limit: limit, Max: max}
+	vm.Interrupt(err)
+	panic(vm.NewGoError(err))
+}
+
+// wrapMethod replaces obj[name] with a function that runs check before the
+// original method and checkResult, if set, on its result.
+func wrapMethod(obj *goja.Object, name string, check func(call goja.FunctionCall), checkResult func(goja.Value)) {
+	orig, ok := goja.AssertFunction(obj.Get(name))
+	if !ok {
+		return
+	}
+	obj.Set(name, func(call goja.FunctionCall) goja.Value {
+		if check != nil {
+			check(call)
+		}
+		res, err := orig(call.This, call.Arguments...)
+		if err != nil {
+			panic(err)
+		}
+		if checkResult != nil {
+			checkResult(res)
+		}
+		return res
+	})
+}
+
+// stringLength returns the length of v if it is a string, or -1.
+func stringLength(v goja.Value) int64 {
+	if s, ok := v.(goja.String); ok {
+		return int64(s.Length())
+	}
+	return -1
+}
+
+// limitStringBuiltins wraps the String methods that can build a huge string
+// from a single call so their result length is checked, up front where it
+// can be computed and on the result otherwise.
+func limitStringBuiltins(vm *goja.Runtime, maxLen int) {
+	proto := vm.Get("String").ToObject(vm).Get("prototype").ToObject(vm)
+	tooLong := func(n int64) {
+		if n > int64(maxLen) {
+			limitExceeded(vm, VMLimitStringLength, int64(maxLen))
+		}
+	}
+	checkResult := func(res goja.Value) {
+		tooLong(stringLength(res))
+	}
+
+	wrapMethod(proto, "repeat", func(call goja.FunctionCall) {
+		count := call.Argument(0).ToInteger()
+		if count > 0 {
+			n := int64(len(call.This.String()))
+			if n > 0 && count > int64(maxLen)/n {
+				tooLong(int64(maxLen) + 1)
+			}
+			tooLong(n * count)
+		}
+	}, nil)
+	padCheck := func(call goja.FunctionCall) {
+		tooLong(call.Argument(0).ToInteger())
+	}
+	wrapMethod(proto, "padStart", padCheck, nil)
+	wrapMethod(proto, "padEnd", padCheck, nil)
+	wrapMethod(proto, "concat", func(call goja.FunctionCall) {
+		n := int64(len(call.This.String()))
+		for _, arg := range call.Arguments {
+			n += int64(len(arg.String()))
+		}
+		tooLong(n)
+	}, nil)
+	wrapMethod(proto, "replace", nil, checkResult)
+	wrapMethod(proto, "replaceAll", func(call goja.FunctionCall) {
+		// A string pattern with a string replacement has a known result
+		// length; regexps and replacer functions are checked on the result.
+		pattern, ok := call.Argument(0).(goja.String)
+		repl, replOK := call.Argument(1).(goja.String)
+		if !ok || !replOK || pattern.Length() == 0 {
+			return
+		}
+		this, p := call.This.String(), pattern.String()
+		tooLong(int64(len(this)) + int64(strings.Count(this, p))*int64(len(repl.String())-len(p)))
+	}, checkResult)
+}
+
+// limitArrayBuiltins caps the array length that the Array constructor,
+// Array.from and growing methods may create, and refuses to run the native
+// methods that walk an array longer than maxLen, since they cannot be
+// interrupted. join is also held to the string length limit.
+func limitArrayBuiltins(vm *goja.Runtime, maxLen, maxStringLen int) {
+	tooLong := func(n int64) {
+		if n > int64(maxLen) {
+			limitExceeded(vm, VMLimitArrayLength, int64(maxLen))
+		}
+	}
+	lengthOf := func(v goja.Value) int64 {
+		if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+			return 0
+		}
+		return v.ToObject(vm).Get("length").ToInteger()
+	}
+	thisLength := func(call goja.FunctionCall) int64 {
+		return lengthOf(call.This)
+	}
+
+	orig := vm.Get("Array").ToObject(vm)
+	construct, _ := goja.AssertConstructor(orig)
+	isArray, _ := goja.AssertFunction(orig.Get("isArray"))
+	proto := orig.Get("prototype").ToObject(vm)
+
+	ctor := vm.ToValue(func(call goja.ConstructorCall) *goja.Object {
+		// new Array(n) with a single number creates n empty slots.
+		if len(call.Arguments) == 1 {
+			switch n := call.Arguments[0].Export().(type) {
+			case int64:
+				tooLong(n)
+			case float64:
+				if n > float64(maxLen) {
+					tooLong(int64(maxLen) + 1)
+				}
+			}
+		}
+		obj, err := construct(call.NewTarget, call.Arguments...)
+		if err != nil {
+			panic(err)
+		}
+		return obj
+	}).ToObject(vm)
+	for _, name := range []string{"isArray", "of", "from"} {
+		ctor.Set(name, orig.Get(name))
+	}
+	ctor.Set("prototype", proto)
+	proto.Set("constructor", ctor)
+	vm.Set("Array", ctor)
+
+	wrapMethod(ctor, "from", func(call goja.FunctionCall) {
+		src := call.Argument(0)
+		if src == nil || goja.IsUndefined(src) || goja.IsNull(src) {
+			return
+		}
+		obj := src.ToObject(vm)
+		tooLong(obj.Get("length").ToInteger())
+		if size := obj.Get("size"); size != nil {
+			tooLong(size.ToInteger())
+		}
+	}, func(res goja.Value) {
+		tooLong(lengthOf(res))
+	})
+
+	grow := func(extra func(call goja.FunctionCall) int64) func(goja.FunctionCall) {
+		return func(call goja.FunctionCall) {
+			tooLong(thisLength(call) + extra(call))
+		}
+	}
+	argCount := func(call goja.FunctionCall) int64 { return int64(len(call.Arguments)) }
+	wrapMethod(proto, "push", grow(argCount), nil)
+	wrapMethod(proto, "unshift", grow(argCount), nil)
+	wrapMethod(proto, "splice", grow(func(call goja.FunctionCall) int64 {
+		if len(call.Arguments) <= 2 {
+			return 0
+		}
+		return int64(len(call.Arguments) - 2)
+	}), nil)
+	wrapMethod(proto, "concat", grow(func(call goja.FunctionCall) int64 {
+		var n int64
+		for _, arg := range call.Arguments {
+			if spread, _ := isArray(goja.Undefined(), arg); spread.ToBoolean() {
+				n += lengthOf(arg)
+			} else {
+				n++
+			}
+		}
+		return n
+	}), nil)
+	wrapMethod(proto, "join", func(call goja.FunctionCall) {
+		n := thisLength(call)
+		tooLong(n)
+		sep := int64(1) // ","
+		if s := call.Argument(0); !goja.IsUndefined(s) {
+			sep = int64(len(s.String()))
+		}
+		if n > 1 && sep*(n-1) > int64(maxStringLen) {
+			limitExceeded(vm, VMLimitStringLength, int64(maxStringLen))
+		}
+	}, func(res goja.Value) {
+		if stringLength(res) > int64(maxStringLen) {
+			limitExceeded(vm, VMLimitStringLength, int64(maxStringLen))
+		}
+	})
+
+	// The remaining methods walk every index up to length, which a sparse
+	// array can set far beyond its real contents.
+	walk := func(call goja.FunctionCall) { tooLong(thisLength(call)) }
+	for _, name := range []string{
+		"fill", "copyWithin", "every", "some", "forEach", "map", "filter",
+		"reduce", "reduceRight", "find", "findIndex", "findLast", "findLastIndex",
+		"indexOf", "lastIndexOf", "includes", "slice", "reverse", "sort",
+		"flat", "flatMap", "toString", "toLocaleString",
+		"toReversed", "toSorted", "toSpliced", "with",
+	} {
+		wrapMethod(proto, name, walk, nil)
+	}
+}
+
+// lengthGuard backs the __vmLen global that instrumented code wraps around the
+// value assigned to a length property. Like the comma operator it returns its
+// last argument, which is the one checked.
+func (inst *vmInstance) lengthGuard(call goja.FunctionCall) goja.Value {
+	v := call.Argument(len(call.Arguments) - 1)
+	if n := v.ToFloat(); n > float64(inst.limits.MaxArrayLength) {
+		limitExceeded(inst.runtime, VMLimitArrayLength, int64(inst.limits.MaxArrayLength))
+	}
+	return v
+}
+
+// stringGuard backs the __vmStr global that instrumented code wraps around
+// string concatenations and template literals. It returns its last argument.
+func (inst *vmInstance) stringGuard(call goja.FunctionCall) goja.Value {
+	v := call.Argument(len(call.Arguments) - 1)
+	if stringLength(v) > int64(inst.limits.MaxStringLength) {
+		limitExceeded(inst.runtime, VMLimitStringLength, int64(inst.limits.MaxStringLength))
+	}
+	return v
+}
+
+// resultLimits caps the rows and bytes one db.query call may return.
+type resultLimits struct {
+	maxRows  int
+	maxBytes int64
+}
+
+// valueSize estimates the memory a scanned column value holds.
+func valueSize(v interface{}) int64 {
+	switch x := v.(type) {
+	case string:
+		return int64(len(x))
+	case []byte:
+		return int64(len(x))
+	default:
+		return 8
+	}
+}
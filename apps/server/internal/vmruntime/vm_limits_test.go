@@ -0,0 +1,260 @@
+package vmruntime
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// wantLimit fails the test unless err is a VMLimitError for limit.
+func wantLimit(t *testing.T, err error, limit string) {
+	t.Helper()
+	var limitErr *VMLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != limit {
+		t.Fatalf("err = %v, want VMLimitError(%s)", err, limit)
+	}
+}
+
+func TestLimits_StepBudget(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		exports.routes = {
+			"GET /spin": function(ctx) {
+				try {
+					for (var i = 0; ; i++) {}
+				} catch (e) {
+					return { caught: true };
+				}
+			},
+			"GET /recurse": function(ctx) {
+				var f = n => n <= 0 ? 0 : 1 + f(n - 1);
+				var total = 0;
+				for (var i = 0; i < 100; i++) total += f(50);
+				return { total: total };
+			}
+		};
+	`
+	vm, err := newWorkspaceVM("ws-limit-steps", code, db, VMLimits{MaxSteps: 10000})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/spin"})
+	wantLimit(t, err, VMLimitSteps)
+
+	// ~5200 steps: within budget, and the counter was reset after /spin.
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/recurse"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if total := toInt64(resp.Body.(map[string]interface{})["total"]); total != 5000 {
+		t.Fatalf("total = %d, want 5000", total)
+	}
+}
+
+func TestLimits_CallStack(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		function deep(n) { return deep(n + 1); }
+		exports.routes = { "GET /deep": function(ctx) { return deep(0); } };
+	`
+	vm, err := newWorkspaceVM("ws-limit-stack", code, db, VMLimits{MaxCallStackSize: 100})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/deep"})
+	wantLimit(t, err, VMLimitCallStack)
+}
+
+func TestLimits_ResultSet(t *testing.T) {
+	db := newTestDB(t)
+	db.Exec(`CREATE TABLE items (id INTEGER PRIMARY KEY, body TEXT)`)
+	for i := 0; i < 20; i++ {
+		db.Exec(`INSERT INTO items (body) VALUES (?)`, strings.Repeat("x", 100))
+	}
+	code := `
+		exports.routes = {
+			"GET /some": function(ctx) { return db.query("SELECT * FROM items LIMIT 5"); },
+			"GET /all": function(ctx) {
+				try { return db.query("SELECT * FROM items"); } catch (e) { return { caught: true }; }
+			},
+			"GET /wide": function(ctx) { return db.query("SELECT body || body || body AS b FROM items LIMIT 8"); }
+		};
+	`
+	vm, err := newWorkspaceVM("ws-limit-rows", code, db, VMLimits{MaxResultRows: 10, MaxResultBytes: 2000})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/some"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if rows := toSliceOfMaps(t, resp.Body); len(rows) != 5 {
+		t.Fatalf("rows = %d, want 5", len(rows))
+	}
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/all"})
+	wantLimit(t, err, VMLimitResultRows)
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/wide"})
+	wantLimit(t, err, VMLimitResultBytes)
+}
+
+func TestLimits_StringLength(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		exports.routes = {
+			"GET /ok": function(ctx) { return { s: "ab".repeat(3) + "x".padStart(3, "-") }; },
+			"GET /repeat": function(ctx) {
+				try { return { s: "ab".repeat(1e9) }; } catch (e) { return { caught: true }; }
+			},
+			"GET /pad": function(ctx) { return { s: "x".padEnd(5000) }; }
+		};
+	`
+	vm, err := newWorkspaceVM("ws-limit-string", code, db, VMLimits{MaxStringLength: 1000})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/ok"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if s := resp.Body.(map[string]interface{})["s"]; s != "ababab--x" {
+		t.Fatalf("s = %v, want ababab--x", s)
+	}
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/repeat"})
+	wantLimit(t, err, VMLimitStringLength)
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/pad"})
+	wantLimit(t, err, VMLimitStringLength)
+}
+
+func TestLimits_ArrayAndStringGrowth(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		function attempt(f) { try { return f(); } catch (e) { return { caught: String(e) }; } }
+		exports.routes = {
+			"GET /ok": function(ctx) {
+				var a = [1, 2]; a.push(3); a.length = 2;
+				var b = Array(3).fill(0).concat([1], 2);
+				return { a: a.join("-"), b: b.length, t: ` + "`${a.length}x`" + `, s: "a" + 1 + 2, r: "aXa".replaceAll("a", "bb") };
+			},
+			"GET /fill":     function(ctx) { return attempt(function() { return new Array(20000000).fill(1).length; }); },
+			"GET /join":     function(ctx) { return attempt(function() { return new Array(4000000).join("abcdefgh").length; }); },
+			"GET /from":     function(ctx) { return attempt(function() { return Array.from({ length: 1e9 }).length; }); },
+			"GET /length":   function(ctx) { var a = []; a.length = 1e9; return { n: a.length }; },
+			"GET /sparse":   function(ctx) { var a = []; a[1e8] = 1; return attempt(function() { a.forEach(function() {}); }); },
+			"GET /double":   function(ctx) { var s = "abcdefgh"; while (true) { s = s + s; } },
+			"GET /template": function(ctx) { var s = "x".repeat(600000); return { n: ` + "`${s}${s}`" + `.length }; },
+			"GET /replace":  function(ctx) { return { n: "a".repeat(500000).replaceAll("a", "bbb").length }; }
+		};
+	`
+	limits := VMLimits{MaxSteps: 1000, HeapGuardBytes: 16 << 20, MaxStringLength: 1 << 20, MaxArrayLength: 8 << 20}
+	vm, err := newWorkspaceVM("ws-limit-growth", code, db, limits)
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/ok"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	body := resp.Body.(map[string]interface{})
+	if body["a"] != "1-2" || toInt64(body["b"]) != 5 || body["t"] != "2x" || body["s"] != "a12" || body["r"] != "bbXbb" {
+		t.Fatalf("body = %+v", body)
+	}
+
+	tests := []struct {
+		path  string
+		limit string
+	}{
+		{"/fill", VMLimitArrayLength},
+		{"/join", VMLimitStringLength},
+		{"/from", VMLimitArrayLength},
+		{"/length", VMLimitArrayLength},
+		{"/sparse", VMLimitArrayLength},
+		{"/double", VMLimitStringLength},
+		{"/template", VMLimitStringLength},
+		{"/replace", VMLimitStringLength},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		_, err := vm.Handle(VMRequest{Method: "GET", Path: tt.path})
+		wantLimit(t, err, tt.limit)
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s took %s, want it refused before the work is done", tt.path, elapsed)
+		}
+	}
+}
+
+func TestLimits_HeapGuard(t *testing.T) {
+	db := newTestDB(t)
+	code := `
+		exports.routes = {
+			"GET /hog": function(ctx) {
+				var keep = [];
+				while (true) { keep.push(new Array(100000).fill(1)); }
+			}
+		};
+	`
+	vm, err := newWorkspaceVM("ws-limit-heap", code, db, VMLimits{HeapGuardBytes: 32 << 20, MaxSteps: 1 << 62})
+	if err != nil {
+		t.Fatalf("newWorkspaceVM failed: %v", err)
+	}
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/hog"})
+	if !errors.Is(err, ErrVMHeapPressure) {
+		t.Fatalf("err = %v, want ErrVMHeapPressure", err)
+	}
+}
+
+func TestInstrumentCode(t *testing.T) {
+	tests := []string{
+		`for (var i = 0; i < 3; i++) n++;`,
+		`for (var i = 0; i < 3; i++) n++`,
+		`var i = 0; while (i < 3) i++, n++;`,
+		`var i = 0; do n++; while (++i < 3);`,
+		`for (var k in {a: 1, b: 2, c: 3}) for (var j of [1]) n++;`,
+		`var i = 0; while (i++ < 3) if (true) n++; else n--;`,
+		`[1, 2, 3].forEach(x => n++);`,
+		`var f = a => b => n += a + b - 2; f(1)(2); f(0)(3); f(2)(1);`,
+		`class C { m() { return 1; } get g() { return 2; } } n = new C().m() + new C().g;`,
+		"var s = `${(() => 1)()}`; for (var i = 0; i < 3; i++) n++;",
+		`n = (function() { return (0, 1) + 2; })();`,
+		`n = (function() { var a = [1, 2, 3, 4]; a.length = 3; return a.length; })();`,
+		"n = (function() { return `${1}${2}`.length + 1; })();",
+		`n = (function() { var t = "ab"; t += "c"; return t.length; })();`,
+		`n = (function() { var o = { length: 0 }; o["length"] = (1, 3); return o.length; })();`,
+		`n = (function(a) { return (a) + 1 + (a, 1); })(1);`,
+	}
+	for _, src := range tests {
+		out, err := instrumentCode(src)
+		if err != nil {
+			t.Fatalf("instrumentCode(%q) failed: %v", src, err)
+		}
+		if !strings.Contains(out, vmStepFunc) {
+			t.Errorf("instrumentCode(%q) = %q, want step calls", src, out)
+			continue
+		}
+
+		vm, err := newWorkspaceVM("ws-instrument", "var n = 0;\n"+src+"\nexports.routes = { 'GET /n': function() { return { n: n }; } };", newTestDB(t), DefaultVMLimits())
+		if err != nil {
+			t.Fatalf("load %q: %v", out, err)
+		}
+		resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/n"})
+		if err != nil {
+			t.Fatalf("Handle %q: %v", out, err)
+		}
+		if n := toInt64(resp.Body.(map[string]interface{})["n"]); n != 3 {
+			t.Errorf("%q: n = %d, want 3 (instrumented: %q)", src, n, out)
+		}
+	}
+
+	for _, src := range []string{`var __vmStep = 1;`, `var __vmLen = 1;`, `__vmStr("x");`} {
+		if _, err := instrumentCode(src); err == nil {
+			t.Fatalf("instrumentCode(%q): expected reserved identifier error", src)
+		}
+	}
+}
@@ -2,11 +2,16 @@ package vmruntime
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrNoLogicCode is returned by GetOrCreate when the workspace has no code.
+var ErrNoLogicCode = errors.New("no logic code deployed")
+
 // VMCodeLoader loads the JS logic code for a workspace.
 type VMCodeLoader interface {
 	GetLogicCode(ctx context.Context, workspaceID string) (code string, hash string, err error)
@@ -14,43 +19,68 @@ type VMCodeLoader interface {
 
 // VMPool manages cached WorkspaceVM instances with LRU eviction.
 type VMPool struct {
-	mu         sync.RWMutex
-	vms        map[string]*WorkspaceVM
-	vmStore    *VMStore
-	codeLoader VMCodeLoader
-	maxVMs     int
-	accessLog  map[string]time.Time
+	mu          sync.RWMutex
+	vms         map[string]*WorkspaceVM
+	vmStore     *VMStore
+	codeLoader  VMCodeLoader
+	maxVMs      int
+	idleTimeout time.Duration
+	codeTTL     time.Duration
+	limits      VMLimits
+	checkedAt   map[string]time.Time // when the loader last confirmed a VM's code
+	// invalidations counts Invalidate calls; code loaded while it changed
+	// may predate the invalidation and is not trusted.
+	invalidations atomic.Uint64
+	stop          chan struct{}
+	stopOnce      sync.Once
 }
 
-// NewVMPool creates a new VMPool.
-func NewVMPool(vmStore *VMStore, codeLoader VMCodeLoader, maxVMs int) *VMPool {
-	if maxVMs <= 0 {
-		maxVMs = 100
+// NewVMPool creates a new VMPool. When opts.IdleTimeout is set a background
+// evictor closes idle VMs and database handles until Close is called.
+func NewVMPool(vmStore *VMStore, codeLoader VMCodeLoader, opts VMPoolOptions) *VMPool {
+	if opts.MaxVMs <= 0 {
+		opts.MaxVMs = 100
+	}
+	p := &VMPool{
+		vms:         make(map[string]*WorkspaceVM),
+		vmStore:     vmStore,
+		codeLoader:  codeLoader,
+		maxVMs:      opts.MaxVMs,
+		idleTimeout: opts.IdleTimeout,
+		codeTTL:     opts.CodeTTL,
+		limits:      opts.Limits.normalize(),
+		checkedAt:   make(map[string]time.Time),
+		stop:        make(chan struct{}),
 	}
-	return &VMPool{
-		vms:        make(map[string]*WorkspaceVM),
-		vmStore:    vmStore,
-		codeLoader: codeLoader,
-		maxVMs:     maxVMs,
-		accessLog:  make(map[string]time.Time),
+	if p.idleTimeout > 0 {
+		go p.runEvictor()
 	}
+	return p
 }
 
-// GetOrCreate returns a cached VM or creates a new one. If the code has been
-// updated (hash mismatch), the VM is rebuilt automatically.
+// GetOrCreate returns a cached VM or creates a new one. Within the code TTL a
+// cached VM is returned without consulting the code loader; after it the code
+// is reloaded and, if it has been updated (hash mismatch), the VM is rebuilt.
 func (p *VMPool) GetOrCreate(ctx context.Context, workspaceID string) (*WorkspaceVM, error) {
+	if vm := p.trusted(workspaceID); vm != nil {
+		vm.touch()
+		return vm, nil
+	}
+
+	generation := p.invalidations.Load()
 	code, hash, err := p.codeLoader.GetLogicCode(ctx, workspaceID)
 	if err != nil {
 		return nil, fmt.Errorf("vmpool: load code: %w", err)
 	}
 	if code == "" {
-		return nil, fmt.Errorf("vmpool: no logic code deployed for workspace %s", workspaceID)
+		return nil, fmt.Errorf("vmpool: %w for workspace %s", ErrNoLogicCode, workspaceID)
 	}
 
 	p.mu.RLock()
 	if vm, ok := p.vms[workspaceID]; ok && vm.codeHash == hash {
 		p.mu.RUnlock()
-		p.touchAccess(workspaceID)
+		vm.touch()
+		p.markChecked(workspaceID, vm, generation)
 		return vm, nil
 	}
 	p.mu.RUnlock()
@@ -61,7 +91,8 @@ func (p *VMPool) GetOrCreate(ctx context.Context, workspaceID string) (*Workspac
 
 	// Double-check after acquiring write lock
 	if vm, ok := p.vms[workspaceID]; ok && vm.codeHash == hash {
-		p.accessLog[workspaceID] = time.Now()
+		vm.touch()
+		p.confirmLocked(workspaceID, generation)
 		return vm, nil
 	}
 
@@ -71,10 +102,12 @@ func (p *VMPool) GetOrCreate(ctx context.Context, workspaceID string) (*Workspac
 		return nil, fmt.Errorf("vmpool: get db: %w", err)
 	}
 
-	vm, err := NewWorkspaceVM(workspaceID, code, db)
+	vm, err := newWorkspaceVM(workspaceID, code, db, p.limits)
 	if err != nil {
 		return nil, fmt.Errorf("vmpool: create vm: %w", err)
 	}
+	vm.store = p.vmStore
+	vm.touch()
 
 	// Evict if at capacity
 	if len(p.vms) >= p.maxVMs {
@@ -82,50 +115,127 @@ func (p *VMPool) GetOrCreate(ctx context.Context, workspaceID string) (*Workspac
 	}
 
 	p.vms[workspaceID] = vm
-	p.accessLog[workspaceID] = time.Now()
+	p.confirmLocked(workspaceID, generation)
 	return vm, nil
 }
 
-// Invalidate removes a workspace's VM from the cache, forcing a rebuild on next access.
+// trusted returns the cached VM if its code was confirmed within the code TTL.
+func (p *VMPool) trusted(workspaceID string) *WorkspaceVM {
+	if p.codeTTL <= 0 {
+		return nil
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	vm, ok := p.vms[workspaceID]
+	if !ok || time.Since(p.checkedAt[workspaceID]) >= p.codeTTL {
+		return nil
+	}
+	return vm
+}
+
+// markChecked records that the loader confirmed vm's code, unless vm has been
+// replaced or invalidated meanwhile.
+func (p *VMPool) markChecked(workspaceID string, vm *WorkspaceVM, generation uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.vms[workspaceID] == vm {
+		p.confirmLocked(workspaceID, generation)
+	}
+}
+
+// confirmLocked records the confirmation of the code, unless an invalidation
+// happened since it was loaded at generation. Must be called with p.mu held.
+func (p *VMPool) confirmLocked(workspaceID string, generation uint64) {
+	if p.invalidations.Load() == generation {
+		p.checkedAt[workspaceID] = time.Now()
+	}
+}
+
+// Limits returns the execution limits applied to the pool's VMs.
+func (p *VMPool) Limits() VMLimits {
+	return p.limits
+}
+
+// Invalidate removes a workspace's VM from the cache, forcing a reload of its
+// code and a rebuild on next access.
 func (p *VMPool) Invalidate(workspaceID string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.invalidations.Add(1)
+	p.remove(workspaceID)
+}
+
+// remove drops a workspace's VM. Must be called with p.mu held.
+func (p *VMPool) remove(workspaceID string) {
 	delete(p.vms, workspaceID)
-	delete(p.accessLog, workspaceID)
+	delete(p.checkedAt, workspaceID)
 }
 
-// Close removes all VMs from the pool.
+// Close stops the evictor and removes all VMs from the pool.
 func (p *VMPool) Close() {
+	p.stopOnce.Do(func() { close(p.stop) })
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	for id := range p.vms {
-		delete(p.vms, id)
-		delete(p.accessLog, id)
+		p.remove(id)
 	}
 }
 
-// touchAccess updates the last access time for a workspace (for LRU tracking).
-func (p *VMPool) touchAccess(workspaceID string) {
-	p.mu.Lock()
-	p.accessLog[workspaceID] = time.Now()
-	p.mu.Unlock()
-}
-
 // evictLRU removes the least recently used VM from the pool.
 // Must be called with p.mu held.
 func (p *VMPool) evictLRU() {
 	var oldestID string
-	var oldestTime time.Time
+	var oldestTime int64
 
-	for id, t := range p.accessLog {
-		if oldestID == "" || t.Before(oldestTime) {
+	for id, vm := range p.vms {
+		if t := vm.lastAccess.Load(); oldestID == "" || t < oldestTime {
 			oldestID = id
 			oldestTime = t
 		}
 	}
 
 	if oldestID != "" {
-		delete(p.vms, oldestID)
-		delete(p.accessLog, oldestID)
+		p.remove(oldestID)
+	}
+}
+
+// runEvictor periodically evicts idle VMs and database handles.
+func (p *VMPool) runEvictor() {
+	ticker := time.NewTicker(p.idleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			p.EvictIdle()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// EvictIdle drops VMs not accessed within the idle timeout, then closes the
+// database handles of workspaces that no longer have a cached VM and have not
+// been used within the idle timeout either.
+func (p *VMPool) EvictIdle() {
+	if p.idleTimeout <= 0 {
+		return
+	}
+	cutoff := time.Now().Add(-p.idleTimeout).UnixNano()
+
+	p.mu.Lock()
+	for id, vm := range p.vms {
+		if vm.lastAccess.Load() < cutoff {
+			p.remove(id)
+		}
+	}
+	live := make(map[string]bool, len(p.vms))
+	for id := range p.vms {
+		live[id] = true
 	}
+	p.mu.Unlock()
+
+	// A cached VM keeps using its *sql.DB without calling GetDB, so its
+	// handle must stay open for as long as the VM does. Handles of dropped
+	// VMs that are still executing are retained and kept open by the store.
+	p.vmStore.EvictIdle(p.idleTimeout, live)
 }
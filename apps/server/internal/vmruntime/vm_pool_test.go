@@ -3,16 +3,22 @@ package vmruntime
 import (
 	"context"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // mockCodeLoader is a test VMCodeLoader that returns configurable code per workspace.
 type mockCodeLoader struct {
 	codes map[string]string // workspaceID → JS code
+	calls atomic.Int64
 }
 
 func (m *mockCodeLoader) GetLogicCode(_ context.Context, workspaceID string) (string, string, error) {
+	m.calls.Add(1)
 	code, ok := m.codes[workspaceID]
 	if !ok || code == "" {
 		return "", "", nil
@@ -24,10 +30,10 @@ func (m *mockCodeLoader) GetLogicCode(_ context.Context, workspaceID string) (st
 func newTestPool(t *testing.T, maxVMs int, codes map[string]string) (*VMPool, *mockCodeLoader) {
 	t.Helper()
 	dir := t.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	t.Cleanup(func() { store.Close() })
 	loader := &mockCodeLoader{codes: codes}
-	pool := NewVMPool(store, loader, maxVMs)
+	pool := NewVMPool(store, loader, VMPoolOptions{MaxVMs: maxVMs})
 	t.Cleanup(func() { pool.Close() })
 	return pool, loader
 }
@@ -131,6 +137,47 @@ func TestVMPool_Invalidate(t *testing.T) {
 	}
 }
 
+// ── Code TTL ─────────────────────────────────────────────────────────
+
+func TestVMPool_CodeTTL(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{BaseDir: t.TempDir()})
+	defer store.Close()
+	loader := &mockCodeLoader{codes: map[string]string{
+		"ws-ttl": `exports.routes = { "GET /v1": function() { return 1; } };`,
+	}}
+	pool := NewVMPool(store, loader, VMPoolOptions{MaxVMs: 10, CodeTTL: time.Hour})
+	defer pool.Close()
+	ctx := context.Background()
+
+	vm1, _ := pool.GetOrCreate(ctx, "ws-ttl")
+	for i := 0; i < 10; i++ {
+		pool.GetOrCreate(ctx, "ws-ttl")
+	}
+	if n := loader.calls.Load(); n != 1 {
+		t.Fatalf("loader calls = %d, want 1 within the code TTL", n)
+	}
+
+	// An undeployed change is not seen until the VM is invalidated.
+	loader.codes["ws-ttl"] = `exports.routes = { "GET /v2": function() { return 2; } };`
+	if vm, _ := pool.GetOrCreate(ctx, "ws-ttl"); vm != vm1 {
+		t.Fatal("expected cached VM within the code TTL")
+	}
+	pool.Invalidate("ws-ttl")
+	vm2, _ := pool.GetOrCreate(ctx, "ws-ttl")
+	if vm2 == vm1 || vm2.CodeHash() == vm1.CodeHash() {
+		t.Fatal("expected rebuilt VM after Invalidate")
+	}
+
+	// Once the TTL expires the code is checked again.
+	pool.mu.Lock()
+	pool.checkedAt["ws-ttl"] = time.Now().Add(-2 * time.Hour)
+	pool.mu.Unlock()
+	calls := loader.calls.Load()
+	if vm, _ := pool.GetOrCreate(ctx, "ws-ttl"); vm != vm2 || loader.calls.Load() != calls+1 {
+		t.Fatal("expected one code check and the same VM after the TTL expired")
+	}
+}
+
 // ── LRU eviction ─────────────────────────────────────────────────────
 
 func TestVMPool_LRUEviction(t *testing.T) {
@@ -200,16 +247,144 @@ func TestVMPool_Close(t *testing.T) {
 
 func TestVMPool_DefaultMaxVMs(t *testing.T) {
 	dir := t.TempDir()
-	store := NewVMStore(dir)
+	store := NewVMStore(VMStoreOptions{BaseDir: dir})
 	defer store.Close()
 
-	pool := NewVMPool(store, &mockCodeLoader{}, 0) // 0 → default 100
+	pool := NewVMPool(store, &mockCodeLoader{}, VMPoolOptions{MaxVMs: 0}) // 0 → default 100
 	if pool.maxVMs != 100 {
 		t.Fatalf("default maxVMs = %d, want 100", pool.maxVMs)
 	}
 
-	pool2 := NewVMPool(store, &mockCodeLoader{}, -5) // negative → default 100
+	pool2 := NewVMPool(store, &mockCodeLoader{}, VMPoolOptions{MaxVMs: -5}) // negative → default 100
 	if pool2.maxVMs != 100 {
 		t.Fatalf("negative maxVMs = %d, want 100", pool2.maxVMs)
 	}
 }
+
+// ── Idle eviction ────────────────────────────────────────────────────
+
+func TestVMPool_EvictIdle(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{BaseDir: t.TempDir()})
+	defer store.Close()
+	loader := &mockCodeLoader{codes: map[string]string{
+		"ws-idle":   `exports.routes = {};`,
+		"ws-active": `exports.routes = {};`,
+	}}
+	pool := NewVMPool(store, loader, VMPoolOptions{MaxVMs: 10, IdleTimeout: time.Hour})
+	defer pool.Close()
+	ctx := context.Background()
+
+	pool.GetOrCreate(ctx, "ws-idle")
+	pool.GetOrCreate(ctx, "ws-active")
+	store.GetDB("ws-orphan")
+
+	// Age everything except ws-active past the idle timeout.
+	old := time.Now().Add(-2 * time.Hour)
+	pool.mu.RLock()
+	pool.vms["ws-idle"].lastAccess.Store(old.UnixNano())
+	pool.mu.RUnlock()
+	store.mu.Lock()
+	for id, used := range store.lastUsed {
+		if id != "ws-active" {
+			used.Store(old.UnixNano())
+		}
+	}
+	store.mu.Unlock()
+
+	pool.EvictIdle()
+
+	pool.mu.RLock()
+	_, idleCached := pool.vms["ws-idle"]
+	_, activeCached := pool.vms["ws-active"]
+	pool.mu.RUnlock()
+	if idleCached || !activeCached {
+		t.Fatalf("cached after evict: ws-idle=%v ws-active=%v, want false/true", idleCached, activeCached)
+	}
+
+	store.mu.RLock()
+	_, idleOpen := store.dbs["ws-idle"]
+	_, activeOpen := store.dbs["ws-active"]
+	_, orphanOpen := store.dbs["ws-orphan"]
+	store.mu.RUnlock()
+	if idleOpen || orphanOpen || !activeOpen {
+		t.Fatalf("open dbs after evict: ws-idle=%v ws-orphan=%v ws-active=%v, want false/false/true", idleOpen, orphanOpen, activeOpen)
+	}
+}
+
+func TestVMPool_EvictIdleKeepsRetainedDB(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{BaseDir: t.TempDir()})
+	defer store.Close()
+	loader := &mockCodeLoader{codes: map[string]string{
+		"ws-busy": `exports.routes = { "GET /ping": function() { return { ok: true }; } };`,
+	}}
+	pool := NewVMPool(store, loader, VMPoolOptions{MaxVMs: 10, IdleTimeout: time.Hour})
+	defer pool.Close()
+
+	vm, err := pool.GetOrCreate(context.Background(), "ws-busy")
+	if err != nil {
+		t.Fatalf("GetOrCreate: %v", err)
+	}
+	// Simulate an execution in flight on a VM that has since been dropped.
+	release, err := vm.retainDB()
+	if err != nil {
+		t.Fatalf("retainDB: %v", err)
+	}
+	pool.Invalidate("ws-busy")
+	ageDBs := func() {
+		store.mu.Lock()
+		for _, used := range store.lastUsed {
+			used.Store(time.Now().Add(-2 * time.Hour).UnixNano())
+		}
+		store.mu.Unlock()
+	}
+
+	ageDBs()
+	pool.EvictIdle()
+	if err := vm.db.Ping(); err != nil {
+		t.Fatalf("db closed under a running execution: %v", err)
+	}
+
+	release()
+	ageDBs()
+	pool.EvictIdle()
+	store.mu.RLock()
+	_, open := store.dbs["ws-busy"]
+	store.mu.RUnlock()
+	if open {
+		t.Fatal("db still open after the execution released it")
+	}
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/ping"})
+	if !errors.Is(err, ErrVMClosed) {
+		t.Fatalf("Handle on a closed VM = %v, want ErrVMClosed", err)
+	}
+}
+
+// ── Limits from options ──────────────────────────────────────────────
+
+func TestVMPool_LimitsFromOptions(t *testing.T) {
+	store := NewVMStore(VMStoreOptions{BaseDir: t.TempDir()})
+	defer store.Close()
+	loader := &mockCodeLoader{codes: map[string]string{
+		"ws-limits": `exports.routes = { "GET /loop": function() { while (true) {} } };`,
+	}}
+	pool := NewVMPool(store, loader, VMPoolOptions{Limits: VMLimits{ExecTimeout: 50 * time.Millisecond, MaxCodeSize: 100}})
+	defer pool.Close()
+
+	vm, err := pool.GetOrCreate(context.Background(), "ws-limits")
+	if err != nil {
+		t.Fatalf("GetOrCreate failed: %v", err)
+	}
+	start := time.Now()
+	if _, err := vm.Handle(VMRequest{Method: "GET", Path: "/loop"}); err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("exec took %v, want ~50ms", elapsed)
+	}
+
+	loader.codes["ws-limits"] = `exports.routes = {}; // ` + strings.Repeat("x", 100)
+	if _, err := pool.GetOrCreate(context.Background(), "ws-limits"); err == nil {
+		t.Fatal("expected code size error")
+	}
+}
@@ -8,10 +8,23 @@ import (
 	"github.com/dop251/goja"
 )
 
+// Default limits, overridable through VMLimits.
 const (
 	VMMaxCodeSize = 1 << 20          // 1MB code size limit
 	VMExecTimeout = 10 * time.Second // single request max execution time
 	VMLoadTimeout = 5 * time.Second  // code loading max time
+
+	VMMaxSteps         = 10_000_000 // loop iterations + function calls per execution
+	VMHeapGuardBytes   = 256 << 20  // process heap growth during one execution
+	VMMaxCallStackSize = 1000       // JS call depth
+	VMMaxResultRows    = 10_000     // rows per db.query
+	VMMaxResultBytes   = 16 << 20   // bytes per db.query
+	VMMaxStringLength  = 16 << 20   // length of strings built by builtins, + and templates
+	VMMaxArrayLength   = 1 << 20    // length of arrays created or grown by builtins
+
+	VMFetchTimeout  = 5 * time.Second // per fetch call, within the exec timeout
+	VMMaxFetchCalls = 20              // fetch calls per execution
+	VMMaxFetchBytes = 5 << 20         // bytes per fetch request or response body
 )
 
 // setupSandbox disables dangerous global objects in the goja VM.
@@ -26,53 +39,57 @@ func setupSandbox(vm *goja.Runtime) {
 }
 
 // injectConsoleAPI injects a console object that forwards to Go's log package.
-func injectConsoleAPI(vm *goja.Runtime, workspaceID string) {
+func injectConsoleAPI(vm *goja.Runtime, workspaceID string, redact func(string) string) {
 	consoleObj := vm.NewObject()
-	consoleObj.Set("log", func(call goja.FunctionCall) goja.Value {
-		logWithLevel(workspaceID, "LOG", call)
-		return goja.Undefined()
-	})
-	consoleObj.Set("warn", func(call goja.FunctionCall) goja.Value {
-		logWithLevel(workspaceID, "WARN", call)
-		return goja.Undefined()
-	})
-	consoleObj.Set("error", func(call goja.FunctionCall) goja.Value {
-		logWithLevel(workspaceID, "ERROR", call)
-		return goja.Undefined()
-	})
-	consoleObj.Set("info", func(call goja.FunctionCall) goja.Value {
-		logWithLevel(workspaceID, "INFO", call)
-		return goja.Undefined()
-	})
+	for method, level := range map[string]string{"log": "LOG", "warn": "WARN", "error": "ERROR", "info": "INFO"} {
+		consoleObj.Set(method, func(call goja.FunctionCall) goja.Value {
+			logWithLevel(workspaceID, level, call, redact)
+			return goja.Undefined()
+		})
+	}
 	vm.Set("console", consoleObj)
 }
 
-func logWithLevel(workspaceID, level string, call goja.FunctionCall) {
+// logWithLevel writes a console line with secret values redacted.
+func logWithLevel(workspaceID, level string, call goja.FunctionCall, redact func(string) string) {
 	parts := make([]interface{}, len(call.Arguments))
 	for i, arg := range call.Arguments {
 		parts[i] = arg.Export()
 	}
-	log.Printf("[VM:%s] [%s] %v", workspaceID[:8], level, parts)
+	log.Printf("[VM:%s] [%s] %s", workspaceID[:8], level, redact(fmt.Sprint(parts)))
 }
 
 // validateCodeSize checks that the code does not exceed the maximum allowed size.
-func validateCodeSize(code string) error {
-	if len(code) > VMMaxCodeSize {
-		return fmt.Errorf("code size %d exceeds maximum %d bytes", len(code), VMMaxCodeSize)
+func validateCodeSize(code string, maxSize int) error {
+	if len(code) > maxSize {
+		return fmt.Errorf("code size %d exceeds maximum %d bytes", len(code), maxSize)
 	}
 	return nil
 }
 
 // withTimeout runs a function with a timeout by using goja's Interrupt mechanism.
-func withTimeout(vm *goja.Runtime, timeout time.Duration, fn func() error) error {
+// An interrupt carrying a *VMLimitError (the timeout itself, or a budget
+// enforced elsewhere) is returned as that error.
+func withTimeout(vm *goja.Runtime, limit string, timeout time.Duration, fn func() error) error {
 	timer := time.AfterFunc(timeout, func() {
-		vm.Interrupt("execution timeout exceeded")
+		vm.Interrupt(&VMLimitError{Limit: limit, Max: timeout.Milliseconds()})
 	})
-	defer timer.Stop()
+	defer func() {
+		timer.Stop()
+		// Runtimes are reused across requests; drop an interrupt that fired
+		// after fn returned so it cannot abort the next request.
+		vm.ClearInterrupt()
+	}()
 
 	err := fn()
 	if err != nil {
 		if interrupted, ok := err.(*goja.InterruptedError); ok {
+			if limitErr, ok := interrupted.Value().(*VMLimitError); ok {
+				return limitErr
+			}
+			if interrupted.Value() == ErrVMHeapPressure {
+				return ErrVMHeapPressure
+			}
 			return fmt.Errorf("timeout: %s", interrupted.String())
 		}
 		return err
@@ -1,8 +1,10 @@
 package vmruntime
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	_ "modernc.org/sqlite"
 )
@@ -103,13 +105,18 @@ func TestSandbox_LoadTimeout(t *testing.T) {
 	db := newTestDB(t)
 	// Infinite loop during load
 	code := `while(true) {}`
-	_, err := NewWorkspaceVM("ws-sandbox-timeout", code, db)
+	// A step budget this large leaves the wall clock to stop the loop.
+	_, err := newWorkspaceVM("ws-sandbox-timeout", code, db, VMLimits{LoadTimeout: 200 * time.Millisecond, MaxSteps: 1 << 62})
 	if err == nil {
 		t.Fatal("expected error for infinite loop during load")
 	}
 	if !strings.Contains(err.Error(), "timeout") && !strings.Contains(err.Error(), "interrupt") {
 		t.Fatalf("error = %v, want timeout/interrupt mention", err)
 	}
+	var limitErr *VMLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != VMLimitLoadTimeout {
+		t.Fatalf("error = %v, want VMLimitError(%s)", err, VMLimitLoadTimeout)
+	}
 }
 
 func TestSandbox_ExecTimeout(t *testing.T) {
@@ -122,7 +129,7 @@ func TestSandbox_ExecTimeout(t *testing.T) {
 			}
 		};
 	`
-	vm, err := NewWorkspaceVM("ws-sandbox-exec-timeout", code, db)
+	vm, err := newWorkspaceVM("ws-sandbox-exec-timeout", code, db, VMLimits{ExecTimeout: 200 * time.Millisecond, MaxSteps: 1 << 62})
 	if err != nil {
 		t.Fatalf("NewWorkspaceVM failed: %v", err)
 	}
@@ -134,6 +141,10 @@ func TestSandbox_ExecTimeout(t *testing.T) {
 	if !strings.Contains(err.Error(), "timeout") && !strings.Contains(err.Error(), "interrupt") {
 		t.Fatalf("error = %v, want timeout/interrupt mention", err)
 	}
+	var limitErr *VMLimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != VMLimitExecTimeout {
+		t.Fatalf("error = %v, want VMLimitError(%s)", err, VMLimitExecTimeout)
+	}
 }
 
 // ── Console API exists ───────────────────────────────────────────────
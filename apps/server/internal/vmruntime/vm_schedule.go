@@ -0,0 +1,149 @@
+package vmruntime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/reverseai/server/internal/pkg/security"
+	"github.com/robfig/cron/v3"
+)
+
+// MaxSchedules is the maximum number of entries in exports.schedules.
+const MaxSchedules = 20
+
+// Schedule triggers passed to the handler as ctx.trigger.
+const (
+	ScheduleTriggerCron   = "cron"
+	ScheduleTriggerManual = "manual"
+)
+
+// ParseSchedule parses a schedule spec and binds it to loc (UTC if nil).
+//
+// Specs are standard 5-field expressions ("0 2 * * *") or the
+// @yearly/@monthly/@weekly/@daily/@hourly descriptors. Schedules are fired
+// at minute granularity, so "@every" is not supported; the time zone comes
+// from the workspace settings, not from a TZ= prefix in the spec.
+func ParseSchedule(spec string, loc *time.Location) (cron.Schedule, error) {
+	trimmed := strings.TrimSpace(spec)
+	if trimmed == "" {
+		return nil, errors.New("empty schedule")
+	}
+	if trimmed != spec {
+		return nil, errors.New("schedule must not have leading or trailing spaces")
+	}
+	if strings.HasPrefix(spec, "@every") {
+		return nil, errors.New("@every is not supported, use a cron expression")
+	}
+	if strings.HasPrefix(spec, "TZ=") || strings.HasPrefix(spec, "CRON_TZ=") {
+		return nil, errors.New("time zone prefixes are not supported, set the workspace time zone instead")
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	if spec, ok := schedule.(*cron.SpecSchedule); ok {
+		spec.Location = loc
+	}
+	return schedule, nil
+}
+
+// extractSchedules reads exports.schedules, mapping cron specs to handlers:
+//
+//	exports.schedules = {
+//	  "0 2 * * *": function(ctx) { ... },
+//	};
+//
+// Unlike routes, a bad entry fails the load so the mistake surfaces when the
+// code is deployed rather than when the schedule silently never fires.
+func extractSchedules(vm *goja.Runtime) (map[string]goja.Callable, error) {
+	exportsVal := vm.Get("exports")
+	if exportsVal == nil || goja.IsUndefined(exportsVal) || goja.IsNull(exportsVal) {
+		return map[string]goja.Callable{}, nil
+	}
+
+	exportsObj := exportsVal.ToObject(vm)
+	schedulesVal := exportsObj.Get("schedules")
+	if schedulesVal == nil || goja.IsUndefined(schedulesVal) || goja.IsNull(schedulesVal) {
+		return map[string]goja.Callable{}, nil
+	}
+
+	schedulesObj := schedulesVal.ToObject(vm)
+	keys := schedulesObj.Keys()
+	if len(keys) > MaxSchedules {
+		return nil, fmt.Errorf("too many schedules: %d (max %d)", len(keys), MaxSchedules)
+	}
+	schedules := make(map[string]goja.Callable, len(keys))
+	for _, key := range keys {
+		if _, err := ParseSchedule(key, time.UTC); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", key, err)
+		}
+		fn, ok := goja.AssertFunction(schedulesObj.Get(key))
+		if !ok {
+			return nil, fmt.Errorf("schedule %q is not a function", key)
+		}
+		schedules[key] = fn
+	}
+	return schedules, nil
+}
+
+func scheduleSpecs(schedules map[string]goja.Callable) []string {
+	specs := make([]string, 0, len(schedules))
+	for spec := range schedules {
+		specs = append(specs, spec)
+	}
+	sort.Strings(specs)
+	return specs
+}
+
+// VMScheduleRun describes one invocation of a schedule handler.
+type VMScheduleRun struct {
+	Schedule    string    // key in exports.schedules
+	ScheduledAt time.Time // the fire time; the request time for manual runs
+	Trigger     string    // ScheduleTriggerCron or ScheduleTriggerManual
+	RunID       string
+	Attempt     int // 1 for the first attempt, incremented by retries
+	// Egress, Secrets and Redactor are applied as for an HTTP request.
+	Egress   *VMEgress
+	Secrets  VMSecretResolver
+	Redactor *security.SecretRedactor
+}
+
+// Schedules returns the cron specs declared in exports.schedules, sorted.
+func (w *WorkspaceVM) Schedules() []string {
+	return append([]string(nil), w.schedules...)
+}
+
+// RunSchedule calls the handler of run.Schedule with
+//
+//	ctx = { schedule, scheduledAt, trigger, runId, attempt }
+//
+// under the same budgets as a route handler. Scheduled code runs as the
+// workspace itself, so `db` is unrestricted.
+func (w *WorkspaceVM) RunSchedule(ctx context.Context, run VMScheduleRun) (*VMResponse, error) {
+	if !w.hasSchedule(run.Schedule) {
+		return nil, fmt.Errorf("vm: no schedule %q", run.Schedule)
+	}
+	req := VMRequest{Egress: run.Egress, Secrets: run.Secrets, Redactor: run.Redactor}
+	return w.invoke(ctx, req, func(inst *vmInstance) goja.Callable {
+		return inst.schedules[run.Schedule]
+	}, map[string]interface{}{
+		"schedule":    run.Schedule,
+		"scheduledAt": run.ScheduledAt.UTC().Format(time.RFC3339),
+		"trigger":     run.Trigger,
+		"runId":       run.RunID,
+		"attempt":     run.Attempt,
+	})
+}
+
+func (w *WorkspaceVM) hasSchedule(spec string) bool {
+	i := sort.SearchStrings(w.schedules, spec)
+	return i < len(w.schedules) && w.schedules[i] == spec
+}
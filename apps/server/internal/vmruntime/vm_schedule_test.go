@@ -0,0 +1,125 @@
+package vmruntime
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	schedule, err := ParseSchedule("0 2 * * *", shanghai)
+	if err != nil {
+		t.Fatalf("ParseSchedule: %v", err)
+	}
+	// 2026-01-01 00:00 UTC is 08:00 in Shanghai; the next 02:00 there is
+	// 2026-01-01 18:00 UTC.
+	next := schedule.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC))
+	if want := time.Date(2026, 1, 1, 18, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Fatalf("next = %v, want %v", next.UTC(), want)
+	}
+
+	if _, err := ParseSchedule("@daily", nil); err != nil {
+		t.Fatalf("@daily: %v", err)
+	}
+	for _, spec := range []string{"", " 0 2 * * *", "* * * *", "0 0 2 * * *", "@every 1m", "CRON_TZ=UTC 0 2 * * *", "61 * * * *"} {
+		if _, err := ParseSchedule(spec, nil); err == nil {
+			t.Errorf("ParseSchedule(%q) should fail", spec)
+		}
+	}
+}
+
+func TestWorkspaceVM_Schedules(t *testing.T) {
+	code := `
+		exports.schedules = {
+			"0 2 * * *": function(ctx) { return { ran: ctx.schedule }; },
+			"*/5 * * * *": function(ctx) {}
+		};
+	`
+	vm, err := NewWorkspaceVM("ws-schedules", code, newTestDB(t))
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM: %v", err)
+	}
+	got := vm.Schedules()
+	if len(got) != 2 || got[0] != "*/5 * * * *" || got[1] != "0 2 * * *" {
+		t.Fatalf("Schedules() = %v", got)
+	}
+	if len(vm.Routes()) != 0 {
+		t.Fatalf("Routes() = %v, want none", vm.Routes())
+	}
+}
+
+func TestWorkspaceVM_SchedulesRejectedAtLoad(t *testing.T) {
+	cases := map[string]string{
+		`exports.schedules = { "not a cron": function() {} };`: "invalid schedule",
+		`exports.schedules = { "0 2 * * *": 42 };`:             "not a function",
+	}
+	var many strings.Builder
+	many.WriteString("exports.schedules = {")
+	for i := 0; i <= MaxSchedules; i++ {
+		many.WriteString(`"` + string(rune('0'+i%10)) + ` ` + string(rune('0'+i/10)) + ` * * *": function() {},`)
+	}
+	many.WriteString("};")
+	cases[many.String()] = "too many schedules"
+
+	for code, want := range cases {
+		_, err := NewWorkspaceVM("ws-bad-schedule", code, newTestDB(t))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("load error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestWorkspaceVM_RunSchedule(t *testing.T) {
+	db := newTestDB(t)
+	if _, err := db.Exec("CREATE TABLE runs (schedule TEXT, trigger TEXT, attempt INTEGER)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	code := `
+		exports.schedules = {
+			"0 2 * * *": function(ctx) {
+				db.insert("runs", { schedule: ctx.schedule, trigger: ctx.trigger, attempt: ctx.attempt });
+				return { scheduledAt: ctx.scheduledAt, runId: ctx.runId, token: secrets.get("TOKEN") };
+			},
+			"0 3 * * *": function(ctx) { throw new Error("boom " + secrets.get("TOKEN")); }
+		};
+	`
+	vm, err := NewWorkspaceVM("ws-run-schedule", code, db)
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM: %v", err)
+	}
+	resolver := func(name string) (string, bool, error) { return "s3cr3t-value", name == "TOKEN", nil }
+	at := time.Date(2026, 3, 1, 2, 0, 0, 0, time.UTC)
+
+	resp, err := vm.RunSchedule(context.Background(), VMScheduleRun{
+		Schedule: "0 2 * * *", ScheduledAt: at, Trigger: ScheduleTriggerManual, RunID: "run-1", Attempt: 2, Secrets: resolver,
+	})
+	if err != nil {
+		t.Fatalf("RunSchedule: %v", err)
+	}
+	body := resp.Body.(map[string]interface{})
+	if body["scheduledAt"] != "2026-03-01T02:00:00Z" || body["runId"] != "run-1" || body["token"] != "s3cr3t-value" {
+		t.Fatalf("body = %v", body)
+	}
+	var trigger string
+	var attempt int
+	if err := db.QueryRow("SELECT trigger, attempt FROM runs").Scan(&trigger, &attempt); err != nil {
+		t.Fatalf("query runs: %v", err)
+	}
+	if trigger != ScheduleTriggerManual || attempt != 2 {
+		t.Fatalf("row = %s/%d", trigger, attempt)
+	}
+
+	_, err = vm.RunSchedule(context.Background(), VMScheduleRun{Schedule: "0 3 * * *", ScheduledAt: at, Trigger: ScheduleTriggerCron, Secrets: resolver})
+	if err == nil || !strings.Contains(err.Error(), "boom [REDACTED:TOKEN]") {
+		t.Fatalf("error = %v, want redacted throw", err)
+	}
+
+	if _, err := vm.RunSchedule(context.Background(), VMScheduleRun{Schedule: "0 4 * * *"}); err == nil {
+		t.Fatal("unknown schedule should fail")
+	}
+}
@@ -0,0 +1,90 @@
+package vmruntime
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/dop251/goja"
+	"github.com/reverseai/server/internal/pkg/security"
+)
+
+// VMSecretResolver returns the value of a workspace secret, or ok=false if no
+// secret has that name.
+type VMSecretResolver func(name string) (value string, ok bool, err error)
+
+// vmSecrets backs the `secrets` global and the "{{secrets.NAME}}" references
+// of fetch. Console output and handler errors are redacted with the
+// workspace's redactor and with every value resolved during the current
+// request (which covers a secret changed since the redactor was built)
+// before they leave the VM.
+type vmSecrets struct {
+	vm        *goja.Runtime
+	resolver  VMSecretResolver
+	workspace *security.SecretRedactor // all workspace secrets; may be nil
+	used      map[string]string
+	redactor  *security.SecretRedactor // rebuilt when used grows
+}
+
+// injectSecretsAPI injects the `secrets` global:
+//
+//	secrets.get("NAME") // the value, or null if the secret is not defined
+func injectSecretsAPI(vm *goja.Runtime) *vmSecrets {
+	s := &vmSecrets{vm: vm}
+	obj := vm.NewObject()
+	obj.Set("get", func(call goja.FunctionCall) goja.Value {
+		name := requireString(vm, call, 0, "secrets.get(name)")
+		value, ok, err := s.lookup(name)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("secrets.get: %w", err)))
+		}
+		if !ok {
+			return goja.Null()
+		}
+		return vm.ToValue(value)
+	})
+	vm.Set("secrets", obj)
+	return s
+}
+
+// begin installs the request's resolver and workspace redactor.
+func (s *vmSecrets) begin(resolver VMSecretResolver, workspace *security.SecretRedactor) {
+	s.resolver = resolver
+	s.workspace = workspace
+}
+
+// reset forgets the resolver, the redactor and the values seen by the
+// request.
+func (s *vmSecrets) reset() {
+	s.resolver = nil
+	s.workspace = nil
+	s.used = nil
+	s.redactor = nil
+}
+
+// lookup resolves name and records its value for redaction.
+func (s *vmSecrets) lookup(name string) (string, bool, error) {
+	if s.resolver == nil {
+		return "", false, errors.New("secrets are not available in this context")
+	}
+	value, ok, err := s.resolver(name)
+	if err != nil {
+		return "", false, fmt.Errorf("resolve secret %q: %w", name, err)
+	}
+	if !ok {
+		return "", false, nil
+	}
+	if s.used[name] != value {
+		if s.used == nil {
+			s.used = make(map[string]string)
+		}
+		s.used[name] = value
+		s.redactor = security.NewSecretRedactor(s.used)
+	}
+	return value, true, nil
+}
+
+// redact replaces the workspace's secret values and those resolved so far
+// in text.
+func (s *vmSecrets) redact(text string) string {
+	return s.redactor.Redact(s.workspace.Redact(text))
+}
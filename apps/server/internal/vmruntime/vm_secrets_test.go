@@ -0,0 +1,108 @@
+package vmruntime
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/reverseai/server/internal/pkg/security"
+)
+
+func TestSecrets_GetAndRedact(t *testing.T) {
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	code := `exports.routes = {
+		"GET /get": function(ctx) {
+			var token = secrets.get("API_TOKEN");
+			console.log("using", token);
+			return { token: token, missing: secrets.get("NOPE") };
+		},
+		"GET /throw": function(ctx) {
+			throw new Error("bad token " + secrets.get("API_TOKEN"));
+		}
+	};`
+	vm, err := NewWorkspaceVM("ws-secrets", code, newTestDB(t))
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM failed: %v", err)
+	}
+	resolver := func(name string) (string, bool, error) {
+		if name == "API_TOKEN" {
+			return "tok-12345", true, nil
+		}
+		return "", false, nil
+	}
+
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/get", Secrets: resolver})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	body := resp.Body.(map[string]interface{})
+	if body["token"] != "tok-12345" || body["missing"] != nil {
+		t.Fatalf("body = %+v", body)
+	}
+	if out := logs.String(); strings.Contains(out, "tok-12345") || !strings.Contains(out, "[REDACTED:API_TOKEN]") {
+		t.Fatalf("console output not redacted: %q", out)
+	}
+
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/throw", Secrets: resolver})
+	if err == nil || strings.Contains(err.Error(), "tok-12345") || !strings.Contains(err.Error(), "[REDACTED:API_TOKEN]") {
+		t.Fatalf("err = %v, want the secret redacted", err)
+	}
+
+	// Without a resolver secrets.get throws, and nothing leaks from the
+	// previous request.
+	_, err = vm.Handle(VMRequest{Method: "GET", Path: "/get"})
+	if err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Fatalf("err = %v, want secrets unavailable", err)
+	}
+}
+
+func TestSecrets_ResolverError(t *testing.T) {
+	vm, err := NewWorkspaceVM("ws-secrets-err", `exports.routes = { "GET /": function() {
+		try { secrets.get("A"); } catch (e) { return { error: String(e) }; }
+	} };`, newTestDB(t))
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM failed: %v", err)
+	}
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/", Secrets: func(string) (string, bool, error) {
+		return "", false, errors.New("store down")
+	}})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	if msg, _ := resp.Body.(map[string]interface{})["error"].(string); !strings.Contains(msg, `resolve secret "A": store down`) {
+		t.Fatalf("error = %v", msg)
+	}
+}
+
+func TestSecrets_WorkspaceRedactor(t *testing.T) {
+	var logs bytes.Buffer
+	prev := log.Writer()
+	log.SetOutput(&logs)
+	t.Cleanup(func() { log.SetOutput(prev) })
+
+	// The handler never reads the secret, but echoes it from the request.
+	vm, err := NewWorkspaceVM("ws-secrets-redactor", `exports.routes = {
+		"POST /echo": function(ctx) {
+			console.log("got", ctx.body.value);
+			throw new Error("rejected " + ctx.body.value);
+		}
+	};`, newTestDB(t))
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM failed: %v", err)
+	}
+	redactor := security.NewSecretRedactor(map[string]string{"API_TOKEN": "tok-12345"})
+
+	_, err = vm.Handle(VMRequest{Method: "POST", Path: "/echo", Body: map[string]interface{}{"value": "tok-12345"}, Redactor: redactor})
+	if err == nil || strings.Contains(err.Error(), "tok-12345") || !strings.Contains(err.Error(), "[REDACTED:API_TOKEN]") {
+		t.Fatalf("err = %v, want the secret redacted", err)
+	}
+	if out := logs.String(); strings.Contains(out, "tok-12345") || !strings.Contains(out, "[REDACTED:API_TOKEN]") {
+		t.Fatalf("console output not redacted: %q", out)
+	}
+}
@@ -0,0 +1,396 @@
+package vmruntime
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"strings"
+	"sync"
+	"time"
+	_ "time/tzdata" // time zones must not depend on the host's zoneinfo
+
+	"github.com/dop251/goja"
+	"github.com/google/uuid"
+	"github.com/xeipuuv/gojsonschema"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bounds of the standard library modules.
+const (
+	maxRandomBytes   = 1024 // per crypto.randomBytes call
+	maxCachedSchemas = 64   // compiled validate() schemas kept per runtime
+)
+
+// injectStdlib injects the standard library modules available to workspace
+// code next to `db` and `console`: crypto, validate, time and kv. None of
+// them reach the network or the host filesystem.
+func injectStdlib(vm *goja.Runtime, d *vmDB) {
+	injectCryptoAPI(vm)
+	injectValidateAPI(vm)
+	injectTimeAPI(vm)
+	injectKVAPI(vm, d)
+}
+
+// ── crypto ───────────────────────────────────────────────────────────
+
+// hmacHashes are the digests accepted by crypto.hmac.
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// injectCryptoAPI injects the `crypto` global:
+//
+//	crypto.uuid()                               random UUID v4
+//	crypto.sha256(data, encoding?)              digest as "hex" (default) or "base64"
+//	crypto.hmac(key, data, algorithm?, enc?)    algorithm "sha256" (default), "sha1" or "sha512"
+//	crypto.randomBytes(n, encoding?)            n secure random bytes, encoded
+//	crypto.hashPassword(password)               bcrypt hash
+//	crypto.verifyPassword(password, hash)       true if password matches hash
+//	crypto.timingSafeEqual(a, b)                constant-time string comparison
+func injectCryptoAPI(vm *goja.Runtime) {
+	obj := vm.NewObject()
+	obj.Set("uuid", func(goja.FunctionCall) goja.Value {
+		return vm.ToValue(uuid.NewString())
+	})
+	obj.Set("sha256", func(call goja.FunctionCall) goja.Value {
+		sum := sha256.Sum256([]byte(requireString(vm, call, 0, "crypto.sha256(data)")))
+		return vm.ToValue(encodeBytes(vm, sum[:], call.Argument(1)))
+	})
+	obj.Set("hmac", func(call goja.FunctionCall) goja.Value {
+		key := requireString(vm, call, 0, "crypto.hmac(key, data)")
+		data := requireString(vm, call, 1, "crypto.hmac(key, data)")
+		algorithm := "sha256"
+		if arg := call.Argument(2); !goja.IsUndefined(arg) && !goja.IsNull(arg) {
+			algorithm = strings.ToLower(arg.String())
+		}
+		newHash, ok := hmacHashes[algorithm]
+		if !ok {
+			panic(vm.NewTypeError(fmt.Sprintf("crypto.hmac: unsupported algorithm %q", algorithm)))
+		}
+		mac := hmac.New(newHash, []byte(key))
+		mac.Write([]byte(data))
+		return vm.ToValue(encodeBytes(vm, mac.Sum(nil), call.Argument(3)))
+	})
+	obj.Set("randomBytes", func(call goja.FunctionCall) goja.Value {
+		n := call.Argument(0).ToInteger()
+		if n < 1 || n > maxRandomBytes {
+			panic(vm.NewTypeError(fmt.Sprintf("crypto.randomBytes: size must be between 1 and %d", maxRandomBytes)))
+		}
+		buf := make([]byte, n)
+		if _, err := rand.Read(buf); err != nil {
+			panic(vm.NewGoError(fmt.Errorf("crypto.randomBytes: %w", err)))
+		}
+		return vm.ToValue(encodeBytes(vm, buf, call.Argument(1)))
+	})
+	obj.Set("hashPassword", func(call goja.FunctionCall) goja.Value {
+		password := requireString(vm, call, 0, "crypto.hashPassword(password)")
+		hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("crypto.hashPassword: %w", err)))
+		}
+		return vm.ToValue(string(hashed))
+	})
+	obj.Set("verifyPassword", func(call goja.FunctionCall) goja.Value {
+		password := requireString(vm, call, 0, "crypto.verifyPassword(password, hash)")
+		hashed := requireString(vm, call, 1, "crypto.verifyPassword(password, hash)")
+		return vm.ToValue(bcrypt.CompareHashAndPassword([]byte(hashed), []byte(password)) == nil)
+	})
+	obj.Set("timingSafeEqual", func(call goja.FunctionCall) goja.Value {
+		a := requireString(vm, call, 0, "crypto.timingSafeEqual(a, b)")
+		b := requireString(vm, call, 1, "crypto.timingSafeEqual(a, b)")
+		return vm.ToValue(subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1)
+	})
+	vm.Set("crypto", obj)
+}
+
+// encodeBytes encodes b as "hex" (the default when enc is undefined) or "base64".
+func encodeBytes(vm *goja.Runtime, b []byte, enc goja.Value) string {
+	if goja.IsUndefined(enc) || goja.IsNull(enc) {
+		return hex.EncodeToString(b)
+	}
+	switch enc.String() {
+	case "hex":
+		return hex.EncodeToString(b)
+	case "base64":
+		return base64.StdEncoding.EncodeToString(b)
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	panic(vm.NewTypeError(fmt.Sprintf("unsupported encoding %q", enc.String())))
+}
+
+// requireString returns argument i as a string, throwing a TypeError naming
+// usage when it is missing.
+func requireString(vm *goja.Runtime, call goja.FunctionCall, i int, usage string) string {
+	arg := call.Argument(i)
+	if goja.IsUndefined(arg) || goja.IsNull(arg) {
+		panic(vm.NewTypeError(usage + " requires a string argument"))
+	}
+	return arg.String()
+}
+
+// ── validate ─────────────────────────────────────────────────────────
+
+// injectValidateAPI injects `validate(schema, value)`, which checks value
+// against a JSON Schema and returns { valid, errors: [{ field, message }] }.
+// Only local $refs are allowed so validation never fetches remote schemas.
+func injectValidateAPI(vm *goja.Runtime) {
+	cache := make(map[string]*gojsonschema.Schema)
+	vm.Set("validate", func(call goja.FunctionCall) goja.Value {
+		if len(call.Arguments) < 2 {
+			panic(vm.NewTypeError("validate requires (schema, value)"))
+		}
+		raw := call.Arguments[0].Export()
+		if err := checkLocalRefs(raw); err != nil {
+			panic(vm.NewTypeError("validate: " + err.Error()))
+		}
+		schemaJSON, err := json.Marshal(raw)
+		if err != nil {
+			panic(vm.NewTypeError("validate: schema is not JSON: " + err.Error()))
+		}
+		schema, ok := cache[string(schemaJSON)]
+		if !ok {
+			schema, err = gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schemaJSON))
+			if err != nil {
+				panic(vm.NewTypeError("validate: invalid schema: " + err.Error()))
+			}
+			if len(cache) >= maxCachedSchemas {
+				clear(cache)
+			}
+			cache[string(schemaJSON)] = schema
+		}
+
+		result, err := schema.Validate(gojsonschema.NewGoLoader(call.Arguments[1].Export()))
+		if err != nil {
+			panic(vm.NewTypeError("validate: " + err.Error()))
+		}
+		errs := make([]interface{}, 0, len(result.Errors()))
+		for _, e := range result.Errors() {
+			errs = append(errs, map[string]interface{}{
+				"field":   e.Field(),
+				"message": e.Description(),
+			})
+		}
+		return vm.ToValue(map[string]interface{}{
+			"valid":  result.Valid(),
+			"errors": errs,
+		})
+	})
+}
+
+// checkLocalRefs rejects any $ref that does not point into the schema itself.
+func checkLocalRefs(v interface{}) error {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for key, child := range node {
+			if ref, ok := child.(string); ok && key == "$ref" && !strings.HasPrefix(ref, "#") {
+				return fmt.Errorf("only local $ref values (\"#/...\") are allowed, got %q", ref)
+			}
+			if err := checkLocalRefs(child); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, child := range node {
+			if err := checkLocalRefs(child); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ── time ─────────────────────────────────────────────────────────────
+
+// Named layouts accepted by time.format and time.parse in place of tokens.
+var namedLayouts = map[string]string{
+	"iso":      time.RFC3339,
+	"isoMs":    "2006-01-02T15:04:05.000Z07:00",
+	"date":     "2006-01-02",
+	"time":     "15:04:05",
+	"datetime": "2006-01-02 15:04:05",
+	"rfc1123":  time.RFC1123,
+}
+
+// layoutTokens maps format tokens to Go layout elements, longest first.
+var layoutTokens = []struct{ token, layout string }{
+	{"YYYY", "2006"}, {"YY", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"}, {"M", "1"},
+	{"dddd", "Monday"}, {"ddd", "Mon"},
+	{"DD", "02"}, {"D", "2"},
+	{"HH", "15"}, {"hh", "03"}, {"h", "3"},
+	{"mm", "04"}, {"m", "4"},
+	{"ss", "05"}, {"s", "5"},
+	{"SSS", "000"},
+	{"A", "PM"}, {"a", "pm"},
+	{"ZZ", "-0700"}, {"Z", "-07:00"},
+}
+
+// locations caches loaded time zones.
+var locations sync.Map // name → *time.Location
+
+// injectTimeAPI injects the `time` global. Values are epoch milliseconds,
+// Date objects or date strings; zones are IANA names (default "UTC").
+//
+//	time.now(tz?)                          current time as an ISO string
+//	time.format(value, layout?, tz?)       layout tokens (YYYY-MM-DD HH:mm:ss) or a named layout
+//	time.parse(text, layout?, tz?)         epoch milliseconds
+//	time.add(value, amount, unit, tz?)     epoch milliseconds; calendar units respect tz
+//	time.startOf(value, unit, tz?)         start of the year/month/week/day/hour in tz
+func injectTimeAPI(vm *goja.Runtime) {
+	obj := vm.NewObject()
+	obj.Set("now", func(call goja.FunctionCall) goja.Value {
+		loc := timeLocation(vm, call.Argument(0))
+		return vm.ToValue(time.Now().In(loc).Format(time.RFC3339))
+	})
+	obj.Set("format", func(call goja.FunctionCall) goja.Value {
+		t := timeValue(vm, call.Argument(0), time.UTC)
+		loc := timeLocation(vm, call.Argument(2))
+		return vm.ToValue(t.In(loc).Format(timeLayout(call.Argument(1))))
+	})
+	obj.Set("parse", func(call goja.FunctionCall) goja.Value {
+		text := requireString(vm, call, 0, "time.parse(text)")
+		loc := timeLocation(vm, call.Argument(2))
+		if layout := call.Argument(1); !goja.IsUndefined(layout) && !goja.IsNull(layout) {
+			t, err := time.ParseInLocation(timeLayout(layout), text, loc)
+			if err != nil {
+				panic(vm.NewTypeError("time.parse: " + err.Error()))
+			}
+			return vm.ToValue(t.UnixMilli())
+		}
+		return vm.ToValue(timeValue(vm, vm.ToValue(text), loc).UnixMilli())
+	})
+	obj.Set("add", func(call goja.FunctionCall) goja.Value {
+		loc := timeLocation(vm, call.Argument(3))
+		t := timeValue(vm, call.Argument(0), loc).In(loc)
+		n := int(call.Argument(1).ToInteger())
+		switch unit := strings.TrimSuffix(call.Argument(2).String(), "s"); unit {
+		case "year":
+			t = t.AddDate(n, 0, 0)
+		case "month":
+			t = t.AddDate(0, n, 0)
+		case "week":
+			t = t.AddDate(0, 0, 7*n)
+		case "day":
+			t = t.AddDate(0, 0, n)
+		case "hour":
+			t = t.Add(time.Duration(n) * time.Hour)
+		case "minute":
+			t = t.Add(time.Duration(n) * time.Minute)
+		case "second":
+			t = t.Add(time.Duration(n) * time.Second)
+		case "millisecond":
+			t = t.Add(time.Duration(n) * time.Millisecond)
+		default:
+			panic(vm.NewTypeError(fmt.Sprintf("time.add: unsupported unit %q", unit)))
+		}
+		return vm.ToValue(t.UnixMilli())
+	})
+	obj.Set("startOf", func(call goja.FunctionCall) goja.Value {
+		loc := timeLocation(vm, call.Argument(2))
+		t := timeValue(vm, call.Argument(0), loc).In(loc)
+		y, m, d := t.Date()
+		switch unit := call.Argument(1).String(); unit {
+		case "year":
+			t = time.Date(y, 1, 1, 0, 0, 0, 0, loc)
+		case "month":
+			t = time.Date(y, m, 1, 0, 0, 0, 0, loc)
+		case "week": // weeks start on Monday
+			t = time.Date(y, m, d-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc)
+		case "day":
+			t = time.Date(y, m, d, 0, 0, 0, 0, loc)
+		case "hour":
+			t = time.Date(y, m, d, t.Hour(), 0, 0, 0, loc)
+		default:
+			panic(vm.NewTypeError(fmt.Sprintf("time.startOf: unsupported unit %q", unit)))
+		}
+		return vm.ToValue(t.UnixMilli())
+	})
+	vm.Set("time", obj)
+}
+
+// timeLocation loads the zone named by v, defaulting to UTC.
+func timeLocation(vm *goja.Runtime, v goja.Value) *time.Location {
+	if goja.IsUndefined(v) || goja.IsNull(v) || v.String() == "" {
+		return time.UTC
+	}
+	name := v.String()
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(vm.NewTypeError(fmt.Sprintf("unknown time zone %q", name)))
+	}
+	locations.Store(name, loc)
+	return loc
+}
+
+// timeLayout turns a named layout or token layout into a Go layout; an
+// undefined layout means ISO 8601.
+func timeLayout(v goja.Value) string {
+	if goja.IsUndefined(v) || goja.IsNull(v) {
+		return time.RFC3339
+	}
+	layout := v.String()
+	if named, ok := namedLayouts[layout]; ok {
+		return named
+	}
+	var b strings.Builder
+	for i := 0; i < len(layout); {
+		matched := false
+		for _, tok := range layoutTokens {
+			if strings.HasPrefix(layout[i:], tok.token) {
+				b.WriteString(tok.layout)
+				i += len(tok.token)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			b.WriteByte(layout[i])
+			i++
+		}
+	}
+	return b.String()
+}
+
+// dateStringLayouts are tried in order for string values without a layout;
+// strings without an offset are read in the given zone.
+var dateStringLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// timeValue converts epoch milliseconds, a Date or a date string to a time.
+func timeValue(vm *goja.Runtime, v goja.Value, loc *time.Location) time.Time {
+	switch value := v.Export().(type) {
+	case time.Time:
+		return value
+	case int64:
+		return time.UnixMilli(value)
+	case float64:
+		return time.UnixMilli(int64(value))
+	case string:
+		for _, layout := range dateStringLayouts {
+			if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+				return t
+			}
+		}
+		panic(vm.NewTypeError(fmt.Sprintf("invalid date %q", value)))
+	}
+	panic(vm.NewTypeError("expected a date, epoch milliseconds or date string"))
+}
@@ -0,0 +1,198 @@
+package vmruntime
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// runStdlib runs body as the handler of a single GET route and returns the
+// response body.
+func runStdlib(t *testing.T, body string) map[string]interface{} {
+	t.Helper()
+	db := newTestDB(t)
+	db.SetMaxOpenConns(1)
+	code := `exports.routes = { "GET /test": function(ctx) {` + body + `} };`
+	vm, err := NewWorkspaceVM("ws-stdlib", code, db)
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM failed: %v", err)
+	}
+	resp, err := vm.Handle(VMRequest{Method: "GET", Path: "/test"})
+	if err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	return resp.Body.(map[string]interface{})
+}
+
+func TestStdlib_Crypto(t *testing.T) {
+	body := runStdlib(t, `
+		var hash = crypto.hashPassword("s3cret");
+		return {
+			uuid:      crypto.uuid(),
+			sha256:    crypto.sha256("abc"),
+			hmac:      crypto.hmac("key", "data"),
+			random:    crypto.randomBytes(16),
+			random64:  crypto.randomBytes(16, "base64"),
+			verifyOK:  crypto.verifyPassword("s3cret", hash),
+			verifyBad: crypto.verifyPassword("wrong", hash),
+			equal:     crypto.timingSafeEqual("a", "a"),
+			tooMany:   (function() { try { crypto.randomBytes(1 << 20); return false; } catch (e) { return true; } })()
+		};
+	`)
+
+	if s, _ := body["uuid"].(string); len(s) != 36 {
+		t.Fatalf("uuid = %v", body["uuid"])
+	}
+	if body["sha256"] != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Fatalf("sha256 = %v", body["sha256"])
+	}
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte("data"))
+	if body["hmac"] != hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatalf("hmac = %v", body["hmac"])
+	}
+	if s, _ := body["random"].(string); len(s) != 32 {
+		t.Fatalf("random = %v, want 32 hex chars", body["random"])
+	}
+	if s, _ := body["random64"].(string); len(s) != 24 {
+		t.Fatalf("random64 = %v, want 24 base64 chars", body["random64"])
+	}
+	if body["verifyOK"] != true || body["verifyBad"] != false || body["equal"] != true || body["tooMany"] != true {
+		t.Fatalf("body = %+v", body)
+	}
+}
+
+func TestStdlib_Validate(t *testing.T) {
+	body := runStdlib(t, `
+		var schema = {
+			type: "object",
+			required: ["email", "age"],
+			properties: {
+				email: { type: "string", format: "email" },
+				age:   { type: "integer", minimum: 18 }
+			}
+		};
+		var remote;
+		try { validate({ $ref: "http://example.com/schema.json" }, {}); } catch (e) { remote = String(e); }
+		return {
+			ok:     validate(schema, { email: "ann@example.com", age: 30 }),
+			bad:    validate(schema, { email: "nope", age: 12 }),
+			remote: remote
+		};
+	`)
+
+	if ok := body["ok"].(map[string]interface{}); ok["valid"] != true {
+		t.Fatalf("ok = %+v", ok)
+	}
+	bad := body["bad"].(map[string]interface{})
+	errs, _ := bad["errors"].([]interface{})
+	if bad["valid"] != false || len(errs) != 2 {
+		t.Fatalf("bad = %+v, want 2 errors", bad)
+	}
+	fields := []string{}
+	for _, e := range errs {
+		fields = append(fields, e.(map[string]interface{})["field"].(string))
+	}
+	if joined := strings.Join(fields, ","); !strings.Contains(joined, "email") || !strings.Contains(joined, "age") {
+		t.Fatalf("error fields = %v", fields)
+	}
+	if remote, _ := body["remote"].(string); !strings.Contains(remote, "local $ref") {
+		t.Fatalf("remote = %v, want a rejected remote $ref", body["remote"])
+	}
+}
+
+func TestStdlib_Time(t *testing.T) {
+	body := runStdlib(t, `
+		var t = time.parse("2024-03-10 01:30", "YYYY-MM-DD HH:mm", "America/New_York");
+		return {
+			iso:      time.format(t),
+			local:    time.format(t, "YYYY-MM-DD HH:mm ZZ", "America/New_York"),
+			named:    time.format(0, "datetime", "Asia/Shanghai"),
+			plusDay:  time.format(time.add(t, 1, "day", "America/New_York"), "HH:mm", "America/New_York"),
+			plus24h:  time.format(time.add(t, 24, "hours"), "HH:mm", "America/New_York"),
+			week:     time.format(time.startOf("2024-03-14", "week"), "date"),
+			fromDate: time.format(new Date(Date.UTC(2024, 0, 2)), "date"),
+			now:      typeof time.now("Europe/Paris")
+		};
+	`)
+
+	want := map[string]interface{}{
+		"iso":      "2024-03-10T06:30:00Z",
+		"local":    "2024-03-10 01:30 -0500",
+		"named":    "1970-01-01 08:00:00",
+		"plusDay":  "01:30", // calendar day across the DST change
+		"plus24h":  "02:30",
+		"week":     "2024-03-11",
+		"fromDate": "2024-01-02",
+		"now":      "string",
+	}
+	for key, v := range want {
+		if body[key] != v {
+			t.Fatalf("%s = %v, want %v", key, body[key], v)
+		}
+	}
+}
+
+func TestStdlib_KV(t *testing.T) {
+	body := runStdlib(t, `
+		kv.set("user:1", { name: "ann", tags: ["a"] });
+		kv.set("user:2", "bob");
+		kv.set("temp", 1, { ttl: -1 });
+		kv.set("session", "x", { ttl: 60 });
+		kv.incr("visits");
+		var rolledBack;
+		try {
+			db.transaction(function() {
+				kv.incr("visits", 5);
+				throw new Error("abort");
+			});
+		} catch (e) { rolledBack = kv.get("visits"); }
+		return {
+			user:       kv.get("user:1"),
+			missing:    kv.get("nope"),
+			keys:       kv.list("user:"),
+			visits:     kv.incr("visits", 2),
+			rolledBack: rolledBack,
+			session:    kv.get("session"),
+			deleted:    kv.delete("user:2"),
+			after:      kv.get("user:2")
+		};
+	`)
+
+	user := body["user"].(map[string]interface{})
+	if user["name"] != "ann" {
+		t.Fatalf("user = %+v", user)
+	}
+	if body["missing"] != nil || body["after"] != nil {
+		t.Fatalf("missing = %v, after = %v, want null", body["missing"], body["after"])
+	}
+	if keys := fmt.Sprint(body["keys"]); keys != "[user:1 user:2]" {
+		t.Fatalf("keys = %v", body["keys"])
+	}
+	if toInt64(body["rolledBack"]) != 1 || toInt64(body["visits"]) != 3 {
+		t.Fatalf("rolledBack = %v, visits = %v, want 1 and 3", body["rolledBack"], body["visits"])
+	}
+	if body["session"] != "x" || body["deleted"] != true {
+		t.Fatalf("body = %+v", body)
+	}
+}
+
+func TestStdlib_KVHiddenFromStore(t *testing.T) {
+	store, cleanup := newTestStore(t)
+	defer cleanup()
+	db, _ := store.GetDB("ws-kv")
+	vm, err := NewWorkspaceVM("ws-kv-hidden", `exports.routes = { "GET /": function() { kv.set("a", 1); } };`, db)
+	if err != nil {
+		t.Fatalf("NewWorkspaceVM failed: %v", err)
+	}
+	if _, err := vm.Handle(VMRequest{Method: "GET", Path: "/"}); err != nil {
+		t.Fatalf("Handle failed: %v", err)
+	}
+	tables, _ := store.ListTables(t.Context(), "ws-kv")
+	if len(tables) != 0 {
+		t.Fatalf("tables = %+v, want kv table hidden", tables)
+	}
+}
@@ -3,7 +3,7 @@
  * Table/Row CRUD, SQL execution, schema graph, stats
  */
 
-import { request, type ApiResponse } from './shared'
+import { API_BASE_URL, getAccessToken, request, type ApiResponse } from './shared'
 
 // ===== Type Definitions =====
 
@@ -87,6 +87,24 @@ export interface AlterTableRequest {
   alter_columns?: AlterColumnDef[]
   drop_columns?: string[]
   rename?: string
+  /** Preview the generated SQL and data-loss report without changing anything */
+  dry_run?: boolean
+  /** Apply even if rows are dropped or values change when cast */
+  allow_data_loss?: boolean
+}
+
+export interface AlterDataLoss {
+  column: string
+  reason: 'not_null' | 'type_cast'
+  rows: number
+}
+
+export interface AlterTablePlan {
+  table: string
+  statements: string[]
+  rebuild: boolean
+  data_loss: AlterDataLoss[]
+  applied: boolean
 }
 
 export interface QueryFilter {
@@ -112,11 +130,47 @@ export interface QueryResult {
 }
 
 export interface QueryHistoryItem {
+  id: string
+  user_id: string
   sql: string
   duration_ms: number
-  status: string
+  row_count: number
+  affected_rows: number
+  status: 'success' | 'error'
   created_at: string
   error?: string
+  pinned: boolean
+  name?: string
+}
+
+export interface QueryHistoryParams {
+  search?: string
+  status?: 'success' | 'error'
+  pinned?: boolean
+  mine?: boolean
+  page?: number
+  page_size?: number
+}
+
+export interface DatabaseSnapshot {
+  id: string
+  workspace_id: string
+  reason: 'manual' | 'scheduled' | 'pre_drop_table' | 'pre_alter_table' | 'pre_restore' | 'pre_migrate'
+  label?: string
+  size_bytes: number
+  created_at: string
+}
+
+export interface DatabaseMigration {
+  version: number
+  name: string
+  source: 'dashboard' | 'agent'
+  up: string[]
+  down: string[]
+  status: 'applied' | 'reverted' | 'superseded'
+  created_at: string
+  applied_at?: string
+  reverted_at?: string
 }
 
 export interface DatabaseStats {
@@ -189,14 +243,19 @@ export const workspaceDatabaseApi = {
   /**
    * Alter table structure
    */
-  async alterTable(workspaceId: string, tableName: string, req: AlterTableRequest): Promise<void> {
-    await request<ApiResponse<{ message: string }>>(
+  async alterTable(
+    workspaceId: string,
+    tableName: string,
+    req: AlterTableRequest
+  ): Promise<AlterTablePlan> {
+    const response = await request<ApiResponse<{ message?: string; plan: AlterTablePlan }>>(
       `/workspaces/${workspaceId}/database/tables/${encodeURIComponent(tableName)}`,
       {
         method: 'PATCH',
         body: JSON.stringify(req),
       }
     )
+    return (response.data as any)?.plan
   },
 
   /**
@@ -334,15 +393,145 @@ export const workspaceDatabaseApi = {
   },
 
   /**
-   * Get SQL query history (last 100)
+   * Get SQL query history (newest first; pinned=true lists saved queries)
    */
-  async getQueryHistory(workspaceId: string): Promise<QueryHistoryItem[]> {
+  async getQueryHistory(
+    workspaceId: string,
+    params: QueryHistoryParams = {}
+  ): Promise<QueryHistoryItem[]> {
+    const query = new URLSearchParams()
+    for (const [key, value] of Object.entries(params)) {
+      if (value !== undefined && value !== '') query.set(key, String(value))
+    }
+    const qs = query.toString()
     const response = await request<ApiResponse<{ history: QueryHistoryItem[] }>>(
-      `/workspaces/${workspaceId}/database/query/history`
+      `/workspaces/${workspaceId}/database/query/history${qs ? `?${qs}` : ''}`
     )
     return (response.data as any)?.history ?? []
   },
 
+  /**
+   * Pin/unpin a history entry and name it (saved query)
+   */
+  async updateQueryHistory(
+    workspaceId: string,
+    historyId: string,
+    patch: { pinned?: boolean; name?: string }
+  ): Promise<QueryHistoryItem> {
+    const response = await request<ApiResponse<{ history: QueryHistoryItem }>>(
+      `/workspaces/${workspaceId}/database/query/history/${historyId}`,
+      {
+        method: 'PATCH',
+        body: JSON.stringify(patch),
+      }
+    )
+    return (response.data as any)?.history
+  },
+
+  /**
+   * Delete a history entry
+   */
+  async deleteQueryHistory(workspaceId: string, historyId: string): Promise<void> {
+    await request<ApiResponse<{ deleted: boolean }>>(
+      `/workspaces/${workspaceId}/database/query/history/${historyId}`,
+      { method: 'DELETE' }
+    )
+  },
+
+  /**
+   * List database snapshots (newest first)
+   */
+  async listSnapshots(workspaceId: string): Promise<DatabaseSnapshot[]> {
+    const response = await request<ApiResponse<{ snapshots: DatabaseSnapshot[] }>>(
+      `/workspaces/${workspaceId}/database/snapshots`
+    )
+    return (response.data as any)?.snapshots ?? []
+  },
+
+  /**
+   * Create a manual snapshot
+   */
+  async createSnapshot(workspaceId: string, label?: string): Promise<DatabaseSnapshot> {
+    const response = await request<ApiResponse<{ snapshot: DatabaseSnapshot }>>(
+      `/workspaces/${workspaceId}/database/snapshots`,
+      {
+        method: 'POST',
+        body: JSON.stringify({ label: label ?? '' }),
+      }
+    )
+    return (response.data as any)?.snapshot
+  },
+
+  /**
+   * Restore the database to a snapshot (a pre_restore snapshot is taken first)
+   */
+  async restoreSnapshot(workspaceId: string, snapshotId: string): Promise<void> {
+    await request<ApiResponse<{ message: string }>>(
+      `/workspaces/${workspaceId}/database/snapshots/${snapshotId}/restore`,
+      { method: 'POST' }
+    )
+  },
+
+  /**
+   * Download a snapshot as a SQLite file
+   */
+  async downloadSnapshot(workspaceId: string, snapshotId: string): Promise<Blob> {
+    const token = getAccessToken()
+    const response = await fetch(
+      `${API_BASE_URL}/workspaces/${workspaceId}/database/snapshots/${snapshotId}/download`,
+      { headers: token ? { Authorization: `Bearer ${token}` } : {} }
+    )
+    if (!response.ok) {
+      throw new Error(`Snapshot download failed: ${response.status}`)
+    }
+    return response.blob()
+  },
+
+  /**
+   * Delete a snapshot
+   */
+  async deleteSnapshot(workspaceId: string, snapshotId: string): Promise<void> {
+    await request<ApiResponse<{ message: string }>>(
+      `/workspaces/${workspaceId}/database/snapshots/${snapshotId}`,
+      { method: 'DELETE' }
+    )
+  },
+
+  /**
+   * List schema migrations (oldest first) with the current schema version
+   */
+  async listMigrations(
+    workspaceId: string
+  ): Promise<{ migrations: DatabaseMigration[]; current_version: number }> {
+    const response = await request<
+      ApiResponse<{ migrations: DatabaseMigration[]; current_version: number }>
+    >(`/workspaces/${workspaceId}/database/migrations`)
+    const data = response.data as any
+    return { migrations: data?.migrations ?? [], current_version: data?.current_version ?? 0 }
+  },
+
+  /**
+   * Re-apply reverted migrations up to and including a version
+   */
+  async applyMigration(workspaceId: string, version: number): Promise<number> {
+    const response = await request<ApiResponse<{ current_version: number }>>(
+      `/workspaces/${workspaceId}/database/migrations/${version}/apply`,
+      { method: 'POST' }
+    )
+    return (response.data as any)?.current_version ?? 0
+  },
+
+  /**
+   * Revert a migration and every migration after it
+   */
+  async revertMigration(workspaceId: string, version: number): Promise<number> {
+    const response = await request<ApiResponse<{ current_version: number }>>(
+      `/workspaces/${workspaceId}/database/migrations/${version}/revert`,
+      { method: 'POST' }
+    )
+    return (response.data as any)?.current_version ?? 0
+  },
+
   /**
    * Get database statistics
    */